@@ -0,0 +1,85 @@
+// Package arch defines the interface implemented by the instruction set
+// architectures supported by the parser and the assemblers, and a registry of
+// these architectures.
+//
+// Architectures register themselves when imported; e.g.
+//
+//	import _ "github.com/mewlang/asm/arch/mips"
+package arch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mewlang/asm/ast"
+)
+
+// An Arch describes an instruction set architecture; its registers, its
+// instructions and the shapes of their operands.
+type Arch interface {
+	// Name returns the name of the architecture; e.g. "mips".
+	Name() string
+	// ByteOrder returns the byte order of the architecture.
+	ByteOrder() binary.ByteOrder
+	// WordSize returns the size in bytes of a machine word.
+	WordSize() int
+	// Reg returns the register with the given name, as it appears in the source
+	// code (e.g. "$t0" or "eax"), and true if present, and false otherwise.
+	Reg(name string) (reg ast.Reg, ok bool)
+	// RegName returns the canonical name of the given register.
+	RegName(reg ast.Reg) string
+	// RegClass returns the class of the given register.
+	RegClass(reg ast.Reg) RegClass
+	// Mnemonics returns the sorted mnemonics of the instructions of the
+	// architecture, including pseudo-instructions.
+	Mnemonics() []string
+	// Validate returns an error if the operation of inst is not an instruction
+	// of the architecture, or if the operands of inst do not match any of the
+	// operand forms of the instruction.
+	Validate(inst *ast.Inst) error
+}
+
+// A RegClass represents a class of registers which may be used
+// interchangeably; such as the 32-bit general purpose registers.
+type RegClass struct {
+	// Name is the name of the register class; e.g. "gpr".
+	Name string
+	// Size is the size in bits of the registers of the class.
+	Size int
+}
+
+func (class RegClass) String() string {
+	return class.Name
+}
+
+// archs is a map from architecture name to registered architecture.
+var archs = make(map[string]Arch)
+
+// Register makes an architecture available by its name. If Register is called
+// twice with the same name it panics.
+func Register(a Arch) {
+	name := a.Name()
+	if _, dup := archs[name]; dup {
+		panic(fmt.Sprintf("arch.Register: architecture %q already registered", name))
+	}
+	archs[name] = a
+}
+
+// Lookup returns the registered architecture of the given name and true if
+// present, and false otherwise.
+func Lookup(name string) (a Arch, ok bool) {
+	a, ok = archs[strings.ToLower(name)]
+	return a, ok
+}
+
+// Names returns the sorted names of the registered architectures.
+func Names() []string {
+	var names []string
+	for name := range archs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
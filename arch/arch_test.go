@@ -0,0 +1,79 @@
+package arch_test
+
+import (
+	"testing"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/arch/mips"
+	"github.com/mewlang/asm/ast"
+)
+
+func TestLookup(t *testing.T) {
+	a, ok := arch.Lookup("MIPS")
+	if !ok {
+		t.Fatalf("unable to locate registered architecture %q; registered: %v", "mips", arch.Names())
+	}
+	if a != mips.Arch {
+		t.Errorf("architecture mismatch; expected %v, got %v", mips.Arch.Name(), a.Name())
+	}
+	if _, ok := arch.Lookup("pdp-11"); ok {
+		t.Errorf("unexpected architecture %q", "pdp-11")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	r := arch.Reg(mips.GPR)
+	golden := []struct {
+		form arch.Form
+		args []ast.Arg
+		want bool
+	}{
+		// i=0
+		{form: arch.Form{r, r, arch.Imm}, args: []ast.Arg{mips.T0, mips.T1, ast.Int(1)}, want: true},
+		// i=1
+		{form: arch.Form{r, r, arch.Imm}, args: []ast.Arg{mips.T0, mips.T1, ast.Ident("loop")}, want: true},
+		// i=2
+		{form: arch.Form{r, r, arch.Imm}, args: []ast.Arg{mips.T0, mips.T1, mips.T2}, want: false},
+		// i=3
+		{form: arch.Form{r, r}, args: []ast.Arg{mips.T0, mips.T1, mips.T2}, want: false},
+		// i=4
+		{form: arch.Form{arch.Imm}, args: []ast.Arg{ast.String("foo")}, want: false},
+		// i=5
+		{form: arch.Form{r}, args: []ast.Arg{ast.Reg(100)}, want: false},
+		// i=6
+		{form: arch.Form{arch.Writeback(mips.GPR), arch.RegList(mips.GPR)}, args: []ast.Arg{&ast.Writeback{X: mips.SP}, &ast.RegList{Regs: []ast.Arg{mips.S0, mips.S1, mips.RA}}}, want: true},
+		// i=7
+		{form: arch.Form{arch.RegList(mips.GPR)}, args: []ast.Arg{&ast.RegList{Regs: []ast.Arg{mips.S0, ast.Ident("s1")}}}, want: false},
+		// i=8
+		{form: arch.Form{r, arch.ShiftedReg(mips.GPR)}, args: []ast.Arg{mips.T0, &ast.ShiftedReg{X: mips.T1, Op: "lsl", Amount: ast.Int(2)}}, want: true},
+		// i=9
+		{form: arch.Form{r, arch.Imm}, args: []ast.Arg{mips.T0, &ast.Imm{X: ast.Int(2)}}, want: true},
+		// i=10
+		{form: arch.Form{r, arch.Imm}, args: []ast.Arg{mips.T0, &ast.Literal{X: ast.Int(2)}}, want: false},
+		// i=11
+		{form: arch.Form{r, arch.Literal}, args: []ast.Arg{mips.T0, &ast.Literal{X: ast.Ident("msg")}}, want: true},
+		// i=12
+		{form: arch.Form{r, arch.PreIndex}, args: []ast.Arg{mips.T0, &ast.Writeback{X: &ast.Mem{Base: mips.SP, Disp: ast.Int(-16)}}}, want: true},
+		// i=13
+		{form: arch.Form{r, arch.PreIndex}, args: []ast.Arg{mips.T0, &ast.Writeback{X: mips.SP}}, want: false},
+		// i=14
+		{form: arch.Form{r, arch.ShiftedImm}, args: []ast.Arg{mips.T0, &ast.ShiftedReg{X: &ast.Imm{X: ast.Int(1)}, Op: "lsl", Amount: ast.Int(12)}}, want: true},
+		// i=15
+		{form: arch.Form{r, arch.ShiftedReg(mips.GPR)}, args: []ast.Arg{mips.T0, &ast.ShiftedReg{X: &ast.Imm{X: ast.Int(1)}, Op: "lsl", Amount: ast.Int(12)}}, want: false},
+		// i=16
+		{form: arch.Form{r, arch.Mem}, args: []ast.Arg{mips.T0, &ast.Mem{Base: mips.SP, Size: 32}}, want: true},
+		// i=17
+		{form: arch.Form{arch.SizedMem(32)}, args: []ast.Arg{&ast.Mem{Base: mips.SP, Size: 32}}, want: true},
+		// i=18
+		{form: arch.Form{arch.SizedMem(32)}, args: []ast.Arg{&ast.Mem{Base: mips.SP}}, want: false},
+		// i=19
+		{form: arch.Form{arch.SizedMem(32)}, args: []ast.Arg{&ast.Mem{Base: mips.SP, Size: 8}}, want: false},
+	}
+
+	for i, g := range golden {
+		got := arch.Match(mips.Arch, g.form, g.args)
+		if got != g.want {
+			t.Errorf("i=%d: expected %v, got %v", i, g.want, got)
+		}
+	}
+}
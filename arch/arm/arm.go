@@ -0,0 +1,424 @@
+// Package arm describes the ARMv7-M architecture of the Cortex-M processors,
+// whose instructions are encoded using the Thumb-2 instruction set. It
+// registers the architecture with the arch package under the name "thumb".
+//
+// Mnemonics are written using the unified assembler language (UAL); a base
+// mnemonic is optionally followed by an "s" suffix which specifies that the
+// instruction updates the condition flags, a condition suffix and a ".w" or
+// ".n" width qualifier, in that order; such as addseq.w.
+package arm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/ast"
+)
+
+// Arch is the ARMv7-M architecture.
+var Arch arch.Arch = thumb{}
+
+func init() {
+	arch.Register(Arch)
+}
+
+// Register classes of the ARMv7-M architecture.
+var (
+	// GPR is the class of the 16 general purpose registers, including the stack
+	// pointer, the link register and the program counter.
+	GPR = arch.RegClass{Name: "gpr", Size: 32}
+	// SysReg is the class of the special registers, which are accessed by mrs
+	// and msr.
+	SysReg = arch.RegClass{Name: "sysreg", Size: 32}
+)
+
+// General purpose registers. The register number is given by the value of the
+// register; e.g. LR is r14.
+const (
+	R0 ast.Reg = iota
+	R1
+	R2
+	R3
+	R4
+	R5
+	R6
+	R7
+	R8
+	R9
+	R10
+	R11
+	R12
+	SP // stack pointer
+	LR // link register
+	PC // program counter
+)
+
+// Special registers. The SYSm field of mrs and msr is given by the value of the
+// register minus APSR.
+const (
+	APSR ast.Reg = 0x100 + iota
+	IAPSR
+	EAPSR
+	XPSR
+	_
+	IPSR
+	EPSR
+	IEPSR
+	MSP
+	PSP
+	_
+	_
+	_
+	_
+	_
+	_
+	PRIMASK
+	BASEPRI
+	BASEPRI_MAX
+	FAULTMASK
+	CONTROL
+)
+
+// regNames maps from general purpose register to canonical register name.
+var regNames = [...]string{
+	"r0", "r1", "r2", "r3", "r4", "r5", "r6", "r7",
+	"r8", "r9", "r10", "r11", "r12", "sp", "lr", "pc",
+}
+
+// sysRegNames maps from special register to canonical register name.
+var sysRegNames = map[ast.Reg]string{
+	APSR:        "apsr",
+	IAPSR:       "iapsr",
+	EAPSR:       "eapsr",
+	XPSR:        "xpsr",
+	IPSR:        "ipsr",
+	EPSR:        "epsr",
+	IEPSR:       "iepsr",
+	MSP:         "msp",
+	PSP:         "psp",
+	PRIMASK:     "primask",
+	BASEPRI:     "basepri",
+	BASEPRI_MAX: "basepri_max",
+	FAULTMASK:   "faultmask",
+	CONTROL:     "control",
+}
+
+// regs maps from register name, including aliases, to register.
+var regs = map[string]ast.Reg{
+	"sb":  R9,
+	"sl":  R10,
+	"fp":  R11,
+	"ip":  R12,
+	"r13": SP,
+	"r14": LR,
+	"r15": PC,
+	// The flags written by msr may be given explicitly; the "g" flags of the
+	// DSP extension are not supported.
+	"apsr_nzcvq":  APSR,
+	"iapsr_nzcvq": IAPSR,
+	"eapsr_nzcvq": EAPSR,
+	"xpsr_nzcvq":  XPSR,
+}
+
+func init() {
+	for i, name := range regNames {
+		regs[name] = ast.Reg(i)
+	}
+	for reg, name := range sysRegNames {
+		regs[name] = reg
+	}
+}
+
+// A Cond is the condition of a conditionally executed instruction; the value
+// of which is its 4-bit encoding.
+type Cond uint8
+
+// Conditions.
+const (
+	EQ Cond = iota // equal
+	NE             // not equal
+	CS             // carry set; unsigned higher or same
+	CC             // carry clear; unsigned lower
+	MI             // negative
+	PL             // positive or zero
+	VS             // overflow
+	VC             // no overflow
+	HI             // unsigned higher
+	LS             // unsigned lower or same
+	GE             // signed greater than or equal
+	LT             // signed less than
+	GT             // signed greater than
+	LE             // signed less than or equal
+	AL             // always
+)
+
+// condNames maps from condition to canonical condition name.
+var condNames = [...]string{"eq", "ne", "cs", "cc", "mi", "pl", "vs", "vc", "hi", "ls", "ge", "lt", "gt", "le", "al"}
+
+// conds maps from condition name, including aliases, to condition.
+var conds = map[string]Cond{
+	"hs": CS,
+	"lo": CC,
+}
+
+func init() {
+	for i, name := range condNames {
+		conds[name] = Cond(i)
+	}
+}
+
+func (cond Cond) String() string {
+	if int(cond) < len(condNames) {
+		return condNames[cond]
+	}
+	return fmt.Sprintf("<unknown condition: %d>", uint8(cond))
+}
+
+// ParseCond returns the condition of the given name (e.g. "eq" or "hs") and
+// true if present, and false otherwise.
+func ParseCond(name string) (cond Cond, ok bool) {
+	cond, ok = conds[name]
+	return cond, ok
+}
+
+// Invert returns the inverse condition of cond; e.g. NE for EQ. The inverse of
+// AL is AL.
+func (cond Cond) Invert() Cond {
+	if cond == AL {
+		return AL
+	}
+	return cond ^ 1
+}
+
+// A Mnemonic is an instruction mnemonic split into its base mnemonic and its
+// suffixes; e.g. addseq.w is split into add, s, eq and .w.
+type Mnemonic struct {
+	// Base is the base mnemonic; e.g. "add".
+	Base string
+	// S specifies that the instruction updates the condition flags.
+	S bool
+	// Cond is the condition of the instruction; or AL if not present.
+	Cond Cond
+	// Width is the width qualifier of the instruction; either ".w" for a 32-bit
+	// encoding, ".n" for a 16-bit encoding or "" if not present.
+	Width string
+}
+
+// Split splits the given mnemonic into its base mnemonic and its suffixes. It
+// returns false if op is not a mnemonic of the architecture.
+func Split(op ast.Op) (m Mnemonic, ok bool) {
+	m, ok = mnemonics[string(op)]
+	return m, ok
+}
+
+// Properties of base mnemonics.
+const (
+	// The instruction may be conditional.
+	flagCond = 1 << iota
+	// The instruction has a flag-setting variant with an "s" suffix.
+	flagS
+	// The instruction has both 16-bit and 32-bit encodings.
+	flagWidth
+)
+
+// A base describes the operand forms and the properties of a base mnemonic.
+type base struct {
+	// Operand forms.
+	forms []arch.Form
+	// Properties of the base mnemonic.
+	flags int
+}
+
+// Operation table and split mnemonics of the architecture.
+var (
+	ops       = make(arch.OpTable)
+	mnemonics = make(map[string]Mnemonic)
+)
+
+func init() {
+	r, i, m := arch.Reg(GPR), arch.Imm, arch.Mem
+	sh := arch.ShiftedReg(GPR)
+	list, wb := arch.RegList(GPR), arch.Writeback(GPR)
+	sys := arch.Reg(SysReg)
+
+	bases := make(map[string]base)
+	// add adds the given forms and properties to the named base mnemonics.
+	add := func(forms []arch.Form, flags int, names ...string) {
+		for _, name := range names {
+			bases[name] = base{forms: forms, flags: flags}
+		}
+	}
+	// Data processing instructions. The first operand of two operand forms is
+	// both the destination and the first source operand.
+	add([]arch.Form{{r, r, r}, {r, r, sh}, {r, r, i}, {r, r}, {r, sh}, {r, i}}, flagCond|flagS|flagWidth, "add", "sub", "adc", "sbc", "and", "orr", "eor", "bic", "orn", "rsb")
+	add([]arch.Form{{r, r, i}, {r, i}}, flagCond, "addw", "subw")
+	add([]arch.Form{{r, r}, {r, sh}, {r, i}}, flagCond|flagS|flagWidth, "mov", "mvn")
+	add([]arch.Form{{r, i}}, flagCond, "movw", "movt")
+	add([]arch.Form{{r, r}, {r, sh}, {r, i}}, flagCond|flagWidth, "cmp", "cmn", "tst", "teq")
+	add([]arch.Form{{r, r, i}, {r, r, r}, {r, r}, {r, i}}, flagCond|flagS|flagWidth, "lsl", "lsr", "asr", "ror")
+	add([]arch.Form{{r, r}}, flagCond|flagS, "rrx")
+	add([]arch.Form{{r, r}}, flagCond|flagS|flagWidth, "neg")
+	add([]arch.Form{{r, r}}, flagCond, "clz", "rbit")
+	add([]arch.Form{{r, r}}, flagCond|flagWidth, "rev", "rev16", "revsh")
+	add([]arch.Form{{r, r}, {r, sh}}, flagCond|flagWidth, "sxtb", "sxth", "uxtb", "uxth")
+	add([]arch.Form{{r, r, i, i}}, flagCond, "sbfx", "ubfx", "bfi")
+	add([]arch.Form{{r, i, i}}, flagCond, "bfc")
+	add([]arch.Form{{r, i}}, flagCond|flagWidth, "adr")
+	// Multiply and divide instructions.
+	add([]arch.Form{{r, r, r}, {r, r}}, flagCond|flagS|flagWidth, "mul")
+	add([]arch.Form{{r, r, r, r}}, flagCond, "mla", "mls", "smull", "umull", "smlal", "umlal")
+	add([]arch.Form{{r, r, r}, {r, r}}, flagCond, "sdiv", "udiv")
+	// Load and store instructions. Loads may be relative to the program
+	// counter, from a label or from a literal pool. The base register is
+	// updated before the access if followed by '!', such as [r1, #4]!, and
+	// after the access if followed by an offset, such as [r1], #4.
+	pre := arch.PreIndex
+	add([]arch.Form{{r, m}, {r, pre}, {r, m, i}, {r, i}, {r, arch.Literal}}, flagCond|flagWidth, "ldr")
+	add([]arch.Form{{r, m}, {r, pre}, {r, m, i}, {r, i}}, flagCond|flagWidth, "ldrb", "ldrh", "ldrsb", "ldrsh")
+	add([]arch.Form{{r, m}, {r, pre}, {r, m, i}}, flagCond|flagWidth, "str", "strb", "strh")
+	add([]arch.Form{{r, r, m}}, flagCond, "ldrd", "strd")
+	add([]arch.Form{{r, m}}, flagCond, "ldrex", "ldrexb", "ldrexh")
+	add([]arch.Form{{r, r, m}}, flagCond, "strex", "strexb", "strexh")
+	add([]arch.Form{{}}, flagCond, "clrex")
+	add([]arch.Form{{r, list}, {wb, list}}, flagCond|flagWidth, "ldm", "ldmia", "ldmfd", "ldmdb", "ldmea", "stm", "stmia", "stmea", "stmdb", "stmfd")
+	add([]arch.Form{{list}}, flagCond|flagWidth, "push", "pop")
+	// Branch instructions. Branch targets are immediate operands; usually
+	// labels.
+	add([]arch.Form{{i}}, flagCond|flagWidth, "b")
+	add([]arch.Form{{i}}, flagCond, "bl")
+	add([]arch.Form{{r}}, flagCond, "bx", "blx")
+	add([]arch.Form{{r, i}}, 0, "cbz", "cbnz")
+	add([]arch.Form{{m}}, flagCond, "tbb", "tbh")
+	// If-then instructions, whose operand is the condition of the first
+	// instruction of the IT block; such as itte eq.
+	for _, name := range ITs {
+		add([]arch.Form{{i}}, 0, name)
+	}
+	// Miscellaneous instructions. The options of barriers and of cpsid and
+	// cpsie are given by name; such as dmb ish and cpsid i.
+	add([]arch.Form{{}}, flagCond|flagWidth, "nop", "yield", "wfe", "wfi", "sev")
+	add([]arch.Form{{}, {i}}, 0, "bkpt")
+	add([]arch.Form{{i}}, flagCond, "svc")
+	add([]arch.Form{{i}}, flagWidth, "udf")
+	add([]arch.Form{{i}}, 0, "cpsid", "cpsie")
+	add([]arch.Form{{}, {i}}, flagCond, "dmb", "dsb", "isb")
+	add([]arch.Form{{r, sys}}, flagCond, "mrs")
+	add([]arch.Form{{sys, r}}, flagCond, "msr")
+
+	// Expand the suffixes of the base mnemonics.
+	condSuffixes := []string{""}
+	for name := range conds {
+		condSuffixes = append(condSuffixes, name)
+	}
+	sort.Strings(condSuffixes)
+	for name, b := range bases {
+		for _, s := range []bool{false, true} {
+			if s && b.flags&flagS == 0 {
+				continue
+			}
+			for _, condSuffix := range condSuffixes {
+				if condSuffix != "" && b.flags&flagCond == 0 {
+					continue
+				}
+				for _, width := range []string{"", ".w", ".n"} {
+					if width != "" && b.flags&flagWidth == 0 {
+						continue
+					}
+					mnemonic := name
+					if s {
+						mnemonic += "s"
+					}
+					mnemonic += condSuffix + width
+					if _, ok := mnemonics[mnemonic]; ok {
+						panic(fmt.Errorf("arm: ambiguous mnemonic %q", mnemonic))
+					}
+					cond := AL
+					if condSuffix != "" {
+						cond = conds[condSuffix]
+					}
+					mnemonics[mnemonic] = Mnemonic{Base: name, S: s, Cond: cond, Width: width}
+					ops[mnemonic] = b.forms
+				}
+			}
+		}
+	}
+}
+
+// ITs holds the mnemonics of the if-then instructions. The letters following
+// "it" specify whether the condition of the second, third and fourth
+// instruction of the IT block is the same as ("t") or the inverse of ("e") the
+// condition of the first instruction.
+var ITs = []string{
+	"it",
+	"itt", "ite",
+	"ittt", "itte", "itet", "itee",
+	"itttt", "ittte", "ittet", "ittee", "itett", "itete", "iteet", "iteee",
+}
+
+// thumb implements the arch.Arch interface for ARMv7-M.
+type thumb struct{}
+
+// Name returns the name of the architecture.
+func (thumb) Name() string {
+	return "thumb"
+}
+
+// ByteOrder returns the byte order of the architecture.
+func (thumb) ByteOrder() binary.ByteOrder {
+	return binary.LittleEndian
+}
+
+// WordSize returns the size in bytes of a machine word.
+func (thumb) WordSize() int {
+	return 4
+}
+
+// Reg returns the register with the given name and true if present, and false
+// otherwise. General purpose registers are given either by number (e.g. r13)
+// or by name (e.g. sp).
+func (thumb) Reg(name string) (reg ast.Reg, ok bool) {
+	reg, ok = regs[name]
+	return reg, ok
+}
+
+// RegName returns the canonical name of the given register.
+func (thumb) RegName(reg ast.Reg) string {
+	if int(reg) < len(regNames) {
+		return regNames[reg]
+	}
+	if name, ok := sysRegNames[reg]; ok {
+		return name
+	}
+	return fmt.Sprintf("<unknown register: %d>", reg)
+}
+
+// RegClass returns the class of the given register.
+func (thumb) RegClass(reg ast.Reg) arch.RegClass {
+	if int(reg) < len(regNames) {
+		return GPR
+	}
+	if _, ok := sysRegNames[reg]; ok {
+		return SysReg
+	}
+	return arch.RegClass{}
+}
+
+// Mnemonics returns the sorted mnemonics of the instructions of the
+// architecture, including all combinations of suffixes.
+func (thumb) Mnemonics() []string {
+	return ops.Mnemonics()
+}
+
+// Validate returns an error if inst is not a valid instruction of the
+// architecture.
+func (a thumb) Validate(inst *ast.Inst) error {
+	return ops.Validate(a, inst)
+}
+
+// CommaMem returns true; memory operands are written as a comma-separated list
+// of terms, such as [r0, r1, lsl #2].
+func (thumb) CommaMem() bool {
+	return true
+}
@@ -0,0 +1,541 @@
+// Package arm64 describes the AArch64 execution state of the ARMv8-A
+// architecture, whose instructions are encoded using the A64 instruction set.
+// It registers the architecture with the arch package under the name "arm64".
+//
+// Only the integer instructions are described; the floating-point and SIMD
+// instructions are not supported. Conditional branches are written using their
+// condition suffix; such as b.ne.
+package arm64
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/ast"
+)
+
+// Arch is the AArch64 architecture.
+var Arch arch.Arch = arm64{}
+
+func init() {
+	arch.Register(Arch)
+}
+
+// Register classes of the AArch64 architecture. The stack pointer and the zero
+// register share register number 31, and which of them is referred to depends
+// on the instruction and on the position of the operand.
+var (
+	// GPR64 is the class of the 64-bit general purpose registers x0-x30, the
+	// zero register xzr and the stack pointer sp.
+	GPR64 = arch.RegClass{Name: "gpr64", Size: 64}
+	// GPR32 is the class of the 32-bit general purpose registers w0-w30, the
+	// zero register wzr and the stack pointer wsp; which are the lower halves
+	// of the 64-bit registers.
+	GPR32 = arch.RegClass{Name: "gpr32", Size: 32}
+	// SysReg is the class of the system registers, which are accessed by mrs
+	// and msr.
+	SysReg = arch.RegClass{Name: "sysreg", Size: 64}
+)
+
+// General purpose registers. The register number is given by the lower 5 bits
+// of the value of the register; e.g. X30 is x30 and W1 is w1.
+const (
+	X0 ast.Reg = iota
+	X1
+	X2
+	X3
+	X4
+	X5
+	X6
+	X7
+	X8
+	X9
+	X10
+	X11
+	X12
+	X13
+	X14
+	X15
+	X16
+	X17
+	X18
+	X19
+	X20
+	X21
+	X22
+	X23
+	X24
+	X25
+	X26
+	X27
+	X28
+	X29 // frame pointer
+	X30 // link register
+	XZR // zero register
+)
+
+// Stack pointer and 32-bit general purpose registers.
+const (
+	SP  ast.Reg = 0x20 | XZR
+	W0  ast.Reg = 0x40 | X0
+	WZR ast.Reg = 0x40 | XZR
+	WSP ast.Reg = 0x40 | SP
+)
+
+// Aliases of general purpose registers.
+const (
+	FP = X29 // frame pointer
+	LR = X30 // link register
+)
+
+// System registers. The value of a system register is 0x8000 plus its 15-bit
+// encoding op0<1>:op1:CRn:CRm:op2, as used by mrs and msr. Other system
+// registers are given using the generic syntax s<op0>_<op1>_c<n>_c<m>_<op2>;
+// such as s3_3_c13_c0_2 for tpidr_el0.
+const (
+	MIDR_EL1    ast.Reg = 0x8000 | 0x4000 // s3_0_c0_c0_0
+	MPIDR_EL1   ast.Reg = 0x8000 | 0x4005 // s3_0_c0_c0_5
+	SCTLR_EL1   ast.Reg = 0x8000 | 0x4080 // s3_0_c1_c0_0
+	TTBR0_EL1   ast.Reg = 0x8000 | 0x4100 // s3_0_c2_c0_0
+	TTBR1_EL1   ast.Reg = 0x8000 | 0x4101 // s3_0_c2_c0_1
+	TCR_EL1     ast.Reg = 0x8000 | 0x4102 // s3_0_c2_c0_2
+	SPSR_EL1    ast.Reg = 0x8000 | 0x4200 // s3_0_c4_c0_0
+	ELR_EL1     ast.Reg = 0x8000 | 0x4201 // s3_0_c4_c0_1
+	SP_EL0      ast.Reg = 0x8000 | 0x4208 // s3_0_c4_c1_0
+	CURRENTEL   ast.Reg = 0x8000 | 0x4212 // s3_0_c4_c2_2
+	ESR_EL1     ast.Reg = 0x8000 | 0x4290 // s3_0_c5_c2_0
+	FAR_EL1     ast.Reg = 0x8000 | 0x4300 // s3_0_c6_c0_0
+	MAIR_EL1    ast.Reg = 0x8000 | 0x4510 // s3_0_c10_c2_0
+	VBAR_EL1    ast.Reg = 0x8000 | 0x4600 // s3_0_c12_c0_0
+	TPIDR_EL1   ast.Reg = 0x8000 | 0x4684 // s3_0_c13_c0_4
+	CTR_EL0     ast.Reg = 0x8000 | 0x5801 // s3_3_c0_c0_1
+	DCZID_EL0   ast.Reg = 0x8000 | 0x5807 // s3_3_c0_c0_7
+	NZCV        ast.Reg = 0x8000 | 0x5A10 // s3_3_c4_c2_0
+	DAIF        ast.Reg = 0x8000 | 0x5A11 // s3_3_c4_c2_1
+	FPCR        ast.Reg = 0x8000 | 0x5A20 // s3_3_c4_c4_0
+	FPSR        ast.Reg = 0x8000 | 0x5A21 // s3_3_c4_c4_1
+	TPIDR_EL0   ast.Reg = 0x8000 | 0x5E82 // s3_3_c13_c0_2
+	TPIDRRO_EL0 ast.Reg = 0x8000 | 0x5E83 // s3_3_c13_c0_3
+	CNTFRQ_EL0  ast.Reg = 0x8000 | 0x5F00 // s3_3_c14_c0_0
+	CNTVCT_EL0  ast.Reg = 0x8000 | 0x5F02 // s3_3_c14_c0_2
+)
+
+// sysRegNames maps from named system register to canonical register name.
+var sysRegNames = map[ast.Reg]string{
+	MIDR_EL1:    "midr_el1",
+	MPIDR_EL1:   "mpidr_el1",
+	SCTLR_EL1:   "sctlr_el1",
+	TTBR0_EL1:   "ttbr0_el1",
+	TTBR1_EL1:   "ttbr1_el1",
+	TCR_EL1:     "tcr_el1",
+	SPSR_EL1:    "spsr_el1",
+	ELR_EL1:     "elr_el1",
+	SP_EL0:      "sp_el0",
+	CURRENTEL:   "currentel",
+	ESR_EL1:     "esr_el1",
+	FAR_EL1:     "far_el1",
+	MAIR_EL1:    "mair_el1",
+	VBAR_EL1:    "vbar_el1",
+	TPIDR_EL1:   "tpidr_el1",
+	CTR_EL0:     "ctr_el0",
+	DCZID_EL0:   "dczid_el0",
+	NZCV:        "nzcv",
+	DAIF:        "daif",
+	FPCR:        "fpcr",
+	FPSR:        "fpsr",
+	TPIDR_EL0:   "tpidr_el0",
+	TPIDRRO_EL0: "tpidrro_el0",
+	CNTFRQ_EL0:  "cntfrq_el0",
+	CNTVCT_EL0:  "cntvct_el0",
+}
+
+// regs maps from register name, including aliases, to register.
+var regs = map[string]ast.Reg{
+	"xzr": XZR,
+	"sp":  SP,
+	"wzr": WZR,
+	"wsp": WSP,
+	"fp":  FP,
+	"lr":  LR,
+}
+
+func init() {
+	for i := X0; i < XZR; i++ {
+		regs[fmt.Sprintf("x%d", i)] = i
+		regs[fmt.Sprintf("w%d", i)] = 0x40 | i
+	}
+	for reg, name := range sysRegNames {
+		regs[name] = reg
+	}
+}
+
+// Num returns the 5-bit register number of the given general purpose register;
+// 31 for the zero register and the stack pointer.
+func Num(reg ast.Reg) uint32 {
+	return uint32(reg & 0x1F)
+}
+
+// Is32 returns true if reg is a 32-bit general purpose register, and false
+// otherwise.
+func Is32(reg ast.Reg) bool {
+	return reg&0x40 != 0
+}
+
+// IsSP returns true if reg is the stack pointer sp or wsp, and false otherwise.
+func IsSP(reg ast.Reg) bool {
+	return reg&0x20 != 0
+}
+
+// SysRegEnc returns the 15-bit encoding of the given system register, as used
+// by mrs and msr.
+func SysRegEnc(reg ast.Reg) uint32 {
+	return uint32(reg) & 0x7FFF
+}
+
+// A Cond is the condition of a conditionally executed instruction; the value
+// of which is its 4-bit encoding.
+type Cond uint8
+
+// Conditions.
+const (
+	EQ Cond = iota // equal
+	NE             // not equal
+	HS             // carry set; unsigned higher or same
+	LO             // carry clear; unsigned lower
+	MI             // negative
+	PL             // positive or zero
+	VS             // overflow
+	VC             // no overflow
+	HI             // unsigned higher
+	LS             // unsigned lower or same
+	GE             // signed greater than or equal
+	LT             // signed less than
+	GT             // signed greater than
+	LE             // signed less than or equal
+	AL             // always
+	NV             // always; behaves as AL
+)
+
+// condNames maps from condition to canonical condition name.
+var condNames = [...]string{"eq", "ne", "hs", "lo", "mi", "pl", "vs", "vc", "hi", "ls", "ge", "lt", "gt", "le", "al", "nv"}
+
+// conds maps from condition name, including aliases, to condition.
+var conds = map[string]Cond{
+	"cs": HS,
+	"cc": LO,
+}
+
+func init() {
+	for i, name := range condNames {
+		conds[name] = Cond(i)
+	}
+}
+
+func (cond Cond) String() string {
+	if int(cond) < len(condNames) {
+		return condNames[cond]
+	}
+	return fmt.Sprintf("<unknown condition: %d>", uint8(cond))
+}
+
+// ParseCond returns the condition of the given name (e.g. "eq" or "cs") and
+// true if present, and false otherwise.
+func ParseCond(name string) (cond Cond, ok bool) {
+	cond, ok = conds[name]
+	return cond, ok
+}
+
+// Invert returns the inverse condition of cond; e.g. NE for EQ.
+func (cond Cond) Invert() Cond {
+	return cond ^ 1
+}
+
+// EncodeLogicalImm returns the 13-bit encoding N:immr:imms of val as a bitmask
+// immediate of the given register width (32 or 64), and true if val may be
+// encoded as such. A bitmask immediate is a pattern of 2, 4, 8, 16, 32 or 64
+// bits, consisting of a rotated run of ones, which is replicated across the
+// register. The values 0 and all ones are not bitmask immediates.
+func EncodeLogicalImm(val uint64, width int) (uint32, bool) {
+	if width == 32 {
+		if val>>32 != 0 {
+			return 0, false
+		}
+		val |= val << 32
+	}
+	if val == 0 || val == ^uint64(0) {
+		return 0, false
+	}
+	// Locate the smallest element size at which the pattern repeats.
+	size := uint(64)
+	for size > 2 {
+		half := size / 2
+		mask := uint64(1)<<half - 1
+		if val&mask != val>>half&mask {
+			break
+		}
+		size = half
+	}
+	mask := ^uint64(0) >> (64 - size)
+	elem := val & mask
+	// Rotate the element right, so that its run of ones starts at bit 0.
+	ones := uint(bits.OnesCount64(elem))
+	for rot := uint(0); rot < size; rot++ {
+		x := (elem>>rot | elem<<(size-rot)) & mask
+		if x == 1<<ones-1 {
+			n := uint32(0)
+			if size == 64 {
+				n = 1
+			}
+			// The element size is encoded by the leading ones of ^imms.
+			imms := uint32(^(size<<1-1)&0x3F) | uint32(ones-1)
+			immr := uint32(size-rot) & uint32(size-1)
+			return n<<12 | immr<<6 | imms&0x3F, true
+		}
+	}
+	return 0, false
+}
+
+// DecodeLogicalImm returns the value of the 13-bit encoding N:immr:imms of a
+// bitmask immediate of the given register width (32 or 64), and true if the
+// encoding is valid.
+func DecodeLogicalImm(enc uint32, width int) (uint64, bool) {
+	n, immr, imms := enc>>12&1, uint(enc>>6&0x3F), enc&0x3F
+	if n == 1 && width == 32 {
+		return 0, false
+	}
+	// The element size is given by the most significant bit of N:NOT(imms).
+	l := bits.Len32(n<<6 | ^imms&0x3F)
+	if l < 2 {
+		return 0, false
+	}
+	size := uint(1) << uint(l-1)
+	levels := uint32(size - 1)
+	s, r := uint(imms&levels), immr&uint(levels)
+	if s == uint(levels) {
+		// All ones elements are reserved.
+		return 0, false
+	}
+	mask := ^uint64(0) >> (64 - size)
+	elem := uint64(1)<<(s+1) - 1
+	elem = (elem>>r | elem<<(size-r)) & mask
+	val := elem
+	for i := size; i < 64; i *= 2 {
+		val |= val << i
+	}
+	if width == 32 {
+		val &= 0xFFFFFFFF
+	}
+	return val, true
+}
+
+// ops is the operation table of the architecture.
+var ops = make(arch.OpTable)
+
+func init() {
+	x, w := arch.Reg(GPR64), arch.Reg(GPR32)
+	shx, shw := arch.ShiftedReg(GPR64), arch.ShiftedReg(GPR32)
+	i, m, pre, shi := arch.Imm, arch.Mem, arch.PreIndex, arch.ShiftedImm
+	sys := arch.Reg(SysReg)
+
+	// add adds the given forms to the named mnemonics.
+	add := func(forms []arch.Form, names ...string) {
+		for _, name := range names {
+			ops[name] = append(ops[name], forms...)
+		}
+	}
+	// both returns the forms of both register widths, as given by a function
+	// of the register shape and the shifted register shape of a width.
+	both := func(f func(r, sh arch.Shape) []arch.Form) []arch.Form {
+		return append(f(x, shx), f(w, shw)...)
+	}
+
+	// Arithmetic instructions. The extended register forms add a 32-bit
+	// register, which is sign or zero extended, to a 64-bit register.
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r, r}, {r, r, sh}, {r, r, i}, {r, r, shi}} }), "add", "adds", "sub", "subs")
+	add([]arch.Form{{x, x, shw}}, "add", "adds", "sub", "subs")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r}, {r, sh}, {r, i}, {r, shi}} }), "cmp", "cmn")
+	add([]arch.Form{{x, shw}}, "cmp", "cmn")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r}, {r, sh}} }), "neg", "negs", "mvn")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r, r}} }), "adc", "adcs", "sbc", "sbcs")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r}} }), "ngc", "ngcs")
+	// Logical instructions. Immediate operands are bitmask immediates.
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r, r}, {r, r, sh}, {r, r, i}} }), "and", "ands", "orr", "eor", "bic", "bics", "orn", "eon")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r}, {r, sh}, {r, i}} }), "tst")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r}, {r, i}} }), "mov")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, i}, {r, shi}} }), "movz", "movn", "movk")
+	// Shift and bitfield instructions.
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r, r}, {r, r, i}} }), "lsl", "lsr", "asr", "ror")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r, r}} }), "lslv", "lsrv", "asrv", "rorv")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r, i, i}} }), "ubfm", "sbfm", "bfm", "ubfx", "sbfx", "ubfiz", "sbfiz", "bfi", "bfxil")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r, r, i}} }), "extr")
+	add([]arch.Form{{x, w}, {w, w}}, "sxtb", "sxth")
+	add([]arch.Form{{x, w}}, "sxtw")
+	add([]arch.Form{{w, w}}, "uxtb", "uxth")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r}} }), "rbit", "rev", "rev16", "clz", "cls")
+	add([]arch.Form{{x, x}}, "rev32")
+	// Multiply and divide instructions.
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r, r}} }), "udiv", "sdiv", "mul", "mneg")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r, r, r}} }), "madd", "msub")
+	add([]arch.Form{{x, w, w, x}}, "smaddl", "smsubl", "umaddl", "umsubl")
+	add([]arch.Form{{x, w, w}}, "smull", "umull", "smnegl", "umnegl")
+	add([]arch.Form{{x, x, x}}, "smulh", "umulh")
+	// Conditional instructions. Conditions are given by name; such as eq.
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r, r, i}} }), "csel", "csinc", "csinv", "csneg")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, i}} }), "cset", "csetm")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r, i}} }), "cinc", "cinv", "cneg")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r, i, i}, {r, i, i, i}} }), "ccmp", "ccmn")
+	// Address generation instructions.
+	add([]arch.Form{{x, i}}, "adr", "adrp")
+	// Branch instructions. Branch targets are immediate operands; usually
+	// labels.
+	add([]arch.Form{{i}}, "b", "bl")
+	for name := range conds {
+		add([]arch.Form{{i}}, "b."+name)
+	}
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, i}} }), "cbz", "cbnz")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, i, i}} }), "tbz", "tbnz")
+	add([]arch.Form{{x}}, "br", "blr")
+	add([]arch.Form{{}, {x}}, "ret")
+	// System instructions. The options of barriers are given by name; such as
+	// dmb ish.
+	add([]arch.Form{{i}}, "svc", "hvc", "smc", "brk", "hlt", "hint", "dmb", "dsb")
+	add([]arch.Form{{}}, "nop", "yield", "wfe", "wfi", "sev", "sevl", "eret")
+	add([]arch.Form{{}, {i}}, "clrex", "isb")
+	add([]arch.Form{{x, sys}}, "mrs")
+	add([]arch.Form{{sys, x}}, "msr")
+	// Load and store instructions. Post-indexed memory operands are followed by
+	// the offset; such as [x0], #8. Loads may be relative to the program
+	// counter, from a label or from a literal pool.
+	ldst := func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, m}, {r, pre}, {r, m, i}} }
+	lit := func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, i}, {r, arch.Literal}} }
+	add(both(ldst), "ldr", "str", "ldrsb", "ldrsh")
+	add(both(lit), "ldr")
+	add(ldst(w, shw), "ldrb", "ldrh", "strb", "strh")
+	add(ldst(x, shx), "ldrsw")
+	add(lit(x, shx), "ldrsw")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, m}} }), "ldur", "stur", "ldursb", "ldursh")
+	add([]arch.Form{{w, m}}, "ldurb", "ldurh", "sturb", "sturh")
+	add([]arch.Form{{x, m}}, "ldursw")
+	pair := func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r, m}, {r, r, pre}, {r, r, m, i}} }
+	add(both(pair), "ldp", "stp")
+	add(pair(x, shx), "ldpsw")
+	// Exclusive and acquire-release instructions. The status register of
+	// exclusive stores precedes the stored register.
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, m}} }), "ldxr", "ldaxr", "ldar", "stlr")
+	add([]arch.Form{{w, m}}, "ldxrb", "ldxrh", "ldaxrb", "ldaxrh", "ldarb", "ldarh", "stlrb", "stlrh")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{w, r, m}} }), "stxr", "stlxr")
+	add([]arch.Form{{w, w, m}}, "stxrb", "stxrh", "stlxrb", "stlxrh")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{r, r, m}} }), "ldxp", "ldaxp")
+	add(both(func(r, sh arch.Shape) []arch.Form { return []arch.Form{{w, r, r, m}} }), "stxp", "stlxp")
+}
+
+// arm64 implements the arch.Arch interface for AArch64.
+type arm64 struct{}
+
+// Name returns the name of the architecture.
+func (arm64) Name() string {
+	return "arm64"
+}
+
+// ByteOrder returns the byte order of the architecture.
+func (arm64) ByteOrder() binary.ByteOrder {
+	return binary.LittleEndian
+}
+
+// WordSize returns the size in bytes of a machine word.
+func (arm64) WordSize() int {
+	return 8
+}
+
+// Reg returns the register with the given name and true if present, and false
+// otherwise. System registers are given either by name (e.g. tpidr_el0) or by
+// encoding (e.g. s3_3_c13_c0_2).
+func (arm64) Reg(name string) (reg ast.Reg, ok bool) {
+	if reg, ok = regs[name]; ok {
+		return reg, true
+	}
+	return parseSysReg(name)
+}
+
+// parseSysReg returns the system register of the given name written using the
+// generic syntax s<op0>_<op1>_c<n>_c<m>_<op2>, and true if valid.
+func parseSysReg(name string) (reg ast.Reg, ok bool) {
+	fields := strings.Split(name, "_")
+	if len(fields) != 5 || !strings.HasPrefix(fields[0], "s") || !strings.HasPrefix(fields[2], "c") || !strings.HasPrefix(fields[3], "c") {
+		return 0, false
+	}
+	fields[0], fields[2], fields[3] = fields[0][1:], fields[2][1:], fields[3][1:]
+	var vals [5]uint64
+	for i, field := range fields {
+		val, err := strconv.ParseUint(field, 10, 8)
+		if err != nil {
+			return 0, false
+		}
+		vals[i] = val
+	}
+	op0, op1, crn, crm, op2 := vals[0], vals[1], vals[2], vals[3], vals[4]
+	if op0 < 2 || op0 > 3 || op1 > 7 || crn > 15 || crm > 15 || op2 > 7 {
+		return 0, false
+	}
+	return ast.Reg(0x8000 | (op0-2)<<14 | op1<<11 | crn<<7 | crm<<3 | op2), true
+}
+
+// RegName returns the canonical name of the given register.
+func (arm64) RegName(reg ast.Reg) string {
+	switch {
+	case reg == XZR:
+		return "xzr"
+	case reg == SP:
+		return "sp"
+	case reg == WZR:
+		return "wzr"
+	case reg == WSP:
+		return "wsp"
+	case reg < XZR:
+		return fmt.Sprintf("x%d", reg)
+	case reg >= W0 && reg < WZR:
+		return fmt.Sprintf("w%d", reg&0x1F)
+	case reg&0x8000 != 0:
+		if name, ok := sysRegNames[reg]; ok {
+			return name
+		}
+		enc := SysRegEnc(reg)
+		return fmt.Sprintf("s%d_%d_c%d_c%d_%d", 2+enc>>14, enc>>11&7, enc>>7&15, enc>>3&15, enc&7)
+	}
+	return fmt.Sprintf("<unknown register: %d>", reg)
+}
+
+// RegClass returns the class of the given register.
+func (arm64) RegClass(reg ast.Reg) arch.RegClass {
+	switch {
+	case reg <= XZR || reg == SP:
+		return GPR64
+	case reg >= W0 && reg <= WZR || reg == WSP:
+		return GPR32
+	case reg&0x8000 != 0:
+		return SysReg
+	}
+	return arch.RegClass{}
+}
+
+// CommaMem returns true; memory operands are written as a comma-separated list
+// of terms, such as [x0, #16].
+func (arm64) CommaMem() bool {
+	return true
+}
+
+// Mnemonics returns the sorted mnemonics of the instructions of the
+// architecture, including aliases.
+func (arm64) Mnemonics() []string {
+	return ops.Mnemonics()
+}
+
+// Validate returns an error if inst is not a valid instruction of the
+// architecture.
+func (a arm64) Validate(inst *ast.Inst) error {
+	return ops.Validate(a, inst)
+}
@@ -0,0 +1,226 @@
+package arch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mewlang/asm/ast"
+)
+
+// Kind specifies the kind of an operand.
+type Kind int
+
+// Operand kinds.
+const (
+	// KindReg matches a register of a given class.
+	KindReg Kind = iota + 1
+	// KindImm matches an immediate value, a label or a constant expression.
+	KindImm
+	// KindMem matches a memory operand.
+	KindMem
+	// KindRegList matches a list of registers of a given class.
+	KindRegList
+	// KindShiftedReg matches a shifted register of a given class.
+	KindShiftedReg
+	// KindWriteback matches a base register of a given class which is updated
+	// by the instruction.
+	KindWriteback
+	// KindLiteral matches a constant loaded from a literal pool.
+	KindLiteral
+	// KindPreIndex matches a memory operand whose base register is updated
+	// before the memory access; such as [sp, #-16]!.
+	KindPreIndex
+	// KindShiftedImm matches a shifted immediate; such as #1, lsl #12.
+	KindShiftedImm
+)
+
+// A Shape describes the expected shape of an instruction operand.
+type Shape struct {
+	// Kind is the kind of the operand.
+	Kind Kind
+	// Class is the register class of register operands.
+	Class RegClass
+	// Size is the size in bits of the memory location of sized memory operands;
+	// or 0 to match memory operands of any size.
+	Size int
+}
+
+func (shape Shape) String() string {
+	switch shape.Kind {
+	case KindReg:
+		return shape.Class.String()
+	case KindImm:
+		return "imm"
+	case KindMem:
+		if shape.Size != 0 {
+			return fmt.Sprintf("mem%d", shape.Size)
+		}
+		return "mem"
+	case KindRegList:
+		return "{" + shape.Class.String() + "}"
+	case KindShiftedReg:
+		return "shifted " + shape.Class.String()
+	case KindWriteback:
+		return shape.Class.String() + "!"
+	case KindLiteral:
+		return "=imm"
+	case KindPreIndex:
+		return "mem!"
+	case KindShiftedImm:
+		return "shifted imm"
+	}
+	return fmt.Sprintf("<unknown operand kind: %d>", shape.Kind)
+}
+
+// Reg returns the shape of a register operand of the given class.
+func Reg(class RegClass) Shape {
+	return Shape{Kind: KindReg, Class: class}
+}
+
+// Imm is the shape of an immediate operand.
+var Imm = Shape{Kind: KindImm}
+
+// Mem is the shape of a memory operand.
+var Mem = Shape{Kind: KindMem}
+
+// SizedMem returns the shape of a memory operand whose size is specified by a
+// size keyword of the given size in bits; such as dword [eax].
+func SizedMem(size int) Shape {
+	return Shape{Kind: KindMem, Size: size}
+}
+
+// RegList returns the shape of a register list of the given class.
+func RegList(class RegClass) Shape {
+	return Shape{Kind: KindRegList, Class: class}
+}
+
+// ShiftedReg returns the shape of a shifted register of the given class.
+func ShiftedReg(class RegClass) Shape {
+	return Shape{Kind: KindShiftedReg, Class: class}
+}
+
+// Writeback returns the shape of a base register of the given class which is
+// updated by the instruction.
+func Writeback(class RegClass) Shape {
+	return Shape{Kind: KindWriteback, Class: class}
+}
+
+// Literal is the shape of a constant loaded from a literal pool.
+var Literal = Shape{Kind: KindLiteral}
+
+// PreIndex is the shape of a memory operand whose base register is updated
+// before the memory access.
+var PreIndex = Shape{Kind: KindPreIndex}
+
+// ShiftedImm is the shape of a shifted immediate.
+var ShiftedImm = Shape{Kind: KindShiftedImm}
+
+// A Form is a sequence of operand shapes accepted by an instruction.
+type Form []Shape
+
+func (form Form) String() string {
+	var shapes []string
+	for _, shape := range form {
+		shapes = append(shapes, shape.String())
+	}
+	return strings.Join(shapes, ", ")
+}
+
+// An OpTable maps the mnemonics of the instructions of an architecture to their
+// operand forms. It provides a table driven implementation of the Mnemonics and
+// Validate methods of the Arch interface.
+type OpTable map[string][]Form
+
+// Mnemonics returns the sorted mnemonics of the table.
+func (t OpTable) Mnemonics() []string {
+	var mnemonics []string
+	for mnemonic := range t {
+		mnemonics = append(mnemonics, mnemonic)
+	}
+	sort.Strings(mnemonics)
+	return mnemonics
+}
+
+// Validate returns an error if the operation of inst is not present in the
+// table, or if the operands of inst do not match any of its operand forms. The
+// register classes of register operands are given by the architecture.
+func (t OpTable) Validate(a Arch, inst *ast.Inst) error {
+	forms, ok := t[string(inst.Op)]
+	if !ok {
+		return fmt.Errorf("arch.OpTable.Validate: unknown %s instruction %q", a.Name(), inst.Op)
+	}
+	for _, form := range forms {
+		if Match(a, form, inst.Args) {
+			return nil
+		}
+	}
+	var want []string
+	for _, form := range forms {
+		want = append(want, fmt.Sprintf("%q", form))
+	}
+	return fmt.Errorf("arch.OpTable.Validate: invalid operands of %q; expected %s", inst.Op, strings.Join(want, " or "))
+}
+
+// Match returns true if the provided operands match the given form, and false
+// otherwise.
+func Match(a Arch, form Form, args []ast.Arg) bool {
+	if len(args) != len(form) {
+		return false
+	}
+	for i, shape := range form {
+		if !MatchShape(a, shape, args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchShape returns true if the provided operand matches the given shape, and
+// false otherwise.
+func MatchShape(a Arch, shape Shape, arg ast.Arg) bool {
+	switch shape.Kind {
+	case KindReg:
+		reg, ok := arg.(ast.Reg)
+		return ok && a.RegClass(reg) == shape.Class
+	case KindImm:
+		switch arg.(type) {
+		case ast.Int, ast.Uint, ast.Addr, ast.Ident, *ast.BinaryExpr, *ast.UnaryExpr, *ast.ParenExpr, *ast.Imm:
+			return true
+		}
+	case KindMem:
+		mem, ok := arg.(*ast.Mem)
+		return ok && (shape.Size == 0 || mem.Size == shape.Size)
+	case KindRegList:
+		list, ok := arg.(*ast.RegList)
+		if !ok {
+			return false
+		}
+		for _, reg := range list.Regs {
+			if !MatchShape(a, Reg(shape.Class), reg) {
+				return false
+			}
+		}
+		return true
+	case KindShiftedReg:
+		shift, ok := arg.(*ast.ShiftedReg)
+		return ok && MatchShape(a, Reg(shape.Class), shift.X)
+	case KindWriteback:
+		wb, ok := arg.(*ast.Writeback)
+		return ok && MatchShape(a, Reg(shape.Class), wb.X)
+	case KindLiteral:
+		_, ok := arg.(*ast.Literal)
+		return ok
+	case KindPreIndex:
+		if wb, ok := arg.(*ast.Writeback); ok {
+			_, ok := wb.X.(*ast.Mem)
+			return ok
+		}
+	case KindShiftedImm:
+		if shift, ok := arg.(*ast.ShiftedReg); ok {
+			_, ok := shift.X.(*ast.Imm)
+			return ok
+		}
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+// Package mips describes the MIPS32 instruction set architecture. It registers
+// itself with the arch package under the name "mips".
+package mips
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/ast"
+)
+
+// Arch is the MIPS32 architecture.
+var Arch arch.Arch = mips{}
+
+func init() {
+	arch.Register(Arch)
+}
+
+// GPR is the class of the 32 general purpose registers.
+var GPR = arch.RegClass{Name: "gpr", Size: 32}
+
+// Registers of the MIPS32 architecture.
+const (
+	Zero ast.Reg = iota // constant zero
+	AT                  // assembler temporary
+	V0                  // function results
+	V1
+	A0 // function arguments
+	A1
+	A2
+	A3
+	T0 // temporaries
+	T1
+	T2
+	T3
+	T4
+	T5
+	T6
+	T7
+	S0 // saved temporaries
+	S1
+	S2
+	S3
+	S4
+	S5
+	S6
+	S7
+	T8 // temporaries
+	T9
+	K0 // reserved for the kernel
+	K1
+	GP // global pointer
+	SP // stack pointer
+	FP // frame pointer
+	RA // return address
+)
+
+// regNames maps from register to canonical register name.
+var regNames = [...]string{
+	"$zero", "$at", "$v0", "$v1", "$a0", "$a1", "$a2", "$a3",
+	"$t0", "$t1", "$t2", "$t3", "$t4", "$t5", "$t6", "$t7",
+	"$s0", "$s1", "$s2", "$s3", "$s4", "$s5", "$s6", "$s7",
+	"$t8", "$t9", "$k0", "$k1", "$gp", "$sp", "$fp", "$ra",
+}
+
+// regs maps from register name, including aliases, to register.
+var regs = make(map[string]ast.Reg)
+
+func init() {
+	for i, name := range regNames {
+		regs[name] = ast.Reg(i)
+		regs[fmt.Sprintf("$r%d", i)] = ast.Reg(i)
+	}
+	regs["$s8"] = FP
+}
+
+// Operand shapes.
+var (
+	r = arch.Reg(GPR)
+	i = arch.Imm
+	m = arch.Mem
+)
+
+// ops maps from mnemonic to the operand forms of the instruction. Branch and
+// jump targets are immediate operands; usually labels. The memory operands of
+// loads and stores consist of a base register and a 16-bit displacement; such
+// as 8($sp).
+var ops = arch.OpTable{
+	// Arithmetic and logical instructions.
+	"add":   {{r, r, r}},
+	"addu":  {{r, r, r}},
+	"sub":   {{r, r, r}},
+	"subu":  {{r, r, r}},
+	"and":   {{r, r, r}},
+	"or":    {{r, r, r}},
+	"xor":   {{r, r, r}},
+	"nor":   {{r, r, r}},
+	"slt":   {{r, r, r}},
+	"sltu":  {{r, r, r}},
+	"addi":  {{r, r, i}},
+	"addiu": {{r, r, i}},
+	"slti":  {{r, r, i}},
+	"sltiu": {{r, r, i}},
+	"andi":  {{r, r, i}},
+	"ori":   {{r, r, i}},
+	"xori":  {{r, r, i}},
+	"lui":   {{r, i}},
+	// Load and store instructions.
+	"lb":  {{r, m}},
+	"lbu": {{r, m}},
+	"lh":  {{r, m}},
+	"lhu": {{r, m}},
+	"lw":  {{r, m}},
+	"sb":  {{r, m}},
+	"sh":  {{r, m}},
+	"sw":  {{r, m}},
+	// Shift instructions.
+	"sll":  {{r, r, i}},
+	"srl":  {{r, r, i}},
+	"sra":  {{r, r, i}},
+	"sllv": {{r, r, r}},
+	"srlv": {{r, r, r}},
+	"srav": {{r, r, r}},
+	// Multiplication and division instructions.
+	"mult":  {{r, r}},
+	"multu": {{r, r}},
+	"div":   {{r, r}},
+	"divu":  {{r, r}},
+	"mfhi":  {{r}},
+	"mflo":  {{r}},
+	"mthi":  {{r}},
+	"mtlo":  {{r}},
+	// Branch and jump instructions.
+	"beq":  {{r, r, i}},
+	"bne":  {{r, r, i}},
+	"blez": {{r, i}},
+	"bgtz": {{r, i}},
+	"bltz": {{r, i}},
+	"bgez": {{r, i}},
+	"j":    {{i}},
+	"jal":  {{i}},
+	"jr":   {{r}},
+	"jalr": {{r}, {r, r}},
+	// Exception instructions.
+	"syscall": {{}},
+	"break":   {{}},
+	// Pseudo-instructions.
+	"nop":  {{}},
+	"move": {{r, r}},
+	"not":  {{r, r}},
+	"neg":  {{r, r}},
+	"li":   {{r, i}},
+	"la":   {{r, i}},
+	"b":    {{i}},
+	"beqz": {{r, i}},
+	"bnez": {{r, i}},
+}
+
+// mips implements the arch.Arch interface for MIPS32.
+type mips struct{}
+
+// Name returns the name of the architecture.
+func (mips) Name() string {
+	return "mips"
+}
+
+// ByteOrder returns the byte order of the architecture.
+func (mips) ByteOrder() binary.ByteOrder {
+	return binary.BigEndian
+}
+
+// WordSize returns the size in bytes of a machine word.
+func (mips) WordSize() int {
+	return 4
+}
+
+// Reg returns the register with the given name and true if present, and false
+// otherwise. Registers are given either by name (e.g. $t0) or by number (e.g.
+// $r8).
+func (mips) Reg(name string) (reg ast.Reg, ok bool) {
+	reg, ok = regs[name]
+	return reg, ok
+}
+
+// RegName returns the canonical name of the given register.
+func (mips) RegName(reg ast.Reg) string {
+	if int(reg) < len(regNames) {
+		return regNames[reg]
+	}
+	return fmt.Sprintf("<unknown register: %d>", reg)
+}
+
+// RegClass returns the class of the given register.
+func (mips) RegClass(reg ast.Reg) arch.RegClass {
+	if int(reg) < len(regNames) {
+		return GPR
+	}
+	return arch.RegClass{}
+}
+
+// OffsetMem returns true; memory operands are written using the offset(base)
+// syntax, such as 8($sp).
+func (mips) OffsetMem() bool {
+	return true
+}
+
+// Mnemonics returns the sorted mnemonics of the instructions of the
+// architecture, including pseudo-instructions.
+func (mips) Mnemonics() []string {
+	return ops.Mnemonics()
+}
+
+// Validate returns an error if inst is not a valid MIPS32 instruction.
+func (a mips) Validate(inst *ast.Inst) error {
+	return ops.Validate(a, inst)
+}
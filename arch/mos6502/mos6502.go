@@ -0,0 +1,147 @@
+// Package mos6502 describes the instruction set architecture of the MOS 6502
+// 8-bit microprocessor, as used by the NES, the Commodore 64 and the Apple II.
+// It registers itself with the arch package under the name "6502".
+//
+// The addressing modes of the 6502 are expressed using the following operands,
+// where addr and zp are expressions of 16-bit and 8-bit addresses respectively:
+//
+//	lda #imm      ; immediate
+//	lda addr      ; zero page or absolute
+//	lda addr, x   ; zero page or absolute, indexed by x
+//	lda addr, y   ; zero page or absolute, indexed by y
+//	lda (zp, x)   ; indexed indirect
+//	lda (zp), y   ; indirect indexed
+//	jmp (addr)    ; indirect
+//	asl a         ; accumulator
+//
+// An operand whose outermost expression is parenthesized is indirect; e.g. jmp
+// (vec) jumps to the address stored at vec, whereas jmp vec+(2*3) does not.
+package mos6502
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/ast"
+)
+
+// Arch is the 6502 architecture.
+var Arch arch.Arch = mos6502{}
+
+func init() {
+	arch.Register(Arch)
+}
+
+// Register classes of the 8-bit registers; each register forms a class of its
+// own, as the registers may not be used interchangeably.
+var (
+	Acc    = arch.RegClass{Name: "a", Size: 8}
+	IndexX = arch.RegClass{Name: "x", Size: 8}
+	IndexY = arch.RegClass{Name: "y", Size: 8}
+)
+
+// Registers of the 6502 architecture, which may be given as operands.
+const (
+	A ast.Reg = iota // accumulator
+	X                // index register x
+	Y                // index register y
+)
+
+// regNames maps from register to canonical register name.
+var regNames = [...]string{"a", "x", "y"}
+
+// regClasses maps from register to register class.
+var regClasses = [...]arch.RegClass{Acc, IndexX, IndexY}
+
+// ops is the operation table of the architecture.
+var ops = make(arch.OpTable)
+
+func init() {
+	i, m := arch.Imm, arch.Mem
+	a, x, y := arch.Reg(Acc), arch.Reg(IndexX), arch.Reg(IndexY)
+
+	// add adds the given forms to the named mnemonics.
+	add := func(forms []arch.Form, names ...string) {
+		for _, name := range names {
+			ops[name] = append(ops[name], forms...)
+		}
+	}
+
+	// Load, store, arithmetic and logical instructions of the accumulator.
+	// Immediate, absolute and indirect operands all have the immediate shape.
+	add([]arch.Form{{i}, {i, x}, {i, y}, {m}}, "adc", "and", "cmp", "eor", "lda", "ora", "sbc", "sta")
+	// Read-modify-write instructions.
+	add([]arch.Form{{}, {a}, {i}, {i, x}}, "asl", "lsr", "rol", "ror")
+	add([]arch.Form{{i}, {i, x}}, "inc", "dec")
+	// Load and store instructions of the index registers.
+	add([]arch.Form{{i}, {i, y}}, "ldx", "stx")
+	add([]arch.Form{{i}, {i, x}}, "ldy", "sty")
+	add([]arch.Form{{i}}, "cpx", "cpy", "bit")
+	// Branch and jump instructions.
+	add([]arch.Form{{i}}, "bcc", "bcs", "beq", "bmi", "bne", "bpl", "bvc", "bvs", "jmp", "jsr")
+	// Implied instructions.
+	add([]arch.Form{{}},
+		"brk", "nop", "rti", "rts",
+		"clc", "cld", "cli", "clv", "sec", "sed", "sei",
+		"dex", "dey", "inx", "iny",
+		"pha", "php", "pla", "plp",
+		"tax", "tay", "tsx", "txa", "txs", "tya")
+}
+
+// mos6502 implements the arch.Arch interface for the 6502.
+type mos6502 struct{}
+
+// Name returns the name of the architecture.
+func (mos6502) Name() string {
+	return "6502"
+}
+
+// ByteOrder returns the byte order of the architecture.
+func (mos6502) ByteOrder() binary.ByteOrder {
+	return binary.LittleEndian
+}
+
+// WordSize returns the size in bytes of a machine word.
+func (mos6502) WordSize() int {
+	return 1
+}
+
+// Reg returns the register with the given name and true if present, and false
+// otherwise. Register names are case-insensitive.
+func (mos6502) Reg(name string) (reg ast.Reg, ok bool) {
+	for i, regName := range regNames {
+		if strings.ToLower(name) == regName {
+			return ast.Reg(i), true
+		}
+	}
+	return 0, false
+}
+
+// RegName returns the canonical name of the given register.
+func (mos6502) RegName(reg ast.Reg) string {
+	if int(reg) < len(regNames) {
+		return regNames[reg]
+	}
+	return fmt.Sprintf("<unknown register: %d>", reg)
+}
+
+// RegClass returns the class of the given register.
+func (mos6502) RegClass(reg ast.Reg) arch.RegClass {
+	if int(reg) < len(regClasses) {
+		return regClasses[reg]
+	}
+	return arch.RegClass{}
+}
+
+// Mnemonics returns the sorted mnemonics of the instructions of the
+// architecture.
+func (mos6502) Mnemonics() []string {
+	return ops.Mnemonics()
+}
+
+// Validate returns an error if inst is not a valid 6502 instruction.
+func (a mos6502) Validate(inst *ast.Inst) error {
+	return ops.Validate(a, inst)
+}
@@ -0,0 +1,298 @@
+// Package riscv describes the 32-bit and 64-bit RISC-V instruction set
+// architectures, with the M (integer multiplication and division), A (atomic)
+// and C (compressed) standard extensions. It registers the architectures with
+// the arch package under the names "riscv32" and "riscv64" respectively.
+package riscv
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/ast"
+)
+
+// Architectures of the base integer instruction sets.
+var (
+	// Arch is the RV32I architecture.
+	Arch arch.Arch = riscv{xlen: 32}
+	// Arch64 is the RV64I architecture.
+	Arch64 arch.Arch = riscv{xlen: 64}
+)
+
+func init() {
+	arch.Register(Arch)
+	arch.Register(Arch64)
+}
+
+// Register classes of the 32 general purpose registers of the base integer
+// instruction sets.
+var (
+	GPR   = arch.RegClass{Name: "gpr", Size: 32}
+	GPR64 = arch.RegClass{Name: "gpr", Size: 64}
+)
+
+// Registers of the RISC-V architecture, named by their ABI names. The register
+// number is given by the value of the register; e.g. A0 is x10.
+const (
+	Zero ast.Reg = iota // hard-wired zero
+	RA                  // return address
+	SP                  // stack pointer
+	GP                  // global pointer
+	TP                  // thread pointer
+	T0                  // temporaries
+	T1
+	T2
+	S0 // saved registers; s0 is also the frame pointer
+	S1
+	A0 // function arguments and return values
+	A1
+	A2 // function arguments
+	A3
+	A4
+	A5
+	A6
+	A7
+	S2 // saved registers
+	S3
+	S4
+	S5
+	S6
+	S7
+	S8
+	S9
+	S10
+	S11
+	T3 // temporaries
+	T4
+	T5
+	T6
+)
+
+// FP is the frame pointer; an alias of S0.
+const FP = S0
+
+// regNames maps from register to canonical register name.
+var regNames = [...]string{
+	"zero", "ra", "sp", "gp", "tp", "t0", "t1", "t2",
+	"s0", "s1", "a0", "a1", "a2", "a3", "a4", "a5",
+	"a6", "a7", "s2", "s3", "s4", "s5", "s6", "s7",
+	"s8", "s9", "s10", "s11", "t3", "t4", "t5", "t6",
+}
+
+// regs maps from register name, including aliases, to register.
+var regs = make(map[string]ast.Reg)
+
+func init() {
+	for i, name := range regNames {
+		regs[name] = ast.Reg(i)
+		regs[fmt.Sprintf("x%d", i)] = ast.Reg(i)
+	}
+	regs["fp"] = FP
+}
+
+// Operation tables of the base integer instruction sets.
+var (
+	ops32 = newOpTable(32)
+	ops64 = newOpTable(64)
+)
+
+// newOpTable returns the operation table of the given base integer instruction
+// set. Branch and jump targets are immediate operands; usually labels.
+func newOpTable(xlen int) arch.OpTable {
+	r := arch.Reg(GPR)
+	if xlen == 64 {
+		r = arch.Reg(GPR64)
+	}
+	i, m := arch.Imm, arch.Mem
+
+	ops := arch.OpTable{
+		// Integer computational instructions.
+		"lui":   {{r, i}},
+		"auipc": {{r, i}},
+		// Control transfer instructions.
+		"jal":  {{r, i}, {i}},
+		"jalr": {{r}, {r, m}, {r, r, i}},
+		// Memory ordering instructions.
+		"fence":   {{}, {i, i}},
+		"fence.i": {{}},
+		// Environment call and breakpoints.
+		"ecall":  {{}},
+		"ebreak": {{}},
+		// Control and status register instructions.
+		"csrrw":  {{r, i, r}},
+		"csrrs":  {{r, i, r}},
+		"csrrc":  {{r, i, r}},
+		"csrrwi": {{r, i, i}},
+		"csrrsi": {{r, i, i}},
+		"csrrci": {{r, i, i}},
+		// Pseudo-instructions.
+		"nop":  {{}},
+		"li":   {{r, i}},
+		"la":   {{r, i}},
+		"mv":   {{r, r}},
+		"not":  {{r, r}},
+		"neg":  {{r, r}},
+		"seqz": {{r, r}},
+		"snez": {{r, r}},
+		"sltz": {{r, r}},
+		"sgtz": {{r, r}},
+		"j":    {{i}},
+		"jr":   {{r}},
+		"ret":  {{}},
+		"call": {{i}},
+		"tail": {{i}},
+		"beqz": {{r, i}},
+		"bnez": {{r, i}},
+		"blez": {{r, i}},
+		"bgez": {{r, i}},
+		"bltz": {{r, i}},
+		"bgtz": {{r, i}},
+		"bgt":  {{r, r, i}},
+		"ble":  {{r, r, i}},
+		"bgtu": {{r, r, i}},
+		"bleu": {{r, r, i}},
+		// Compressed instructions.
+		"c.nop":      {{}},
+		"c.ebreak":   {{}},
+		"c.addi":     {{r, i}},
+		"c.li":       {{r, i}},
+		"c.lui":      {{r, i}},
+		"c.addi16sp": {{r, i}},
+		"c.addi4spn": {{r, r, i}},
+		"c.slli":     {{r, i}},
+		"c.srli":     {{r, i}},
+		"c.srai":     {{r, i}},
+		"c.andi":     {{r, i}},
+		"c.mv":       {{r, r}},
+		"c.add":      {{r, r}},
+		"c.sub":      {{r, r}},
+		"c.xor":      {{r, r}},
+		"c.or":       {{r, r}},
+		"c.and":      {{r, r}},
+		"c.j":        {{i}},
+		"c.jr":       {{r}},
+		"c.jalr":     {{r}},
+		"c.beqz":     {{r, i}},
+		"c.bnez":     {{r, i}},
+		"c.lw":       {{r, m}},
+		"c.sw":       {{r, m}},
+		"c.lwsp":     {{r, m}},
+		"c.swsp":     {{r, m}},
+	}
+
+	// add adds the given form to the named instructions.
+	add := func(form arch.Form, names ...string) {
+		for _, name := range names {
+			ops[name] = append(ops[name], form)
+		}
+	}
+	add(arch.Form{r, r, r}, "add", "sub", "sll", "slt", "sltu", "xor", "srl", "sra", "or", "and")
+	add(arch.Form{r, r, i}, "addi", "slti", "sltiu", "xori", "ori", "andi", "slli", "srli", "srai")
+	add(arch.Form{r, r, i}, "beq", "bne", "blt", "bge", "bltu", "bgeu")
+	add(arch.Form{r, m}, "lb", "lh", "lw", "lbu", "lhu", "sb", "sh", "sw")
+	add(arch.Form{r, r, r}, "mul", "mulh", "mulhsu", "mulhu", "div", "divu", "rem", "remu")
+	widths := []string{".w"}
+	if xlen == 64 {
+		add(arch.Form{r, r, r}, "addw", "subw", "sllw", "srlw", "sraw", "mulw", "divw", "divuw", "remw", "remuw")
+		add(arch.Form{r, r, i}, "addiw", "slliw", "srliw", "sraiw")
+		add(arch.Form{r, m}, "ld", "lwu", "sd", "c.ld", "c.sd", "c.ldsp", "c.sdsp")
+		add(arch.Form{r, r}, "negw", "sext.w", "c.addw", "c.subw")
+		add(arch.Form{r, i}, "c.addiw")
+		widths = append(widths, ".d")
+	} else {
+		add(arch.Form{i}, "c.jal")
+	}
+	// Atomic instructions, with optional acquire and release ordering.
+	for _, width := range widths {
+		for _, order := range []string{"", ".aq", ".rl", ".aqrl"} {
+			add(arch.Form{r, m}, "lr"+width+order)
+			add(arch.Form{r, r, m}, "sc"+width+order)
+			for _, name := range AMOs {
+				add(arch.Form{r, r, m}, name+width+order)
+			}
+		}
+	}
+	return ops
+}
+
+// AMOs holds the mnemonics of the atomic memory operations, without width and
+// ordering suffixes.
+var AMOs = []string{"amoswap", "amoadd", "amoxor", "amoand", "amoor", "amomin", "amomax", "amominu", "amomaxu"}
+
+// riscv implements the arch.Arch interface for RISC-V.
+type riscv struct {
+	// Width in bits of the general purpose registers; either 32 or 64.
+	xlen int
+}
+
+// Name returns the name of the architecture.
+func (a riscv) Name() string {
+	if a.xlen == 64 {
+		return "riscv64"
+	}
+	return "riscv32"
+}
+
+// ByteOrder returns the byte order of the architecture.
+func (riscv) ByteOrder() binary.ByteOrder {
+	return binary.LittleEndian
+}
+
+// WordSize returns the size in bytes of a machine word.
+func (a riscv) WordSize() int {
+	return a.xlen / 8
+}
+
+// Reg returns the register with the given name and true if present, and false
+// otherwise. Registers are given either by ABI name (e.g. a0) or by number
+// (e.g. x10).
+func (riscv) Reg(name string) (reg ast.Reg, ok bool) {
+	reg, ok = regs[name]
+	return reg, ok
+}
+
+// RegName returns the canonical name of the given register.
+func (riscv) RegName(reg ast.Reg) string {
+	if int(reg) < len(regNames) {
+		return regNames[reg]
+	}
+	return fmt.Sprintf("<unknown register: %d>", reg)
+}
+
+// RegClass returns the class of the given register.
+func (a riscv) RegClass(reg ast.Reg) arch.RegClass {
+	switch {
+	case int(reg) >= len(regNames):
+		return arch.RegClass{}
+	case a.xlen == 64:
+		return GPR64
+	}
+	return GPR
+}
+
+// OffsetMem returns true; memory operands are written using the offset(base)
+// syntax, such as 8(sp).
+func (riscv) OffsetMem() bool {
+	return true
+}
+
+// Mnemonics returns the sorted mnemonics of the instructions of the
+// architecture, including pseudo-instructions.
+func (a riscv) Mnemonics() []string {
+	return a.ops().Mnemonics()
+}
+
+// Validate returns an error if inst is not a valid instruction of the
+// architecture.
+func (a riscv) Validate(inst *ast.Inst) error {
+	return a.ops().Validate(a, inst)
+}
+
+// ops returns the operation table of the architecture.
+func (a riscv) ops() arch.OpTable {
+	if a.xlen == 64 {
+		return ops64
+	}
+	return ops32
+}
@@ -0,0 +1,500 @@
+// Package x86 describes the 32-bit and 64-bit x86 instruction set
+// architectures, using the Intel syntax of NASM. It registers the architectures
+// with the arch package under the names "x86" and "x86-64" respectively.
+package x86
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/ast"
+)
+
+// Architectures of the processor modes.
+var (
+	// Arch is the 32-bit x86 architecture.
+	Arch arch.Arch = x86{bits: 32}
+	// Arch64 is the 64-bit x86 architecture; also known as amd64.
+	Arch64 arch.Arch = x86{bits: 64}
+)
+
+func init() {
+	arch.Register(Arch)
+	arch.Register(Arch64)
+}
+
+// Register classes of the general purpose registers.
+var (
+	GPR8  = arch.RegClass{Name: "r8", Size: 8}
+	GPR16 = arch.RegClass{Name: "r16", Size: 16}
+	GPR32 = arch.RegClass{Name: "r32", Size: 32}
+	GPR64 = arch.RegClass{Name: "r64", Size: 64}
+)
+
+// Registers of the x86 architecture.
+const (
+	// 8-bit general purpose registers.
+	AL ast.Reg = iota
+	CL
+	DL
+	BL
+	AH
+	CH
+	DH
+	BH
+	// 16-bit general purpose registers.
+	AX
+	CX
+	DX
+	BX
+	SP
+	BP
+	SI
+	DI
+	// 32-bit general purpose registers.
+	EAX
+	ECX
+	EDX
+	EBX
+	ESP
+	EBP
+	ESI
+	EDI
+	// 8-bit general purpose registers of 64-bit mode.
+	SPL
+	BPL
+	SIL
+	DIL
+	R8B
+	R9B
+	R10B
+	R11B
+	R12B
+	R13B
+	R14B
+	R15B
+	// 16-bit general purpose registers of 64-bit mode.
+	R8W
+	R9W
+	R10W
+	R11W
+	R12W
+	R13W
+	R14W
+	R15W
+	// 32-bit general purpose registers of 64-bit mode.
+	R8D
+	R9D
+	R10D
+	R11D
+	R12D
+	R13D
+	R14D
+	R15D
+	// 64-bit general purpose registers.
+	RAX
+	RCX
+	RDX
+	RBX
+	RSP
+	RBP
+	RSI
+	RDI
+	R8
+	R9
+	R10
+	R11
+	R12
+	R13
+	R14
+	R15
+)
+
+// A regInfo describes a register.
+type regInfo struct {
+	// Canonical name of the register.
+	name string
+	// Class of the register.
+	class arch.RegClass
+	// Register number, as encoded in instructions; the fourth bit is encoded
+	// in the REX prefix.
+	num uint8
+}
+
+// regInfos maps from register to register description.
+var regInfos = [...]regInfo{
+	AL:   {"al", GPR8, 0},
+	CL:   {"cl", GPR8, 1},
+	DL:   {"dl", GPR8, 2},
+	BL:   {"bl", GPR8, 3},
+	AH:   {"ah", GPR8, 4},
+	CH:   {"ch", GPR8, 5},
+	DH:   {"dh", GPR8, 6},
+	BH:   {"bh", GPR8, 7},
+	AX:   {"ax", GPR16, 0},
+	CX:   {"cx", GPR16, 1},
+	DX:   {"dx", GPR16, 2},
+	BX:   {"bx", GPR16, 3},
+	SP:   {"sp", GPR16, 4},
+	BP:   {"bp", GPR16, 5},
+	SI:   {"si", GPR16, 6},
+	DI:   {"di", GPR16, 7},
+	EAX:  {"eax", GPR32, 0},
+	ECX:  {"ecx", GPR32, 1},
+	EDX:  {"edx", GPR32, 2},
+	EBX:  {"ebx", GPR32, 3},
+	ESP:  {"esp", GPR32, 4},
+	EBP:  {"ebp", GPR32, 5},
+	ESI:  {"esi", GPR32, 6},
+	EDI:  {"edi", GPR32, 7},
+	SPL:  {"spl", GPR8, 4},
+	BPL:  {"bpl", GPR8, 5},
+	SIL:  {"sil", GPR8, 6},
+	DIL:  {"dil", GPR8, 7},
+	R8B:  {"r8b", GPR8, 8},
+	R9B:  {"r9b", GPR8, 9},
+	R10B: {"r10b", GPR8, 10},
+	R11B: {"r11b", GPR8, 11},
+	R12B: {"r12b", GPR8, 12},
+	R13B: {"r13b", GPR8, 13},
+	R14B: {"r14b", GPR8, 14},
+	R15B: {"r15b", GPR8, 15},
+	R8W:  {"r8w", GPR16, 8},
+	R9W:  {"r9w", GPR16, 9},
+	R10W: {"r10w", GPR16, 10},
+	R11W: {"r11w", GPR16, 11},
+	R12W: {"r12w", GPR16, 12},
+	R13W: {"r13w", GPR16, 13},
+	R14W: {"r14w", GPR16, 14},
+	R15W: {"r15w", GPR16, 15},
+	R8D:  {"r8d", GPR32, 8},
+	R9D:  {"r9d", GPR32, 9},
+	R10D: {"r10d", GPR32, 10},
+	R11D: {"r11d", GPR32, 11},
+	R12D: {"r12d", GPR32, 12},
+	R13D: {"r13d", GPR32, 13},
+	R14D: {"r14d", GPR32, 14},
+	R15D: {"r15d", GPR32, 15},
+	RAX:  {"rax", GPR64, 0},
+	RCX:  {"rcx", GPR64, 1},
+	RDX:  {"rdx", GPR64, 2},
+	RBX:  {"rbx", GPR64, 3},
+	RSP:  {"rsp", GPR64, 4},
+	RBP:  {"rbp", GPR64, 5},
+	RSI:  {"rsi", GPR64, 6},
+	RDI:  {"rdi", GPR64, 7},
+	R8:   {"r8", GPR64, 8},
+	R9:   {"r9", GPR64, 9},
+	R10:  {"r10", GPR64, 10},
+	R11:  {"r11", GPR64, 11},
+	R12:  {"r12", GPR64, 12},
+	R13:  {"r13", GPR64, 13},
+	R14:  {"r14", GPR64, 14},
+	R15:  {"r15", GPR64, 15},
+}
+
+// regs maps from register name to register.
+var regs = make(map[string]ast.Reg)
+
+// gprs maps from register size and number to general purpose register. The
+// 8-bit registers numbered 4 through 7 are spl, bpl, sil and dil.
+var gprs = make(map[[2]int]ast.Reg)
+
+func init() {
+	for reg, info := range regInfos {
+		regs[info.name] = ast.Reg(reg)
+		if !IsHighByte(ast.Reg(reg)) {
+			gprs[[2]int{info.class.Size, int(info.num)}] = ast.Reg(reg)
+		}
+	}
+}
+
+// RegOf returns the general purpose register of the given size in bits and
+// register number, as encoded in instructions. The 8-bit registers numbered 4
+// through 7 are spl, bpl, sil and dil if rex is true, and ah, ch, dh and bh
+// otherwise.
+func RegOf(size int, num uint8, rex bool) ast.Reg {
+	if size == 8 && !rex && 4 <= num && num <= 7 {
+		return AH + ast.Reg(num-4)
+	}
+	return gprs[[2]int{size, int(num)}]
+}
+
+// Num returns the number of the given register, as encoded in instructions.
+// Register numbers above 7 require a REX prefix.
+func Num(reg ast.Reg) uint8 {
+	return regInfos[reg].num
+}
+
+// Is64 returns true if the given register is only available in 64-bit mode,
+// and false otherwise.
+func Is64(reg ast.Reg) bool {
+	return reg >= SPL
+}
+
+// NeedsREX returns true if the given register may only be encoded using a REX
+// prefix, and false otherwise.
+func NeedsREX(reg ast.Reg) bool {
+	return Is64(reg) && (regInfos[reg].class != GPR64 || Num(reg) >= 8)
+}
+
+// IsHighByte returns true if the given register is one of ah, ch, dh and bh,
+// which may not be encoded in instructions with a REX prefix.
+func IsHighByte(reg ast.Reg) bool {
+	return AH <= reg && reg <= BH
+}
+
+// Condition codes of the conditional instructions (e.g. jcc, setcc and cmovcc)
+// mapped to their encodings. Aliases share the same encoding.
+var Conds = map[string]uint8{
+	"o":   0x0,
+	"no":  0x1,
+	"b":   0x2,
+	"c":   0x2,
+	"nae": 0x2,
+	"ae":  0x3,
+	"nb":  0x3,
+	"nc":  0x3,
+	"e":   0x4,
+	"z":   0x4,
+	"ne":  0x5,
+	"nz":  0x5,
+	"be":  0x6,
+	"na":  0x6,
+	"a":   0x7,
+	"nbe": 0x7,
+	"s":   0x8,
+	"ns":  0x9,
+	"p":   0xA,
+	"pe":  0xA,
+	"np":  0xB,
+	"po":  0xB,
+	"l":   0xC,
+	"nge": 0xC,
+	"ge":  0xD,
+	"nl":  0xD,
+	"le":  0xE,
+	"ng":  0xE,
+	"g":   0xF,
+	"nle": 0xF,
+}
+
+// Operand shapes.
+var (
+	r8  = arch.Reg(GPR8)
+	r16 = arch.Reg(GPR16)
+	r32 = arch.Reg(GPR32)
+	r64 = arch.Reg(GPR64)
+	i   = arch.Imm
+	m   = arch.Mem
+	m8  = arch.SizedMem(8)
+	m16 = arch.SizedMem(16)
+)
+
+// Operation tables of the processor modes.
+var (
+	ops32 = newOpTable(32)
+	ops64 = newOpTable(64)
+)
+
+// newOpTable returns the operation table of the given processor mode. Branch
+// targets are immediate operands; usually labels.
+func newOpTable(bits int) arch.OpTable {
+	// General purpose registers, and those of the full operand sizes.
+	gprs := []arch.Shape{r8, r16, r32}
+	wide := []arch.Shape{r16, r32}
+	// Registers of the stack instructions, and of the indirect branch
+	// instructions.
+	stack, branch := []arch.Shape{r16, r32}, r32
+	if bits == 64 {
+		gprs = append(gprs, r64)
+		wide = append(wide, r64)
+		stack, branch = []arch.Shape{r16, r64}, r64
+	}
+
+	ops := arch.OpTable{
+		// Control transfer instructions.
+		"ret":    {{}, {i}},
+		"loop":   {{i}},
+		"loope":  {{i}},
+		"loopne": {{i}},
+		"jecxz":  {{i}},
+		"int":    {{i}},
+		// Miscellaneous instructions.
+		"nop":   {{}},
+		"hlt":   {{}},
+		"leave": {{}},
+		"cdq":   {{}},
+		"cwde":  {{}},
+		"clc":   {{}},
+		"stc":   {{}},
+		"cmc":   {{}},
+		"cld":   {{}},
+		"std":   {{}},
+		"cli":   {{}},
+		"sti":   {{}},
+		"int3":  {{}},
+	}
+	if bits == 64 {
+		ops["jrcxz"] = []arch.Form{{i}}
+		ops["syscall"] = []arch.Form{{}}
+		ops["cqo"] = []arch.Form{{}}
+		ops["cdqe"] = []arch.Form{{}}
+		ops["movsxd"] = []arch.Form{{r64, r32}, {r64, m}}
+	}
+
+	// add adds the given forms to the named instructions.
+	add := func(forms []arch.Form, names ...string) {
+		for _, name := range names {
+			ops[name] = append(ops[name], forms...)
+		}
+	}
+	for _, r := range gprs {
+		// Memory operands whose size is not given by a register operand require
+		// a size keyword; such as dword [eax].
+		mr := arch.SizedMem(r.Class.Size)
+		// Data transfer and arithmetic instructions.
+		add([]arch.Form{{r, r}, {r, m}, {m, r}, {r, i}, {mr, i}}, "mov", "add", "or", "adc", "sbb", "and", "sub", "xor", "cmp")
+		add([]arch.Form{{r, r}, {m, r}, {r, i}, {mr, i}}, "test")
+		// Shift and rotate instructions.
+		add([]arch.Form{{r, i}, {r, r8}, {mr, i}, {mr, r8}}, "rol", "ror", "rcl", "rcr", "shl", "sal", "shr", "sar")
+		// Unary arithmetic instructions.
+		add([]arch.Form{{r}, {mr}}, "inc", "dec", "neg", "not", "mul", "imul", "div", "idiv")
+	}
+	for _, r := range wide {
+		add([]arch.Form{{r, m}}, "lea")
+		add([]arch.Form{{r, r}, {r, m}, {r, r, i}, {r, m, i}}, "imul")
+		for cc := range Conds {
+			add([]arch.Form{{r, r}, {r, m}}, "cmov"+cc)
+		}
+		add([]arch.Form{{r, r8}, {r, m8}}, "movzx", "movsx")
+		if r != r16 {
+			add([]arch.Form{{r, r16}, {r, m16}}, "movzx", "movsx")
+		}
+	}
+	for _, r := range stack {
+		add([]arch.Form{{r}, {arch.SizedMem(r.Class.Size)}}, "push", "pop")
+	}
+	add([]arch.Form{{i}}, "push")
+	add([]arch.Form{{i}, {branch}, {m}}, "jmp", "call")
+	for cc := range Conds {
+		add([]arch.Form{{i}}, "j"+cc)
+		add([]arch.Form{{r8}, {m8}}, "set"+cc)
+	}
+	return ops
+}
+
+// x86 implements the arch.Arch interface for x86.
+type x86 struct {
+	// Processor mode; either 32 or 64.
+	bits int
+}
+
+// Name returns the name of the architecture.
+func (a x86) Name() string {
+	if a.bits == 64 {
+		return "x86-64"
+	}
+	return "x86"
+}
+
+// ByteOrder returns the byte order of the architecture.
+func (x86) ByteOrder() binary.ByteOrder {
+	return binary.LittleEndian
+}
+
+// WordSize returns the size in bytes of a machine word.
+func (a x86) WordSize() int {
+	return a.bits / 8
+}
+
+// Reg returns the register with the given name and true if present, and false
+// otherwise. Register names are case-insensitive.
+func (a x86) Reg(name string) (reg ast.Reg, ok bool) {
+	reg, ok = regs[strings.ToLower(name)]
+	if ok && Is64(reg) && a.bits != 64 {
+		return 0, false
+	}
+	return reg, ok
+}
+
+// RegName returns the canonical name of the given register.
+func (x86) RegName(reg ast.Reg) string {
+	if int(reg) < len(regInfos) {
+		return regInfos[reg].name
+	}
+	return fmt.Sprintf("<unknown register: %d>", reg)
+}
+
+// RegClass returns the class of the given register.
+func (x86) RegClass(reg ast.Reg) arch.RegClass {
+	if int(reg) < len(regInfos) {
+		return regInfos[reg].class
+	}
+	return arch.RegClass{}
+}
+
+// Mnemonics returns the sorted mnemonics of the instructions of the
+// architecture.
+func (a x86) Mnemonics() []string {
+	return a.ops().Mnemonics()
+}
+
+// Validate returns an error if inst is not a valid instruction of the
+// architecture.
+func (a x86) Validate(inst *ast.Inst) error {
+	if err := a.ops().Validate(a, inst); err != nil {
+		return err
+	}
+	return a.checkMemSize(inst)
+}
+
+// mixedSizes specifies the instructions whose register and memory operands may
+// be of different sizes.
+var mixedSizes = map[string]bool{
+	"lea":    true,
+	"movzx":  true,
+	"movsx":  true,
+	"movsxd": true,
+	"rol":    true,
+	"ror":    true,
+	"rcl":    true,
+	"rcr":    true,
+	"shl":    true,
+	"sal":    true,
+	"shr":    true,
+	"sar":    true,
+}
+
+// checkMemSize returns an error if the size keyword of the memory operand of
+// inst specifies a size other than the size of its register operand; such as
+// mov eax, byte [ebx].
+func (a x86) checkMemSize(inst *ast.Inst) error {
+	if mixedSizes[string(inst.Op)] {
+		return nil
+	}
+	size := 0
+	var mem *ast.Mem
+	for _, arg := range inst.Args {
+		switch arg := arg.(type) {
+		case ast.Reg:
+			size = a.RegClass(arg).Size
+		case *ast.Mem:
+			mem = arg
+		}
+	}
+	if mem != nil && mem.Size != 0 && size != 0 && mem.Size != size {
+		return fmt.Errorf("x86.checkMemSize: mismatched operand sizes of %q; %d-bit register and %d-bit memory operand", inst.Op, size, mem.Size)
+	}
+	return nil
+}
+
+// ops returns the operation table of the architecture.
+func (a x86) ops() arch.OpTable {
+	if a.bits == 64 {
+		return ops64
+	}
+	return ops32
+}
@@ -0,0 +1,763 @@
+// Package arm implements an ARMv7-M instruction encoder for the Thumb-2
+// instruction set.
+//
+// Instructions are encoded as one 16-bit halfword or as two 16-bit halfwords,
+// the first of which holds the most significant bits of the 32-bit encoding.
+// Like LLVM, the encoder selects a 16-bit encoding whenever one exists, unless
+// the .w width qualifier is given or the instruction has grown to 32 bits in a
+// previous pass of the assembler. The 16-bit data processing instructions
+// update the condition flags outside of IT blocks but not inside of them, so
+// that e.g. adds r0, r1 and addeq r0, r1 have 16-bit encodings while add r0, r1,
+// r2 is encoded using 32 bits.
+//
+// Conditional instructions, except for branches, must be located in an IT
+// block whose condition of the instruction matches its condition suffix; such
+// as
+//
+//	ite eq
+//	moveq r0, #1
+//	movne r0, #0
+//
+// The targets of bl, and of unconditional b, may refer to addresses assigned at
+// link time; which are reported as relocation records. Such branches are
+// encoded using 32 bits.
+package arm
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"github.com/mewlang/asm/arch"
+	armarch "github.com/mewlang/asm/arch/arm"
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/symtab"
+)
+
+// An Encoder encodes ARMv7-M instructions into Thumb-2 machine code. The
+// encoder keeps track of the IT block of the instructions being encoded, and
+// may therefore not be used concurrently. The zero value is ready to use.
+type Encoder struct {
+	// Conditions of the remaining instructions of the current IT block.
+	it []armarch.Cond
+	// Address of the next instruction of the current IT block.
+	itPC int64
+}
+
+// Arch returns the ARMv7-M architecture.
+func (enc *Encoder) Arch() arch.Arch {
+	return armarch.Arch
+}
+
+// Encode returns the little-endian machine code of inst, which is located at
+// address pc. Branch targets and literal loads are resolved using the provided
+// symbol table. The current IT block ends when an instruction is encoded at
+// another address than the one following the previous instruction; e.g. at the
+// start of a new pass of the assembler. On error, instructions whose branch
+// target or address relative to the program counter is out of range are
+// returned as placeholders.
+func (enc *Encoder) Encode(inst *ast.Inst, pc int64, syms ast.SymbolTable) ([]byte, error) {
+	if len(enc.it) > 0 && pc != enc.itPC {
+		enc.it = nil
+	}
+	e := &encoding{inst: inst, pc: pc, syms: syms, cond: armarch.AL}
+	if len(enc.it) > 0 {
+		e.inIT, e.cond = true, enc.it[0]
+		enc.it = enc.it[1:]
+		e.lastInIT = len(enc.it) == 0
+	}
+	err := e.encode()
+	enc.itPC = pc + int64(len(e.buf))
+	if err != nil {
+		return e.buf, err
+	}
+	if e.it != nil {
+		enc.it = e.it
+	}
+	return e.buf, nil
+}
+
+// An encoding keeps track of the state of the encoding of an instruction.
+type encoding struct {
+	// Instruction to encode.
+	inst *ast.Inst
+	// Split mnemonic of the instruction.
+	m armarch.Mnemonic
+	// Address of the instruction.
+	pc int64
+	// Symbol table used to resolve branch targets and literal loads.
+	syms ast.SymbolTable
+	// inIT specifies that the instruction is located in an IT block, whose
+	// condition of the instruction is cond; and lastInIT that it is the last
+	// instruction of the IT block.
+	inIT, lastInIT bool
+	cond           armarch.Cond
+	// Conditions of the instructions of the IT block started by an if-then
+	// instruction.
+	it []armarch.Cond
+	// Machine code of the instruction.
+	buf []byte
+}
+
+// encode encodes the instruction.
+func (e *encoding) encode() error {
+	if err := armarch.Arch.Validate(e.inst); err != nil {
+		return err
+	}
+	e.m, _ = armarch.Split(e.inst.Op)
+	switch {
+	case e.inIT && (strings.HasPrefix(e.m.Base, "it") || e.m.Base == "cbz" || e.m.Base == "cbnz"):
+		return fmt.Errorf("arm.encoding.encode: %q in IT block", e.inst.Op)
+	case e.inIT && e.m.Cond != e.cond:
+		return fmt.Errorf("arm.encoding.encode: condition of %q does not match condition %v of IT block", e.inst.Op, e.cond)
+	case !e.inIT && e.m.Cond != armarch.AL && e.m.Base != "b":
+		return fmt.Errorf("arm.encoding.encode: conditional instruction %q outside of IT block", e.inst.Op)
+	}
+	if err := e.encodeBase(); err != nil {
+		return err
+	}
+	if e.m.Width == ".n" && len(e.buf) != 2 {
+		return fmt.Errorf("arm.encoding.encode: no 16-bit encoding of %q with the given operands", e.inst.Op)
+	}
+	return nil
+}
+
+// encodeBase encodes the instruction based on its base mnemonic.
+func (e *encoding) encodeBase() error {
+	base, args := e.m.Base, e.inst.Args
+	if _, ok := dpOps[base]; ok {
+		return e.dataProc()
+	}
+	if typ, ok := shiftTypes[base]; ok {
+		return e.shiftInst(typ)
+	}
+	if op, ok := loadStoreOps[base]; ok {
+		return e.loadStore(op)
+	}
+	if hw, ok := hintOps[base]; ok {
+		if e.narrow() {
+			e.emit16(0xBF00 | hw<<4)
+		} else {
+			e.emit32(0xF3AF, 0x8000|hw)
+		}
+		return nil
+	}
+	if op, ok := multiplyOps[base]; ok {
+		if base == "mla" || base == "mls" {
+			// mla rd, rn, rm, ra
+			e.emit32(op[0]|reg(args[1]), op[1]|reg(args[3])<<12|reg(args[0])<<8|reg(args[2]))
+			return nil
+		}
+		// smull rdlo, rdhi, rn, rm
+		e.emit32(op[0]|reg(args[2]), op[1]|reg(args[0])<<12|reg(args[1])<<8|reg(args[3]))
+		return nil
+	}
+	if op, ok := miscOps[base]; ok {
+		rd, rm := reg(args[0]), reg(args[1])
+		if op[0] != 0 && e.narrow() && low(rd) && low(rm) {
+			e.emit16(op[0] | rm<<3 | rd)
+			return nil
+		}
+		e.emit32(op[1]|rm, op[2]|rd<<8|rm)
+		return nil
+	}
+	if strings.HasPrefix(base, "it") {
+		return e.ifThen()
+	}
+	switch base {
+	case "mov", "mvn":
+		return e.move()
+	case "cmp", "cmn", "tst", "teq":
+		return e.compare()
+	case "addw", "subw":
+		rd, rn, x := reg(args[0]), reg(args[0]), args[1]
+		if len(args) == 3 {
+			rn, x = reg(args[1]), args[2]
+		}
+		imm, err := e.imm(x, 0, 0xFFF)
+		if err != nil {
+			return err
+		}
+		op := uint32(0xF200)
+		if base == "subw" {
+			op = 0xF2A0
+		}
+		e.plainImm(op, rn, rd, uint32(imm))
+		return nil
+	case "movw", "movt":
+		val, err := ast.EvalWidth(args[1], e.syms, 16)
+		if err != nil {
+			return err
+		}
+		imm := uint32(val) & 0xFFFF
+		op := uint32(0xF240)
+		if base == "movt" {
+			op = 0xF2C0
+		}
+		e.plainImm(op, imm>>12, reg(args[0]), imm&0xFFF)
+		return nil
+	case "rrx":
+		e.dpReg(opORR, e.s(), 15, reg(args[0]), reg(args[1]), shift{typ: 3})
+		return nil
+	case "neg":
+		rd, rm := reg(args[0]), reg(args[1])
+		if e.narrowFlags() && low(rd) && low(rm) {
+			e.emit16(0x4240 | rm<<3 | rd)
+			return nil
+		}
+		e.dpImm(opRSB, e.s(), rm, rd, 0)
+		return nil
+	case "mul":
+		return e.multiply()
+	case "sdiv", "udiv":
+		rd, rn, rm := reg(args[0]), reg(args[0]), reg(args[1])
+		if len(args) == 3 {
+			rn, rm = reg(args[1]), reg(args[2])
+		}
+		op := uint32(0xFB90)
+		if base == "udiv" {
+			op = 0xFBB0
+		}
+		e.emit32(op|rn, 0xF0F0|rd<<8|rm)
+		return nil
+	case "sxtb", "sxth", "uxtb", "uxth":
+		return e.extend()
+	case "sbfx", "ubfx", "bfi", "bfc":
+		return e.bitfield()
+	case "adr":
+		return e.adr()
+	case "ldrd", "strd":
+		return e.loadStoreDual()
+	case "ldrex", "ldrexb", "ldrexh", "strex", "strexb", "strexh":
+		return e.exclusive()
+	case "clrex":
+		e.emit32(0xF3BF, 0x8F2F)
+		return nil
+	case "ldm", "ldmia", "ldmfd", "ldmdb", "ldmea", "stm", "stmia", "stmea", "stmdb", "stmfd":
+		return e.loadStoreMultiple()
+	case "push", "pop":
+		return e.pushPop()
+	case "b", "bl":
+		return e.branch()
+	case "bx", "blx":
+		if err := e.checkLast(); err != nil {
+			return err
+		}
+		op := uint32(0x4700)
+		if base == "blx" {
+			op = 0x4780
+		}
+		e.emit16(op | reg(args[0])<<3)
+		return nil
+	case "cbz", "cbnz":
+		return e.compareBranch()
+	case "tbb", "tbh":
+		return e.tableBranch()
+	case "bkpt", "svc", "udf":
+		return e.exception()
+	case "cpsid", "cpsie":
+		return e.cps()
+	case "dmb", "dsb", "isb":
+		return e.barrier()
+	case "mrs":
+		e.emit32(0xF3EF, 0x8000|reg(args[0])<<8|sysm(args[1]))
+		return nil
+	case "msr":
+		// The mask selects the N, Z, C, V and Q flags of the APSR.
+		e.emit32(0xF380|reg(args[1]), 0x8800|sysm(args[0]))
+		return nil
+	}
+	return fmt.Errorf("arm.Encoder.Encode: support for instruction %q not yet implemented", e.inst.Op)
+}
+
+// hintOps maps from hint mnemonic to the hint number of its encodings.
+var hintOps = map[string]uint32{
+	"nop":   0,
+	"yield": 1,
+	"wfe":   2,
+	"wfi":   3,
+	"sev":   4,
+}
+
+// multiplyOps maps from multiply mnemonic to the first and second halfword of
+// its encoding, without register fields.
+var multiplyOps = map[string][2]uint32{
+	"mla":   {0xFB00, 0x0000},
+	"mls":   {0xFB00, 0x0010},
+	"smull": {0xFB80, 0x0000},
+	"umull": {0xFBA0, 0x0000},
+	"smlal": {0xFBC0, 0x0000},
+	"umlal": {0xFBE0, 0x0000},
+}
+
+// miscOps maps from mnemonic of miscellaneous two register instructions to
+// their 16-bit encoding (or 0 if not present) and the first and second halfword
+// of their 32-bit encoding, without register fields.
+var miscOps = map[string][3]uint32{
+	"clz":   {0, 0xFAB0, 0xF080},
+	"rbit":  {0, 0xFA90, 0xF0A0},
+	"rev":   {0xBA00, 0xFA90, 0xF080},
+	"rev16": {0xBA40, 0xFA90, 0xF090},
+	"revsh": {0xBAC0, 0xFA90, 0xF0B0},
+}
+
+// multiply encodes a mul instruction. The 16-bit encoding requires the
+// destination register to be one of the source registers.
+func (e *encoding) multiply() error {
+	args := e.inst.Args
+	rd, rn, rm := reg(args[0]), reg(args[0]), reg(args[1])
+	if len(args) == 3 {
+		rn, rm = reg(args[1]), reg(args[2])
+	}
+	if rd == rn {
+		rn, rm = rm, rn
+	}
+	if e.narrowFlags() && low(rd) && low(rn) && rd == rm {
+		e.emit16(0x4340 | rn<<3 | rd)
+		return nil
+	}
+	if e.m.S {
+		return fmt.Errorf("arm.encoding.multiply: no encoding of %q with the given operands; expected low registers and destination register as source", e.inst.Op)
+	}
+	e.emit32(0xFB00|rn, 0xF000|rd<<8|rm)
+	return nil
+}
+
+// extend encodes a sign or zero extension instruction, whose source register
+// may be rotated by 8, 16 or 24 bits.
+func (e *encoding) extend() error {
+	args := e.inst.Args
+	rd := reg(args[0])
+	rm, rot := uint32(0), uint32(0)
+	switch x := args[1].(type) {
+	case ast.Reg:
+		rm = uint32(x)
+	case *ast.ShiftedReg:
+		rm = reg(x.X)
+		if x.Op != "ror" || x.Amount == nil {
+			return fmt.Errorf("arm.encoding.extend: invalid shift %q of %q; expected ror", x.Op, e.inst.Op)
+		}
+		amount, err := ast.Eval(x.Amount, e.syms)
+		if err != nil {
+			return err
+		}
+		if amount != 0 && amount != 8 && amount != 16 && amount != 24 {
+			return fmt.Errorf("arm.encoding.extend: invalid rotation %d of %q; expected 0, 8, 16 or 24", amount, e.inst.Op)
+		}
+		rot = uint32(amount) / 8
+	}
+	ops := map[string][2]uint32{
+		"sxth": {0xB200, 0xFA0F},
+		"sxtb": {0xB240, 0xFA4F},
+		"uxth": {0xB280, 0xFA1F},
+		"uxtb": {0xB2C0, 0xFA5F},
+	}
+	op := ops[e.m.Base]
+	if e.narrow() && rot == 0 && low(rd) && low(rm) {
+		e.emit16(op[0] | rm<<3 | rd)
+		return nil
+	}
+	e.emit32(op[1], 0xF080|rd<<8|rot<<4|rm)
+	return nil
+}
+
+// bitfield encodes a bit field instruction, whose operands specify the least
+// significant bit and the width of the bit field.
+func (e *encoding) bitfield() error {
+	args := e.inst.Args
+	rd, rn, rest := reg(args[0]), uint32(15), args[1:]
+	if e.m.Base != "bfc" {
+		rn, rest = reg(args[1]), args[2:]
+	}
+	lsb, err := e.imm(rest[0], 0, 31)
+	if err != nil {
+		return err
+	}
+	width, err := e.imm(rest[1], 1, 32-lsb)
+	if err != nil {
+		return err
+	}
+	ops := map[string]uint32{"sbfx": 0xF340, "ubfx": 0xF3C0, "bfi": 0xF360, "bfc": 0xF360}
+	// The extract instructions encode the width minus one, and the insert
+	// instructions the most significant bit.
+	last := uint32(width - 1)
+	if e.m.Base == "bfi" || e.m.Base == "bfc" {
+		last = uint32(lsb + width - 1)
+	}
+	e.emit32(ops[e.m.Base]|rn, uint32(lsb>>2)<<12|rd<<8|uint32(lsb&3)<<6|last)
+	return nil
+}
+
+// ifThen encodes an if-then instruction, which starts an IT block of up to four
+// instructions.
+func (e *encoding) ifThen() error {
+	name, _ := e.inst.Args[0].(ast.Ident)
+	first, ok := armarch.ParseCond(string(name))
+	if !ok {
+		return fmt.Errorf("arm.encoding.ifThen: invalid condition %v of %q", e.inst.Args[0], e.inst.Op)
+	}
+	letters := strings.TrimPrefix(e.m.Base, "it")
+	e.it = []armarch.Cond{first}
+	var mask uint32
+	for i, c := range letters {
+		cond, bit := first, uint32(first&1)
+		if c == 'e' {
+			if first == armarch.AL {
+				return fmt.Errorf("arm.encoding.ifThen: invalid else condition of %q; condition al has no inverse", e.inst.Op)
+			}
+			cond, bit = first.Invert(), bit^1
+		}
+		mask |= bit << uint(3-i)
+		e.it = append(e.it, cond)
+	}
+	mask |= 1 << uint(3-len(letters))
+	e.emit16(0xBF00 | uint32(first)<<4 | mask)
+	return nil
+}
+
+// checkLast returns an error if the instruction is located in an IT block,
+// without being the last instruction of the block.
+func (e *encoding) checkLast() error {
+	if e.inIT && !e.lastInIT {
+		return fmt.Errorf("arm.encoding.checkLast: %q must be the last instruction of IT block", e.inst.Op)
+	}
+	return nil
+}
+
+// branch encodes a b or bl instruction. Conditional branches outside of IT
+// blocks use encodings with a condition field.
+func (e *encoding) branch() error {
+	if err := e.checkLast(); err != nil {
+		return err
+	}
+	target := e.inst.Args[0]
+	off, err := e.offset(target)
+	if err != nil {
+		return err
+	}
+	if e.m.Base == "bl" {
+		return e.branch24Reloc(0xD000, symtab.ThumbCall, target, off)
+	}
+	if e.m.Cond != armarch.AL && !e.inIT {
+		cond := uint32(e.m.Cond)
+		if e.narrow() && fits(off, 9) {
+			e.emit16(0xD000 | cond<<8 | uint32(off>>1)&0xFF)
+			return nil
+		}
+		if err := e.checkRange(off, 21); err != nil {
+			return err
+		}
+		// imm32 = SignExtend(S:J2:J1:imm6:imm11:'0')
+		u := uint32(off)
+		s, j2, j1 := u>>20&1, u>>19&1, u>>18&1
+		e.emit32(0xF000|s<<10|cond<<6|u>>12&0x3F, 0x8000|j1<<13|j2<<11|u>>1&0x7FF)
+		return nil
+	}
+	if e.narrow() && fits(off, 12) && !asm.Relocated(e.syms, target, true) {
+		e.emit16(0xE000 | uint32(off>>1)&0x7FF)
+		return nil
+	}
+	return e.branch24Reloc(0x9000, symtab.ThumbJump24, target, off)
+}
+
+// branch24Reloc emits an unconditional b.w or bl instruction with the given
+// offset to target, as of branch24. Targets whose address is assigned at link
+// time are reported as relocation records of the given type.
+func (e *encoding) branch24Reloc(op uint32, typ symtab.RelocType, target ast.Arg, off int64) error {
+	// The offset is relative to the address of the instruction plus 4.
+	ok, err := asm.Reloc(e.syms, symtab.Reloc{Offset: int64(len(e.buf)), Type: typ, Size: 4, Addend: -4, PCRel: true}, target)
+	if err != nil {
+		return err
+	}
+	if ok {
+		off = 0
+	} else if err := e.checkRange(off, 25); err != nil {
+		return err
+	}
+	e.branch24(op, off)
+	return nil
+}
+
+// branch24 emits an unconditional b.w or bl instruction, as given by the
+// second halfword without offset fields, with the given offset.
+func (e *encoding) branch24(op uint32, off int64) {
+	// imm32 = SignExtend(S:I1:I2:imm10:imm11:'0'), where I1 = NOT(J1 XOR S)
+	// and I2 = NOT(J2 XOR S).
+	u := uint32(off)
+	s, i1, i2 := u>>24&1, u>>23&1, u>>22&1
+	j1, j2 := ^(i1^s)&1, ^(i2^s)&1
+	e.emit32(0xF000|s<<10|u>>12&0x3FF, op|j1<<13|j2<<11|u>>1&0x7FF)
+}
+
+// compareBranch encodes a cbz or cbnz instruction, which may only branch
+// forward.
+func (e *encoding) compareBranch() error {
+	rn := reg(e.inst.Args[0])
+	if !low(rn) {
+		return fmt.Errorf("arm.encoding.compareBranch: invalid register %s of %q; expected r0-r7", armarch.Arch.RegName(ast.Reg(rn)), e.inst.Op)
+	}
+	off, err := e.offset(e.inst.Args[1])
+	if err != nil {
+		return err
+	}
+	if off < 0 || off > 126 {
+		// Forward branch targets provisionally resolve to the address of the
+		// instruction before the final pass of the assembler.
+		e.emit16(0)
+		return fmt.Errorf("arm.encoding.compareBranch: branch target out of range; offset %d not in range [0, 126]", off)
+	}
+	op := uint32(0xB100)
+	if e.m.Base == "cbnz" {
+		op = 0xB900
+	}
+	u := uint32(off)
+	e.emit16(op | u>>6<<9 | u>>1&0x1F<<3 | rn)
+	return nil
+}
+
+// tableBranch encodes a tbb or tbh instruction.
+func (e *encoding) tableBranch() error {
+	if err := e.checkLast(); err != nil {
+		return err
+	}
+	mem := e.inst.Args[0].(*ast.Mem)
+	rn, rnOK := mem.Base.(ast.Reg)
+	rm, rmOK := mem.Index.(ast.Reg)
+	scale := 1
+	op := uint32(0xF000)
+	if e.m.Base == "tbh" {
+		scale, op = 2, 0xF010
+	}
+	if !rnOK || !rmOK || mem.Scale != scale || (mem.Extend != "" && mem.Extend != "lsl") || mem.Disp != nil || mem.Rel {
+		if scale == 2 {
+			return fmt.Errorf("arm.encoding.tableBranch: invalid memory operand of %q; expected [rn, rm, lsl #1]", e.inst.Op)
+		}
+		return fmt.Errorf("arm.encoding.tableBranch: invalid memory operand of %q; expected [rn, rm]", e.inst.Op)
+	}
+	e.emit32(0xE8D0|uint32(rn), op|uint32(rm))
+	return nil
+}
+
+// exception encodes a bkpt, svc or udf instruction.
+func (e *encoding) exception() error {
+	args := e.inst.Args
+	switch e.m.Base {
+	case "bkpt":
+		var imm int64
+		if len(args) == 1 {
+			var err error
+			if imm, err = e.imm(args[0], 0, 0xFF); err != nil {
+				return err
+			}
+		}
+		e.emit16(0xBE00 | uint32(imm))
+	case "svc":
+		imm, err := e.imm(args[0], 0, 0xFF)
+		if err != nil {
+			return err
+		}
+		e.emit16(0xDF00 | uint32(imm))
+	case "udf":
+		imm, err := e.imm(args[0], 0, 0xFFFF)
+		if err != nil {
+			return err
+		}
+		if e.narrow() && imm <= 0xFF {
+			e.emit16(0xDE00 | uint32(imm))
+			return nil
+		}
+		u := uint32(imm)
+		e.emit32(0xF7F0|u>>12, 0xA000|u&0xFFF)
+	}
+	return nil
+}
+
+// cps encodes a cpsid or cpsie instruction, whose operand specifies the
+// interrupt masks to set or clear; i for PRIMASK, f for FAULTMASK or both.
+func (e *encoding) cps() error {
+	name, _ := e.inst.Args[0].(ast.Ident)
+	var flags uint32
+	switch name {
+	case "i":
+		flags = 2
+	case "f":
+		flags = 1
+	case "if":
+		flags = 3
+	default:
+		return fmt.Errorf("arm.encoding.cps: invalid interrupt mask %v of %q; expected i, f or if", e.inst.Args[0], e.inst.Op)
+	}
+	op := uint32(0xB660)
+	if e.m.Base == "cpsid" {
+		op = 0xB670
+	}
+	e.emit16(op | flags)
+	return nil
+}
+
+// barrierOpts maps from barrier option name to barrier option.
+var barrierOpts = map[string]uint32{
+	"sy":    15,
+	"st":    14,
+	"ish":   11,
+	"ishst": 10,
+	"nsh":   7,
+	"nshst": 6,
+	"osh":   3,
+	"oshst": 2,
+}
+
+// barrier encodes a dmb, dsb or isb instruction, whose optional operand
+// specifies the barrier option; sy by default.
+func (e *encoding) barrier() error {
+	opt := uint32(15)
+	if args := e.inst.Args; len(args) == 1 {
+		if name, ok := args[0].(ast.Ident); ok {
+			o, ok := barrierOpts[string(name)]
+			if !ok || (e.m.Base == "isb" && o != 15) {
+				return fmt.Errorf("arm.encoding.barrier: invalid barrier option %q of %q", name, e.inst.Op)
+			}
+			opt = o
+		} else {
+			imm, err := e.imm(args[0], 0, 15)
+			if err != nil {
+				return err
+			}
+			opt = uint32(imm)
+		}
+	}
+	ops := map[string]uint32{"dsb": 0x8F40, "dmb": 0x8F50, "isb": 0x8F60}
+	e.emit32(0xF3BF, ops[e.m.Base]|opt)
+	return nil
+}
+
+// sysm returns the SYSm field of the given special register.
+func sysm(x ast.Arg) uint32 {
+	return uint32(x.(ast.Reg) - armarch.APSR)
+}
+
+// offset returns the offset of the given branch target, relative to the
+// address of the instruction plus 4.
+func (e *encoding) offset(target ast.Arg) (int64, error) {
+	addr, err := ast.Eval(target, e.syms)
+	if err != nil {
+		return 0, err
+	}
+	if addr&1 != 0 {
+		return 0, fmt.Errorf("arm.encoding.offset: unaligned branch target 0x%X", addr)
+	}
+	return addr - (e.pc + 4), nil
+}
+
+// checkRange returns an error if the given branch offset does not fit in a
+// sign-extended immediate of the given bit width. A 32-bit placeholder is
+// emitted on error, to keep the layout of the program stable between passes of
+// the assembler.
+func (e *encoding) checkRange(off int64, width uint) error {
+	if !fits(off, width) {
+		e.emit32(0, 0)
+		min, max := int64(-1)<<(width-1), int64(1)<<(width-1)-2
+		return fmt.Errorf("arm.encoding.checkRange: branch target out of range; offset %d not in range [%d, %d]", off, min, max)
+	}
+	return nil
+}
+
+// fits returns true if val fits in a sign-extended immediate of the given bit
+// width, and false otherwise.
+func fits(val int64, width uint) bool {
+	return val>>(width-1) == 0 || val>>(width-1) == -1
+}
+
+// imm evaluates x and returns its value, which must be in the given range.
+func (e *encoding) imm(x ast.Arg, min, max int64) (int64, error) {
+	val, err := ast.Eval(x, e.syms)
+	if err != nil {
+		return 0, err
+	}
+	if val < min || val > max {
+		return 0, fmt.Errorf("arm.encoding.imm: immediate %d of %q out of range [%d, %d]", val, e.inst.Op, min, max)
+	}
+	return val, nil
+}
+
+// thumbImm returns the 12-bit encoding i:imm3:imm8 of val as a modified
+// immediate constant, and true if val may be encoded as such. A modified
+// immediate constant is either an 8-bit value replicated in the pattern
+// 0x000000XY, 0x00XY00XY, 0xXY00XY00 or 0xXYXYXYXY, or an 8-bit value with
+// the most significant bit set rotated right by 8 to 31 bits.
+func thumbImm(val uint32) (uint32, bool) {
+	b := val & 0xFF
+	switch {
+	case val == b:
+		return b, true
+	case val == b<<16|b:
+		return 1<<8 | b, true
+	case val == (val>>8&0xFF)*0x01000100:
+		return 2<<8 | val>>8&0xFF, true
+	case val == b*0x01010101:
+		return 3<<8 | b, true
+	}
+	for rot := 8; rot < 32; rot++ {
+		if x := bits.RotateLeft32(val, rot); x&^0x7F == 0x80 {
+			return uint32(rot)<<7 | x&0x7F, true
+		}
+	}
+	return 0, false
+}
+
+// plainImm emits a 32-bit data processing instruction with a plain 12-bit
+// immediate i:imm3:imm8, as given by the first halfword without register and
+// immediate fields.
+func (e *encoding) plainImm(op, rn, rd, imm uint32) {
+	e.emit32(op|imm>>11<<10|rn, imm>>8&7<<12|rd<<8|imm&0xFF)
+}
+
+// reg returns the register number of the register operand x.
+func reg(x ast.Arg) uint32 {
+	return uint32(x.(ast.Reg))
+}
+
+// low returns true if r is one of the low registers r0-r7, and false otherwise.
+func low(r uint32) bool {
+	return r < 8
+}
+
+// narrow returns true if a 16-bit encoding may be selected; i.e. unless the .w
+// width qualifier is given, or the instruction was encoded using 32 bits in a
+// previous pass of the assembler.
+func (e *encoding) narrow() bool {
+	switch e.m.Width {
+	case ".n":
+		return true
+	case ".w":
+		return false
+	}
+	return asm.MinSize(e.syms) < 4
+}
+
+// narrowFlags returns true if a 16-bit data processing encoding may be
+// selected; which updates the condition flags outside of IT blocks, and does
+// not update them inside of IT blocks.
+func (e *encoding) narrowFlags() bool {
+	return e.narrow() && e.m.S != e.inIT
+}
+
+// s returns the S bit of the instruction, which specifies whether the condition
+// flags are updated.
+func (e *encoding) s() uint32 {
+	if e.m.S {
+		return 1
+	}
+	return 0
+}
+
+// emit16 emits the given 16-bit instruction.
+func (e *encoding) emit16(hw uint32) {
+	e.buf = append(e.buf, byte(hw), byte(hw>>8))
+}
+
+// emit32 emits the 32-bit instruction of the given halfwords.
+func (e *encoding) emit32(hw1, hw2 uint32) {
+	e.buf = append(e.buf, byte(hw1), byte(hw1>>8), byte(hw2), byte(hw2>>8))
+}
@@ -0,0 +1,550 @@
+// The expected encodings of the test cases in this file have been verified using
+// llvm-mc.
+
+package arm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/mewlang/asm/internal/asmtest"
+)
+
+// checkEncode assembles the input of each test case and compares it against the
+// expected little-endian machine code.
+func checkEncode(t *testing.T, golden []struct{ in, want string }) {
+	for i, g := range golden {
+		got, err := asmtest.Text(new(Encoder), g.in)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		want, err := hex.DecodeString(g.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("i=%d: encoding mismatch of %q; expected %x, got %x", i, g.in, want, got)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	golden := []struct{ in, want string }{
+		// i=0
+		{in: "adds r0, r1, #1", want: "481c"},
+		// i=1
+		{in: "adds r0, #1", want: "0130"},
+		// i=2
+		{in: "add r0, r0, r1", want: "0844"},
+		// i=3
+		{in: "add r0, r1, r0", want: "0844"},
+		// i=4
+		{in: "adds r0, r1, r2", want: "8818"},
+		// i=5
+		{in: "add r0, r1, r2", want: "01eb0200"},
+		// i=6
+		{in: "add r0, r1, #4095", want: "01f6ff70"},
+		// i=7
+		{in: "add r0, r1, #-1", want: "01f1ff30"},
+		// i=8
+		{in: "add r0, r1, #-4095", want: "a1f6ff70"},
+		// i=9
+		{in: "subs r0, r1, r2", want: "881a"},
+		// i=10
+		{in: "sub r0, r1, r2, lsl #3", want: "a1ebc200"},
+		// i=11
+		{in: "add.w r0, r0, r1", want: "00eb0100"},
+		// i=12
+		{in: "and r0, r1, #0xFFFFFF00", want: "21f0ff00"},
+		// i=13
+		{in: "ands r0, r1, r0", want: "0840"},
+		// i=14
+		{in: "orr r0, r1, #0xFFFFFF00", want: "61f0ff00"},
+		// i=15
+		{in: "orn r0, r1, r2", want: "61ea0200"},
+		// i=16
+		{in: "eor r0, r1, #0x00FF00FF", want: "81f0ff10"},
+		// i=17
+		{in: "eor r0, r1, #0xFF00FF00", want: "81f0ff20"},
+		// i=18
+		{in: "eor r0, r1, #0xABABABAB", want: "81f0ab30"},
+		// i=19
+		{in: "eor r0, r1, #0x3FC00", want: "81f47f30"},
+		// i=20
+		{in: "adcs r0, r1", want: "4841"},
+		// i=21
+		{in: "sbc r0, r1, r2, asr #32", want: "61eb2200"},
+		// i=22
+		{in: "bic r0, r1, r2, ror #4", want: "21ea3210"},
+		// i=23
+		{in: "rsbs r0, r1, #0", want: "4842"},
+		// i=24
+		{in: "rsb r0, r1, #8", want: "c1f10800"},
+		// i=25
+		{in: "negs r0, r1", want: "4842"},
+		// i=26
+		{in: "mov r0, #0x1234", want: "41f23420"},
+		// i=27
+		{in: "mov r0, #0xFFFFFF00", want: "6ff0ff00"},
+		// i=28
+		{in: "movs r0, #0xFF00", want: "5ff47f40"},
+		// i=29
+		{in: "movs r0, #5", want: "0520"},
+		// i=30
+		{in: "mov r0, #5", want: "4ff00500"},
+		// i=31
+		{in: "mov r0, r1", want: "0846"},
+		// i=32
+		{in: "movs r0, r1", want: "0800"},
+		// i=33
+		{in: "mov r8, r1", want: "8846"},
+		// i=34
+		{in: "mov r0, r1, lsl #2", want: "4fea8100"},
+		// i=35
+		{in: "mov r0, r1, rrx", want: "4fea3100"},
+		// i=36
+		{in: "mvns r0, r1", want: "c843"},
+		// i=37
+		{in: "mvn r0, r1, lsl #1", want: "6fea4100"},
+		// i=38
+		{in: "movw r0, #0xFFFF", want: "4ff6ff70"},
+		// i=39
+		{in: "movt r0, #0x1234", want: "c1f23420"},
+		// i=40
+		{in: "cmp r0, #-1", want: "b0f1ff3f"},
+		// i=41
+		{in: "cmp r0, #255", want: "ff28"},
+		// i=42
+		{in: "cmp r8, r1", want: "8845"},
+		// i=43
+		{in: "cmp r0, r1", want: "8842"},
+		// i=44
+		{in: "cmp r0, r1, lsl #2", want: "b0eb810f"},
+		// i=45
+		{in: "cmn r0, #-1", want: "10f1ff3f"},
+		// i=46
+		{in: "tst r0, r1", want: "0842"},
+		// i=47
+		{in: "teq r0, #0x100", want: "90f4807f"},
+		// i=48
+		{in: "lsls r0, r1, #0", want: "0800"},
+		// i=49
+		{in: "lsl r0, r1, #0", want: "4fea0100"},
+		// i=50
+		{in: "lsls r0, r1", want: "8840"},
+		// i=51
+		{in: "lsl r0, #2", want: "4fea8000"},
+		// i=52
+		{in: "lsrs r0, r1, #32", want: "0808"},
+		// i=53
+		{in: "lsl r0, r1, r2", want: "01fa02f0"},
+		// i=54
+		{in: "rors r0, r1", want: "c841"},
+		// i=55
+		{in: "rrxs r0, r1", want: "5fea3100"},
+		// i=56
+		{in: "muls r0, r1, r0", want: "4843"},
+		// i=57
+		{in: "muls r0, r1", want: "4843"},
+		// i=58
+		{in: "mul r0, r1, r2", want: "01fb02f0"},
+		// i=59
+		{in: "mla r0, r1, r2, r3", want: "01fb0230"},
+		// i=60
+		{in: "mls r0, r1, r2, r3", want: "01fb1230"},
+		// i=61
+		{in: "smull r0, r1, r2, r3", want: "82fb0301"},
+		// i=62
+		{in: "umlal r0, r1, r2, r3", want: "e2fb0301"},
+		// i=63
+		{in: "sdiv r0, r1, r2", want: "91fbf2f0"},
+		// i=64
+		{in: "udiv r0, r1, r2", want: "b1fbf2f0"},
+		// i=65
+		{in: "clz r0, r1", want: "b1fa81f0"},
+		// i=66
+		{in: "rbit r0, r1", want: "91faa1f0"},
+		// i=67
+		{in: "rev r0, r1", want: "08ba"},
+		// i=68
+		{in: "rev r8, r1", want: "91fa81f8"},
+		// i=69
+		{in: "revsh r0, r1", want: "c8ba"},
+		// i=70
+		{in: "sxtb r0, r1", want: "48b2"},
+		// i=71
+		{in: "sxtb r0, r1, ror #8", want: "4ffa91f0"},
+		// i=72
+		{in: "uxth r0, r1, ror #16", want: "1ffaa1f0"},
+		// i=73
+		{in: "sbfx r0, r1, #4, #8", want: "41f30710"},
+		// i=74
+		{in: "ubfx r0, r1, #0, #32", want: "c1f31f00"},
+		// i=75
+		{in: "bfi r0, r1, #8, #4", want: "61f30b20"},
+		// i=76
+		{in: "bfc r0, #3, #5", want: "6ff3c700"},
+		// i=77
+		{in: "addw r0, r1, #4095", want: "01f6ff70"},
+		// i=78
+		{in: "subw r0, r1, #1", want: "a1f20100"},
+		// i=79
+		{in: "sub sp, sp, #8", want: "82b0"},
+		// i=80
+		{in: "add r0, sp, #1020", want: "ffa8"},
+		// i=81
+		{in: "add sp, sp, #512", want: "0df5007d"},
+		// i=82
+		{in: "add r0, sp, r0", want: "6844"},
+		// i=83
+		{in: "sub.w sp, sp, #8", want: "adf1080d"},
+		// i=84
+		{in: "ldr r0, [r1]", want: "0868"},
+		// i=85
+		{in: "ldr r0, [r1, #124]", want: "c86f"},
+		// i=86
+		{in: "ldr r0, [r1, #128]", want: "d1f88000"},
+		// i=87
+		{in: "ldr r0, [r1, #-4]", want: "51f8040c"},
+		// i=88
+		{in: "ldr r0, [sp, #8]", want: "0298"},
+		// i=89
+		{in: "ldr r8, [sp, #8]", want: "ddf80880"},
+		// i=90
+		{in: "ldr r0, [pc, #8]", want: "0248"},
+		// i=91
+		{in: "ldr r0, [r1, r2]", want: "8858"},
+		// i=92
+		{in: "ldr r0, [r1, r2, lsl #2]", want: "51f82200"},
+		// i=93
+		{in: "ldr.w r0, [r1]", want: "d1f80000"},
+		// i=94
+		{in: "ldrb r0, [r1, #32]", want: "91f82000"},
+		// i=95
+		{in: "ldrh r0, [r1, #1]", want: "b1f80100"},
+		// i=96
+		{in: "ldrsb r0, [r1, #1]", want: "91f90100"},
+		// i=97
+		{in: "ldrsh r0, [r1, #-2]", want: "31f9020c"},
+		// i=98
+		{in: "str r0, [sp, #4]", want: "0190"},
+		// i=99
+		{in: "strb r0, [r1, #1]", want: "4870"},
+		// i=100
+		{in: "strh r0, [r1, r2, lsl #1]", want: "21f81200"},
+		// i=101
+		{in: "ldrd r0, r1, [r2, #8]", want: "d2e90201"},
+		// i=102
+		{in: "strd r0, r1, [r2, #-8]", want: "42e90201"},
+		// i=103
+		{in: "ldrex r0, [r1, #4]", want: "51e8010f"},
+		// i=104
+		{in: "strex r0, r1, [r2]", want: "42e80010"},
+		// i=105
+		{in: "ldrexb r0, [r1]", want: "d1e84f0f"},
+		// i=106
+		{in: "strexh r0, r1, [r2]", want: "c2e8501f"},
+		// i=107
+		{in: "clrex", want: "bff32f8f"},
+		// i=108
+		{in: "stmdb sp!, {r4-r7, lr}", want: "2de9f040"},
+		// i=109
+		{in: "ldmia sp!, {r4-r7, pc}", want: "bde8f080"},
+		// i=110
+		{in: "push {r4-r7, lr}", want: "f0b5"},
+		// i=111
+		{in: "pop {r4-r7, pc}", want: "f0bd"},
+		// i=112
+		{in: "push {r8}", want: "4df8048d"},
+		// i=113
+		{in: "pop {r8}", want: "5df8048b"},
+		// i=114
+		{in: "push.w {r4, r5}", want: "2de93000"},
+		// i=115
+		{in: "pop {r0, r8, pc}", want: "bde80181"},
+		// i=116
+		{in: "ldm r0, {r1, r2}", want: "90e80600"},
+		// i=117
+		{in: "ldm r0!, {r1, r2}", want: "06c8"},
+		// i=118
+		{in: "ldm r0, {r0, r1}", want: "03c8"},
+		// i=119
+		{in: "stm r0!, {r1, r2}", want: "06c0"},
+		// i=120
+		{in: "stm r0, {r1, r2}", want: "80e80600"},
+		// i=121
+		{in: "ldmdb r0, {r1, r2}", want: "10e90600"},
+		// i=122
+		{in: "tbb [r0, r1]", want: "d0e801f0"},
+		// i=123
+		{in: "tbh [r0, r1, lsl #1]", want: "d0e811f0"},
+		// i=124
+		{in: "bx lr", want: "7047"},
+		// i=125
+		{in: "blx r3", want: "9847"},
+		// i=126
+		{in: "nop", want: "00bf"},
+		// i=127
+		{in: "nop.w", want: "aff30080"},
+		// i=128
+		{in: "wfi", want: "30bf"},
+		// i=129
+		{in: "bkpt #3", want: "03be"},
+		// i=130
+		{in: "svc #0", want: "00df"},
+		// i=131
+		{in: "udf #0", want: "00de"},
+		// i=132
+		{in: "cpsid i", want: "72b6"},
+		// i=133
+		{in: "cpsie if", want: "63b6"},
+		// i=134
+		{in: "dmb", want: "bff35f8f"},
+		// i=135
+		{in: "dmb ish", want: "bff35b8f"},
+		// i=136
+		{in: "dsb #1", want: "bff3418f"},
+		// i=137
+		{in: "isb", want: "bff36f8f"},
+		// i=138
+		{in: "mrs r0, primask", want: "eff31080"},
+		// i=139
+		{in: "mrs r1, apsr", want: "eff30081"},
+		// i=140
+		{in: "msr basepri, r0", want: "80f31188"},
+		// i=141
+		{in: "msr apsr_nzcvq, r0", want: "80f30088"},
+		// i=142
+		{in: "ldr r0, [r1, #4]!", want: "51f8040f"},
+		// i=143
+		{in: "ldr r0, [r1], #4", want: "51f8040b"},
+		// i=144
+		{in: "str r0, [sp, #-4]!", want: "4df8040d"},
+		// i=145
+		{in: "ldrb r2, [r3], #-1", want: "13f80129"},
+		// i=146
+		{in: "strh r4, [r5, #255]!", want: "25f8ff4f"},
+		// i=147
+		{in: "ldrsh r6, [r7], #-255", want: "37f9ff69"},
+		// i=148
+		{in: "ldr r8, [r9], #0", want: "59f8008b"},
+		// i=149
+		{in: "ldrsb r1, [r2, #-8]!", want: "12f9081d"},
+		// i=150
+		{in: "strb r3, [r4], #17", want: "04f8113b"},
+		// i=151
+		{in: "ldrh r5, [r6, #2]!", want: "36f8025f"},
+		// i=152
+		{in: "ldr pc, [sp], #4", want: "5df804fb"},
+	}
+	checkEncode(t, golden)
+}
+
+func TestEncodeIT(t *testing.T) {
+	golden := []struct{ in, want string }{
+		// i=0
+		{in: "it eq\nmoveq r0, #1", want: "08bf0120"},
+		// i=1
+		{in: "ite ne\naddne r0, r1\naddeq r0, r1", want: "14bf08440844"},
+		// i=2
+		{in: "ittt hi\naddhi r0, r0, r1\naddshi r0, r1\naddhi r0, r1, r2", want: "82bf401810eb01008818"},
+		// i=3
+		{in: "it ge\nldrge r0, [r1]", want: "a8bf0868"},
+		// i=4
+		{in: "itete gt\nmovgt r0, #1\nmovle r0, #2\nmovgt r0, #3\nmovle r0, #4", want: "cbbf0120022003200420"},
+		// i=5
+		{in: "itttt al\nmov r0, #1\nmov r0, #2\nmov r0, #3\nmov r0, #4", want: "e1bf0120022003200420"},
+		// i=6
+		{in: "it eq\npopeq {r4, pc}", want: "08bf10bd"},
+		// i=7
+		{in: "it eq\nbxeq lr", want: "08bf7047"},
+		// i=8
+		{in: "it eq\nmoveq r0, #1\nmovs r0, #1", want: "08bf01200120"},
+	}
+	checkEncode(t, golden)
+}
+
+func TestEncodeBranch(t *testing.T) {
+	// pad returns a data directive of n zero bytes.
+	pad := func(n int) string {
+		return "db " + strings.TrimSuffix(strings.Repeat("0, ", n), ", ")
+	}
+	golden := []struct{ in, want string }{
+		// i=0
+		{in: "b l\nl:", want: "ffe7"},
+		// i=1
+		{in: "l:\nb l", want: "fee7"},
+		// i=2
+		{in: "b l\n" + pad(2044) + "\nl:", want: "fde3" + strings.Repeat("00", 2044)},
+		// i=3
+		{in: "b l\n" + pad(2050) + "\nl:", want: "00f001bc" + strings.Repeat("00", 2050)},
+		// i=4
+		{in: "beq l\nl:", want: "ffd0"},
+		// i=5
+		{in: "l:\nbeq l", want: "fed0"},
+		// i=6
+		{in: "beq l\n" + pad(258) + "\nl:", want: "00f08180" + strings.Repeat("00", 258)},
+		// i=7
+		{in: "beq.w l\nl:", want: "00f00080"},
+		// i=8
+		{in: "bl l\nl:", want: "00f000f8"},
+		// i=9
+		{in: "l:\nbl l", want: "fff7feff"},
+		// i=10
+		{in: "cbz r0, l\nnop\nl:", want: "00b100bf"},
+		// i=11
+		{in: "cbnz r7, l\n" + pad(126) + "\nl:", want: "f7bb" + strings.Repeat("00", 126)},
+		// i=12
+		{in: "it eq\nbeq l\nl:", want: "08bfffe7"},
+	}
+	checkEncode(t, golden)
+}
+
+func TestEncodeRelax(t *testing.T) {
+	// Instructions never shrink once they have grown; the 16-bit encodings of
+	// adr and ldr require a word-aligned target, which would otherwise move by
+	// the growth of the instruction.
+	golden := []struct{ in, want string }{
+		// i=0
+		{in: "start:\n\tadr r0, fwd\nfwd:\n\tnop", want: "0ff2000000bf"},
+		// i=1
+		{in: "start:\n\tadr r0, fwd\n\tnop\n\tnop\nfwd:\n\tnop", want: "0ff2040000bf00bf00bf"},
+		// i=2
+		{in: "start:\n\tldr r0, fwd\nfwd:\n\tnop", want: "dff8000000bf"},
+	}
+	checkEncode(t, golden)
+}
+
+func TestEncodeLabels(t *testing.T) {
+	const src = `
+main:
+	push {r4, lr}
+	ldr r0, =msg
+	bl puts
+	cmp r0, #0
+	ite eq
+	moveq r0, #1
+	movne r0, #2
+	ldr r1, =0x12345678
+	pop {r4, pc}
+puts:
+	ldrb r1, [r0]
+	cbz r1, done
+	adds r0, #1
+	b puts
+done:
+	bx lr
+	pool
+msg:
+	db "hi", 0
+`
+	want := []string{
+		"10b5",     // push {r4, lr}
+		"0748",     // ldr r0, [pc, #28]
+		"00f006f8", // bl puts
+		"0028",     // cmp r0, #0
+		"0cbf",     // ite eq
+		"0120",     // moveq r0, #1
+		"0220",     // movne r0, #2
+		"0449",     // ldr r1, [pc, #16]
+		"10bd",     // pop {r4, pc}
+		"0178",     // ldrb r1, [r0]
+		"09b1",     // cbz r1, done
+		"0130",     // adds r0, #1
+		"fbe7",     // b puts
+		"7047",     // bx lr
+		"0000",     // padding
+		"28000000", // msg
+		"78563412", // 0x12345678
+		"686900",   // "hi", 0
+	}
+	got, err := asmtest.Text(new(Encoder), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := hex.DecodeString(strings.Join(want, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, w) {
+		t.Errorf("encoding mismatch; expected %x, got %x", w, got)
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	golden := []struct {
+		in   string
+		want string
+	}{
+		// i=0
+		{in: "moveq r0, #1", want: `1:1: arm.encoding.encode: conditional instruction "moveq" outside of IT block`},
+		// i=1
+		{in: "it eq\nmovne r0, #1", want: `2:1: arm.encoding.encode: condition of "movne" does not match condition eq of IT block`},
+		// i=2
+		{in: "it eq\nit eq", want: `2:1: arm.encoding.encode: "it" in IT block`},
+		// i=3
+		{in: "itt eq\nbeq l\nmoveq r0, r1\nl:", want: `2:1: arm.encoding.checkLast: "beq" must be the last instruction of IT block`},
+		// i=4
+		{in: "ite al\nmov r0, #1\nmov r0, #2", want: `1:1: arm.encoding.ifThen: invalid else condition of "ite"; condition al has no inverse`},
+		// i=5
+		{in: "mov r0, #0xFFFF1234", want: `1:1: arm.encoding.move: immediate 0xFFFF1234 of "mov" cannot be encoded as a modified immediate constant`},
+		// i=6
+		{in: "add r0, r1, #0x12345", want: `1:1: arm.encoding.dataProcImm: immediate 0x12345 of "add" cannot be encoded as a modified immediate constant`},
+		// i=7
+		{in: "muls r0, r1, r2", want: `1:1: arm.encoding.multiply: no encoding of "muls" with the given operands; expected low registers and destination register as source`},
+		// i=8
+		{in: "adds.n r0, r1, #8", want: `1:1: arm.encoding.encode: no 16-bit encoding of "adds.n" with the given operands`},
+		// i=9
+		{in: "add r0, pc, r1", want: `1:1: arm.encoding.checkRegs: invalid use of pc in "add"`},
+		// i=10
+		{in: "ldr r0, [r1, #-256]", want: `1:1: arm.encoding.loadStoreOffset: offset -256 of "ldr" out of range [-255, 4095]`},
+		// i=11
+		{in: "str r0, [pc, #4]", want: `1:1: arm.encoding.loadStoreOffset: invalid use of pc in "str"`},
+		// i=12
+		{in: "ldrd r0, r1, [r2, #2]", want: `1:1: arm.encoding.memOffset: offset 2 of "ldrd" not a multiple of 4`},
+		// i=13
+		{in: "push {sp}", want: `1:1: arm.encoding.checkList: invalid use of sp in register list of "push"`},
+		// i=14
+		{in: "pop {lr, pc}", want: `1:1: arm.encoding.checkList: invalid use of both lr and pc in register list of "pop"`},
+		// i=15
+		{in: "ldm r0!, {r0, r1}", want: `1:1: arm.encoding.loadStoreMultiple: invalid writeback of base register in register list of "ldm"`},
+		// i=16
+		{in: "lsr r0, r1, #0", want: `1:1: arm.encoding.imm: immediate 0 of "lsr" out of range [1, 32]`},
+		// i=17
+		{in: "sbfx r0, r1, #30, #3", want: `1:1: arm.encoding.imm: immediate 3 of "sbfx" out of range [1, 2]`},
+		// i=18
+		{in: "tbh [r0, r1]", want: `1:1: arm.encoding.tableBranch: invalid memory operand of "tbh"; expected [rn, rm, lsl #1]`},
+		// i=19
+		{in: "sxtb r0, r1, ror #4", want: `1:1: arm.encoding.extend: invalid rotation 4 of "sxtb"; expected 0, 8, 16 or 24`},
+		// i=20
+		{in: "cbz r0, l\nl:", want: "1:1: arm.encoding.compareBranch: branch target out of range; offset -2 not in range [0, 126]"},
+		// i=21
+		{in: "cbz r8, l\nnop\nl:", want: `1:1: arm.encoding.compareBranch: invalid register r8 of "cbz"; expected r0-r7`},
+		// i=22
+		{in: "isb ish", want: `1:1: arm.encoding.barrier: invalid barrier option "ish" of "isb"`},
+		// i=23
+		{in: "add r0, r1, r2, sxtw", want: `1:1: arm.encoding.shift: invalid shift operator "sxtw" of "add"`},
+		// i=24
+		{in: "ldr r0, [r1, r2, sxtw #2]", want: `1:1: arm.encoding.loadStore: invalid shift operator "sxtw" of index register of "ldr"; expected lsl`},
+		// i=25
+		{in: "ldr r0, [r0, #4]!", want: `1:1: arm.encoding.loadStoreIndex: invalid writeback of base register of "ldr"`},
+		// i=26
+		{in: "str r0, [r1], #256", want: `1:1: arm.encoding.imm: immediate 256 of "str" out of range [-255, 255]`},
+		// i=27
+		{in: "ldr r0, [r1, #4], #4", want: `1:1: arm.encoding.loadStoreIndex: invalid memory operand of "ldr"; expected base register and optional offset`},
+	}
+
+	for i, g := range golden {
+		_, err := asmtest.Text(new(Encoder), g.in)
+		if err == nil {
+			t.Errorf("i=%d: expected error %q, got nil", i, g.want)
+			continue
+		}
+		if got := err.Error(); got != g.want {
+			t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
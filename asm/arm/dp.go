@@ -0,0 +1,476 @@
+package arm
+
+import (
+	"fmt"
+
+	armarch "github.com/mewlang/asm/arch/arm"
+	"github.com/mewlang/asm/ast"
+)
+
+// Op fields of the 32-bit data processing encodings.
+const (
+	opAND = 0
+	opBIC = 1
+	opORR = 2
+	opORN = 3
+	opEOR = 4
+	opADD = 8
+	opADC = 10
+	opSBC = 11
+	opSUB = 13
+	opRSB = 14
+)
+
+// dpOps maps from data processing mnemonic to the op field of its 32-bit
+// encodings.
+var dpOps = map[string]uint32{
+	"and": opAND,
+	"bic": opBIC,
+	"orr": opORR,
+	"orn": opORN,
+	"eor": opEOR,
+	"add": opADD,
+	"adc": opADC,
+	"sbc": opSBC,
+	"sub": opSUB,
+	"rsb": opRSB,
+}
+
+// aluOps maps from data processing mnemonic to the op field of its 16-bit
+// two register encoding.
+var aluOps = map[string]uint32{
+	"and": 0,
+	"eor": 1,
+	"lsl": 2,
+	"lsr": 3,
+	"asr": 4,
+	"adc": 5,
+	"sbc": 6,
+	"ror": 7,
+	"orr": 12,
+	"bic": 14,
+}
+
+// commutative specifies the data processing instructions whose source operands
+// may be swapped.
+var commutative = map[string]bool{
+	"and": true,
+	"eor": true,
+	"adc": true,
+	"orr": true,
+	"add": true,
+}
+
+// shiftTypes maps from shift mnemonic to shift type.
+var shiftTypes = map[string]uint32{
+	"lsl": 0,
+	"lsr": 1,
+	"asr": 2,
+	"ror": 3,
+}
+
+// A shift is the shift applied to a register operand.
+type shift struct {
+	// Shift type; lsl, lsr, asr or ror (rrx if the amount is 0).
+	typ uint32
+	// Encoded shift amount; in range [0, 31].
+	amount uint32
+}
+
+// shift returns the register and the encoded shift of the shifted register
+// operand x.
+func (e *encoding) shift(x *ast.ShiftedReg) (rm uint32, sh shift, err error) {
+	rm = reg(x.X)
+	if x.Op == "rrx" {
+		if x.Amount != nil {
+			return 0, shift{}, fmt.Errorf("arm.encoding.shift: unexpected shift amount of rrx in %q", e.inst.Op)
+		}
+		return rm, shift{typ: 3}, nil
+	}
+	typ, ok := shiftTypes[x.Op]
+	if !ok {
+		return 0, shift{}, fmt.Errorf("arm.encoding.shift: invalid shift operator %q of %q", x.Op, e.inst.Op)
+	}
+	if x.Amount == nil {
+		return 0, shift{}, fmt.Errorf("arm.encoding.shift: missing shift amount of %s in %q", x.Op, e.inst.Op)
+	}
+	amount, err := e.shiftAmount(typ, x.Amount)
+	if err != nil {
+		return 0, shift{}, err
+	}
+	return rm, shift{typ: typ, amount: amount}, nil
+}
+
+// shiftAmount evaluates the shift amount x of the given shift type, and returns
+// its encoding. Right shifts by 32 bits are encoded as 0.
+func (e *encoding) shiftAmount(typ uint32, x ast.Arg) (uint32, error) {
+	min, max := int64(1), int64(32)
+	switch typ {
+	case 0:
+		min, max = 0, 31
+	case 3:
+		max = 31
+	}
+	amount, err := e.imm(x, min, max)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(amount) & 31, nil
+}
+
+// dataProc encodes a data processing instruction, whose second source operand
+// is a register, a shifted register or an immediate. The first source operand
+// of two operand forms is the destination register.
+func (e *encoding) dataProc() error {
+	args := e.inst.Args
+	rd := reg(args[0])
+	rn, op2 := rd, args[1]
+	if len(args) == 3 {
+		rn, op2 = reg(args[1]), args[2]
+	}
+	switch x := op2.(type) {
+	case ast.Reg:
+		return e.dataProcReg(rd, rn, uint32(x), len(args) == 3)
+	case *ast.ShiftedReg:
+		rm, sh, err := e.shift(x)
+		if err != nil {
+			return err
+		}
+		if sh == (shift{}) {
+			return e.dataProcReg(rd, rn, rm, len(args) == 3)
+		}
+		if err := e.checkRegs(rd, rn, rm); err != nil {
+			return err
+		}
+		e.dpReg(dpOps[e.m.Base], e.s(), rn, rd, rm, sh)
+		return nil
+	}
+	return e.dataProcImm(rd, rn, op2, len(args) == 3)
+}
+
+// dataProcReg encodes a data processing instruction with register operands.
+func (e *encoding) dataProcReg(rd, rn, rm uint32, three bool) error {
+	base := e.m.Base
+	switch base {
+	case "add":
+		if e.narrowFlags() && low(rd) && low(rn) && low(rm) && (three || e.m.S) {
+			e.emit16(0x1800 | rm<<6 | rn<<3 | rd)
+			return nil
+		}
+		if !e.m.S && e.narrow() && (rd == rn || rd == rm) {
+			if rd == rm {
+				rm = rn
+			}
+			e.emit16(0x4400 | rd&8<<4 | rm<<3 | rd&7)
+			return nil
+		}
+	case "sub":
+		if e.narrowFlags() && low(rd) && low(rn) && low(rm) {
+			e.emit16(0x1A00 | rm<<6 | rn<<3 | rd)
+			return nil
+		}
+	default:
+		op, ok := aluOps[base]
+		if ok && e.narrowFlags() && low(rd) && low(rn) && low(rm) {
+			switch {
+			case rd == rn:
+				e.emit16(0x4000 | op<<6 | rm<<3 | rd)
+				return nil
+			case rd == rm && commutative[base]:
+				e.emit16(0x4000 | op<<6 | rn<<3 | rd)
+				return nil
+			}
+		}
+	}
+	if err := e.checkRegs(rd, rn, rm); err != nil {
+		return err
+	}
+	e.dpReg(dpOps[base], e.s(), rn, rd, rm, shift{})
+	return nil
+}
+
+// dataProcImm encodes a data processing instruction with an immediate operand.
+// Immediates which cannot be encoded as modified immediate constants are
+// encoded using the complementary instruction with an inverted or negated
+// immediate where possible; such as bic for and, and sub for add.
+func (e *encoding) dataProcImm(rd, rn uint32, x ast.Arg, three bool) error {
+	val, err := ast.EvalWidth(x, e.syms, 32)
+	if err != nil {
+		return err
+	}
+	v := uint32(val)
+	base := e.m.Base
+	switch base {
+	case "add", "sub":
+		if e.narrowFlags() && low(rd) && low(rn) {
+			op := uint32(0)
+			if base == "sub" {
+				op = 0x200
+			}
+			switch {
+			case v < 8 && (three || rd != rn):
+				e.emit16(0x1C00 | op | v<<6 | rn<<3 | rd)
+				return nil
+			case v < 0x100 && rd == rn:
+				e.emit16(0x3000 | op<<2 | rd<<8 | v)
+				return nil
+			}
+		}
+		if !e.m.S && e.narrow() && rn == uint32(armarch.SP) && v&3 == 0 {
+			switch {
+			case rd == rn && v < 0x200:
+				op := uint32(0xB000)
+				if base == "sub" {
+					op = 0xB080
+				}
+				e.emit16(op | v>>2)
+				return nil
+			case base == "add" && low(rd) && v < 0x400:
+				e.emit16(0xA800 | rd<<8 | v>>2)
+				return nil
+			}
+		}
+	case "rsb":
+		if e.narrowFlags() && low(rd) && low(rn) && v == 0 {
+			e.emit16(0x4240 | rn<<3 | rd)
+			return nil
+		}
+	}
+	if err := e.checkRegs(rd, rn, uint32(armarch.R0)); err != nil {
+		return err
+	}
+	op := dpOps[base]
+	if imm, ok := thumbImm(v); ok {
+		e.dpImm(op, e.s(), rn, rd, imm)
+		return nil
+	}
+	// Complementary instructions.
+	switch base {
+	case "and", "bic", "orr", "orn":
+		if imm, ok := thumbImm(^v); ok {
+			e.dpImm(op^1, e.s(), rn, rd, imm)
+			return nil
+		}
+	case "add", "sub":
+		if imm, ok := thumbImm(-v); ok {
+			e.dpImm(op^(opADD^opSUB), e.s(), rn, rd, imm)
+			return nil
+		}
+		if !e.m.S {
+			ops := map[string][2]uint32{"add": {0xF200, 0xF2A0}, "sub": {0xF2A0, 0xF200}}[base]
+			switch {
+			case v < 0x1000:
+				e.plainImm(ops[0], rn, rd, v)
+				return nil
+			case -v < 0x1000:
+				e.plainImm(ops[1], rn, rd, -v)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("arm.encoding.dataProcImm: immediate 0x%X of %q cannot be encoded as a modified immediate constant", v, e.inst.Op)
+}
+
+// checkRegs returns an error if the destination register rd, the first source
+// register rn or the second source register rm of a 32-bit data processing
+// instruction is invalid. The stack pointer may only be used with add and sub,
+// as source register rn or as destination register if also source register.
+func (e *encoding) checkRegs(rd, rn, rm uint32) error {
+	sp, pc := uint32(armarch.SP), uint32(armarch.PC)
+	addSub := e.m.Base == "add" || e.m.Base == "sub"
+	for _, r := range []uint32{rd, rn, rm} {
+		if r == pc {
+			return fmt.Errorf("arm.encoding.checkRegs: invalid use of pc in %q", e.inst.Op)
+		}
+	}
+	if rm == sp || (rn == sp && !addSub) || (rd == sp && rn != sp) {
+		return fmt.Errorf("arm.encoding.checkRegs: invalid use of sp in %q", e.inst.Op)
+	}
+	return nil
+}
+
+// move encodes a mov or mvn instruction. Shifted register operands of mov are
+// encoded as shift instructions.
+func (e *encoding) move() error {
+	args := e.inst.Args
+	rd := reg(args[0])
+	mvn := e.m.Base == "mvn"
+	switch x := args[1].(type) {
+	case ast.Reg:
+		rm := uint32(x)
+		switch {
+		case mvn && e.narrowFlags() && low(rd) && low(rm):
+			e.emit16(0x43C0 | rm<<3 | rd)
+			return nil
+		case !mvn && e.m.S && e.narrowFlags() && low(rd) && low(rm):
+			// movs rd, rm is lsls rd, rm, #0
+			e.emit16(rm<<3 | rd)
+			return nil
+		case !mvn && !e.m.S && e.narrow():
+			e.emit16(0x4600 | rd&8<<4 | rm<<3 | rd&7)
+			return nil
+		}
+		e.dpReg(opORR+e.mvnBit(), e.s(), 15, rd, rm, shift{})
+		return nil
+	case *ast.ShiftedReg:
+		rm, sh, err := e.shift(x)
+		if err != nil {
+			return err
+		}
+		if mvn {
+			e.dpReg(opMVN, e.s(), 15, rd, rm, sh)
+			return nil
+		}
+		if sh.typ == 3 && sh.amount == 0 {
+			// rrx
+			e.dpReg(opORR, e.s(), 15, rd, rm, sh)
+			return nil
+		}
+		e.shiftImm(sh.typ, rd, rm, sh.amount)
+		return nil
+	}
+	val, err := ast.EvalWidth(args[1], e.syms, 32)
+	if err != nil {
+		return err
+	}
+	v := uint32(val)
+	if !mvn && e.narrowFlags() && low(rd) && v < 0x100 {
+		e.emit16(0x2000 | rd<<8 | v)
+		return nil
+	}
+	if imm, ok := thumbImm(v); ok {
+		e.dpImm(opORR+e.mvnBit(), e.s(), 15, rd, imm)
+		return nil
+	}
+	if imm, ok := thumbImm(^v); ok {
+		e.dpImm(opORR+e.mvnBit()^1, e.s(), 15, rd, imm)
+		return nil
+	}
+	if !mvn && !e.m.S && v < 0x10000 {
+		e.plainImm(0xF240, v>>12, rd, v&0xFFF)
+		return nil
+	}
+	return fmt.Errorf("arm.encoding.move: immediate 0x%X of %q cannot be encoded as a modified immediate constant", v, e.inst.Op)
+}
+
+// opMVN is the op field of the 32-bit mvn encodings, which are orn encodings
+// with the pc as first source register.
+const opMVN = opORN
+
+// mvnBit returns the difference between the op fields of the 32-bit encodings
+// of the instruction and of mov.
+func (e *encoding) mvnBit() uint32 {
+	if e.m.Base == "mvn" {
+		return opMVN - opORR
+	}
+	return 0
+}
+
+// compareOps maps from compare mnemonic to the op field of its 32-bit
+// encodings.
+var compareOps = map[string]uint32{
+	"tst": opAND,
+	"teq": opEOR,
+	"cmn": opADD,
+	"cmp": opSUB,
+}
+
+// compare encodes a compare or test instruction, which updates the condition
+// flags without writing a destination register.
+func (e *encoding) compare() error {
+	args := e.inst.Args
+	rn := reg(args[0])
+	base := e.m.Base
+	op := compareOps[base]
+	switch x := args[1].(type) {
+	case ast.Reg:
+		rm := uint32(x)
+		if e.narrow() {
+			ops := map[string]uint32{"tst": 0x4200, "cmp": 0x4280, "cmn": 0x42C0}
+			switch {
+			case ops[base] != 0 && low(rn) && low(rm):
+				e.emit16(ops[base] | rm<<3 | rn)
+				return nil
+			case base == "cmp":
+				e.emit16(0x4500 | rn&8<<4 | rm<<3 | rn&7)
+				return nil
+			}
+		}
+		e.dpReg(op, 1, rn, 15, rm, shift{})
+		return nil
+	case *ast.ShiftedReg:
+		rm, sh, err := e.shift(x)
+		if err != nil {
+			return err
+		}
+		e.dpReg(op, 1, rn, 15, rm, sh)
+		return nil
+	}
+	val, err := ast.EvalWidth(args[1], e.syms, 32)
+	if err != nil {
+		return err
+	}
+	v := uint32(val)
+	if base == "cmp" && e.narrow() && low(rn) && v < 0x100 {
+		e.emit16(0x2800 | rn<<8 | v)
+		return nil
+	}
+	if imm, ok := thumbImm(v); ok {
+		e.dpImm(op, 1, rn, 15, imm)
+		return nil
+	}
+	if base == "cmp" || base == "cmn" {
+		if imm, ok := thumbImm(-v); ok {
+			e.dpImm(op^(opADD^opSUB), 1, rn, 15, imm)
+			return nil
+		}
+	}
+	return fmt.Errorf("arm.encoding.compare: immediate 0x%X of %q cannot be encoded as a modified immediate constant", v, e.inst.Op)
+}
+
+// shiftInst encodes a shift instruction of the given shift type, whose shift
+// amount is an immediate or a register.
+func (e *encoding) shiftInst(typ uint32) error {
+	args := e.inst.Args
+	rd := reg(args[0])
+	rn, x := rd, args[1]
+	if len(args) == 3 {
+		rn, x = reg(args[1]), args[2]
+	}
+	if rm, ok := x.(ast.Reg); ok {
+		if e.narrowFlags() && rd == rn && low(rd) && low(uint32(rm)) {
+			e.emit16(0x4000 | aluOps[e.m.Base]<<6 | uint32(rm)<<3 | rd)
+			return nil
+		}
+		e.emit32(0xFA00|typ<<5|e.s()<<4|rn, 0xF000|rd<<8|uint32(rm))
+		return nil
+	}
+	amount, err := e.shiftAmount(typ, x)
+	if err != nil {
+		return err
+	}
+	e.shiftImm(typ, rd, rn, amount)
+	return nil
+}
+
+// shiftImm emits a shift instruction of the given shift type with the given
+// encoded shift amount. Shifts by 0 are encoded as register moves.
+func (e *encoding) shiftImm(typ, rd, rm, amount uint32) {
+	if typ != 3 && e.narrowFlags() && low(rd) && low(rm) {
+		e.emit16(typ<<11 | amount<<6 | rm<<3 | rd)
+		return
+	}
+	e.dpReg(opORR, e.s(), 15, rd, rm, shift{typ: typ, amount: amount})
+}
+
+// dpImm emits a 32-bit data processing instruction with the given modified
+// immediate constant i:imm3:imm8.
+func (e *encoding) dpImm(op, s, rn, rd, imm uint32) {
+	e.emit32(0xF000|imm>>11<<10|op<<5|s<<4|rn, imm>>8&7<<12|rd<<8|imm&0xFF)
+}
+
+// dpReg emits a 32-bit data processing instruction with the given shifted
+// register operand.
+func (e *encoding) dpReg(op, s, rn, rd, rm uint32, sh shift) {
+	e.emit32(0xEA00|op<<5|s<<4|rn, sh.amount>>2<<12|rd<<8|sh.amount&3<<6|sh.typ<<4|rm)
+}
@@ -0,0 +1,392 @@
+package arm
+
+import (
+	"fmt"
+
+	armarch "github.com/mewlang/asm/arch/arm"
+	"github.com/mewlang/asm/ast"
+)
+
+// A loadStoreOp holds the encodings of a load or store instruction, without
+// register and offset fields.
+type loadStoreOp struct {
+	// First halfword of the 32-bit encoding with a positive 12-bit offset. The
+	// encodings with a negative 8-bit offset or a register offset are obtained
+	// by clearing bit 7.
+	wide uint32
+	// 16-bit encoding with a register offset.
+	reg uint32
+	// 16-bit encoding with a 5-bit offset scaled by size; or 0 if not present.
+	imm uint32
+	// Size in bytes of the loaded or stored value.
+	size int64
+}
+
+// loadStoreOps maps from load and store mnemonic to its encodings.
+var loadStoreOps = map[string]loadStoreOp{
+	"str":   {wide: 0xF8C0, reg: 0x5000, imm: 0x6000, size: 4},
+	"strh":  {wide: 0xF8A0, reg: 0x5200, imm: 0x8000, size: 2},
+	"strb":  {wide: 0xF880, reg: 0x5400, imm: 0x7000, size: 1},
+	"ldrsb": {wide: 0xF990, reg: 0x5600},
+	"ldr":   {wide: 0xF8D0, reg: 0x5800, imm: 0x6800, size: 4},
+	"ldrh":  {wide: 0xF8B0, reg: 0x5A00, imm: 0x8800, size: 2},
+	"ldrb":  {wide: 0xF890, reg: 0x5C00, imm: 0x7800, size: 1},
+	"ldrsh": {wide: 0xF9B0, reg: 0x5E00},
+}
+
+// loadStore encodes a load or store instruction, whose address is a memory
+// operand or a label relative to the program counter.
+func (e *encoding) loadStore(op loadStoreOp) error {
+	args := e.inst.Args
+	rt := reg(args[0])
+	var mem *ast.Mem
+	switch x := args[1].(type) {
+	case *ast.Mem:
+		if len(args) == 3 {
+			return e.loadStoreIndex(op, rt, x, args[2])
+		}
+		mem = x
+	case *ast.Writeback:
+		return e.loadStoreIndex(op, rt, x.X.(*ast.Mem), nil)
+	case *ast.Literal:
+		return fmt.Errorf("arm.encoding.loadStore: literal %v of %q not placed in literal pool", x.X, e.inst.Op)
+	default:
+		addr, err := ast.Eval(x, e.syms)
+		if err != nil {
+			return err
+		}
+		return e.loadStoreOffset(op, rt, uint32(armarch.PC), addr-e.align4())
+	}
+	rn, ok := mem.Base.(ast.Reg)
+	if !ok || mem.Rel {
+		return fmt.Errorf("arm.encoding.loadStore: invalid memory operand of %q; expected base register", e.inst.Op)
+	}
+	if mem.Index != nil {
+		if mem.Disp != nil {
+			return fmt.Errorf("arm.encoding.loadStore: invalid memory operand of %q; unexpected offset with index register", e.inst.Op)
+		}
+		if mem.Extend != "" && mem.Extend != "lsl" {
+			return fmt.Errorf("arm.encoding.loadStore: invalid shift operator %q of index register of %q; expected lsl", mem.Extend, e.inst.Op)
+		}
+		rm := reg(mem.Index)
+		if e.narrow() && mem.Scale == 1 && low(rt) && low(uint32(rn)) && low(rm) {
+			e.emit16(op.reg | rm<<6 | uint32(rn)<<3 | rt)
+			return nil
+		}
+		if mem.Scale == 8 {
+			return fmt.Errorf("arm.encoding.loadStore: invalid shift amount of index register of %q; expected 0, 1, 2 or 3", e.inst.Op)
+		}
+		shift := map[int]uint32{1: 0, 2: 1, 4: 2}[mem.Scale]
+		e.emit32(op.wide&^0x80|uint32(rn), rt<<12|shift<<4|rm)
+		return nil
+	}
+	var off int64
+	if mem.Disp != nil {
+		var err error
+		if off, err = ast.Eval(mem.Disp, e.syms); err != nil {
+			return err
+		}
+	}
+	return e.loadStoreOffset(op, rt, uint32(rn), off)
+}
+
+// loadStoreOffset encodes a load or store instruction, whose address is the
+// base register rn plus an immediate offset.
+func (e *encoding) loadStoreOffset(op loadStoreOp, rt, rn uint32, off int64) error {
+	sp, pc := uint32(armarch.SP), uint32(armarch.PC)
+	load := op.wide&0x10 != 0
+	if e.narrow() && low(rt) && off >= 0 {
+		switch {
+		case op.imm != 0 && low(rn) && off%op.size == 0 && off/op.size < 32:
+			e.emit16(op.imm | uint32(off/op.size)<<6 | rn<<3 | rt)
+			return nil
+		case op.size == 4 && rn == sp && off&3 == 0 && off < 0x400:
+			hw := uint32(0x9000)
+			if load {
+				hw = 0x9800
+			}
+			e.emit16(hw | rt<<8 | uint32(off)>>2)
+			return nil
+		case op.size == 4 && load && rn == pc && off&3 == 0 && off < 0x400:
+			e.emit16(0x4800 | rt<<8 | uint32(off)>>2)
+			return nil
+		}
+	}
+	if rn == pc {
+		if !load {
+			return fmt.Errorf("arm.encoding.loadStoreOffset: invalid use of pc in %q", e.inst.Op)
+		}
+		// The U bit specifies whether the offset is added or subtracted.
+		switch {
+		case off >= 0 && off < 0x1000:
+			e.emit32(op.wide|pc, rt<<12|uint32(off))
+			return nil
+		case off < 0 && -off < 0x1000:
+			e.emit32(op.wide&^0x80|pc, rt<<12|uint32(-off))
+			return nil
+		}
+		e.emit32(0, 0)
+		return fmt.Errorf("arm.encoding.loadStoreOffset: offset %d of %q out of range [-4095, 4095]", off, e.inst.Op)
+	}
+	switch {
+	case off >= 0 && off < 0x1000:
+		e.emit32(op.wide|rn, rt<<12|uint32(off))
+		return nil
+	case off < 0 && off > -0x100:
+		e.emit32(op.wide&^0x80|rn, rt<<12|0xC00|uint32(-off))
+		return nil
+	}
+	return fmt.Errorf("arm.encoding.loadStoreOffset: offset %d of %q out of range [-255, 4095]", off, e.inst.Op)
+}
+
+// loadStoreIndex encodes a load or store instruction which updates the base
+// register of the memory operand mem; by the offset of mem before the access
+// (pre-indexed), or by the offset post after the access (post-indexed) if
+// non-nil.
+func (e *encoding) loadStoreIndex(op loadStoreOp, rt uint32, mem *ast.Mem, post ast.Arg) error {
+	rn, ok := mem.Base.(ast.Reg)
+	if !ok || mem.Index != nil || mem.Rel || (post != nil && mem.Disp != nil) {
+		return fmt.Errorf("arm.encoding.loadStoreIndex: invalid memory operand of %q; expected base register and optional offset", e.inst.Op)
+	}
+	if rn == armarch.PC || uint32(rn) == rt {
+		return fmt.Errorf("arm.encoding.loadStoreIndex: invalid writeback of base register of %q", e.inst.Op)
+	}
+	// The P bit specifies pre-indexed addressing and the U bit whether the
+	// offset is added or subtracted.
+	p, disp := uint32(0x400), mem.Disp
+	if post != nil {
+		p, disp = 0, post
+	}
+	var off int64
+	if disp != nil {
+		var err error
+		if off, err = e.imm(disp, -255, 255); err != nil {
+			return err
+		}
+	}
+	u := uint32(0x200)
+	if off < 0 {
+		u, off = 0, -off
+	}
+	e.emit32(op.wide&^0x80|uint32(rn), rt<<12|0x900|p|u|uint32(off))
+	return nil
+}
+
+// align4 returns the word-aligned address of the instruction plus 4, which is
+// the base address of addresses relative to the program counter.
+func (e *encoding) align4() int64 {
+	return (e.pc + 4) &^ 3
+}
+
+// adr encodes an adr instruction, which computes the address of a label
+// relative to the program counter.
+func (e *encoding) adr() error {
+	rd := reg(e.inst.Args[0])
+	addr, err := ast.Eval(e.inst.Args[1], e.syms)
+	if err != nil {
+		return err
+	}
+	off := addr - e.align4()
+	switch {
+	case e.narrow() && low(rd) && off >= 0 && off&3 == 0 && off < 0x400:
+		e.emit16(0xA000 | rd<<8 | uint32(off)>>2)
+	case off >= 0 && off < 0x1000:
+		e.plainImm(0xF200, uint32(armarch.PC), rd, uint32(off))
+	case off < 0 && -off < 0x1000:
+		e.plainImm(0xF2A0, uint32(armarch.PC), rd, uint32(-off))
+	default:
+		e.emit32(0, 0)
+		return fmt.Errorf("arm.encoding.adr: offset %d of %q out of range [-4095, 4095]", off, e.inst.Op)
+	}
+	return nil
+}
+
+// memOffset returns the base register and the offset of the memory operand x,
+// whose offset must be a multiple of scale in the given range.
+func (e *encoding) memOffset(x ast.Arg, scale, min, max int64) (rn uint32, off int64, err error) {
+	mem := x.(*ast.Mem)
+	base, ok := mem.Base.(ast.Reg)
+	if !ok || mem.Index != nil || mem.Rel {
+		return 0, 0, fmt.Errorf("arm.encoding.memOffset: invalid memory operand of %q; expected base register and optional offset", e.inst.Op)
+	}
+	if mem.Disp != nil {
+		if off, err = e.imm(mem.Disp, min, max); err != nil {
+			return 0, 0, err
+		}
+	}
+	if off%scale != 0 {
+		return 0, 0, fmt.Errorf("arm.encoding.memOffset: offset %d of %q not a multiple of %d", off, e.inst.Op, scale)
+	}
+	return uint32(base), off, nil
+}
+
+// loadStoreDual encodes an ldrd or strd instruction.
+func (e *encoding) loadStoreDual() error {
+	args := e.inst.Args
+	rn, off, err := e.memOffset(args[2], 4, -1020, 1020)
+	if err != nil {
+		return err
+	}
+	hw := uint32(0xE940)
+	if e.m.Base == "ldrd" {
+		hw = 0xE950
+	}
+	if off >= 0 {
+		hw |= 0x80
+	} else {
+		off = -off
+	}
+	e.emit32(hw|rn, reg(args[0])<<12|reg(args[1])<<8|uint32(off)>>2)
+	return nil
+}
+
+// exclusive encodes an exclusive load or store instruction. The status
+// register of exclusive stores precedes the stored register.
+func (e *encoding) exclusive() error {
+	args := e.inst.Args
+	switch e.m.Base {
+	case "ldrex":
+		rn, off, err := e.memOffset(args[1], 4, 0, 1020)
+		if err != nil {
+			return err
+		}
+		e.emit32(0xE850|rn, reg(args[0])<<12|0xF00|uint32(off)>>2)
+	case "strex":
+		rn, off, err := e.memOffset(args[2], 4, 0, 1020)
+		if err != nil {
+			return err
+		}
+		e.emit32(0xE840|rn, reg(args[1])<<12|reg(args[0])<<8|uint32(off)>>2)
+	case "ldrexb", "ldrexh":
+		rn, _, err := e.memOffset(args[1], 1, 0, 0)
+		if err != nil {
+			return err
+		}
+		hw := uint32(0xF4F)
+		if e.m.Base == "ldrexh" {
+			hw = 0xF5F
+		}
+		e.emit32(0xE8D0|rn, reg(args[0])<<12|hw)
+	case "strexb", "strexh":
+		rn, _, err := e.memOffset(args[2], 1, 0, 0)
+		if err != nil {
+			return err
+		}
+		hw := uint32(0xF40)
+		if e.m.Base == "strexh" {
+			hw = 0xF50
+		}
+		e.emit32(0xE8C0|rn, reg(args[1])<<12|hw|reg(args[0]))
+	}
+	return nil
+}
+
+// regList returns the register mask of the register list x.
+func regList(x ast.Arg) uint32 {
+	var mask uint32
+	for _, r := range x.(*ast.RegList).Regs {
+		mask |= 1 << reg(r)
+	}
+	return mask
+}
+
+// checkList returns an error if the register mask of a load or store multiple
+// instruction is invalid. Loads of the pc must be the last instruction of IT
+// blocks.
+func (e *encoding) checkList(mask uint32, load bool) error {
+	sp, lr, pc := uint32(1)<<armarch.SP, uint32(1)<<armarch.LR, uint32(1)<<armarch.PC
+	switch {
+	case mask == 0:
+		return fmt.Errorf("arm.encoding.checkList: empty register list of %q", e.inst.Op)
+	case mask&sp != 0:
+		return fmt.Errorf("arm.encoding.checkList: invalid use of sp in register list of %q", e.inst.Op)
+	case !load && mask&pc != 0:
+		return fmt.Errorf("arm.encoding.checkList: invalid use of pc in register list of %q", e.inst.Op)
+	case load && mask&(lr|pc) == lr|pc:
+		return fmt.Errorf("arm.encoding.checkList: invalid use of both lr and pc in register list of %q", e.inst.Op)
+	case load && mask&pc != 0:
+		return e.checkLast()
+	}
+	return nil
+}
+
+// loadStoreMultiple encodes a load or store multiple instruction, whose base
+// register is updated if followed by '!'.
+func (e *encoding) loadStoreMultiple() error {
+	args := e.inst.Args
+	base := e.m.Base
+	load := base[0] == 'l'
+	var rn, w uint32
+	switch x := args[0].(type) {
+	case ast.Reg:
+		rn = uint32(x)
+	case *ast.Writeback:
+		rn, w = reg(x.X), 1
+	}
+	mask := regList(args[1])
+	if err := e.checkList(mask, load); err != nil {
+		return err
+	}
+	if w == 1 && mask&(1<<rn) != 0 {
+		return fmt.Errorf("arm.encoding.loadStoreMultiple: invalid writeback of base register in register list of %q", e.inst.Op)
+	}
+	decrement := map[string]bool{"ldmdb": true, "ldmea": true, "stmdb": true, "stmfd": true}[base]
+	if e.narrow() && !decrement && low(rn) && mask&^0xFF == 0 {
+		// The 16-bit loads update the base register unless it is loaded.
+		switch {
+		case load && (w == 1) == (mask&(1<<rn) == 0):
+			e.emit16(0xC800 | rn<<8 | mask)
+			return nil
+		case !load && w == 1:
+			e.emit16(0xC000 | rn<<8 | mask)
+			return nil
+		}
+	}
+	hw := uint32(0xE880)
+	if decrement {
+		hw = 0xE900
+	}
+	if load {
+		hw |= 0x10
+	}
+	e.emit32(hw|w<<5|rn, mask)
+	return nil
+}
+
+// pushPop encodes a push or pop instruction. Single registers which cannot be
+// encoded using 16 bits are stored or loaded using the stack pointer with
+// writeback.
+func (e *encoding) pushPop() error {
+	mask := regList(e.inst.Args[0])
+	pop := e.m.Base == "pop"
+	if err := e.checkList(mask, pop); err != nil {
+		return err
+	}
+	extra := uint32(1) << armarch.LR
+	hw, multiple, single := uint32(0xB400), uint32(0xE92D), uint32(0xF84D)
+	if pop {
+		extra = 1 << armarch.PC
+		hw, multiple, single = 0xBC00, 0xE8BD, 0xF85D
+	}
+	if e.narrow() && mask&^(0xFF|extra) == 0 {
+		if mask&extra != 0 {
+			hw |= 0x100
+		}
+		e.emit16(hw | mask&0xFF)
+		return nil
+	}
+	if mask&(mask-1) == 0 {
+		rt := uint32(0)
+		for mask>>rt != 1 {
+			rt++
+		}
+		if pop {
+			e.emit32(single, rt<<12|0xB04)
+		} else {
+			e.emit32(single, rt<<12|0xD04)
+		}
+		return nil
+	}
+	e.emit32(multiple, mask)
+	return nil
+}
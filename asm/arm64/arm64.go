@@ -0,0 +1,448 @@
+// Package arm64 implements an AArch64 instruction encoder for the integer
+// instructions of the A64 instruction set.
+//
+// Every instruction is encoded as a single 32-bit little-endian word. Like
+// LLVM, the encoder accepts the common aliases of the A64 instructions; such as
+// mov, cmp, lsl and cset. Immediates which do not fit in the immediate field
+// of an instruction are encoded using an equivalent instruction if present
+// (e.g. add x0, x1, #-1 is encoded as sub x0, x1, #1), and rejected otherwise.
+//
+// Memory operands are given as a base register with an optional offset or
+// index register; such as
+//
+//	ldr x0, [x1, #8]          ; unsigned offset
+//	ldr x0, [x1, #8]!         ; pre-indexed
+//	ldr x0, [x1], #8          ; post-indexed
+//	ldr x0, [x1, w2, sxtw #3] ; extended index register
+//
+// The targets of b, bl and adrp, and the immediate of add, may refer to
+// addresses assigned at link time; which are reported as relocation records.
+// The relocated immediate of add is the offset of the address within its 4 KB
+// page, as of the add following adrp:
+//
+//	adrp x0, msg
+//	add x0, x0, #msg
+package arm64
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mewlang/asm/arch"
+	arm64arch "github.com/mewlang/asm/arch/arm64"
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/symtab"
+)
+
+// Encoder is the A64 instruction encoder.
+var Encoder asm.Encoder = encoder{}
+
+// encoder implements the asm.Encoder interface for AArch64.
+type encoder struct{}
+
+// Arch returns the AArch64 architecture.
+func (encoder) Arch() arch.Arch {
+	return arm64arch.Arch
+}
+
+// Encode returns the little-endian machine code of inst, which is located at
+// address pc. Branch targets and literal loads are resolved using the provided
+// symbol table. Every instruction is 4 bytes in size, and a zero placeholder of
+// that size is returned on error.
+func (encoder) Encode(inst *ast.Inst, pc int64, syms ast.SymbolTable) ([]byte, error) {
+	buf := make([]byte, 4)
+	if err := arm64arch.Arch.Validate(inst); err != nil {
+		return buf, err
+	}
+	e := &encoding{inst: inst, pc: pc, syms: syms}
+	w, err := e.encode()
+	if e.err != nil {
+		err = e.err
+	}
+	if err != nil {
+		return buf, err
+	}
+	binary.LittleEndian.PutUint32(buf, w)
+	return buf, nil
+}
+
+// An encoding keeps track of the state of the encoding of an instruction.
+type encoding struct {
+	// Instruction to encode.
+	inst *ast.Inst
+	// Address of the instruction.
+	pc int64
+	// Symbol table used to resolve branch targets and literal loads.
+	syms ast.SymbolTable
+	// First error of invalid register operands; which are checked as the
+	// register fields of the instruction are encoded.
+	err error
+}
+
+// Hint instructions mapped to their hint numbers.
+var hintOps = map[string]uint32{
+	"nop":   0,
+	"yield": 1,
+	"wfe":   2,
+	"wfi":   3,
+	"sev":   4,
+	"sevl":  5,
+}
+
+// Exception generating instructions mapped to their opcodes.
+var exceptionOps = map[string]uint32{
+	"svc": 0xD4000001,
+	"hvc": 0xD4000002,
+	"smc": 0xD4000003,
+	"brk": 0xD4200000,
+	"hlt": 0xD4400000,
+}
+
+// Branch to register instructions mapped to their opcodes.
+var branchRegOps = map[string]uint32{
+	"br":  0xD61F0000,
+	"blr": 0xD63F0000,
+	"ret": 0xD65F0000,
+}
+
+// encode encodes the instruction.
+func (e *encoding) encode() (uint32, error) {
+	op, args := string(e.inst.Op), e.inst.Args
+	if o, ok := arithOps[op]; ok {
+		return e.arith(o[0], o[1], args[0], args[1], args[2])
+	}
+	if o, ok := logicalOps[op]; ok {
+		return e.logical(o.opc, o.n, args[0], args[1], args[2])
+	}
+	if o, ok := loadStoreOps[op]; ok {
+		return e.loadStore(o)
+	}
+	if o, ok := pairOps[op]; ok {
+		return e.loadStorePair(o)
+	}
+	if o, ok := exclusiveOps[op]; ok {
+		return e.exclusive(o)
+	}
+	if o, ok := bitfieldOps[op]; ok {
+		return e.bitfield(o)
+	}
+	if o, ok := dp1Ops[op]; ok {
+		if op == "rev" && sf(args[0]) == 0 {
+			o = dp1Ops["rev32"]
+		}
+		return sf(args[0])<<31 | 0x5AC00000 | o<<10 | e.reg(args[1])<<5 | e.reg(args[0]), nil
+	}
+	if o, ok := dp2Ops[op]; ok {
+		return sf(args[0])<<31 | 0x1AC00000 | e.reg(args[2])<<16 | o<<10 | e.reg(args[1])<<5 | e.reg(args[0]), nil
+	}
+	if o, ok := dp3Ops[op]; ok {
+		return e.multiply(o)
+	}
+	if o, ok := condSelectOps[op]; ok {
+		return e.condSelect(o)
+	}
+	if o, ok := moveWideOps[op]; ok {
+		return e.moveWide(o, args[0], args[1])
+	}
+	if hint, ok := hintOps[op]; ok {
+		return 0xD503201F | hint<<5, nil
+	}
+	if o, ok := exceptionOps[op]; ok {
+		imm, err := e.imm(args[0], 0, 0xFFFF)
+		if err != nil {
+			return 0, err
+		}
+		return o | uint32(imm)<<5, nil
+	}
+	if o, ok := branchRegOps[op]; ok {
+		rn := uint32(arm64arch.LR)
+		if len(args) == 1 {
+			rn = e.reg(args[0])
+		}
+		return o | rn<<5, nil
+	}
+	if cond, ok := condBranch(op); ok {
+		off, err := e.offset(args[0], 21)
+		if err != nil {
+			return 0, err
+		}
+		return 0x54000000 | uint32(off)>>2&0x7FFFF<<5 | uint32(cond), nil
+	}
+
+	switch op {
+	case "cmp", "cmn":
+		// Alias of subs and adds with the zero register as destination.
+		o := arithOps["subs"]
+		if op == "cmn" {
+			o = arithOps["adds"]
+		}
+		return e.arith(o[0], o[1], zr(args[0]), args[0], args[1])
+	case "neg", "negs":
+		// Alias of sub and subs with the zero register as first source.
+		o := arithOps["sub"]
+		if op == "negs" {
+			o = arithOps["subs"]
+		}
+		if _, ok := args[1].(ast.Reg); !ok && !isShiftedReg(args[1]) {
+			return 0, fmt.Errorf("arm64.encoding.encode: invalid operand of %q; expected register", op)
+		}
+		return e.arith(o[0], o[1], args[0], zr(args[0]), args[1])
+	case "adc", "adcs", "sbc", "sbcs":
+		return e.carry(op, args[0], args[1], args[2])
+	case "ngc", "ngcs":
+		return e.carry(map[string]string{"ngc": "sbc", "ngcs": "sbcs"}[op], args[0], zr(args[0]), args[1])
+	case "tst":
+		// Alias of ands with the zero register as destination.
+		return e.logical(logicalOps["ands"].opc, 0, zr(args[0]), args[0], args[1])
+	case "mvn":
+		// Alias of orn with the zero register as first source.
+		return e.logical(logicalOps["orn"].opc, 1, args[0], zr(args[0]), args[1])
+	case "mov":
+		return e.mov(args[0], args[1])
+	case "lsl", "lsr", "asr", "ror":
+		return e.shiftInst(op)
+	case "extr":
+		return e.extr(args[0], args[1], args[2], args[3])
+	case "ccmp", "ccmn":
+		return e.condCompare(op)
+	case "adr", "adrp":
+		return e.adr(op == "adrp")
+	case "b", "bl":
+		w := uint32(0x14000000)
+		if op == "bl" {
+			w = 0x94000000
+		}
+		if ok, err := e.reloc(symtab.ARM64Branch26, true, args[0]); err != nil || ok {
+			return w, err
+		}
+		off, err := e.offset(args[0], 28)
+		if err != nil {
+			return 0, err
+		}
+		return w | uint32(off)>>2&0x3FFFFFF, nil
+	case "cbz", "cbnz":
+		off, err := e.offset(args[1], 21)
+		if err != nil {
+			return 0, err
+		}
+		w := uint32(0x34000000)
+		if op == "cbnz" {
+			w = 0x35000000
+		}
+		return sf(args[0])<<31 | w | uint32(off)>>2&0x7FFFF<<5 | e.reg(args[0]), nil
+	case "tbz", "tbnz":
+		return e.testBranch(op == "tbnz")
+	case "hint":
+		imm, err := e.imm(args[0], 0, 127)
+		if err != nil {
+			return 0, err
+		}
+		return 0xD503201F | uint32(imm)<<5, nil
+	case "eret":
+		return 0xD69F03E0, nil
+	case "clrex", "dmb", "dsb", "isb":
+		return e.barrier(op)
+	case "mrs":
+		return 0xD5300000 | arm64arch.SysRegEnc(args[1].(ast.Reg))<<5 | e.reg(args[0]), nil
+	case "msr":
+		return 0xD5100000 | arm64arch.SysRegEnc(args[0].(ast.Reg))<<5 | e.reg(args[1]), nil
+	}
+	return 0, fmt.Errorf("arm64.Encoder.Encode: support for instruction %q not yet implemented", op)
+}
+
+// condBranch returns the condition of the given conditional branch mnemonic
+// (e.g. b.ne) and true if present, and false otherwise.
+func condBranch(op string) (arm64arch.Cond, bool) {
+	if len(op) < 3 || op[:2] != "b." {
+		return 0, false
+	}
+	return arm64arch.ParseCond(op[2:])
+}
+
+// cond returns the condition of the operand x, which is given by name.
+func (e *encoding) cond(x ast.Arg) (arm64arch.Cond, error) {
+	if name, ok := x.(ast.Ident); ok {
+		if cond, ok := arm64arch.ParseCond(string(name)); ok {
+			return cond, nil
+		}
+	}
+	return 0, fmt.Errorf("arm64.encoding.cond: invalid condition %v of %q", x, e.inst.Op)
+}
+
+// testBranch encodes a tbz or tbnz instruction, which branches if the given bit
+// of a register is zero or non-zero respectively.
+func (e *encoding) testBranch(nonzero bool) (uint32, error) {
+	args := e.inst.Args
+	bit, err := e.imm(args[1], 0, int64(width(args[0])-1))
+	if err != nil {
+		return 0, err
+	}
+	off, err := e.offset(args[2], 16)
+	if err != nil {
+		return 0, err
+	}
+	w := uint32(0x36000000)
+	if nonzero {
+		w = 0x37000000
+	}
+	return uint32(bit)>>5<<31 | w | uint32(bit)&0x1F<<19 | uint32(off)>>2&0x3FFF<<5 | e.reg(args[0]), nil
+}
+
+// adr encodes an adr instruction, or an adrp instruction which computes the
+// address of the 4 KB page of the target.
+func (e *encoding) adr(page bool) (uint32, error) {
+	args := e.inst.Args
+	addr, err := ast.Eval(args[1], e.syms)
+	if err != nil {
+		return 0, err
+	}
+	if page {
+		if ok, err := e.reloc(symtab.ARM64Page21, true, args[1]); err != nil || ok {
+			return 0x90000000 | e.reg(args[0]), err
+		}
+	}
+	off, w := addr-e.pc, uint32(0x10000000)
+	if page {
+		off, w = addr>>12-e.pc>>12, 0x90000000
+	}
+	if !fits(off, 21) {
+		return 0, fmt.Errorf("arm64.encoding.adr: target 0x%X of %q out of range; offset %d not in range [%d, %d]", addr, e.inst.Op, off, -1<<20, 1<<20-1)
+	}
+	imm := uint32(off)
+	return w | imm&3<<29 | imm>>2&0x7FFFF<<5 | e.reg(args[0]), nil
+}
+
+// barrierOpts maps from barrier option name to barrier option.
+var barrierOpts = map[string]uint32{
+	"oshld": 1,
+	"oshst": 2,
+	"osh":   3,
+	"nshld": 5,
+	"nshst": 6,
+	"nsh":   7,
+	"ishld": 9,
+	"ishst": 10,
+	"ish":   11,
+	"ld":    13,
+	"st":    14,
+	"sy":    15,
+}
+
+// barrier encodes a clrex, dmb, dsb or isb instruction, whose operand specifies
+// the barrier option; sy by default.
+func (e *encoding) barrier(op string) (uint32, error) {
+	crm := uint32(15)
+	if args := e.inst.Args; len(args) == 1 {
+		if name, ok := args[0].(ast.Ident); ok {
+			opt, ok := barrierOpts[string(name)]
+			if !ok || (op != "dmb" && op != "dsb" && opt != 15) {
+				return 0, fmt.Errorf("arm64.encoding.barrier: invalid barrier option %q of %q", name, op)
+			}
+			crm = opt
+		} else {
+			imm, err := e.imm(args[0], 0, 15)
+			if err != nil {
+				return 0, err
+			}
+			crm = uint32(imm)
+		}
+	}
+	ops := map[string]uint32{"clrex": 0xD503305F, "dsb": 0xD503309F, "dmb": 0xD50330BF, "isb": 0xD50330DF}
+	return ops[op] | crm<<8, nil
+}
+
+// offset returns the offset of the given branch or literal load target,
+// relative to the address of the instruction. The offset must be a multiple of
+// 4 which fits in a sign-extended immediate of the given bit width.
+func (e *encoding) offset(target ast.Arg, width uint) (int64, error) {
+	addr, err := ast.Eval(target, e.syms)
+	if err != nil {
+		return 0, err
+	}
+	if addr&3 != 0 {
+		return 0, fmt.Errorf("arm64.encoding.offset: unaligned target 0x%X of %q", addr, e.inst.Op)
+	}
+	off := addr - e.pc
+	if !fits(off, width) {
+		min, max := int64(-1)<<(width-1), int64(1)<<(width-1)-4
+		return 0, fmt.Errorf("arm64.encoding.offset: target 0x%X of %q out of range; offset %d not in range [%d, %d]", addr, e.inst.Op, off, min, max)
+	}
+	return off, nil
+}
+
+// reloc reports the relocation record of the given type of the instruction,
+// which refers to the address x. It returns true if the bit field of the
+// instruction is relocated, in which case it is encoded as zero.
+func (e *encoding) reloc(typ symtab.RelocType, pcrel bool, x ast.Arg) (bool, error) {
+	return asm.Reloc(e.syms, symtab.Reloc{Type: typ, Size: 4, PCRel: pcrel}, x)
+}
+
+// fits returns true if val fits in a sign-extended immediate of the given bit
+// width, and false otherwise.
+func fits(val int64, width uint) bool {
+	return val>>(width-1) == 0 || val>>(width-1) == -1
+}
+
+// imm evaluates x and returns its value, which must be in the given range.
+func (e *encoding) imm(x ast.Arg, min, max int64) (int64, error) {
+	val, err := ast.Eval(x, e.syms)
+	if err != nil {
+		return 0, err
+	}
+	if val < min || val > max {
+		return 0, fmt.Errorf("arm64.encoding.imm: immediate %d of %q out of range [%d, %d]", val, e.inst.Op, min, max)
+	}
+	return val, nil
+}
+
+// reg returns the register number of the general purpose register operand x,
+// in an operand position where register number 31 denotes the zero register.
+func (e *encoding) reg(x ast.Arg) uint32 {
+	return e.gpr(x, false)
+}
+
+// regSP returns the register number of the general purpose register operand x,
+// in an operand position where register number 31 denotes the stack pointer.
+func (e *encoding) regSP(x ast.Arg) uint32 {
+	return e.gpr(x, true)
+}
+
+// gpr returns the register number of the general purpose register operand x.
+// The stack pointer is valid if sp is true, and the zero register otherwise.
+// Invalid registers are recorded in e.err.
+func (e *encoding) gpr(x ast.Arg, sp bool) uint32 {
+	reg := x.(ast.Reg)
+	if arm64arch.Num(reg) == 31 && arm64arch.IsSP(reg) != sp && e.err == nil {
+		e.err = fmt.Errorf("arm64.encoding.gpr: invalid register %s of %q", arm64arch.Arch.RegName(reg), e.inst.Op)
+	}
+	return arm64arch.Num(reg)
+}
+
+// isSP returns true if x is the stack pointer sp or wsp, and false otherwise.
+func isSP(x ast.Arg) bool {
+	reg, ok := x.(ast.Reg)
+	return ok && arm64arch.IsSP(reg)
+}
+
+// zr returns the zero register of the same width as the register operand x.
+func zr(x ast.Arg) ast.Reg {
+	if sf(x) == 0 {
+		return arm64arch.WZR
+	}
+	return arm64arch.XZR
+}
+
+// sf returns the sf bit of instructions operating on the register operand x;
+// 1 for 64-bit registers and 0 for 32-bit registers.
+func sf(x ast.Arg) uint32 {
+	if arm64arch.Is32(x.(ast.Reg)) {
+		return 0
+	}
+	return 1
+}
+
+// width returns the width in bits of the register operand x.
+func width(x ast.Arg) uint {
+	return 32 << sf(x)
+}
@@ -0,0 +1,590 @@
+// The expected encodings of the test cases in this file have been verified using
+// llvm-mc.
+
+package arm64
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/mewlang/asm/internal/asmtest"
+)
+
+func TestEncode(t *testing.T) {
+	golden := []struct{ in, want string }{
+		// i=0
+		{in: "add x0, x1, x2", want: "2000028b"},
+		// i=1
+		{in: "add w0, w1, w2, lsl #3", want: "200c020b"},
+		// i=2
+		{in: "add x0, sp, #16", want: "e0430091"},
+		// i=3
+		{in: "add sp, sp, #0x1000", want: "ff074091"},
+		// i=4
+		{in: "add x0, x1, #-1", want: "200400d1"},
+		// i=5
+		{in: "adds x0, x1, x2, asr #63", want: "20fc82ab"},
+		// i=6
+		{in: "sub x0, x1, w2, sxtw #2", want: "20c822cb"},
+		// i=7
+		{in: "sub sp, sp, x1", want: "ff6321cb"},
+		// i=8
+		{in: "add x0, sp, x1", want: "e063218b"},
+		// i=9
+		{in: "add x0, x1, w2, uxtb", want: "2000228b"},
+		// i=10
+		{in: "add x0, x1, x2, uxtx #4", want: "2070228b"},
+		// i=11
+		{in: "subs w0, w1, #4095", want: "20fc3f71"},
+		// i=12
+		{in: "cmp x0, #0", want: "1f0000f1"},
+		// i=13
+		{in: "cmp w1, w2, lsl #2", want: "3f08026b"},
+		// i=14
+		{in: "cmn x0, #1", want: "1f0400b1"},
+		// i=15
+		{in: "neg x0, x1", want: "e00301cb"},
+		// i=16
+		{in: "negs w0, w1, lsl #2", want: "e00b016b"},
+		// i=17
+		{in: "adc x0, x1, x2", want: "2000029a"},
+		// i=18
+		{in: "sbcs w0, w1, w2", want: "2000027a"},
+		// i=19
+		{in: "ngc x0, x1", want: "e00301da"},
+		// i=20
+		{in: "ngcs w0, w1", want: "e003017a"},
+		// i=21
+		{in: "and x0, x1, #0xff", want: "201c4092"},
+		// i=22
+		{in: "and w0, w1, #0xff00ff00", want: "209c0812"},
+		// i=23
+		{in: "orr x0, xzr, #0x5555555555555555", want: "e0f300b2"},
+		// i=24
+		{in: "eor x0, x1, x2, ror #3", want: "200cc2ca"},
+		// i=25
+		{in: "bic w0, w1, w2", want: "2000220a"},
+		// i=26
+		{in: "bics x0, x1, x2, lsl #1", want: "200422ea"},
+		// i=27
+		{in: "orn x0, x1, x2", want: "200022aa"},
+		// i=28
+		{in: "eon w0, w1, w2", want: "2000224a"},
+		// i=29
+		{in: "ands x0, x1, #1", want: "200040f2"},
+		// i=30
+		{in: "tst x0, #0x8000000000000000", want: "1f0041f2"},
+		// i=31
+		{in: "tst w1, w2", want: "3f00026a"},
+		// i=32
+		{in: "mvn x0, x1", want: "e00321aa"},
+		// i=33
+		{in: "mvn w0, w1, lsl #3", want: "e00f212a"},
+		// i=34
+		{in: "mov x0, x1", want: "e00301aa"},
+		// i=35
+		{in: "mov sp, x1", want: "3f000091"},
+		// i=36
+		{in: "mov x0, sp", want: "e0030091"},
+		// i=37
+		{in: "mov w0, #-1", want: "00008012"},
+		// i=38
+		{in: "mov x0, #-1", want: "00008092"},
+		// i=39
+		{in: "mov x0, #0xffff0000", want: "e0ffbfd2"},
+		// i=40
+		{in: "mov x0, #0x0f0f0f0f0f0f0f0f", want: "e0cf00b2"},
+		// i=41
+		{in: "mov w0, #0x12340000", want: "8046a252"},
+		// i=42
+		{in: "mov x0, #-0x10001", want: "2000a092"},
+		// i=43
+		{in: "movz x0, #1, lsl #32", want: "2000c0d2"},
+		// i=44
+		{in: "movk x0, #0xbeef, lsl #16", want: "e0ddb7f2"},
+		// i=45
+		{in: "movn w0, #0", want: "00008012"},
+		// i=46
+		{in: "lsl x0, x1, #3", want: "20f07dd3"},
+		// i=47
+		{in: "lsr w0, w1, #31", want: "207c1f53"},
+		// i=48
+		{in: "asr x0, x1, #63", want: "20fc7f93"},
+		// i=49
+		{in: "ror x0, x1, #7", want: "201cc193"},
+		// i=50
+		{in: "ror w0, w1, #0", want: "20008113"},
+		// i=51
+		{in: "lsl x0, x1, x2", want: "2020c29a"},
+		// i=52
+		{in: "lsrv w0, w1, w2", want: "2024c21a"},
+		// i=53
+		{in: "ubfx x0, x1, #4, #8", want: "202c44d3"},
+		// i=54
+		{in: "sbfx w0, w1, #0, #1", want: "20000013"},
+		// i=55
+		{in: "ubfiz x0, x1, #4, #8", want: "201c7cd3"},
+		// i=56
+		{in: "sbfiz w0, w1, #31, #1", want: "20000113"},
+		// i=57
+		{in: "bfi x0, x1, #8, #16", want: "203c78b3"},
+		// i=58
+		{in: "bfxil w0, w1, #3, #5", want: "201c0333"},
+		// i=59
+		{in: "ubfm x0, x1, #1, #2", want: "200841d3"},
+		// i=60
+		{in: "bfm w0, w1, #4, #3", want: "200c0433"},
+		// i=61
+		{in: "extr x0, x1, x2, #7", want: "201cc293"},
+		// i=62
+		{in: "sxtb x0, w1", want: "201c4093"},
+		// i=63
+		{in: "sxth w0, w1", want: "203c0013"},
+		// i=64
+		{in: "sxtw x0, w1", want: "207c4093"},
+		// i=65
+		{in: "uxtb w0, w1", want: "201c0053"},
+		// i=66
+		{in: "uxth w0, w1", want: "203c0053"},
+		// i=67
+		{in: "rbit x0, x1", want: "2000c0da"},
+		// i=68
+		{in: "rev w0, w1", want: "2008c05a"},
+		// i=69
+		{in: "rev x0, x1", want: "200cc0da"},
+		// i=70
+		{in: "rev16 x0, x1", want: "2004c0da"},
+		// i=71
+		{in: "rev32 x0, x1", want: "2008c0da"},
+		// i=72
+		{in: "clz w0, w1", want: "2010c05a"},
+		// i=73
+		{in: "cls x0, x1", want: "2014c0da"},
+		// i=74
+		{in: "udiv x0, x1, x2", want: "2008c29a"},
+		// i=75
+		{in: "sdiv w0, w1, w2", want: "200cc21a"},
+		// i=76
+		{in: "mul x0, x1, x2", want: "207c029b"},
+		// i=77
+		{in: "mneg w0, w1, w2", want: "20fc021b"},
+		// i=78
+		{in: "madd x0, x1, x2, x3", want: "200c029b"},
+		// i=79
+		{in: "msub w0, w1, w2, w3", want: "208c021b"},
+		// i=80
+		{in: "smull x0, w1, w2", want: "207c229b"},
+		// i=81
+		{in: "umull x0, w1, w2", want: "207ca29b"},
+		// i=82
+		{in: "smaddl x0, w1, w2, x3", want: "200c229b"},
+		// i=83
+		{in: "umsubl x0, w1, w2, x3", want: "208ca29b"},
+		// i=84
+		{in: "smnegl x0, w1, w2", want: "20fc229b"},
+		// i=85
+		{in: "umnegl x0, w1, w2", want: "20fca29b"},
+		// i=86
+		{in: "smulh x0, x1, x2", want: "207c429b"},
+		// i=87
+		{in: "umulh x0, x1, x2", want: "207cc29b"},
+		// i=88
+		{in: "csel x0, x1, x2, eq", want: "2000829a"},
+		// i=89
+		{in: "csinc w0, w1, w2, ne", want: "2014821a"},
+		// i=90
+		{in: "csinv x0, x1, x2, hs", want: "202082da"},
+		// i=91
+		{in: "csneg x0, x1, x2, lo", want: "203482da"},
+		// i=92
+		{in: "cset x0, eq", want: "e0179f9a"},
+		// i=93
+		{in: "csetm w0, lt", want: "e0a39f5a"},
+		// i=94
+		{in: "cinc x0, x1, gt", want: "20d4819a"},
+		// i=95
+		{in: "cinv w0, w1, le", want: "20c0815a"},
+		// i=96
+		{in: "cneg x0, x1, mi", want: "205481da"},
+		// i=97
+		{in: "ccmp x0, x1, #4, ne", want: "041041fa"},
+		// i=98
+		{in: "ccmp w0, #31, #15, al", want: "0fe85f7a"},
+		// i=99
+		{in: "ccmn x0, #0, #0, eq", want: "000840ba"},
+		// i=100
+		{in: "ldr x0, [x1]", want: "200040f9"},
+		// i=101
+		{in: "ldr w0, [x1, #4]", want: "200440b9"},
+		// i=102
+		{in: "ldr x0, [sp, #32760]", want: "e0ff7ff9"},
+		// i=103
+		{in: "ldr x0, [x1, #-8]", want: "20805ff8"},
+		// i=104
+		{in: "ldr x0, [x1, #3]", want: "203040f8"},
+		// i=105
+		{in: "ldr x0, [x1, #16]!", want: "200c41f8"},
+		// i=106
+		{in: "ldr x0, [sp, #-16]!", want: "e00f5ff8"},
+		// i=107
+		{in: "str x0, [sp, #-16]!", want: "e00f1ff8"},
+		// i=108
+		{in: "ldr x0, [x1], #8", want: "208440f8"},
+		// i=109
+		{in: "str w0, [x1], #-4", want: "20c41fb8"},
+		// i=110
+		{in: "ldrb w0, [x1, #4095]", want: "20fc7f39"},
+		// i=111
+		{in: "ldrsb x0, [x1]", want: "20008039"},
+		// i=112
+		{in: "ldrsb w0, [x1, #1]", want: "2004c039"},
+		// i=113
+		{in: "ldrsh x0, [x1, #2]", want: "20048079"},
+		// i=114
+		{in: "ldrsh w0, [x1], #2", want: "2024c078"},
+		// i=115
+		{in: "ldrh w0, [x1, #-1]", want: "20f05f78"},
+		// i=116
+		{in: "strh w0, [x1, #2]!", want: "202c0078"},
+		// i=117
+		{in: "strb w0, [x1]", want: "20000039"},
+		// i=118
+		{in: "ldrsw x0, [x1, #4]", want: "200480b9"},
+		// i=119
+		{in: "ldrsw x0, [x1, x2, lsl #2]", want: "2078a2b8"},
+		// i=120
+		{in: "ldr x0, [x1, x2]", want: "206862f8"},
+		// i=121
+		{in: "ldr x0, [x1, x2, lsl #3]", want: "207862f8"},
+		// i=122
+		{in: "ldr x0, [x1, x2, lsl #0]", want: "206862f8"},
+		// i=123
+		{in: "ldr w0, [x1, w2, sxtw #2]", want: "20d862b8"},
+		// i=124
+		{in: "ldr w0, [x1, w2, uxtw]", want: "204862b8"},
+		// i=125
+		{in: "ldr x0, [x1, x2, sxtx]", want: "20e862f8"},
+		// i=126
+		{in: "ldr x0, [sp, w2, uxtw #3]", want: "e05b62f8"},
+		// i=127
+		{in: "ldrb w0, [x1, x2]", want: "20686238"},
+		// i=128
+		{in: "ldrb w0, [x1, x2, lsl #0]", want: "20786238"},
+		// i=129
+		{in: "ldrb w0, [x1, w2, sxtw]", want: "20c86238"},
+		// i=130
+		{in: "ldrh w0, [x1, x2, lsl #1]", want: "20786278"},
+		// i=131
+		{in: "str x0, [x1, x2, lsl #3]", want: "207822f8"},
+		// i=132
+		{in: "ldur x0, [x1, #-1]", want: "20f05ff8"},
+		// i=133
+		{in: "stur w0, [x1, #255]", want: "20f00fb8"},
+		// i=134
+		{in: "ldurb w0, [x1]", want: "20004038"},
+		// i=135
+		{in: "ldursh x0, [x1, #-2]", want: "20e09f78"},
+		// i=136
+		{in: "ldursw x0, [x1, #3]", want: "203080b8"},
+		// i=137
+		{in: "sturh w0, [x1, #1]", want: "20100078"},
+		// i=138
+		{in: "ldp x0, x1, [sp]", want: "e00740a9"},
+		// i=139
+		{in: "ldp x0, x1, [sp, #16]", want: "e00741a9"},
+		// i=140
+		{in: "stp x29, x30, [sp, #-16]!", want: "fd7bbfa9"},
+		// i=141
+		{in: "ldp x29, x30, [sp], #16", want: "fd7bc1a8"},
+		// i=142
+		{in: "ldp w0, w1, [x2, #-256]", want: "40046029"},
+		// i=143
+		{in: "stp w0, w1, [x2, #252]", want: "40841f29"},
+		// i=144
+		{in: "ldpsw x0, x1, [x2, #8]", want: "40044169"},
+		// i=145
+		{in: "ldpsw x0, x1, [x2], #-8", want: "4004ff68"},
+		// i=146
+		{in: "ldp x0, x1, [x2, #504]!", want: "4084dfa9"},
+		// i=147
+		{in: "ldxr x0, [x1]", want: "207c5fc8"},
+		// i=148
+		{in: "ldxr w0, [sp]", want: "e07f5f88"},
+		// i=149
+		{in: "ldaxr x0, [x1]", want: "20fc5fc8"},
+		// i=150
+		{in: "ldar w0, [x1]", want: "20fcdf88"},
+		// i=151
+		{in: "stlr x0, [x1]", want: "20fc9fc8"},
+		// i=152
+		{in: "ldxrb w0, [x1]", want: "207c5f08"},
+		// i=153
+		{in: "ldaxrh w0, [x1]", want: "20fc5f48"},
+		// i=154
+		{in: "stlrb w0, [x1]", want: "20fc9f08"},
+		// i=155
+		{in: "ldarh w0, [x1]", want: "20fcdf48"},
+		// i=156
+		{in: "stxr w2, x0, [x1]", want: "207c02c8"},
+		// i=157
+		{in: "stlxr w2, w0, [x1]", want: "20fc0288"},
+		// i=158
+		{in: "stxrb w2, w0, [x1]", want: "207c0208"},
+		// i=159
+		{in: "stlxrh w2, w0, [x1]", want: "20fc0248"},
+		// i=160
+		{in: "ldxp x0, x1, [x2]", want: "40047fc8"},
+		// i=161
+		{in: "ldaxp w0, w1, [x2]", want: "40847f88"},
+		// i=162
+		{in: "stxp w3, x0, x1, [x2]", want: "400423c8"},
+		// i=163
+		{in: "stlxp w3, w0, w1, [x2]", want: "40842388"},
+		// i=164
+		{in: "ldxr x0, [x1, #0]", want: "207c5fc8"},
+		// i=165
+		{in: "b #8", want: "02000014"},
+		// i=166
+		{in: "b.ne #-4", want: "e1ffff54"},
+		// i=167
+		{in: "cbz x0, #1048572", want: "e0ff7fb4"},
+		// i=168
+		{in: "tbnz x1, #40, #-32768", want: "010044b7"},
+		// i=169
+		{in: "bl #-134217728", want: "00000096"},
+		// i=170
+		{in: "adr x0, #-1048576", want: "00008010"},
+		// i=171
+		{in: "adrp x0, #4096", want: "000000b0"},
+		// i=172
+		{in: "ldr x0, #16", want: "80000058"},
+		// i=173
+		{in: "br x0", want: "00001fd6"},
+		// i=174
+		{in: "blr x1", want: "20003fd6"},
+		// i=175
+		{in: "ret", want: "c0035fd6"},
+		// i=176
+		{in: "ret x2", want: "40005fd6"},
+		// i=177
+		{in: "svc #0", want: "010000d4"},
+		// i=178
+		{in: "hvc #1", want: "220000d4"},
+		// i=179
+		{in: "smc #0xffff", want: "e3ff1fd4"},
+		// i=180
+		{in: "brk #1", want: "200020d4"},
+		// i=181
+		{in: "hlt #2", want: "400040d4"},
+		// i=182
+		{in: "hint #7", want: "ff2003d5"},
+		// i=183
+		{in: "nop", want: "1f2003d5"},
+		// i=184
+		{in: "yield", want: "3f2003d5"},
+		// i=185
+		{in: "wfe", want: "5f2003d5"},
+		// i=186
+		{in: "wfi", want: "7f2003d5"},
+		// i=187
+		{in: "sev", want: "9f2003d5"},
+		// i=188
+		{in: "sevl", want: "bf2003d5"},
+		// i=189
+		{in: "eret", want: "e0039fd6"},
+		// i=190
+		{in: "clrex", want: "5f3f03d5"},
+		// i=191
+		{in: "clrex #3", want: "5f3303d5"},
+		// i=192
+		{in: "dmb ish", want: "bf3b03d5"},
+		// i=193
+		{in: "dmb ishld", want: "bf3903d5"},
+		// i=194
+		{in: "dsb sy", want: "9f3f03d5"},
+		// i=195
+		{in: "dsb #12", want: "9f3c03d5"},
+		// i=196
+		{in: "isb", want: "df3f03d5"},
+		// i=197
+		{in: "isb sy", want: "df3f03d5"},
+		// i=198
+		{in: "mrs x0, tpidr_el0", want: "40d03bd5"},
+		// i=199
+		{in: "msr tpidr_el0, x1", want: "41d01bd5"},
+		// i=200
+		{in: "mrs x0, nzcv", want: "00423bd5"},
+		// i=201
+		{in: "msr daif, x0", want: "20421bd5"},
+		// i=202
+		{in: "mrs x0, s3_0_c15_c2_0", want: "00f238d5"},
+		// i=203
+		{in: "mrs x0, cntvct_el0", want: "40e03bd5"},
+		// i=204
+		{in: "mrs x0, sctlr_el1", want: "001038d5"},
+		// i=205
+		{in: "msr fpsr, x0", want: "20441bd5"},
+		// i=206
+		{in: "mrs x0, mpidr_el1", want: "a00038d5"},
+	}
+
+	for i, g := range golden {
+		got, err := asmtest.Text(Encoder, g.in)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		want, err := hex.DecodeString(g.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("i=%d: encoding mismatch of %q; expected %x, got %x", i, g.in, want, got)
+		}
+	}
+}
+
+func TestEncodeLabels(t *testing.T) {
+	const src = `
+main:
+	stp x29, x30, [sp, #-16]!
+	mov x29, sp
+	adr x0, msg
+	bl puts
+	cmp x0, #0
+	b.ne fail
+	ldr x1, =0x123456789
+	ldr w2, value
+	cbz x1, done
+	tbnz w2, #0, done
+fail:
+	mov x0, #1
+done:
+	ldp x29, x30, [sp], #16
+	ret
+puts:
+	ldrb w1, [x0], #1
+	cbnz w1, puts
+	ret
+	pool
+value:
+	dd 42
+msg:
+	db "hi", 0
+`
+	want := []string{
+		"fd7bbfa9",         // stp x29, x30, [sp, #-16]!
+		"fd030091",         // mov x29, sp
+		"20020010",         // adr x0, msg
+		"0a000094",         // bl puts
+		"1f0000f1",         // cmp x0, #0
+		"a1000054",         // b.ne fail
+		"41010058",         // ldr x1, 0x40
+		"62010018",         // ldr w2, value
+		"610000b4",         // cbz x1, done
+		"42000037",         // tbnz w2, #0, done
+		"200080d2",         // mov x0, #1
+		"fd7bc1a8",         // ldp x29, x30, [sp], #16
+		"c0035fd6",         // ret
+		"01144038",         // ldrb w1, [x0], #1
+		"e1ffff35",         // cbnz w1, puts
+		"c0035fd6",         // ret
+		"8967452301000000", // 0x123456789
+		"2a000000",         // 42
+		"686900",           // "hi", 0
+	}
+	got, err := asmtest.Text(Encoder, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := hex.DecodeString(strings.Join(want, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, w) {
+		t.Errorf("encoding mismatch; expected %x, got %x", w, got)
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	golden := []struct {
+		in   string
+		want string
+	}{
+		// i=0
+		{in: "and x0, x1, #0", want: `1:1: arm64.encoding.bitmask: immediate 0x0 of "and" cannot be encoded as a bitmask immediate`},
+		// i=1
+		{in: "orr w0, w1, #0x12345", want: `1:1: arm64.encoding.bitmask: immediate 0x12345 of "orr" cannot be encoded as a bitmask immediate`},
+		// i=2
+		{in: "and x0, x1, #-1", want: `1:1: arm64.encoding.bitmask: immediate 0xFFFFFFFFFFFFFFFF of "and" cannot be encoded as a bitmask immediate`},
+		// i=3
+		{in: "eor w0, w1, #0xffffffff", want: `1:1: arm64.encoding.bitmask: immediate 0xFFFFFFFF of "eor" cannot be encoded as a bitmask immediate`},
+		// i=4
+		{in: "tst x0, #0x5", want: `1:1: arm64.encoding.bitmask: immediate 0x5 of "tst" cannot be encoded as a bitmask immediate`},
+		// i=5
+		{in: "mov x0, #0x12345678", want: `1:1: arm64.encoding.mov: immediate 0x12345678 of "mov" cannot be encoded using movz, movn or a bitmask immediate`},
+		// i=6
+		{in: "add x0, x1, #0x1001000", want: `1:1: arm64.encoding.arithImm: immediate 16781312 of "add" out of range; expected 12-bit value, optionally shifted left by 12`},
+		// i=7
+		{in: "add x0, xzr, #1", want: `1:1: arm64.encoding.gpr: invalid register xzr of "add"`},
+		// i=8
+		{in: "and sp, x1, x2", want: `1:1: arm64.encoding.gpr: invalid register sp of "and"`},
+		// i=9
+		{in: "ands sp, x1, #1", want: `1:1: arm64.encoding.gpr: invalid register sp of "ands"`},
+		// i=10
+		{in: "ldr x0, [x1, #32768]", want: `1:1: arm64.encoding.loadStore: offset 32768 of "ldr" out of range; expected multiple of 8 in range [0, 32760] or offset in range [-256, 255]`},
+		// i=11
+		{in: "ldr x0, [x1, #-257]!", want: `1:1: arm64.encoding.indexed: offset -257 of "ldr" out of range [-256, 255]`},
+		// i=12
+		{in: "ldr x0, [x0], #8", want: `1:1: arm64.encoding.checkWriteback: unpredictable "ldr"; base register x0 is both updated and transferred`},
+		// i=13
+		{in: "ldp x0, x1, [x0, #16]!", want: `1:1: arm64.encoding.checkWriteback: unpredictable "ldp"; base register x0 is both updated and transferred`},
+		// i=14
+		{in: "ldr x0, [x1, x2, lsl #2]", want: `1:1: arm64.encoding.loadStoreReg: invalid shift amount 2 of index register of "ldr"; expected 0 or 3`},
+		// i=15
+		{in: "ldr w0, [x1, x2, sxtw]", want: `1:1: arm64.encoding.loadStoreReg: invalid index register x2 of "ldr"; expected 32-bit register`},
+		// i=16
+		{in: "ldr x0, [x1, w2]", want: `1:1: arm64.encoding.loadStoreReg: invalid index register w2 of "ldr"; expected 64-bit register`},
+		// i=17
+		{in: "ldp x0, x1, [x2, #4]", want: `1:1: arm64.encoding.loadStorePair: offset 4 of "ldp" out of range; expected multiple of 8 in range [-512, 504]`},
+		// i=18
+		{in: "ldur x0, [x1, #256]", want: `1:1: arm64.encoding.loadStore: offset 256 of "ldur" out of range [-256, 255]`},
+		// i=19
+		{in: "ldr x0, [w1]", want: `1:1: arm64.encoding.memOffset: invalid memory operand of "ldr"; expected base register and optional offset`},
+		// i=20
+		{in: "ldxr x0, [x1, #8]", want: `1:1: arm64.encoding.exclusive: invalid offset 8 of "ldxr"; expected 0`},
+		// i=21
+		{in: "b.ne 2", want: `1:1: arm64.encoding.offset: unaligned target 0x2 of "b.ne"`},
+		// i=22
+		{in: "b 0x8000000", want: `1:1: arm64.encoding.offset: target 0x8000000 of "b" out of range; offset 134217728 not in range [-134217728, 134217724]`},
+		// i=23
+		{in: "cbz x0, 0x100000", want: `1:1: arm64.encoding.offset: target 0x100000 of "cbz" out of range; offset 1048576 not in range [-1048576, 1048572]`},
+		// i=24
+		{in: "ubfx x0, x1, #60, #8", want: `1:1: arm64.encoding.imm: immediate 8 of "ubfx" out of range [1, 4]`},
+		// i=25
+		{in: "movk x0, #1, lsl #12", want: `1:1: arm64.encoding.shiftedImm: shift amount 12 of immediate of "movk" not a multiple of 16`},
+		// i=26
+		{in: "add x0, x1, x2, sxtw", want: `1:1: arm64.encoding.arithExt: invalid register x2 of "add"; expected 32-bit register`},
+		// i=27
+		{in: "cset x0, al", want: `1:1: arm64.encoding.condSelect: invalid condition al of "cset"`},
+		// i=28
+		{in: "csinc x0, x1, x2, foo", want: `1:1: arm64.encoding.cond: invalid condition foo of "csinc"`},
+		// i=29
+		{in: "dmb foo", want: `1:1: arm64.encoding.barrier: invalid barrier option "foo" of "dmb"`},
+		// i=30
+		{in: "sxtw w0, w1", want: `1:1: parser.parseInst: arch.OpTable.Validate: invalid operands of "sxtw"; expected "gpr64, gpr32"; got [identifier]: "sxtw"`},
+	}
+
+	for i, g := range golden {
+		_, err := asmtest.Text(Encoder, g.in)
+		if err == nil {
+			t.Errorf("i=%d: expected error %q, got nil", i, g.want)
+			continue
+		}
+		if got := err.Error(); got != g.want {
+			t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
@@ -0,0 +1,583 @@
+package arm64
+
+import (
+	"fmt"
+
+	arm64arch "github.com/mewlang/asm/arch/arm64"
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/symtab"
+)
+
+// Arithmetic instructions mapped to their op and S bits.
+var arithOps = map[string][2]uint32{
+	"add":  {0, 0},
+	"adds": {0, 1},
+	"sub":  {1, 0},
+	"subs": {1, 1},
+}
+
+// A logicalOp specifies the opc field and the N bit of a logical instruction,
+// which inverts the second source operand.
+type logicalOp struct {
+	opc, n uint32
+}
+
+// Logical instructions mapped to their opc field and N bit.
+var logicalOps = map[string]logicalOp{
+	"and":  {0, 0},
+	"bic":  {0, 1},
+	"orr":  {1, 0},
+	"orn":  {1, 1},
+	"eor":  {2, 0},
+	"eon":  {2, 1},
+	"ands": {3, 0},
+	"bics": {3, 1},
+}
+
+// shiftTypes maps from shift operator to shift type.
+var shiftTypes = map[string]uint32{
+	"lsl": 0,
+	"lsr": 1,
+	"asr": 2,
+	"ror": 3,
+}
+
+// extendOps maps from extend operator to the option field of extended register
+// operands.
+var extendOps = map[string]uint32{
+	"uxtb": 0,
+	"uxth": 1,
+	"uxtw": 2,
+	"uxtx": 3,
+	"sxtb": 4,
+	"sxth": 5,
+	"sxtw": 6,
+	"sxtx": 7,
+}
+
+// arith encodes an arithmetic instruction with the given op and S bits; which
+// computes rn + op2 or rn - op2. The second source operand is an immediate, a
+// shifted register or an extended register. Registers are extended, rather than
+// shifted, if the stack pointer is used.
+func (e *encoding) arith(op, s uint32, rd, rn, op2 ast.Arg) (uint32, error) {
+	sf := sf(rd)
+	w := sf<<31 | op<<30 | s<<29
+	switch x := op2.(type) {
+	case ast.Reg:
+		if isSP(rd) || isSP(rn) {
+			return e.arithExt(w, rd, rn, x, 2|sf, 0)
+		}
+		return w | 0x0B000000 | e.reg(x)<<16 | e.reg(rn)<<5 | e.reg(rd), nil
+	case *ast.ShiftedReg:
+		if _, ok := x.X.(*ast.Imm); ok {
+			break
+		}
+		option, ok := extendOps[x.Op]
+		if x.Op == "lsl" && (isSP(rd) || isSP(rn)) {
+			option, ok = 2|sf, true
+		}
+		if ok {
+			var amount int64
+			if x.Amount != nil {
+				var err error
+				if amount, err = e.imm(x.Amount, 0, 4); err != nil {
+					return 0, err
+				}
+			}
+			return e.arithExt(w, rd, rn, x.X, option, uint32(amount))
+		}
+		typ, amount, err := e.shift(x, false)
+		if err != nil {
+			return 0, err
+		}
+		return w | 0x0B000000 | typ<<22 | e.reg(x.X)<<16 | amount<<10 | e.reg(rn)<<5 | e.reg(rd), nil
+	}
+	return e.arithImm(op, s, rd, rn, op2)
+}
+
+// arithExt encodes an arithmetic instruction with an extended register operand
+// rm, as given by the sf, op and S bits of w.
+func (e *encoding) arithExt(w uint32, rd, rn, rm ast.Arg, option, amount uint32) (uint32, error) {
+	// The uxtx and sxtx extensions of 64-bit instructions use a 64-bit
+	// register, and the other extensions a 32-bit register.
+	if want64 := option&3 == 3; sf(rd) == 1 && (sf(rm) == 1) != want64 {
+		want := "32-bit"
+		if want64 {
+			want = "64-bit"
+		}
+		return 0, fmt.Errorf("arm64.encoding.arithExt: invalid register %s of %q; expected %s register", arm64arch.Arch.RegName(rm.(ast.Reg)), e.inst.Op, want)
+	}
+	rdNum := e.gpr(rd, w>>29&1 == 0)
+	return w | 0x0B200000 | e.reg(rm)<<16 | option<<13 | amount<<10 | e.regSP(rn)<<5 | rdNum, nil
+}
+
+// arithImm encodes an arithmetic instruction with an immediate operand; a
+// 12-bit value which is optionally shifted left by 12 bits. Negative values are
+// encoded by inverting the operation; e.g. add x0, x1, #-1 is encoded as sub
+// x0, x1, #1.
+func (e *encoding) arithImm(op, s uint32, rd, rn, x ast.Arg) (uint32, error) {
+	var imm, sh int64
+	if shift, ok := x.(*ast.ShiftedReg); ok {
+		amount, err := e.shiftedImm(shift, 12)
+		if err != nil {
+			return 0, err
+		}
+		if imm, err = e.imm(shift.X, 0, 0xFFF); err != nil {
+			return 0, err
+		}
+		sh = amount / 12
+	} else if op == 0 && s == 0 && asm.Relocated(e.syms, x, false) {
+		// Offset of the address within its 4 KB page; as of add following
+		// adrp.
+		if _, err := e.reloc(symtab.ARM64PageOff12, false, x); err != nil {
+			return 0, err
+		}
+	} else {
+		val, err := ast.Eval(x, e.syms)
+		if err != nil {
+			return 0, err
+		}
+		imm = val
+		if imm < 0 {
+			imm, op = -imm, op^1
+		}
+		switch {
+		case imm <= 0xFFF:
+		case imm&0xFFF == 0 && imm>>12 <= 0xFFF:
+			imm, sh = imm>>12, 1
+		default:
+			return 0, fmt.Errorf("arm64.encoding.arithImm: immediate %d of %q out of range; expected 12-bit value, optionally shifted left by 12", val, e.inst.Op)
+		}
+	}
+	rdNum := e.gpr(rd, s == 0)
+	return sf(rd)<<31 | op<<30 | s<<29 | 0x11000000 | uint32(sh)<<22 | uint32(imm)<<10 | e.regSP(rn)<<5 | rdNum, nil
+}
+
+// shiftedImm returns the shift amount of the shifted immediate x, which must be
+// a left shift by a multiple of the given step.
+func (e *encoding) shiftedImm(x *ast.ShiftedReg, step int64) (int64, error) {
+	if x.Op != "lsl" {
+		return 0, fmt.Errorf("arm64.encoding.shiftedImm: invalid shift operator %q of immediate of %q; expected lsl", x.Op, e.inst.Op)
+	}
+	if x.Amount == nil {
+		return 0, fmt.Errorf("arm64.encoding.shiftedImm: missing shift amount of immediate of %q", e.inst.Op)
+	}
+	max := step
+	if step == 16 {
+		max = int64(width(e.inst.Args[0])) - 16
+	}
+	amount, err := e.imm(x.Amount, 0, max)
+	if err != nil {
+		return 0, err
+	}
+	if amount%step != 0 {
+		return 0, fmt.Errorf("arm64.encoding.shiftedImm: shift amount %d of immediate of %q not a multiple of %d", amount, e.inst.Op, step)
+	}
+	return amount, nil
+}
+
+// shift returns the shift type and shift amount of the shifted register x. The
+// ror shift type is valid if ror is true.
+func (e *encoding) shift(x *ast.ShiftedReg, ror bool) (typ, amount uint32, err error) {
+	typ, ok := shiftTypes[x.Op]
+	if !ok || (typ == 3 && !ror) {
+		return 0, 0, fmt.Errorf("arm64.encoding.shift: invalid shift operator %q of %q", x.Op, e.inst.Op)
+	}
+	if x.Amount == nil {
+		return 0, 0, fmt.Errorf("arm64.encoding.shift: missing shift amount of %s in %q", x.Op, e.inst.Op)
+	}
+	val, err := e.imm(x.Amount, 0, int64(width(x.X))-1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return typ, uint32(val), nil
+}
+
+// isShiftedReg returns true if x is a shifted register, and false otherwise.
+func isShiftedReg(x ast.Arg) bool {
+	shift, ok := x.(*ast.ShiftedReg)
+	if !ok {
+		return false
+	}
+	_, ok = shift.X.(ast.Reg)
+	return ok
+}
+
+// logical encodes a logical instruction with the given opc field and N bit. The
+// second source operand is a bitmask immediate or a shifted register.
+func (e *encoding) logical(opc, n uint32, rd, rn, op2 ast.Arg) (uint32, error) {
+	sf := sf(rd)
+	w := sf<<31 | opc<<29
+	switch x := op2.(type) {
+	case ast.Reg:
+		return w | 0x0A000000 | n<<21 | e.reg(x)<<16 | e.reg(rn)<<5 | e.reg(rd), nil
+	case *ast.ShiftedReg:
+		typ, amount, err := e.shift(x, true)
+		if err != nil {
+			return 0, err
+		}
+		return w | 0x0A000000 | typ<<22 | n<<21 | e.reg(x.X)<<16 | amount<<10 | e.reg(rn)<<5 | e.reg(rd), nil
+	}
+	imm, err := e.bitmask(op2, n == 1)
+	if err != nil {
+		return 0, err
+	}
+	// The destination of flag setting instructions is the zero register.
+	rdNum := e.gpr(rd, opc != 3)
+	return w | 0x12000000 | imm<<10 | e.reg(rn)<<5 | rdNum, nil
+}
+
+// bitmask evaluates x and returns the N:immr:imms encoding of its value as a
+// bitmask immediate of the width of the destination register. The value is
+// inverted first if invert is true.
+func (e *encoding) bitmask(x ast.Arg, invert bool) (uint32, error) {
+	width := int(width(e.inst.Args[0]))
+	val, err := ast.EvalWidth(x, e.syms, width)
+	if err != nil {
+		return 0, err
+	}
+	u := uint64(val)
+	if invert {
+		u = ^u
+	}
+	mask := ^uint64(0) >> uint(64-width)
+	imm, ok := arm64arch.EncodeLogicalImm(u&mask, width)
+	if !ok {
+		return 0, fmt.Errorf("arm64.encoding.bitmask: immediate 0x%X of %q cannot be encoded as a bitmask immediate", uint64(val)&mask, e.inst.Op)
+	}
+	return imm, nil
+}
+
+// Move wide instructions mapped to their opc fields.
+var moveWideOps = map[string]uint32{
+	"movn": 0,
+	"movz": 2,
+	"movk": 3,
+}
+
+// moveWide encodes a move wide instruction with the given opc field, which
+// moves a 16-bit immediate shifted left by 0, 16, 32 or 48 bits. Plain
+// immediates with a single non-zero 16-bit halfword are shifted implicitly;
+// e.g. movz x0, #0x10000 is movz x0, #1, lsl #16.
+func (e *encoding) moveWide(opc uint32, rd, x ast.Arg) (uint32, error) {
+	var imm, hw int64
+	if shift, ok := x.(*ast.ShiftedReg); ok {
+		amount, err := e.shiftedImm(shift, 16)
+		if err != nil {
+			return 0, err
+		}
+		if imm, err = e.imm(shift.X, 0, 0xFFFF); err != nil {
+			return 0, err
+		}
+		hw = amount / 16
+	} else {
+		val, err := ast.Eval(x, e.syms)
+		if err != nil {
+			return 0, err
+		}
+		for hw = 0; hw < int64(width(rd))/16; hw++ {
+			if val&^(0xFFFF<<uint(16*hw)) == 0 {
+				break
+			}
+		}
+		if hw == int64(width(rd))/16 {
+			return 0, fmt.Errorf("arm64.encoding.moveWide: immediate %d of %q out of range; expected 16-bit value, optionally shifted left by a multiple of 16", val, e.inst.Op)
+		}
+		imm = val >> uint(16*hw)
+	}
+	return sf(rd)<<31 | opc<<29 | 0x12800000 | uint32(hw)<<21 | uint32(imm)<<5 | e.reg(rd), nil
+}
+
+// mov encodes the mov alias. Register moves are encoded as orr with the zero
+// register, or as add with an immediate of 0 if the stack pointer is used.
+// Immediate moves are encoded as movz, movn or orr with a bitmask immediate, in
+// order of preference; like LLVM.
+func (e *encoding) mov(rd, x ast.Arg) (uint32, error) {
+	if rm, ok := x.(ast.Reg); ok {
+		if isSP(rd) || isSP(rm) {
+			return e.arithImm(0, 0, rd, rm, ast.Int(0))
+		}
+		return e.logical(logicalOps["orr"].opc, 0, rd, zr(rd), rm)
+	}
+	width := width(rd)
+	val, err := ast.EvalWidth(x, e.syms, int(width))
+	if err != nil {
+		return 0, err
+	}
+	u, mask := uint64(val), ^uint64(0)>>(64-width)
+	if !isSP(rd) {
+		if hw, ok := halfword(u&mask, width); ok {
+			return e.moveWide(moveWideOps["movz"], rd, &ast.ShiftedReg{X: ast.Int(u >> (16 * hw) & 0xFFFF), Op: "lsl", Amount: ast.Int(16 * hw)})
+		}
+		if hw, ok := halfword(^u&mask, width); ok {
+			return e.moveWide(moveWideOps["movn"], rd, &ast.ShiftedReg{X: ast.Int(^u >> (16 * hw) & 0xFFFF), Op: "lsl", Amount: ast.Int(16 * hw)})
+		}
+	}
+	if _, ok := arm64arch.EncodeLogicalImm(u&mask, int(width)); !ok {
+		return 0, fmt.Errorf("arm64.encoding.mov: immediate 0x%X of %q cannot be encoded using movz, movn or a bitmask immediate", u&mask, e.inst.Op)
+	}
+	return e.logical(logicalOps["orr"].opc, 0, rd, zr(rd), x)
+}
+
+// halfword returns the index of the only 16-bit halfword of val which may be
+// non-zero, and true if present; the index of 0 is 0.
+func halfword(val uint64, width uint) (uint, bool) {
+	for hw := uint(0); hw < width/16; hw++ {
+		if val&^(0xFFFF<<(16*hw)) == 0 {
+			return hw, true
+		}
+	}
+	return 0, false
+}
+
+// carry encodes an add or subtract with carry instruction.
+func (e *encoding) carry(op string, rd, rn, rm ast.Arg) (uint32, error) {
+	o := map[string]uint32{"adc": 0, "adcs": 1, "sbc": 2, "sbcs": 3}[op]
+	return sf(rd)<<31 | o<<29 | 0x1A000000 | e.reg(rm)<<16 | e.reg(rn)<<5 | e.reg(rd), nil
+}
+
+// A bitfieldOp specifies the opc field of a bitfield instruction, and the form
+// of its operands.
+type bitfieldOp struct {
+	opc uint32
+	// Form of the operands; "" for immr and imms, "x" for lsb and width of a
+	// bitfield extract, and "iz" for lsb and width of a bitfield insert.
+	form string
+}
+
+// Bitfield instructions, including aliases, mapped to their opc fields and
+// operand forms.
+var bitfieldOps = map[string]bitfieldOp{
+	"sbfm":  {0, ""},
+	"bfm":   {1, ""},
+	"ubfm":  {2, ""},
+	"sbfx":  {0, "x"},
+	"bfxil": {1, "x"},
+	"ubfx":  {2, "x"},
+	"sbfiz": {0, "iz"},
+	"bfi":   {1, "iz"},
+	"ubfiz": {2, "iz"},
+	"sxtb":  {0, "ext"},
+	"sxth":  {0, "ext"},
+	"sxtw":  {0, "ext"},
+	"uxtb":  {2, "ext"},
+	"uxth":  {2, "ext"},
+}
+
+// bitfield encodes a bitfield instruction, or one of its aliases.
+func (e *encoding) bitfield(o bitfieldOp) (uint32, error) {
+	args := e.inst.Args
+	rd, rn := args[0], args[1]
+	size := int64(width(rd))
+	var immr, imms int64
+	switch o.form {
+	case "ext":
+		// Sign or zero extension of the lower 8, 16 or 32 bits.
+		imms = map[ast.Op]int64{"sxtb": 7, "sxth": 15, "sxtw": 31, "uxtb": 7, "uxth": 15}[e.inst.Op]
+	case "":
+		var err error
+		if immr, err = e.imm(args[2], 0, size-1); err != nil {
+			return 0, err
+		}
+		if imms, err = e.imm(args[3], 0, size-1); err != nil {
+			return 0, err
+		}
+	default:
+		lsb, err := e.imm(args[2], 0, size-1)
+		if err != nil {
+			return 0, err
+		}
+		bits, err := e.imm(args[3], 1, size-lsb)
+		if err != nil {
+			return 0, err
+		}
+		if o.form == "x" {
+			immr, imms = lsb, lsb+bits-1
+		} else {
+			immr, imms = -lsb&(size-1), bits-1
+		}
+	}
+	return e.bitfieldWord(o.opc, rd, rn, immr, imms), nil
+}
+
+// bitfieldWord returns the word of a bitfield instruction with the given opc
+// field and immr and imms fields. The N bit equals the sf bit.
+func (e *encoding) bitfieldWord(opc uint32, rd, rn ast.Arg, immr, imms int64) uint32 {
+	sf := sf(rd)
+	return sf<<31 | opc<<29 | 0x13000000 | sf<<22 | uint32(immr)<<16 | uint32(imms)<<10 | e.reg(rn)<<5 | e.reg(rd)
+}
+
+// shiftInst encodes a shift instruction; either with a register operand, or
+// with an immediate operand as an alias of ubfm, sbfm or extr.
+func (e *encoding) shiftInst(op string) (uint32, error) {
+	args := e.inst.Args
+	rd, rn := args[0], args[1]
+	if rm, ok := args[2].(ast.Reg); ok {
+		return sf(rd)<<31 | 0x1AC00000 | e.reg(rm)<<16 | dp2Ops[op+"v"]<<10 | e.reg(rn)<<5 | e.reg(rd), nil
+	}
+	size := int64(width(rd))
+	amount, err := e.imm(args[2], 0, size-1)
+	if err != nil {
+		return 0, err
+	}
+	switch op {
+	case "lsl":
+		return e.bitfieldWord(bitfieldOps["ubfm"].opc, rd, rn, -amount&(size-1), size-1-amount), nil
+	case "lsr":
+		return e.bitfieldWord(bitfieldOps["ubfm"].opc, rd, rn, amount, size-1), nil
+	case "asr":
+		return e.bitfieldWord(bitfieldOps["sbfm"].opc, rd, rn, amount, size-1), nil
+	}
+	return e.extr(rd, rn, rn, ast.Int(amount))
+}
+
+// extr encodes an extr instruction, which extracts a register from the pair of
+// registers rn:rm starting at the given bit.
+func (e *encoding) extr(rd, rn, rm, lsb ast.Arg) (uint32, error) {
+	imms, err := e.imm(lsb, 0, int64(width(rd))-1)
+	if err != nil {
+		return 0, err
+	}
+	sf := sf(rd)
+	return sf<<31 | 0x13800000 | sf<<22 | e.reg(rm)<<16 | uint32(imms)<<10 | e.reg(rn)<<5 | e.reg(rd), nil
+}
+
+// Data processing instructions with one source register mapped to their opcode
+// fields. The opcode of rev is 2 for 32-bit registers; the same as rev32 of
+// 64-bit registers.
+var dp1Ops = map[string]uint32{
+	"rbit":  0,
+	"rev16": 1,
+	"rev32": 2,
+	"rev":   3,
+	"clz":   4,
+	"cls":   5,
+}
+
+// Data processing instructions with two source registers mapped to their
+// opcode fields.
+var dp2Ops = map[string]uint32{
+	"udiv": 2,
+	"sdiv": 3,
+	"lslv": 8,
+	"lsrv": 9,
+	"asrv": 10,
+	"rorv": 11,
+}
+
+// A dp3Op specifies the op31 field and o0 bit of a data processing instruction
+// with three source registers, and whether the addend is the zero register.
+type dp3Op struct {
+	op31, o0 uint32
+	// The addend is the zero register.
+	noAddend bool
+}
+
+// Data processing instructions with three source registers, including
+// aliases, mapped to their op31 field and o0 bit.
+var dp3Ops = map[string]dp3Op{
+	"madd":   {0, 0, false},
+	"msub":   {0, 1, false},
+	"mul":    {0, 0, true},
+	"mneg":   {0, 1, true},
+	"smaddl": {1, 0, false},
+	"smsubl": {1, 1, false},
+	"smull":  {1, 0, true},
+	"smnegl": {1, 1, true},
+	"smulh":  {2, 0, true},
+	"umaddl": {5, 0, false},
+	"umsubl": {5, 1, false},
+	"umull":  {5, 0, true},
+	"umnegl": {5, 1, true},
+	"umulh":  {6, 0, true},
+}
+
+// multiply encodes a multiply instruction.
+func (e *encoding) multiply(o dp3Op) (uint32, error) {
+	args := e.inst.Args
+	ra := uint32(31)
+	if !o.noAddend {
+		ra = e.reg(args[3])
+	}
+	return sf(args[0])<<31 | 0x1B000000 | o.op31<<21 | e.reg(args[2])<<16 | o.o0<<15 | ra<<10 | e.reg(args[1])<<5 | e.reg(args[0]), nil
+}
+
+// A condSelectOp specifies the op bit and op2 field of a conditional select
+// instruction, and the form of its operands.
+type condSelectOp struct {
+	op, op2 uint32
+	// Form of the operands; "" for rd, rn, rm, cond, "inc" for rd, rn, cond and
+	// "set" for rd, cond. The condition of the aliases is inverted.
+	form string
+}
+
+// Conditional select instructions, including aliases, mapped to their op bit,
+// op2 field and operand form.
+var condSelectOps = map[string]condSelectOp{
+	"csel":  {0, 0, ""},
+	"csinc": {0, 1, ""},
+	"csinv": {1, 0, ""},
+	"csneg": {1, 1, ""},
+	"cinc":  {0, 1, "inc"},
+	"cinv":  {1, 0, "inc"},
+	"cneg":  {1, 1, "inc"},
+	"cset":  {0, 1, "set"},
+	"csetm": {1, 0, "set"},
+}
+
+// condSelect encodes a conditional select instruction, or one of its aliases.
+func (e *encoding) condSelect(o condSelectOp) (uint32, error) {
+	args := e.inst.Args
+	rd := args[0]
+	var rn, rm ast.Arg
+	switch o.form {
+	case "":
+		rn, rm = args[1], args[2]
+	case "inc":
+		rn, rm = args[1], args[1]
+	case "set":
+		rn, rm = zr(rd), zr(rd)
+	}
+	cond, err := e.cond(args[len(args)-1])
+	if err != nil {
+		return 0, err
+	}
+	if o.form != "" {
+		if cond >= arm64arch.AL {
+			return 0, fmt.Errorf("arm64.encoding.condSelect: invalid condition %v of %q", cond, e.inst.Op)
+		}
+		cond = cond.Invert()
+	}
+	return sf(rd)<<31 | o.op<<30 | 0x1A800000 | e.reg(rm)<<16 | uint32(cond)<<12 | o.op2<<10 | e.reg(rn)<<5 | e.reg(rd), nil
+}
+
+// condCompare encodes a ccmp or ccmn instruction, which sets the condition flags
+// to the result of a comparison if the condition holds, and to the given flags
+// otherwise.
+func (e *encoding) condCompare(op string) (uint32, error) {
+	args := e.inst.Args
+	rn := args[0]
+	w := sf(rn)<<31 | 0x3A400000
+	if op == "ccmp" {
+		w |= 1 << 30
+	}
+	if rm, ok := args[1].(ast.Reg); ok {
+		w |= e.reg(rm) << 16
+	} else {
+		imm, err := e.imm(args[1], 0, 31)
+		if err != nil {
+			return 0, err
+		}
+		w |= uint32(imm)<<16 | 1<<11
+	}
+	nzcv, err := e.imm(args[2], 0, 15)
+	if err != nil {
+		return 0, err
+	}
+	cond, err := e.cond(args[3])
+	if err != nil {
+		return 0, err
+	}
+	return w | uint32(cond)<<12 | e.reg(rn)<<5 | uint32(nzcv), nil
+}
@@ -0,0 +1,331 @@
+package arm64
+
+import (
+	"fmt"
+	"math/bits"
+
+	arm64arch "github.com/mewlang/asm/arch/arm64"
+	"github.com/mewlang/asm/ast"
+)
+
+// A loadStoreOp specifies the size and opc fields of a load or store
+// instruction.
+type loadStoreOp struct {
+	// Log2 of the access size in bytes; which is incremented for 64-bit
+	// registers of ldr, str, ldur and stur.
+	size uint32
+	// Loads have an opc field of 1, and stores 0. Sign-extending loads have an
+	// opc field of 2, which is incremented for 32-bit registers.
+	opc uint32
+	// The instruction uses an unscaled 9-bit signed offset.
+	unscaled bool
+}
+
+// Load and store instructions mapped to their size and opc fields.
+var loadStoreOps = map[string]loadStoreOp{
+	"strb":   {0, 0, false},
+	"ldrb":   {0, 1, false},
+	"ldrsb":  {0, 2, false},
+	"strh":   {1, 0, false},
+	"ldrh":   {1, 1, false},
+	"ldrsh":  {1, 2, false},
+	"str":    {2, 0, false},
+	"ldr":    {2, 1, false},
+	"ldrsw":  {2, 2, false},
+	"sturb":  {0, 0, true},
+	"ldurb":  {0, 1, true},
+	"ldursb": {0, 2, true},
+	"sturh":  {1, 0, true},
+	"ldurh":  {1, 1, true},
+	"ldursh": {1, 2, true},
+	"stur":   {2, 0, true},
+	"ldur":   {2, 1, true},
+	"ldursw": {2, 2, true},
+}
+
+// Index register extensions mapped to the option field of load and store
+// instructions.
+var indexOptions = map[string]uint32{
+	"":     3,
+	"lsl":  3,
+	"uxtw": 2,
+	"sxtw": 6,
+	"sxtx": 7,
+}
+
+// loadStore encodes a load or store instruction. The memory operand is given by
+// a base register and an optional offset or index register, and may be pre- or
+// post-indexed. Loads may also be relative to the program counter.
+func (e *encoding) loadStore(o loadStoreOp) (uint32, error) {
+	args := e.inst.Args
+	rt := args[0]
+	size, opc := o.size, o.opc
+	switch {
+	case size == 2 && opc < 2 && sf(rt) == 1:
+		size = 3
+	case opc == 2 && size < 2 && sf(rt) == 0:
+		opc = 3
+	}
+	w := size<<30 | opc<<22 | e.reg(rt)
+	var mem *ast.Mem
+	switch x := args[1].(type) {
+	case *ast.Writeback:
+		// Pre-indexed; e.g. [x0, #16]!
+		rn, off, err := e.memOffset(x.X.(*ast.Mem))
+		if err != nil {
+			return 0, err
+		}
+		return e.indexed(w, rt, rn, off, 3)
+	case *ast.Mem:
+		mem = x
+	default:
+		// Load relative to the program counter.
+		off, err := e.offset(x, 21)
+		if err != nil {
+			return 0, err
+		}
+		// The opc field is 0 for 32-bit registers, 1 for 64-bit registers and
+		// 2 for ldrsw.
+		litOpc := size - 2
+		if opc == 2 {
+			litOpc = 2
+		}
+		return litOpc<<30 | 0x18000000 | uint32(off)>>2&0x7FFFF<<5 | e.reg(rt), nil
+	}
+	if len(args) == 3 {
+		// Post-indexed; e.g. [x0], #16
+		rn, off, err := e.memOffset(mem)
+		if err != nil {
+			return 0, err
+		}
+		if mem.Disp != nil {
+			return 0, fmt.Errorf("arm64.encoding.loadStore: invalid memory operand of post-indexed %q; expected [xn]", e.inst.Op)
+		}
+		if off, err = ast.Eval(args[2], e.syms); err != nil {
+			return 0, err
+		}
+		return e.indexed(w, rt, rn, off, 1)
+	}
+	if mem.Index != nil && !o.unscaled {
+		return e.loadStoreReg(w, size, mem)
+	}
+	rn, off, err := e.memOffset(mem)
+	if err != nil {
+		return 0, err
+	}
+	scale := int64(1) << size
+	switch {
+	case !o.unscaled && off >= 0 && off%scale == 0 && off/scale <= 0xFFF:
+		return w | 0x39000000 | uint32(off/scale)<<10 | rn<<5, nil
+	case off >= -256 && off <= 255:
+		// Unscaled offset; e.g. ldur.
+		return w | 0x38000000 | uint32(off)&0x1FF<<12 | rn<<5, nil
+	case o.unscaled:
+		return 0, fmt.Errorf("arm64.encoding.loadStore: offset %d of %q out of range [-256, 255]", off, e.inst.Op)
+	}
+	return 0, fmt.Errorf("arm64.encoding.loadStore: offset %d of %q out of range; expected multiple of %d in range [0, %d] or offset in range [-256, 255]", off, e.inst.Op, scale, 0xFFF*scale)
+}
+
+// indexed encodes a pre-indexed (idx 3) or post-indexed (idx 1) load or store
+// instruction with the given offset, as given by the size, opc and Rt fields of
+// w.
+func (e *encoding) indexed(w uint32, rt ast.Arg, rn uint32, off int64, idx uint32) (uint32, error) {
+	if off < -256 || off > 255 {
+		return 0, fmt.Errorf("arm64.encoding.indexed: offset %d of %q out of range [-256, 255]", off, e.inst.Op)
+	}
+	if err := e.checkWriteback(rn, rt); err != nil {
+		return 0, err
+	}
+	return w | 0x38000000 | uint32(off)&0x1FF<<12 | idx<<10 | rn<<5, nil
+}
+
+// checkWriteback returns an error if the base register number rn, which is
+// updated by the instruction, is also one of the given transferred registers;
+// the behaviour of which is unpredictable.
+func (e *encoding) checkWriteback(rn uint32, regs ...ast.Arg) error {
+	for _, reg := range regs {
+		if rn != 31 && arm64arch.Num(reg.(ast.Reg)) == rn {
+			return fmt.Errorf("arm64.encoding.checkWriteback: unpredictable %q; base register %s is both updated and transferred", e.inst.Op, arm64arch.Arch.RegName(reg.(ast.Reg)))
+		}
+	}
+	return nil
+}
+
+// loadStoreReg encodes a load or store instruction with an index register, as
+// given by the size, opc and Rt fields of w. The shift amount of the index
+// register is either 0 or log2 of the access size.
+func (e *encoding) loadStoreReg(w, size uint32, mem *ast.Mem) (uint32, error) {
+	base, baseOK := mem.Base.(ast.Reg)
+	index, indexOK := mem.Index.(ast.Reg)
+	option, ok := indexOptions[mem.Extend]
+	if !baseOK || !indexOK || !ok || mem.Disp != nil || mem.Rel || arm64arch.Arch.RegClass(base) != arm64arch.GPR64 || arm64arch.Arch.RegClass(index) == arm64arch.SysReg {
+		return 0, fmt.Errorf("arm64.encoding.loadStoreReg: invalid memory operand of %q; expected [xn, xm{, lsl|sxtx #amount}] or [xn, wm, uxtw|sxtw {#amount}]", e.inst.Op)
+	}
+	// The uxtw and sxtw extensions use a 32-bit index register, and the other
+	// extensions a 64-bit index register.
+	if want32 := option&1 == 0; arm64arch.Is32(index) != want32 {
+		want := "64-bit"
+		if want32 {
+			want = "32-bit"
+		}
+		return 0, fmt.Errorf("arm64.encoding.loadStoreReg: invalid index register %s of %q; expected %s register", arm64arch.Arch.RegName(index), e.inst.Op, want)
+	}
+	// The S bit specifies that the index register is shifted by log2 of the
+	// access size; byte accesses set the S bit if a shift amount of 0 is given
+	// explicitly.
+	var s uint32
+	if mem.Scale > 1 || (mem.Extend != "" && mem.Scale != 0) {
+		amount := uint32(bits.TrailingZeros(uint(mem.Scale)))
+		if amount != 0 && amount != size {
+			return 0, fmt.Errorf("arm64.encoding.loadStoreReg: invalid shift amount %d of index register of %q; expected 0 or %d", amount, e.inst.Op, size)
+		}
+		if amount == size {
+			s = 1
+		}
+	}
+	return w | 0x38200800 | e.reg(index)<<16 | option<<13 | s<<12 | e.regSP(base)<<5, nil
+}
+
+// memOffset returns the base register number and the offset of the memory
+// operand mem, which must not have an index register.
+func (e *encoding) memOffset(mem *ast.Mem) (rn uint32, off int64, err error) {
+	base, ok := mem.Base.(ast.Reg)
+	if !ok || mem.Index != nil || mem.Rel || arm64arch.Arch.RegClass(base) != arm64arch.GPR64 {
+		return 0, 0, fmt.Errorf("arm64.encoding.memOffset: invalid memory operand of %q; expected base register and optional offset", e.inst.Op)
+	}
+	if mem.Disp != nil {
+		if off, err = ast.Eval(mem.Disp, e.syms); err != nil {
+			return 0, 0, err
+		}
+	}
+	return e.regSP(base), off, nil
+}
+
+// A pairOp specifies the opc field and L bit of a load or store pair
+// instruction.
+type pairOp struct {
+	// The opc field for 32-bit registers; which is incremented by 2 for 64-bit
+	// registers.
+	opc uint32
+	// The instruction is a load.
+	l uint32
+}
+
+// Load and store pair instructions mapped to their opc field and L bit.
+var pairOps = map[string]pairOp{
+	"stp":   {0, 0},
+	"ldp":   {0, 1},
+	"ldpsw": {1, 1},
+}
+
+// loadStorePair encodes a load or store pair instruction, whose offset is a
+// multiple of the register size in the range [-64, 63].
+func (e *encoding) loadStorePair(o pairOp) (uint32, error) {
+	args := e.inst.Args
+	rt, rt2 := args[0], args[1]
+	opc, scale := o.opc, int64(4)
+	if o.opc == 0 && sf(rt) == 1 {
+		opc, scale = 2, 8
+	}
+	var (
+		mem *ast.Mem
+		idx uint32 = 2 // signed offset
+	)
+	switch x := args[2].(type) {
+	case *ast.Writeback:
+		mem, idx = x.X.(*ast.Mem), 3
+	case *ast.Mem:
+		mem = x
+	}
+	rn, off, err := e.memOffset(mem)
+	if err != nil {
+		return 0, err
+	}
+	if len(args) == 4 {
+		if mem.Disp != nil {
+			return 0, fmt.Errorf("arm64.encoding.loadStorePair: invalid memory operand of post-indexed %q; expected [xn]", e.inst.Op)
+		}
+		if off, err = ast.Eval(args[3], e.syms); err != nil {
+			return 0, err
+		}
+		idx = 1
+	}
+	if off%scale != 0 || off < -64*scale || off > 63*scale {
+		return 0, fmt.Errorf("arm64.encoding.loadStorePair: offset %d of %q out of range; expected multiple of %d in range [%d, %d]", off, e.inst.Op, scale, -64*scale, 63*scale)
+	}
+	if idx != 2 {
+		if err := e.checkWriteback(rn, rt, rt2); err != nil {
+			return 0, err
+		}
+	}
+	return opc<<30 | 0x28000000 | idx<<23 | o.l<<22 | uint32(off/scale)&0x7F<<15 | e.reg(rt2)<<10 | rn<<5 | e.reg(rt), nil
+}
+
+// An exclusiveOp specifies the fields of an exclusive or acquire-release load
+// or store instruction.
+type exclusiveOp struct {
+	// Log2 of the access size in bytes; or 2 for registers, which is
+	// incremented for 64-bit registers.
+	size uint32
+	// Fields of the instruction; o2 is set for non-exclusive acquire-release
+	// instructions, L for loads, o1 for pairs and o0 for acquire-release
+	// semantics.
+	o2, l, o1, o0 uint32
+	// The instruction is an exclusive store, whose first operand is the status
+	// register.
+	status bool
+}
+
+// Exclusive and acquire-release load and store instructions mapped to their
+// fields.
+var exclusiveOps = map[string]exclusiveOp{
+	"stxrb":  {0, 0, 0, 0, 0, true},
+	"stlxrb": {0, 0, 0, 0, 1, true},
+	"ldxrb":  {0, 0, 1, 0, 0, false},
+	"ldaxrb": {0, 0, 1, 0, 1, false},
+	"stlrb":  {0, 1, 0, 0, 1, false},
+	"ldarb":  {0, 1, 1, 0, 1, false},
+	"stxrh":  {1, 0, 0, 0, 0, true},
+	"stlxrh": {1, 0, 0, 0, 1, true},
+	"ldxrh":  {1, 0, 1, 0, 0, false},
+	"ldaxrh": {1, 0, 1, 0, 1, false},
+	"stlrh":  {1, 1, 0, 0, 1, false},
+	"ldarh":  {1, 1, 1, 0, 1, false},
+	"stxr":   {2, 0, 0, 0, 0, true},
+	"stlxr":  {2, 0, 0, 0, 1, true},
+	"ldxr":   {2, 0, 1, 0, 0, false},
+	"ldaxr":  {2, 0, 1, 0, 1, false},
+	"stlr":   {2, 1, 0, 0, 1, false},
+	"ldar":   {2, 1, 1, 0, 1, false},
+	"stxp":   {2, 0, 0, 1, 0, true},
+	"stlxp":  {2, 0, 0, 1, 1, true},
+	"ldxp":   {2, 0, 1, 1, 0, false},
+	"ldaxp":  {2, 0, 1, 1, 1, false},
+}
+
+// exclusive encodes an exclusive or acquire-release load or store instruction,
+// whose memory operand is a base register without offset.
+func (e *encoding) exclusive(o exclusiveOp) (uint32, error) {
+	args := e.inst.Args
+	rs := uint32(31)
+	if o.status {
+		rs = e.reg(args[0])
+		args = args[1:]
+	}
+	rt, rt2 := e.reg(args[0]), uint32(31)
+	if o.o1 == 1 {
+		rt2 = e.reg(args[1])
+	}
+	size := o.size
+	if size == 2 {
+		size += sf(args[0])
+	}
+	rn, off, err := e.memOffset(args[len(args)-1].(*ast.Mem))
+	if err != nil {
+		return 0, err
+	}
+	if off != 0 {
+		return 0, fmt.Errorf("arm64.encoding.exclusive: invalid offset %d of %q; expected 0", off, e.inst.Op)
+	}
+	return size<<30 | 0x08000000 | o.o2<<23 | o.l<<22 | o.o1<<21 | rs<<16 | o.o0<<15 | rt2<<10 | rn<<5 | rt, nil
+}
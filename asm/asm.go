@@ -0,0 +1,566 @@
+// Package asm implements the assembly of programs into machine code.
+//
+// The architecture specific encoding of instructions is delegated to an
+// Encoder, while the assembler lays out sections, resolves labels and handles
+// directives. Labels are resolved by assembling the program repeatedly until the
+// addresses of all labels are stable; which allows forward references and
+// instructions whose size depends on their operands. Instructions never shrink
+// once they have grown, as reported to the encoder through the Sizer passed as
+// symbol table. The symbols and the layout of sections are kept by a symbol
+// table of the symtab package.
+//
+// References to external symbols, and in relocatable objects references to the
+// addresses of sections, are recorded as relocation records of the sections;
+// to be resolved at link time. The relocated fields of machine code are reported
+// by the encoder through the Relocator passed as symbol table, and hold zero.
+//
+// Local labels, whose names start with a '.', are scoped to the nearest
+// preceding non-local label; e.g. the local label .loop following the label
+// func is named func.loop. References to local labels resolve within the scope
+// of the referencing line.
+//
+// Constants of literal operands, such as =0x12345678, are placed in literal
+// pools; at the next pool directive of the section, or else at the end of the
+// section. The instruction is encoded with each literal operand replaced by the
+// address of its constant.
+package asm
+
+import (
+	"fmt"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/symtab"
+	"github.com/mewlang/asm/token"
+)
+
+// An Encoder encodes the instructions of an architecture into machine code.
+type Encoder interface {
+	// Arch returns the architecture of the instructions.
+	Arch() arch.Arch
+	// Encode returns the machine code of inst, which is located at address pc.
+	// Identifiers, such as labels, are resolved using the provided symbol
+	// table; a Relocator when invoked by the assembler, to which the fields
+	// referring to addresses assigned at link time are reported. On error,
+	// the returned machine code is either nil or placeholder bytes of the
+	// size of the instruction, which are used to lay out the program before
+	// the final pass.
+	Encode(inst *ast.Inst, pc int64, syms ast.SymbolTable) ([]byte, error)
+}
+
+// A Sizer is a symbol table which specifies the minimum size of the machine
+// code of an instruction. The symbol tables passed to Encoder.Encode by the
+// assembler are sizers, whose minimum size is the largest size of the machine
+// code of the instruction in previous passes; except for the first pass, which
+// resolves forward references provisionally. Encoders which select between
+// encodings of different sizes return machine code of at least the minimum size
+// whenever possible, so that instructions never shrink once they have grown and
+// the layout of programs converges.
+type Sizer interface {
+	ast.SymbolTable
+	// MinSize returns the minimum size in bytes of the machine code of the
+	// instruction.
+	MinSize() int
+}
+
+// MinSize returns the minimum size in bytes of the machine code of an
+// instruction, as specified by its symbol table; if a Sizer. It returns 0
+// otherwise.
+func MinSize(syms ast.SymbolTable) int {
+	if s, ok := syms.(Sizer); ok {
+		return s.MinSize()
+	}
+	return 0
+}
+
+// A Compressor is an Encoder which may encode instructions using the compressed
+// encodings of the architecture; such as those of the C extension of RISC-V.
+type Compressor interface {
+	Encoder
+	// Compress returns true if instructions are compressed, and false
+	// otherwise.
+	Compress() bool
+}
+
+// An Object is an assembled program.
+type Object struct {
+	// Arch is the architecture of the machine code.
+	Arch arch.Arch
+	// Sections of the object, in order of first appearance.
+	Sections []*Section
+	// Symbols maps from the name of labels and constants to their values. The
+	// value of a label is its address.
+	Symbols ast.Symbols
+	// Globals lists the names of the global symbols, in order of declaration.
+	Globals []string
+	// Symtab is the symbol table of the object; which records the section of
+	// each label, and the external symbols.
+	Symtab *symtab.Table
+	// Compressed specifies that the machine code may hold compressed
+	// instructions, as encoded by a Compressor.
+	Compressed bool
+}
+
+// Section returns the section with the given name; or nil if not present.
+func (obj *Object) Section(name string) *Section {
+	for _, sect := range obj.Sections {
+		if sect.Name == name {
+			return sect
+		}
+	}
+	return nil
+}
+
+// Image returns the memory image of the given sections, which are sorted by
+// address; the data of each section located at its address, with the gaps
+// between sections filled with zero bytes. The address of the first byte of
+// the image is given by addr.
+func Image(sects []*Section) (addr int64, data []byte) {
+	if len(sects) == 0 {
+		return 0, nil
+	}
+	addr = sects[0].Addr
+	for _, sect := range sects {
+		off := int(sect.Addr - addr)
+		data = append(data, make([]byte, off-len(data))...)
+		data = append(data, sect.Data...)
+	}
+	return addr, data
+}
+
+// A Section is a contiguous block of machine code or data.
+type Section struct {
+	// Name of the section (e.g. .text).
+	Name string
+	// Addr is the address of the first byte of the section.
+	Addr int64
+	// Data holds the contents of the section.
+	Data []byte
+	// Relocs holds the relocation records of the section, in order of offset.
+	Relocs []symtab.Reloc
+}
+
+// An Error represents an assembly error of a given source line.
+type Error struct {
+	// Pos is the position of the line; or the zero value if not known.
+	Pos token.Position
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v: %v", e.Pos, e.Err)
+}
+
+const (
+	// DefaultSection is the name of the section which is active at the start of
+	// a program.
+	DefaultSection = ".text"
+	// sectionAlign is the default alignment in bytes of the start address of
+	// sections.
+	sectionAlign = 4
+	// maxPasses is the maximum number of passes made to lay out a program.
+	maxPasses = 16
+	// maxAlign is the maximum alignment in bytes of align directives.
+	maxAlign = 1 << 16
+)
+
+// A Config specifies the layout of assembled programs.
+type Config struct {
+	// Relocatable specifies that the addresses of sections are assigned at
+	// link time; as of relocatable object files. The sections of relocatable
+	// objects are located at distinct provisional addresses, and references to
+	// their addresses are recorded as relocation records. Relocatable programs
+	// may not contain org directives, and the origin and section alignment of
+	// the configuration are ignored.
+	Relocatable bool
+	// Origin is the start address of the first section of programs without an
+	// org directive.
+	Origin int64
+	// SectionAlign is the alignment in bytes of the start address of sections,
+	// which must be a power of two; or 0 to align sections to 4 bytes.
+	SectionAlign int64
+}
+
+// Assemble assembles prog into machine code using the provided encoder. The
+// positions of the lines of prog are reported in errors using fset, which may be
+// nil. The sections are placed one after another, starting at the address of
+// the org directive of prog; if any.
+func Assemble(fset *token.FileSet, enc Encoder, prog *ast.Program) (obj *Object, err error) {
+	conf := &Config{}
+	return conf.Assemble(fset, enc, prog)
+}
+
+// Assemble assembles prog into machine code using the provided encoder, and the
+// layout specified by conf. The positions of the lines of prog are reported in
+// errors using fset, which may be nil.
+func (conf *Config) Assemble(fset *token.FileSet, enc Encoder, prog *ast.Program) (obj *Object, err error) {
+	a := &assembler{
+		fset:  fset,
+		enc:   enc,
+		prog:  prog,
+		sizes: make(map[*ast.Line]int),
+	}
+	if err := a.scope(); err != nil {
+		return nil, err
+	}
+	layout := symtab.Layout{Origin: conf.Origin, Align: conf.SectionAlign, Relocatable: conf.Relocatable}
+	if layout.Align == 0 {
+		layout.Align = sectionAlign
+	}
+	if layout.Align < 0 || layout.Align&(layout.Align-1) != 0 {
+		return nil, fmt.Errorf("asm.Assemble: invalid section alignment %d; expected power of two", layout.Align)
+	}
+	if err := a.locateOrigin(&layout); err != nil {
+		return nil, err
+	}
+	a.syms = symtab.New(layout)
+
+	// Lay out the program until the address of every label is stable.
+	for n := 0; ; n++ {
+		if n == maxPasses {
+			return nil, fmt.Errorf("asm.Assemble: layout of program did not converge in %d passes", maxPasses)
+		}
+		var lits map[int]int64
+		obj, lits, err = a.pass(false)
+		if err != nil {
+			return nil, err
+		}
+		a.npasses++
+		stable, err := a.update(obj, lits)
+		if err != nil {
+			return nil, err
+		}
+		if stable {
+			break
+		}
+	}
+
+	// Make a final pass which reports any remaining errors.
+	obj, _, err = a.pass(true)
+	return obj, err
+}
+
+// An assembler keeps track of the state of the assembly of a program.
+type assembler struct {
+	// File set used to report positions.
+	fset *token.FileSet
+	// Instruction encoder.
+	enc Encoder
+	// Program to assemble.
+	prog *ast.Program
+	// Symbol table and layout of sections.
+	syms *symtab.Table
+	// Addresses of the constants of literal operands computed by the previous
+	// pass, indexed by the order of appearance of the literal operands.
+	lits map[int]int64
+	// Largest size in bytes of the machine code of each instruction in
+	// previous passes, indexed by its line; except for the first pass, which
+	// resolves forward references provisionally.
+	sizes map[*ast.Line]int
+	// Number of passes made to lay out the program.
+	npasses int
+}
+
+// A literal is a pending constant of a literal pool.
+type literal struct {
+	// Index of the literal operand, in order of appearance.
+	n int
+	// Constant expression.
+	x ast.Arg
+	// Line of the literal operand.
+	line *ast.Line
+}
+
+// locateOrigin sets the origin address of layout to the address specified by
+// the optional org directive of the program. Org directives are invalid in
+// relocatable programs.
+func (a *assembler) locateOrigin(layout *symtab.Layout) error {
+	seen := false
+	for _, line := range a.prog.Lines {
+		dir, ok := line.Node.(*ast.OrgDir)
+		if !ok {
+			continue
+		}
+		if layout.Relocatable {
+			return a.errorf(line, "asm.Assemble: org directive in relocatable program")
+		}
+		if seen {
+			return a.errorf(line, "asm.Assemble: more than one org directive")
+		}
+		addr, err := ast.Eval(dir.Addr, nil)
+		if err != nil {
+			return a.error(line, err)
+		}
+		if addr < 0 {
+			return a.errorf(line, "asm.Assemble: negative origin address %d", addr)
+		}
+		layout.Origin = addr
+		seen = true
+	}
+	return nil
+}
+
+// pass makes one pass over the program and returns the resulting object and the
+// addresses of the constants of literal operands. In the final pass, symbols
+// are resolved strictly, encoding errors are reported and relocation records
+// are computed. Earlier passes provisionally resolve undefined symbols and
+// literal operands to the current address and ignore encoding errors, to lay
+// out the program.
+func (a *assembler) pass(final bool) (*Object, map[int]int64, error) {
+	obj := &Object{
+		Arch:    a.enc.Arch(),
+		Symbols: make(ast.Symbols),
+		Symtab:  a.syms,
+	}
+	if c, ok := a.enc.(Compressor); ok {
+		obj.Compressed = c.Compress()
+	}
+	syms := a.syms
+	syms.Begin(final)
+	var cur *Section
+	// section returns the active section; creating the default section if no
+	// section is active.
+	section := func() *Section {
+		if cur == nil {
+			cur = a.section(obj, DefaultSection)
+		}
+		return cur
+	}
+	// Pending literals of each section, and the addresses of placed literals.
+	pools := make(map[*Section][]literal)
+	lits := make(map[int]int64)
+	nlits := 0
+	for _, line := range a.prog.Lines {
+		if line.Label != nil {
+			name := line.Label.Name
+			if syms.Symbol(name) != nil {
+				return nil, nil, a.errorf(line, "asm.Assemble: redeclaration of symbol %q", name)
+			}
+			sect := section()
+			syms.Define(name, sect.Name, sect.Addr+int64(len(sect.Data)))
+		}
+		if cur != nil {
+			syms.SetPC(cur.Addr + int64(len(cur.Data)))
+		}
+		var err error
+		switch node := line.Node.(type) {
+		case nil:
+			// Label or comment only.
+		case *ast.SectionDir:
+			cur = a.section(obj, node.Name)
+		case *ast.GlobalDir:
+			for _, name := range node.Names {
+				syms.Global(name)
+			}
+			obj.Globals = append(obj.Globals, node.Names...)
+		case *ast.ExternDir:
+			for _, name := range node.Names {
+				if syms.Symbol(name) != nil {
+					return nil, nil, a.errorf(line, "asm.Assemble: redeclaration of symbol %q", name)
+				}
+				syms.Extern(name)
+			}
+		case *ast.OrgDir:
+			// Handled by locateOrigin.
+		case *ast.AlignDir:
+			err = a.align(section(), node, syms)
+		case *ast.DataDir:
+			sect := section()
+			pc := sect.Addr + int64(len(sect.Data))
+			var buf []byte
+			var relocs []symtab.Reloc
+			buf, relocs, err = a.data(sect, pc, node)
+			if err != nil && !final {
+				buf, err = make([]byte, node.Len()), nil
+			}
+			if err == nil && final {
+				sect.Relocs = append(sect.Relocs, relocs...)
+			}
+			sect.Data = append(sect.Data, buf...)
+		case *ast.EquDir:
+			if syms.Symbol(node.Name) != nil {
+				return nil, nil, a.errorf(line, "asm.Assemble: redeclaration of symbol %q", node.Name)
+			}
+			err = syms.Equ(node.Name, node.Val)
+		case *ast.PoolDir:
+			sect := section()
+			if err := a.flush(sect, pools[sect], lits, final); err != nil {
+				return nil, nil, err
+			}
+			delete(pools, sect)
+		case *ast.Inst:
+			sect := section()
+			pc := sect.Addr + int64(len(sect.Data))
+			syms.SetPC(pc)
+			inst := node
+			for i, arg := range node.Args {
+				lit, ok := arg.(*ast.Literal)
+				if !ok {
+					continue
+				}
+				if inst == node {
+					inst = &ast.Inst{Op: node.Op, Args: append([]ast.Arg(nil), node.Args...)}
+				}
+				n := nlits
+				nlits++
+				addr, ok := a.lits[n]
+				if !ok {
+					addr = pc
+				}
+				inst.Args[i] = ast.Addr(addr)
+				pools[sect] = append(pools[sect], literal{n: n, x: lit.X, line: line})
+			}
+			// References to addresses assigned at link time, which are not
+			// reported by the encoder, take precedence over encoding errors;
+			// as the encoder resolves them to provisional addresses.
+			rel := a.newRelocator(sect, pc, string(inst.Op))
+			rel.min = a.sizes[line]
+			var buf []byte
+			buf, err = a.enc.Encode(inst, pc, rel)
+			if a.npasses > 0 && len(buf) > a.sizes[line] {
+				a.sizes[line] = len(buf)
+			}
+			if final {
+				if cerr := rel.check(inst.Args); cerr != nil {
+					err = cerr
+				}
+				if err == nil {
+					sect.Relocs = append(sect.Relocs, rel.relocs...)
+				}
+			}
+			sect.Data = append(sect.Data, buf...)
+		default:
+			err = fmt.Errorf("asm.Assemble: support for node type %T not yet implemented", node)
+		}
+		if err != nil && final {
+			return nil, nil, a.error(line, err)
+		}
+	}
+
+	// Place the pending literals at the end of their sections.
+	for _, sect := range obj.Sections {
+		syms.SetPC(sect.Addr + int64(len(sect.Data)))
+		if err := a.flush(sect, pools[sect], lits, final); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Record the values of labels and constants.
+	for _, sym := range syms.Symbols() {
+		if sym.Kind != symtab.Extern {
+			obj.Symbols[sym.Name] = sym.Value
+		}
+	}
+	return obj, lits, nil
+}
+
+// flush places the constants of the given pending literals in a literal pool
+// at the end of sect, and records their addresses in lits. The literal pool is
+// aligned to the word size of the architecture, and literals of equal integer
+// constants share their storage.
+func (a *assembler) flush(sect *Section, pool []literal, lits map[int]int64, final bool) error {
+	if len(pool) == 0 {
+		return nil
+	}
+	width := a.enc.Arch().WordSize()
+	addr := sect.Addr + int64(len(sect.Data))
+	sect.Data = append(sect.Data, make([]byte, symtab.AlignUp(addr, int64(width))-addr)...)
+	consts := make(map[int64]int64)
+	for _, lit := range pool {
+		addr := sect.Addr + int64(len(sect.Data))
+		if val, err := ast.Eval(lit.x, nil); err == nil {
+			if prev, ok := consts[val]; ok {
+				lits[lit.n] = prev
+				continue
+			}
+			consts[val] = addr
+		}
+		dir := &ast.DataDir{Width: width, Vals: []ast.Arg{lit.x}}
+		buf, relocs, err := a.data(sect, addr, dir)
+		if err == nil && final {
+			sect.Relocs = append(sect.Relocs, relocs...)
+		}
+		if err != nil {
+			if final {
+				return a.error(lit.line, err)
+			}
+			buf = make([]byte, width)
+		}
+		lits[lit.n] = addr
+		sect.Data = append(sect.Data, buf...)
+	}
+	return nil
+}
+
+// section returns the named section of obj, creating it if not yet present.
+// The start address of new sections is given by the symbol table.
+func (a *assembler) section(obj *Object, name string) *Section {
+	if sect := obj.Section(name); sect != nil {
+		return sect
+	}
+	sect := &Section{Name: name, Addr: a.syms.Section(name)}
+	obj.Sections = append(obj.Sections, sect)
+	return sect
+}
+
+// align pads the data of sect with zero bytes up to the alignment specified by
+// dir.
+func (a *assembler) align(sect *Section, dir *ast.AlignDir, syms ast.SymbolTable) error {
+	n, err := ast.Eval(dir.Align, syms)
+	if err != nil {
+		return err
+	}
+	if n <= 0 || n&(n-1) != 0 {
+		return fmt.Errorf("asm.Assemble: invalid alignment %d; expected power of two", n)
+	}
+	if n > maxAlign {
+		return fmt.Errorf("asm.Assemble: invalid alignment %d; expected at most %d", n, maxAlign)
+	}
+	addr := sect.Addr + int64(len(sect.Data))
+	pad := symtab.AlignUp(addr, n) - addr
+	sect.Data = append(sect.Data, make([]byte, pad)...)
+	return nil
+}
+
+// update records the section sizes of obj and the addresses of the constants of
+// literal operands for use by the next pass. It returns true if the layout is
+// stable; i.e. if neither symbols, section addresses nor literal addresses
+// changed.
+func (a *assembler) update(obj *Object, lits map[int]int64) (stable bool, err error) {
+	sizes := make(map[string]int64)
+	for _, sect := range obj.Sections {
+		sizes[sect.Name] = int64(len(sect.Data))
+	}
+	if stable, err = a.syms.End(sizes); err != nil {
+		return false, err
+	}
+	if len(lits) != len(a.lits) {
+		stable = false
+	}
+	for n, addr := range lits {
+		if old, ok := a.lits[n]; !ok || old != addr {
+			stable = false
+		}
+	}
+	a.lits = lits
+	return stable, nil
+}
+
+// error returns an assembly error of the given line.
+func (a *assembler) error(line *ast.Line, err error) error {
+	e := &Error{Err: err}
+	if a.fset != nil {
+		e.Pos = a.fset.Position(line.Pos)
+	}
+	return e
+}
+
+// errorf returns an assembly error of the given line, with a formatted error
+// message.
+func (a *assembler) errorf(line *ast.Line, format string, args ...interface{}) error {
+	return a.error(line, fmt.Errorf(format, args...))
+}
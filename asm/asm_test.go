@@ -0,0 +1,410 @@
+package asm_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	mipsarch "github.com/mewlang/asm/arch/mips"
+	x86arch "github.com/mewlang/asm/arch/x86"
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/asm/arm"
+	"github.com/mewlang/asm/asm/arm64"
+	"github.com/mewlang/asm/asm/mips"
+	"github.com/mewlang/asm/asm/riscv"
+	"github.com/mewlang/asm/asm/x86"
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/parser"
+	"github.com/mewlang/asm/symtab"
+	"github.com/mewlang/asm/token"
+)
+
+func TestAssemble(t *testing.T) {
+	const src = `
+	global main
+size equ end - main
+main:
+	li $a0, size
+	la $a1, msg
+	j main
+end:
+	section .data
+msg:
+	db "hi"
+	align 4
+	dw 0x1234
+`
+	fset := token.NewFileSet()
+	conf := &parser.Config{Arch: mipsarch.Arch}
+	prog, err := conf.ParseFile(fset, "foo.asm", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := asm.Assemble(fset, mips.Encoder, prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(obj.Sections) != 2 {
+		t.Fatalf("section count mismatch; expected 2, got %d", len(obj.Sections))
+	}
+	golden := []struct {
+		name string
+		addr int64
+		data []byte
+	}{
+		// i=0
+		{
+			name: ".text",
+			addr: 0,
+			data: []byte{
+				0x24, 0x04, 0x00, 0x10, // li $a0, 16
+				0x3C, 0x05, 0x00, 0x00, // lui $a1, 0
+				0x34, 0xA5, 0x00, 0x10, // ori $a1, $a1, 16
+				0x08, 0x00, 0x00, 0x00, // j main
+			},
+		},
+		// i=1
+		{
+			name: ".data",
+			addr: 16,
+			data: []byte{'h', 'i', 0, 0, 0x12, 0x34},
+		},
+	}
+	for i, g := range golden {
+		sect := obj.Sections[i]
+		if sect.Name != g.name {
+			t.Errorf("i=%d: section name mismatch; expected %q, got %q", i, g.name, sect.Name)
+		}
+		if sect.Addr != g.addr {
+			t.Errorf("i=%d: section address mismatch; expected %d, got %d", i, g.addr, sect.Addr)
+		}
+		if !bytes.Equal(sect.Data, g.data) {
+			t.Errorf("i=%d: section data mismatch; expected %x, got %x", i, g.data, sect.Data)
+		}
+	}
+	wantSyms := ast.Symbols{"size": 16, "main": 0, "end": 16, "msg": 16}
+	if !reflect.DeepEqual(obj.Symbols, wantSyms) {
+		t.Errorf("symbols mismatch; expected %v, got %v", wantSyms, obj.Symbols)
+	}
+	if want := []string{"main"}; !reflect.DeepEqual(obj.Globals, want) {
+		t.Errorf("globals mismatch; expected %v, got %v", want, obj.Globals)
+	}
+}
+
+func TestAssembleLiteralPool(t *testing.T) {
+	const src = `
+main:
+	ldr r0, =0x12345678
+	ldr r1, =msg
+	pool
+	ldr r2, =0x12345678
+	ldr r3, =0x12345678
+	section .data
+msg:
+	db "hi"
+`
+	enc := new(arm.Encoder)
+	fset := token.NewFileSet()
+	conf := &parser.Config{Arch: enc.Arch()}
+	prog, err := conf.ParseFile(fset, "foo.asm", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := asm.Assemble(fset, enc, prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x00, 0x48, // ldr r0, [pc, #0]
+		0x01, 0x49, // ldr r1, [pc, #4]
+		0x78, 0x56, 0x34, 0x12, // 0x12345678
+		0x14, 0x00, 0x00, 0x00, // msg
+		0x00, 0x4A, // ldr r2, [pc, #0]
+		0x00, 0x4B, // ldr r3, [pc, #0]
+		0x78, 0x56, 0x34, 0x12, // 0x12345678
+	}
+	if got := obj.Section(asm.DefaultSection).Data; !bytes.Equal(got, want) {
+		t.Errorf("section data mismatch; expected %x, got %x", want, got)
+	}
+}
+
+func TestAssembleLocalLabels(t *testing.T) {
+	const src = `
+foo:
+	beq $a0, $zero, .done
+	j .done
+.done:
+	jr $ra
+bar:
+.loop:
+	bne $a0, $zero, .loop
+	j .done
+.done:
+	jr $ra
+.len equ .done - bar
+`
+	fset := token.NewFileSet()
+	conf := &parser.Config{Arch: mipsarch.Arch}
+	prog, err := conf.ParseFile(fset, "foo.asm", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := asm.Assemble(fset, mips.Encoder, prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x10, 0x80, 0x00, 0x01, // beq $a0, $zero, foo.done
+		0x08, 0x00, 0x00, 0x02, // j foo.done
+		0x03, 0xE0, 0x00, 0x08, // jr $ra
+		0x14, 0x80, 0xFF, 0xFF, // bne $a0, $zero, bar.loop
+		0x08, 0x00, 0x00, 0x05, // j bar.done
+		0x03, 0xE0, 0x00, 0x08, // jr $ra
+	}
+	if got := obj.Section(asm.DefaultSection).Data; !bytes.Equal(got, want) {
+		t.Errorf("section data mismatch; expected %x, got %x", want, got)
+	}
+	wantSyms := ast.Symbols{"foo": 0, "foo.done": 8, "bar": 12, "bar.loop": 12, "bar.done": 20, "bar.len": 8}
+	if !reflect.DeepEqual(obj.Symbols, wantSyms) {
+		t.Errorf("symbols mismatch; expected %v, got %v", wantSyms, obj.Symbols)
+	}
+	// The lines of the program are left unmodified.
+	if name := prog.Lines[3].Label.Name; name != ".done" {
+		t.Errorf("label name mismatch; expected %q, got %q", ".done", name)
+	}
+}
+
+func TestAssembleRelocs(t *testing.T) {
+	const src = `
+	extern printf, exit
+	global main
+main:
+	push msg
+	call printf
+	add esp, 4
+	jmp done
+	nop
+done:
+	call exit
+	section .data
+msg:
+	db "hi", 10, 0
+table:
+	dd main, printf + 8, done - main
+`
+	golden := []struct {
+		relocatable bool
+		want        map[string][]symtab.Reloc
+	}{
+		// i=0
+		{
+			relocatable: false,
+			want: map[string][]symtab.Reloc{
+				".text": {
+					{Offset: 3, Size: 4, Symbol: "printf", Addend: -4, PCRel: true},
+					{Offset: 14, Size: 4, Symbol: "exit", Addend: -4, PCRel: true},
+				},
+				".data": {
+					{Offset: 8, Size: 4, Symbol: "printf", Addend: 8},
+				},
+			},
+		},
+		// i=1
+		{
+			relocatable: true,
+			want: map[string][]symtab.Reloc{
+				".text": {
+					{Offset: 1, Size: 4, Section: ".data", Addend: 0},
+					{Offset: 6, Size: 4, Symbol: "printf", Addend: -4, PCRel: true},
+					{Offset: 17, Size: 4, Symbol: "exit", Addend: -4, PCRel: true},
+				},
+				".data": {
+					{Offset: 4, Size: 4, Section: ".text", Addend: 0},
+					{Offset: 8, Size: 4, Symbol: "printf", Addend: 8},
+				},
+			},
+		},
+	}
+
+	fset := token.NewFileSet()
+	conf := &parser.Config{Arch: x86arch.Arch}
+	prog, err := conf.ParseFile(fset, "foo.asm", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, g := range golden {
+		conf := &asm.Config{Relocatable: g.relocatable}
+		obj, err := conf.Assemble(fset, x86.Encoder, prog)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		for name, want := range g.want {
+			got := obj.Section(name).Relocs
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("i=%d: relocations of %q mismatch; expected %+v, got %+v", i, name, want, got)
+			}
+		}
+		sym := obj.Symtab.Symbol("main")
+		if sym == nil || sym.Kind != symtab.Label || sym.Section != ".text" || !sym.Global {
+			t.Errorf("i=%d: symbol mismatch; expected global label main of .text, got %+v", i, sym)
+		}
+	}
+}
+
+func TestAssembleRelocTypes(t *testing.T) {
+	golden := []struct {
+		enc  asm.Encoder
+		src  string
+		want []symtab.Reloc
+		data string
+	}{
+		// i=0
+		{
+			enc: x86.Encoder64,
+			src: "\textern f\n\tmov rax, f\n\tadd eax, f\n\tjmp f\n\tlea rdi, [rel f + 4]\n\tmov eax, [f]",
+			want: []symtab.Reloc{
+				{Offset: 2, Size: 8, Symbol: "f"},
+				{Offset: 11, Size: 4, Symbol: "f"},
+				{Offset: 16, Size: 4, Symbol: "f", Addend: -4, PCRel: true},
+				{Offset: 23, Size: 4, Symbol: "f", Addend: 0, PCRel: true},
+				{Offset: 30, Size: 4, Symbol: "f"},
+			},
+			data: "48b800000000000000000500000000e900000000488d3d000000008b042500000000",
+		},
+		// i=1
+		{
+			enc: arm64.Encoder,
+			src: "\textern f\n\tbl f\n\tadrp x0, msg\n\tadd x0, x0, #msg\n\tsection .data\nmsg:\n\tdb 0",
+			want: []symtab.Reloc{
+				{Offset: 0, Type: symtab.ARM64Branch26, Size: 4, Symbol: "f", PCRel: true},
+				{Offset: 4, Type: symtab.ARM64Page21, Size: 4, Section: ".data", PCRel: true},
+				{Offset: 8, Type: symtab.ARM64PageOff12, Size: 4, Section: ".data"},
+			},
+			data: "000000940000009000000091",
+		},
+		// i=2
+		{
+			enc: &arm.Encoder{},
+			src: "\textern f\n\tbl f\n\tb f + 8",
+			want: []symtab.Reloc{
+				{Offset: 0, Type: symtab.ThumbCall, Size: 4, Symbol: "f", Addend: -4, PCRel: true},
+				{Offset: 4, Type: symtab.ThumbJump24, Size: 4, Symbol: "f", Addend: 4, PCRel: true},
+			},
+			data: "00f000f800f000b8",
+		},
+		// i=3
+		{
+			enc: mips.Encoder,
+			src: "\textern f\n\tjal f\n\tla $a0, f + 4",
+			want: []symtab.Reloc{
+				{Offset: 0, Type: symtab.MIPS26, Size: 4, Symbol: "f"},
+				{Offset: 4, Type: symtab.MIPSHi16, Size: 4, Symbol: "f", Addend: 4},
+				{Offset: 8, Type: symtab.MIPSLo16, Size: 4, Symbol: "f", Addend: 4},
+			},
+			data: "0c0000003c04000024840000",
+		},
+		// i=4
+		{
+			enc: riscv.Encoder,
+			src: "\textern f\n\tcall f\n\tla a0, f",
+			want: []symtab.Reloc{
+				{Offset: 0, Type: symtab.RISCVCall, Size: 8, Symbol: "f", PCRel: true},
+				{Offset: 8, Type: symtab.RISCVPCRelHi20, Size: 4, Symbol: "f", PCRel: true},
+				{Offset: 12, Type: symtab.RISCVPCRelLo12I, Size: 4, Symbol: "f", PCRel: true},
+			},
+			data: "97000000e78000001705000013050500",
+		},
+	}
+
+	for i, g := range golden {
+		fset := token.NewFileSet()
+		prog, err := (&parser.Config{Arch: g.enc.Arch()}).ParseFile(fset, "foo.asm", g.src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		obj, err := (&asm.Config{Relocatable: true}).Assemble(fset, g.enc, prog)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		text := obj.Section(asm.DefaultSection)
+		if !reflect.DeepEqual(text.Relocs, g.want) {
+			t.Errorf("i=%d: relocations mismatch; expected %+v, got %+v", i, g.want, text.Relocs)
+		}
+		if got := hex.EncodeToString(text.Data); got != g.data {
+			t.Errorf("i=%d: machine code mismatch; expected %s, got %s", i, g.data, got)
+		}
+	}
+}
+
+func TestImage(t *testing.T) {
+	sects := []*asm.Section{
+		{Name: ".text", Addr: 0x8000, Data: []byte{0xA9, 0x01, 0x60}},
+		{Name: ".data", Addr: 0x8004, Data: []byte{0x68, 0x69}},
+	}
+	addr, data := asm.Image(sects)
+	if addr != 0x8000 {
+		t.Errorf("address mismatch; expected 0x8000, got 0x%X", addr)
+	}
+	want := []byte{0xA9, 0x01, 0x60, 0x00, 0x68, 0x69}
+	if !bytes.Equal(data, want) {
+		t.Errorf("image mismatch; expected %x, got %x", want, data)
+	}
+}
+
+func TestAssembleError(t *testing.T) {
+	golden := []struct {
+		in   string
+		want string
+	}{
+		// i=0
+		{in: "org 0x100\norg 0x200", want: "foo.asm:2:1: asm.Assemble: more than one org directive"},
+		// i=1
+		{in: "align 3", want: "foo.asm:1:1: asm.Assemble: invalid alignment 3; expected power of two"},
+		// i=2
+		{in: "x equ 1\nx equ 2", want: `foo.asm:2:1: asm.Assemble: redeclaration of symbol "x"`},
+		// i=3
+		{in: "\tdb foo", want: `foo.asm:1:2: ast.Eval: undefined symbol "foo"`},
+		// i=4
+		{in: "extern f\nf:", want: `foo.asm:2:1: asm.Assemble: redeclaration of symbol "f"`},
+		// i=5
+		{in: "extern f\ng equ f * 2", want: `foo.asm:2:1: symtab.Table.Equ: value of "g" is not a constant or an address relative to a label`},
+		// i=6
+		{in: "foo:\n.done:\n.done:", want: `foo.asm:3:1: asm.Assemble: redeclaration of local label ".done" in scope of "foo"`},
+		// i=7
+		{in: ".done:\nfoo:", want: `foo.asm:1:1: asm.Assemble: local label ".done" used before any non-local label`},
+		// i=8
+		{in: "\tj .done\nfoo:\n.done:", want: `foo.asm:1:2: asm.Assemble: local label ".done" used before any non-local label`},
+		// i=9
+		{in: "\textern f\n\tli $a0, f", want: `foo.asm:2:2: asm.Assemble: unable to relocate reference to symbol "f" of "li"`},
+		// i=10
+		{in: "\textern f\n\tla $a0, f * 2", want: `foo.asm:2:2: asm.Assemble: unable to relocate reference to symbol "f" of "la"`},
+		// i=11
+		{in: "\textern f\n\tbeq $a0, $a1, f", want: `foo.asm:2:2: asm.Assemble: unable to relocate reference to symbol "f" of "beq"`},
+		// i=12
+		{in: "db 1\nalign 1<<40", want: "foo.asm:2:1: asm.Assemble: invalid alignment 1099511627776; expected at most 65536"},
+		// i=13
+		{in: "db 1\nalign 1<<62", want: "foo.asm:2:1: asm.Assemble: invalid alignment 4611686018427387904; expected at most 65536"},
+	}
+
+	for i, g := range golden {
+		fset := token.NewFileSet()
+		conf := &parser.Config{Arch: mipsarch.Arch}
+		prog, err := conf.ParseFile(fset, "foo.asm", g.in)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		_, err = asm.Assemble(fset, mips.Encoder, prog)
+		if err == nil {
+			t.Errorf("i=%d: expected error %q, got nil", i, g.want)
+			continue
+		}
+		if got := err.Error(); got != g.want {
+			t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
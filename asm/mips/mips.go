@@ -0,0 +1,437 @@
+// Package mips implements a MIPS32 instruction encoder.
+//
+// Instructions are encoded into the R, I and J instruction formats; loads and
+// stores are I format instructions whose immediate is the displacement of the
+// memory operand:
+//
+//	R: op(6) rs(5) rt(5) rd(5) shamt(5) funct(6)
+//	I: op(6) rs(5) rt(5) imm(16)
+//	J: op(6) target(26)
+//
+// Branch delay slots are not filled automatically; the instruction following a
+// branch or jump is always executed.
+//
+// The targets of j and jal, and the address of la, may refer to addresses
+// assigned at link time; which are reported as relocation records. Such la
+// pseudo-instructions are expanded into lui and addiu, whose immediates are the
+// upper and lower 16 bits of the address.
+package mips
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mewlang/asm/arch"
+	mipsarch "github.com/mewlang/asm/arch/mips"
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/symtab"
+)
+
+// Encoder is the MIPS32 instruction encoder.
+var Encoder asm.Encoder = encoder{}
+
+// encoder implements the asm.Encoder interface for MIPS32.
+type encoder struct{}
+
+// Arch returns the MIPS32 architecture.
+func (encoder) Arch() arch.Arch {
+	return mipsarch.Arch
+}
+
+// Encode returns the big-endian machine code of inst, which is located at
+// address pc. Branch and jump targets are resolved using the provided symbol
+// table.
+func (encoder) Encode(inst *ast.Inst, pc int64, syms ast.SymbolTable) ([]byte, error) {
+	if err := mipsarch.Arch.Validate(inst); err != nil {
+		return nil, err
+	}
+	e := &encoding{inst: inst, pc: pc, syms: syms}
+	words, err := e.encode()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4*len(words))
+	for i, word := range words {
+		binary.BigEndian.PutUint32(buf[4*i:], word)
+	}
+	return buf, nil
+}
+
+// Opcodes of the I and J format instructions; and of the special R format
+// instructions.
+const (
+	opSpecial = 0x00
+	opRegImm  = 0x01
+	opJ       = 0x02
+	opJal     = 0x03
+	opBeq     = 0x04
+	opBne     = 0x05
+	opBlez    = 0x06
+	opBgtz    = 0x07
+	opAddiu   = 0x09
+	opOri     = 0x0D
+	opLui     = 0x0F
+)
+
+// Function codes of R format instructions.
+const (
+	functSll  = 0x00
+	functJr   = 0x08
+	functJalr = 0x09
+	functOr   = 0x25
+	functSub  = 0x22
+	functNor  = 0x27
+)
+
+// rd, rs, rt instructions mapped to their function codes.
+var arithOps = map[ast.Op]uint32{
+	"add":  0x20,
+	"addu": 0x21,
+	"sub":  functSub,
+	"subu": 0x23,
+	"and":  0x24,
+	"or":   functOr,
+	"xor":  0x26,
+	"nor":  functNor,
+	"slt":  0x2A,
+	"sltu": 0x2B,
+}
+
+// rd, rt, rs instructions mapped to their function codes.
+var shiftVarOps = map[ast.Op]uint32{
+	"sllv": 0x04,
+	"srlv": 0x06,
+	"srav": 0x07,
+}
+
+// rd, rt, shamt instructions mapped to their function codes.
+var shiftOps = map[ast.Op]uint32{
+	"sll": functSll,
+	"srl": 0x02,
+	"sra": 0x03,
+}
+
+// rs, rt instructions mapped to their function codes.
+var multOps = map[ast.Op]uint32{
+	"mult":  0x18,
+	"multu": 0x19,
+	"div":   0x1A,
+	"divu":  0x1B,
+}
+
+// Instructions which move from (rd) or to (rs) the hi and lo registers mapped
+// to their function codes.
+var (
+	moveFromOps = map[ast.Op]uint32{"mfhi": 0x10, "mflo": 0x12}
+	moveToOps   = map[ast.Op]uint32{"mthi": 0x11, "mtlo": 0x13}
+)
+
+// rt, rs, imm instructions mapped to their opcodes. The immediates of the
+// logical instructions are zero-extended; the others are sign-extended.
+var (
+	immOps = map[ast.Op]uint32{
+		"addi":  0x08,
+		"addiu": opAddiu,
+		"slti":  0x0A,
+		"sltiu": 0x0B,
+	}
+	logicalImmOps = map[ast.Op]uint32{
+		"andi": 0x0C,
+		"ori":  opOri,
+		"xori": 0x0E,
+	}
+)
+
+// rt, offset(base) load and store instructions mapped to their opcodes.
+var memOps = map[ast.Op]uint32{
+	"lb":  0x20,
+	"lh":  0x21,
+	"lw":  0x23,
+	"lbu": 0x24,
+	"lhu": 0x25,
+	"sb":  0x28,
+	"sh":  0x29,
+	"sw":  0x2B,
+}
+
+// rs, offset branch instructions mapped to their opcodes and rt fields. The
+// REGIMM branches use the rt field to distinguish the condition.
+var branchZeroOps = map[ast.Op][2]uint32{
+	"blez": {opBlez, 0},
+	"bgtz": {opBgtz, 0},
+	"bltz": {opRegImm, 0},
+	"bgez": {opRegImm, 1},
+}
+
+// An encoding keeps track of the encoding of a single instruction.
+type encoding struct {
+	// Instruction to encode.
+	inst *ast.Inst
+	// Address of the instruction.
+	pc int64
+	// Symbol table used to resolve identifiers.
+	syms ast.SymbolTable
+}
+
+// encode returns the instruction words of the instruction. Pseudo-instructions
+// may expand to more than one word.
+func (e *encoding) encode() ([]uint32, error) {
+	op, args := e.inst.Op, e.inst.Args
+	if funct, ok := arithOps[op]; ok {
+		return words(rtype(reg(args[1]), reg(args[2]), reg(args[0]), 0, funct))
+	}
+	if funct, ok := shiftVarOps[op]; ok {
+		return words(rtype(reg(args[2]), reg(args[1]), reg(args[0]), 0, funct))
+	}
+	if funct, ok := shiftOps[op]; ok {
+		shamt, err := e.uimm(args[2], 5)
+		if err != nil {
+			return nil, err
+		}
+		return words(rtype(0, reg(args[1]), reg(args[0]), shamt, funct))
+	}
+	if funct, ok := multOps[op]; ok {
+		return words(rtype(reg(args[0]), reg(args[1]), 0, 0, funct))
+	}
+	if funct, ok := moveFromOps[op]; ok {
+		return words(rtype(0, 0, reg(args[0]), 0, funct))
+	}
+	if funct, ok := moveToOps[op]; ok {
+		return words(rtype(reg(args[0]), 0, 0, 0, funct))
+	}
+	if opcode, ok := immOps[op]; ok {
+		imm, err := e.simm(args[2], 16)
+		if err != nil {
+			return nil, err
+		}
+		return words(itype(opcode, reg(args[1]), reg(args[0]), imm))
+	}
+	if opcode, ok := logicalImmOps[op]; ok {
+		imm, err := e.uimm(args[2], 16)
+		if err != nil {
+			return nil, err
+		}
+		return words(itype(opcode, reg(args[1]), reg(args[0]), imm))
+	}
+	if opcode, ok := memOps[op]; ok {
+		base, off, err := e.mem(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return words(itype(opcode, base, reg(args[0]), off))
+	}
+	if code, ok := branchZeroOps[op]; ok {
+		return e.branch(code[0], reg(args[0]), code[1], args[1])
+	}
+
+	switch op {
+	case "lui":
+		imm, err := e.uimm(args[1], 16)
+		if err != nil {
+			return nil, err
+		}
+		return words(itype(opLui, 0, reg(args[0]), imm))
+	case "beq":
+		return e.branch(opBeq, reg(args[0]), reg(args[1]), args[2])
+	case "bne":
+		return e.branch(opBne, reg(args[0]), reg(args[1]), args[2])
+	case "j":
+		return e.jump(opJ, args[0])
+	case "jal":
+		return e.jump(opJal, args[0])
+	case "jr":
+		return words(rtype(reg(args[0]), 0, 0, 0, functJr))
+	case "jalr":
+		// The return address is stored in $ra unless a destination register is
+		// specified.
+		rd, rs := uint32(mipsarch.RA), reg(args[0])
+		if len(args) == 2 {
+			rd, rs = reg(args[0]), reg(args[1])
+		}
+		return words(rtype(rs, 0, rd, 0, functJalr))
+	case "syscall":
+		return words(rtype(0, 0, 0, 0, 0x0C))
+	case "break":
+		return words(rtype(0, 0, 0, 0, 0x0D))
+
+	// Pseudo-instructions.
+	case "nop":
+		return words(rtype(0, 0, 0, 0, functSll))
+	case "move":
+		return words(rtype(reg(args[1]), 0, reg(args[0]), 0, functOr))
+	case "not":
+		return words(rtype(reg(args[1]), 0, reg(args[0]), 0, functNor))
+	case "neg":
+		return words(rtype(0, reg(args[1]), reg(args[0]), 0, functSub))
+	case "li":
+		return e.loadImm(reg(args[0]), args[1])
+	case "la":
+		return e.loadAddr(reg(args[0]), args[1])
+	case "b":
+		return e.branch(opBeq, 0, 0, args[0])
+	case "beqz":
+		return e.branch(opBeq, reg(args[0]), 0, args[1])
+	case "bnez":
+		return e.branch(opBne, reg(args[0]), 0, args[1])
+	}
+	return nil, fmt.Errorf("mips.Encoder.Encode: support for instruction %q not yet implemented", op)
+}
+
+// branch encodes a conditional branch to the given target. The offset of the
+// target is relative to the address of the delay slot, in words.
+func (e *encoding) branch(opcode, rs, rt uint32, target ast.Arg) ([]uint32, error) {
+	addr, err := ast.Eval(target, e.syms)
+	if err != nil {
+		return nil, err
+	}
+	if addr&3 != 0 {
+		return nil, fmt.Errorf("mips.encoding.branch: unaligned branch target 0x%X", addr)
+	}
+	off := (addr - (e.pc + 4)) >> 2
+	if off < -1<<15 || off >= 1<<15 {
+		return nil, fmt.Errorf("mips.encoding.branch: branch target 0x%X out of range; offset %d not in range [%d, %d]", addr, off, -1<<15, 1<<15-1)
+	}
+	return words(itype(opcode, rs, rt, uint32(off)&0xFFFF))
+}
+
+// jump encodes a jump to the given target. The target must be located within
+// the same 256 MB region as the delay slot.
+func (e *encoding) jump(opcode uint32, target ast.Arg) ([]uint32, error) {
+	addr, err := ast.Eval(target, e.syms)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := e.reloc(symtab.MIPS26, 0, target)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return words(opcode << 26)
+	}
+	if addr&3 != 0 {
+		return nil, fmt.Errorf("mips.encoding.jump: unaligned jump target 0x%X", addr)
+	}
+	if addr < 0 || addr>>28 != (e.pc+4)>>28 {
+		return nil, fmt.Errorf("mips.encoding.jump: jump target 0x%X out of range; not in the 256 MB region of 0x%X", addr, e.pc+4)
+	}
+	return words(opcode<<26 | uint32(addr>>2)&0x3FFFFFF)
+}
+
+// loadImm encodes the li pseudo-instruction, using a single instruction when
+// the value fits in 16 bits.
+func (e *encoding) loadImm(rt uint32, x ast.Arg) ([]uint32, error) {
+	val, err := ast.EvalWidth(x, e.syms, 32)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case -1<<15 <= val && val < 1<<15:
+		return words(itype(opAddiu, 0, rt, uint32(val)&0xFFFF))
+	case 0 <= val && val < 1<<16:
+		return words(itype(opOri, 0, rt, uint32(val)))
+	}
+	hi, lo := uint32(val)>>16, uint32(val)&0xFFFF
+	if lo == 0 {
+		return words(itype(opLui, 0, rt, hi))
+	}
+	return words(itype(opLui, 0, rt, hi), itype(opOri, rt, rt, lo))
+}
+
+// loadAddr encodes the la pseudo-instruction. The expansion is always two
+// instructions long, so that its size does not depend on the address.
+func (e *encoding) loadAddr(rt uint32, x ast.Arg) ([]uint32, error) {
+	val, err := ast.EvalWidth(x, e.syms, 32)
+	if err != nil {
+		return nil, err
+	}
+	if asm.Relocated(e.syms, x, false) {
+		// The lower 16 bits are sign-extended by addiu, which is accounted for
+		// by the upper 16 bits of lui.
+		if _, err := e.reloc(symtab.MIPSHi16, 0, x); err != nil {
+			return nil, err
+		}
+		if _, err := e.reloc(symtab.MIPSLo16, 4, x); err != nil {
+			return nil, err
+		}
+		return words(itype(opLui, 0, rt, 0), itype(opAddiu, rt, rt, 0))
+	}
+	hi, lo := uint32(val)>>16, uint32(val)&0xFFFF
+	return words(itype(opLui, 0, rt, hi), itype(opOri, rt, rt, lo))
+}
+
+// mem returns the base register and the sign-extended 16-bit displacement of
+// the memory operand x. The base register is $zero if not present.
+func (e *encoding) mem(x ast.Arg) (base, off uint32, err error) {
+	mem := x.(*ast.Mem)
+	if mem.Index != nil || mem.Rel {
+		return 0, 0, fmt.Errorf("mips.encoding.mem: invalid memory operand of %q; expected base register and displacement", e.inst.Op)
+	}
+	if mem.Base != nil {
+		base = reg(mem.Base)
+	}
+	if mem.Disp != nil {
+		off, err = e.simm(mem.Disp, 16)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return base, off, nil
+}
+
+// simm evaluates x and returns its value as a sign-extended immediate of the
+// given bit width.
+func (e *encoding) simm(x ast.Arg, width uint) (uint32, error) {
+	val, err := ast.Eval(x, e.syms)
+	if err != nil {
+		return 0, err
+	}
+	min, max := int64(-1)<<(width-1), int64(1)<<(width-1)-1
+	if val < min || val > max {
+		return 0, fmt.Errorf("mips.encoding.simm: immediate %d of %q out of range [%d, %d]", val, e.inst.Op, min, max)
+	}
+	return uint32(val) & (1<<width - 1), nil
+}
+
+// uimm evaluates x and returns its value as a zero-extended immediate of the
+// given bit width.
+func (e *encoding) uimm(x ast.Arg, width uint) (uint32, error) {
+	val, err := ast.Eval(x, e.syms)
+	if err != nil {
+		return 0, err
+	}
+	max := int64(1)<<width - 1
+	if val < 0 || val > max {
+		return 0, fmt.Errorf("mips.encoding.uimm: immediate %d of %q out of range [0, %d]", val, e.inst.Op, max)
+	}
+	return uint32(val), nil
+}
+
+// reloc reports the relocation record of the given type of the instruction at
+// offset off of the machine code, which refers to the address x. It returns
+// true if the immediate of the instruction is relocated, in which case it is
+// encoded as zero.
+func (e *encoding) reloc(typ symtab.RelocType, off int64, x ast.Arg) (bool, error) {
+	return asm.Reloc(e.syms, symtab.Reloc{Offset: off, Type: typ, Size: 4}, x)
+}
+
+// rtype returns an R format instruction word.
+func rtype(rs, rt, rd, shamt, funct uint32) uint32 {
+	return opSpecial<<26 | rs<<21 | rt<<16 | rd<<11 | shamt<<6 | funct
+}
+
+// itype returns an I format instruction word.
+func itype(opcode, rs, rt, imm uint32) uint32 {
+	return opcode<<26 | rs<<21 | rt<<16 | imm
+}
+
+// reg returns the register number of the register operand x, which has been
+// validated by the architecture.
+func reg(x ast.Arg) uint32 {
+	return uint32(x.(ast.Reg))
+}
+
+// words returns the given instruction words.
+func words(ws ...uint32) ([]uint32, error) {
+	return ws, nil
+}
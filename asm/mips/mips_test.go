@@ -0,0 +1,231 @@
+// The expected encodings of the test cases in this file have been verified using
+// llvm-mc.
+
+package mips
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/mewlang/asm/internal/asmtest"
+)
+
+func TestEncode(t *testing.T) {
+	golden := []struct {
+		in   string
+		want string
+	}{
+		// i=0
+		{in: "add $t1, $t2, $t3", want: "014b4820"},
+		// i=1
+		{in: "addu $v0, $a0, $a1", want: "00851021"},
+		// i=2
+		{in: "subu $s0, $s1, $s2", want: "02328023"},
+		// i=3
+		{in: "and $t0, $t1, $t2", want: "012a4024"},
+		// i=4
+		{in: "sltu $t0, $t1, $t2", want: "012a402b"},
+		// i=5
+		{in: "addi $t0, $t0, -1", want: "2108ffff"},
+		// i=6
+		{in: "addiu $sp, $sp, -32", want: "27bdffe0"},
+		// i=7
+		{in: "slti $t0, $t1, -5", want: "2928fffb"},
+		// i=8
+		{in: "sltiu $t0, $t1, 100", want: "2d280064"},
+		// i=9
+		{in: "andi $t0, $t1, 0xFFFF", want: "3128ffff"},
+		// i=10
+		{in: "ori $t0, $zero, 16", want: "34080010"},
+		// i=11
+		{in: "xori $t0, $t1, 0xFF", want: "392800ff"},
+		// i=12
+		{in: "lui $t0, 0x1234", want: "3c081234"},
+		// i=13
+		{in: "sll $t1, $t2, 3", want: "000a48c0"},
+		// i=14
+		{in: "srl $t0, $t1, 31", want: "000947c2"},
+		// i=15
+		{in: "sra $t0, $t1, 1", want: "00094043"},
+		// i=16
+		{in: "sllv $t1, $t2, $t3", want: "016a4804"},
+		// i=17
+		{in: "srav $t0, $t1, $t2", want: "01494007"},
+		// i=18
+		{in: "mult $t1, $t2", want: "012a0018"},
+		// i=19
+		{in: "multu $t1, $t2", want: "012a0019"},
+		// i=20
+		{in: "div $t1, $t2", want: "012a001a"},
+		// i=21
+		{in: "mfhi $t1", want: "00004810"},
+		// i=22
+		{in: "mflo $t1", want: "00004812"},
+		// i=23
+		{in: "mthi $t1", want: "01200011"},
+		// i=24
+		{in: "mtlo $t1", want: "01200013"},
+		// i=25
+		{in: "jr $ra", want: "03e00008"},
+		// i=26
+		{in: "jalr $t9", want: "0320f809"},
+		// i=27
+		{in: "jalr $t1, $t9", want: "03204809"},
+		// i=28
+		{in: "syscall", want: "0000000c"},
+		// i=29
+		{in: "break", want: "0000000d"},
+		// i=30
+		{in: "nop", want: "00000000"},
+		// i=31
+		{in: "move $t0, $t1", want: "01204025"},
+		// i=32
+		{in: "not $t0, $t1", want: "01204027"},
+		// i=33
+		{in: "neg $t0, $t1", want: "00094022"},
+		// i=34
+		{in: "li $t0, 5", want: "24080005"},
+		// i=35
+		{in: "li $t0, -5", want: "2408fffb"},
+		// i=36
+		{in: "li $t0, 0xFFFF", want: "3408ffff"},
+		// i=37
+		{in: "li $t0, 0x10000", want: "3c080001"},
+		// i=38
+		{in: "li $t0, 0x12345678", want: "3c08123435085678"},
+		// i=39
+		{in: "la $t0, 0x10", want: "3c08000035080010"},
+		// i=40
+		{in: "addiu $t0, $t0, (1 << 4) | 2", want: "25080012"},
+		// i=41
+		{in: "lw $t0, 8($sp)", want: "8fa80008"},
+		// i=42
+		{in: "lb $a0, -1($t1)", want: "8124ffff"},
+		// i=43
+		{in: "lbu $v0, ($a0)", want: "90820000"},
+		// i=44
+		{in: "lh $t2, 32767($gp)", want: "878a7fff"},
+		// i=45
+		{in: "lhu $t3, -32768($fp)", want: "97cb8000"},
+		// i=46
+		{in: "sb $t0, 3($s0)", want: "a2080003"},
+		// i=47
+		{in: "sh $t1, -2($s1)", want: "a629fffe"},
+		// i=48
+		{in: "sw $ra, 28($sp)", want: "afbf001c"},
+		// i=49
+		{in: "lw $t0, [$t1]", want: "8d280000"},
+	}
+
+	for i, g := range golden {
+		got, err := asmtest.Text(Encoder, g.in)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		want, err := hex.DecodeString(g.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("i=%d: encoding mismatch of %q; expected %x, got %x", i, g.in, want, got)
+		}
+	}
+}
+
+func TestEncodeLabels(t *testing.T) {
+	const src = `
+	org 0x400000
+main:
+	addi $t0, $t0, -1
+	bne $t0, $zero, main
+	beq $t0, $t1, end
+	blez $t0, main
+	bgez $t0, end
+	b end
+	j main
+	jal end
+	la $a0, msg
+end:
+	jr $ra
+	nop
+msg:
+	db "hi", 0
+`
+	golden := []string{
+		"2108ffff", // addi $t0, $t0, -1
+		"1500fffe", // bne $t0, $zero, main
+		"11090007", // beq $t0, $t1, end
+		"1900fffc", // blez $t0, main
+		"05010005", // bgez $t0, end
+		"10000004", // b end
+		"08100000", // j main
+		"0c10000a", // jal end
+		"3c040040", // lui $a0, 0x40
+		"34840030", // ori $a0, $a0, 0x30
+		"03e00008", // jr $ra
+		"00000000", // nop
+		"68690000", // "hi", 0 and padding
+	}
+	got, err := asmtest.Text(Encoder, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := hex.DecodeString(strings.Join(golden, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = want[:len(want)-1]
+	if !bytes.Equal(got, want) {
+		t.Errorf("encoding mismatch; expected %x, got %x", want, got)
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	golden := []struct {
+		in   string
+		want string
+	}{
+		// i=0
+		{in: "addi $t0, $t0, 0x8000", want: `1:1: mips.encoding.simm: immediate 32768 of "addi" out of range [-32768, 32767]`},
+		// i=1
+		{in: "addi $t0, $t0, -0x8001", want: `1:1: mips.encoding.simm: immediate -32769 of "addi" out of range [-32768, 32767]`},
+		// i=2
+		{in: "ori $t0, $t0, -1", want: `1:1: mips.encoding.uimm: immediate -1 of "ori" out of range [0, 65535]`},
+		// i=3
+		{in: "sll $t0, $t0, 32", want: `1:1: mips.encoding.uimm: immediate 32 of "sll" out of range [0, 31]`},
+		// i=4
+		{in: "li $t0, 0x100000000", want: "1:1: ast.EvalWidth: value 4294967296 overflows 32-bit operand"},
+		// i=5
+		{in: "nop\nj foo", want: `2:1: ast.Eval: undefined symbol "foo"`},
+		// i=6
+		{in: "b 0x20002", want: "1:1: mips.encoding.branch: unaligned branch target 0x20002"},
+		// i=7
+		{in: "b 0x20004", want: "1:1: mips.encoding.branch: branch target 0x20004 out of range; offset 32768 not in range [-32768, 32767]"},
+		// i=8
+		{in: "j 0x10000000", want: "1:1: mips.encoding.jump: jump target 0x10000000 out of range; not in the 256 MB region of 0x4"},
+		// i=9
+		{in: "foo:\nfoo:\nnop", want: `2:1: asm.Assemble: redeclaration of symbol "foo"`},
+		// i=10
+		{in: "lw $t0, 32768($sp)", want: `1:1: mips.encoding.simm: immediate 32768 of "lw" out of range [-32768, 32767]`},
+		// i=11
+		{in: "sw $t0, -32769($sp)", want: `1:1: mips.encoding.simm: immediate -32769 of "sw" out of range [-32768, 32767]`},
+		// i=12
+		{in: "lw $t0, [$t1 + $t2]", want: `1:1: mips.encoding.mem: invalid memory operand of "lw"; expected base register and displacement`},
+		// i=13
+		{in: "sw $t0, $t1", want: `1:1: parser.parseInst: arch.OpTable.Validate: invalid operands of "sw"; expected "gpr, mem"; got [identifier]: "sw"`},
+	}
+
+	for i, g := range golden {
+		_, err := asmtest.Text(Encoder, g.in)
+		if err == nil {
+			t.Errorf("i=%d: expected error %q, got nil", i, g.want)
+			continue
+		}
+		if got := err.Error(); got != g.want {
+			t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+// Package mos6502 implements a MOS 6502 instruction encoder for the 56
+// documented instructions of the NMOS 6502.
+//
+// Instructions are encoded as a one byte opcode followed by zero, one or two
+// operand bytes; the latter holding a little-endian 16-bit address. The
+// addressing mode of an instruction is given by the syntax of its operands, as
+// described by the arch/mos6502 package.
+//
+// Addresses of instructions which have both a zero page and an absolute
+// addressing mode are encoded using the shorter zero page addressing mode
+// whenever the address is known to be located in the zero page; i.e. in the
+// range [0x00, 0xFF]. As undefined symbols provisionally resolve to the address
+// of the instruction before the final pass of the assembler, the absolute
+// addressing mode is used for forward references until their addresses are
+// known. Addresses assigned at link time are reported as relocation records,
+// and use the absolute addressing mode.
+package mos6502
+
+import (
+	"fmt"
+
+	"github.com/mewlang/asm/arch"
+	mos6502arch "github.com/mewlang/asm/arch/mos6502"
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/symtab"
+)
+
+// Encoder is the 6502 instruction encoder.
+var Encoder asm.Encoder = encoder{}
+
+// encoder implements the asm.Encoder interface for the 6502.
+type encoder struct{}
+
+// Arch returns the 6502 architecture.
+func (encoder) Arch() arch.Arch {
+	return mos6502arch.Arch
+}
+
+// Encode returns the machine code of inst, which is located at address pc.
+// Addresses and branch targets are resolved using the provided symbol table.
+// On error, a zero placeholder of the size of the instruction is returned if
+// its addressing mode is known.
+func (encoder) Encode(inst *ast.Inst, pc int64, syms ast.SymbolTable) ([]byte, error) {
+	if err := mos6502arch.Arch.Validate(inst); err != nil {
+		return nil, err
+	}
+	e := &encoding{inst: inst, pc: pc, syms: syms, opcodes: opcodes[string(inst.Op)]}
+	return e.encode()
+}
+
+// A mode is an addressing mode of the 6502.
+type mode int
+
+// Addressing modes.
+const (
+	implied         mode = iota // brk
+	accumulator                 // asl a
+	immediate                   // lda #imm
+	zeroPage                    // lda zp
+	zeroPageX                   // lda zp, x
+	zeroPageY                   // ldx zp, y
+	absolute                    // lda addr
+	absoluteX                   // lda addr, x
+	absoluteY                   // lda addr, y
+	indirect                    // jmp (addr)
+	indexedIndirect             // lda (zp, x)
+	indirectIndexed             // lda (zp), y
+	relative                    // beq label
+)
+
+// modeNames maps from addressing mode to its name.
+var modeNames = [...]string{
+	implied:         "implied",
+	accumulator:     "accumulator",
+	immediate:       "immediate",
+	zeroPage:        "zero page",
+	zeroPageX:       "zero page indexed by x",
+	zeroPageY:       "zero page indexed by y",
+	absolute:        "absolute",
+	absoluteX:       "absolute indexed by x",
+	absoluteY:       "absolute indexed by y",
+	indirect:        "indirect",
+	indexedIndirect: "indexed indirect",
+	indirectIndexed: "indirect indexed",
+	relative:        "relative",
+}
+
+func (m mode) String() string {
+	return modeNames[m]
+}
+
+// size returns the size in bytes of instructions of the addressing mode.
+func (m mode) size() int {
+	switch m {
+	case implied, accumulator:
+		return 1
+	case absolute, absoluteX, absoluteY, indirect:
+		return 3
+	}
+	return 2
+}
+
+// zeroPageModes maps from absolute addressing mode to the corresponding zero
+// page addressing mode.
+var zeroPageModes = map[mode]mode{
+	absolute:  zeroPage,
+	absoluteX: zeroPageX,
+	absoluteY: zeroPageY,
+}
+
+// opcodes maps from mnemonic to the opcodes of the addressing modes of the
+// instruction.
+var opcodes = map[string]map[mode]byte{
+	// Load, store, arithmetic and logical instructions of the accumulator.
+	"adc": {immediate: 0x69, zeroPage: 0x65, zeroPageX: 0x75, absolute: 0x6D, absoluteX: 0x7D, absoluteY: 0x79, indexedIndirect: 0x61, indirectIndexed: 0x71},
+	"and": {immediate: 0x29, zeroPage: 0x25, zeroPageX: 0x35, absolute: 0x2D, absoluteX: 0x3D, absoluteY: 0x39, indexedIndirect: 0x21, indirectIndexed: 0x31},
+	"cmp": {immediate: 0xC9, zeroPage: 0xC5, zeroPageX: 0xD5, absolute: 0xCD, absoluteX: 0xDD, absoluteY: 0xD9, indexedIndirect: 0xC1, indirectIndexed: 0xD1},
+	"eor": {immediate: 0x49, zeroPage: 0x45, zeroPageX: 0x55, absolute: 0x4D, absoluteX: 0x5D, absoluteY: 0x59, indexedIndirect: 0x41, indirectIndexed: 0x51},
+	"lda": {immediate: 0xA9, zeroPage: 0xA5, zeroPageX: 0xB5, absolute: 0xAD, absoluteX: 0xBD, absoluteY: 0xB9, indexedIndirect: 0xA1, indirectIndexed: 0xB1},
+	"ora": {immediate: 0x09, zeroPage: 0x05, zeroPageX: 0x15, absolute: 0x0D, absoluteX: 0x1D, absoluteY: 0x19, indexedIndirect: 0x01, indirectIndexed: 0x11},
+	"sbc": {immediate: 0xE9, zeroPage: 0xE5, zeroPageX: 0xF5, absolute: 0xED, absoluteX: 0xFD, absoluteY: 0xF9, indexedIndirect: 0xE1, indirectIndexed: 0xF1},
+	"sta": {zeroPage: 0x85, zeroPageX: 0x95, absolute: 0x8D, absoluteX: 0x9D, absoluteY: 0x99, indexedIndirect: 0x81, indirectIndexed: 0x91},
+	// Read-modify-write instructions.
+	"asl": {accumulator: 0x0A, zeroPage: 0x06, zeroPageX: 0x16, absolute: 0x0E, absoluteX: 0x1E},
+	"lsr": {accumulator: 0x4A, zeroPage: 0x46, zeroPageX: 0x56, absolute: 0x4E, absoluteX: 0x5E},
+	"rol": {accumulator: 0x2A, zeroPage: 0x26, zeroPageX: 0x36, absolute: 0x2E, absoluteX: 0x3E},
+	"ror": {accumulator: 0x6A, zeroPage: 0x66, zeroPageX: 0x76, absolute: 0x6E, absoluteX: 0x7E},
+	"dec": {zeroPage: 0xC6, zeroPageX: 0xD6, absolute: 0xCE, absoluteX: 0xDE},
+	"inc": {zeroPage: 0xE6, zeroPageX: 0xF6, absolute: 0xEE, absoluteX: 0xFE},
+	// Load, store and compare instructions of the index registers.
+	"ldx": {immediate: 0xA2, zeroPage: 0xA6, zeroPageY: 0xB6, absolute: 0xAE, absoluteY: 0xBE},
+	"ldy": {immediate: 0xA0, zeroPage: 0xA4, zeroPageX: 0xB4, absolute: 0xAC, absoluteX: 0xBC},
+	"stx": {zeroPage: 0x86, zeroPageY: 0x96, absolute: 0x8E},
+	"sty": {zeroPage: 0x84, zeroPageX: 0x94, absolute: 0x8C},
+	"cpx": {immediate: 0xE0, zeroPage: 0xE4, absolute: 0xEC},
+	"cpy": {immediate: 0xC0, zeroPage: 0xC4, absolute: 0xCC},
+	"bit": {zeroPage: 0x24, absolute: 0x2C},
+	// Branch and jump instructions.
+	"bcc": {relative: 0x90},
+	"bcs": {relative: 0xB0},
+	"beq": {relative: 0xF0},
+	"bmi": {relative: 0x30},
+	"bne": {relative: 0xD0},
+	"bpl": {relative: 0x10},
+	"bvc": {relative: 0x50},
+	"bvs": {relative: 0x70},
+	"jmp": {absolute: 0x4C, indirect: 0x6C},
+	"jsr": {absolute: 0x20},
+	// Implied instructions.
+	"brk": {implied: 0x00},
+	"nop": {implied: 0xEA},
+	"rti": {implied: 0x40},
+	"rts": {implied: 0x60},
+	"clc": {implied: 0x18},
+	"cld": {implied: 0xD8},
+	"cli": {implied: 0x58},
+	"clv": {implied: 0xB8},
+	"sec": {implied: 0x38},
+	"sed": {implied: 0xF8},
+	"sei": {implied: 0x78},
+	"dex": {implied: 0xCA},
+	"dey": {implied: 0x88},
+	"inx": {implied: 0xE8},
+	"iny": {implied: 0xC8},
+	"pha": {implied: 0x48},
+	"php": {implied: 0x08},
+	"pla": {implied: 0x68},
+	"plp": {implied: 0x28},
+	"tax": {implied: 0xAA},
+	"tay": {implied: 0xA8},
+	"tsx": {implied: 0xBA},
+	"txa": {implied: 0x8A},
+	"txs": {implied: 0x9A},
+	"tya": {implied: 0x98},
+}
+
+// An encoding keeps track of the state of the encoding of an instruction.
+type encoding struct {
+	// Instruction to encode.
+	inst *ast.Inst
+	// Address of the instruction.
+	pc int64
+	// Symbol table used to resolve addresses and branch targets.
+	syms ast.SymbolTable
+	// Opcodes of the addressing modes of the instruction.
+	opcodes map[mode]byte
+}
+
+// encode encodes the instruction.
+func (e *encoding) encode() ([]byte, error) {
+	m, x, err := e.mode()
+	if err != nil {
+		return nil, err
+	}
+	var val int64
+	switch m {
+	case implied, accumulator:
+		return []byte{e.opcodes[m]}, nil
+	case immediate:
+		val, err = e.eval(x, -0x80, 0xFF)
+	case relative:
+		val, err = e.offset(x)
+	case indexedIndirect, indirectIndexed:
+		val, err = e.eval(x, 0, 0xFF)
+	default:
+		if val, err = ast.Eval(x, e.syms); err != nil {
+			break
+		}
+		if _, ok := e.opcodes[m]; ok && asm.Relocated(e.syms, x, false) {
+			_, err = asm.Reloc(e.syms, symtab.Reloc{Offset: 1, Size: 2}, x)
+			val = 0
+			break
+		}
+		m, err = e.address(m, val)
+	}
+	buf := make([]byte, m.size())
+	if err != nil {
+		return buf, err
+	}
+	buf[0] = e.opcodes[m]
+	buf[1] = byte(val)
+	if m.size() == 3 {
+		buf[2] = byte(val >> 8)
+	}
+	return buf, nil
+}
+
+// mode returns the addressing mode of the instruction and its address or
+// immediate operand, as given by the syntax of its operands. Addresses are
+// reported using the absolute addressing modes, as the zero page addressing
+// modes are selected by the value of the address.
+func (e *encoding) mode() (m mode, x ast.Arg, err error) {
+	args := e.inst.Args
+	switch len(args) {
+	case 0:
+		// The accumulator operand of read-modify-write instructions may be
+		// omitted.
+		m = implied
+		if _, ok := e.opcodes[accumulator]; ok {
+			m = accumulator
+		}
+	case 1:
+		switch arg := args[0].(type) {
+		case ast.Reg:
+			m = accumulator
+		case *ast.Imm:
+			m, x = immediate, arg.X
+		case *ast.ParenExpr:
+			m, x = indirect, arg.X
+		case *ast.Mem:
+			if arg.Base != nil || arg.Index != mos6502arch.X {
+				return 0, nil, fmt.Errorf("mos6502.encoding.mode: invalid memory operand of %q; expected (zp, x)", e.inst.Op)
+			}
+			m, x = indexedIndirect, arg.Disp
+		default:
+			m, x = absolute, arg
+			if _, ok := e.opcodes[relative]; ok {
+				m = relative
+			}
+		}
+	case 2:
+		switch arg := args[0].(type) {
+		case *ast.Imm:
+			return 0, nil, fmt.Errorf("mos6502.encoding.mode: invalid indexed immediate operand of %q", e.inst.Op)
+		case *ast.ParenExpr:
+			if args[1] != mos6502arch.Y {
+				return 0, nil, fmt.Errorf("mos6502.encoding.mode: invalid indirect operand of %q; expected (zp), y", e.inst.Op)
+			}
+			m, x = indirectIndexed, arg.X
+		default:
+			m, x = absoluteX, arg
+			if args[1] == mos6502arch.Y {
+				m = absoluteY
+			}
+		}
+	}
+	if !e.supports(m) {
+		return 0, nil, fmt.Errorf("mos6502.encoding.mode: %s addressing mode not supported by %q", m, e.inst.Op)
+	}
+	return m, x, nil
+}
+
+// supports returns true if the instruction supports the given addressing mode,
+// or the zero page addressing mode corresponding to the given absolute
+// addressing mode; and false otherwise.
+func (e *encoding) supports(m mode) bool {
+	if _, ok := e.opcodes[m]; ok {
+		return true
+	}
+	zp, ok := zeroPageModes[m]
+	if !ok {
+		return false
+	}
+	_, ok = e.opcodes[zp]
+	return ok
+}
+
+// address returns the addressing mode of the given absolute addressing mode,
+// which is the corresponding zero page addressing mode if supported by the
+// instruction and if addr is located in the zero page.
+func (e *encoding) address(m mode, addr int64) (mode, error) {
+	zp := zeroPageModes[m]
+	_, hasZP := e.opcodes[zp]
+	_, hasAbs := e.opcodes[m]
+	switch {
+	case hasZP && addr >= 0 && addr <= 0xFF:
+		return zp, nil
+	case !hasAbs:
+		return zp, fmt.Errorf("mos6502.encoding.address: address 0x%X of %q out of range; expected zero page address in range [0x00, 0xFF]", addr, e.inst.Op)
+	case addr < 0 || addr > 0xFFFF:
+		return m, fmt.Errorf("mos6502.encoding.address: address 0x%X of %q out of range [0x0000, 0xFFFF]", addr, e.inst.Op)
+	}
+	return m, nil
+}
+
+// eval evaluates x and returns its value, which must be in the given range.
+func (e *encoding) eval(x ast.Arg, min, max int64) (int64, error) {
+	val, err := ast.Eval(x, e.syms)
+	if err != nil {
+		return 0, err
+	}
+	if val < min || val > max {
+		return 0, fmt.Errorf("mos6502.encoding.eval: operand %d of %q out of range [%d, %d]", val, e.inst.Op, min, max)
+	}
+	return val, nil
+}
+
+// offset returns the offset of the given branch target, relative to the
+// address of the instruction following the branch.
+func (e *encoding) offset(target ast.Arg) (int64, error) {
+	addr, err := ast.Eval(target, e.syms)
+	if err != nil {
+		return 0, err
+	}
+	off := addr - (e.pc + 2)
+	if off < -0x80 || off > 0x7F {
+		return 0, fmt.Errorf("mos6502.encoding.offset: target 0x%X of %q out of range; offset %d not in range [-128, 127]", addr, e.inst.Op, off)
+	}
+	return off, nil
+}
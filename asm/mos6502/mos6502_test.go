@@ -0,0 +1,271 @@
+package mos6502
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/mewlang/asm/internal/asmtest"
+)
+
+func TestEncode(t *testing.T) {
+	// The expected encodings have been verified against the opcode matrix of
+	// the MCS6500 family programming manual.
+	golden := []struct{ in, want string }{
+		// i=0
+		{in: "lda #0x10", want: "a910"},
+		// i=1
+		{in: "lda #-1", want: "a9ff"},
+		// i=2
+		{in: "lda #'A'", want: "a941"},
+		// i=3
+		{in: "lda 0x10", want: "a510"},
+		// i=4
+		{in: "lda 0x10, x", want: "b510"},
+		// i=5
+		{in: "lda 0x10, y", want: "b91000"},
+		// i=6
+		{in: "lda 0x1234", want: "ad3412"},
+		// i=7
+		{in: "lda 0x1234, x", want: "bd3412"},
+		// i=8
+		{in: "lda 0x1234, y", want: "b93412"},
+		// i=9
+		{in: "lda (0x10, x)", want: "a110"},
+		// i=10
+		{in: "lda (0x10), y", want: "b110"},
+		// i=11
+		{in: "lda (0x20, X)", want: "a120"},
+		// i=12
+		{in: "sta 0x00", want: "8500"},
+		// i=13
+		{in: "sta 0xFF, x", want: "95ff"},
+		// i=14
+		{in: "sta 0x0200", want: "8d0002"},
+		// i=15
+		{in: "sta 0x0200, x", want: "9d0002"},
+		// i=16
+		{in: "sta 0x0200, y", want: "990002"},
+		// i=17
+		{in: "sta (0x10, x)", want: "8110"},
+		// i=18
+		{in: "sta (0x10), y", want: "9110"},
+		// i=19
+		{in: "adc #1", want: "6901"},
+		// i=20
+		{in: "and #0x0F", want: "290f"},
+		// i=21
+		{in: "cmp 0x80", want: "c580"},
+		// i=22
+		{in: "eor 0x1000, x", want: "5d0010"},
+		// i=23
+		{in: "ora (0xFE), y", want: "11fe"},
+		// i=24
+		{in: "sbc 0x44, y", want: "f94400"},
+		// i=25
+		{in: "asl", want: "0a"},
+		// i=26
+		{in: "asl a", want: "0a"},
+		// i=27
+		{in: "lsr A", want: "4a"},
+		// i=28
+		{in: "rol 0x10", want: "2610"},
+		// i=29
+		{in: "ror 0x1000, x", want: "7e0010"},
+		// i=30
+		{in: "inc 0x10, x", want: "f610"},
+		// i=31
+		{in: "dec 0x4000", want: "ce0040"},
+		// i=32
+		{in: "ldx #0", want: "a200"},
+		// i=33
+		{in: "ldx 0x10, y", want: "b610"},
+		// i=34
+		{in: "ldx 0x1000, y", want: "be0010"},
+		// i=35
+		{in: "ldy 0x10, x", want: "b410"},
+		// i=36
+		{in: "ldy 0x1000, x", want: "bc0010"},
+		// i=37
+		{in: "stx 0x10", want: "8610"},
+		// i=38
+		{in: "stx 0x10, y", want: "9610"},
+		// i=39
+		{in: "stx 0x2000", want: "8e0020"},
+		// i=40
+		{in: "sty 0x10, x", want: "9410"},
+		// i=41
+		{in: "sty 0x2000", want: "8c0020"},
+		// i=42
+		{in: "cpx #0x20", want: "e020"},
+		// i=43
+		{in: "cpy 0x10", want: "c410"},
+		// i=44
+		{in: "bit 0x2002", want: "2c0220"},
+		// i=45
+		{in: "bit 0x10", want: "2410"},
+		// i=46
+		{in: "jmp 0x8000", want: "4c0080"},
+		// i=47
+		{in: "jmp 0x0010", want: "4c1000"},
+		// i=48
+		{in: "jmp (0xFFFC)", want: "6cfcff"},
+		// i=49
+		{in: "jsr 0xC000", want: "2000c0"},
+		// i=50
+		{in: "beq 0x10", want: "f00e"},
+		// i=51
+		{in: "bne 0", want: "d0fe"},
+		// i=52
+		{in: "bpl 0x81", want: "107f"},
+		// i=53
+		{in: "bcc 2", want: "9000"},
+		// i=54
+		{in: "brk", want: "00"},
+		// i=55
+		{in: "nop", want: "ea"},
+		// i=56
+		{in: "rti", want: "40"},
+		// i=57
+		{in: "rts", want: "60"},
+		// i=58
+		{in: "sei", want: "78"},
+		// i=59
+		{in: "cld", want: "d8"},
+		// i=60
+		{in: "txs", want: "9a"},
+		// i=61
+		{in: "pha", want: "48"},
+		// i=62
+		{in: "pla", want: "68"},
+		// i=63
+		{in: "inx", want: "e8"},
+		// i=64
+		{in: "dey", want: "88"},
+		// i=65
+		{in: "tya", want: "98"},
+		// i=66
+		{in: "lda 0x100 - 1", want: "a5ff"},
+		// i=67
+		{in: "lda (ptr), y\nptr equ 0x20", want: "b120"},
+	}
+
+	for i, g := range golden {
+		got, err := asmtest.Text(Encoder, g.in)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		want, err := hex.DecodeString(g.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("i=%d: encoding mismatch of %q; expected %x, got %x", i, g.in, want, got)
+		}
+	}
+}
+
+func TestEncodeLabels(t *testing.T) {
+	// The zero page variables are declared after their first use, and are
+	// therefore known to be located in the zero page only after the first pass.
+	const src = `
+	org 0x8000
+reset:
+	sei
+	ldx #0xFF
+	txs
+	lda #msg & 0xFF
+	sta ptr
+	lda #msg >> 8
+	sta ptr + 1
+	ldy #0
+loop:
+	lda (ptr), y
+	beq done
+	sta 0x2007
+	iny
+	bne loop
+done:
+	jmp done
+msg:
+	db "hi", 0
+ptr equ 0x10
+`
+	want := []string{
+		"78",     // sei
+		"a2ff",   // ldx #0xFF
+		"9a",     // txs
+		"a91b",   // lda #msg & 0xFF
+		"8510",   // sta ptr
+		"a980",   // lda #msg >> 8
+		"8511",   // sta ptr + 1
+		"a000",   // ldy #0
+		"b110",   // lda (ptr), y
+		"f006",   // beq done
+		"8d0720", // sta 0x2007
+		"c8",     // iny
+		"d0f6",   // bne loop
+		"4c1880", // jmp done
+		"686900", // "hi", 0
+	}
+	got, err := asmtest.Text(Encoder, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := hex.DecodeString(strings.Join(want, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, w) {
+		t.Errorf("encoding mismatch; expected %x, got %x", w, got)
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	golden := []struct {
+		in   string
+		want string
+	}{
+		// i=0
+		{in: "lda #0x100", want: `1:1: mos6502.encoding.eval: operand 256 of "lda" out of range [-128, 255]`},
+		// i=1
+		{in: "lda (0x100), y", want: `1:1: mos6502.encoding.eval: operand 256 of "lda" out of range [0, 255]`},
+		// i=2
+		{in: "lda 0x10000", want: `1:1: mos6502.encoding.address: address 0x10000 of "lda" out of range [0x0000, 0xFFFF]`},
+		// i=3
+		{in: "stx 0x100, y", want: `1:1: mos6502.encoding.address: address 0x100 of "stx" out of range; expected zero page address in range [0x00, 0xFF]`},
+		// i=4
+		{in: "sta #1", want: `1:1: mos6502.encoding.mode: immediate addressing mode not supported by "sta"`},
+		// i=5
+		{in: "lda (0x10)", want: `1:1: mos6502.encoding.mode: indirect addressing mode not supported by "lda"`},
+		// i=6
+		{in: "jsr (0x10)", want: `1:1: mos6502.encoding.mode: indirect addressing mode not supported by "jsr"`},
+		// i=7
+		{in: "lda #1, x", want: `1:1: mos6502.encoding.mode: invalid indexed immediate operand of "lda"`},
+		// i=8
+		{in: "lda (0x10), x", want: `1:1: mos6502.encoding.mode: invalid indirect operand of "lda"; expected (zp), y`},
+		// i=9
+		{in: "lda 0x10(x)", want: `1:1: mos6502.encoding.mode: invalid memory operand of "lda"; expected (zp, x)`},
+		// i=10
+		{in: "beq 0x100", want: `1:1: mos6502.encoding.offset: target 0x100 of "beq" out of range; offset 254 not in range [-128, 127]`},
+		// i=11
+		{in: "ldy 0x10, y", want: `1:1: parser.parseInst: arch.OpTable.Validate: invalid operands of "ldy"; expected "imm" or "imm, x"; got [identifier]: "ldy"`},
+		// i=12
+		{in: "lda a", want: `1:1: parser.parseInst: arch.OpTable.Validate: invalid operands of "lda"; expected "imm" or "imm, x" or "imm, y" or "mem"; got [identifier]: "lda"`},
+		// i=13
+		{in: "bne done", want: `1:1: ast.Eval: undefined symbol "done"`},
+	}
+
+	for i, g := range golden {
+		_, err := asmtest.Text(Encoder, g.in)
+		if err == nil {
+			t.Errorf("i=%d: expected error %q, got nil", i, g.want)
+			continue
+		}
+		if got := err.Error(); got != g.want {
+			t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
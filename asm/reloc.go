@@ -0,0 +1,205 @@
+package asm
+
+import (
+	"fmt"
+
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/symtab"
+)
+
+// A Relocator is a symbol table which records the relocation records of the
+// machine code of an instruction. The symbol tables passed to Encoder.Encode by
+// the assembler are relocators.
+//
+// The fields of machine code which refer to addresses assigned at link time,
+// such as the addresses of external symbols, are reported by the encoder and
+// encoded as zero. The assembler reports an error if the operands of an
+// instruction refer to such an address, which the encoder did not report.
+type Relocator interface {
+	ast.SymbolTable
+	// Relocated returns true if a field of machine code which refers to the
+	// address x is relocated, and false otherwise. PC-relative fields which
+	// refer to the section of the instruction are resolved by the assembler.
+	Relocated(x ast.Arg, pcrel bool) bool
+	// Reloc records the relocation record r of a field of machine code which
+	// refers to the address x, and returns true if the field is relocated. The
+	// offset of r is relative to the start of the machine code of the
+	// instruction, and the addend of the reference of x is added to the
+	// addend of r.
+	Reloc(r symtab.Reloc, x ast.Arg) (bool, error)
+}
+
+// Relocated returns true if a field of machine code which refers to the address
+// x is relocated, as specified by the symbol table of the instruction if a
+// Relocator, and false otherwise.
+func Relocated(syms ast.SymbolTable, x ast.Arg, pcrel bool) bool {
+	if rel, ok := syms.(Relocator); ok {
+		return rel.Relocated(x, pcrel)
+	}
+	return false
+}
+
+// Reloc records the relocation record r of a field of machine code which refers
+// to the address x using the symbol table of the instruction; if a Relocator.
+// It returns true if the field is relocated, in which case the field is encoded
+// as zero.
+func Reloc(syms ast.SymbolTable, r symtab.Reloc, x ast.Arg) (bool, error) {
+	if rel, ok := syms.(Relocator); ok {
+		return rel.Reloc(r, x)
+	}
+	return false, nil
+}
+
+// A relocator is the symbol table of an instruction or data directive, which
+// records the relocation records of its machine code.
+type relocator struct {
+	*symtab.Table
+	// Section of the instruction or data directive.
+	sect *Section
+	// Address of the instruction or data directive.
+	pc int64
+	// Operator of the instruction, or keyword of the data directive.
+	op string
+	// Relocation records of the machine code.
+	relocs []symtab.Reloc
+	// Reported references, by section and external symbol.
+	reported map[symtab.Ref]bool
+	// Minimum size in bytes of the machine code of the instruction.
+	min int
+}
+
+// newRelocator returns a new relocator of the instruction or data directive
+// located at address pc of sect.
+func (a *assembler) newRelocator(sect *Section, pc int64, op string) *relocator {
+	return &relocator{Table: a.syms, sect: sect, pc: pc, op: op, reported: make(map[symtab.Ref]bool)}
+}
+
+// MinSize returns the minimum size in bytes of the machine code of the
+// instruction.
+func (r *relocator) MinSize() int {
+	return r.min
+}
+
+// ref returns the reference of the address x to an address assigned at link
+// time, and true if present; which is recorded as reported.
+func (r *relocator) ref(x ast.Arg) (ref symtab.Ref, ok bool, err error) {
+	ref, ok, err = r.Ref(x)
+	if err != nil {
+		if ref.Name != "" {
+			return symtab.Ref{}, false, r.relocError(ref.Name)
+		}
+		return symtab.Ref{}, false, err
+	}
+	if ok {
+		r.reported[symtab.Ref{Section: ref.Section, Symbol: ref.Symbol}] = true
+	}
+	return ref, ok, nil
+}
+
+// Relocated returns true if a field of machine code which refers to the address
+// x is relocated, and false otherwise.
+func (r *relocator) Relocated(x ast.Arg, pcrel bool) bool {
+	ref, ok, err := r.ref(x)
+	if err != nil || !ok {
+		return false
+	}
+	return !pcrel || ref.Section != r.sect.Name
+}
+
+// Reloc records the relocation record rel of a field of machine code which
+// refers to the address x, and returns true if the field is relocated.
+func (r *relocator) Reloc(rel symtab.Reloc, x ast.Arg) (bool, error) {
+	ref, ok, err := r.ref(x)
+	if err != nil || !ok {
+		return false, err
+	}
+	// The page offset of adrp depends on the address of the section, unlike
+	// other PC-relative fields.
+	if rel.PCRel && rel.Type != symtab.ARM64Page21 && ref.Section == r.sect.Name {
+		return false, nil
+	}
+	rel.Offset += r.pc - r.sect.Addr
+	rel.Section, rel.Symbol = ref.Section, ref.Symbol
+	rel.Addend += ref.Addend
+	r.relocs = append(r.relocs, rel)
+	return true, nil
+}
+
+// check returns an error if the given operands refer to an address assigned at
+// link time, which was not reported.
+func (r *relocator) check(args []ast.Arg) error {
+	for _, x := range exprs(nil, args...) {
+		ref, ok, err := r.Ref(x)
+		switch {
+		case err != nil && ref.Name != "":
+			return r.relocError(ref.Name)
+		case ok && !r.reported[symtab.Ref{Section: ref.Section, Symbol: ref.Symbol}]:
+			return r.relocError(ref.Name)
+		}
+	}
+	return nil
+}
+
+// relocError returns an error reporting that the reference to the named symbol
+// may not be relocated.
+func (r *relocator) relocError(name string) error {
+	return fmt.Errorf("asm.Assemble: unable to relocate reference to symbol %q of %q", name, r.op)
+}
+
+// exprs appends the expressions of the given operands to xs.
+func exprs(xs []ast.Arg, args ...ast.Arg) []ast.Arg {
+	for _, arg := range args {
+		switch arg := arg.(type) {
+		case nil, ast.Reg, *ast.RegList, ast.String:
+		case *ast.Mem:
+			xs = exprs(xs, arg.Disp)
+		case *ast.ShiftedReg:
+			xs = exprs(xs, arg.X, arg.Amount)
+		case *ast.Writeback:
+			xs = exprs(xs, arg.X)
+		case *ast.Imm:
+			xs = exprs(xs, arg.X)
+		case *ast.Literal:
+			xs = exprs(xs, arg.X)
+		default:
+			xs = append(xs, arg)
+		}
+	}
+	return xs
+}
+
+// dataKeywords maps from element width to the keyword of data directives.
+var dataKeywords = map[int]string{1: "db", 2: "dw", 4: "dd", 8: "dq"}
+
+// data returns the data stored by the data directive located at address pc of
+// sect. The elements which refer to addresses assigned at link time are
+// relocated, and hold zero.
+func (a *assembler) data(sect *Section, pc int64, dir *ast.DataDir) ([]byte, []symtab.Reloc, error) {
+	r := a.newRelocator(sect, pc, dataKeywords[dir.Width])
+	vals := dir.Vals
+	off := 0
+	for i, val := range dir.Vals {
+		if s, ok := val.(ast.String); ok {
+			off += int(symtab.AlignUp(int64(len(s)), int64(dir.Width)))
+			continue
+		}
+		if !ast.IsFloat(val) {
+			ok, err := r.Reloc(symtab.Reloc{Offset: int64(off), Size: dir.Width}, val)
+			if err != nil {
+				return nil, nil, err
+			}
+			if ok {
+				if &vals[0] == &dir.Vals[0] {
+					vals = append([]ast.Arg(nil), dir.Vals...)
+				}
+				vals[i] = ast.Int(0)
+			}
+		}
+		off += dir.Width
+	}
+	buf, err := (&ast.DataDir{Width: dir.Width, Vals: vals}).Encode(r, a.enc.Arch().ByteOrder())
+	if err != nil {
+		return nil, nil, err
+	}
+	return buf, r.relocs, nil
+}
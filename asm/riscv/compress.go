@@ -0,0 +1,574 @@
+package riscv
+
+import (
+	"fmt"
+
+	riscvarch "github.com/mewlang/asm/arch/riscv"
+	"github.com/mewlang/asm/ast"
+)
+
+// Compressed instructions are encoded into the CR, CI, CSS, CIW, CL, CS, CA, CB
+// and CJ instruction formats. The three-bit register fields rd', rs1' and rs2'
+// specify one of the eight registers x8-x15.
+//
+//	CR:  funct4(4) rd/rs1(5) rs2(5) op(2)
+//	CI:  funct3(3) imm(1) rd/rs1(5) imm(5) op(2)
+//	CSS: funct3(3) imm(6) rs2(5) op(2)
+//	CIW: funct3(3) imm(8) rd'(3) op(2)
+//	CL:  funct3(3) imm(3) rs1'(3) imm(2) rd'(3) op(2)
+//	CS:  funct3(3) imm(3) rs1'(3) imm(2) rs2'(3) op(2)
+//	CA:  funct6(6) rd'/rs1'(3) funct2(2) rs2'(3) op(2)
+//	CB:  funct3(3) offset(3) rs1'(3) offset(5) op(2)
+//	CJ:  funct3(3) jump target(11) op(2)
+
+// encodeCompressed encodes the explicitly compressed instruction.
+func (e *encoding) encodeCompressed() error {
+	op, args := e.inst.Op, e.inst.Args
+	switch op {
+	case "c.nop":
+		e.emit16(cNop)
+		return nil
+	case "c.ebreak":
+		e.emit16(cEbreak)
+		return nil
+	case "c.addi", "c.addiw", "c.li", "c.andi":
+		var rd uint32
+		var err error
+		if op == "c.andi" {
+			rd, err = e.creg(args[0])
+		} else {
+			rd, err = e.nonzero(args[0])
+		}
+		if err != nil {
+			return err
+		}
+		imm, err := e.cimm(args[1], -32, 31, 1, op == "c.addi")
+		if err != nil {
+			return err
+		}
+		switch op {
+		case "c.addi":
+			e.emit16(ci(0, 1, rd, imm))
+		case "c.addiw":
+			e.emit16(ci(1, 1, rd, imm))
+		case "c.li":
+			e.emit16(ci(2, 1, rd, imm))
+		case "c.andi":
+			e.emit16(cb(4, 1, rd, 2<<3|uint16(imm>>5&1)<<5, imm))
+		}
+		return nil
+	case "c.lui":
+		rd, err := e.nonzero(args[0])
+		if err != nil {
+			return err
+		}
+		if rd == uint32(riscvarch.SP) {
+			return fmt.Errorf("riscv.encoding.encodeCompressed: invalid destination register sp of %q", op)
+		}
+		imm, err := ast.Eval(args[1], e.syms)
+		if err != nil {
+			return err
+		}
+		if !(1 <= imm && imm <= 0x1F || 0xFFFE0 <= imm && imm <= 0xFFFFF) {
+			return fmt.Errorf("riscv.encoding.encodeCompressed: immediate %d of %q out of range [1, 31] or [0xFFFE0, 0xFFFFF]", imm, op)
+		}
+		e.emit16(ci(3, 1, rd, uint32(imm)))
+		return nil
+	case "c.addi16sp":
+		if reg(args[0]) != uint32(riscvarch.SP) {
+			return fmt.Errorf("riscv.encoding.encodeCompressed: invalid destination register of %q; expected sp", op)
+		}
+		imm, err := e.cimm(args[1], -512, 496, 16, true)
+		if err != nil {
+			return err
+		}
+		e.emit16(cAddi16sp(imm))
+		return nil
+	case "c.addi4spn":
+		rd, err := e.creg(args[0])
+		if err != nil {
+			return err
+		}
+		if reg(args[1]) != uint32(riscvarch.SP) {
+			return fmt.Errorf("riscv.encoding.encodeCompressed: invalid source register of %q; expected sp", op)
+		}
+		imm, err := e.cimm(args[2], 4, 1020, 4, true)
+		if err != nil {
+			return err
+		}
+		e.emit16(cAddi4spn(rd, imm))
+		return nil
+	case "c.slli", "c.srli", "c.srai":
+		var rd uint32
+		var err error
+		if op == "c.slli" {
+			rd, err = e.nonzero(args[0])
+		} else {
+			rd, err = e.creg(args[0])
+		}
+		if err != nil {
+			return err
+		}
+		shamt, err := e.cimm(args[1], 1, int64(e.xlen-1), 1, true)
+		if err != nil {
+			return err
+		}
+		switch op {
+		case "c.slli":
+			e.emit16(ci(0, 2, rd, shamt))
+		case "c.srli":
+			e.emit16(cb(4, 1, rd, uint16(shamt>>5&1)<<5, shamt))
+		case "c.srai":
+			e.emit16(cb(4, 1, rd, 1<<3|uint16(shamt>>5&1)<<5, shamt))
+		}
+		return nil
+	case "c.mv", "c.add":
+		rd, err := e.nonzero(args[0])
+		if err != nil {
+			return err
+		}
+		rs2, err := e.nonzero(args[1])
+		if err != nil {
+			return err
+		}
+		funct4 := uint16(8)
+		if op == "c.add" {
+			funct4 = 9
+		}
+		e.emit16(cr(funct4, rd, rs2))
+		return nil
+	case "c.sub", "c.xor", "c.or", "c.and", "c.subw", "c.addw":
+		rd, err := e.creg(args[0])
+		if err != nil {
+			return err
+		}
+		rs2, err := e.creg(args[1])
+		if err != nil {
+			return err
+		}
+		e.emit16(ca(caOps[op], rd, rs2))
+		return nil
+	case "c.j", "c.jal":
+		off, err := e.offset(args[0], 12)
+		if err != nil {
+			return err
+		}
+		funct3 := uint16(5)
+		if op == "c.jal" {
+			funct3 = 1
+		}
+		e.emit16(cj(funct3, off))
+		return nil
+	case "c.jr", "c.jalr":
+		rs1, err := e.nonzero(args[0])
+		if err != nil {
+			return err
+		}
+		funct4 := uint16(8)
+		if op == "c.jalr" {
+			funct4 = 9
+		}
+		e.emit16(cr(funct4, rs1, 0))
+		return nil
+	case "c.beqz", "c.bnez":
+		rs1, err := e.creg(args[0])
+		if err != nil {
+			return err
+		}
+		off, err := e.offset(args[1], 9)
+		if err != nil {
+			return err
+		}
+		funct3 := uint16(6)
+		if op == "c.bnez" {
+			funct3 = 7
+		}
+		e.emit16(cBranch(funct3, rs1, off))
+		return nil
+	case "c.lw", "c.sw", "c.ld", "c.sd":
+		rd, err := e.creg(args[0])
+		if err != nil {
+			return err
+		}
+		mem := args[1].(*ast.Mem)
+		base, ok := mem.Base.(ast.Reg)
+		if !ok || mem.Index != nil || mem.Rel {
+			return fmt.Errorf("riscv.encoding.encodeCompressed: invalid memory operand of %q; expected offset(register)", op)
+		}
+		rs1, err := e.creg(base)
+		if err != nil {
+			return err
+		}
+		size := int64(4)
+		if op == "c.ld" || op == "c.sd" {
+			size = 8
+		}
+		off, err := e.cimm(disp(mem), 0, 31*size, size, false)
+		if err != nil {
+			return err
+		}
+		e.emit16(cLoadStore(cLoadStoreOps[op], rs1, rd, off))
+		return nil
+	case "c.lwsp", "c.swsp", "c.ldsp", "c.sdsp":
+		r := reg(args[0])
+		if op == "c.lwsp" || op == "c.ldsp" {
+			var err error
+			if r, err = e.nonzero(args[0]); err != nil {
+				return err
+			}
+		}
+		mem := args[1].(*ast.Mem)
+		if mem.Base != riscvarch.SP || mem.Index != nil || mem.Rel {
+			return fmt.Errorf("riscv.encoding.encodeCompressed: invalid memory operand of %q; expected offset(sp)", op)
+		}
+		size := int64(4)
+		if op == "c.ldsp" || op == "c.sdsp" {
+			size = 8
+		}
+		off, err := e.cimm(disp(mem), 0, 63*size, size, false)
+		if err != nil {
+			return err
+		}
+		switch op {
+		case "c.lwsp":
+			e.emit16(cLwsp(r, off))
+		case "c.ldsp":
+			e.emit16(cLdsp(r, off))
+		case "c.swsp":
+			e.emit16(cSwsp(r, off))
+		case "c.sdsp":
+			e.emit16(cSdsp(r, off))
+		}
+		return nil
+	}
+	return fmt.Errorf("riscv.Encoder.Encode: support for instruction %q not yet implemented", op)
+}
+
+// disp returns the displacement of the memory operand; or 0 if not present.
+func disp(mem *ast.Mem) ast.Arg {
+	if mem.Disp == nil {
+		return ast.Int(0)
+	}
+	return mem.Disp
+}
+
+// creg returns the three-bit register field of the register operand x, which
+// must be one of x8-x15.
+func (e *encoding) creg(x ast.Arg) (uint32, error) {
+	r := reg(x)
+	if r < 8 || r > 15 {
+		return 0, fmt.Errorf("riscv.encoding.creg: invalid register %s of %q; expected one of s0, s1 or a0-a5", riscvarch.Arch.RegName(ast.Reg(r)), e.inst.Op)
+	}
+	return r - 8, nil
+}
+
+// nonzero returns the register number of the register operand x, which must
+// not be zero.
+func (e *encoding) nonzero(x ast.Arg) (uint32, error) {
+	r := reg(x)
+	if r == 0 {
+		return 0, fmt.Errorf("riscv.encoding.nonzero: invalid register zero of %q", e.inst.Op)
+	}
+	return r, nil
+}
+
+// cimm evaluates x and returns its value as an immediate of a compressed
+// instruction. The value must be within the range [min, max] and a multiple of
+// align, and if nz is set it must be non-zero.
+func (e *encoding) cimm(x ast.Arg, min, max, align int64, nz bool) (uint32, error) {
+	val, err := ast.Eval(x, e.syms)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case val < min || val > max:
+		return 0, fmt.Errorf("riscv.encoding.cimm: immediate %d of %q out of range [%d, %d]", val, e.inst.Op, min, max)
+	case val%align != 0:
+		return 0, fmt.Errorf("riscv.encoding.cimm: immediate %d of %q not a multiple of %d", val, e.inst.Op, align)
+	case nz && val == 0:
+		return 0, fmt.Errorf("riscv.encoding.cimm: invalid immediate 0 of %q; expected non-zero value", e.inst.Op)
+	}
+	return uint32(val), nil
+}
+
+// compress returns the compressed instruction equivalent to the 32-bit
+// instruction word w and true if present, and false otherwise. When more than
+// one compressed instruction is applicable, the one chosen by GNU as and LLVM
+// is used.
+func compress(w uint32, xlen int) (uint16, bool) {
+	opcode := w & 0x7F
+	rd := w >> 7 & 0x1F
+	funct3 := w >> 12 & 7
+	rs1 := w >> 15 & 0x1F
+	rs2 := w >> 20 & 0x1F
+	funct7 := w >> 25
+	imm := int64(int32(w) >> 20)
+	rdc, rdok := cregNum(rd)
+	rs1c, rs1ok := cregNum(rs1)
+	rs2c, rs2ok := cregNum(rs2)
+	sp := uint32(riscvarch.SP)
+
+	switch opcode {
+	case opImm:
+		switch funct3 {
+		case 0: // addi
+			switch {
+			case rd == 0 && rs1 == 0 && imm == 0:
+				return cNop, true
+			case rd != 0 && rs1 != 0 && imm == 0:
+				return cr(8, rd, rs1), true
+			case rd != 0 && rs1 == 0 && fits(imm, 6):
+				return ci(2, 1, rd, uint32(imm)), true
+			case rd != 0 && rd == rs1 && fits(imm, 6):
+				return ci(0, 1, rd, uint32(imm)), true
+			case rd == sp && rs1 == sp && imm%16 == 0 && fits(imm, 10):
+				return cAddi16sp(uint32(imm)), true
+			case rdok && rs1 == sp && imm > 0 && imm%4 == 0 && imm < 1024:
+				return cAddi4spn(rdc, uint32(imm)), true
+			}
+		case 1: // slli
+			if rd != 0 && rd == rs1 && imm != 0 {
+				return ci(0, 2, rd, uint32(imm)), true
+			}
+		case 5: // srli, srai
+			shamt := uint32(imm) & 0x3F
+			if rdok && rd == rs1 && shamt != 0 {
+				return cb(4, 1, rdc, uint16(imm>>10&1)<<3|uint16(shamt>>5)<<5, shamt), true
+			}
+		case 7: // andi
+			if rdok && rd == rs1 && fits(imm, 6) {
+				return cb(4, 1, rdc, 2<<3|uint16(imm>>5&1)<<5, uint32(imm)), true
+			}
+		}
+	case opImm32:
+		// addiw
+		if funct3 == 0 && rd != 0 && rd == rs1 && fits(imm, 6) {
+			return ci(1, 1, rd, uint32(imm)), true
+		}
+	case opLui:
+		hi := w >> 12
+		if rd != 0 && rd != sp && (1 <= hi && hi <= 0x1F || hi >= 0xFFFE0) {
+			return ci(3, 1, rd, hi), true
+		}
+	case opOp:
+		switch {
+		case funct7 == 0 && funct3 == 0: // add
+			switch {
+			case rd == 0:
+			case rs1 == 0 && rs2 != 0:
+				return cr(8, rd, rs2), true
+			case rs2 == 0 && rs1 != 0:
+				return cr(8, rd, rs1), true
+			case rd == rs1 && rs2 != 0:
+				return cr(9, rd, rs2), true
+			case rd == rs2 && rs1 != 0:
+				return cr(9, rd, rs1), true
+			}
+		case funct7 == 0x20 && funct3 == 0: // sub
+			if rdok && rd == rs1 && rs2ok {
+				return ca(caOps["c.sub"], rdc, rs2c), true
+			}
+		case funct7 == 0 && (funct3 == 4 || funct3 == 6 || funct3 == 7): // xor, or, and
+			funct := map[uint32]uint16{4: caOps["c.xor"], 6: caOps["c.or"], 7: caOps["c.and"]}[funct3]
+			switch {
+			case rdok && rd == rs1 && rs2ok:
+				return ca(funct, rdc, rs2c), true
+			case rdok && rd == rs2 && rs1ok:
+				return ca(funct, rdc, rs1c), true
+			}
+		}
+	case opOp32:
+		switch {
+		case funct7 == 0x20 && funct3 == 0: // subw
+			if rdok && rd == rs1 && rs2ok {
+				return ca(caOps["c.subw"], rdc, rs2c), true
+			}
+		case funct7 == 0 && funct3 == 0: // addw
+			switch {
+			case rdok && rd == rs1 && rs2ok:
+				return ca(caOps["c.addw"], rdc, rs2c), true
+			case rdok && rd == rs2 && rs1ok:
+				return ca(caOps["c.addw"], rdc, rs1c), true
+			}
+		}
+	case opJal:
+		off := jOffset(w)
+		switch {
+		case !fits(off, 12):
+		case rd == 0:
+			return cj(5, uint32(off)), true
+		case rd == uint32(riscvarch.RA) && xlen == 32:
+			return cj(1, uint32(off)), true
+		}
+	case opJalr:
+		if funct3 == 0 && imm == 0 && rs1 != 0 {
+			switch rd {
+			case 0:
+				return cr(8, rs1, 0), true
+			case uint32(riscvarch.RA):
+				return cr(9, rs1, 0), true
+			}
+		}
+	case opBranch:
+		off := bOffset(w)
+		if (funct3 == 0 || funct3 == 1) && rs2 == 0 && rs1ok && fits(off, 9) {
+			return cBranch(uint16(6+funct3), rs1c, uint32(off)), true
+		}
+	case opLoad:
+		switch {
+		case funct3 == 2 && rs1 == sp && rd != 0 && imm%4 == 0 && 0 <= imm && imm < 256:
+			return cLwsp(rd, uint32(imm)), true
+		case funct3 == 2 && rdok && rs1ok && imm%4 == 0 && 0 <= imm && imm < 128:
+			return cLoadStore(cLoadStoreOps["c.lw"], rs1c, rdc, uint32(imm)), true
+		case funct3 == 3 && xlen == 64 && rs1 == sp && rd != 0 && imm%8 == 0 && 0 <= imm && imm < 512:
+			return cLdsp(rd, uint32(imm)), true
+		case funct3 == 3 && xlen == 64 && rdok && rs1ok && imm%8 == 0 && 0 <= imm && imm < 256:
+			return cLoadStore(cLoadStoreOps["c.ld"], rs1c, rdc, uint32(imm)), true
+		}
+	case opStore:
+		off := int64(int32(w)>>25<<5) | int64(rd)
+		switch {
+		case funct3 == 2 && rs1 == sp && off%4 == 0 && 0 <= off && off < 256:
+			return cSwsp(rs2, uint32(off)), true
+		case funct3 == 2 && rs2ok && rs1ok && off%4 == 0 && 0 <= off && off < 128:
+			return cLoadStore(cLoadStoreOps["c.sw"], rs1c, rs2c, uint32(off)), true
+		case funct3 == 3 && xlen == 64 && rs1 == sp && off%8 == 0 && 0 <= off && off < 512:
+			return cSdsp(rs2, uint32(off)), true
+		case funct3 == 3 && xlen == 64 && rs2ok && rs1ok && off%8 == 0 && 0 <= off && off < 256:
+			return cLoadStore(cLoadStoreOps["c.sd"], rs1c, rs2c, uint32(off)), true
+		}
+	case opSystem:
+		if w == 0x00100073 {
+			return cEbreak, true
+		}
+	}
+	return 0, false
+}
+
+// cregNum returns the three-bit register field of register r and true if r is
+// one of x8-x15, and false otherwise.
+func cregNum(r uint32) (uint32, bool) {
+	return r - 8, 8 <= r && r <= 15
+}
+
+// fits returns true if val fits in a sign-extended immediate of the given bit
+// width, and false otherwise.
+func fits(val int64, width uint) bool {
+	return -1<<(width-1) <= val && val < 1<<(width-1)
+}
+
+// bOffset returns the branch offset of the B format instruction word w.
+func bOffset(w uint32) int64 {
+	off := bit(w, 31)<<12 | bit(w, 7)<<11 | (w>>25&0x3F)<<5 | (w>>8&0xF)<<1
+	return int64(int32(off<<19) >> 19)
+}
+
+// jOffset returns the jump offset of the J format instruction word w.
+func jOffset(w uint32) int64 {
+	off := bit(w, 31)<<20 | (w>>12&0xFF)<<12 | bit(w, 20)<<11 | (w>>21&0x3FF)<<1
+	return int64(int32(off<<11) >> 11)
+}
+
+// Compressed instructions without operands.
+const (
+	cNop    = 0x0001
+	cEbreak = 0x9002
+)
+
+// Function fields of CA format instructions; funct6 followed by funct2.
+var caOps = map[ast.Op]uint16{
+	"c.sub":  0x23<<2 | 0,
+	"c.xor":  0x23<<2 | 1,
+	"c.or":   0x23<<2 | 2,
+	"c.and":  0x23<<2 | 3,
+	"c.subw": 0x27<<2 | 0,
+	"c.addw": 0x27<<2 | 1,
+}
+
+// CL and CS format load and store instructions mapped to their funct3 fields.
+var cLoadStoreOps = map[ast.Op]uint16{
+	"c.lw": 2,
+	"c.ld": 3,
+	"c.sw": 6,
+	"c.sd": 7,
+}
+
+// cr returns a CR format instruction, with opcode 2.
+func cr(funct4 uint16, rd, rs2 uint32) uint16 {
+	return funct4<<12 | uint16(rd)<<7 | uint16(rs2)<<2 | 2
+}
+
+// ci returns a CI format instruction, with a six-bit immediate.
+func ci(funct3, op uint16, rd, imm uint32) uint16 {
+	return funct3<<13 | uint16(bit(imm, 5))<<12 | uint16(rd)<<7 | uint16(imm&0x1F)<<2 | op
+}
+
+// ca returns a CA format instruction, with opcode 1.
+func ca(funct uint16, rd, rs2 uint32) uint16 {
+	return (funct>>2)<<10 | uint16(rd)<<7 | (funct&3)<<5 | uint16(rs2)<<2 | 1
+}
+
+// cb returns a CB format instruction with the given bits 12:10 and a five-bit
+// immediate in bits 6:2.
+func cb(funct3, op uint16, rs1 uint32, hi uint16, imm uint32) uint16 {
+	return funct3<<13 | hi<<7 | uint16(rs1)<<7 | uint16(imm&0x1F)<<2 | op
+}
+
+// cj returns a CJ format jump instruction, with a 12-bit offset.
+func cj(funct3 uint16, off uint32) uint16 {
+	target := bit(off, 11)<<10 | bit(off, 4)<<9 | (off>>8&3)<<7 | bit(off, 10)<<6 | bit(off, 6)<<5 | bit(off, 7)<<4 | (off>>1&7)<<1 | bit(off, 5)
+	return funct3<<13 | uint16(target)<<2 | 1
+}
+
+// cBranch returns a CB format branch instruction, with a nine-bit offset.
+func cBranch(funct3 uint16, rs1, off uint32) uint16 {
+	hi := bit(off, 8)<<2 | off>>3&3
+	lo := (off>>6&3)<<3 | (off>>1&3)<<1 | bit(off, 5)
+	return funct3<<13 | uint16(hi)<<10 | uint16(rs1)<<7 | uint16(lo)<<2 | 1
+}
+
+// cAddi16sp returns a c.addi16sp instruction.
+func cAddi16sp(imm uint32) uint16 {
+	lo := bit(imm, 4)<<4 | bit(imm, 6)<<3 | (imm>>7&3)<<1 | bit(imm, 5)
+	return 3<<13 | uint16(bit(imm, 9))<<12 | uint16(riscvarch.SP)<<7 | uint16(lo)<<2 | 1
+}
+
+// cAddi4spn returns a c.addi4spn instruction.
+func cAddi4spn(rd, imm uint32) uint16 {
+	field := (imm>>4&3)<<6 | (imm>>6&0xF)<<2 | bit(imm, 2)<<1 | bit(imm, 3)
+	return uint16(field)<<5 | uint16(rd)<<2
+}
+
+// cLoadStore returns a CL or CS format load or store instruction. Words are
+// loaded and stored by funct3 2 and 6, and double words by funct3 3 and 7.
+func cLoadStore(funct3 uint16, rs1, r, off uint32) uint16 {
+	lo := bit(off, 2)<<1 | bit(off, 6)
+	if funct3&1 == 1 {
+		lo = off >> 6 & 3
+	}
+	return funct3<<13 | uint16(off>>3&7)<<10 | uint16(rs1)<<7 | uint16(lo)<<5 | uint16(r)<<2
+}
+
+// cLwsp returns a c.lwsp instruction.
+func cLwsp(rd, off uint32) uint16 {
+	lo := (off>>2&7)<<2 | off>>6&3
+	return 2<<13 | uint16(bit(off, 5))<<12 | uint16(rd)<<7 | uint16(lo)<<2 | 2
+}
+
+// cLdsp returns a c.ldsp instruction.
+func cLdsp(rd, off uint32) uint16 {
+	lo := (off>>3&3)<<3 | off>>6&7
+	return 3<<13 | uint16(bit(off, 5))<<12 | uint16(rd)<<7 | uint16(lo)<<2 | 2
+}
+
+// cSwsp returns a c.swsp instruction.
+func cSwsp(rs2, off uint32) uint16 {
+	field := (off>>2&0xF)<<2 | off>>6&3
+	return 6<<13 | uint16(field)<<7 | uint16(rs2)<<2 | 2
+}
+
+// cSdsp returns a c.sdsp instruction.
+func cSdsp(rs2, off uint32) uint16 {
+	field := (off>>3&7)<<3 | off>>6&7
+	return 7<<13 | uint16(field)<<7 | uint16(rs2)<<2 | 2
+}
@@ -0,0 +1,776 @@
+// Package riscv implements a RISC-V instruction encoder for the RV32I and RV64I
+// base integer instruction sets, with the M, A and C standard extensions.
+//
+// Instructions are encoded into the R, I, S, B, U and J instruction formats:
+//
+//	R: funct7(7) rs2(5) rs1(5) funct3(3) rd(5) opcode(7)
+//	I: imm(12) rs1(5) funct3(3) rd(5) opcode(7)
+//	S: imm(7) rs2(5) rs1(5) funct3(3) imm(5) opcode(7)
+//	B: imm(7) rs2(5) rs1(5) funct3(3) imm(5) opcode(7)
+//	U: imm(20) rd(5) opcode(7)
+//	J: imm(20) rd(5) opcode(7)
+//
+// Compressed instructions are written using their c. prefixed mnemonics. The
+// encoders with support for the C extension also compress other instructions
+// whenever an equivalent compressed instruction exists, like GNU as.
+//
+// The targets of the call, tail and la pseudo-instructions may refer to
+// addresses assigned at link time; which are reported as relocation records.
+package riscv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"github.com/mewlang/asm/arch"
+	riscvarch "github.com/mewlang/asm/arch/riscv"
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/symtab"
+)
+
+// Instruction encoders of the base integer instruction sets.
+var (
+	// Encoder is the RV32I instruction encoder.
+	Encoder asm.Encoder = encoder{xlen: 32}
+	// Encoder64 is the RV64I instruction encoder.
+	Encoder64 asm.Encoder = encoder{xlen: 64}
+	// EncoderC is the RV32I instruction encoder which compresses instructions.
+	EncoderC asm.Encoder = encoder{xlen: 32, compress: true}
+	// Encoder64C is the RV64I instruction encoder which compresses
+	// instructions.
+	Encoder64C asm.Encoder = encoder{xlen: 64, compress: true}
+)
+
+// encoder implements the asm.Encoder interface for RISC-V.
+type encoder struct {
+	// Width in bits of the general purpose registers; either 32 or 64.
+	xlen int
+	// Compress instructions.
+	compress bool
+}
+
+// Arch returns the RISC-V architecture of the base integer instruction set.
+func (enc encoder) Arch() arch.Arch {
+	if enc.xlen == 64 {
+		return riscvarch.Arch64
+	}
+	return riscvarch.Arch
+}
+
+// Compress returns true if instructions are compressed, and false otherwise.
+func (enc encoder) Compress() bool {
+	return enc.compress
+}
+
+// Encode returns the little-endian machine code of inst, which is located at
+// address pc. Branch and jump targets are resolved using the provided symbol
+// table.
+func (enc encoder) Encode(inst *ast.Inst, pc int64, syms ast.SymbolTable) ([]byte, error) {
+	if err := enc.Arch().Validate(inst); err != nil {
+		return nil, err
+	}
+	e := &encoding{inst: inst, pc: pc, syms: syms, xlen: enc.xlen, compress: enc.compress}
+	if err := e.encode(); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// Major opcodes of 32-bit instructions.
+const (
+	opLoad    = 0x03
+	opMiscMem = 0x0F
+	opImm     = 0x13
+	opAuipc   = 0x17
+	opImm32   = 0x1B
+	opStore   = 0x23
+	opAMO     = 0x2F
+	opOp      = 0x33
+	opLui     = 0x37
+	opOp32    = 0x3B
+	opBranch  = 0x63
+	opJalr    = 0x67
+	opJal     = 0x6F
+	opSystem  = 0x73
+)
+
+// An rop specifies the opcode and function fields of an R format instruction.
+type rop struct {
+	opcode, funct3, funct7 uint32
+}
+
+// rd, rs1, rs2 instructions.
+var regOps = map[ast.Op]rop{
+	"add":    {opOp, 0, 0x00},
+	"sub":    {opOp, 0, 0x20},
+	"sll":    {opOp, 1, 0x00},
+	"slt":    {opOp, 2, 0x00},
+	"sltu":   {opOp, 3, 0x00},
+	"xor":    {opOp, 4, 0x00},
+	"srl":    {opOp, 5, 0x00},
+	"sra":    {opOp, 5, 0x20},
+	"or":     {opOp, 6, 0x00},
+	"and":    {opOp, 7, 0x00},
+	"mul":    {opOp, 0, 0x01},
+	"mulh":   {opOp, 1, 0x01},
+	"mulhsu": {opOp, 2, 0x01},
+	"mulhu":  {opOp, 3, 0x01},
+	"div":    {opOp, 4, 0x01},
+	"divu":   {opOp, 5, 0x01},
+	"rem":    {opOp, 6, 0x01},
+	"remu":   {opOp, 7, 0x01},
+	"addw":   {opOp32, 0, 0x00},
+	"subw":   {opOp32, 0, 0x20},
+	"sllw":   {opOp32, 1, 0x00},
+	"srlw":   {opOp32, 5, 0x00},
+	"sraw":   {opOp32, 5, 0x20},
+	"mulw":   {opOp32, 0, 0x01},
+	"divw":   {opOp32, 4, 0x01},
+	"divuw":  {opOp32, 5, 0x01},
+	"remw":   {opOp32, 6, 0x01},
+	"remuw":  {opOp32, 7, 0x01},
+}
+
+// rd, rs1, imm instructions mapped to their opcodes and function codes.
+var immOps = map[ast.Op][2]uint32{
+	"addi":  {opImm, 0},
+	"slti":  {opImm, 2},
+	"sltiu": {opImm, 3},
+	"xori":  {opImm, 4},
+	"ori":   {opImm, 6},
+	"andi":  {opImm, 7},
+	"addiw": {opImm32, 0},
+}
+
+// rd, rs1, shamt instructions. The funct7 field holds the upper bits of the
+// immediate.
+var shiftOps = map[ast.Op]rop{
+	"slli":  {opImm, 1, 0x00},
+	"srli":  {opImm, 5, 0x00},
+	"srai":  {opImm, 5, 0x20},
+	"slliw": {opImm32, 1, 0x00},
+	"srliw": {opImm32, 5, 0x00},
+	"sraiw": {opImm32, 5, 0x20},
+}
+
+// Load and store instructions mapped to their function codes.
+var (
+	loadOps = map[ast.Op]uint32{
+		"lb":  0,
+		"lh":  1,
+		"lw":  2,
+		"ld":  3,
+		"lbu": 4,
+		"lhu": 5,
+		"lwu": 6,
+	}
+	storeOps = map[ast.Op]uint32{
+		"sb": 0,
+		"sh": 1,
+		"sw": 2,
+		"sd": 3,
+	}
+)
+
+// Branch instructions mapped to their function codes.
+var branchOps = map[ast.Op]uint32{
+	"beq":  0,
+	"bne":  1,
+	"blt":  4,
+	"bge":  5,
+	"bltu": 6,
+	"bgeu": 7,
+}
+
+// Control and status register instructions mapped to their function codes.
+var csrOps = map[ast.Op]uint32{
+	"csrrw":  1,
+	"csrrs":  2,
+	"csrrc":  3,
+	"csrrwi": 5,
+	"csrrsi": 6,
+	"csrrci": 7,
+}
+
+// Atomic memory operations mapped to their funct5 fields.
+var amoOps = map[string]uint32{
+	"lr":      0x02,
+	"sc":      0x03,
+	"amoswap": 0x01,
+	"amoadd":  0x00,
+	"amoxor":  0x04,
+	"amoand":  0x0C,
+	"amoor":   0x08,
+	"amomin":  0x10,
+	"amomax":  0x14,
+	"amominu": 0x18,
+	"amomaxu": 0x1C,
+}
+
+// Branch pseudo-instructions which compare a register against zero, mapped to
+// the native branch instruction and whether the operands are swapped; e.g.
+// bgtz rs is blt zero, rs.
+var branchZeroOps = map[ast.Op]struct {
+	op   ast.Op
+	swap bool
+}{
+	"beqz": {"beq", false},
+	"bnez": {"bne", false},
+	"blez": {"bge", true},
+	"bgez": {"bge", false},
+	"bltz": {"blt", false},
+	"bgtz": {"blt", true},
+}
+
+// Branch pseudo-instructions with swapped operands mapped to the native branch
+// instruction.
+var branchSwapOps = map[ast.Op]ast.Op{
+	"bgt":  "blt",
+	"ble":  "bge",
+	"bgtu": "bltu",
+	"bleu": "bgeu",
+}
+
+// An encoding keeps track of the encoding of a single instruction.
+type encoding struct {
+	// Instruction to encode.
+	inst *ast.Inst
+	// Address of the instruction.
+	pc int64
+	// Symbol table used to resolve identifiers.
+	syms ast.SymbolTable
+	// Width in bits of the general purpose registers; either 32 or 64.
+	xlen int
+	// Compress instructions.
+	compress bool
+	// Machine code of the instruction. Pseudo-instructions may expand to more
+	// than one native instruction.
+	buf []byte
+}
+
+// encode encodes the instruction.
+func (e *encoding) encode() error {
+	op, args := e.inst.Op, e.inst.Args
+	if strings.HasPrefix(string(op), "c.") {
+		return e.encodeCompressed()
+	}
+	if o, ok := regOps[op]; ok {
+		e.emit(rtype(o, reg(args[0]), reg(args[1]), reg(args[2])))
+		return nil
+	}
+	if o, ok := immOps[op]; ok {
+		imm, err := e.simm(args[2], 12)
+		if err != nil {
+			return err
+		}
+		e.emit(itype(o[0], o[1], reg(args[0]), reg(args[1]), imm))
+		return nil
+	}
+	if o, ok := shiftOps[op]; ok {
+		width := uint(5)
+		if e.xlen == 64 && o.opcode == opImm {
+			width = 6
+		}
+		shamt, err := e.uimm(args[2], width)
+		if err != nil {
+			return err
+		}
+		e.emit(itype(o.opcode, o.funct3, reg(args[0]), reg(args[1]), o.funct7<<5|shamt))
+		return nil
+	}
+	if funct3, ok := loadOps[op]; ok {
+		base, off, err := e.mem(args[1])
+		if err != nil {
+			return err
+		}
+		e.emit(itype(opLoad, funct3, reg(args[0]), base, off))
+		return nil
+	}
+	if funct3, ok := storeOps[op]; ok {
+		base, off, err := e.mem(args[1])
+		if err != nil {
+			return err
+		}
+		e.emit(stype(funct3, base, reg(args[0]), off))
+		return nil
+	}
+	if funct3, ok := branchOps[op]; ok {
+		return e.branch(funct3, reg(args[0]), reg(args[1]), args[2])
+	}
+	if funct3, ok := csrOps[op]; ok {
+		csr, err := e.uimm(args[1], 12)
+		if err != nil {
+			return err
+		}
+		var src uint32
+		if funct3 >= 5 {
+			// Zero-extended 5-bit immediate in place of rs1.
+			if src, err = e.uimm(args[2], 5); err != nil {
+				return err
+			}
+		} else {
+			src = reg(args[2])
+		}
+		e.emit(itype(opSystem, funct3, reg(args[0]), src, csr))
+		return nil
+	}
+	if strings.HasPrefix(string(op), "lr.") || strings.HasPrefix(string(op), "sc.") || strings.HasPrefix(string(op), "amo") {
+		return e.amo()
+	}
+	if o, ok := branchZeroOps[op]; ok {
+		rs1, rs2 := reg(args[0]), uint32(riscvarch.Zero)
+		if o.swap {
+			rs1, rs2 = rs2, rs1
+		}
+		return e.branch(branchOps[o.op], rs1, rs2, args[1])
+	}
+	if native, ok := branchSwapOps[op]; ok {
+		return e.branch(branchOps[native], reg(args[1]), reg(args[0]), args[2])
+	}
+
+	zero, ra := uint32(riscvarch.Zero), uint32(riscvarch.RA)
+	switch op {
+	case "lui", "auipc":
+		imm, err := e.uimm(args[1], 20)
+		if err != nil {
+			return err
+		}
+		opcode := uint32(opLui)
+		if op == "auipc" {
+			opcode = opAuipc
+		}
+		e.emit(utype(opcode, reg(args[0]), imm))
+		return nil
+	case "jal":
+		if len(args) == 1 {
+			return e.jump(ra, args[0])
+		}
+		return e.jump(reg(args[0]), args[1])
+	case "jalr":
+		// The return address is stored in ra unless a destination register is
+		// specified.
+		switch len(args) {
+		case 1:
+			e.emit(itype(opJalr, 0, ra, reg(args[0]), 0))
+		case 2:
+			base, off, err := e.mem(args[1])
+			if err != nil {
+				return err
+			}
+			e.emit(itype(opJalr, 0, reg(args[0]), base, off))
+		default:
+			off, err := e.simm(args[2], 12)
+			if err != nil {
+				return err
+			}
+			e.emit(itype(opJalr, 0, reg(args[0]), reg(args[1]), off))
+		}
+		return nil
+	case "fence":
+		pred, succ := uint32(0xF), uint32(0xF)
+		if len(args) == 2 {
+			var err error
+			if pred, err = fenceSet(args[0]); err != nil {
+				return err
+			}
+			if succ, err = fenceSet(args[1]); err != nil {
+				return err
+			}
+		}
+		e.emit(itype(opMiscMem, 0, 0, 0, pred<<4|succ))
+		return nil
+	case "fence.i":
+		e.emit(itype(opMiscMem, 1, 0, 0, 0))
+		return nil
+	case "ecall":
+		e.emit(itype(opSystem, 0, 0, 0, 0))
+		return nil
+	case "ebreak":
+		e.emit(itype(opSystem, 0, 0, 0, 1))
+		return nil
+
+	// Pseudo-instructions.
+	case "nop":
+		e.emit(itype(opImm, 0, zero, zero, 0))
+		return nil
+	case "li":
+		return e.loadImm(reg(args[0]), args[1])
+	case "la":
+		return e.auipcPair(reg(args[0]), args[1], symtab.RISCVPCRelHi20, func(rd, lo uint32) uint32 {
+			return itype(opImm, 0, rd, rd, lo)
+		})
+	case "mv":
+		e.emit(itype(opImm, 0, reg(args[0]), reg(args[1]), 0))
+		return nil
+	case "not":
+		e.emit(itype(opImm, 4, reg(args[0]), reg(args[1]), 0xFFF))
+		return nil
+	case "neg":
+		e.emit(rtype(regOps["sub"], reg(args[0]), zero, reg(args[1])))
+		return nil
+	case "negw":
+		e.emit(rtype(regOps["subw"], reg(args[0]), zero, reg(args[1])))
+		return nil
+	case "sext.w":
+		e.emit(itype(opImm32, 0, reg(args[0]), reg(args[1]), 0))
+		return nil
+	case "seqz":
+		e.emit(itype(opImm, 3, reg(args[0]), reg(args[1]), 1))
+		return nil
+	case "snez":
+		e.emit(rtype(regOps["sltu"], reg(args[0]), zero, reg(args[1])))
+		return nil
+	case "sltz":
+		e.emit(rtype(regOps["slt"], reg(args[0]), reg(args[1]), zero))
+		return nil
+	case "sgtz":
+		e.emit(rtype(regOps["slt"], reg(args[0]), zero, reg(args[1])))
+		return nil
+	case "j":
+		return e.jump(zero, args[0])
+	case "jr":
+		e.emit(itype(opJalr, 0, zero, reg(args[0]), 0))
+		return nil
+	case "ret":
+		e.emit(itype(opJalr, 0, zero, ra, 0))
+		return nil
+	case "call":
+		return e.auipcPair(ra, args[0], symtab.RISCVCall, func(rd, lo uint32) uint32 {
+			return itype(opJalr, 0, ra, rd, lo)
+		})
+	case "tail":
+		return e.auipcPair(uint32(riscvarch.T1), args[0], symtab.RISCVCall, func(rd, lo uint32) uint32 {
+			return itype(opJalr, 0, zero, rd, lo)
+		})
+	}
+	return fmt.Errorf("riscv.Encoder.Encode: support for instruction %q not yet implemented", op)
+}
+
+// amo encodes the atomic instruction. The mnemonic consists of the operation,
+// the width and an optional ordering; e.g. amoadd.w.aqrl.
+func (e *encoding) amo() error {
+	parts := strings.Split(string(e.inst.Op), ".")
+	funct5 := amoOps[parts[0]]
+	funct3 := uint32(2)
+	if parts[1] == "d" {
+		funct3 = 3
+	}
+	var aqrl uint32
+	if len(parts) == 3 {
+		aqrl = map[string]uint32{"aq": 2, "rl": 1, "aqrl": 3}[parts[2]]
+	}
+	args := e.inst.Args
+	rd, rs2, addr := reg(args[0]), uint32(0), args[len(args)-1]
+	if len(args) == 3 {
+		rs2 = reg(args[1])
+	}
+	base, off, err := e.mem(addr)
+	if err != nil {
+		return err
+	}
+	if off != 0 {
+		return fmt.Errorf("riscv.encoding.amo: invalid offset %d of %q; expected 0", int32(off<<20)>>20, e.inst.Op)
+	}
+	o := rop{opcode: opAMO, funct3: funct3, funct7: funct5<<2 | aqrl}
+	e.emit(rtype(o, rd, base, rs2))
+	return nil
+}
+
+// branch encodes a conditional branch to the given target.
+func (e *encoding) branch(funct3, rs1, rs2 uint32, target ast.Arg) error {
+	off, err := e.offset(target, 13)
+	if err != nil {
+		return err
+	}
+	e.emit(btype(funct3, rs1, rs2, off))
+	return nil
+}
+
+// jump encodes a jump to the given target, which stores the return address in
+// rd.
+func (e *encoding) jump(rd uint32, target ast.Arg) error {
+	off, err := e.offset(target, 21)
+	if err != nil {
+		return err
+	}
+	e.emit(jtype(rd, off))
+	return nil
+}
+
+// offset returns the offset of the given branch or jump target, relative to
+// the address of the next native instruction. The offset must be even, and
+// fit in a sign-extended immediate of the given bit width.
+func (e *encoding) offset(target ast.Arg, width uint) (uint32, error) {
+	addr, err := ast.Eval(target, e.syms)
+	if err != nil {
+		return 0, err
+	}
+	off := addr - e.addr()
+	if off&1 != 0 {
+		return 0, fmt.Errorf("riscv.encoding.offset: unaligned branch target 0x%X", addr)
+	}
+	min, max := int64(-1)<<(width-1), int64(1)<<(width-1)-2
+	if off < min || off > max {
+		return 0, fmt.Errorf("riscv.encoding.offset: branch target 0x%X out of range; offset %d not in range [%d, %d]", addr, off, min, max)
+	}
+	return uint32(off), nil
+}
+
+// loadImm encodes the li pseudo-instruction, using the shortest sequence of
+// lui, addi(w), slli and srli instructions which loads the value.
+func (e *encoding) loadImm(rd uint32, x ast.Arg) error {
+	val, err := ast.EvalWidth(x, e.syms, e.xlen)
+	if err != nil {
+		return err
+	}
+	if e.xlen == 32 {
+		val = int64(int32(val))
+	}
+	for _, w := range loadImmSeq(rd, val, e.xlen) {
+		e.emit(w)
+	}
+	return nil
+}
+
+// loadImmSeq returns the sequence of instructions which loads val into rd. The
+// algorithm matches the one used by LLVM, so that the output of li is identical.
+func loadImmSeq(rd uint32, val int64, xlen int) []uint32 {
+	seq := loadImmShift(rd, val, xlen)
+	if val <= 0 || len(seq) <= 2 {
+		return seq
+	}
+	// Positive values may be loaded as a shifted value without leading zeros,
+	// followed by a logical right shift which restores the leading zeros. The
+	// bits shifted out are either filled with ones or zeros.
+	zeros := uint(bits.LeadingZeros64(uint64(val)))
+	shifted := uint64(val) << zeros
+	for _, fill := range []uint64{1<<zeros - 1, 0} {
+		tmp := loadImmShift(rd, int64(shifted|fill), xlen)
+		tmp = append(tmp, itype(opImm, 5, rd, rd, uint32(zeros)))
+		if len(tmp) < len(seq) {
+			seq = tmp
+		}
+	}
+	return seq
+}
+
+// loadImmShift returns a sequence of lui, addi(w) and slli instructions which
+// loads val into rd.
+func loadImmShift(rd uint32, val int64, xlen int) []uint32 {
+	if int64(int32(val)) == val {
+		// Sign-extended 32-bit value; lui and addi(w).
+		var seq []uint32
+		hi := uint32((val+0x800)>>12) & 0xFFFFF
+		lo := uint32(val) & 0xFFF
+		rs1 := uint32(riscvarch.Zero)
+		if hi != 0 {
+			seq = append(seq, utype(opLui, rd, hi))
+			rs1 = rd
+		}
+		if lo != 0 || hi == 0 {
+			opcode := uint32(opImm)
+			if hi != 0 && xlen == 64 {
+				opcode = opImm32
+			}
+			seq = append(seq, itype(opcode, 0, rd, rs1, lo))
+		}
+		return seq
+	}
+	// Load the upper bits, shift them into place and add the lower 12 bits.
+	lo := val << 52 >> 52
+	hi := int64(uint64(val)+0x800) >> 12
+	shift := uint(12)
+	for hi&1 == 0 {
+		hi >>= 1
+		shift++
+	}
+	// Use lui for the upper bits if it reduces the shift amount enough.
+	if shift > 12 && !fits(hi, 12) && int64(int32(hi<<12)) == hi<<12 {
+		shift -= 12
+		hi <<= 12
+	}
+	seq := loadImmShift(rd, hi, xlen)
+	seq = append(seq, itype(opImm, 1, rd, rd, uint32(shift)))
+	if lo != 0 {
+		seq = append(seq, itype(opImm, 0, rd, rd, uint32(lo)&0xFFF))
+	}
+	return seq
+}
+
+// auipcPair encodes an auipc instruction which adds the upper bits of the
+// offset of target to the pc, followed by an instruction which adds the lower
+// bits; as created by the provided function. The instructions are never
+// compressed, so that the size of the expansion does not depend on the
+// address. Targets whose address is assigned at link time are reported as a
+// relocation record of the given type of the pair; either RISCVCall, or
+// RISCVPCRelHi20 followed by RISCVPCRelLo12I of the lower instruction.
+func (e *encoding) auipcPair(rd uint32, target ast.Arg, typ symtab.RelocType, lower func(rd, lo uint32) uint32) error {
+	addr, err := ast.Eval(target, e.syms)
+	if err != nil {
+		return err
+	}
+	if asm.Relocated(e.syms, target, true) {
+		relocs := []symtab.Reloc{{Type: typ, Size: 8, PCRel: true}}
+		if typ == symtab.RISCVPCRelHi20 {
+			relocs = []symtab.Reloc{{Type: typ, Size: 4, PCRel: true}, {Offset: 4, Type: symtab.RISCVPCRelLo12I, Size: 4, PCRel: true}}
+		}
+		for _, r := range relocs {
+			r.Offset += int64(len(e.buf))
+			if _, err := asm.Reloc(e.syms, r, target); err != nil {
+				return err
+			}
+		}
+		e.emit32(utype(opAuipc, rd, 0))
+		e.emit32(lower(rd, 0))
+		return nil
+	}
+	off := addr - e.addr()
+	if off < -1<<31-0x800 || off >= 1<<31-0x800 {
+		return fmt.Errorf("riscv.encoding.auipcPair: target 0x%X out of range of pc-relative addressing", addr)
+	}
+	hi := uint32((off+0x800)>>12) & 0xFFFFF
+	lo := uint32(off) & 0xFFF
+	e.emit32(utype(opAuipc, rd, hi))
+	e.emit32(lower(rd, lo))
+	return nil
+}
+
+// mem returns the base register and the offset of the memory operand x, as a
+// 12-bit immediate.
+func (e *encoding) mem(x ast.Arg) (base, off uint32, err error) {
+	mem := x.(*ast.Mem)
+	reg, ok := mem.Base.(ast.Reg)
+	if !ok || mem.Index != nil || mem.Rel {
+		return 0, 0, fmt.Errorf("riscv.encoding.mem: invalid memory operand of %q; expected offset(register)", e.inst.Op)
+	}
+	if mem.Disp == nil {
+		return uint32(reg), 0, nil
+	}
+	off, err = e.simm(mem.Disp, 12)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(reg), off, nil
+}
+
+// fenceSet returns the set of memory and I/O operations specified by x; a
+// combination of i, o, r and w in that order.
+func fenceSet(x ast.Arg) (uint32, error) {
+	name, ok := x.(ast.Ident)
+	if !ok {
+		return 0, fmt.Errorf("riscv.fenceSet: invalid fence operand %v; expected combination of i, o, r and w", x)
+	}
+	var set uint32
+	rest := string(name)
+	for i, c := range "iorw" {
+		if strings.HasPrefix(rest, string(c)) {
+			set |= 8 >> uint(i)
+			rest = rest[1:]
+		}
+	}
+	if len(rest) > 0 || set == 0 {
+		return 0, fmt.Errorf("riscv.fenceSet: invalid fence operand %q; expected combination of i, o, r and w", name)
+	}
+	return set, nil
+}
+
+// simm evaluates x and returns its value as a sign-extended immediate of the
+// given bit width.
+func (e *encoding) simm(x ast.Arg, width uint) (uint32, error) {
+	val, err := ast.Eval(x, e.syms)
+	if err != nil {
+		return 0, err
+	}
+	min, max := int64(-1)<<(width-1), int64(1)<<(width-1)-1
+	if val < min || val > max {
+		return 0, fmt.Errorf("riscv.encoding.simm: immediate %d of %q out of range [%d, %d]", val, e.inst.Op, min, max)
+	}
+	return uint32(val) & (1<<width - 1), nil
+}
+
+// uimm evaluates x and returns its value as a zero-extended immediate of the
+// given bit width.
+func (e *encoding) uimm(x ast.Arg, width uint) (uint32, error) {
+	val, err := ast.Eval(x, e.syms)
+	if err != nil {
+		return 0, err
+	}
+	max := int64(1)<<width - 1
+	if val < 0 || val > max {
+		return 0, fmt.Errorf("riscv.encoding.uimm: immediate %d of %q out of range [0, %d]", val, e.inst.Op, max)
+	}
+	return uint32(val), nil
+}
+
+// addr returns the address of the next native instruction.
+func (e *encoding) addr() int64 {
+	return e.pc + int64(len(e.buf))
+}
+
+// emit emits the given 32-bit instruction word; or the equivalent compressed
+// instruction if present and the encoder compresses instructions.
+func (e *encoding) emit(w uint32) {
+	if e.compress {
+		if c, ok := compress(w, e.xlen); ok {
+			e.emit16(c)
+			return
+		}
+	}
+	e.emit32(w)
+}
+
+// emit32 emits the given 32-bit instruction word.
+func (e *encoding) emit32(w uint32) {
+	e.buf = append(e.buf, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(e.buf[len(e.buf)-4:], w)
+}
+
+// emit16 emits the given 16-bit compressed instruction.
+func (e *encoding) emit16(c uint16) {
+	e.buf = append(e.buf, 0, 0)
+	binary.LittleEndian.PutUint16(e.buf[len(e.buf)-2:], c)
+}
+
+// rtype returns an R format instruction word.
+func rtype(o rop, rd, rs1, rs2 uint32) uint32 {
+	return o.funct7<<25 | rs2<<20 | rs1<<15 | o.funct3<<12 | rd<<7 | o.opcode
+}
+
+// itype returns an I format instruction word, with a 12-bit immediate.
+func itype(opcode, funct3, rd, rs1, imm uint32) uint32 {
+	return imm<<20 | rs1<<15 | funct3<<12 | rd<<7 | opcode
+}
+
+// stype returns an S format store instruction word, with a 12-bit offset.
+func stype(funct3, rs1, rs2, off uint32) uint32 {
+	return (off>>5&0x7F)<<25 | rs2<<20 | rs1<<15 | funct3<<12 | (off&0x1F)<<7 | opStore
+}
+
+// btype returns a B format branch instruction word, with a 13-bit offset.
+func btype(funct3, rs1, rs2, off uint32) uint32 {
+	return bit(off, 12)<<31 | (off>>5&0x3F)<<25 | rs2<<20 | rs1<<15 | funct3<<12 | (off>>1&0xF)<<8 | bit(off, 11)<<7 | opBranch
+}
+
+// utype returns a U format instruction word, with a 20-bit immediate.
+func utype(opcode, rd, imm uint32) uint32 {
+	return imm<<12 | rd<<7 | opcode
+}
+
+// jtype returns a J format jal instruction word, with a 21-bit offset.
+func jtype(rd, off uint32) uint32 {
+	return bit(off, 20)<<31 | (off>>1&0x3FF)<<21 | bit(off, 11)<<20 | (off>>12&0xFF)<<12 | rd<<7 | opJal
+}
+
+// bit returns bit i of x.
+func bit(x uint32, i uint) uint32 {
+	return x >> i & 1
+}
+
+// reg returns the register number of the register operand x, which has been
+// validated by the architecture.
+func reg(x ast.Arg) uint32 {
+	return uint32(x.(ast.Reg))
+}
@@ -0,0 +1,534 @@
+// The expected encodings of the test cases in this file have been verified using
+// llvm-mc.
+
+package riscv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/internal/asmtest"
+)
+
+// checkEncode assembles the input of each test case using the given encoder and
+// compares it against the expected little-endian machine code.
+func checkEncode(t *testing.T, enc asm.Encoder, golden []struct{ in, want string }) {
+	for i, g := range golden {
+		got, err := asmtest.Text(enc, g.in)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		want, err := hex.DecodeString(g.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("i=%d: encoding mismatch of %q; expected %x, got %x", i, g.in, want, got)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	golden := []struct{ in, want string }{
+		// i=0
+		{in: "lui a0, 0x12345", want: "37553412"},
+		// i=1
+		{in: "auipc t0, 1", want: "97120000"},
+		// i=2
+		{in: "jal ra, 2048", want: "ef001000"},
+		// i=3
+		{in: "jal 16", want: "ef000001"},
+		// i=4
+		{in: "jalr a0", want: "e7000500"},
+		// i=5
+		{in: "jalr ra, 8(a0)", want: "e7008500"},
+		// i=6
+		{in: "jalr t0, a1, -4", want: "e782c5ff"},
+		// i=7
+		{in: "beq a0, a1, 8", want: "6304b500"},
+		// i=8
+		{in: "bne s0, zero, -16", want: "e31804fe"},
+		// i=9
+		{in: "blt a0, a1, 4094", want: "e34fb57e"},
+		// i=10
+		{in: "bge t1, t2, -4096", want: "63507380"},
+		// i=11
+		{in: "bltu a0, a1, 12", want: "6366b500"},
+		// i=12
+		{in: "lb a0, -1(sp)", want: "0305f1ff"},
+		// i=13
+		{in: "lw a0, 2047(a1)", want: "03a5f57f"},
+		// i=14
+		{in: "lhu a0, (a1)", want: "03d50500"},
+		// i=15
+		{in: "sb a0, -2048(sp)", want: "2300a180"},
+		// i=16
+		{in: "sw ra, 12(sp)", want: "23261100"},
+		// i=17
+		{in: "addi a0, a1, -1", want: "1385f5ff"},
+		// i=18
+		{in: "sltiu a0, a1, 5", want: "13b55500"},
+		// i=19
+		{in: "xori a0, a1, -1", want: "13c5f5ff"},
+		// i=20
+		{in: "slli a0, a1, 31", want: "1395f501"},
+		// i=21
+		{in: "srai a0, a1, 3", want: "13d53540"},
+		// i=22
+		{in: "add a0, a1, a2", want: "3385c500"},
+		// i=23
+		{in: "sub a0, a1, a2", want: "3385c540"},
+		// i=24
+		{in: "sra a0, a1, a2", want: "33d5c540"},
+		// i=25
+		{in: "and x10, x11, x12", want: "33f5c500"},
+		// i=26
+		{in: "fence", want: "0f00f00f"},
+		// i=27
+		{in: "fence rw, w", want: "0f001003"},
+		// i=28
+		{in: "fence.i", want: "0f100000"},
+		// i=29
+		{in: "ecall", want: "73000000"},
+		// i=30
+		{in: "ebreak", want: "73001000"},
+		// i=31
+		{in: "csrrs a0, 0xC00, zero", want: "732500c0"},
+		// i=32
+		{in: "csrrwi a0, 0x300, 5", want: "73d50230"},
+		// i=33
+		{in: "mul a0, a1, a2", want: "3385c502"},
+		// i=34
+		{in: "mulhsu a0, a1, a2", want: "33a5c502"},
+		// i=35
+		{in: "remu a0, a1, a2", want: "33f5c502"},
+		// i=36
+		{in: "lr.w.aq a0, (a1)", want: "2fa50514"},
+		// i=37
+		{in: "sc.w.rl a0, a2, (a1)", want: "2fa5c51a"},
+		// i=38
+		{in: "amoswap.w.aqrl a0, a2, (a1)", want: "2fa5c50e"},
+		// i=39
+		{in: "amomaxu.w a0, a2, (a1)", want: "2fa5c5e0"},
+		// i=40
+		{in: "nop", want: "13000000"},
+		// i=41
+		{in: "li a0, -2048", want: "13050080"},
+		// i=42
+		{in: "li a0, 2048", want: "3715000013050580"},
+		// i=43
+		{in: "li a0, 0x12345678", want: "3755341213058567"},
+		// i=44
+		{in: "li a0, 0x1000", want: "37150000"},
+		// i=45
+		{in: "li a0, 0xFFFFFFFF", want: "1305f0ff"},
+		// i=46
+		{in: "mv fp, sp", want: "13040100"},
+		// i=47
+		{in: "not a0, a1", want: "13c5f5ff"},
+		// i=48
+		{in: "neg a0, a1", want: "3305b040"},
+		// i=49
+		{in: "seqz a0, a1", want: "13b51500"},
+		// i=50
+		{in: "snez a0, a1", want: "3335b000"},
+		// i=51
+		{in: "sgtz a0, a1", want: "3325b000"},
+		// i=52
+		{in: "j 32", want: "6f000002"},
+		// i=53
+		{in: "jr a0", want: "67000500"},
+		// i=54
+		{in: "ret", want: "67800000"},
+		// i=55
+		{in: "bgtz a0, 8", want: "6344a000"},
+		// i=56
+		{in: "bleu a0, a1, 8", want: "63f4a500"},
+	}
+	checkEncode(t, Encoder, golden)
+}
+
+func TestEncode64(t *testing.T) {
+	golden := []struct{ in, want string }{
+		// i=0
+		{in: "ld a0, 8(sp)", want: "03358100"},
+		// i=1
+		{in: "lwu a0, 4(a1)", want: "03e54500"},
+		// i=2
+		{in: "sd ra, 24(sp)", want: "233c1100"},
+		// i=3
+		{in: "addiw a0, a1, -5", want: "1b85b5ff"},
+		// i=4
+		{in: "slliw a0, a1, 31", want: "1b95f501"},
+		// i=5
+		{in: "sraiw a0, a1, 3", want: "1bd53540"},
+		// i=6
+		{in: "slli a0, a1, 63", want: "1395f503"},
+		// i=7
+		{in: "srai a0, a1, 40", want: "13d58542"},
+		// i=8
+		{in: "addw a0, a1, a2", want: "3b85c500"},
+		// i=9
+		{in: "subw a0, a1, a2", want: "3b85c540"},
+		// i=10
+		{in: "sraw a0, a1, a2", want: "3bd5c540"},
+		// i=11
+		{in: "mulw a0, a1, a2", want: "3b85c502"},
+		// i=12
+		{in: "remuw a0, a1, a2", want: "3bf5c502"},
+		// i=13
+		{in: "negw a0, a1", want: "3b05b040"},
+		// i=14
+		{in: "sext.w a0, a1", want: "1b850500"},
+		// i=15
+		{in: "lr.d a0, (a1)", want: "2fb50510"},
+		// i=16
+		{in: "sc.d.aq a0, a2, (a1)", want: "2fb5c51c"},
+		// i=17
+		{in: "amoadd.d.aqrl a0, a2, (a1)", want: "2fb5c506"},
+		// i=18
+		{in: "li a0, 0x12345", want: "372501001b055534"},
+		// i=19
+		{in: "li a0, 0x80000000", want: "130510001315f501"},
+		// i=20
+		{in: "li a0, 0xFFFFFFFF", want: "1305f0ff13550502"},
+		// i=21
+		{in: "li a0, 0x100000000", want: "1305100013150502"},
+		// i=22
+		{in: "li a0, 0x123456789", want: "372509001b05b5a21315d50013059578"},
+		// i=23
+		{in: "li a0, 0x123456789ABCDEF0", want: "377524001b05d58a1315e5001305d5c41315c5001305755e1315d500130505ef"},
+		// i=24
+		{in: "li a0, 0x7FFFFFFFFFFFFFFF", want: "1305f0ff13551500"},
+		// i=25
+		{in: "li a0, 0x8000000000000000", want: "1305f0ff1315f503"},
+		// i=26
+		{in: "li a0, 0xFFFFFFFF00000000", want: "1305f0ff13150502"},
+		// i=27
+		{in: "li a0, -1", want: "1305f0ff"},
+	}
+	checkEncode(t, Encoder64, golden)
+}
+
+func TestEncodeCompressed(t *testing.T) {
+	golden := []struct{ in, want string }{
+		// i=0
+		{in: "addi sp, sp, 16", want: "4101"},
+		// i=1
+		{in: "addi sp, sp, -64", want: "3971"},
+		// i=2
+		{in: "addi a0, sp, 16", want: "0808"},
+		// i=3
+		{in: "addi a0, a1, 0", want: "2e85"},
+		// i=4
+		{in: "addi a0, zero, 5", want: "1545"},
+		// i=5
+		{in: "addi sp, sp, 496", want: "7d61"},
+		// i=6
+		{in: "addi a0, sp, 1024", want: "13050140"},
+		// i=7
+		{in: "add a0, zero, a1", want: "2e85"},
+		// i=8
+		{in: "add a0, a1, zero", want: "2e85"},
+		// i=9
+		{in: "add a0, a1, a0", want: "2e95"},
+		// i=10
+		{in: "add t0, t0, t1", want: "9a92"},
+		// i=11
+		{in: "sub a0, a0, a1", want: "0d8d"},
+		// i=12
+		{in: "sub a0, a1, a0", want: "3385a540"},
+		// i=13
+		{in: "and a0, a1, a0", want: "6d8d"},
+		// i=14
+		{in: "andi a0, a0, 31", want: "7d89"},
+		// i=15
+		{in: "andi a0, a0, 32", want: "13750502"},
+		// i=16
+		{in: "slli t0, t0, 31", want: "fe02"},
+		// i=17
+		{in: "srai a5, a5, 31", want: "fd87"},
+		// i=18
+		{in: "lui a0, 0xfffff", want: "7d75"},
+		// i=19
+		{in: "lui a0, 32", want: "37050200"},
+		// i=20
+		{in: "lui sp, 1", want: "37110000"},
+		// i=21
+		{in: "li a0, 5", want: "1545"},
+		// i=22
+		{in: "li a0, 0x12345", want: "496513055534"},
+		// i=23
+		{in: "li a0, 0x12345678", want: "3755341213058567"},
+		// i=24
+		{in: "mv a0, a1", want: "2e85"},
+		// i=25
+		{in: "ret", want: "8280"},
+		// i=26
+		{in: "nop", want: "0100"},
+		// i=27
+		{in: "ebreak", want: "0290"},
+		// i=28
+		{in: "jr a0", want: "0285"},
+		// i=29
+		{in: "jalr a0", want: "0295"},
+		// i=30
+		{in: "jalr t0, 0(a0)", want: "e7020500"},
+		// i=31
+		{in: "j 2046", want: "fdaf"},
+		// i=32
+		{in: "j 2048", want: "6f001000"},
+		// i=33
+		{in: "jal 16", want: "0128"},
+		// i=34
+		{in: "bnez s1, 254", want: "fdec"},
+		// i=35
+		{in: "beqz a0, 256", want: "63000510"},
+		// i=36
+		{in: "beqz t0, 8", want: "63840200"},
+		// i=37
+		{in: "lw a0, 124(a1)", want: "e85d"},
+		// i=38
+		{in: "lw a0, 128(a1)", want: "03a50508"},
+		// i=39
+		{in: "lw t0, 252(sp)", want: "fe52"},
+		// i=40
+		{in: "lw a0, 256(sp)", want: "03250110"},
+		// i=41
+		{in: "sw zero, 8(sp)", want: "02c4"},
+		// i=42
+		{in: "sw a0, 124(s0)", want: "68dc"},
+		// i=43
+		{in: "c.nop", want: "0100"},
+		// i=44
+		{in: "c.addi a0, -1", want: "7d15"},
+		// i=45
+		{in: "c.li a0, 31", want: "7d45"},
+		// i=46
+		{in: "c.lui a0, 0xfffff", want: "7d75"},
+		// i=47
+		{in: "c.addi16sp sp, -32", want: "3d71"},
+		// i=48
+		{in: "c.addi4spn a0, sp, 8", want: "2800"},
+		// i=49
+		{in: "c.srli a0, 1", want: "0581"},
+		// i=50
+		{in: "c.andi a0, -1", want: "7d99"},
+		// i=51
+		{in: "c.mv a0, a1", want: "2e85"},
+		// i=52
+		{in: "c.and a0, a1", want: "6d8d"},
+		// i=53
+		{in: "c.j 16", want: "01a8"},
+		// i=54
+		{in: "c.jal 16", want: "0128"},
+		// i=55
+		{in: "c.jr ra", want: "8280"},
+		// i=56
+		{in: "c.jalr a0", want: "0295"},
+		// i=57
+		{in: "c.beqz a0, -2", want: "7ddd"},
+		// i=58
+		{in: "c.lw a0, 4(a1)", want: "c841"},
+		// i=59
+		{in: "c.sw a0, (a1)", want: "88c1"},
+		// i=60
+		{in: "c.lwsp a0, 4(sp)", want: "1245"},
+		// i=61
+		{in: "c.swsp a0, 4(sp)", want: "2ac2"},
+	}
+	checkEncode(t, EncoderC, golden)
+}
+
+func TestEncodeCompressed64(t *testing.T) {
+	golden := []struct{ in, want string }{
+		// i=0
+		{in: "addiw a0, a0, 5", want: "1525"},
+		// i=1
+		{in: "addiw a0, a1, 5", want: "1b855500"},
+		// i=2
+		{in: "sext.w a0, a0", want: "0125"},
+		// i=3
+		{in: "addw a0, a1, a0", want: "2d9d"},
+		// i=4
+		{in: "subw a0, a0, a1", want: "0d9d"},
+		// i=5
+		{in: "ld a0, 248(a1)", want: "e87d"},
+		// i=6
+		{in: "ld a0, 4(a1)", want: "03b54500"},
+		// i=7
+		{in: "ld ra, 504(sp)", want: "fe70"},
+		// i=8
+		{in: "sd s0, 16(s1)", want: "80e8"},
+		// i=9
+		{in: "slli a0, a0, 63", want: "7e15"},
+		// i=10
+		{in: "srli a0, a0, 32", want: "0191"},
+		// i=11
+		{in: "jal 16", want: "ef000001"},
+		// i=12
+		{in: "li a0, 0x123456789ABCDEF0", want: "377524001b05d58a3a051305d5c432051305755e3605130505ef"},
+		// i=13
+		{in: "li a0, 0xFFFFFFFF", want: "7d550191"},
+		// i=14
+		{in: "c.ld a0, 8(a1)", want: "8865"},
+		// i=15
+		{in: "c.sdsp a0, 8(sp)", want: "2ae4"},
+		// i=16
+		{in: "c.addiw a0, -1", want: "7d35"},
+		// i=17
+		{in: "c.subw a0, a1", want: "0d9d"},
+		// i=18
+		{in: "c.slli a0, 63", want: "7e15"},
+	}
+	checkEncode(t, Encoder64C, golden)
+}
+
+func TestEncodeLabels(t *testing.T) {
+	const src = `
+main:
+	addi sp, sp, -16
+	sw ra, 12(sp)
+	la a0, msg
+	call puts
+	li a0, 0
+	lw ra, 12(sp)
+	addi sp, sp, 16
+	ret
+puts:
+	lbu a1, 0(a0)
+	beqz a1, done
+	addi a0, a0, 1
+	j puts
+done:
+	tail main
+msg:
+	db "hi", 0
+`
+	golden := []struct {
+		enc  asm.Encoder
+		want []string
+	}{
+		// i=0
+		{
+			enc: Encoder,
+			want: []string{
+				"130101ff", // addi sp, sp, -16
+				"23261100", // sw ra, 12(sp)
+				"17050000", // auipc a0, 0
+				"13058503", // addi a0, a0, 56
+				"97000000", // auipc ra, 0
+				"e7808001", // jalr ra, 24(ra)
+				"13050000", // addi a0, zero, 0
+				"8320c100", // lw ra, 12(sp)
+				"13010101", // addi sp, sp, 16
+				"67800000", // jalr zero, 0(ra)
+				"83450500", // lbu a1, 0(a0)
+				"63860500", // beq a1, zero, done
+				"13051500", // addi a0, a0, 1
+				"6ff05fff", // jal zero, puts
+				"17030000", // auipc t1, 0
+				"670083fc", // jalr zero, -56(t1)
+				"686900",   // "hi", 0
+			},
+		},
+		// i=1
+		{
+			enc: EncoderC,
+			want: []string{
+				"4111",     // c.addi sp, -16
+				"06c6",     // c.swsp ra, 12(sp)
+				"17050000", // auipc a0, 0
+				"1305a502", // addi a0, a0, 42
+				"97000000", // auipc ra, 0
+				"e7800001", // jalr ra, 16(ra)
+				"0145",     // c.li a0, 0
+				"b240",     // c.lwsp ra, 12(sp)
+				"4101",     // c.addi sp, 16
+				"8280",     // c.jr ra
+				"83450500", // lbu a1, 0(a0)
+				"99c1",     // c.beqz a1, done
+				"0505",     // c.addi a0, 1
+				"e5bf",     // c.j puts
+				"17030000", // auipc t1, 0
+				"6700a3fd", // jalr zero, -38(t1)
+				"686900",   // "hi", 0
+			},
+		},
+	}
+
+	for i, g := range golden {
+		got, err := asmtest.Text(g.enc, src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		want, err := hex.DecodeString(strings.Join(g.want, ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("i=%d: encoding mismatch; expected %x, got %x", i, want, got)
+		}
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	golden := []struct {
+		enc  asm.Encoder
+		in   string
+		want string
+	}{
+		// i=0
+		{enc: Encoder, in: "addi a0, a1, 2048", want: `1:1: riscv.encoding.simm: immediate 2048 of "addi" out of range [-2048, 2047]`},
+		// i=1
+		{enc: Encoder, in: "slli a0, a1, 32", want: `1:1: riscv.encoding.uimm: immediate 32 of "slli" out of range [0, 31]`},
+		// i=2
+		{enc: Encoder, in: "lui a0, 0x100000", want: `1:1: riscv.encoding.uimm: immediate 1048576 of "lui" out of range [0, 1048575]`},
+		// i=3
+		{enc: Encoder, in: "beq a0, a1, 3", want: "1:1: riscv.encoding.offset: unaligned branch target 0x3"},
+		// i=4
+		{enc: Encoder, in: "beq a0, a1, 4096", want: "1:1: riscv.encoding.offset: branch target 0x1000 out of range; offset 4096 not in range [-4096, 4094]"},
+		// i=5
+		{enc: Encoder, in: "li a0, 0x100000000", want: "1:1: ast.EvalWidth: value 4294967296 overflows 32-bit operand"},
+		// i=6
+		{enc: Encoder, in: "fence wr, w", want: `1:1: riscv.fenceSet: invalid fence operand "wr"; expected combination of i, o, r and w`},
+		// i=7
+		{enc: Encoder, in: "lr.w a0, 4(a1)", want: `1:1: riscv.encoding.amo: invalid offset 4 of "lr.w"; expected 0`},
+		// i=8
+		{enc: Encoder, in: "lw a0, [a1 + a2]", want: `1:1: riscv.encoding.mem: invalid memory operand of "lw"; expected offset(register)`},
+		// i=9
+		{enc: Encoder, in: "ld a0, 0(a1)", want: `1:1: parser.parseInst: arch.OpTable.Validate: unknown riscv32 instruction "ld"; got [identifier]: "ld"`},
+		// i=10
+		{enc: EncoderC, in: "c.addi a0, 0", want: `1:1: riscv.encoding.cimm: invalid immediate 0 of "c.addi"; expected non-zero value`},
+		// i=11
+		{enc: EncoderC, in: "c.li zero, 1", want: `1:1: riscv.encoding.nonzero: invalid register zero of "c.li"`},
+		// i=12
+		{enc: EncoderC, in: "c.lw ra, 4(a1)", want: `1:1: riscv.encoding.creg: invalid register ra of "c.lw"; expected one of s0, s1 or a0-a5`},
+		// i=13
+		{enc: EncoderC, in: "c.lwsp a0, 2(sp)", want: `1:1: riscv.encoding.cimm: immediate 2 of "c.lwsp" not a multiple of 4`},
+		// i=14
+		{enc: EncoderC, in: "c.lwsp a0, 4(a0)", want: `1:1: riscv.encoding.encodeCompressed: invalid memory operand of "c.lwsp"; expected offset(sp)`},
+		// i=15
+		{enc: EncoderC, in: "c.lui a0, 32", want: `1:1: riscv.encoding.encodeCompressed: immediate 32 of "c.lui" out of range [1, 31] or [0xFFFE0, 0xFFFFF]`},
+		// i=16
+		{enc: EncoderC, in: "c.j 2048", want: "1:1: riscv.encoding.offset: branch target 0x800 out of range; offset 2048 not in range [-2048, 2046]"},
+		// i=17
+		{enc: Encoder64C, in: "c.jal 16", want: `1:1: parser.parseInst: arch.OpTable.Validate: unknown riscv64 instruction "c.jal"; got [identifier]: "c.jal"`},
+	}
+
+	for i, g := range golden {
+		_, err := asmtest.Text(g.enc, g.in)
+		if err == nil {
+			t.Errorf("i=%d: expected error %q, got nil", i, g.want)
+			continue
+		}
+		if got := err.Error(); got != g.want {
+			t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package asm
+
+import (
+	"strings"
+
+	"github.com/mewlang/asm/ast"
+)
+
+// scope qualifies the names of local labels, and of references to local
+// labels, by the name of the nearest preceding non-local label; e.g. the local
+// label .loop in the scope of the label func is named func.loop. The program is
+// copied, leaving the lines of the original program unmodified.
+func (a *assembler) scope() error {
+	s := &scoper{a: a, locals: make(map[string]bool)}
+	prog := &ast.Program{Lines: make([]*ast.Line, len(a.prog.Lines))}
+	for i, line := range a.prog.Lines {
+		l := *line
+		if line.Label != nil {
+			if !isLocal(line.Label.Name) {
+				s.scope = line.Label.Name
+			}
+			name, err := s.declare(line, line.Label.Name)
+			if err != nil {
+				return err
+			}
+			l.Label = &ast.LabelDecl{Name: name}
+		}
+		var err error
+		switch node := line.Node.(type) {
+		case *ast.Inst:
+			inst := *node
+			inst.Args, err = s.qualifyAll(line, node.Args)
+			l.Node = &inst
+		case *ast.DataDir:
+			dir := *node
+			dir.Vals, err = s.qualifyAll(line, node.Vals)
+			l.Node = &dir
+		case *ast.AlignDir:
+			dir := *node
+			dir.Align, err = s.qualify(line, node.Align)
+			l.Node = &dir
+		case *ast.EquDir:
+			dir := *node
+			if dir.Name, err = s.declare(line, node.Name); err != nil {
+				return err
+			}
+			dir.Val, err = s.qualify(line, node.Val)
+			l.Node = &dir
+		}
+		if err != nil {
+			return err
+		}
+		prog.Lines[i] = &l
+	}
+	a.prog = prog
+	return nil
+}
+
+// A scoper qualifies the names of local labels.
+type scoper struct {
+	a *assembler
+	// Name of the nearest preceding non-local label; or the empty string if
+	// not present.
+	scope string
+	// Qualified names of the declared local labels.
+	locals map[string]bool
+}
+
+// local returns the qualified name of the named local label, which is declared
+// or referred to by line.
+func (s *scoper) local(line *ast.Line, name string) (string, error) {
+	if s.scope == "" {
+		return "", s.a.errorf(line, "asm.Assemble: local label %q used before any non-local label", name)
+	}
+	return s.scope + name, nil
+}
+
+// declare returns the qualified name of the named label, which is declared by
+// line.
+func (s *scoper) declare(line *ast.Line, name string) (string, error) {
+	if !isLocal(name) {
+		return name, nil
+	}
+	qname, err := s.local(line, name)
+	if err != nil {
+		return "", err
+	}
+	if s.locals[qname] {
+		return "", s.a.errorf(line, "asm.Assemble: redeclaration of local label %q in scope of %q", name, s.scope)
+	}
+	s.locals[qname] = true
+	return qname, nil
+}
+
+// qualify returns a copy of the operand x of line, in which references to local
+// labels are qualified.
+func (s *scoper) qualify(line *ast.Line, x ast.Arg) (ast.Arg, error) {
+	var err error
+	switch x := x.(type) {
+	case ast.Ident:
+		if !isLocal(string(x)) {
+			return x, nil
+		}
+		name, err := s.local(line, string(x))
+		return ast.Ident(name), err
+	case *ast.ParenExpr:
+		y := *x
+		y.X, err = s.qualify(line, x.X)
+		return &y, err
+	case *ast.UnaryExpr:
+		y := *x
+		y.X, err = s.qualify(line, x.X)
+		return &y, err
+	case *ast.BinaryExpr:
+		y := *x
+		if y.X, err = s.qualify(line, x.X); err != nil {
+			return nil, err
+		}
+		y.Y, err = s.qualify(line, x.Y)
+		return &y, err
+	case *ast.Imm:
+		y := *x
+		y.X, err = s.qualify(line, x.X)
+		return &y, err
+	case *ast.Literal:
+		y := *x
+		y.X, err = s.qualify(line, x.X)
+		return &y, err
+	case *ast.Mem:
+		y := *x
+		y.Disp, err = s.qualify(line, x.Disp)
+		return &y, err
+	case *ast.ShiftedReg:
+		y := *x
+		if y.X, err = s.qualify(line, x.X); err != nil {
+			return nil, err
+		}
+		y.Amount, err = s.qualify(line, x.Amount)
+		return &y, err
+	case *ast.Writeback:
+		y := *x
+		y.X, err = s.qualify(line, x.X)
+		return &y, err
+	}
+	return x, nil
+}
+
+// qualifyAll returns a copy of the operands xs of line, in which references to
+// local labels are qualified.
+func (s *scoper) qualifyAll(line *ast.Line, xs []ast.Arg) ([]ast.Arg, error) {
+	ys := make([]ast.Arg, len(xs))
+	for i, x := range xs {
+		y, err := s.qualify(line, x)
+		if err != nil {
+			return nil, err
+		}
+		ys[i] = y
+	}
+	return ys, nil
+}
+
+// isLocal returns true if the named label is a local label, and false
+// otherwise.
+func isLocal(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
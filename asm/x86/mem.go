@@ -0,0 +1,261 @@
+package x86
+
+import (
+	"fmt"
+
+	x86arch "github.com/mewlang/asm/arch/x86"
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/symtab"
+)
+
+// emitRM emits an instruction with a ModRM byte; its prefixes, opcode, ModRM
+// byte, SIB byte and displacement. The reg field holds either a register or an
+// opcode extension, and the r/m field either a register or a memory operand.
+// The operand size in bits determines the operand-size prefix and the REX.W
+// bit. The number of bytes of the immediate following the instruction is
+// required to encode RIP-relative addresses.
+func (e *encoding) emitRM(size int, opcode []byte, reg, rm ast.Arg, immLen int) error {
+	var p prefixes
+	p.size(e, size)
+	var modrm byte
+	switch reg := reg.(type) {
+	case ast.Reg:
+		modrm = p.reg(reg, rexR) << 3
+	case ext:
+		modrm = byte(reg) << 3
+	}
+	var m *memOperand
+	switch rm := rm.(type) {
+	case ast.Reg:
+		modrm |= 0xC0 | p.reg(rm, rexB)
+	case *ast.Mem:
+		var err error
+		if m, err = e.mem(rm, &p); err != nil {
+			return err
+		}
+		modrm |= m.mod<<6 | m.rm
+	}
+	if err := p.emit(e); err != nil {
+		return err
+	}
+	e.emit(opcode...)
+	e.emit(modrm)
+	if m == nil {
+		return nil
+	}
+	e.emit(m.sib...)
+	if m.rel {
+		if ok, err := e.reloc(symtab.Reloc{Size: 4, Addend: -int64(4 + immLen), PCRel: true}, m.x); err != nil || ok {
+			return err
+		}
+		// RIP-relative displacement; relative to the end of the instruction.
+		rel := m.disp - (e.pc + int64(len(e.buf)+4+immLen))
+		if !fitsInt(rel, 32) {
+			return fmt.Errorf("x86.encoding.emitRM: RIP-relative address 0x%X out of range", m.disp)
+		}
+		e.emitInt(rel, 32)
+		return nil
+	}
+	if m.reloc {
+		if ok, err := e.reloc(symtab.Reloc{Size: 4}, m.x); err != nil || ok {
+			return err
+		}
+	}
+	e.emitInt(m.disp, 8*m.dispLen)
+	return nil
+}
+
+// isMoffs returns true if the memory operand may be encoded as an absolute
+// memory offset of the accumulator forms of mov, and false otherwise. Memory
+// offsets are 64-bit in 64-bit mode, and are therefore only used in 16-bit and
+// 32-bit mode.
+func (e *encoding) isMoffs(mem *ast.Mem) bool {
+	return e.bits != 64 && mem.Base == nil && mem.Index == nil && !mem.Rel
+}
+
+// moffs emits an accumulator form of mov with an absolute memory offset.
+func (e *encoding) moffs(opcode byte, reg ast.Reg, mem *ast.Mem) error {
+	disp, err := e.disp(mem, 32)
+	if err != nil {
+		return err
+	}
+	if e.bits == 16 {
+		// Address-size override prefix.
+		e.emit(0x67)
+	}
+	if err := e.emitOpcode(e.size(reg), opcode); err != nil {
+		return err
+	}
+	if ok, err := e.reloc(symtab.Reloc{Size: 4}, mem.Disp); err != nil || ok {
+		return err
+	}
+	e.emitInt(disp, 32)
+	return nil
+}
+
+// A memOperand is the encoding of a memory operand.
+type memOperand struct {
+	// The mod and r/m fields of the ModRM byte.
+	mod, rm byte
+	// Optional SIB byte.
+	sib []byte
+	// Displacement; or target address of RIP-relative memory operands.
+	disp int64
+	// Length in bytes of the displacement.
+	dispLen int
+	// RIP-relative memory operand.
+	rel bool
+	// Displacement expression.
+	x ast.Arg
+	// The displacement refers to an address assigned at link time; and is
+	// 32-bit.
+	reloc bool
+}
+
+// mem returns the encoding of the given memory operand, and records the
+// prefixes it requires.
+func (e *encoding) mem(mem *ast.Mem, p *prefixes) (*memOperand, error) {
+	var base, index ast.Reg
+	hasBase, hasIndex := mem.Base != nil, mem.Index != nil
+	if hasBase {
+		base = mem.Base.(ast.Reg)
+	}
+	if hasIndex {
+		index = mem.Index.(ast.Reg)
+	}
+	scale := mem.Scale
+	// Swap base and index if the stack pointer is used as an unscaled index.
+	if hasIndex && scale == 1 && x86arch.Num(index) == 4 && (!hasBase || x86arch.Num(base) != 4) {
+		if hasBase {
+			base, index = index, base
+		} else {
+			base, hasBase, hasIndex = index, true, false
+		}
+	}
+
+	// Determine the address size.
+	addrSize := 0
+	if hasBase {
+		addrSize = e.size(base)
+	}
+	if hasIndex {
+		if hasBase && e.size(index) != addrSize {
+			return nil, fmt.Errorf("x86.encoding.mem: mismatched address sizes of base register %q and index register %q", x86arch.Arch.RegName(base), x86arch.Arch.RegName(index))
+		}
+		addrSize = e.size(index)
+	}
+	switch addrSize {
+	case 0:
+		if e.bits == 16 {
+			p.addr = true
+		}
+	case 32:
+		p.addr = e.bits != 32
+	case 64:
+		// Only available in 64-bit mode.
+	default:
+		return nil, fmt.Errorf("x86.encoding.mem: unsupported %d-bit addressing", addrSize)
+	}
+	if hasIndex && x86arch.Num(index) == 4 {
+		return nil, fmt.Errorf("x86.encoding.mem: invalid index register %q", x86arch.Arch.RegName(index))
+	}
+	dispWidth := 32
+	if addrSize == 64 || (addrSize == 0 && e.bits == 64) {
+		// Sign-extended to 64 bits.
+		dispWidth = 64
+	}
+	disp, err := e.disp(mem, dispWidth)
+	if err != nil {
+		return nil, err
+	}
+	m := &memOperand{disp: disp, x: mem.Disp}
+	m.reloc = !mem.Rel && mem.Disp != nil && asm.Relocated(e.syms, mem.Disp, false)
+
+	switch {
+	case mem.Rel:
+		if e.bits != 64 {
+			return nil, fmt.Errorf("x86.encoding.mem: RIP-relative addressing requires 64-bit mode")
+		}
+		if hasBase || hasIndex {
+			return nil, fmt.Errorf("x86.encoding.mem: RIP-relative memory operand with base or index register")
+		}
+		m.mod, m.rm, m.rel = 0, 5, true
+		return m, nil
+	case !hasBase && !hasIndex:
+		m.dispLen = 4
+		if e.bits == 64 {
+			// Absolute address using a SIB byte without base and index, since
+			// mod=00 r/m=101 is RIP-relative in 64-bit mode.
+			m.mod, m.rm, m.sib = 0, 4, []byte{0x25}
+			return m, nil
+		}
+		m.mod, m.rm = 0, 5
+		return m, nil
+	case !hasBase:
+		// Scaled index without base; always a 32-bit displacement.
+		m.mod, m.rm, m.dispLen = 0, 4, 4
+		m.sib = []byte{ssBits(scale)<<6 | p.reg(index, rexX)<<3 | 5}
+		return m, nil
+	}
+
+	// Base register with optional index and displacement; the r/m and base
+	// encodings 101 with mod=00 denote the absence of a base register, so
+	// ebp, rbp and r13 require a displacement.
+	switch {
+	case m.reloc:
+		m.mod, m.dispLen = 2, 4
+	case disp == 0 && x86arch.Num(base)&7 != 5:
+		m.mod = 0
+	case fitsInt(disp, 8):
+		m.mod, m.dispLen = 1, 1
+	default:
+		m.mod, m.dispLen = 2, 4
+	}
+	b := p.reg(base, rexB)
+	switch {
+	case hasIndex:
+		m.rm = 4
+		m.sib = []byte{ssBits(scale)<<6 | p.reg(index, rexX)<<3 | b}
+	case b == 4:
+		// The stack pointer and r12 require a SIB byte without index.
+		m.rm = 4
+		m.sib = []byte{0x24}
+	default:
+		m.rm = b
+	}
+	return m, nil
+}
+
+// disp evaluates the displacement of the given memory operand, which must fit
+// in a 32-bit displacement; sign-extended to the given address width.
+func (e *encoding) disp(mem *ast.Mem, width int) (int64, error) {
+	if mem.Disp == nil {
+		return 0, nil
+	}
+	val, err := ast.EvalWidth(mem.Disp, e.syms, width)
+	if err != nil {
+		return 0, err
+	}
+	if mem.Rel {
+		return val, nil
+	}
+	if width == 64 && !fitsInt(val, 32) {
+		return 0, fmt.Errorf("x86.encoding.disp: displacement 0x%X out of range of sign-extended 32-bit displacement", val)
+	}
+	return val, nil
+}
+
+// ssBits returns the encoding of the given scale factor in the ss field of the
+// SIB byte.
+func ssBits(scale int) byte {
+	switch scale {
+	case 2:
+		return 1
+	case 4:
+		return 2
+	case 8:
+		return 3
+	}
+	return 0
+}
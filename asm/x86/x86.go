@@ -0,0 +1,849 @@
+// Package x86 implements an x86 and x86-64 instruction encoder for the Intel
+// syntax of NASM.
+//
+// Instructions are encoded using the shortest encoding selected by NASM; e.g.
+// sign-extended 8-bit immediates, the short forms of accumulator operations
+// and short jumps whenever the target is within reach.
+//
+// Memory operands are encoded using the ModRM and SIB bytes of 32-bit
+// addressing, or 64-bit addressing in 64-bit mode; with the shortest
+// displacement, and a SIB byte only if required by the index register or by a
+// base register of esp. Memory operands whose size is not implied by a
+// register operand require a size keyword; such as inc dword [eax] or
+// mov byte [esi], 0.
+//
+// Fields which refer to addresses assigned at link time are reported as
+// relocation records, and use the full-size encoding; i.e. 32-bit immediates
+// and displacements, near branches and movabs for 64-bit immediates.
+package x86
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mewlang/asm/arch"
+	x86arch "github.com/mewlang/asm/arch/x86"
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/symtab"
+)
+
+// Instruction encoders of the processor modes.
+var (
+	// Encoder is the x86 instruction encoder for 32-bit mode.
+	Encoder asm.Encoder = encoder{bits: 32}
+	// Encoder16 is the x86 instruction encoder for 16-bit mode; which is the
+	// default mode of flat binaries produced by NASM. Memory operands are
+	// encoded using 32-bit addressing.
+	Encoder16 asm.Encoder = encoder{bits: 16}
+	// Encoder64 is the x86-64 instruction encoder for 64-bit mode.
+	Encoder64 asm.Encoder = encoder{bits: 64}
+)
+
+// encoder implements the asm.Encoder interface for x86.
+type encoder struct {
+	// Processor mode; either 16, 32 or 64.
+	bits int
+}
+
+// Arch returns the x86 architecture of the processor mode.
+func (enc encoder) Arch() arch.Arch {
+	if enc.bits == 64 {
+		return x86arch.Arch64
+	}
+	return x86arch.Arch
+}
+
+// Encode returns the machine code of inst, which is located at address pc.
+// Branch targets and displacements are resolved using the provided symbol
+// table.
+func (enc encoder) Encode(inst *ast.Inst, pc int64, syms ast.SymbolTable) ([]byte, error) {
+	if err := enc.Arch().Validate(inst); err != nil {
+		return nil, err
+	}
+	e := &encoding{inst: inst, pc: pc, syms: syms, bits: enc.bits}
+	if err := e.encode(); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// Opcode extensions of the arithmetic instructions, as stored in the reg field
+// of the ModRM byte. The opcodes of the register forms are given by 8 times the
+// extension.
+var arithOps = map[ast.Op]ext{
+	"add": 0,
+	"or":  1,
+	"adc": 2,
+	"sbb": 3,
+	"and": 4,
+	"sub": 5,
+	"xor": 6,
+	"cmp": 7,
+}
+
+// Opcode extensions of the shift and rotate instructions.
+var shiftOps = map[ast.Op]ext{
+	"rol": 0,
+	"ror": 1,
+	"rcl": 2,
+	"rcr": 3,
+	"shl": 4,
+	"sal": 4,
+	"shr": 5,
+	"sar": 7,
+}
+
+// Opcode extensions of the unary arithmetic instructions of opcode F6 (8-bit)
+// and F7.
+var unaryOps = map[ast.Op]ext{
+	"not":  2,
+	"neg":  3,
+	"mul":  4,
+	"imul": 5,
+	"div":  6,
+	"idiv": 7,
+}
+
+// Opcodes of instructions without operands.
+var nullaryOps = map[ast.Op][]byte{
+	"nop":     {0x90},
+	"hlt":     {0xF4},
+	"leave":   {0xC9},
+	"clc":     {0xF8},
+	"stc":     {0xF9},
+	"cmc":     {0xF5},
+	"cld":     {0xFC},
+	"std":     {0xFD},
+	"cli":     {0xFA},
+	"sti":     {0xFB},
+	"int3":    {0xCC},
+	"syscall": {0x0F, 0x05},
+	"cqo":     {0x48, 0x99},
+	"cdqe":    {0x48, 0x98},
+}
+
+// Opcodes of the loop instructions, which only have a short form.
+var loopOps = map[ast.Op]byte{
+	"loopne": 0xE0,
+	"loope":  0xE1,
+	"loop":   0xE2,
+	"jecxz":  0xE3,
+	"jrcxz":  0xE3,
+}
+
+// An ext is an opcode extension, stored in the reg field of the ModRM byte.
+type ext uint8
+
+// An encoding keeps track of the encoding of a single instruction.
+type encoding struct {
+	// Instruction to encode.
+	inst *ast.Inst
+	// Address of the instruction.
+	pc int64
+	// Symbol table used to resolve identifiers.
+	syms ast.SymbolTable
+	// Processor mode; either 16, 32 or 64.
+	bits int
+	// Machine code of the instruction.
+	buf []byte
+	// Immediate operand which refers to an address assigned at link time; or
+	// nil.
+	relocImm ast.Arg
+}
+
+// encode encodes the instruction.
+func (e *encoding) encode() error {
+	op, args := e.inst.Op, e.inst.Args
+	if ext, ok := arithOps[op]; ok {
+		return e.arith(ext, args[0], args[1])
+	}
+	if ext, ok := shiftOps[op]; ok {
+		return e.shift(ext, args[0], args[1])
+	}
+	if opcode, ok := nullaryOps[op]; ok {
+		e.emit(opcode...)
+		return nil
+	}
+	if opcode, ok := loopOps[op]; ok {
+		// jecxz requires an address-size override prefix in 16-bit and 64-bit
+		// mode.
+		if op == "jecxz" && e.bits != 32 {
+			e.emit(0x67)
+		}
+		return e.shortBranch(opcode, args[0])
+	}
+	if ext, ok := unaryOps[op]; ok && len(args) == 1 {
+		size := argSize(args[0])
+		return e.emitRM(size, []byte{0xF6 | wide(size)}, ext, args[0], 0)
+	}
+
+	switch {
+	case strings.HasPrefix(string(op), "j") && op != "jmp":
+		cc := x86arch.Conds[string(op[1:])]
+		return e.branch([]byte{0x70 | cc}, []byte{0x0F, 0x80 | cc}, args[0])
+	case strings.HasPrefix(string(op), "set"):
+		cc := x86arch.Conds[string(op[3:])]
+		return e.emitRM(8, []byte{0x0F, 0x90 | cc}, ext(0), args[0], 0)
+	case strings.HasPrefix(string(op), "cmov"):
+		cc := x86arch.Conds[string(op[4:])]
+		size, err := e.operandSize(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		return e.emitRM(size, []byte{0x0F, 0x40 | cc}, args[0], args[1], 0)
+	}
+
+	switch op {
+	case "mov":
+		return e.mov(args[0], args[1])
+	case "test":
+		return e.test(args[0], args[1])
+	case "lea":
+		dst := args[0].(ast.Reg)
+		return e.emitRM(e.size(dst), []byte{0x8D}, dst, args[1], 0)
+	case "movzx", "movsx":
+		dst := args[0].(ast.Reg)
+		opcode := byte(0xB6)
+		if op == "movsx" {
+			opcode = 0xBE
+		}
+		if argSize(args[1]) == 16 {
+			opcode |= 1
+		}
+		if src, ok := args[1].(ast.Reg); ok && x86arch.NeedsREX(src) && x86arch.IsHighByte(dst) {
+			return fmt.Errorf("x86.encoding.encode: unable to encode %q together with a REX prefix", x86arch.Arch.RegName(dst))
+		}
+		return e.emitRM(e.size(dst), []byte{0x0F, opcode}, dst, args[1], 0)
+	case "movsxd":
+		if err := e.memSize(args[1], 32); err != nil {
+			return err
+		}
+		dst := args[0].(ast.Reg)
+		return e.emitRM(64, []byte{0x63}, dst, args[1], 0)
+	case "push", "pop":
+		switch arg := args[0].(type) {
+		case ast.Reg:
+			opcode := byte(0x50)
+			if op == "pop" {
+				opcode = 0x58
+			}
+			return e.emitOpReg(e.stackSize(e.size(arg)), opcode, arg)
+		case *ast.Mem:
+			// push: FF /6; pop: 8F /0
+			opcode, x := byte(0xFF), ext(6)
+			if op == "pop" {
+				opcode, x = 0x8F, 0
+			}
+			return e.emitRM(e.stackSize(arg.Size), []byte{opcode}, x, arg, 0)
+		}
+		size := e.immSize(e.opSize())
+		val, err := e.evalImm(args[0], size)
+		if err != nil {
+			return err
+		}
+		if e.fitsImm8(val, size) {
+			e.emit(0x6A, byte(val))
+			return nil
+		}
+		e.emit(0x68)
+		return e.emitImm(val, size)
+	case "inc", "dec":
+		var x ext
+		if op == "dec" {
+			x = 1
+		}
+		size := argSize(args[0])
+		if reg, ok := args[0].(ast.Reg); ok && size != 8 && e.bits != 64 {
+			// The one-byte forms are REX prefixes in 64-bit mode.
+			return e.emitOpReg(size, 0x40|byte(x)<<3, reg)
+		}
+		return e.emitRM(size, []byte{0xFE | wide(size)}, x, args[0], 0)
+	case "imul":
+		dst := args[0].(ast.Reg)
+		size, err := e.operandSize(dst, args[1])
+		if err != nil {
+			return err
+		}
+		if len(args) == 2 {
+			return e.emitRM(size, []byte{0x0F, 0xAF}, dst, args[1], 0)
+		}
+		isize := e.immSize(size)
+		val, err := e.evalImm(args[2], size)
+		if err != nil {
+			return err
+		}
+		if e.fitsImm8(val, size) {
+			if err := e.emitRM(size, []byte{0x6B}, dst, args[1], 1); err != nil {
+				return err
+			}
+			e.emit(byte(val))
+			return nil
+		}
+		if err := e.emitRM(size, []byte{0x69}, dst, args[1], isize/8); err != nil {
+			return err
+		}
+		return e.emitImm(val, isize)
+	case "jmp", "call":
+		x := ext(4)
+		if op == "call" {
+			x = 2
+		}
+		switch arg := args[0].(type) {
+		case ast.Reg:
+			return e.emitRM(e.stackSize(e.size(arg)), []byte{0xFF}, x, arg, 0)
+		case *ast.Mem:
+			if err := e.memSize(arg, e.branchSize()); err != nil {
+				return err
+			}
+			return e.emitRM(e.opSize(), []byte{0xFF}, x, arg, 0)
+		}
+		if op == "call" {
+			return e.nearBranch([]byte{0xE8}, args[0])
+		}
+		return e.branch([]byte{0xEB}, []byte{0xE9}, args[0])
+	case "ret":
+		if len(args) == 0 {
+			e.emit(0xC3)
+			return nil
+		}
+		e.emit(0xC2)
+		return e.uimm(args[0], 16)
+	case "int":
+		e.emit(0xCD)
+		return e.uimm(args[0], 8)
+	case "cdq", "cwde":
+		if e.bits == 16 {
+			e.emit(0x66)
+		}
+		opcode := byte(0x99)
+		if op == "cwde" {
+			opcode = 0x98
+		}
+		e.emit(opcode)
+		return nil
+	}
+	return fmt.Errorf("x86.Encoder.Encode: support for instruction %q not yet implemented", op)
+}
+
+// mov encodes the mov instruction.
+func (e *encoding) mov(dst, src ast.Arg) error {
+	size, err := e.operandSize(dst, src)
+	if err != nil {
+		return err
+	}
+	switch src := src.(type) {
+	case ast.Reg:
+		if mem, ok := dst.(*ast.Mem); ok && x86arch.Num(src) == 0 && e.isMoffs(mem) {
+			return e.moffs(0xA2|wide(size), src, mem)
+		}
+		return e.emitRM(size, []byte{0x88 | wide(size)}, src, dst, 0)
+	case *ast.Mem:
+		reg := dst.(ast.Reg)
+		if x86arch.Num(reg) == 0 && e.isMoffs(src) {
+			return e.moffs(0xA0|wide(size), reg, src)
+		}
+		return e.emitRM(size, []byte{0x8A | wide(size)}, reg, src, 0)
+	}
+	switch dst := dst.(type) {
+	case *ast.Mem:
+		// C6 /0 ib; C7 /0 iw or id, sign-extended for 64-bit operations.
+		isize := e.immSize(size)
+		val, err := e.evalImm(src, size)
+		if err != nil {
+			return err
+		}
+		if err := e.emitRM(size, []byte{0xC6 | wide(size)}, ext(0), dst, isize/8); err != nil {
+			return err
+		}
+		return e.emitImm(val, isize)
+	case ast.Reg:
+		if size == 64 && asm.Relocated(e.syms, src, false) {
+			// movabs with a relocated 64-bit immediate.
+			if err := e.emitOpReg(64, 0xB8, dst); err != nil {
+				return err
+			}
+			_, err := e.reloc(symtab.Reloc{Size: 8}, src)
+			return err
+		}
+		if size == 64 {
+			val, err := ast.EvalWidth(src, e.syms, 64)
+			if err != nil {
+				return err
+			}
+			switch {
+			case val >= 0 && val <= 0xFFFFFFFF:
+				// Like NASM, use the zero-extending 32-bit form; e.g. mov eax,
+				// imm32 for mov rax, imm32.
+				if err := e.emitOpReg(32, 0xB8, dst); err != nil {
+					return err
+				}
+				e.emitInt(val, 32)
+				return nil
+			case !fitsInt(val, 32):
+				// movabs
+				if err := e.emitOpReg(64, 0xB8, dst); err != nil {
+					return err
+				}
+				e.emitInt(val, 64)
+				return nil
+			}
+			// Sign-extended 32-bit immediate.
+			if err := e.emitRM(64, []byte{0xC7}, ext(0), dst, 4); err != nil {
+				return err
+			}
+			e.emitInt(val, 32)
+			return nil
+		}
+		opcode := byte(0xB0)
+		if size != 8 {
+			opcode = 0xB8
+		}
+		if err := e.emitOpReg(size, opcode, dst); err != nil {
+			return err
+		}
+		return e.imm(src, size)
+	}
+	return fmt.Errorf("x86.encoding.mov: invalid destination operand type %T", dst)
+}
+
+// arith encodes the arithmetic instruction with the given opcode extension.
+func (e *encoding) arith(x ext, dst, src ast.Arg) error {
+	size, err := e.operandSize(dst, src)
+	if err != nil {
+		return err
+	}
+	opcode := byte(x) << 3
+	switch src := src.(type) {
+	case ast.Reg:
+		return e.emitRM(size, []byte{opcode | wide(size)}, src, dst, 0)
+	case *ast.Mem:
+		return e.emitRM(size, []byte{opcode | 0x02 | wide(size)}, dst, src, 0)
+	}
+	isize := e.immSize(size)
+	val, err := e.evalImm(src, size)
+	if err != nil {
+		return err
+	}
+	reg, isReg := dst.(ast.Reg)
+	switch {
+	case isReg && reg == x86arch.AL:
+		// AL, imm8
+		e.emit(opcode | 0x04)
+	case size == 8:
+		if err := e.emitRM(size, []byte{0x80}, x, dst, 1); err != nil {
+			return err
+		}
+	case e.fitsImm8(val, size):
+		// Sign-extended 8-bit immediate.
+		if err := e.emitRM(size, []byte{0x83}, x, dst, 1); err != nil {
+			return err
+		}
+		e.emit(byte(val))
+		return nil
+	case isReg && x86arch.Num(reg) == 0:
+		// AX, imm16; EAX, imm32 or RAX, imm32
+		if err := e.emitOpcode(size, opcode|0x05); err != nil {
+			return err
+		}
+	default:
+		if err := e.emitRM(size, []byte{0x81}, x, dst, isize/8); err != nil {
+			return err
+		}
+	}
+	return e.emitImm(val, isize)
+}
+
+// test encodes the test instruction.
+func (e *encoding) test(dst, src ast.Arg) error {
+	size, err := e.operandSize(dst, src)
+	if err != nil {
+		return err
+	}
+	if src, ok := src.(ast.Reg); ok {
+		return e.emitRM(size, []byte{0x84 | wide(size)}, src, dst, 0)
+	}
+	isize := e.immSize(size)
+	val, err := e.evalImm(src, size)
+	if err != nil {
+		return err
+	}
+	if reg, ok := dst.(ast.Reg); ok && x86arch.Num(reg) == 0 {
+		// AL, imm8; AX, imm16; EAX, imm32 or RAX, imm32
+		if err := e.emitOpcode(size, 0xA8|wide(size)); err != nil {
+			return err
+		}
+	} else if err := e.emitRM(size, []byte{0xF6 | wide(size)}, ext(0), dst, isize/8); err != nil {
+		return err
+	}
+	return e.emitImm(val, isize)
+}
+
+// shift encodes the shift or rotate instruction with the given opcode
+// extension. The shift count is either an immediate or the cl register.
+func (e *encoding) shift(x ext, dst, count ast.Arg) error {
+	size := argSize(dst)
+	if reg, ok := count.(ast.Reg); ok {
+		if reg != x86arch.CL {
+			return fmt.Errorf("x86.encoding.shift: invalid shift count register %q; expected cl", x86arch.Arch.RegName(reg))
+		}
+		return e.emitRM(size, []byte{0xD2 | wide(size)}, x, dst, 0)
+	}
+	val, err := ast.Eval(count, e.syms)
+	if err != nil {
+		return err
+	}
+	if val == 1 {
+		return e.emitRM(size, []byte{0xD0 | wide(size)}, x, dst, 0)
+	}
+	if err := e.emitRM(size, []byte{0xC0 | wide(size)}, x, dst, 1); err != nil {
+		return err
+	}
+	return e.uimm(count, 8)
+}
+
+// emitOpReg emits an instruction whose register operand is encoded in the low
+// three bits of its opcode.
+func (e *encoding) emitOpReg(size int, opcode byte, reg ast.Reg) error {
+	var p prefixes
+	p.size(e, size)
+	num := p.reg(reg, rexB)
+	if err := p.emit(e); err != nil {
+		return err
+	}
+	e.emit(opcode | num)
+	return nil
+}
+
+// emitOpcode emits an instruction without register operands, with the
+// prefixes of the given operand size.
+func (e *encoding) emitOpcode(size int, opcode byte) error {
+	var p prefixes
+	p.size(e, size)
+	if err := p.emit(e); err != nil {
+		return err
+	}
+	e.emit(opcode)
+	return nil
+}
+
+// REX prefix bits.
+const (
+	rexB = 0x01 // extension of the r/m, base or opcode register field
+	rexX = 0x02 // extension of the SIB index field
+	rexR = 0x04 // extension of the ModRM reg field
+	rexW = 0x08 // 64-bit operand size
+)
+
+// prefixes records the prefixes of an instruction.
+type prefixes struct {
+	// Operand-size override prefix.
+	opsize bool
+	// Address-size override prefix.
+	addr bool
+	// REX prefix bits.
+	rex byte
+	// A REX prefix is required; e.g. to access sil.
+	needREX bool
+	// High byte register which prevents a REX prefix; e.g. ah.
+	highByte ast.Arg
+}
+
+// size records the prefixes of the given operand size in bits. Operand sizes
+// equal to the default operand size of the processor mode require no
+// prefixes.
+func (p *prefixes) size(e *encoding, size int) {
+	switch {
+	case size == 16 && e.bits != 16, size == 32 && e.bits == 16:
+		p.opsize = true
+	case size == 64:
+		p.rex |= rexW
+	}
+}
+
+// reg records the prefixes required to encode the given register, and returns
+// the low three bits of its register number. The fourth bit is stored in the
+// given REX bit.
+func (p *prefixes) reg(reg ast.Reg, bit byte) byte {
+	num := x86arch.Num(reg)
+	if num >= 8 {
+		p.rex |= bit
+	}
+	if x86arch.NeedsREX(reg) {
+		p.needREX = true
+	}
+	if x86arch.IsHighByte(reg) {
+		p.highByte = reg
+	}
+	return num & 7
+}
+
+// emit emits the recorded prefixes.
+func (p *prefixes) emit(e *encoding) error {
+	rex := p.rex != 0 || p.needREX
+	if rex && p.highByte != nil {
+		return fmt.Errorf("x86.prefixes.emit: unable to encode %q together with a REX prefix", x86arch.Arch.RegName(p.highByte.(ast.Reg)))
+	}
+	if rex && e.bits != 64 {
+		return fmt.Errorf("x86.prefixes.emit: REX prefix requires 64-bit mode")
+	}
+	if p.opsize {
+		e.emit(0x66)
+	}
+	if p.addr {
+		e.emit(0x67)
+	}
+	if rex {
+		e.emit(0x40 | p.rex)
+	}
+	return nil
+}
+
+// branch encodes a relative branch to the given target, using the short
+// opcode if the target is within reach of an 8-bit displacement, and the near
+// opcode otherwise. Relocated targets use the near opcode.
+func (e *encoding) branch(short, near []byte, target ast.Arg) error {
+	addr, err := ast.Eval(target, e.syms)
+	if err != nil {
+		return err
+	}
+	rel := addr - (e.pc + int64(len(e.buf)+len(short)+1))
+	if -128 <= rel && rel <= 127 && !asm.Relocated(e.syms, target, true) {
+		e.emit(short...)
+		e.emit(byte(rel))
+		return nil
+	}
+	return e.nearBranch(near, target)
+}
+
+// nearBranch encodes a relative branch to the given target, using a 16-bit
+// displacement in 16-bit mode and a 32-bit displacement otherwise.
+func (e *encoding) nearBranch(opcode []byte, target ast.Arg) error {
+	addr, err := ast.Eval(target, e.syms)
+	if err != nil {
+		return err
+	}
+	e.emit(opcode...)
+	size := e.immSize(e.opSize())
+	if ok, err := e.reloc(symtab.Reloc{Size: size / 8, Addend: -int64(size / 8), PCRel: true}, target); err != nil || ok {
+		return err
+	}
+	rel := addr - (e.pc + int64(len(e.buf)+size/8))
+	if !fitsInt(rel, size) {
+		return fmt.Errorf("x86.encoding.nearBranch: branch target 0x%X out of range", addr)
+	}
+	e.emitInt(rel, size)
+	return nil
+}
+
+// shortBranch encodes a relative branch to the given target, using an 8-bit
+// displacement.
+func (e *encoding) shortBranch(opcode byte, target ast.Arg) error {
+	addr, err := ast.Eval(target, e.syms)
+	if err != nil {
+		return err
+	}
+	e.emit(opcode)
+	if ok, err := e.reloc(symtab.Reloc{Size: 1, Addend: -1, PCRel: true}, target); err != nil || ok {
+		return err
+	}
+	rel := addr - (e.pc + int64(len(e.buf)+1))
+	if rel < -128 || rel > 127 {
+		return fmt.Errorf("x86.encoding.shortBranch: branch target 0x%X out of range; displacement %d not in range [-128, 127]", addr, rel)
+	}
+	e.emit(byte(rel))
+	return nil
+}
+
+// evalImm evaluates the immediate x of an operation of the given size in bits.
+// The immediates of 64-bit operations are sign-extended 32-bit values.
+// Immediates which refer to addresses assigned at link time are recorded, and
+// emitted as relocated fields by emitImm.
+func (e *encoding) evalImm(x ast.Arg, size int) (int64, error) {
+	if asm.Relocated(e.syms, x, false) {
+		e.relocImm = x
+		return 0, nil
+	}
+	val, err := ast.EvalWidth(x, e.syms, size)
+	if err != nil {
+		return 0, err
+	}
+	if size == 64 && !fitsInt(val, 32) {
+		return 0, fmt.Errorf("x86.encoding.evalImm: immediate 0x%X out of range of sign-extended 32-bit immediate", val)
+	}
+	return val, nil
+}
+
+// imm encodes the immediate x using the given size in bits.
+func (e *encoding) imm(x ast.Arg, size int) error {
+	val, err := e.evalImm(x, size)
+	if err != nil {
+		return err
+	}
+	return e.emitImm(val, e.immSize(size))
+}
+
+// fitsImm8 returns true if the immediate val of an operation of the given size
+// in bits may be encoded as a sign-extended 8-bit immediate, and false
+// otherwise; relocated immediates are full-size.
+func (e *encoding) fitsImm8(val int64, size int) bool {
+	return e.relocImm == nil && fitsInt8(val, size)
+}
+
+// emitImm emits the immediate val evaluated by evalImm, using the given size in
+// bits.
+func (e *encoding) emitImm(val int64, size int) error {
+	if e.relocImm != nil {
+		if ok, err := e.reloc(symtab.Reloc{Size: size / 8}, e.relocImm); err != nil || ok {
+			return err
+		}
+	}
+	e.emitInt(val, size)
+	return nil
+}
+
+// uimm encodes the unsigned immediate x using the given size in bits.
+func (e *encoding) uimm(x ast.Arg, size int) error {
+	val, err := ast.Eval(x, e.syms)
+	if err != nil {
+		return err
+	}
+	if ok, err := e.reloc(symtab.Reloc{Size: size / 8}, x); err != nil || ok {
+		return err
+	}
+	if max := int64(1)<<uint(size) - 1; val < 0 || val > max {
+		return fmt.Errorf("x86.encoding.uimm: immediate %d of %q out of range [0, %d]", val, e.inst.Op, max)
+	}
+	e.emitInt(val, size)
+	return nil
+}
+
+// size returns the size in bits of the given register.
+func (e *encoding) size(reg ast.Reg) int {
+	return x86arch.Arch64.RegClass(reg).Size
+}
+
+// opSize returns the default operand size in bits of the processor mode.
+func (e *encoding) opSize() int {
+	if e.bits == 16 {
+		return 16
+	}
+	return 32
+}
+
+// stackSize returns the operand size in bits of stack and indirect branch
+// instructions of the given size, which default to 64-bit operands in 64-bit
+// mode.
+func (e *encoding) stackSize(size int) int {
+	if size != 64 {
+		return size
+	}
+	return e.opSize()
+}
+
+// branchSize returns the size in bits of the target address of indirect branch
+// instructions.
+func (e *encoding) branchSize() int {
+	if e.bits == 64 {
+		return 64
+	}
+	return e.opSize()
+}
+
+// operandSize returns the operand size in bits of an instruction, as given by
+// its register operands and sized memory operands.
+func (e *encoding) operandSize(args ...ast.Arg) (int, error) {
+	size := 0
+	for _, arg := range args {
+		s := argSize(arg)
+		if s == 0 {
+			continue
+		}
+		if size != 0 && s != size {
+			return 0, fmt.Errorf("x86.encoding.operandSize: mismatched operand sizes of %q; %d-bit and %d-bit", e.inst.Op, size, s)
+		}
+		size = s
+	}
+	return size, nil
+}
+
+// memSize returns an error if arg is a memory operand whose size keyword
+// specifies a size other than the given size in bits.
+func (e *encoding) memSize(arg ast.Arg, size int) error {
+	if mem, ok := arg.(*ast.Mem); ok && mem.Size != 0 && mem.Size != size {
+		return fmt.Errorf("x86.encoding.memSize: invalid %d-bit memory operand of %q; expected %d-bit", mem.Size, e.inst.Op, size)
+	}
+	return nil
+}
+
+// immSize returns the size in bits of the immediate of an operation of the
+// given size; at most 32 bits.
+func (e *encoding) immSize(size int) int {
+	if size > 32 {
+		return 32
+	}
+	return size
+}
+
+// emit appends the given bytes to the machine code of the instruction.
+func (e *encoding) emit(bs ...byte) {
+	e.buf = append(e.buf, bs...)
+}
+
+// reloc reports the relocation record r of a field at the end of the machine
+// code, which refers to the address x. It returns true if the field is
+// relocated, in which case the field is emitted as zero.
+func (e *encoding) reloc(r symtab.Reloc, x ast.Arg) (bool, error) {
+	r.Offset = int64(len(e.buf))
+	ok, err := asm.Reloc(e.syms, r, x)
+	if err != nil || !ok {
+		return false, err
+	}
+	e.emitInt(0, 8*r.Size)
+	return true, nil
+}
+
+// emitInt appends the little-endian encoding of val using the given size in
+// bits.
+func (e *encoding) emitInt(val int64, size int) {
+	for i := 0; i < size/8; i++ {
+		e.buf = append(e.buf, byte(val>>uint(8*i)))
+	}
+}
+
+// argSize returns the size in bits of the given register or memory operand; or
+// 0 if the size of the operand is not specified.
+func argSize(arg ast.Arg) int {
+	switch arg := arg.(type) {
+	case ast.Reg:
+		return x86arch.Arch64.RegClass(arg).Size
+	case *ast.Mem:
+		return arg.Size
+	}
+	return 0
+}
+
+// wide returns the w bit of opcodes which operate on either 8-bit operands
+// (w=0) or operands of the full operand size (w=1), given the operand size in
+// bits.
+func wide(size int) byte {
+	if size == 8 {
+		return 0
+	}
+	return 1
+}
+
+// fitsInt8 returns true if the value, truncated to the given size in bits, may
+// be encoded as a sign-extended 8-bit immediate, and false otherwise.
+func fitsInt8(val int64, size int) bool {
+	shift := uint(64 - size)
+	v := val << shift >> shift
+	return -128 <= v && v <= 127
+}
+
+// fitsInt returns true if the value fits in a signed integer of the given size
+// in bits, and false otherwise.
+func fitsInt(val int64, size int) bool {
+	shift := uint(64 - size)
+	return val<<shift>>shift == val
+}
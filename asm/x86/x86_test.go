@@ -0,0 +1,682 @@
+package x86
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/internal/asmtest"
+)
+
+// flatten returns the contents of the sections of obj, placed at their
+// addresses relative to the first section and padded with zero bytes.
+func flatten(obj *asm.Object) []byte {
+	var buf []byte
+	for _, sect := range obj.Sections {
+		off := int(sect.Addr - obj.Sections[0].Addr)
+		buf = append(buf, make([]byte, off-len(buf))...)
+		buf = append(buf, sect.Data...)
+	}
+	return buf
+}
+
+func TestEncode(t *testing.T) {
+	// The expected encodings have been verified using GNU as.
+	golden := []struct {
+		in   string
+		want string
+	}{
+		// i=0
+		{in: "mov eax, 4", want: "b804000000"},
+		// i=1
+		{in: "mov al, 0x12", want: "b012"},
+		// i=2
+		{in: "mov ax, 0x1234", want: "66b83412"},
+		// i=3
+		{in: "mov eax, ebx", want: "89d8"},
+		// i=4
+		{in: "mov cl, dh", want: "88f1"},
+		// i=5
+		{in: "mov si, di", want: "6689fe"},
+		// i=6
+		{in: "movzx eax, bl", want: "0fb6c3"},
+		// i=7
+		{in: "movzx eax, bx", want: "0fb7c3"},
+		// i=8
+		{in: "movsx ecx, al", want: "0fbec8"},
+		// i=9
+		{in: "movsx ax, dl", want: "660fbec2"},
+		// i=10
+		{in: "push eax", want: "50"},
+		// i=11
+		{in: "push 1", want: "6a01"},
+		// i=12
+		{in: "push 1000", want: "68e8030000"},
+		// i=13
+		{in: "pop ebp", want: "5d"},
+		// i=14
+		{in: "push bx", want: "6653"},
+		// i=15
+		{in: "add eax, 1", want: "83c001"},
+		// i=16
+		{in: "add eax, 1000", want: "05e8030000"},
+		// i=17
+		{in: "add ebx, 1000", want: "81c3e8030000"},
+		// i=18
+		{in: "add al, 5", want: "0405"},
+		// i=19
+		{in: "add bl, 5", want: "80c305"},
+		// i=20
+		{in: "add eax, ebx", want: "01d8"},
+		// i=21
+		{in: "sub ecx, edx", want: "29d1"},
+		// i=22
+		{in: "cmp eax, -1", want: "83f8ff"},
+		// i=23
+		{in: "and eax, 0xffffff00", want: "2500ffffff"},
+		// i=24
+		{in: "xor eax, eax", want: "31c0"},
+		// i=25
+		{in: "or dl, cl", want: "08ca"},
+		// i=26
+		{in: "adc ax, 0x1234", want: "66153412"},
+		// i=27
+		{in: "sbb bx, 1", want: "6683db01"},
+		// i=28
+		{in: "test eax, eax", want: "85c0"},
+		// i=29
+		{in: "test eax, 1", want: "a901000000"},
+		// i=30
+		{in: "test al, 1", want: "a801"},
+		// i=31
+		{in: "test ebx, 0x100", want: "f7c300010000"},
+		// i=32
+		{in: "test cl, 3", want: "f6c103"},
+		// i=33
+		{in: "shl eax, 1", want: "d1e0"},
+		// i=34
+		{in: "shl eax, 4", want: "c1e004"},
+		// i=35
+		{in: "shr ebx, cl", want: "d3eb"},
+		// i=36
+		{in: "sar dl, 2", want: "c0fa02"},
+		// i=37
+		{in: "rol ax, 1", want: "66d1c0"},
+		// i=38
+		{in: "inc eax", want: "40"},
+		// i=39
+		{in: "dec edi", want: "4f"},
+		// i=40
+		{in: "inc bl", want: "fec3"},
+		// i=41
+		{in: "inc cx", want: "6641"},
+		// i=42
+		{in: "neg eax", want: "f7d8"},
+		// i=43
+		{in: "not ebx", want: "f7d3"},
+		// i=44
+		{in: "mul ecx", want: "f7e1"},
+		// i=45
+		{in: "imul ecx", want: "f7e9"},
+		// i=46
+		{in: "div bl", want: "f6f3"},
+		// i=47
+		{in: "idiv esi", want: "f7fe"},
+		// i=48
+		{in: "imul eax, ebx", want: "0fafc3"},
+		// i=49
+		{in: "imul eax, ebx, 10", want: "6bc30a"},
+		// i=50
+		{in: "imul eax, ebx, 1000", want: "69c3e8030000"},
+		// i=51
+		{in: "ret", want: "c3"},
+		// i=52
+		{in: "ret 8", want: "c20800"},
+		// i=53
+		{in: "int 0x80", want: "cd80"},
+		// i=54
+		{in: "int3", want: "cc"},
+		// i=55
+		{in: "nop", want: "90"},
+		// i=56
+		{in: "hlt", want: "f4"},
+		// i=57
+		{in: "leave", want: "c9"},
+		// i=58
+		{in: "cdq", want: "99"},
+		// i=59
+		{in: "cwde", want: "98"},
+		// i=60
+		{in: "clc", want: "f8"},
+		// i=61
+		{in: "stc", want: "f9"},
+		// i=62
+		{in: "cld", want: "fc"},
+		// i=63
+		{in: "std", want: "fd"},
+		// i=64
+		{in: "cli", want: "fa"},
+		// i=65
+		{in: "sti", want: "fb"},
+		// i=66
+		{in: "cmc", want: "f5"},
+		// i=67
+		{in: "sete al", want: "0f94c0"},
+		// i=68
+		{in: "setne cl", want: "0f95c1"},
+		// i=69
+		{in: "cmovl eax, ebx", want: "0f4cc3"},
+		// i=70
+		{in: "cmovge cx, dx", want: "660f4dca"},
+		// i=71
+		{in: "jmp eax", want: "ffe0"},
+		// i=72
+		{in: "call ebx", want: "ffd3"}}
+
+	for i, g := range golden {
+		obj, err := asmtest.Assemble(nil, Encoder, g.in)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		want, err := hex.DecodeString(g.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := flatten(obj); !bytes.Equal(got, want) {
+			t.Errorf("i=%d: encoding mismatch of %q; expected %x, got %x", i, g.in, want, got)
+		}
+	}
+}
+
+func TestEncodeMem(t *testing.T) {
+	// The expected encodings have been verified using GNU as.
+	golden := []struct {
+		in   string
+		want string
+	}{
+		// i=0
+		{in: "mov eax, [0x1000]", want: "a100100000"},
+		// i=1
+		{in: "mov [0x1000], al", want: "a200100000"},
+		// i=2
+		{in: "mov ebx, [0x1000]", want: "8b1d00100000"},
+		// i=3
+		{in: "mov eax, [ebx+ecx*4-8]", want: "8b448bf8"},
+		// i=4
+		{in: "mov eax, [esp]", want: "8b0424"},
+		// i=5
+		{in: "mov eax, [ebp]", want: "8b4500"},
+		// i=6
+		{in: "mov eax, [ecx*8]", want: "8b04cd00000000"},
+		// i=7
+		{in: "lea esi, [esi+0]", want: "8d36"},
+		// i=8
+		{in: "add eax, [ebx]", want: "0303"},
+		// i=9
+		{in: "sub [ecx+0x80], edx", want: "299180000000"},
+		// i=10
+		{in: "mov cx, [eax]", want: "668b08"},
+		// i=11
+		{in: "jmp [ebx]", want: "ff23"},
+		// i=12
+		{in: "call [0x2000]", want: "ff1500200000"},
+		// i=13
+		{in: "imul eax, [ebx], 100", want: "6b0364"},
+		// i=14
+		{in: "mov dword [eax], 1", want: "c70001000000"},
+		// i=15
+		{in: "mov byte [ebx+4], 0x80", want: "c6430480"},
+		// i=16
+		{in: "mov word [ecx], 0x1234", want: "66c7013412"},
+		// i=17
+		{in: "add dword [eax], 1", want: "830001"},
+		// i=18
+		{in: "add dword [eax], 0x1000", want: "810000100000"},
+		// i=19
+		{in: "add byte [esi], 2", want: "800602"},
+		// i=20
+		{in: "cmp word [edi+8], 3", want: "66837f0803"},
+		// i=21
+		{in: "test byte [eax], 1", want: "f60001"},
+		// i=22
+		{in: "test dword [eax], 0x100", want: "f70000010000"},
+		// i=23
+		{in: "shl dword [eax], 1", want: "d120"},
+		// i=24
+		{in: "shr byte [ebx], 4", want: "c02b04"},
+		// i=25
+		{in: "sar word [ecx], cl", want: "66d339"},
+		// i=26
+		{in: "inc dword [eax]", want: "ff00"},
+		// i=27
+		{in: "dec byte [eax]", want: "fe08"},
+		// i=28
+		{in: "neg dword [ebx]", want: "f71b"},
+		// i=29
+		{in: "not word [ebx]", want: "66f713"},
+		// i=30
+		{in: "mul dword [ecx]", want: "f721"},
+		// i=31
+		{in: "push dword [eax]", want: "ff30"},
+		// i=32
+		{in: "pop dword [eax]", want: "8f00"},
+		// i=33
+		{in: "push word [eax]", want: "66ff30"},
+		// i=34
+		{in: "sete byte [eax]", want: "0f9400"},
+		// i=35
+		{in: "movzx eax, byte [ebx]", want: "0fb603"},
+		// i=36
+		{in: "movsx eax, word [ebx]", want: "0fbf03"},
+		// i=37
+		{in: "movzx cx, byte [ebx]", want: "660fb60b"},
+		// i=38
+		{in: "mov dword [eax], ebx", want: "8918"},
+		// i=39
+		{in: "jmp dword [ebx]", want: "ff23"},
+		// i=40
+		{in: "mov eax, [esp+ebx]", want: "8b041c"},
+		// i=41
+		{in: "mov eax, [ebx+esp]", want: "8b041c"},
+		// i=42
+		{in: "mov eax, [ebp+eax*2]", want: "8b444500"},
+		// i=43
+		{in: "mov eax, [eax+ebp]", want: "8b0428"},
+		// i=44
+		{in: "mov eax, [esi+127]", want: "8b467f"},
+		// i=45
+		{in: "mov eax, [esi-128]", want: "8b4680"},
+		// i=46
+		{in: "mov eax, [esi+128]", want: "8b8680000000"},
+		// i=47
+		{in: "mov eax, [esp+ecx*2+0x12345678]", want: "8b844c78563412"},
+		// i=48
+		{in: "lea edi, [ebx+ebx*2]", want: "8d3c5b"},
+		// i=49
+		{in: "mov eax, [esp-4]", want: "8b4424fc"},
+		// i=50
+		{in: "mov eax, [ebp+edi*8]", want: "8b44fd00"},
+		// i=51
+		{in: "mov eax, dword [ebx]", want: "8b03"},
+		// i=52
+		{in: "shl dword [eax], cl", want: "d320"},
+	}
+
+	for i, g := range golden {
+		obj, err := asmtest.Assemble(nil, Encoder, g.in)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		want, err := hex.DecodeString(g.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := flatten(obj); !bytes.Equal(got, want) {
+			t.Errorf("i=%d: encoding mismatch of %q; expected %x, got %x", i, g.in, want, got)
+		}
+	}
+}
+
+func TestEncode64(t *testing.T) {
+	// The expected encodings have been verified using GNU as; except for i=14,
+	// which uses the shorter encoding selected by NASM.
+	golden := []struct {
+		in   string
+		want string
+	}{
+		// i=0
+		{in: "mov rax, rbx", want: "4889d8"},
+		// i=1
+		{in: "mov r8, r9", want: "4d89c8"},
+		// i=2
+		{in: "mov eax, r10d", want: "4489d0"},
+		// i=3
+		{in: "mov r11w, ax", want: "664189c3"},
+		// i=4
+		{in: "mov sil, dil", want: "4088fe"},
+		// i=5
+		{in: "mov r12b, al", want: "4188c4"},
+		// i=6
+		{in: "add rax, 8", want: "4883c008"},
+		// i=7
+		{in: "add r15, 0x1000", want: "4981c700100000"},
+		// i=8
+		{in: "sub rsp, 16", want: "4883ec10"},
+		// i=9
+		{in: "and rax, -16", want: "4883e0f0"},
+		// i=10
+		{in: "xor r9d, r9d", want: "4531c9"},
+		// i=11
+		{in: "cmp rax, 0x7fffffff", want: "483dffffff7f"},
+		// i=12
+		{in: "test r8, r8", want: "4d85c0"},
+		// i=13
+		{in: "test rax, 0x100", want: "48a900010000"},
+		// i=14
+		{in: "mov rax, 60", want: "b83c000000"},
+		// i=15
+		{in: "mov rax, -1", want: "48c7c0ffffffff"},
+		// i=16
+		{in: "mov r10, 0x123456789", want: "49ba8967452301000000"},
+		// i=17
+		{in: "mov rax, [rbx]", want: "488b03"},
+		// i=18
+		{in: "mov rax, [rsp]", want: "488b0424"},
+		// i=19
+		{in: "mov rax, [rbp]", want: "488b4500"},
+		// i=20
+		{in: "mov rax, [r12]", want: "498b0424"},
+		// i=21
+		{in: "mov rax, [r13]", want: "498b4500"},
+		// i=22
+		{in: "mov rax, [rsp+8]", want: "488b442408"},
+		// i=23
+		{in: "mov rax, [rbp-8]", want: "488b45f8"},
+		// i=24
+		{in: "mov rax, [r12+r13*8+16]", want: "4b8b44ec10"},
+		// i=25
+		{in: "mov [rdi+rcx*4+0x1000], eax", want: "89848f00100000"},
+		// i=26
+		{in: "mov eax, [0x1000]", want: "8b042500100000"},
+		// i=27
+		{in: "mov eax, [ebx]", want: "678b03"},
+		// i=28
+		{in: "mov rcx, [rax*2]", want: "488b0c4500000000"},
+		// i=29
+		{in: "lea r8, [r9+r10*2-4]", want: "4f8d4451fc"},
+		// i=30
+		{in: "push rax", want: "50"},
+		// i=31
+		{in: "push r12", want: "4154"},
+		// i=32
+		{in: "pop r15", want: "415f"},
+		// i=33
+		{in: "push 0x1000", want: "6800100000"},
+		// i=34
+		{in: "inc rax", want: "48ffc0"},
+		// i=35
+		{in: "dec r8d", want: "41ffc8"},
+		// i=36
+		{in: "inc eax", want: "ffc0"},
+		// i=37
+		{in: "neg rdx", want: "48f7da"},
+		// i=38
+		{in: "imul r9, [rsp+8], 10", want: "4c6b4c24080a"},
+		// i=39
+		{in: "shl rax, 3", want: "48c1e003"},
+		// i=40
+		{in: "sar r10, cl", want: "49d3fa"},
+		// i=41
+		{in: "movzx r8, al", want: "4c0fb6c0"},
+		// i=42
+		{in: "movsx rax, cx", want: "480fbfc1"},
+		// i=43
+		{in: "movsxd rax, ecx", want: "4863c1"},
+		// i=44
+		{in: "cmovne rax, r9", want: "490f45c1"},
+		// i=45
+		{in: "sete r8b", want: "410f94c0"},
+		// i=46
+		{in: "jmp r11", want: "41ffe3"},
+		// i=47
+		{in: "call [rax+8]", want: "ff5008"},
+		// i=48
+		{in: "syscall", want: "0f05"},
+		// i=49
+		{in: "cqo", want: "4899"},
+		// i=50
+		{in: "cdqe", want: "4898"},
+		// i=51
+		{in: "cmp [r8+4], ecx", want: "41394804"},
+		// i=52
+		{in: "test [rax], rdx", want: "488510"},
+		// i=53
+		{in: "mov spl, 1", want: "40b401"},
+		// i=54
+		{in: "mov rax, [rel 0x1000]", want: "488b05f90f0000"},
+		// i=55
+		{in: "mov qword [rax], -1", want: "48c700ffffffff"},
+		// i=56
+		{in: "add qword [rel 0x1000], 1", want: "488305f80f000001"},
+		// i=57
+		{in: "inc qword [r12]", want: "49ff0424"},
+		// i=58
+		{in: "push qword [rax]", want: "ff30"},
+		// i=59
+		{in: "pop qword [r8]", want: "418f00"},
+		// i=60
+		{in: "movsxd rax, dword [rbx]", want: "486303"},
+		// i=61
+		{in: "call qword [rax]", want: "ff10"},
+		// i=62
+		{in: "mov byte [rax], sil", want: "408830"},
+	}
+
+	for i, g := range golden {
+		obj, err := asmtest.Assemble(nil, Encoder64, g.in)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		want, err := hex.DecodeString(g.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := flatten(obj); !bytes.Equal(got, want) {
+			t.Errorf("i=%d: encoding mismatch of %q; expected %x, got %x", i, g.in, want, got)
+		}
+	}
+}
+
+func TestEncodeBranch(t *testing.T) {
+	golden := []struct {
+		in   string
+		want string
+	}{
+		// i=0
+		{in: "jmp 0x1000", want: "e9fb0f0000"},
+		// i=1
+		{in: "jmp 0x10", want: "eb0e"},
+		// i=2
+		{in: "je 0", want: "74fe"},
+		// i=3
+		{in: "jne 0x2000", want: "0f85fa1f0000"},
+		// i=4
+		{in: "call 0", want: "e8fbffffff"},
+		// i=5
+		{in: "loop 0", want: "e2fe"},
+		// i=6
+		{in: "jecxz 8", want: "e306"},
+		// i=7
+		{
+			in: `
+start:
+	jmp end
+	jz end
+	db ` + strings.Repeat("0, ", 129) + `0
+end:
+	jmp start
+	ja end
+`,
+			want: "e988000000" + "0f8482000000" + strings.Repeat("00", 130) + "e96effffff" + "77f9",
+		},
+	}
+
+	for i, g := range golden {
+		obj, err := asmtest.Assemble(nil, Encoder, g.in)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		want, err := hex.DecodeString(g.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := flatten(obj); !bytes.Equal(got, want) {
+			t.Errorf("i=%d: encoding mismatch; expected %x, got %x", i, want, got)
+		}
+	}
+}
+
+func TestEncodeHello(t *testing.T) {
+	// The expected output matches nasm -f bin; which defaults to 16-bit mode,
+	// and to 32-bit mode when the source is prefixed with "bits 32".
+	golden := []struct {
+		enc  asm.Encoder
+		want []byte
+	}{
+		// i=0
+		{
+			enc: Encoder,
+			want: []byte{
+				0xB8, 0x04, 0x00, 0x00, 0x00, // mov eax, 4
+				0xBB, 0x01, 0x00, 0x00, 0x00, // mov ebx, 1
+				0xB9, 0x24, 0x00, 0x00, 0x00, // mov ecx, hello
+				0xBA, 0x0D, 0x00, 0x00, 0x00, // mov edx, 13
+				0xCD, 0x80, // int 0x80
+				0xB8, 0x01, 0x00, 0x00, 0x00, // mov eax, 1
+				0xBB, 0x00, 0x00, 0x00, 0x00, // mov ebx, 0
+				0xCD, 0x80, // int 0x80
+				0x00, 0x00, // padding
+				'H', 'e', 'l', 'l', 'o', ' ', 'w', 'o', 'r', 'l', 'd', '!', '\n',
+			},
+		},
+		// i=1
+		{
+			enc: Encoder16,
+			want: []byte{
+				0x66, 0xB8, 0x04, 0x00, 0x00, 0x00, // mov eax, 4
+				0x66, 0xBB, 0x01, 0x00, 0x00, 0x00, // mov ebx, 1
+				0x66, 0xB9, 0x28, 0x00, 0x00, 0x00, // mov ecx, hello
+				0x66, 0xBA, 0x0D, 0x00, 0x00, 0x00, // mov edx, 13
+				0xCD, 0x80, // int 0x80
+				0x66, 0xB8, 0x01, 0x00, 0x00, 0x00, // mov eax, 1
+				0x66, 0xBB, 0x00, 0x00, 0x00, 0x00, // mov ebx, 0
+				0xCD, 0x80, // int 0x80
+				'H', 'e', 'l', 'l', 'o', ' ', 'w', 'o', 'r', 'l', 'd', '!', '\n',
+			},
+		},
+	}
+
+	buf, err := ioutil.ReadFile("../../testdata/hello_x86.asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, g := range golden {
+		obj, err := asmtest.Assemble(nil, g.enc, string(buf))
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if got := flatten(obj); !bytes.Equal(got, g.want) {
+			t.Errorf("i=%d: output mismatch; expected %x, got %x", i, g.want, got)
+		}
+	}
+}
+
+func TestEncodeHello64(t *testing.T) {
+	want := []byte{
+		0xB8, 0x01, 0x00, 0x00, 0x00, // mov eax, 1
+		0xBF, 0x01, 0x00, 0x00, 0x00, // mov edi, 1
+		0x48, 0x8D, 0x35, 0x13, 0x00, 0x00, 0x00, // lea rsi, [rel hello]
+		0xBA, 0x0D, 0x00, 0x00, 0x00, // mov edx, 13
+		0x0F, 0x05, // syscall
+		0xB8, 0x3C, 0x00, 0x00, 0x00, // mov eax, 60
+		0x31, 0xFF, // xor edi, edi
+		0x0F, 0x05, // syscall
+		0x00, 0x00, 0x00, // padding
+		'H', 'e', 'l', 'l', 'o', ' ', 'w', 'o', 'r', 'l', 'd', '!', '\n',
+	}
+	buf, err := ioutil.ReadFile("../../testdata/hello_x86_64.asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := asmtest.Assemble(nil, Encoder64, string(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := flatten(obj); !bytes.Equal(got, want) {
+		t.Errorf("output mismatch; expected %x, got %x", want, got)
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	golden := []struct {
+		in   string
+		want string
+	}{
+		// i=0
+		{in: "mov al, 256", want: "1:1: ast.EvalWidth: value 256 overflows 8-bit operand"},
+		// i=1
+		{in: "mov eax, bl", want: `1:1: parser.parseInst: arch.OpTable.Validate: invalid operands of "mov"; expected "r8, r8" or "r8, mem" or "mem, r8" or "r8, imm" or "mem8, imm" or "r16, r16" or "r16, mem" or "mem, r16" or "r16, imm" or "mem16, imm" or "r32, r32" or "r32, mem" or "mem, r32" or "r32, imm" or "mem32, imm"; got [identifier]: "mov"`},
+		// i=2
+		{in: "shl eax, bl", want: `1:1: x86.encoding.shift: invalid shift count register "bl"; expected cl`},
+		// i=3
+		{in: "int 256", want: `1:1: x86.encoding.uimm: immediate 256 of "int" out of range [0, 255]`},
+		// i=4
+		{in: "loop 0x100", want: "1:1: x86.encoding.shortBranch: branch target 0x100 out of range; displacement 254 not in range [-128, 127]"},
+		// i=5
+		{in: "jmp foo", want: `1:1: ast.Eval: undefined symbol "foo"`},
+		// i=6
+		{in: "mov eax, [rel 0x10]", want: "1:1: x86.encoding.mem: RIP-relative addressing requires 64-bit mode"},
+		// i=7
+		{in: "mov byte [eax], ebx", want: `1:1: parser.parseInst: x86.checkMemSize: mismatched operand sizes of "mov"; 32-bit register and 8-bit memory operand; got [identifier]: "mov"`},
+		// i=8
+		{in: "inc [eax]", want: `1:1: parser.parseInst: arch.OpTable.Validate: invalid operands of "inc"; expected "r8" or "mem8" or "r16" or "mem16" or "r32" or "mem32"; got [identifier]: "inc"`},
+		// i=9
+		{in: "jmp word [eax]", want: `1:1: x86.encoding.memSize: invalid 16-bit memory operand of "jmp"; expected 32-bit`},
+		// i=10
+		{in: "mov eax, [esp*2]", want: `1:1: x86.encoding.mem: invalid index register "esp"`},
+		// i=11
+		{in: "mov eax, [ebx+cx]", want: `1:1: x86.encoding.mem: mismatched address sizes of base register "ebx" and index register "cx"`},
+		// i=12
+		{in: "mov eax, byte [ebx]", want: `1:1: parser.parseInst: x86.checkMemSize: mismatched operand sizes of "mov"; 32-bit register and 8-bit memory operand; got [identifier]: "mov"`},
+		// i=13
+		{in: "imul cx, dword [ebx], 3", want: `1:1: parser.parseInst: x86.checkMemSize: mismatched operand sizes of "imul"; 16-bit register and 32-bit memory operand; got [identifier]: "imul"`},
+	}
+
+	for i, g := range golden {
+		_, err := asmtest.Assemble(nil, Encoder, g.in)
+		if err == nil {
+			t.Errorf("i=%d: expected error %q, got nil", i, g.want)
+			continue
+		}
+		if got := err.Error(); got != g.want {
+			t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
+
+func TestEncodeError64(t *testing.T) {
+	golden := []struct {
+		in   string
+		want string
+	}{
+		// i=0
+		{in: "mov ah, r8b", want: `1:1: x86.prefixes.emit: unable to encode "ah" together with a REX prefix`},
+		// i=1
+		{in: "mov eax, [ebx+rcx]", want: `1:1: x86.encoding.mem: mismatched address sizes of base register "ebx" and index register "rcx"`},
+		// i=2
+		{in: "mov eax, [rsp*2]", want: `1:1: x86.encoding.mem: invalid index register "rsp"`},
+		// i=3
+		{in: "mov eax, [bx]", want: "1:1: x86.encoding.mem: unsupported 16-bit addressing"},
+		// i=4
+		{in: "add rax, 0x80000000", want: "1:1: x86.encoding.evalImm: immediate 0x80000000 out of range of sign-extended 32-bit immediate"},
+	}
+
+	for i, g := range golden {
+		_, err := asmtest.Assemble(nil, Encoder64, g.in)
+		if err == nil {
+			t.Errorf("i=%d: expected error %q, got nil", i, g.want)
+			continue
+		}
+		if got := err.Error(); got != g.want {
+			t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
@@ -1,14 +1,44 @@
-// TODO(u): Add support for expressions. Update the comment of Node to reflect
-// this addition.
-
 // Package ast declares the types used to represent abstract syntax trees of
 // assembly source code.
 package ast
 
-// Node represent an instruction, a directive or an argument of the abstract
-// syntax tree.
+import "github.com/mewlang/asm/token"
+
+// Node represent an instruction, a directive, an argument or an expression of
+// the abstract syntax tree.
 type Node interface{}
 
+// Program represent an assembly program which consists of zero or more lines.
+type Program struct {
+	// Lines is a slice of zero or more non-empty lines.
+	Lines []*Line
+}
+
+// Line represent a line of assembly source code which consists of an optional
+// label declaration, an optional instruction or directive and an optional line
+// comment.
+type Line struct {
+	// Pos is the position of the first token of the line.
+	Pos token.Pos
+	// Label is the label declaration of the line; or nil if not present.
+	Label *LabelDecl
+	// Node is the instruction (*Inst) or directive of the line; or nil if not
+	// present.
+	Node Node
+	// Comment is the line comment of the line, including the leading ';'; or an
+	// empty string if not present.
+	Comment string
+}
+
+// LabelDecl represent a label declaration which associates a name with the
+// memory location of the instruction or directive that follows it. The names
+// of local labels start with a '.', and are scoped to the nearest preceding
+// non-local label.
+type LabelDecl struct {
+	// Name is the name of the label.
+	Name string
+}
+
 // Inst represent an instruction which consists of an operation (opcode) and
 // zero or more operands (arguments). Some instructions are not implemented in
 // hardware; these pseudo-instructions represent one or more native
@@ -21,28 +51,228 @@ type Inst struct {
 }
 
 // An Op specifies the operation to perform of an instruction; such as ADD, BEQ,
-// XOR, etc.
-type Op int
+// XOR, etc. It holds the mnemonic of the instruction as it appears in the
+// source code, since the set of valid operations is architecture specific.
+type Op string
 
 // Arg represent an instruction operand which specifies an address, a label, a
-// register or an immediate value.
+// register, an immediate value or a constant expression.
 type Arg interface{}
 
 // Addr represent an address which specifies a memory location.
-type Addr uint32
+type Addr uint64
 
 // Ident represent an identifier which specifies a memory location.
 type Ident string
 
-// Reg represent a register from r0 through r31.
-type Reg uint8
+// Reg represent a register. The numbering of registers is architecture
+// specific; see the arch package.
+type Reg uint16
+
+// Int represent a signed integer value.
+type Int int64
 
-// Int represent a 16-bit signed integer value.
-type Int int16
+// Uint represent an unsigned integer value, which does not fit in an Int.
+type Uint uint64
 
-// Uint represent a 16-bit unsigned integer value.
-type Uint uint16
+// Float represent a floating-point value.
+type Float float64
+
+// String represent the value of a string literal, with quotes removed and
+// escape sequences interpreted.
+type String string
+
+// BinaryExpr represent a binary expression; such as buf_end - buf.
+type BinaryExpr struct {
+	// X is the left operand.
+	X Arg
+	// Op is the operator; one of token.Add, token.Sub, token.Mul, token.Div,
+	// token.Mod, token.And, token.Or, token.Xor, token.Shl or token.Shr.
+	Op token.Type
+	// Y is the right operand.
+	Y Arg
+}
+
+// UnaryExpr represent a unary expression; such as ~flags.
+type UnaryExpr struct {
+	// Op is the operator; one of token.Add, token.Sub or token.Not.
+	Op token.Type
+	// X is the operand.
+	X Arg
+}
+
+// ParenExpr represent a parenthesized expression; such as (1 << 12).
+type ParenExpr struct {
+	// X is the expression inside of the parentheses.
+	X Arg
+}
+
+// Mem represent a memory operand, which specifies the address of a memory
+// location as the sum of a base register, a scaled index register and a
+// displacement; such as [ebx + ecx*4 + 8] or [x0, w1, sxtw #2]. The size of the
+// memory location may be given by a size keyword; such as dword [eax].
+type Mem struct {
+	// Base is the base register; or nil if not present.
+	Base Arg
+	// Index is the index register; or nil if not present.
+	Index Arg
+	// Scale is the scale factor of the index register; or 0 if not present.
+	Scale int
+	// Extend is the shift or extend operator applied to the index register;
+	// such as "lsl" or "sxtw", or an empty string if not present. The shift
+	// amount of the operator is given by Scale, which is 0 if the amount is
+	// omitted; e.g. [x0, w1, sxtw].
+	Extend string
+	// Disp is the displacement; or nil if not present.
+	Disp Arg
+	// Rel specifies that the address is relative to the instruction pointer;
+	// such as [rel msg].
+	Rel bool
+	// Size is the size in bits of the memory location, as specified by a size
+	// keyword; or 0 if not specified.
+	Size int
+}
+
+// Imm represent an immediate operand marked by a '#' prefix; such as #16.
+type Imm struct {
+	// X is the immediate value or constant expression.
+	X Arg
+}
+
+// Literal represent a constant which is loaded from a literal pool; such as
+// =0x12345678.
+type Literal struct {
+	// X is the constant expression.
+	X Arg
+}
+
+// RegList represent a list of registers; such as {r4-r7, lr}. Register ranges
+// are expanded by the parser.
+type RegList struct {
+	// Regs holds the registers of the list, in order of appearance.
+	Regs []Arg
+}
+
+// ShiftedReg represent a register operand whose value is shifted or extended
+// before use; such as r2, lsl #3 or w1, sxtw. Shifted immediates, such as
+// #1, lsl #12, are represented by a ShiftedReg whose operand is an *Imm.
+type ShiftedReg struct {
+	// X is the shifted register or immediate.
+	X Arg
+	// Op is the shift or extend operator; such as "lsl", "ror" or "uxtw".
+	Op string
+	// Amount is the shift amount; or nil if not present (e.g. rrx).
+	Amount Arg
+}
+
+// Writeback represent a base register which is updated with the address
+// computed by the instruction; such as r0! in stmdb r0!, {r4-r11}, or the
+// pre-indexed memory operand [sp, #-16]! whose base register is updated before
+// the memory access.
+type Writeback struct {
+	// X is the base register (ast.Reg) or memory operand (*Mem).
+	X Arg
+}
 
 // A Directive is a command to the assembler which specifies memory alignment,
-// section names and program origin among others.
-type Directive int
+// section names and program origin among others. The underlying type of a
+// Directive is one of the following:
+//
+//	*SectionDir
+//	*GlobalDir
+//	*ExternDir
+//	*AlignDir
+//	*DataDir
+//	*OrgDir
+//	*EquDir
+//	*PoolDir
+type Directive interface {
+	// isDirective ensures that only directive nodes can be assigned to the
+	// Directive interface.
+	isDirective()
+}
+
+// SectionDir represent a section directive, which specifies the section of
+// subsequent instructions and data; such as
+//
+//	section ".text"
+type SectionDir struct {
+	// Name is the name of the section.
+	Name string
+}
+
+// GlobalDir represent a global directive, which exports one or more symbols;
+// such as
+//
+//	global _start
+type GlobalDir struct {
+	// Names is a slice of one or more symbol names.
+	Names []string
+}
+
+// ExternDir represent an extern directive, which declares one or more symbols
+// defined by other objects; such as
+//
+//	extern printf
+type ExternDir struct {
+	// Names is a slice of one or more symbol names.
+	Names []string
+}
+
+// AlignDir represent an align directive, which aligns the address of the
+// subsequent instruction or data to a multiple of a power of two; such as
+//
+//	align 0x80
+type AlignDir struct {
+	// Align is the alignment in bytes.
+	Align Arg
+}
+
+// DataDir represent a data directive, which stores a sequence of values of a
+// given element width; such as
+//
+//	db "Hello world", '!', 10
+type DataDir struct {
+	// Width is the width in bytes of each element; 1 for db, 2 for dw, 4 for dd
+	// and 8 for dq.
+	Width int
+	// Vals is a slice of one or more values; string values are stored as one
+	// element per character.
+	Vals []Arg
+}
+
+// OrgDir represent an origin directive, which specifies the address at which
+// the program is loaded; such as
+//
+//	org 0x7C00
+type OrgDir struct {
+	// Addr is the origin address.
+	Addr Arg
+}
+
+// EquDir represent an equate directive, which defines a symbol of a constant
+// value; such as
+//
+//	buf_len equ buf_end - buf
+type EquDir struct {
+	// Name is the name of the symbol.
+	Name string
+	// Val is the value of the symbol.
+	Val Arg
+}
+
+// PoolDir represent a literal pool directive, which places the pending
+// constants of literal operands (such as =0x12345678) at the current location;
+// such as
+//
+//	pool
+type PoolDir struct{}
+
+func (*SectionDir) isDirective() {}
+func (*GlobalDir) isDirective()  {}
+func (*ExternDir) isDirective()  {}
+func (*AlignDir) isDirective()   {}
+func (*DataDir) isDirective()    {}
+func (*OrgDir) isDirective()     {}
+func (*EquDir) isDirective()     {}
+func (*PoolDir) isDirective()    {}
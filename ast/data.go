@@ -0,0 +1,69 @@
+package ast
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Len returns the length in bytes of the data stored by the data directive. A
+// string value occupies one element per character, padded with zeros to a
+// multiple of the element width.
+func (d *DataDir) Len() int {
+	n := 0
+	for _, val := range d.Vals {
+		if s, ok := val.(String); ok {
+			n += (len(s) + d.Width - 1) / d.Width * d.Width
+			continue
+		}
+		n += d.Width
+	}
+	return n
+}
+
+// Encode returns the data stored by the data directive, using the provided
+// byte order for multi-byte elements. Identifiers are resolved using the
+// provided symbol table. Floating-point values may only be stored by 4 and 8
+// byte elements.
+func (d *DataDir) Encode(syms SymbolTable, order binary.ByteOrder) (buf []byte, err error) {
+	buf = make([]byte, 0, d.Len())
+	elem := make([]byte, 8)
+	for _, val := range d.Vals {
+		if s, ok := val.(String); ok {
+			buf = append(buf, s...)
+			for len(buf)%d.Width != 0 {
+				buf = append(buf, 0)
+			}
+			continue
+		}
+		var x uint64
+		if IsFloat(val) {
+			if d.Width != 4 && d.Width != 8 {
+				return nil, fmt.Errorf("ast.DataDir.Encode: floating-point value in %d-byte data element", d.Width)
+			}
+			x, err = FloatBits(val, 8*d.Width)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			v, err := EvalWidth(val, syms, 8*d.Width)
+			if err != nil {
+				return nil, err
+			}
+			x = uint64(v)
+		}
+		switch d.Width {
+		case 1:
+			elem[0] = byte(x)
+		case 2:
+			order.PutUint16(elem, uint16(x))
+		case 4:
+			order.PutUint32(elem, uint32(x))
+		case 8:
+			order.PutUint64(elem, x)
+		default:
+			return nil, fmt.Errorf("ast.DataDir.Encode: invalid element width %d", d.Width)
+		}
+		buf = append(buf, elem[:d.Width]...)
+	}
+	return buf, nil
+}
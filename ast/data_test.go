@@ -0,0 +1,100 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/mewlang/asm/token"
+)
+
+func TestDataDirEncode(t *testing.T) {
+	syms := Symbols{"hello": 0x08049000}
+	golden := []struct {
+		dir   *DataDir
+		order binary.ByteOrder
+		want  []byte
+		err   string
+	}{
+		// i=0
+		{
+			dir:   &DataDir{Width: 1, Vals: []Arg{String("Hello world"), Int('!'), Int(10)}},
+			order: binary.LittleEndian,
+			want:  []byte("Hello world!\n"),
+		},
+		// i=1
+		{
+			dir:   &DataDir{Width: 2, Vals: []Arg{String("abc"), Int(-1)}},
+			order: binary.BigEndian,
+			want:  []byte{'a', 'b', 'c', 0, 0xFF, 0xFF},
+		},
+		// i=2
+		{
+			dir:   &DataDir{Width: 4, Vals: []Arg{Ident("hello"), Float(1.5)}},
+			order: binary.LittleEndian,
+			want:  []byte{0x00, 0x90, 0x04, 0x08, 0x00, 0x00, 0xC0, 0x3F},
+		},
+		// i=3
+		{
+			dir:   &DataDir{Width: 8, Vals: []Arg{Float(-2)}},
+			order: binary.BigEndian,
+			want:  []byte{0xC0, 0, 0, 0, 0, 0, 0, 0},
+		},
+		// i=4
+		{
+			dir:   &DataDir{Width: 1, Vals: []Arg{Int(256)}},
+			order: binary.LittleEndian,
+			err:   "ast.EvalWidth: value 256 overflows 8-bit operand",
+		},
+		// i=5
+		{
+			dir:   &DataDir{Width: 2, Vals: []Arg{Float(1)}},
+			order: binary.LittleEndian,
+			err:   "ast.DataDir.Encode: floating-point value in 2-byte data element",
+		},
+		// i=6
+		{
+			dir:   &DataDir{Width: 8, Vals: []Arg{Uint(0xFFFFFFFFFFFFFFFF)}},
+			order: binary.LittleEndian,
+			want:  []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
+		},
+		// i=7
+		{
+			dir:   &DataDir{Width: 4, Vals: []Arg{Uint(0xFFFFFFFFFFFFFFFF)}},
+			order: binary.LittleEndian,
+			err:   "ast.EvalWidth: value 18446744073709551615 overflows 32-bit operand",
+		},
+		// i=8
+		{
+			dir:   &DataDir{Width: 2, Vals: []Arg{Uint(0xFFFFFFFFFFFFFFF0)}},
+			order: binary.LittleEndian,
+			err:   "ast.EvalWidth: value 18446744073709551600 overflows 16-bit operand",
+		},
+		// i=9
+		{
+			dir:   &DataDir{Width: 8, Vals: []Arg{&BinaryExpr{X: Uint(0xFFFFFFFFFFFFFFFF), Op: token.Add, Y: Int(1)}}},
+			order: binary.LittleEndian,
+			err:   "ast.Eval: integer overflow; unsigned value 18446744073709551615 in expression",
+		},
+	}
+
+	for i, g := range golden {
+		got, err := g.dir.Encode(syms, g.order)
+		if len(g.err) > 0 {
+			if err == nil || err.Error() != g.err {
+				t.Errorf("i=%d: expected error %q, got %v", i, g.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if !bytes.Equal(got, g.want) {
+			t.Errorf("i=%d: expected % X, got % X", i, g.want, got)
+		}
+		if n := g.dir.Len(); n != len(g.want) {
+			t.Errorf("i=%d: length mismatch; expected %d, got %d", i, len(g.want), n)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package ast
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mewlang/asm/token"
+)
+
+// A SymbolTable maps symbol names, such as labels, to their values.
+type SymbolTable interface {
+	// Lookup returns the value of the named symbol and true if the symbol is
+	// defined, and false otherwise.
+	Lookup(name string) (val int64, ok bool)
+}
+
+// Symbols is a symbol table backed by a map from symbol name to value.
+type Symbols map[string]int64
+
+// Lookup returns the value of the named symbol and true if the symbol is
+// defined, and false otherwise.
+func (syms Symbols) Lookup(name string) (val int64, ok bool) {
+	val, ok = syms[name]
+	return val, ok
+}
+
+// An UndefinedError reports a reference to a symbol which is not defined in the
+// symbol table.
+type UndefinedError struct {
+	// Name of the undefined symbol.
+	Name string
+}
+
+func (e *UndefinedError) Error() string {
+	return fmt.Sprintf("ast.Eval: undefined symbol %q", e.Name)
+}
+
+// Eval evaluates the constant expression x and returns its value. Identifiers
+// are resolved using the provided symbol table, which may be nil if x contains
+// no identifiers. An error is returned if an intermediate result overflows a
+// 64-bit signed integer. Unsigned values which overflow a 64-bit signed integer
+// evaluate to their two's complement representation, and are invalid operands
+// of unary and binary expressions.
+func Eval(x Arg, syms SymbolTable) (val int64, err error) {
+	switch x := x.(type) {
+	case Int:
+		return int64(x), nil
+	case Uint:
+		return int64(x), nil
+	case Addr:
+		return int64(x), nil
+	case Ident:
+		if syms != nil {
+			if val, ok := syms.Lookup(string(x)); ok {
+				return val, nil
+			}
+		}
+		return 0, &UndefinedError{Name: string(x)}
+	case Float:
+		return 0, fmt.Errorf("ast.Eval: floating-point value %v is not an integer constant", float64(x))
+	case *ParenExpr:
+		return Eval(x.X, syms)
+	case *Imm:
+		return Eval(x.X, syms)
+	case *UnaryExpr:
+		v, err := evalOperand(x.X, syms)
+		if err != nil {
+			return 0, err
+		}
+		switch x.Op {
+		case token.Add:
+			return v, nil
+		case token.Sub:
+			if v == math.MinInt64 {
+				return 0, fmt.Errorf("ast.Eval: integer overflow in -(%d)", v)
+			}
+			return -v, nil
+		case token.Not:
+			return ^v, nil
+		}
+		return 0, fmt.Errorf("ast.Eval: invalid unary operator %v", x.Op)
+	case *BinaryExpr:
+		a, err := evalOperand(x.X, syms)
+		if err != nil {
+			return 0, err
+		}
+		b, err := evalOperand(x.Y, syms)
+		if err != nil {
+			return 0, err
+		}
+		return evalBinary(a, x.Op, b)
+	}
+	return 0, fmt.Errorf("ast.Eval: %T is not a constant expression", x)
+}
+
+// evalOperand evaluates the operand x of a unary or binary expression. An error
+// is returned if x is an unsigned value which overflows a 64-bit signed integer.
+func evalOperand(x Arg, syms SymbolTable) (val int64, err error) {
+	if u, ok := bigUint(x); ok {
+		return 0, fmt.Errorf("ast.Eval: integer overflow; unsigned value %d in expression", u)
+	}
+	return Eval(x, syms)
+}
+
+// bigUint returns the value of x and true if x is an unsigned value which
+// overflows a 64-bit signed integer, and false otherwise.
+func bigUint(x Arg) (val uint64, ok bool) {
+	switch x := x.(type) {
+	case Uint:
+		return uint64(x), x > math.MaxInt64
+	case *ParenExpr:
+		return bigUint(x.X)
+	case *Imm:
+		return bigUint(x.X)
+	}
+	return 0, false
+}
+
+// evalBinary returns the result of the binary operation a op b.
+func evalBinary(a int64, op token.Type, b int64) (val int64, err error) {
+	switch op {
+	case token.Add:
+		val = a + b
+		if (b > 0 && val < a) || (b < 0 && val > a) {
+			return 0, fmt.Errorf("ast.Eval: integer overflow in %d + %d", a, b)
+		}
+		return val, nil
+	case token.Sub:
+		val = a - b
+		if (b < 0 && val < a) || (b > 0 && val > a) {
+			return 0, fmt.Errorf("ast.Eval: integer overflow in %d - %d", a, b)
+		}
+		return val, nil
+	case token.Mul:
+		val = a * b
+		if a != 0 && (val/a != b || (a == -1 && b == math.MinInt64)) {
+			return 0, fmt.Errorf("ast.Eval: integer overflow in %d * %d", a, b)
+		}
+		return val, nil
+	case token.Div, token.Mod:
+		if b == 0 {
+			return 0, fmt.Errorf("ast.Eval: division by zero in %d %v %d", a, op, b)
+		}
+		if a == math.MinInt64 && b == -1 {
+			return 0, fmt.Errorf("ast.Eval: integer overflow in %d %v %d", a, op, b)
+		}
+		if op == token.Div {
+			return a / b, nil
+		}
+		return a % b, nil
+	case token.And:
+		return a & b, nil
+	case token.Or:
+		return a | b, nil
+	case token.Xor:
+		return a ^ b, nil
+	case token.Shl, token.Shr:
+		if b < 0 || b > 63 {
+			return 0, fmt.Errorf("ast.Eval: invalid shift count %d", b)
+		}
+		if op == token.Shr {
+			return a >> uint(b), nil
+		}
+		val = a << uint(b)
+		if val>>uint(b) != a {
+			return 0, fmt.Errorf("ast.Eval: integer overflow in %d << %d", a, b)
+		}
+		return val, nil
+	}
+	return 0, fmt.Errorf("ast.Eval: invalid binary operator %v", op)
+}
+
+// EvalWidth evaluates the constant expression x and returns its value. An error
+// is returned if the value does not fit in an operand of the given width in
+// bits, using either a signed or an unsigned interpretation. Unsigned values
+// which overflow a 64-bit signed integer only fit in 64-bit operands.
+func EvalWidth(x Arg, syms SymbolTable, width int) (val int64, err error) {
+	if u, ok := bigUint(x); ok && width < 64 {
+		return 0, fmt.Errorf("ast.EvalWidth: value %d overflows %d-bit operand", u, width)
+	}
+	val, err = Eval(x, syms)
+	if err != nil {
+		return 0, err
+	}
+	if !Fits(val, width) {
+		return 0, fmt.Errorf("ast.EvalWidth: value %d overflows %d-bit operand", val, width)
+	}
+	return val, nil
+}
+
+// Fits returns true if val fits in the given width in bits, using either a
+// signed or an unsigned interpretation, and false otherwise.
+func Fits(val int64, width int) bool {
+	if width >= 64 {
+		return true
+	}
+	return -1<<uint(width-1) <= val && val < 1<<uint(width)
+}
+
+// IsFloat returns true if x is a floating-point constant expression, and false
+// otherwise.
+func IsFloat(x Arg) bool {
+	switch x := x.(type) {
+	case Float:
+		return true
+	case *ParenExpr:
+		return IsFloat(x.X)
+	case *UnaryExpr:
+		return IsFloat(x.X)
+	}
+	return false
+}
+
+// EvalFloat evaluates the floating-point constant expression x and returns its
+// value. Floating-point constant expressions consist of floating-point values,
+// parentheses and the unary operators '+' and '-'.
+func EvalFloat(x Arg) (val float64, err error) {
+	switch x := x.(type) {
+	case Float:
+		return float64(x), nil
+	case *ParenExpr:
+		return EvalFloat(x.X)
+	case *UnaryExpr:
+		v, err := EvalFloat(x.X)
+		if err != nil {
+			return 0, err
+		}
+		switch x.Op {
+		case token.Add:
+			return v, nil
+		case token.Sub:
+			return -v, nil
+		}
+		return 0, fmt.Errorf("ast.EvalFloat: invalid unary operator %v for floating-point operand", x.Op)
+	}
+	return 0, fmt.Errorf("ast.EvalFloat: %T is not a floating-point constant expression", x)
+}
+
+// FloatBits evaluates the floating-point constant expression x and returns its
+// IEEE 754 binary representation of the given width in bits; either 32 or 64.
+// An error is returned if the value overflows the given width. Note that 32-bit
+// values are rounded from the 64-bit value of x; the parser rounds the
+// floating-point literals of 4-byte data directives directly to 32 bits.
+func FloatBits(x Arg, width int) (bits uint64, err error) {
+	val, err := EvalFloat(x)
+	if err != nil {
+		return 0, err
+	}
+	switch width {
+	case 32:
+		// Values which round to the largest finite 32-bit floating-point value
+		// do not overflow.
+		f := float32(val)
+		if math.IsInf(float64(f), 0) && !math.IsInf(val, 0) {
+			return 0, fmt.Errorf("ast.FloatBits: value %v overflows 32-bit floating-point operand", val)
+		}
+		return uint64(math.Float32bits(f)), nil
+	case 64:
+		return math.Float64bits(val), nil
+	}
+	return 0, fmt.Errorf("ast.FloatBits: invalid floating-point width %d", width)
+}
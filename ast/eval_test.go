@@ -0,0 +1,154 @@
+package ast
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mewlang/asm/token"
+)
+
+func TestEval(t *testing.T) {
+	syms := Symbols{
+		"buf":     0x1000,
+		"buf_end": 0x1040,
+		"flags":   0x3,
+	}
+	golden := []struct {
+		x    Arg
+		want int64
+		err  string
+	}{
+		// i=0
+		{x: &BinaryExpr{X: Ident("buf_end"), Op: token.Sub, Y: Ident("buf")}, want: 0x40},
+		// i=1
+		{x: &BinaryExpr{X: &ParenExpr{X: &BinaryExpr{X: Int(1), Op: token.Shl, Y: Int(12)}}, Op: token.Or, Y: Ident("flags")}, want: 0x1003},
+		// i=2
+		{x: &UnaryExpr{Op: token.Not, X: Uint(0)}, want: -1},
+		// i=3
+		{x: &BinaryExpr{X: Int(-7), Op: token.Mod, Y: Int(3)}, want: -1},
+		// i=4
+		{x: &BinaryExpr{X: Int(100), Op: token.Shr, Y: Int(2)}, want: 25},
+		// i=5
+		{x: &BinaryExpr{X: Int(6), Op: token.And, Y: &BinaryExpr{X: Int(3), Op: token.Xor, Y: Int(1)}}, want: 2},
+		// i=6
+		{x: &BinaryExpr{X: Ident("buf"), Op: token.Add, Y: Ident("missing")}, err: `ast.Eval: undefined symbol "missing"`},
+		// i=7
+		{x: &BinaryExpr{X: Int(1), Op: token.Div, Y: Int(0)}, err: "ast.Eval: division by zero in 1 / 0"},
+		// i=8
+		{x: &BinaryExpr{X: Int(1), Op: token.Shl, Y: Int(64)}, err: "ast.Eval: invalid shift count 64"},
+		// i=9
+		{x: &BinaryExpr{X: &BinaryExpr{X: Int(1), Op: token.Shl, Y: Int(62)}, Op: token.Mul, Y: Int(4)}, err: "ast.Eval: integer overflow in 4611686018427387904 * 4"},
+		// i=10
+		{x: String("foo"), err: "ast.Eval: ast.String is not a constant expression"},
+		// i=11
+		{x: &Imm{X: &UnaryExpr{Op: token.Sub, X: Ident("flags")}}, want: -3},
+		// i=12
+		{x: &Literal{X: Int(1)}, err: "ast.Eval: *ast.Literal is not a constant expression"},
+		// i=13
+		{x: Uint(0xFFFFFFFFFFFFFFFF), want: -1},
+		// i=14
+		{x: &BinaryExpr{X: Uint(0xFFFFFFFFFFFFFFFF), Op: token.Add, Y: Int(1)}, err: "ast.Eval: integer overflow; unsigned value 18446744073709551615 in expression"},
+		// i=15
+		{x: &UnaryExpr{Op: token.Sub, X: &ParenExpr{X: Uint(0x8000000000000000)}}, err: "ast.Eval: integer overflow; unsigned value 9223372036854775808 in expression"},
+	}
+
+	for i, g := range golden {
+		got, err := Eval(g.x, syms)
+		if len(g.err) > 0 {
+			if err == nil || err.Error() != g.err {
+				t.Errorf("i=%d: expected error %q, got %v", i, g.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if got != g.want {
+			t.Errorf("i=%d: expected %d, got %d", i, g.want, got)
+		}
+	}
+}
+
+func TestEvalWidth(t *testing.T) {
+	golden := []struct {
+		x     Arg
+		width int
+		err   string
+	}{
+		// i=0
+		{x: Int(-128), width: 8},
+		// i=1
+		{x: Int(255), width: 8},
+		// i=2
+		{x: Int(256), width: 8, err: "ast.EvalWidth: value 256 overflows 8-bit operand"},
+		// i=3
+		{x: Int(-129), width: 8, err: "ast.EvalWidth: value -129 overflows 8-bit operand"},
+		// i=4
+		{x: &BinaryExpr{X: Int(1), Op: token.Shl, Y: Int(16)}, width: 16, err: "ast.EvalWidth: value 65536 overflows 16-bit operand"},
+		// i=5
+		{x: &UnaryExpr{Op: token.Sub, X: Int(1)}, width: 64},
+		// i=6
+		{x: Uint(0xFFFFFFFFFFFFFFFF), width: 64},
+		// i=7
+		{x: Uint(0xFFFFFFFFFFFFFFFF), width: 32, err: "ast.EvalWidth: value 18446744073709551615 overflows 32-bit operand"},
+	}
+
+	for i, g := range golden {
+		_, err := EvalWidth(g.x, nil, g.width)
+		if len(g.err) > 0 {
+			if err == nil || err.Error() != g.err {
+				t.Errorf("i=%d: expected error %q, got %v", i, g.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+		}
+	}
+}
+
+func TestFloatBits(t *testing.T) {
+	golden := []struct {
+		x     Arg
+		width int
+		want  uint64
+		err   string
+	}{
+		// i=0
+		{x: Float(1.5), width: 32, want: 0x3FC00000},
+		// i=1
+		{x: Float(1.5), width: 64, want: 0x3FF8000000000000},
+		// i=2
+		{x: &UnaryExpr{Op: token.Sub, X: &ParenExpr{X: Float(2)}}, width: 32, want: 0xC0000000},
+		// i=3
+		{x: Float(1e300), width: 32, err: "ast.FloatBits: value 1e+300 overflows 32-bit floating-point operand"},
+		// i=4
+		{x: Int(1), width: 32, err: "ast.EvalFloat: ast.Int is not a floating-point constant expression"},
+		// i=5
+		{x: Float(1), width: 16, err: "ast.FloatBits: invalid floating-point width 16"},
+		// i=6
+		{x: Float(math.MaxFloat32), width: 32, want: 0x7F7FFFFF},
+		// i=7
+		{x: &UnaryExpr{Op: token.Sub, X: Float(3.40282356e38)}, width: 32, want: 0xFF7FFFFF},
+		// i=8
+		{x: Float(3.4028236e38), width: 32, err: "ast.FloatBits: value 3.4028236e+38 overflows 32-bit floating-point operand"},
+	}
+
+	for i, g := range golden {
+		got, err := FloatBits(g.x, g.width)
+		if len(g.err) > 0 {
+			if err == nil || err.Error() != g.err {
+				t.Errorf("i=%d: expected error %q, got %v", i, g.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if got != g.want {
+			t.Errorf("i=%d: expected 0x%X, got 0x%X", i, g.want, got)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Package arm64 implements an AArch64 instruction decoder for the integer
+// instructions of the A64 instruction set.
+//
+// The decoder is the inverse of the asm/arm64 encoder; every decoded
+// instruction encodes back into the same machine code. Like LLVM, instructions
+// are decoded into their preferred aliases; such as mov, cmp, lsl and cset.
+// Floating-point and SIMD instructions, instructions of later versions of the
+// architecture, and instructions with reserved field values or unpredictable
+// behaviour are reported as invalid.
+package arm64
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mewlang/asm/arch"
+	arm64arch "github.com/mewlang/asm/arch/arm64"
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/disasm"
+)
+
+// Decoder is the A64 instruction decoder.
+var Decoder disasm.Decoder = decoder{}
+
+// decoder implements the disasm.Decoder interface for AArch64.
+type decoder struct{}
+
+// Arch returns the AArch64 architecture.
+func (decoder) Arch() arch.Arch {
+	return arm64arch.Arch
+}
+
+// Decode decodes the first little-endian instruction of src, which is located
+// at address pc, and returns the instruction and its length in bytes; which is
+// always 4. Branch targets and the addresses of literal loads are represented
+// by ast.Addr operands.
+func (decoder) Decode(src []byte, pc int64) (inst ast.Inst, n int, err error) {
+	if len(src) < 4 {
+		return inst, len(src), fmt.Errorf("arm64.Decode: truncated instruction; expected 4 bytes, got %d", len(src))
+	}
+	w := word(binary.LittleEndian.Uint32(src))
+	inst, ok := decodeWord(w, pc)
+	if !ok {
+		return inst, 4, fmt.Errorf("arm64.Decode: invalid instruction word 0x%08X", uint32(w))
+	}
+	return inst, 4, nil
+}
+
+// A word is a 32-bit A64 instruction word.
+type word uint32
+
+// Fields of instruction words. The Rt and Rs fields are located at the same
+// positions as Rd and Rm, and the Rt2 field at the same position as Ra.
+func (w word) sf() uint32 { return uint32(w) >> 31 }
+func (w word) rd() uint32 { return uint32(w) & 0x1F }
+func (w word) rn() uint32 { return uint32(w) >> 5 & 0x1F }
+func (w word) rm() uint32 { return uint32(w) >> 16 & 0x1F }
+func (w word) ra() uint32 { return uint32(w) >> 10 & 0x1F }
+
+// field returns the n-bit field of w starting at bit lo.
+func (w word) field(lo, n uint) uint32 {
+	return uint32(w) >> lo & (1<<n - 1)
+}
+
+// sext sign-extends the n-bit value x.
+func sext(x uint32, n uint) int64 {
+	return int64(int32(x<<(32-n)) >> (32 - n))
+}
+
+// reg returns the general purpose register with the given register number, in
+// an operand position where register number 31 denotes the zero register. The
+// register is 64-bit if sf is 1, and 32-bit otherwise.
+func reg(n, sf uint32) ast.Reg {
+	if sf == 0 {
+		return arm64arch.W0 + ast.Reg(n)
+	}
+	return arm64arch.X0 + ast.Reg(n)
+}
+
+// regSP returns the general purpose register with the given register number,
+// in an operand position where register number 31 denotes the stack pointer.
+// The register is 64-bit if sf is 1, and 32-bit otherwise.
+func regSP(n, sf uint32) ast.Reg {
+	if n == 31 {
+		if sf == 0 {
+			return arm64arch.WSP
+		}
+		return arm64arch.SP
+	}
+	return reg(n, sf)
+}
+
+// imm returns the immediate operand of the given value.
+func imm(val int64) *ast.Imm {
+	return &ast.Imm{X: ast.Int(val)}
+}
+
+// shiftedImm returns the immediate operand of the given value, which is
+// shifted left by the given amount if non-zero; such as #1, lsl #12.
+func shiftedImm(val, amount int64) ast.Arg {
+	if amount == 0 {
+		return imm(val)
+	}
+	return &ast.ShiftedReg{X: imm(val), Op: "lsl", Amount: ast.Int(amount)}
+}
+
+// decodeWord decodes the instruction word w, which is located at address pc.
+// The major opcode is given by bits 28-25.
+func decodeWord(w word, pc int64) (inst ast.Inst, ok bool) {
+	switch op0 := w.field(25, 4); {
+	case op0&0xE == 0x8:
+		return decodeDataImm(w, pc)
+	case op0&0xE == 0xA:
+		return decodeBranch(w, pc)
+	case op0&0x5 == 0x4:
+		return decodeLoadStore(w, pc)
+	case op0&0x7 == 0x5:
+		return decodeDataReg(w)
+	}
+	return inst, false
+}
+
+// Hint instructions indexed by hint number.
+var hintOps = []ast.Op{"nop", "yield", "wfe", "wfi", "sev", "sevl"}
+
+// Exception generating instructions indexed by their opc and LL fields.
+var exceptionOps = map[[2]uint32]ast.Op{
+	{0, 1}: "svc",
+	{0, 2}: "hvc",
+	{0, 3}: "smc",
+	{1, 0}: "brk",
+	{2, 0}: "hlt",
+}
+
+// Barrier option names indexed by the CRm field of dmb and dsb.
+var barrierOpts = map[uint32]ast.Ident{
+	1:  "oshld",
+	2:  "oshst",
+	3:  "osh",
+	5:  "nshld",
+	6:  "nshst",
+	7:  "nsh",
+	9:  "ishld",
+	10: "ishst",
+	11: "ish",
+	13: "ld",
+	14: "st",
+	15: "sy",
+}
+
+// decodeBranch decodes the branch, exception generating and system
+// instruction word w, which is located at address pc.
+func decodeBranch(w word, pc int64) (inst ast.Inst, ok bool) {
+	u := uint32(w)
+	switch {
+	case u&0x7C000000 == 0x14000000:
+		op := ast.Op("b")
+		if w.sf() == 1 {
+			op = "bl"
+		}
+		return ast.Inst{Op: op, Args: []ast.Arg{ast.Addr(pc + sext(w.field(0, 26), 26)<<2)}}, true
+	case u&0xFF000010 == 0x54000000:
+		op := ast.Op("b." + arm64arch.Cond(w.field(0, 4)).String())
+		return ast.Inst{Op: op, Args: []ast.Arg{ast.Addr(pc + sext(w.field(5, 19), 19)<<2)}}, true
+	case u&0x7E000000 == 0x34000000:
+		op := ast.Op("cbz")
+		if w.field(24, 1) == 1 {
+			op = "cbnz"
+		}
+		return ast.Inst{Op: op, Args: []ast.Arg{reg(w.rd(), w.sf()), ast.Addr(pc + sext(w.field(5, 19), 19)<<2)}}, true
+	case u&0x7E000000 == 0x36000000:
+		op := ast.Op("tbz")
+		if w.field(24, 1) == 1 {
+			op = "tbnz"
+		}
+		bit := w.sf()<<5 | w.field(19, 5)
+		return ast.Inst{Op: op, Args: []ast.Arg{reg(w.rd(), w.sf()), imm(int64(bit)), ast.Addr(pc + sext(w.field(5, 14), 14)<<2)}}, true
+	case u&0xFF00001C == 0xD4000000:
+		op, ok := exceptionOps[[2]uint32{w.field(21, 3), w.field(0, 2)}]
+		return ast.Inst{Op: op, Args: []ast.Arg{imm(int64(w.field(5, 16)))}}, ok
+	case u&0xFFFFF01F == 0xD503201F:
+		hint := w.field(5, 7)
+		if int(hint) < len(hintOps) {
+			return ast.Inst{Op: hintOps[hint]}, true
+		}
+		return ast.Inst{Op: "hint", Args: []ast.Arg{imm(int64(hint))}}, true
+	case u&0xFFFFF01F == 0xD503301F:
+		return decodeBarrier(w)
+	case u&0xFFF00000 == 0xD5300000:
+		sysreg := ast.Reg(0x8000 | w.field(5, 15))
+		return ast.Inst{Op: "mrs", Args: []ast.Arg{reg(w.rd(), 1), sysreg}}, true
+	case u&0xFFF00000 == 0xD5100000:
+		sysreg := ast.Reg(0x8000 | w.field(5, 15))
+		return ast.Inst{Op: "msr", Args: []ast.Arg{sysreg, reg(w.rd(), 1)}}, true
+	case u&0xFFFFFC1F == 0xD61F0000:
+		return ast.Inst{Op: "br", Args: []ast.Arg{reg(w.rn(), 1)}}, true
+	case u&0xFFFFFC1F == 0xD63F0000:
+		return ast.Inst{Op: "blr", Args: []ast.Arg{reg(w.rn(), 1)}}, true
+	case u&0xFFFFFC1F == 0xD65F0000:
+		if w.rn() == uint32(arm64arch.LR) {
+			return ast.Inst{Op: "ret"}, true
+		}
+		return ast.Inst{Op: "ret", Args: []ast.Arg{reg(w.rn(), 1)}}, true
+	case u == 0xD69F03E0:
+		return ast.Inst{Op: "eret"}, true
+	}
+	return inst, false
+}
+
+// decodeBarrier decodes the clrex, dsb, dmb or isb instruction word w. The
+// barrier option is omitted if it is the default option sy.
+func decodeBarrier(w word) (inst ast.Inst, ok bool) {
+	crm := w.field(8, 4)
+	switch w.field(5, 3) {
+	case 2:
+		inst.Op = "clrex"
+	case 4:
+		inst.Op = "dsb"
+	case 5:
+		inst.Op = "dmb"
+	case 6:
+		inst.Op = "isb"
+	default:
+		return inst, false
+	}
+	switch {
+	case inst.Op == "dsb" || inst.Op == "dmb":
+		if opt, ok := barrierOpts[crm]; ok {
+			inst.Args = []ast.Arg{opt}
+		} else {
+			inst.Args = []ast.Arg{imm(int64(crm))}
+		}
+	case crm != 15:
+		inst.Args = []ast.Arg{imm(int64(crm))}
+	}
+	return inst, true
+}
@@ -0,0 +1,269 @@
+// The expected instructions of the test cases in this file have been verified
+// using llvm-mc.
+
+package arm64
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	arm64asm "github.com/mewlang/asm/asm/arm64"
+	"github.com/mewlang/asm/disasm"
+	"github.com/mewlang/asm/internal/asmtest"
+)
+
+func TestDecode(t *testing.T) {
+	golden := []struct {
+		in   string
+		want string
+		err  string
+	}{
+		// i=0
+		{in: "ff074091", want: "add sp, sp, #1, lsl #12"},
+		// i=1
+		{in: "200400d1", want: "sub x0, x1, #1"},
+		// i=2
+		{in: "20c822cb", want: "sub x0, x1, w2, sxtw #2"},
+		// i=3
+		{in: "ff6321cb", want: "sub sp, sp, x1"},
+		// i=4
+		{in: "2070228b", want: "add x0, x1, x2, uxtx #4"},
+		// i=5
+		{in: "1f0000f1", want: "cmp x0, #0"},
+		// i=6
+		{in: "e00301cb", want: "neg x0, x1"},
+		// i=7
+		{in: "e00301da", want: "ngc x0, x1"},
+		// i=8
+		{in: "209c0812", want: "and w0, w1, #4278255360"},
+		// i=9
+		{in: "e0f300b2", want: "mov x0, #6148914691236517205"},
+		// i=10
+		{in: "1f0041f2", want: "tst x0, #-9223372036854775808"},
+		// i=11
+		{in: "e00f212a", want: "mvn w0, w1, lsl #3"},
+		// i=12
+		{in: "3f000091", want: "mov sp, x1"},
+		// i=13
+		{in: "00008012", want: "mov w0, #-1"},
+		// i=14
+		{in: "2000a092", want: "mov x0, #-65537"},
+		// i=15
+		{in: "e0ddb7f2", want: "movk x0, #48879, lsl #16"},
+		// i=16
+		{in: "20f07dd3", want: "lsl x0, x1, #3"},
+		// i=17
+		{in: "20fc7f93", want: "asr x0, x1, #63"},
+		// i=18
+		{in: "201cc193", want: "ror x0, x1, #7"},
+		// i=19
+		{in: "202c44d3", want: "ubfx x0, x1, #4, #8"},
+		// i=20
+		{in: "203c78b3", want: "bfi x0, x1, #8, #16"},
+		// i=21
+		{in: "207c4093", want: "sxtw x0, w1"},
+		// i=22
+		{in: "201c0053", want: "uxtb w0, w1"},
+		// i=23
+		{in: "2008c0da", want: "rev32 x0, x1"},
+		// i=24
+		{in: "207c229b", want: "smull x0, w1, w2"},
+		// i=25
+		{in: "e0179f9a", want: "cset x0, eq"},
+		// i=26
+		{in: "20d4819a", want: "cinc x0, x1, gt"},
+		// i=27
+		{in: "0fe85f7a", want: "ccmp w0, #31, #15, al"},
+		// i=28
+		{in: "200c41f8", want: "ldr x0, [x1, #16]!"},
+		// i=29
+		{in: "208440f8", want: "ldr x0, [x1], #8"},
+		// i=30
+		{in: "2004c039", want: "ldrsb w0, [x1, #1]"},
+		// i=31
+		{in: "20d862b8", want: "ldr w0, [x1, w2, sxtw #2]"},
+		// i=32
+		{in: "20786238", want: "ldrb w0, [x1, x2, lsl #0]"},
+		// i=33
+		{in: "20f05ff8", want: "ldur x0, [x1, #-1]"},
+		// i=34
+		{in: "fd7bc1a8", want: "ldp x29, x30, [sp], #16"},
+		// i=35
+		{in: "40044169", want: "ldpsw x0, x1, [x2, #8]"},
+		// i=36
+		{in: "20fc0288", want: "stlxr w2, w0, [x1]"},
+		// i=37
+		{in: "40847f88", want: "ldaxp w0, w1, [x2]"},
+		// i=38
+		{in: "e1ffff54", want: "b.ne 0xFFFFFFFFFFFFFFFC"},
+		// i=39
+		{in: "010044b7", want: "tbnz x1, #40, 0xFFFFFFFFFFFF8000"},
+		// i=40
+		{in: "000000b0", want: "adrp x0, 0x1000"},
+		// i=41
+		{in: "80000058", want: "ldr x0, 0x10"},
+		// i=42
+		{in: "c0035fd6", want: "ret"},
+		// i=43
+		{in: "010000d4", want: "svc #0"},
+		// i=44
+		{in: "bf3903d5", want: "dmb ishld"},
+		// i=45
+		{in: "df3f03d5", want: "isb"},
+		// i=46
+		{in: "40d03bd5", want: "mrs x0, tpidr_el0"},
+		// i=47
+		{in: "20421bd5", want: "msr daif, x0"},
+		// i=48
+		{in: "00f238d5", want: "mrs x0, s3_0_c15_c2_0"},
+		// i=49
+		{in: "e0ff9f12", want: "movn w0, #65535"},
+		// i=50
+		{in: "e03f40b2", want: "orr x0, xzr, #65535"},
+		// i=51
+		{in: "0000a052", want: "movz w0, #0, lsl #16"},
+		// i=52
+		{in: "200040fd", err: "arm64.Decode: invalid instruction word 0xFD400020"},
+		// i=53
+		{in: "400440a8", err: "arm64.Decode: invalid instruction word 0xA8400440"},
+		// i=54
+		{in: "800000d8", err: "arm64.Decode: invalid instruction word 0xD8000080"},
+		// i=55
+		{in: "2004bfa9", err: "arm64.Decode: invalid instruction word 0xA9BF0420"},
+		// i=56
+		{in: "d1a41792", err: "arm64.Decode: invalid instruction word 0x9217A4D1"},
+		// i=57
+		{in: "417ca0c8", err: "arm64.Decode: invalid instruction word 0xC8A07C41"},
+		// i=58
+		{in: "bf4100d5", err: "arm64.Decode: invalid instruction word 0xD50041BF"},
+		// i=59
+		{in: "00000000", err: "arm64.Decode: invalid instruction word 0x00000000"},
+		// i=60
+		{in: "1f20", err: "arm64.Decode: truncated instruction; expected 4 bytes, got 2"},
+	}
+
+	for i, g := range golden {
+		src, err := hex.DecodeString(g.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inst, n, err := Decoder.Decode(src, 0)
+		if len(g.err) > 0 {
+			if err == nil {
+				t.Errorf("i=%d: expected error %q, got nil", i, g.err)
+			} else if got := err.Error(); got != g.err {
+				t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.err, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if n != len(src) {
+			t.Errorf("i=%d: length mismatch; expected %d, got %d", i, len(src), n)
+		}
+		got, err := disasm.FormatNode(Decoder.Arch(), &inst)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if got != g.want {
+			t.Errorf("i=%d: instruction mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	golden := []string{
+		// i=0
+		`
+	org 0x10000
+main:
+	stp x29, x30, [sp, #-32]!
+	mov x29, sp
+	str x19, [sp, #16]
+	adrp x0, msg
+	add x0, x0, #0x10
+	bl puts
+	mov x19, #0x5678
+	movk x19, #0x1234, lsl #16
+	movk x19, #0x9ABC, lsl #32
+	ldr x1, =0xFFFF0000FFFF0000
+	and x2, x19, #0xFF00FF00FF00FF00
+	tst w2, #1
+	cset w0, ne
+	ldr x19, [sp, #16]
+	ldp x29, x30, [sp], #32
+	ret
+puts:
+	ldrb w1, [x0], #1
+	cbz w1, done
+	sub sp, sp, #16
+	strb w1, [sp, #15]
+	ldrsb x1, [sp, #15]
+	add sp, sp, #16
+	b puts
+done:
+	ret
+	pool
+msg:
+	db "hi", 0
+`,
+		// i=1
+		`
+	org 0x10000
+_start:
+	ldr w1, [x0, w2, sxtw #2]
+	ldr x1, [x0, x2, lsl #3]
+	ldrh w1, [x0, x2]
+	ldur x1, [x0, #-8]
+	ldr x1, [x0, #8]!
+	ldpsw x1, x2, [x0, #-8]
+	ldaxr x1, [x0]
+	stlxr w3, x1, [x0]
+	cbnz w3, _start
+	add x0, sp, w1, uxtw #2
+	cmp x0, w1, sxtb
+	neg x0, x1, lsl #3
+	lsl w0, w1, #5
+	asr x0, x1, x2
+	ubfiz x0, x1, #8, #4
+	sbfx w0, w1, #3, #7
+	extr x0, x1, x2, #12
+	madd x0, x1, x2, x3
+	umulh x0, x1, x2
+	csinv w0, w1, w2, vs
+	cneg x0, x1, pl
+	ccmn w0, w1, #8, hi
+	tbnz x0, #63, _start
+	b.le _start
+	dmb ish
+	mrs x0, cntvct_el0
+	svc #0
+`,
+	}
+
+	for i, src := range golden {
+		want, err := asmtest.Text(arm64asm.Encoder, src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		prog := disasm.Disassemble(Decoder, want, 0x10000)
+		out, err := disasm.Sprint(Decoder.Arch(), prog)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		got, err := asmtest.Text(arm64asm.Encoder, out)
+		if err != nil {
+			t.Errorf("i=%d: unable to assemble disassembly; %v\n%s", i, err, out)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("i=%d: round-trip mismatch; expected %x, got %x\n%s", i, want, got, out)
+		}
+	}
+}
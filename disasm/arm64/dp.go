@@ -0,0 +1,365 @@
+package arm64
+
+import (
+	arm64arch "github.com/mewlang/asm/arch/arm64"
+	"github.com/mewlang/asm/ast"
+)
+
+// Arithmetic instructions indexed by their op and S bits.
+var arithOps = [2][2]ast.Op{
+	{"add", "adds"},
+	{"sub", "subs"},
+}
+
+// Logical instructions indexed by their opc field and N bit.
+var logicalOps = [4][2]ast.Op{
+	{"and", "bic"},
+	{"orr", "orn"},
+	{"eor", "eon"},
+	{"ands", "bics"},
+}
+
+// Shift operators indexed by shift type.
+var shiftOps = []string{"lsl", "lsr", "asr", "ror"}
+
+// Extend operators indexed by the option field of extended register operands.
+var extendOps = []string{"uxtb", "uxth", "uxtw", "uxtx", "sxtb", "sxth", "sxtw", "sxtx"}
+
+// decodeDataImm decodes the data processing instruction word w with an
+// immediate operand, which is located at address pc.
+func decodeDataImm(w word, pc int64) (inst ast.Inst, ok bool) {
+	sf := w.sf()
+	rd, rn := w.rd(), w.rn()
+	switch w.field(23, 3) {
+	case 0, 1:
+		// PC-relative addressing.
+		off := sext(w.field(5, 19), 19)<<2 | int64(w.field(29, 2))
+		if sf == 0 {
+			return ast.Inst{Op: "adr", Args: []ast.Arg{reg(rd, 1), ast.Addr(pc + off)}}, true
+		}
+		return ast.Inst{Op: "adrp", Args: []ast.Arg{reg(rd, 1), ast.Addr(pc&^0xFFF + off<<12)}}, true
+	case 2:
+		// Add and subtract.
+		op, s, sh, imm12 := w.field(30, 1), w.field(29, 1), w.field(22, 1), int64(w.field(10, 12))
+		x := shiftedImm(imm12, 12*int64(sh))
+		switch {
+		case s == 1 && rd == 31:
+			return ast.Inst{Op: map[uint32]ast.Op{0: "cmn", 1: "cmp"}[op], Args: []ast.Arg{regSP(rn, sf), x}}, true
+		case op == 0 && s == 0 && sh == 0 && imm12 == 0 && (rd == 31 || rn == 31):
+			return ast.Inst{Op: "mov", Args: []ast.Arg{regSP(rd, sf), regSP(rn, sf)}}, true
+		}
+		rdReg := regSP(rd, sf)
+		if s == 1 {
+			rdReg = reg(rd, sf)
+		}
+		return ast.Inst{Op: arithOps[op][s], Args: []ast.Arg{rdReg, regSP(rn, sf), x}}, true
+	case 4:
+		// Logical with bitmask immediate.
+		opc, n := w.field(29, 2), w.field(22, 1)
+		width := 32 << sf
+		if sf == 0 && n == 1 {
+			return inst, false
+		}
+		// The bits of immr above the element size are ignored; only the
+		// canonical encoding of each value is accepted.
+		val, ok := arm64arch.DecodeLogicalImm(w.field(10, 13), width)
+		if enc, _ := arm64arch.EncodeLogicalImm(val, width); !ok || enc != w.field(10, 13) {
+			return inst, false
+		}
+		x := imm(int64(val))
+		switch {
+		case opc == 3 && rd == 31:
+			return ast.Inst{Op: "tst", Args: []ast.Arg{reg(rn, sf), x}}, true
+		case opc == 1 && rn == 31 && (rd == 31 || !isMoveWide(val, width)):
+			return ast.Inst{Op: "mov", Args: []ast.Arg{regSP(rd, sf), x}}, true
+		}
+		rdReg := regSP(rd, sf)
+		if opc == 3 {
+			rdReg = reg(rd, sf)
+		}
+		return ast.Inst{Op: logicalOps[opc][0], Args: []ast.Arg{rdReg, reg(rn, sf), x}}, true
+	case 5:
+		return decodeMoveWide(w)
+	case 6:
+		return decodeBitfield(w)
+	case 7:
+		// Extract.
+		imms := w.field(10, 6)
+		if w.field(29, 2) != 0 || w.field(21, 1) != 0 || w.field(22, 1) != sf || (sf == 0 && imms >= 32) {
+			return inst, false
+		}
+		if rn == w.rm() {
+			return ast.Inst{Op: "ror", Args: []ast.Arg{reg(rd, sf), reg(rn, sf), imm(int64(imms))}}, true
+		}
+		return ast.Inst{Op: "extr", Args: []ast.Arg{reg(rd, sf), reg(rn, sf), reg(w.rm(), sf), imm(int64(imms))}}, true
+	}
+	return inst, false
+}
+
+// isMoveWide returns true if the value of the given register width may be
+// moved into a register using movz or movn, and false otherwise.
+func isMoveWide(val uint64, width int) bool {
+	mask := ^uint64(0) >> uint(64-width)
+	for _, v := range []uint64{val & mask, ^val & mask} {
+		for hw := 0; hw < width/16; hw++ {
+			if v&^(0xFFFF<<uint(16*hw)) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeMoveWide decodes the move wide instruction word w. Like LLVM, movz and
+// movn are decoded as mov unless the immediate is 0 and shifted, or, for movn
+// of 32-bit registers, the immediate is 0xFFFF.
+func decodeMoveWide(w word) (inst ast.Inst, ok bool) {
+	sf, opc, hw := w.sf(), w.field(29, 2), w.field(21, 2)
+	if opc == 1 || (sf == 0 && hw >= 2) {
+		return inst, false
+	}
+	rd := reg(w.rd(), sf)
+	imm16, shift := int64(w.field(5, 16)), 16*hw
+	alias := !(imm16 == 0 && hw != 0)
+	switch {
+	case opc == 2 && alias:
+		val := uint64(imm16) << shift
+		return ast.Inst{Op: "mov", Args: []ast.Arg{rd, imm(int64(val))}}, true
+	case opc == 0 && alias && !(sf == 0 && imm16 == 0xFFFF):
+		val := int64(^(uint64(imm16) << shift))
+		if sf == 0 {
+			val = int64(int32(val))
+		}
+		return ast.Inst{Op: "mov", Args: []ast.Arg{rd, imm(val)}}, true
+	}
+	op := map[uint32]ast.Op{0: "movn", 2: "movz", 3: "movk"}[opc]
+	return ast.Inst{Op: op, Args: []ast.Arg{rd, shiftedImm(imm16, int64(shift))}}, true
+}
+
+// decodeBitfield decodes the bitfield instruction word w into its preferred
+// alias.
+func decodeBitfield(w word) (inst ast.Inst, ok bool) {
+	sf, opc, n := w.sf(), w.field(29, 2), w.field(22, 1)
+	immr, imms := int64(w.field(16, 6)), int64(w.field(10, 6))
+	if opc == 3 || n != sf || (sf == 0 && (immr >= 32 || imms >= 32)) {
+		return inst, false
+	}
+	size := int64(32) << sf
+	rd, rn := reg(w.rd(), sf), reg(w.rn(), sf)
+	switch {
+	case opc == 0 && immr == 0 && (imms == 7 || imms == 15 || (imms == 31 && sf == 1)):
+		op := map[int64]ast.Op{7: "sxtb", 15: "sxth", 31: "sxtw"}[imms]
+		return ast.Inst{Op: op, Args: []ast.Arg{rd, reg(w.rn(), 0)}}, true
+	case opc == 0 && imms == size-1:
+		return ast.Inst{Op: "asr", Args: []ast.Arg{rd, rn, imm(immr)}}, true
+	case opc == 2 && imms != size-1 && imms+1 == immr:
+		return ast.Inst{Op: "lsl", Args: []ast.Arg{rd, rn, imm(size - 1 - imms)}}, true
+	case opc == 2 && imms == size-1:
+		return ast.Inst{Op: "lsr", Args: []ast.Arg{rd, rn, imm(immr)}}, true
+	case opc == 2 && sf == 0 && immr == 0 && (imms == 7 || imms == 15):
+		op := map[int64]ast.Op{7: "uxtb", 15: "uxth"}[imms]
+		return ast.Inst{Op: op, Args: []ast.Arg{rd, rn}}, true
+	case imms < immr:
+		// Bitfield insert; the lsb is given by -immr modulo the register size.
+		op := [3]ast.Op{"sbfiz", "bfi", "ubfiz"}[opc]
+		return ast.Inst{Op: op, Args: []ast.Arg{rd, rn, imm(size - immr), imm(imms + 1)}}, true
+	}
+	// Bitfield extract.
+	op := [3]ast.Op{"sbfx", "bfxil", "ubfx"}[opc]
+	return ast.Inst{Op: op, Args: []ast.Arg{rd, rn, imm(immr), imm(imms - immr + 1)}}, true
+}
+
+// decodeDataReg decodes the data processing instruction word w with register
+// operands.
+func decodeDataReg(w word) (inst ast.Inst, ok bool) {
+	u, sf := uint32(w), w.sf()
+	rd, rn, rm := w.rd(), w.rn(), w.rm()
+	switch {
+	case u&0x1F000000 == 0x0A000000:
+		// Logical with shifted register.
+		opc, n := w.field(29, 2), w.field(21, 1)
+		typ, amount := w.field(22, 2), w.field(10, 6)
+		if sf == 0 && amount >= 32 {
+			return inst, false
+		}
+		op2 := shiftedReg(reg(rm, sf), typ, amount)
+		switch {
+		case opc == 1 && n == 0 && rn == 31 && typ == 0 && amount == 0:
+			return ast.Inst{Op: "mov", Args: []ast.Arg{reg(rd, sf), op2}}, true
+		case opc == 1 && n == 1 && rn == 31:
+			return ast.Inst{Op: "mvn", Args: []ast.Arg{reg(rd, sf), op2}}, true
+		case opc == 3 && n == 0 && rd == 31:
+			return ast.Inst{Op: "tst", Args: []ast.Arg{reg(rn, sf), op2}}, true
+		}
+		return ast.Inst{Op: logicalOps[opc][n], Args: []ast.Arg{reg(rd, sf), reg(rn, sf), op2}}, true
+	case u&0x1F200000 == 0x0B000000:
+		// Add and subtract with shifted register.
+		op, s := w.field(30, 1), w.field(29, 1)
+		typ, amount := w.field(22, 2), w.field(10, 6)
+		if typ == 3 || (sf == 0 && amount >= 32) {
+			return inst, false
+		}
+		op2 := shiftedReg(reg(rm, sf), typ, amount)
+		switch {
+		case s == 1 && rd == 31:
+			return ast.Inst{Op: map[uint32]ast.Op{0: "cmn", 1: "cmp"}[op], Args: []ast.Arg{reg(rn, sf), op2}}, true
+		case op == 1 && rn == 31:
+			return ast.Inst{Op: map[uint32]ast.Op{0: "neg", 1: "negs"}[s], Args: []ast.Arg{reg(rd, sf), op2}}, true
+		}
+		return ast.Inst{Op: arithOps[op][s], Args: []ast.Arg{reg(rd, sf), reg(rn, sf), op2}}, true
+	case u&0x1F200000 == 0x0B200000:
+		return decodeArithExt(w)
+	case u&0x1FE00000 == 0x1A000000:
+		// Add and subtract with carry.
+		if w.field(10, 6) != 0 {
+			return inst, false
+		}
+		op, s := w.field(30, 1), w.field(29, 1)
+		if op == 1 && rn == 31 {
+			return ast.Inst{Op: map[uint32]ast.Op{0: "ngc", 1: "ngcs"}[s], Args: []ast.Arg{reg(rd, sf), reg(rm, sf)}}, true
+		}
+		ops := [2][2]ast.Op{{"adc", "adcs"}, {"sbc", "sbcs"}}
+		return ast.Inst{Op: ops[op][s], Args: []ast.Arg{reg(rd, sf), reg(rn, sf), reg(rm, sf)}}, true
+	case u&0x1FE00000 == 0x1A400000:
+		// Conditional compare.
+		if w.field(29, 1) != 1 || w.field(10, 1) != 0 || w.field(4, 1) != 0 {
+			return inst, false
+		}
+		op := map[uint32]ast.Op{0: "ccmn", 1: "ccmp"}[w.field(30, 1)]
+		var op2 ast.Arg = reg(rm, sf)
+		if w.field(11, 1) == 1 {
+			op2 = imm(int64(rm))
+		}
+		cond := ast.Ident(arm64arch.Cond(w.field(12, 4)).String())
+		return ast.Inst{Op: op, Args: []ast.Arg{reg(rn, sf), op2, imm(int64(w.field(0, 4))), cond}}, true
+	case u&0x1FE00000 == 0x1A800000:
+		return decodeCondSelect(w)
+	case u&0x7FE00000 == 0x1AC00000:
+		// Data processing with two source registers.
+		op := map[uint32]ast.Op{2: "udiv", 3: "sdiv", 8: "lsl", 9: "lsr", 10: "asr", 11: "ror"}[w.field(10, 6)]
+		return ast.Inst{Op: op, Args: []ast.Arg{reg(rd, sf), reg(rn, sf), reg(rm, sf)}}, op != ""
+	case u&0x7FE00000 == 0x5AC00000:
+		// Data processing with one source register.
+		if rm != 0 {
+			return inst, false
+		}
+		ops := map[uint32]ast.Op{0: "rbit", 1: "rev16", 2: "rev", 4: "clz", 5: "cls"}
+		if sf == 1 {
+			ops[2], ops[3] = "rev32", "rev"
+		}
+		op := ops[w.field(10, 6)]
+		return ast.Inst{Op: op, Args: []ast.Arg{reg(rd, sf), reg(rn, sf)}}, op != ""
+	case u&0x1F000000 == 0x1B000000:
+		return decodeMultiply(w)
+	}
+	return inst, false
+}
+
+// shiftedReg returns the register operand x, which is shifted by the given
+// shift type and amount unless it is shifted left by 0.
+func shiftedReg(x ast.Reg, typ, amount uint32) ast.Arg {
+	if typ == 0 && amount == 0 {
+		return x
+	}
+	return &ast.ShiftedReg{X: x, Op: shiftOps[typ], Amount: ast.Int(amount)}
+}
+
+// decodeArithExt decodes the add or subtract instruction word w with an
+// extended register operand. Like LLVM, the extend operator is written as lsl
+// if it is uxtx (uxtw of 32-bit registers) and the stack pointer is used.
+func decodeArithExt(w word) (inst ast.Inst, ok bool) {
+	sf, op, s := w.sf(), w.field(30, 1), w.field(29, 1)
+	option, amount := w.field(13, 3), int64(w.field(10, 3))
+	if w.field(22, 2) != 0 || amount > 4 {
+		return inst, false
+	}
+	rd, rn := w.rd(), w.rn()
+	// The uxtx and sxtx extensions of 64-bit instructions use a 64-bit
+	// register, and the other extensions a 32-bit register.
+	var rmSF uint32
+	if sf == 1 && option&3 == 3 {
+		rmSF = 1
+	}
+	rm := reg(w.rm(), rmSF)
+	var op2 ast.Arg = &ast.ShiftedReg{X: rm, Op: extendOps[option]}
+	if ((rd == 31 && s == 0) || rn == 31) && option == 2|sf {
+		op2 = shiftedReg(rm, 0, uint32(amount))
+	} else if amount != 0 {
+		op2.(*ast.ShiftedReg).Amount = ast.Int(amount)
+	}
+	if s == 1 && rd == 31 {
+		return ast.Inst{Op: map[uint32]ast.Op{0: "cmn", 1: "cmp"}[op], Args: []ast.Arg{regSP(rn, sf), op2}}, true
+	}
+	rdReg := regSP(rd, sf)
+	if s == 1 {
+		rdReg = reg(rd, sf)
+	}
+	return ast.Inst{Op: arithOps[op][s], Args: []ast.Arg{rdReg, regSP(rn, sf), op2}}, true
+}
+
+// decodeCondSelect decodes the conditional select instruction word w into its
+// preferred alias. The condition of the aliases is inverted.
+func decodeCondSelect(w word) (inst ast.Inst, ok bool) {
+	sf, op, op2 := w.sf(), w.field(30, 1), w.field(10, 2)
+	if w.field(29, 1) != 0 || op2 >= 2 {
+		return inst, false
+	}
+	rd, rn, rm := reg(w.rd(), sf), w.rn(), w.rm()
+	cond := arm64arch.Cond(w.field(12, 4))
+	if (op == 1 || op2 == 1) && rn == rm && cond < arm64arch.AL {
+		inv := ast.Ident(cond.Invert().String())
+		switch {
+		case rn == 31 && op2 == 1 && op == 0:
+			return ast.Inst{Op: "cset", Args: []ast.Arg{rd, inv}}, true
+		case rn == 31 && op2 == 0:
+			return ast.Inst{Op: "csetm", Args: []ast.Arg{rd, inv}}, true
+		}
+		ops := [2][2]ast.Op{{"", "cinc"}, {"cinv", "cneg"}}
+		return ast.Inst{Op: ops[op][op2], Args: []ast.Arg{rd, reg(rn, sf), inv}}, true
+	}
+	ops := [2][2]ast.Op{{"csel", "csinc"}, {"csinv", "csneg"}}
+	return ast.Inst{Op: ops[op][op2], Args: []ast.Arg{rd, reg(rn, sf), reg(rm, sf), ast.Ident(cond.String())}}, true
+}
+
+// Multiply instructions indexed by their op31 field and o0 bit, and whether the
+// addend is the zero register.
+var multiplyOps = map[[3]uint32]ast.Op{
+	{0, 0, 0}: "madd",
+	{0, 1, 0}: "msub",
+	{0, 0, 1}: "mul",
+	{0, 1, 1}: "mneg",
+	{1, 0, 0}: "smaddl",
+	{1, 1, 0}: "smsubl",
+	{1, 0, 1}: "smull",
+	{1, 1, 1}: "smnegl",
+	{2, 0, 1}: "smulh",
+	{5, 0, 0}: "umaddl",
+	{5, 1, 0}: "umsubl",
+	{5, 0, 1}: "umull",
+	{5, 1, 1}: "umnegl",
+	{6, 0, 1}: "umulh",
+}
+
+// decodeMultiply decodes the data processing instruction word w with three
+// source registers.
+func decodeMultiply(w word) (inst ast.Inst, ok bool) {
+	sf, op31, o0, ra := w.sf(), w.field(21, 3), w.field(15, 1), w.ra()
+	var noAddend uint32
+	if ra == 31 {
+		noAddend = 1
+	}
+	op, ok := multiplyOps[[3]uint32{op31, o0, noAddend}]
+	if !ok || w.field(29, 2) != 0 || (op31 != 0 && sf == 0) {
+		return inst, false
+	}
+	// The long multiplies multiply 32-bit registers into a 64-bit register.
+	srcSF := sf
+	if op31 == 1 || op31 == 5 {
+		srcSF = 0
+	}
+	args := []ast.Arg{reg(w.rd(), sf), reg(w.rn(), srcSF), reg(w.rm(), srcSF)}
+	if noAddend == 0 {
+		args = append(args, reg(ra, sf))
+	}
+	return ast.Inst{Op: op, Args: args}, true
+}
@@ -0,0 +1,35 @@
+package arm64_test
+
+import (
+	"log"
+	"os"
+
+	"github.com/mewlang/asm/disasm"
+	"github.com/mewlang/asm/disasm/arm64"
+)
+
+func ExampleDecoder() {
+	src := []byte{
+		0xFD, 0x7B, 0xBF, 0xA9, // stp x29, x30, [sp, #-16]!
+		0xFD, 0x03, 0x00, 0x91, // mov x29, sp
+		0x00, 0x04, 0x00, 0xF1, // loop: subs x0, x0, #1
+		0xE1, 0xFF, 0xFF, 0x54, // b.ne loop
+		0xFD, 0x7B, 0xC1, 0xA8, // ldp x29, x30, [sp], #16
+		0xC0, 0x03, 0x5F, 0xD6, // ret
+		0x00, 0x00, 0x00, 0x00, // udf #0
+	}
+	prog := disasm.Disassemble(arm64.Decoder, src, 0x10000)
+	if err := disasm.Fprint(os.Stdout, arm64.Decoder.Arch(), prog); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	//	org 0x10000
+	//	stp x29, x30, [sp, #-16]! ; 00010000: FD7BBFA9
+	//	mov x29, sp               ; 00010004: FD030091
+	// loc_00010008:
+	//	subs x0, x0, #1         ; 00010008: 000400F1
+	//	b.ne loc_00010008       ; 0001000C: E1FFFF54
+	//	ldp x29, x30, [sp], #16 ; 00010010: FD7BC1A8
+	//	ret                     ; 00010014: C0035FD6
+	//	db 0, 0, 0, 0           ; 00010018: arm64.Decode: invalid instruction word 0x00000000
+}
@@ -0,0 +1,199 @@
+package arm64
+
+import (
+	"github.com/mewlang/asm/ast"
+)
+
+// mem returns the memory operand with the given base register number and
+// offset; such as [x0, #8], or [x0] if the offset is 0.
+func mem(rn uint32, off int64) *ast.Mem {
+	m := &ast.Mem{Base: regSP(rn, 1)}
+	if off != 0 {
+		m.Disp = ast.Int(off)
+	}
+	return m
+}
+
+// loadStoreOp returns the mnemonic of the load or store instruction with the
+// given size and opc fields, and the sf bit of its transferred register.
+func loadStoreOp(size, opc uint32) (op ast.Op, sf uint32, ok bool) {
+	if size == 3 {
+		sf = 1
+	}
+	switch opc {
+	case 0:
+		return [4]ast.Op{"strb", "strh", "str", "str"}[size], sf, true
+	case 1:
+		return [4]ast.Op{"ldrb", "ldrh", "ldr", "ldr"}[size], sf, true
+	case 2:
+		// Sign-extending loads into 64-bit registers.
+		return [4]ast.Op{"ldrsb", "ldrsh", "ldrsw", ""}[size], 1, size != 3
+	}
+	// Sign-extending loads into 32-bit registers.
+	return [4]ast.Op{"ldrsb", "ldrsh", "", ""}[size], 0, size < 2
+}
+
+// unscaled returns the mnemonic of the load or store instruction op with an
+// unscaled offset; e.g. ldur for ldr and ldursb for ldrsb.
+func unscaled(op ast.Op) ast.Op {
+	return op[:2] + "ur" + op[3:]
+}
+
+// decodeLoadStore decodes the load and store instruction word w, which is
+// located at address pc.
+func decodeLoadStore(w word, pc int64) (inst ast.Inst, ok bool) {
+	u := uint32(w)
+	if w.field(26, 1) == 1 {
+		// Floating-point and SIMD registers.
+		return inst, false
+	}
+	rt, rn := w.rd(), w.rn()
+	switch {
+	case u&0x3F000000 == 0x08000000:
+		return decodeExclusive(w)
+	case u&0x3B000000 == 0x18000000:
+		// Load relative to the program counter.
+		opc := w.field(30, 2)
+		op, sf := ast.Op("ldr"), opc
+		if opc == 2 {
+			op, sf = "ldrsw", 1
+		}
+		addr := ast.Addr(pc + sext(w.field(5, 19), 19)<<2)
+		return ast.Inst{Op: op, Args: []ast.Arg{reg(rt, sf), addr}}, opc != 3
+	case u&0x3A000000 == 0x28000000:
+		return decodePair(w)
+	case u&0x3B000000 == 0x39000000:
+		// Unsigned offset, scaled by the access size.
+		size := w.field(30, 2)
+		op, sf, ok := loadStoreOp(size, w.field(22, 2))
+		off := int64(w.field(10, 12)) << size
+		return ast.Inst{Op: op, Args: []ast.Arg{reg(rt, sf), mem(rn, off)}}, ok
+	case u&0x3B200000 == 0x38000000:
+		// Unscaled, pre-indexed and post-indexed offset.
+		op, sf, ok := loadStoreOp(w.field(30, 2), w.field(22, 2))
+		if !ok {
+			return inst, false
+		}
+		off := sext(w.field(12, 9), 9)
+		idx := w.field(10, 2)
+		if idx != 0 && rn == rt && rn != 31 {
+			// Unpredictable writeback of the transferred register.
+			return inst, false
+		}
+		switch idx {
+		case 0:
+			return ast.Inst{Op: unscaled(op), Args: []ast.Arg{reg(rt, sf), mem(rn, off)}}, true
+		case 1:
+			return ast.Inst{Op: op, Args: []ast.Arg{reg(rt, sf), mem(rn, 0), imm(off)}}, true
+		case 3:
+			return ast.Inst{Op: op, Args: []ast.Arg{reg(rt, sf), &ast.Writeback{X: mem(rn, off)}}}, true
+		}
+	case u&0x3B200C00 == 0x38200800:
+		// Register offset; the index register is optionally extended and
+		// shifted by log2 of the access size.
+		size := w.field(30, 2)
+		op, sf, ok := loadStoreOp(size, w.field(22, 2))
+		option, s := w.field(13, 3), w.field(12, 1)
+		if !ok || option&2 == 0 {
+			return inst, false
+		}
+		m := &ast.Mem{Base: regSP(rn, 1), Index: reg(w.rm(), option&1)}
+		switch {
+		case option == 3 && s == 0:
+			m.Scale = 1
+		case option == 3:
+			m.Extend, m.Scale = "lsl", 1<<size
+		default:
+			m.Extend = extendOps[option]
+			if s == 1 {
+				m.Scale = 1 << size
+			}
+		}
+		return ast.Inst{Op: op, Args: []ast.Arg{reg(rt, sf), m}}, true
+	}
+	return inst, false
+}
+
+// decodePair decodes the load or store pair instruction word w.
+func decodePair(w word) (inst ast.Inst, ok bool) {
+	opc, idx, l := w.field(30, 2), w.field(23, 2), w.field(22, 1)
+	rt, rt2, rn := w.rd(), w.ra(), w.rn()
+	op := [2]ast.Op{"stp", "ldp"}[l]
+	sf, scale := uint32(0), int64(4)
+	switch {
+	case opc == 1 && l == 1:
+		op, sf = "ldpsw", 1
+	case opc == 2:
+		sf, scale = 1, 8
+	case opc != 0:
+		return inst, false
+	}
+	if idx != 2 && rn != 31 && (rn == rt || rn == rt2) {
+		// Unpredictable writeback of a transferred register.
+		return inst, false
+	}
+	off := sext(w.field(15, 7), 7) * scale
+	args := []ast.Arg{reg(rt, sf), reg(rt2, sf)}
+	switch idx {
+	case 1:
+		args = append(args, mem(rn, 0), imm(off))
+	case 2:
+		args = append(args, mem(rn, off))
+	case 3:
+		args = append(args, &ast.Writeback{X: mem(rn, off)})
+	default:
+		// Non-temporal hints.
+		return inst, false
+	}
+	return ast.Inst{Op: op, Args: args}, true
+}
+
+// Exclusive and acquire-release load and store instructions indexed by their
+// o2, L, o1 and o0 fields.
+var exclusiveOps = map[[4]uint32]ast.Op{
+	{0, 0, 0, 0}: "stxr",
+	{0, 0, 0, 1}: "stlxr",
+	{0, 1, 0, 0}: "ldxr",
+	{0, 1, 0, 1}: "ldaxr",
+	{1, 0, 0, 1}: "stlr",
+	{1, 1, 0, 1}: "ldar",
+	{0, 0, 1, 0}: "stxp",
+	{0, 0, 1, 1}: "stlxp",
+	{0, 1, 1, 0}: "ldxp",
+	{0, 1, 1, 1}: "ldaxp",
+}
+
+// decodeExclusive decodes the exclusive or acquire-release load or store
+// instruction word w. The Rs and Rt2 fields of instructions without a status
+// register and without a second transferred register must be 31.
+func decodeExclusive(w word) (inst ast.Inst, ok bool) {
+	size, o2, l, o1, o0 := w.field(30, 2), w.field(23, 1), w.field(22, 1), w.field(21, 1), w.field(15, 1)
+	op, ok := exclusiveOps[[4]uint32{o2, l, o1, o0}]
+	if !ok {
+		return inst, false
+	}
+	var sf uint32
+	switch size {
+	case 0, 1:
+		if o1 == 1 {
+			return inst, false
+		}
+		op += [2]ast.Op{"b", "h"}[size]
+	default:
+		sf = size - 2
+	}
+	var args []ast.Arg
+	if o2 == 0 && l == 0 {
+		args = append(args, reg(w.rm(), 0))
+	} else if w.rm() != 31 {
+		return inst, false
+	}
+	args = append(args, reg(w.rd(), sf))
+	if o1 == 1 {
+		args = append(args, reg(w.ra(), sf))
+	} else if w.ra() != 31 {
+		return inst, false
+	}
+	args = append(args, mem(w.rn(), 0))
+	return ast.Inst{Op: op, Args: args}, true
+}
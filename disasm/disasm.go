@@ -0,0 +1,171 @@
+// Package disasm implements the disassembly of machine code into assembly
+// programs.
+//
+// The architecture specific decoding of instructions is delegated to a
+// Decoder, while the disassembler sweeps linearly through the machine code,
+// labels branch targets and prints the resulting program using the syntax
+// accepted by the lexer and parser.
+package disasm
+
+import (
+	"fmt"
+	"unicode"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/ast"
+)
+
+// A Decoder decodes the machine code of an architecture into instructions.
+type Decoder interface {
+	// Arch returns the architecture of the instructions.
+	Arch() arch.Arch
+	// Decode decodes the first instruction of src, which is located at address
+	// pc, and returns the instruction and its length in bytes. Branch and jump
+	// targets are represented by ast.Addr operands.
+	//
+	// On error, the returned length specifies the number of bytes to skip
+	// before decoding the next instruction.
+	Decode(src []byte, pc int64) (inst ast.Inst, n int, err error)
+}
+
+// A Config specifies the labels of disassembled machine code.
+type Config struct {
+	// Labels maps from address to label name; such as the names of the symbols
+	// of an object file. Instructions located at the addresses are given the
+	// labels, which are used in place of the generated labels of branch and
+	// jump targets.
+	Labels map[int64]string
+	// Symbols maps from address to the name of symbols located outside of the
+	// disassembled machine code; such as the labels of other sections of an
+	// object file. Branch and jump targets and instruction relative memory
+	// operands located at the addresses are replaced by the names.
+	Symbols map[int64]string
+}
+
+// Disassemble decodes the machine code of src, which is located at address
+// addr, into an assembly program. Branch and jump targets located at the
+// start of a decoded instruction are given labels. Bytes which cannot be
+// decoded are represented by data directives.
+func Disassemble(dec Decoder, src []byte, addr int64) *ast.Program {
+	conf := &Config{}
+	return conf.Disassemble(dec, src, addr)
+}
+
+// Disassemble decodes the machine code of src, which is located at address
+// addr, into an assembly program; as labelled by conf. Labels located at
+// addresses other than the start of a decoded instruction are ignored, as are
+// label names which are not identifiers of non-local labels.
+func (conf *Config) Disassemble(dec Decoder, src []byte, addr int64) *ast.Program {
+	// Sweep linearly through the machine code.
+	type entry struct {
+		addr int64
+		inst *ast.Inst
+		buf  []byte
+		err  error
+	}
+	var entries []*entry
+	starts := make(map[int64]bool)
+	for off := 0; off < len(src); {
+		pc := addr + int64(off)
+		inst, n, err := dec.Decode(src[off:], pc)
+		if n <= 0 || off+n > len(src) {
+			n = len(src) - off
+		}
+		e := &entry{addr: pc, buf: src[off : off+n], err: err}
+		if err == nil {
+			e.inst = &inst
+			starts[pc] = true
+		}
+		entries = append(entries, e)
+		off += n
+	}
+
+	// Locate branch and jump targets.
+	labels := make(map[int64]string)
+	for pc, name := range conf.Labels {
+		if starts[pc] && isIdent(name) {
+			labels[pc] = name
+		}
+	}
+	for _, e := range entries {
+		if e.inst == nil {
+			continue
+		}
+		for i, arg := range e.inst.Args {
+			// Addresses of instruction relative memory operands.
+			if mem, ok := arg.(*ast.Mem); ok && mem.Rel {
+				arg = mem.Disp
+			}
+			target, ok := arg.(ast.Addr)
+			if !ok {
+				continue
+			}
+			name, ok := labels[int64(target)]
+			switch {
+			case ok:
+			case starts[int64(target)]:
+				name = label(int64(target))
+				labels[int64(target)] = name
+			case int64(target) >= addr && int64(target) < addr+int64(len(src)):
+				// Not the start of a decoded instruction.
+				continue
+			default:
+				name, ok = conf.Symbols[int64(target)]
+				if !ok || !isIdent(name) {
+					continue
+				}
+			}
+			if mem, ok := e.inst.Args[i].(*ast.Mem); ok {
+				mem.Disp = ast.Ident(name)
+				continue
+			}
+			e.inst.Args[i] = ast.Ident(name)
+		}
+	}
+
+	// Create the lines of the program.
+	prog := new(ast.Program)
+	if addr != 0 {
+		prog.Lines = append(prog.Lines, &ast.Line{Node: &ast.OrgDir{Addr: ast.Addr(addr)}})
+	}
+	for _, e := range entries {
+		line := &ast.Line{
+			Comment: fmt.Sprintf("; %08X: %X", e.addr, e.buf),
+		}
+		if name, ok := labels[e.addr]; ok {
+			line.Label = &ast.LabelDecl{Name: name}
+		}
+		if e.inst != nil {
+			line.Node = e.inst
+		} else {
+			data := &ast.DataDir{Width: 1}
+			for _, b := range e.buf {
+				data.Vals = append(data.Vals, ast.Int(b))
+			}
+			line.Node = data
+			line.Comment = fmt.Sprintf("; %08X: %v", e.addr, e.err)
+		}
+		prog.Lines = append(prog.Lines, line)
+	}
+	return prog
+}
+
+// isIdent returns true if s is an identifier, and false otherwise. Identifiers
+// are a letter or an underscore followed by any number of letters, digits,
+// underscores and dots.
+func isIdent(s string) bool {
+	for i, r := range s {
+		switch {
+		case unicode.IsLetter(r), r == '_':
+		case i > 0 && (unicode.IsDigit(r) || r == '.'):
+		default:
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// label returns the label name of the given address.
+func label(addr int64) string {
+	return fmt.Sprintf("loc_%08X", addr)
+}
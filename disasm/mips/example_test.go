@@ -0,0 +1,32 @@
+package mips_test
+
+import (
+	"log"
+	"os"
+
+	mipsarch "github.com/mewlang/asm/arch/mips"
+	"github.com/mewlang/asm/disasm"
+	"github.com/mewlang/asm/disasm/mips"
+)
+
+func ExampleDecoder() {
+	src := []byte{
+		0x21, 0x08, 0xFF, 0xFF, // addi $t0, $t0, -1
+		0x15, 0x00, 0xFF, 0xFE, // bne $t0, $zero, loop
+		0x00, 0x00, 0x00, 0x00, // nop
+		0x03, 0xE0, 0x00, 0x08, // jr $ra
+		0xFF, 0xFF, // truncated
+	}
+	prog := disasm.Disassemble(mips.Decoder, src, 0x400000)
+	if err := disasm.Fprint(os.Stdout, mipsarch.Arch, prog); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	//	org 0x400000
+	// loc_00400000:
+	//	addi $t0, $t0, -1            ; 00400000: 2108FFFF
+	//	bne $t0, $zero, loc_00400000 ; 00400004: 1500FFFE
+	//	nop                          ; 00400008: 00000000
+	//	jr $ra                       ; 0040000C: 03E00008
+	//	db 255, 255                  ; 00400010: mips.Decode: truncated instruction; expected 4 bytes, got 2
+}
@@ -0,0 +1,205 @@
+// Package mips implements a MIPS32 instruction decoder.
+//
+// The decoder is the inverse of the asm/mips encoder; every decoded instruction
+// encodes back into the same machine word. Instruction words with non-zero
+// fields which are required to be zero are reported as invalid.
+package mips
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mewlang/asm/arch"
+	mipsarch "github.com/mewlang/asm/arch/mips"
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/disasm"
+)
+
+// Decoder is the MIPS32 instruction decoder.
+var Decoder disasm.Decoder = decoder{}
+
+// decoder implements the disasm.Decoder interface for MIPS32.
+type decoder struct{}
+
+// Arch returns the MIPS32 architecture.
+func (decoder) Arch() arch.Arch {
+	return mipsarch.Arch
+}
+
+// Decode decodes the first big-endian instruction word of src, which is located
+// at address pc. Branch and jump targets are represented by ast.Addr operands.
+func (decoder) Decode(src []byte, pc int64) (inst ast.Inst, n int, err error) {
+	return decode(src, pc)
+}
+
+// Decode decodes the first big-endian instruction word of src and returns the
+// instruction and its length in bytes. Branch and jump targets are relative to
+// address 0; use Decoder to decode instructions located at other addresses.
+func Decode(src []byte) (inst ast.Inst, n int, err error) {
+	return decode(src, 0)
+}
+
+// Mnemonics of R format instructions indexed by function code.
+var functOps = [64]ast.Op{
+	0x00: "sll",
+	0x02: "srl",
+	0x03: "sra",
+	0x04: "sllv",
+	0x06: "srlv",
+	0x07: "srav",
+	0x08: "jr",
+	0x09: "jalr",
+	0x0C: "syscall",
+	0x0D: "break",
+	0x10: "mfhi",
+	0x11: "mthi",
+	0x12: "mflo",
+	0x13: "mtlo",
+	0x18: "mult",
+	0x19: "multu",
+	0x1A: "div",
+	0x1B: "divu",
+	0x20: "add",
+	0x21: "addu",
+	0x22: "sub",
+	0x23: "subu",
+	0x24: "and",
+	0x25: "or",
+	0x26: "xor",
+	0x27: "nor",
+	0x2A: "slt",
+	0x2B: "sltu",
+}
+
+// Mnemonics of I and J format instructions indexed by opcode.
+var opcodeOps = [64]ast.Op{
+	0x02: "j",
+	0x03: "jal",
+	0x04: "beq",
+	0x05: "bne",
+	0x06: "blez",
+	0x07: "bgtz",
+	0x08: "addi",
+	0x09: "addiu",
+	0x0A: "slti",
+	0x0B: "sltiu",
+	0x0C: "andi",
+	0x0D: "ori",
+	0x0E: "xori",
+	0x0F: "lui",
+	0x20: "lb",
+	0x21: "lh",
+	0x23: "lw",
+	0x24: "lbu",
+	0x25: "lhu",
+	0x28: "sb",
+	0x29: "sh",
+	0x2B: "sw",
+}
+
+// Mnemonics of REGIMM branch instructions indexed by the rt field.
+var regImmOps = [32]ast.Op{
+	0x00: "bltz",
+	0x01: "bgez",
+}
+
+// An instruction word.
+type word uint32
+
+// Fields of instruction words.
+func (w word) opcode() uint32 { return uint32(w) >> 26 }
+func (w word) rs() ast.Reg    { return ast.Reg(w >> 21 & 0x1F) }
+func (w word) rt() ast.Reg    { return ast.Reg(w >> 16 & 0x1F) }
+func (w word) rd() ast.Reg    { return ast.Reg(w >> 11 & 0x1F) }
+func (w word) shamt() uint32  { return uint32(w) >> 6 & 0x1F }
+func (w word) funct() uint32  { return uint32(w) & 0x3F }
+func (w word) uimm() ast.Int  { return ast.Int(uint16(w)) }
+func (w word) simm() ast.Int  { return ast.Int(int16(w)) }
+
+// decode decodes the first instruction word of src, which is located at
+// address pc.
+func decode(src []byte, pc int64) (inst ast.Inst, n int, err error) {
+	if len(src) < 4 {
+		return inst, len(src), fmt.Errorf("mips.Decode: truncated instruction; expected 4 bytes, got %d", len(src))
+	}
+	w := word(binary.BigEndian.Uint32(src))
+	if w == 0 {
+		return ast.Inst{Op: "nop"}, 4, nil
+	}
+	inst, ok := decodeWord(w, pc)
+	if !ok {
+		return inst, 4, fmt.Errorf("mips.Decode: invalid instruction word 0x%08X", uint32(w))
+	}
+	return inst, 4, nil
+}
+
+// decodeWord decodes the instruction word w, which is located at address pc.
+// It returns false if w is not a valid instruction word.
+func decodeWord(w word, pc int64) (inst ast.Inst, ok bool) {
+	// zero returns true if the given fields are zero, and false otherwise.
+	zero := func(fields ...uint32) bool {
+		for _, field := range fields {
+			if field != 0 {
+				return false
+			}
+		}
+		return true
+	}
+	rs, rt, rd := uint32(w.rs()), uint32(w.rt()), uint32(w.rd())
+	// Branch target relative to the address of the delay slot.
+	branch := ast.Addr(pc + 4 + int64(w.simm())<<2)
+
+	switch opcode := w.opcode(); opcode {
+	case 0x00:
+		op := functOps[w.funct()]
+		switch op {
+		case "":
+			return inst, false
+		case "sll", "srl", "sra":
+			return ast.Inst{Op: op, Args: []ast.Arg{w.rd(), w.rt(), ast.Int(w.shamt())}}, zero(rs)
+		case "sllv", "srlv", "srav":
+			return ast.Inst{Op: op, Args: []ast.Arg{w.rd(), w.rt(), w.rs()}}, zero(w.shamt())
+		case "jr", "mthi", "mtlo":
+			return ast.Inst{Op: op, Args: []ast.Arg{w.rs()}}, zero(rt, rd, w.shamt())
+		case "jalr":
+			if w.rd() == mipsarch.RA {
+				return ast.Inst{Op: op, Args: []ast.Arg{w.rs()}}, zero(rt, w.shamt())
+			}
+			return ast.Inst{Op: op, Args: []ast.Arg{w.rd(), w.rs()}}, zero(rt, w.shamt())
+		case "syscall", "break":
+			return ast.Inst{Op: op}, zero(rs, rt, rd, w.shamt())
+		case "mfhi", "mflo":
+			return ast.Inst{Op: op, Args: []ast.Arg{w.rd()}}, zero(rs, rt, w.shamt())
+		case "mult", "multu", "div", "divu":
+			return ast.Inst{Op: op, Args: []ast.Arg{w.rs(), w.rt()}}, zero(rd, w.shamt())
+		default:
+			return ast.Inst{Op: op, Args: []ast.Arg{w.rd(), w.rs(), w.rt()}}, zero(w.shamt())
+		}
+	case 0x01:
+		op := regImmOps[rt]
+		return ast.Inst{Op: op, Args: []ast.Arg{w.rs(), branch}}, op != ""
+	default:
+		op := opcodeOps[opcode]
+		switch op {
+		case "":
+			return inst, false
+		case "j", "jal":
+			target := ast.Addr((pc+4)&^0xFFFFFFF | int64(w&0x3FFFFFF)<<2)
+			return ast.Inst{Op: op, Args: []ast.Arg{target}}, true
+		case "beq", "bne":
+			return ast.Inst{Op: op, Args: []ast.Arg{w.rs(), w.rt(), branch}}, true
+		case "blez", "bgtz":
+			return ast.Inst{Op: op, Args: []ast.Arg{w.rs(), branch}}, zero(rt)
+		case "addi", "addiu", "slti", "sltiu":
+			return ast.Inst{Op: op, Args: []ast.Arg{w.rt(), w.rs(), w.simm()}}, true
+		case "lui":
+			return ast.Inst{Op: op, Args: []ast.Arg{w.rt(), w.uimm()}}, zero(rs)
+		case "lb", "lh", "lw", "lbu", "lhu", "sb", "sh", "sw":
+			mem := &ast.Mem{Base: w.rs(), Disp: w.simm()}
+			return ast.Inst{Op: op, Args: []ast.Arg{w.rt(), mem}}, true
+		default:
+			// andi, ori and xori.
+			return ast.Inst{Op: op, Args: []ast.Arg{w.rt(), w.rs(), w.uimm()}}, true
+		}
+	}
+}
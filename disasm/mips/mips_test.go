@@ -0,0 +1,147 @@
+package mips
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	mipsarch "github.com/mewlang/asm/arch/mips"
+	mipsasm "github.com/mewlang/asm/asm/mips"
+	"github.com/mewlang/asm/disasm"
+	"github.com/mewlang/asm/internal/asmtest"
+)
+
+func TestDecode(t *testing.T) {
+	golden := []struct {
+		in   string
+		want string
+		err  string
+	}{
+		// i=0
+		{in: "014b4820", want: "add $t1, $t2, $t3"},
+		// i=1
+		{in: "27bdffe0", want: "addiu $sp, $sp, -32"},
+		// i=2
+		{in: "3128ffff", want: "andi $t0, $t1, 65535"},
+		// i=3
+		{in: "3c081234", want: "lui $t0, 4660"},
+		// i=4
+		{in: "000947c2", want: "srl $t0, $t1, 31"},
+		// i=5
+		{in: "016a4804", want: "sllv $t1, $t2, $t3"},
+		// i=6
+		{in: "012a001a", want: "div $t1, $t2"},
+		// i=7
+		{in: "00004810", want: "mfhi $t1"},
+		// i=8
+		{in: "01200013", want: "mtlo $t1"},
+		// i=9
+		{in: "0320f809", want: "jalr $t9"},
+		// i=10
+		{in: "03204809", want: "jalr $t1, $t9"},
+		// i=11
+		{in: "0000000c", want: "syscall"},
+		// i=12
+		{in: "00000000", want: "nop"},
+		// i=13
+		{in: "1500fffe", want: "bne $t0, $zero, 0xFFFFFFFFFFFFFFFC"},
+		// i=14
+		{in: "11090007", want: "beq $t0, $t1, 0x20"},
+		// i=15
+		{in: "05010005", want: "bgez $t0, 0x18"},
+		// i=16
+		{in: "0c10000a", want: "jal 0x400028"},
+		// i=17
+		{in: "8fa80008", want: "lw $t0, 8($sp)"},
+		// i=18
+		{in: "a629fffe", want: "sh $t1, -2($s1)"},
+		// i=19
+		{in: "0000000", err: "mips.Decode: truncated instruction; expected 4 bytes, got 3"},
+		// i=20
+		{in: "fc000000", err: "mips.Decode: invalid instruction word 0xFC000000"},
+		// i=21
+		{in: "00000001", err: "mips.Decode: invalid instruction word 0x00000001"},
+		// i=22
+		{in: "0000004d", err: "mips.Decode: invalid instruction word 0x0000004D"},
+		// i=23
+		{in: "05020000", err: "mips.Decode: invalid instruction word 0x05020000"},
+	}
+
+	for i, g := range golden {
+		src, err := hex.DecodeString(g.in + g.in[:len(g.in)%2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(g.in)%2 != 0 {
+			src = src[:len(src)-1]
+		}
+		inst, n, err := Decode(src)
+		if len(g.err) > 0 {
+			if err == nil {
+				t.Errorf("i=%d: expected error %q, got nil", i, g.err)
+			} else if got := err.Error(); got != g.err {
+				t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.err, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if n != 4 {
+			t.Errorf("i=%d: length mismatch; expected 4, got %d", i, n)
+		}
+		got, err := disasm.FormatNode(mipsarch.Arch, &inst)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if got != g.want {
+			t.Errorf("i=%d: instruction mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	const src = `
+	org 0x400000
+main:
+	addiu $sp, $sp, -8
+	sw $ra, 4($sp)
+	lbu $a0, -1($a1)
+	li $t0, 0x12345678
+	move $a0, $t0
+loop:
+	addi $t0, $t0, -1
+	sltu $t1, $zero, $t0
+	bnez $t1, loop
+	nop
+	bltz $t0, main
+	jal func
+	nop
+	syscall
+	dd 0xFFFFFFFF
+func:
+	sra $v0, $a0, 2
+	mult $v0, $a0
+	mflo $v0
+	jr $ra
+	xori $v0, $v0, 0x8000
+`
+	want, err := asmtest.Text(mipsasm.Encoder, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog := disasm.Disassemble(Decoder, want, 0x400000)
+	out, err := disasm.Sprint(mipsarch.Arch, prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := asmtest.Text(mipsasm.Encoder, out)
+	if err != nil {
+		t.Fatalf("unable to assemble disassembly; %v\n%s", err, out)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("round-trip mismatch; expected %x, got %x\n%s", want, got, out)
+	}
+}
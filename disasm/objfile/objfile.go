@@ -0,0 +1,676 @@
+// Package objfile implements a reader of the sections of object files; the
+// front end of the disassembler for ELF, Mach-O and PE files.
+//
+// The executable and data sections of a file are disassembled into a single
+// program, in which each section is preceded by a section directive. The
+// contents of data sections are represented by db directives; zero-initialized
+// sections by zero bytes. Instructions and data located at the address of a
+// symbol are labelled by the name of the symbol, and branch and jump targets
+// and instruction relative memory operands located at the address of a symbol
+// of any section are given its name.
+//
+// The sections of relocatable objects are laid out one after another, and their
+// ELF, Mach-O and COFF relocation records applied as by a linker; undefined
+// symbols are located past the end of the sections, and declared by an extern
+// directive. As such, calls of external functions are given the names of the
+// functions. The relocation records of thumb instructions are not applied.
+// Relocated 4-byte and 8-byte data fields are represented by dd and dq
+// directives of the symbol they refer to, plus an addend; such as dq msg + 4.
+// Other relocated data is represented by its relocated value.
+package objfile
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/disasm"
+	"github.com/mewlang/asm/lexer"
+	"github.com/mewlang/asm/symtab"
+	"github.com/mewlang/asm/token"
+)
+
+// A File is an object file or executable.
+type File struct {
+	// Arch is the name of the architecture of the file (e.g. x86-64); or the
+	// empty string if not known.
+	Arch string
+	// Sections holds the executable and data sections of the file, in order of
+	// appearance.
+	Sections []*Section
+	// Symbols maps from address to the name of the symbols of the file;
+	// including undefined symbols.
+	Symbols map[int64]string
+	// Externs holds the names of the undefined symbols of relocatable objects
+	// referred to by relocation records, in order of name.
+	Externs []string
+	// closer closes the underlying file of files opened by Open; or nil.
+	closer io.Closer
+}
+
+// A Section is an executable or data section of a file.
+type Section struct {
+	// Name of the section (e.g. .text).
+	Name string
+	// Addr is the address of the first byte of the section; as laid out by
+	// NewFile for relocatable objects.
+	Addr int64
+	// Data holds the contents of the section, with relocation records
+	// applied.
+	Data []byte
+	// Exec specifies that the section holds machine code.
+	Exec bool
+	// Symbols maps from address to the name of the symbols of the section.
+	Symbols map[int64]string
+	// relocs maps from offset to the relocated data fields of the section.
+	relocs map[int64]dataReloc
+}
+
+// Open opens the named object file or executable. The file should be closed
+// using Close when no longer in use.
+func Open(path string) (*File, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := NewFile(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	f.closer = r
+	return f, nil
+}
+
+// Close closes the file. If the file was created using NewFile directly
+// instead of Open, Close has no effect.
+func (f *File) Close() error {
+	if f.closer == nil {
+		return nil
+	}
+	err := f.closer.Close()
+	f.closer = nil
+	return err
+}
+
+// NewFile reads the object file or executable of r. The file format is given
+// by the magic number at the start of the file; files with an unknown magic
+// number are read as COFF objects.
+func NewFile(r io.ReaderAt) (*File, error) {
+	var magic [4]byte
+	if _, err := r.ReadAt(magic[:], 0); err != nil {
+		return nil, fmt.Errorf("objfile.NewFile: unable to read magic number; %v", err)
+	}
+	switch {
+	case bytes.Equal(magic[:], []byte(elf.ELFMAG)):
+		return newELF(r)
+	case isMachO(magic):
+		return newMachO(r)
+	}
+	return newPE(r)
+}
+
+// isMachO returns true if the given magic number is the magic number of a
+// 32-bit or 64-bit Mach-O file in either byte order, and false otherwise.
+func isMachO(magic [4]byte) bool {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(magic[:]) {
+		case macho.Magic32, macho.Magic64:
+			return true
+		}
+	}
+	return false
+}
+
+// Disassemble disassembles the executable sections of the file using dec, and
+// represents the contents of data sections by data directives. The origin of
+// the program is the address of the first section, as specified by an org
+// directive unless zero.
+func (f *File) Disassemble(dec disasm.Decoder) *ast.Program {
+	prog := new(ast.Program)
+	if len(f.Sections) > 0 && f.Sections[0].Addr != 0 {
+		// The assembler accepts only one org directive.
+		prog.Lines = append(prog.Lines, &ast.Line{Node: &ast.OrgDir{Addr: ast.Addr(f.Sections[0].Addr)}})
+	}
+	if len(f.Externs) > 0 {
+		prog.Lines = append(prog.Lines, &ast.Line{Node: &ast.ExternDir{Names: f.Externs}})
+	}
+	for _, sect := range f.Sections {
+		prog.Lines = append(prog.Lines, &ast.Line{Node: &ast.SectionDir{Name: sect.Name}})
+		if !sect.Exec {
+			prog.Lines = append(prog.Lines, sect.data(f.Symbols)...)
+			continue
+		}
+		conf := &disasm.Config{Labels: sect.Symbols, Symbols: f.Symbols}
+		lines := conf.Disassemble(dec, sect.Data, sect.Addr).Lines
+		if len(lines) > 0 {
+			if _, ok := lines[0].Node.(*ast.OrgDir); ok {
+				lines = lines[1:]
+			}
+		}
+		prog.Lines = append(prog.Lines, lines...)
+	}
+	return prog
+}
+
+// dataWidth is the maximum number of bytes of each data directive representing
+// the contents of data sections.
+const dataWidth = 16
+
+// data returns the lines of the data directives representing the contents of
+// the section. Directives are split at the address of symbols, which label
+// them, and at relocated data fields; which are given the names of the symbols
+// of the file they refer to, as mapped from address by syms.
+func (sect *Section) data(syms map[int64]string) []*ast.Line {
+	var lines []*ast.Line
+	for off := 0; off < len(sect.Data); {
+		addr := sect.Addr + int64(off)
+		var data *ast.DataDir
+		n := 1
+		if r, ok := sect.relocs[int64(off)]; ok {
+			if x := r.arg(syms); x != nil {
+				data = &ast.DataDir{Width: r.size, Vals: []ast.Arg{x}}
+				n = r.size
+			}
+		}
+		if data == nil {
+			for ; n < dataWidth && off+n < len(sect.Data); n++ {
+				if _, ok := sect.Symbols[addr+int64(n)]; ok {
+					break
+				}
+				if _, ok := sect.relocs[int64(off+n)]; ok {
+					break
+				}
+			}
+			data = &ast.DataDir{Width: 1}
+			for _, b := range sect.Data[off : off+n] {
+				data.Vals = append(data.Vals, ast.Int(b))
+			}
+		}
+		line := &ast.Line{Node: data, Comment: fmt.Sprintf("; %08X", addr)}
+		if name, ok := sect.Symbols[addr]; ok {
+			line.Label = &ast.LabelDecl{Name: name}
+		}
+		lines = append(lines, line)
+		off += n
+	}
+	return lines
+}
+
+// index sets the symbols of the file to the symbols of its sections and the
+// given undefined symbols.
+func (f *File) index(ext *externs) {
+	f.Symbols = make(map[int64]string)
+	for _, sect := range f.Sections {
+		for addr, name := range sect.Symbols {
+			f.Symbols[addr] = name
+		}
+	}
+	for name, addr := range ext.addrs {
+		if isLabel(name) {
+			f.Symbols[addr] = name
+			f.Externs = append(f.Externs, name)
+		}
+	}
+	sort.Strings(f.Externs)
+}
+
+// A symbol is a symbol of a section, which is a candidate label of its address.
+type symbol struct {
+	// Address of the symbol.
+	addr int64
+	// Name of the symbol.
+	name string
+	// global specifies that the symbol is visible to other object files.
+	global bool
+}
+
+// labels returns the labels of the given symbols, mapping from address to
+// symbol name. Addresses of several symbols are labelled by the first global
+// symbol in order of name, or else the first local symbol. Symbols whose names
+// are not identifiers of non-local labels are skipped; such as printf@plt.
+func labels(syms []symbol) map[int64]string {
+	sort.SliceStable(syms, func(i, j int) bool {
+		if syms[i].global != syms[j].global {
+			return syms[i].global
+		}
+		return syms[i].name < syms[j].name
+	})
+	m := make(map[int64]string)
+	for _, sym := range syms {
+		if _, ok := m[sym.addr]; !ok && isLabel(sym.name) {
+			m[sym.addr] = sym.name
+		}
+	}
+	return m
+}
+
+// isLabel returns true if name is an identifier of a non-local label, and false
+// otherwise.
+func isLabel(name string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "$") {
+		return false
+	}
+	toks := lexer.Parse(name)
+	return len(toks) == 2 && toks[0].Typ == token.Ident && toks[0].Val == name && toks[1].Typ == token.EOF
+}
+
+// elfArchs maps from ELF machine to architecture name, indexed by ELF class.
+var elfArchs = map[elf.Class]map[elf.Machine]string{
+	elf.ELFCLASS32: {
+		elf.EM_386:   "x86",
+		elf.EM_ARM:   "thumb",
+		elf.EM_MIPS:  "mips",
+		elf.EM_RISCV: "riscv32",
+	},
+	elf.ELFCLASS64: {
+		elf.EM_X86_64:  "x86-64",
+		elf.EM_AARCH64: "arm64",
+		elf.EM_RISCV:   "riscv64",
+	},
+}
+
+// newELF reads the ELF file of r.
+func newELF(r io.ReaderAt) (*File, error) {
+	ef, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{Arch: elfArchs[ef.Class][ef.Machine]}
+	syms, err := ef.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return nil, err
+	}
+	// Addresses of the sections; the sections of relocatable objects are laid
+	// out one after another.
+	addrs := make([]int64, len(ef.Sections))
+	end := int64(0)
+	for i, s := range ef.Sections {
+		if s.Flags&elf.SHF_ALLOC == 0 {
+			continue
+		}
+		addrs[i] = int64(s.Addr)
+		if ef.Type == elf.ET_REL {
+			addrs[i] = symtab.AlignUp(end, int64(s.Addralign))
+		}
+		if addrs[i]+int64(s.Size) > end {
+			end = addrs[i] + int64(s.Size)
+		}
+	}
+	ext := newExterns(end)
+	// addr returns the address of the given symbol.
+	addr := func(sym elf.Symbol) int64 {
+		switch {
+		case sym.Section == elf.SHN_UNDEF:
+			return ext.addr(sym.Name)
+		case ef.Type == elf.ET_REL && int(sym.Section) < len(addrs):
+			// Symbol values of relocatable objects are relative to their
+			// section.
+			return addrs[sym.Section] + int64(sym.Value)
+		}
+		return int64(sym.Value)
+	}
+	// Relocation records, by section index.
+	relocs := make(map[int][]reloc)
+	if ef.Type == elf.ET_REL {
+		for _, s := range ef.Sections {
+			if s.Type != elf.SHT_REL && s.Type != elf.SHT_RELA {
+				continue
+			}
+			rs, err := elfRelocs(ef, s, syms, addr)
+			if err != nil {
+				return nil, err
+			}
+			relocs[int(s.Info)] = append(relocs[int(s.Info)], rs...)
+		}
+	}
+	for i, s := range ef.Sections {
+		if s.Flags&elf.SHF_ALLOC == 0 || (s.Type != elf.SHT_PROGBITS && s.Type != elf.SHT_NOBITS) {
+			continue
+		}
+		// The contents of zero-initialized sections are not stored in the file.
+		data := make([]byte, s.Size)
+		if s.Type != elf.SHT_NOBITS {
+			if data, err = s.Data(); err != nil {
+				return nil, err
+			}
+		}
+		var ss []symbol
+		for _, sym := range syms {
+			typ := elf.ST_TYPE(sym.Info)
+			if int(sym.Section) != i || (typ != elf.STT_NOTYPE && typ != elf.STT_FUNC && typ != elf.STT_OBJECT) {
+				continue
+			}
+			a := addr(sym)
+			if ef.Machine == elf.EM_ARM && typ == elf.STT_FUNC {
+				// Clear the Thumb bit of function addresses.
+				a &^= 1
+			}
+			ss = append(ss, symbol{addr: a, name: sym.Name, global: elf.ST_BIND(sym.Info) != elf.STB_LOCAL})
+		}
+		sect := &Section{
+			Name:    s.Name,
+			Addr:    addrs[i],
+			Data:    data,
+			Exec:    s.Flags&elf.SHF_EXECINSTR != 0,
+			Symbols: labels(ss),
+		}
+		sect.relocate(ef.ByteOrder, relocs[i])
+		f.Sections = append(f.Sections, sect)
+	}
+	f.index(ext)
+	return f, nil
+}
+
+// elfRelocs returns the relocation records of the relocation section s of the
+// ELF relocatable object ef, whose symbols are located at the addresses given
+// by addr. Records of unknown relocation types are skipped.
+func elfRelocs(ef *elf.File, s *elf.Section, syms []elf.Symbol, addr func(elf.Symbol) int64) ([]reloc, error) {
+	data, err := s.Data()
+	if err != nil {
+		return nil, err
+	}
+	var relocs []reloc
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		var (
+			off      uint64
+			sym, typ uint32
+			addend   int64
+		)
+		switch {
+		case ef.Class == elf.ELFCLASS64 && s.Type == elf.SHT_RELA:
+			var e elf.Rela64
+			if err := binary.Read(r, ef.ByteOrder, &e); err != nil {
+				return nil, err
+			}
+			off, sym, typ, addend = e.Off, elf.R_SYM64(e.Info), elf.R_TYPE64(e.Info), e.Addend
+		case ef.Class == elf.ELFCLASS64:
+			var e elf.Rel64
+			if err := binary.Read(r, ef.ByteOrder, &e); err != nil {
+				return nil, err
+			}
+			off, sym, typ = e.Off, elf.R_SYM64(e.Info), elf.R_TYPE64(e.Info)
+		case s.Type == elf.SHT_RELA:
+			var e elf.Rela32
+			if err := binary.Read(r, ef.ByteOrder, &e); err != nil {
+				return nil, err
+			}
+			off, sym, typ, addend = uint64(e.Off), elf.R_SYM32(e.Info), elf.R_TYPE32(e.Info), int64(e.Addend)
+		default:
+			var e elf.Rel32
+			if err := binary.Read(r, ef.ByteOrder, &e); err != nil {
+				return nil, err
+			}
+			off, sym, typ = uint64(e.Off), elf.R_SYM32(e.Info), elf.R_TYPE32(e.Info)
+		}
+		f, ok := elfFields[ef.Machine][typ]
+		if !ok || sym == 0 || int(sym) > len(syms) {
+			continue
+		}
+		relocs = append(relocs, reloc{field: f, off: int64(off), sym: addr(syms[sym-1]), addend: addend, implicit: s.Type == elf.SHT_REL})
+	}
+	return relocs, nil
+}
+
+// machoArchs maps from Mach-O CPU type to architecture name.
+var machoArchs = map[macho.Cpu]string{
+	macho.Cpu386:   "x86",
+	macho.CpuAmd64: "x86-64",
+	macho.CpuArm64: "arm64",
+}
+
+// Mach-O section types and attributes, and symbol types.
+const (
+	// Mask of section types.
+	machoSectionType = 0xFF
+	// Sections of zero-initialized data.
+	machoZeroFill   = 0x01
+	machoGBZeroFill = 0x0C
+	// Section holding only machine code.
+	machoPureInstructions = 0x80000000
+	// Section holding some machine code.
+	machoSomeInstructions = 0x400
+	// Section holding debugging information.
+	machoDebug = 0x02000000
+	// Mask of debugging symbol types.
+	machoStab = 0xE0
+	// Mask of symbol types.
+	machoType = 0x0E
+	// Undefined symbol.
+	machoUndef = 0x00
+	// Symbol defined in a section.
+	machoSect = 0x0E
+	// External symbol.
+	machoExt = 0x01
+)
+
+// newMachO reads the Mach-O file of r.
+func newMachO(r io.ReaderAt) (*File, error) {
+	mf, err := macho.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{Arch: machoArchs[mf.Cpu]}
+	var syms []macho.Symbol
+	if mf.Symtab != nil {
+		syms = mf.Symtab.Syms
+	}
+	end := int64(0)
+	for _, s := range mf.Sections {
+		if int64(s.Addr+s.Size) > end {
+			end = int64(s.Addr + s.Size)
+		}
+	}
+	ext := newExterns(end)
+	for i, s := range mf.Sections {
+		if s.Flags&machoDebug != 0 {
+			continue
+		}
+		var data []byte
+		switch s.Flags & machoSectionType {
+		case machoZeroFill, machoGBZeroFill:
+			// The contents of zero-initialized sections are not stored in the
+			// file.
+			data = make([]byte, s.Size)
+		default:
+			if data, err = s.Data(); err != nil {
+				return nil, err
+			}
+		}
+		var ss []symbol
+		for _, sym := range syms {
+			if sym.Type&machoStab != 0 || sym.Type&machoType != machoSect || int(sym.Sect) != i+1 {
+				continue
+			}
+			ss = append(ss, symbol{addr: int64(sym.Value), name: sym.Name, global: sym.Type&machoExt != 0})
+		}
+		// Relocation records referring to sections are resolved relative to
+		// the address space of the object, while records referring to
+		// symbols are relocated. The data fields of records referring to
+		// sections are relocated to the address they hold, to record them.
+		var relocs []reloc
+		// Addend of the next record, as given by ARM64_RELOC_ADDEND.
+		addend := int64(0)
+		for _, r := range s.Relocs {
+			if mf.Cpu == macho.CpuArm64 && r.Type == uint8(macho.ARM64_RELOC_ADDEND) {
+				// Signed 24-bit addend.
+				addend = int64(int32(r.Value<<8) >> 8)
+				continue
+			}
+			f, ok := machoFields[mf.Cpu][r.Type]
+			if ok && !r.Extern && !r.Scattered && f.typ == symtab.Field && !f.pcrel && r.Value >= 1 && int(r.Value) <= len(mf.Sections) {
+				f.size = 1 << r.Len
+				addr := int64(mf.Sections[r.Value-1].Addr)
+				relocs = append(relocs, reloc{field: f, off: int64(r.Addr), sym: addr, addend: addend - addr, implicit: true})
+				addend = 0
+				continue
+			}
+			if !ok || !r.Extern || r.Scattered || int(r.Value) >= len(syms) {
+				addend = 0
+				continue
+			}
+			f.size = 1 << r.Len
+			rel := reloc{field: f, off: int64(r.Addr), sym: int64(syms[r.Value].Value), addend: addend, implicit: true}
+			if syms[r.Value].Type&machoType == machoUndef {
+				rel.sym = ext.addr(syms[r.Value].Name)
+			}
+			if f.typ == symtab.Field && f.pcrel {
+				// PC-relative fields are relative to the end of the field.
+				rel.addend -= int64(f.size)
+			}
+			relocs = append(relocs, rel)
+			addend = 0
+		}
+		sect := &Section{
+			Name:    s.Name,
+			Addr:    int64(s.Addr),
+			Data:    data,
+			Exec:    s.Flags&(machoPureInstructions|machoSomeInstructions) != 0,
+			Symbols: labels(ss),
+		}
+		sect.relocate(mf.ByteOrder, relocs)
+		f.Sections = append(f.Sections, sect)
+	}
+	f.index(ext)
+	return f, nil
+}
+
+// peArchs maps from PE machine to architecture name.
+var peArchs = map[uint16]string{
+	pe.IMAGE_FILE_MACHINE_I386:  "x86",
+	pe.IMAGE_FILE_MACHINE_AMD64: "x86-64",
+	pe.IMAGE_FILE_MACHINE_ARM64: "arm64",
+	pe.IMAGE_FILE_MACHINE_ARMNT: "thumb",
+}
+
+// Special section number of undefined COFF symbols.
+const peUndef = 0
+
+// Storage classes of COFF symbols.
+const (
+	// External symbol.
+	peExternal = 2
+	// Static symbol.
+	peStatic = 3
+)
+
+// newPE reads the PE executable or COFF object of r.
+func newPE(r io.ReaderAt) (*File, error) {
+	pf, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	arch, ok := peArchs[pf.Machine]
+	if !ok {
+		return nil, fmt.Errorf("objfile.newPE: unknown file format or machine 0x%X", pf.Machine)
+	}
+	f := &File{Arch: arch}
+	// Image base of executables; the addresses of sections are relative to
+	// the image base.
+	var base int64
+	switch opt := pf.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		base = int64(opt.ImageBase)
+	case *pe.OptionalHeader64:
+		base = int64(opt.ImageBase)
+	}
+	// Addresses of the sections; the sections of objects are laid out one
+	// after another.
+	object := pf.OptionalHeader == nil
+	addrs := make([]int64, len(pf.Sections))
+	end := int64(0)
+	for i, s := range pf.Sections {
+		addrs[i] = base + int64(s.VirtualAddress)
+		if object {
+			align := int64(16)
+			if n := s.Characteristics >> 20 & 0xF; n > 0 {
+				align = 1 << (n - 1)
+			}
+			addrs[i] = symtab.AlignUp(end, align)
+		}
+		if addrs[i]+int64(s.Size) > end {
+			end = addrs[i] + int64(s.Size)
+		}
+	}
+	ext := newExterns(end)
+	for i, s := range pf.Sections {
+		exec := s.Characteristics&(pe.IMAGE_SCN_CNT_CODE|pe.IMAGE_SCN_MEM_EXECUTE) != 0
+		switch {
+		case s.Characteristics&pe.IMAGE_SCN_MEM_DISCARDABLE != 0:
+			// Skip base relocations and debugging information.
+			continue
+		case !exec && s.Characteristics&(pe.IMAGE_SCN_CNT_INITIALIZED_DATA|pe.IMAGE_SCN_CNT_UNINITIALIZED_DATA) == 0:
+			continue
+		}
+		var data []byte
+		if s.Characteristics&pe.IMAGE_SCN_CNT_UNINITIALIZED_DATA != 0 {
+			// The contents of zero-initialized sections are not stored in the
+			// file.
+			size := s.VirtualSize
+			if size == 0 {
+				size = s.Size
+			}
+			data = make([]byte, size)
+		} else {
+			if data, err = s.Data(); err != nil {
+				return nil, err
+			}
+			if s.VirtualSize > 0 && int64(s.VirtualSize) < int64(len(data)) {
+				// Strip the padding of sections of executables.
+				data = data[:s.VirtualSize]
+			}
+		}
+		var ss []symbol
+		for _, sym := range pf.Symbols {
+			if int(sym.SectionNumber) != i+1 || (sym.StorageClass != peExternal && sym.StorageClass != peStatic) || sym.Name == s.Name {
+				// Skip the symbols of other sections, and section symbols.
+				continue
+			}
+			ss = append(ss, symbol{addr: addrs[i] + int64(sym.Value), name: sym.Name, global: sym.StorageClass == peExternal})
+		}
+		var relocs []reloc
+		for _, r := range s.Relocs {
+			f, ok := peFields[pf.Machine][r.Type]
+			if !object || !ok || int(r.SymbolTableIndex) >= len(pf.COFFSymbols) {
+				continue
+			}
+			sym := &pf.COFFSymbols[r.SymbolTableIndex]
+			rel := reloc{field: f, off: int64(r.VirtualAddress), sym: int64(sym.Value), implicit: true}
+			switch {
+			case sym.SectionNumber > 0 && int(sym.SectionNumber) <= len(addrs):
+				rel.sym += addrs[sym.SectionNumber-1]
+			case sym.SectionNumber == peUndef:
+				name, err := sym.FullName(pf.StringTable)
+				if err != nil {
+					return nil, err
+				}
+				rel.sym = ext.addr(name)
+			}
+			if f.typ == symtab.Field && f.pcrel {
+				// PC-relative fields are relative to the end of the field.
+				rel.addend -= int64(f.size)
+			}
+			relocs = append(relocs, rel)
+		}
+		sect := &Section{
+			Name:    s.Name,
+			Addr:    addrs[i],
+			Data:    data,
+			Exec:    exec,
+			Symbols: labels(ss),
+		}
+		sect.relocate(binary.LittleEndian, relocs)
+		f.Sections = append(f.Sections, sect)
+	}
+	f.index(ext)
+	return f, nil
+}
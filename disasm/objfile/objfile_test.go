@@ -0,0 +1,397 @@
+package objfile
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mewlang/asm/asm"
+	asmarm64 "github.com/mewlang/asm/asm/arm64"
+	asmmips "github.com/mewlang/asm/asm/mips"
+	asmriscv "github.com/mewlang/asm/asm/riscv"
+	asmx86 "github.com/mewlang/asm/asm/x86"
+	"github.com/mewlang/asm/disasm"
+	"github.com/mewlang/asm/disasm/arm64"
+	"github.com/mewlang/asm/disasm/mips"
+	"github.com/mewlang/asm/disasm/riscv"
+	"github.com/mewlang/asm/disasm/x86"
+	"github.com/mewlang/asm/internal/asmtest"
+	"github.com/mewlang/asm/lexer"
+	"github.com/mewlang/asm/obj"
+	"github.com/mewlang/asm/obj/coff"
+	"github.com/mewlang/asm/obj/elf"
+	"github.com/mewlang/asm/obj/macho"
+	"github.com/mewlang/asm/token"
+)
+
+func TestDisassemble(t *testing.T) {
+	const src = `
+	global _start
+	section .text
+_start:
+	call exit
+	jmp _start
+exit:
+	mov eax, 60
+	syscall
+	section .text.exit
+.loop:
+	ret
+	section .rodata
+msg:
+	db "hi"
+`
+	golden := []struct {
+		format obj.Format
+		want   string
+	}{
+		// i=0
+		{
+			format: &elf.Rel{},
+			want: `	section .text
+_start:
+	call exit  ; 00000000: E802000000
+	jmp _start ; 00000005: EBF9
+exit:
+	mov eax, 60 ; 00000007: B83C000000
+	syscall     ; 0000000C: 0F05
+	section .text.exit
+exit.loop:
+	ret ; 00000010: C3
+	section .rodata
+msg:
+	db 104, 105 ; 00000020
+`,
+		},
+		// i=1
+		{
+			format: &elf.Exec{},
+			want: `	org 0x400000
+	section .text
+_start:
+	call exit  ; 00400000: E802000000
+	jmp _start ; 00400005: EBF9
+exit:
+	mov eax, 60 ; 00400007: B83C000000
+	syscall     ; 0040000C: 0F05
+	section .text.exit
+exit.loop:
+	ret ; 00401000: C3
+	section .rodata
+msg:
+	db 104, 105 ; 00402000
+`,
+		},
+		// i=2
+		{
+			format: &macho.Format{},
+			want: `	section __text
+_start:
+	call exit  ; 00000000: E802000000
+	jmp _start ; 00000005: EBF9
+exit:
+	mov eax, 60 ; 00000007: B83C000000
+	syscall     ; 0000000C: 0F05
+	section __text_exit
+exit.loop:
+	ret ; 00000010: C3
+	section __const
+msg:
+	db 104, 105 ; 00000020
+`,
+		},
+		// i=3
+		{
+			format: &coff.Format{},
+			want: `	section .text
+_start:
+	call exit  ; 00000000: E802000000
+	jmp _start ; 00000005: EBF9
+exit:
+	mov eax, 60 ; 00000007: B83C000000
+	syscall     ; 0000000C: 0F05
+	section .text.exit
+exit.loop:
+	ret ; 00000010: C3
+	section .rodata
+msg:
+	db 104, 105 ; 00000020
+`,
+		},
+	}
+
+	for i, g := range golden {
+		conf, err := g.format.Config(asmx86.Encoder64.Arch())
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		o, err := asmtest.Assemble(conf, asmx86.Encoder64, src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		buf := new(bytes.Buffer)
+		if err := g.format.Write(buf, o); err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		f, err := NewFile(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if f.Arch != "x86-64" {
+			t.Errorf("i=%d: architecture mismatch; expected %q, got %q", i, "x86-64", f.Arch)
+		}
+		got, err := disasm.Sprint(x86.Decoder64.Arch(), f.Disassemble(x86.Decoder64))
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if got != g.want {
+			t.Errorf("i=%d: output mismatch; expected %q, got %q", i, g.want, got)
+		}
+		for _, tok := range lexer.Parse(got) {
+			if tok.Typ == token.Error {
+				t.Errorf("i=%d: unable to tokenize output; %v", i, tok)
+			}
+		}
+	}
+}
+
+func TestDisassembleReloc(t *testing.T) {
+	const x86src = `
+	extern puts
+	section .text
+main:
+	lea rdi, [rel msg]
+	call puts
+	mov rax, [rel ptr]
+	ret
+	section .data
+msg:
+	db "hi", 0
+ptr:
+	dq msg
+	section .bss
+buf:
+	dq 0
+`
+	golden := []struct {
+		format obj.Format
+		enc    asm.Encoder
+		dec    disasm.Decoder
+		src    string
+		want   string
+	}{
+		// i=0
+		{
+			format: &elf.Rel{},
+			enc:    asmx86.Encoder64,
+			dec:    x86.Decoder64,
+			src:    x86src,
+			want: `	extern puts
+	section .text
+main:
+	lea rdi, [rel msg] ; 00000000: 488D3D19000000
+	call puts          ; 00000007: E834000000
+	mov rax, [rel ptr] ; 0000000C: 488B0510000000
+	ret                ; 00000013: C3
+	section .data
+msg:
+	db 104, 105, 0 ; 00000020
+ptr:
+	dq msg ; 00000023
+	section .bss
+buf:
+	db 0, 0, 0, 0, 0, 0, 0, 0 ; 00000030
+`,
+		},
+		// i=1
+		{
+			format: &macho.Format{},
+			enc:    asmx86.Encoder64,
+			dec:    x86.Decoder64,
+			src:    x86src,
+			want: `	extern puts
+	section __text
+main:
+	lea rdi, [rel msg] ; 00000000: 488D3D19000000
+	call puts          ; 00000007: E834000000
+	mov rax, [rel ptr] ; 0000000C: 488B0510000000
+	ret                ; 00000013: C3
+	section __data
+msg:
+	db 104, 105, 0 ; 00000020
+ptr:
+	dq msg ; 00000023
+	section __bss
+buf:
+	db 0, 0, 0, 0, 0, 0, 0, 0 ; 00000030
+`,
+		},
+		// i=2
+		{
+			format: &coff.Format{},
+			enc:    asmx86.Encoder64,
+			dec:    x86.Decoder64,
+			src:    x86src,
+			want: `	extern puts
+	section .text
+main:
+	lea rdi, [rel msg] ; 00000000: 488D3D19000000
+	call puts          ; 00000007: E834000000
+	mov rax, [rel ptr] ; 0000000C: 488B0510000000
+	ret                ; 00000013: C3
+	section .data
+msg:
+	db 104, 105, 0 ; 00000020
+ptr:
+	dq msg ; 00000023
+	section .bss
+buf:
+	db 0, 0, 0, 0, 0, 0, 0, 0 ; 00000030
+`,
+		},
+		// i=3
+		{
+			format: &elf.Rel{},
+			enc:    asmarm64.Encoder,
+			dec:    arm64.Decoder,
+			src: `
+	extern puts
+	section .text
+main:
+	bl puts
+	b main
+	section .data
+	dd 0
+msg:
+	dq msg
+`,
+			want: `	extern puts
+	section .text
+main:
+	bl puts ; 00000000: 08000094
+	b main  ; 00000004: FFFFFF17
+	section .data
+	db 0, 0, 0, 0 ; 00000010
+msg:
+	dq msg ; 00000014
+`,
+		},
+		// i=4
+		{
+			format: &elf.Rel{},
+			enc:    asmmips.Encoder,
+			dec:    mips.Decoder,
+			src: `
+	extern puts
+	section .text
+main:
+	jal puts
+	nop
+	la $a0, msg
+	section .data
+	dd 0
+msg:
+	db "hi", 0
+`,
+			want: `	extern puts
+	section .text
+main:
+	jal puts           ; 00000000: 0C000008
+	nop                ; 00000004: 00000000
+	lui $a0, 0         ; 00000008: 3C040000
+	addiu $a0, $a0, 20 ; 0000000C: 24840014
+	section .data
+	db 0, 0, 0, 0 ; 00000010
+msg:
+	db 104, 105, 0 ; 00000014
+`,
+		},
+		// i=5
+		{
+			format: &elf.Rel{},
+			enc:    asmriscv.Encoder64,
+			dec:    riscv.Decoder64,
+			src: `
+	extern puts
+	section .text
+main:
+	call puts
+	la a0, msg
+	section .data
+	dd 0
+msg:
+	db "hi", 0
+`,
+			want: `	extern puts
+	section .text
+main:
+	auipc ra, 0     ; 00000000: 97000000
+	jalr ra, 32(ra) ; 00000004: E7800002
+	auipc a0, 0     ; 00000008: 17050000
+	addi a0, a0, 12 ; 0000000C: 1305C500
+	section .data
+	db 0, 0, 0, 0 ; 00000010
+msg:
+	db 104, 105, 0 ; 00000014
+`,
+		},
+		// i=6
+		{
+			format: &elf.Rel{},
+			enc:    asmx86.Encoder64,
+			dec:    x86.Decoder64,
+			src: `
+	extern puts
+	section .data
+msg:
+	db "hello", 0
+	dq puts
+	dq msg + 2
+	dd puts + 8
+`,
+			want: `	extern puts
+	section .data
+msg:
+	db 104, 101, 108, 108, 111, 0 ; 00000000
+	dq puts                       ; 00000006
+	dq msg + 2                    ; 0000000E
+	dd puts + 8                   ; 00000016
+`,
+		},
+	}
+
+	for i, g := range golden {
+		conf, err := g.format.Config(g.enc.Arch())
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		o, err := asmtest.Assemble(conf, g.enc, g.src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		buf := new(bytes.Buffer)
+		if err := g.format.Write(buf, o); err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		f, err := NewFile(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		got, err := disasm.Sprint(g.dec.Arch(), f.Disassemble(g.dec))
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if got != g.want {
+			t.Errorf("i=%d: output mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
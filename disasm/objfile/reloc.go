@@ -0,0 +1,329 @@
+package objfile
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/symtab"
+	"github.com/mewlang/asm/token"
+)
+
+// A field specifies the kind of field of a relocation record.
+type field struct {
+	// Relocation type of the bit field of an instruction; or symtab.Field.
+	typ symtab.RelocType
+	// Size in bytes of the field.
+	size int
+	// PC-relative field.
+	pcrel bool
+}
+
+// elfFields maps from ELF machine to the fields of its relocation types.
+var elfFields = map[elf.Machine]map[uint32]field{
+	elf.EM_386: {
+		uint32(elf.R_386_8):     {size: 1},
+		uint32(elf.R_386_PC8):   {size: 1, pcrel: true},
+		uint32(elf.R_386_16):    {size: 2},
+		uint32(elf.R_386_PC16):  {size: 2, pcrel: true},
+		uint32(elf.R_386_32):    {size: 4},
+		uint32(elf.R_386_PC32):  {size: 4, pcrel: true},
+		uint32(elf.R_386_PLT32): {size: 4, pcrel: true},
+	},
+	elf.EM_X86_64: {
+		uint32(elf.R_X86_64_8):     {size: 1},
+		uint32(elf.R_X86_64_PC8):   {size: 1, pcrel: true},
+		uint32(elf.R_X86_64_16):    {size: 2},
+		uint32(elf.R_X86_64_PC16):  {size: 2, pcrel: true},
+		uint32(elf.R_X86_64_32):    {size: 4},
+		uint32(elf.R_X86_64_32S):   {size: 4},
+		uint32(elf.R_X86_64_PC32):  {size: 4, pcrel: true},
+		uint32(elf.R_X86_64_PLT32): {size: 4, pcrel: true},
+		uint32(elf.R_X86_64_64):    {size: 8},
+		uint32(elf.R_X86_64_PC64):  {size: 8, pcrel: true},
+	},
+	elf.EM_AARCH64: {
+		uint32(elf.R_AARCH64_ABS16):            {size: 2},
+		uint32(elf.R_AARCH64_PREL16):           {size: 2, pcrel: true},
+		uint32(elf.R_AARCH64_ABS32):            {size: 4},
+		uint32(elf.R_AARCH64_PREL32):           {size: 4, pcrel: true},
+		uint32(elf.R_AARCH64_ABS64):            {size: 8},
+		uint32(elf.R_AARCH64_PREL64):           {size: 8, pcrel: true},
+		uint32(elf.R_AARCH64_CALL26):           {typ: symtab.ARM64Branch26, size: 4, pcrel: true},
+		uint32(elf.R_AARCH64_JUMP26):           {typ: symtab.ARM64Branch26, size: 4, pcrel: true},
+		uint32(elf.R_AARCH64_ADR_PREL_PG_HI21): {typ: symtab.ARM64Page21, size: 4, pcrel: true},
+		uint32(elf.R_AARCH64_ADD_ABS_LO12_NC):  {typ: symtab.ARM64PageOff12, size: 4},
+	},
+	elf.EM_MIPS: {
+		uint32(elf.R_MIPS_16):   {size: 2},
+		uint32(elf.R_MIPS_32):   {size: 4},
+		uint32(elf.R_MIPS_PC32): {size: 4, pcrel: true},
+		uint32(elf.R_MIPS_26):   {typ: symtab.MIPS26, size: 4},
+		uint32(elf.R_MIPS_HI16): {typ: symtab.MIPSHi16, size: 4},
+		uint32(elf.R_MIPS_LO16): {typ: symtab.MIPSLo16, size: 4},
+	},
+	elf.EM_RISCV: {
+		uint32(elf.R_RISCV_32):           {size: 4},
+		uint32(elf.R_RISCV_32_PCREL):     {size: 4, pcrel: true},
+		uint32(elf.R_RISCV_64):           {size: 8},
+		uint32(elf.R_RISCV_CALL):         {typ: symtab.RISCVCall, size: 8, pcrel: true},
+		uint32(elf.R_RISCV_CALL_PLT):     {typ: symtab.RISCVCall, size: 8, pcrel: true},
+		uint32(elf.R_RISCV_PCREL_HI20):   {typ: symtab.RISCVPCRelHi20, size: 4, pcrel: true},
+		uint32(elf.R_RISCV_PCREL_LO12_I): {typ: symtab.RISCVPCRelLo12I, size: 4},
+	},
+}
+
+// machoFields maps from Mach-O CPU type to the fields of its relocation types.
+// The size of fields is given by the relocation entries.
+var machoFields = map[macho.Cpu]map[uint8]field{
+	macho.CpuAmd64: {
+		uint8(macho.X86_64_RELOC_UNSIGNED): {},
+		uint8(macho.X86_64_RELOC_SIGNED):   {pcrel: true},
+		uint8(macho.X86_64_RELOC_BRANCH):   {pcrel: true},
+	},
+	macho.CpuArm64: {
+		uint8(macho.ARM64_RELOC_UNSIGNED):  {},
+		uint8(macho.ARM64_RELOC_BRANCH26):  {typ: symtab.ARM64Branch26, pcrel: true},
+		uint8(macho.ARM64_RELOC_PAGE21):    {typ: symtab.ARM64Page21, pcrel: true},
+		uint8(macho.ARM64_RELOC_PAGEOFF12): {typ: symtab.ARM64PageOff12},
+	},
+}
+
+// peFields maps from COFF machine to the fields of its relocation types.
+var peFields = map[uint16]map[uint16]field{
+	pe.IMAGE_FILE_MACHINE_I386: {
+		// IMAGE_REL_I386_DIR32 and IMAGE_REL_I386_REL32.
+		0x0006: {size: 4},
+		0x0014: {size: 4, pcrel: true},
+	},
+	pe.IMAGE_FILE_MACHINE_AMD64: {
+		// IMAGE_REL_AMD64_ADDR64, IMAGE_REL_AMD64_ADDR32 and
+		// IMAGE_REL_AMD64_REL32.
+		0x0001: {size: 8},
+		0x0002: {size: 4},
+		0x0004: {size: 4, pcrel: true},
+	},
+	pe.IMAGE_FILE_MACHINE_ARM64: {
+		// IMAGE_REL_ARM64_ADDR32, IMAGE_REL_ARM64_ADDR64 and
+		// IMAGE_REL_ARM64_REL32.
+		0x0001: {size: 4},
+		0x000E: {size: 8},
+		0x0011: {size: 4, pcrel: true},
+		// IMAGE_REL_ARM64_BRANCH26, IMAGE_REL_ARM64_PAGEBASE_REL21 and
+		// IMAGE_REL_ARM64_PAGEOFFSET_12A.
+		0x0003: {typ: symtab.ARM64Branch26, size: 4, pcrel: true},
+		0x0004: {typ: symtab.ARM64Page21, size: 4, pcrel: true},
+		0x0006: {typ: symtab.ARM64PageOff12, size: 4},
+	},
+}
+
+// A reloc is a relocation record of a section.
+type reloc struct {
+	field
+	// Offset of the field from the start of the section.
+	off int64
+	// Address of the symbol referred to.
+	sym int64
+	// Addend of the record, which is added to the implicit addend held by the
+	// field if any.
+	addend int64
+	// implicit specifies that the field holds an addend.
+	implicit bool
+}
+
+// A dataReloc is a relocated data field, which holds the address of a symbol
+// plus an addend.
+type dataReloc struct {
+	// Size in bytes of the field.
+	size int
+	// Address held by the field.
+	addr int64
+}
+
+// arg returns the operand of the data directive of the field; the name of the
+// nearest symbol at or below the address held by the field, plus an addend if
+// non-zero. The names of symbols are mapped from address by syms. It returns
+// nil if no such symbol is present.
+func (r dataReloc) arg(syms map[int64]string) ast.Arg {
+	var name string
+	var sym int64
+	for addr, n := range syms {
+		if addr <= r.addr && (name == "" || addr > sym || addr == sym && n < name) {
+			name, sym = n, addr
+		}
+	}
+	switch {
+	case name == "":
+		return nil
+	case r.addr == sym:
+		return ast.Ident(name)
+	}
+	return &ast.BinaryExpr{X: ast.Ident(name), Op: token.Add, Y: ast.Int(r.addr - sym)}
+}
+
+// relocate sets the fields of the given relocation records of the section to
+// the addresses they refer to, as by a linker, using the given byte order.
+// Records of the lower 12 bits of RISC-V PC-relative offsets refer to the
+// auipc instruction holding the upper bits, whose record must precede them.
+// The 4-byte and 8-byte absolute fields of data sections are recorded as
+// relocated data fields.
+func (sect *Section) relocate(order binary.ByteOrder, relocs []reloc) {
+	data := append([]byte(nil), sect.Data...)
+	// fieldOf returns the field of the given relocation record; or nil if
+	// out of bounds.
+	fieldOf := func(r reloc) []byte {
+		if r.off < 0 || r.off+int64(r.size) > int64(len(data)) {
+			return nil
+		}
+		return data[r.off : r.off+int64(r.size)]
+	}
+	// PC-relative offsets held by auipc instructions, by address.
+	hi := make(map[int64]int64)
+	for i, r := range relocs {
+		b := fieldOf(r)
+		if b == nil {
+			continue
+		}
+		pc := sect.Addr + r.off
+		addr := r.sym + r.addend
+		if r.implicit {
+			addr += implicit(order, b, r.typ)
+			if r.typ == symtab.MIPSHi16 {
+				// The lower 16 bits of the addend are held by the next record
+				// of the lower 16 bits of the address.
+				for _, lo := range relocs[i+1:] {
+					if b := fieldOf(lo); b != nil && lo.typ == symtab.MIPSLo16 && lo.sym == r.sym {
+						addr += implicit(order, b, lo.typ)
+						break
+					}
+				}
+			}
+		}
+		val := addr
+		if r.pcrel {
+			val -= pc
+		}
+		switch r.typ {
+		case symtab.Field:
+			put(order, b, uint64(val))
+			if !sect.Exec && !r.pcrel && (r.size == 4 || r.size == 8) {
+				if sect.relocs == nil {
+					sect.relocs = make(map[int64]dataReloc)
+				}
+				sect.relocs[r.off] = dataReloc{size: r.size, addr: addr}
+			}
+		case symtab.ARM64Branch26, symtab.MIPS26:
+			putBits(order, b, 0x3FFFFFF, val>>2)
+		case symtab.ARM64Page21:
+			page := addr>>12 - pc>>12
+			putBits(order, b, 3<<29|0x7FFFF<<5, page&3<<29|page>>2&0x7FFFF<<5)
+		case symtab.ARM64PageOff12:
+			off := val & 0xFFF
+			if w := order.Uint32(b); w&0x3B000000 == 0x39000000 {
+				// The offset of ldr and str is scaled by the size of the
+				// access.
+				off >>= w >> 30
+			}
+			putBits(order, b, 0xFFF<<10, off<<10)
+		case symtab.MIPSHi16:
+			// Adjusted for the sign extension of the lower 16 bits.
+			putBits(order, b, 0xFFFF, (val+0x8000)>>16)
+		case symtab.MIPSLo16:
+			putBits(order, b, 0xFFFF, val)
+		case symtab.RISCVCall:
+			putBits(order, b, 0xFFFFF000, val+0x800)
+			putBits(order, b[4:], 0xFFF<<20, val<<20)
+		case symtab.RISCVPCRelHi20:
+			hi[pc] = val
+			putBits(order, b, 0xFFFFF000, val+0x800)
+		case symtab.RISCVPCRelLo12I:
+			putBits(order, b, 0xFFF<<20, hi[addr]<<20)
+		}
+	}
+	sect.Data = data
+}
+
+// implicit returns the implicit addend held by the field b of the given
+// relocation type.
+func implicit(order binary.ByteOrder, b []byte, typ symtab.RelocType) int64 {
+	switch typ {
+	case symtab.Field:
+		switch len(b) {
+		case 1:
+			return int64(int8(b[0]))
+		case 2:
+			return int64(int16(order.Uint16(b)))
+		case 4:
+			return int64(int32(order.Uint32(b)))
+		case 8:
+			return int64(order.Uint64(b))
+		}
+	case symtab.ARM64Page21:
+		// Signed 21-bit immediate immhi:immlo of adrp.
+		w := order.Uint32(b)
+		return int64(int32((w>>29&3|w>>5&0x7FFFF<<2)<<11) >> 11)
+	case symtab.ARM64PageOff12:
+		return int64(order.Uint32(b) >> 10 & 0xFFF)
+	case symtab.MIPS26:
+		return int64(order.Uint32(b)&0x3FFFFFF) << 2
+	case symtab.MIPSHi16:
+		return int64(int32(order.Uint32(b) << 16))
+	case symtab.MIPSLo16:
+		return int64(int16(order.Uint32(b)))
+	}
+	// The fields of ARM64 branches of COFF objects hold no addend.
+	return 0
+}
+
+// put stores the value of a field of machine code or data in b, using the given
+// byte order.
+func put(order binary.ByteOrder, b []byte, val uint64) {
+	switch len(b) {
+	case 1:
+		b[0] = uint8(val)
+	case 2:
+		order.PutUint16(b, uint16(val))
+	case 4:
+		order.PutUint32(b, uint32(val))
+	case 8:
+		order.PutUint64(b, val)
+	}
+}
+
+// putBits stores the bits of val selected by mask in the 32-bit instruction b,
+// using the given byte order.
+func putBits(order binary.ByteOrder, b []byte, mask uint32, val int64) {
+	order.PutUint32(b, order.Uint32(b)&^mask|uint32(val)&mask)
+}
+
+// externAlign is the distance in bytes between the addresses of the undefined
+// symbols of relocatable objects.
+const externAlign = 16
+
+// An externs locates the undefined symbols of a relocatable object past the end
+// of its sections.
+type externs struct {
+	// Address of the next undefined symbol.
+	next int64
+	// addrs maps from name to the address of undefined symbols.
+	addrs map[string]int64
+}
+
+// newExterns returns a new locator of the undefined symbols of a relocatable
+// object, whose sections end at the given address.
+func newExterns(end int64) *externs {
+	return &externs{next: symtab.AlignUp(end, externAlign), addrs: make(map[string]int64)}
+}
+
+// addr returns the address of the named undefined symbol.
+func (e *externs) addr(name string) int64 {
+	if addr, ok := e.addrs[name]; ok {
+		return addr
+	}
+	addr := e.next
+	e.addrs[name] = addr
+	e.next += externAlign
+	return addr
+}
@@ -0,0 +1,333 @@
+package disasm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/ast"
+)
+
+// Fprint prints the assembly program to w, using the register names of the
+// given architecture. The output is accepted by the lexer and parser.
+func Fprint(w io.Writer, a arch.Arch, prog *ast.Program) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', tabwriter.TabIndent)
+	for _, line := range prog.Lines {
+		if line.Label != nil {
+			if _, err := fmt.Fprintf(tw, "%s:\n", line.Label.Name); err != nil {
+				return err
+			}
+		}
+		if line.Node == nil && len(line.Comment) == 0 {
+			continue
+		}
+		var s string
+		if line.Node != nil {
+			node, err := FormatNode(a, line.Node)
+			if err != nil {
+				return err
+			}
+			s = "\t" + node
+		}
+		if len(line.Comment) > 0 {
+			s += "\t" + line.Comment
+		}
+		if _, err := fmt.Fprintln(tw, s); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// Sprint returns the assembly program as a string, using the register names of
+// the given architecture.
+func Sprint(a arch.Arch, prog *ast.Program) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := Fprint(buf, a, prog); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// FormatNode returns the textual representation of the given instruction or
+// directive, using the register names of the given architecture.
+func FormatNode(a arch.Arch, node ast.Node) (string, error) {
+	switch node := node.(type) {
+	case *ast.Inst:
+		return formatList(string(node.Op), a, node.Args)
+	case *ast.SectionDir:
+		// Section names other than identifiers are quoted; e.g. .text is
+		// written as is, while __TEXT,__text is written as "__TEXT,__text".
+		if !isIdent(strings.TrimPrefix(node.Name, ".")) {
+			return "section " + strconv.Quote(node.Name), nil
+		}
+		return "section " + node.Name, nil
+	case *ast.GlobalDir:
+		return "global " + strings.Join(node.Names, ", "), nil
+	case *ast.ExternDir:
+		return "extern " + strings.Join(node.Names, ", "), nil
+	case *ast.AlignDir:
+		return formatList("align", a, []ast.Arg{node.Align})
+	case *ast.DataDir:
+		names := map[int]string{1: "db", 2: "dw", 4: "dd", 8: "dq"}
+		name, ok := names[node.Width]
+		if !ok {
+			return "", fmt.Errorf("disasm.FormatNode: invalid data width %d", node.Width)
+		}
+		return formatList(name, a, node.Vals)
+	case *ast.OrgDir:
+		return formatList("org", a, []ast.Arg{node.Addr})
+	case *ast.EquDir:
+		return formatList(node.Name+" equ", a, []ast.Arg{node.Val})
+	case *ast.PoolDir:
+		return "pool", nil
+	}
+	return "", fmt.Errorf("disasm.FormatNode: support for node type %T not yet implemented", node)
+}
+
+// formatList returns the given name followed by a comma-separated list of
+// operands.
+func formatList(name string, a arch.Arch, args []ast.Arg) (string, error) {
+	s := name
+	for i, arg := range args {
+		x, err := FormatArg(a, arg)
+		if err != nil {
+			return "", err
+		}
+		if i == 0 {
+			s += " " + x
+		} else {
+			s += ", " + x
+		}
+	}
+	return s, nil
+}
+
+// FormatArg returns the textual representation of the given operand, using the
+// register names of the given architecture. Addresses are formatted in
+// hexadecimal and other integers in decimal.
+func FormatArg(a arch.Arch, arg ast.Arg) (string, error) {
+	switch arg := arg.(type) {
+	case ast.Reg:
+		return a.RegName(arg), nil
+	case ast.Int:
+		return strconv.FormatInt(int64(arg), 10), nil
+	case ast.Uint:
+		return strconv.FormatUint(uint64(arg), 10), nil
+	case ast.Addr:
+		return fmt.Sprintf("0x%X", uint64(arg)), nil
+	case ast.Float:
+		s := strconv.FormatFloat(float64(arg), 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s, nil
+	case ast.Ident:
+		return string(arg), nil
+	case ast.String:
+		return strconv.Quote(string(arg)), nil
+	case *ast.ParenExpr:
+		x, err := FormatArg(a, arg.X)
+		if err != nil {
+			return "", err
+		}
+		return "(" + x + ")", nil
+	case *ast.UnaryExpr:
+		x, err := FormatArg(a, arg.X)
+		if err != nil {
+			return "", err
+		}
+		return arg.Op.String() + x, nil
+	case *ast.BinaryExpr:
+		x, err := FormatArg(a, arg.X)
+		if err != nil {
+			return "", err
+		}
+		y, err := FormatArg(a, arg.Y)
+		if err != nil {
+			return "", err
+		}
+		return x + " " + arg.Op.String() + " " + y, nil
+	case *ast.Mem:
+		mem, err := formatMem(a, arg)
+		if err != nil {
+			return "", err
+		}
+		if keyword, ok := sizeKeywords[arg.Size]; ok {
+			return keyword + " " + mem, nil
+		}
+		return mem, nil
+	case *ast.Imm:
+		x, err := FormatArg(a, arg.X)
+		if err != nil {
+			return "", err
+		}
+		return "#" + x, nil
+	case *ast.Literal:
+		x, err := FormatArg(a, arg.X)
+		if err != nil {
+			return "", err
+		}
+		return "=" + x, nil
+	case *ast.RegList:
+		var regs []string
+		for _, reg := range arg.Regs {
+			x, err := FormatArg(a, reg)
+			if err != nil {
+				return "", err
+			}
+			regs = append(regs, x)
+		}
+		return "{" + strings.Join(regs, ", ") + "}", nil
+	case *ast.ShiftedReg:
+		x, err := FormatArg(a, arg.X)
+		if err != nil {
+			return "", err
+		}
+		if arg.Amount == nil {
+			return x + ", " + arg.Op, nil
+		}
+		amount, err := FormatArg(a, arg.Amount)
+		if err != nil {
+			return "", err
+		}
+		return x + ", " + arg.Op + " #" + amount, nil
+	case *ast.Writeback:
+		x, err := FormatArg(a, arg.X)
+		if err != nil {
+			return "", err
+		}
+		return x + "!", nil
+	}
+	return "", fmt.Errorf("disasm.FormatArg: support for operand type %T not yet implemented", arg)
+}
+
+// sizeKeywords maps from the size in bits of memory locations to size keyword.
+var sizeKeywords = map[int]string{
+	8:  "byte",
+	16: "word",
+	32: "dword",
+	64: "qword",
+}
+
+// An OffsetMem is implemented by architectures which write memory operands
+// using the offset(base) syntax; such as 8(sp).
+type OffsetMem interface {
+	// OffsetMem returns true if memory operands are written using the
+	// offset(base) syntax.
+	OffsetMem() bool
+}
+
+// A CommaMem is implemented by architectures which write memory operands as a
+// comma-separated list of terms; such as [x0, x1, lsl #3].
+type CommaMem interface {
+	// CommaMem returns true if memory operands are written as a
+	// comma-separated list of terms.
+	CommaMem() bool
+}
+
+// formatMem returns the textual representation of the given memory operand;
+// such as [ebx + ecx*4 - 8], 8(sp) if the architecture implements OffsetMem,
+// or [x0, #16] if the architecture implements CommaMem.
+func formatMem(a arch.Arch, mem *ast.Mem) (string, error) {
+	if m, ok := a.(CommaMem); ok && m.CommaMem() && !mem.Rel {
+		return formatCommaMem(a, mem)
+	}
+	if m, ok := a.(OffsetMem); ok && m.OffsetMem() && mem.Base != nil && mem.Index == nil && !mem.Rel {
+		base, err := FormatArg(a, mem.Base)
+		if err != nil {
+			return "", err
+		}
+		if mem.Disp == nil {
+			return "(" + base + ")", nil
+		}
+		disp, err := FormatArg(a, mem.Disp)
+		if err != nil {
+			return "", err
+		}
+		return disp + "(" + base + ")", nil
+	}
+	var terms []string
+	if mem.Rel {
+		terms = append(terms, "rel")
+	}
+	if mem.Base != nil {
+		base, err := FormatArg(a, mem.Base)
+		if err != nil {
+			return "", err
+		}
+		terms = append(terms, base)
+	}
+	if mem.Index != nil {
+		index, err := FormatArg(a, mem.Index)
+		if err != nil {
+			return "", err
+		}
+		if mem.Scale > 1 {
+			index += "*" + strconv.Itoa(mem.Scale)
+		}
+		if len(terms) > 0 && !mem.Rel {
+			index = "+ " + index
+		}
+		terms = append(terms, index)
+	}
+	if mem.Disp != nil {
+		disp, err := FormatArg(a, mem.Disp)
+		if err != nil {
+			return "", err
+		}
+		if len(terms) > 0 && !mem.Rel {
+			// Subtract negative displacements; e.g. [ebp - 8].
+			if strings.HasPrefix(disp, "-") {
+				disp = "- " + disp[1:]
+			} else {
+				disp = "+ " + disp
+			}
+		}
+		terms = append(terms, disp)
+	}
+	return "[" + strings.Join(terms, " ") + "]", nil
+}
+
+// formatCommaMem returns the textual representation of the given memory
+// operand as a comma-separated list of terms; such as [x0, w1, sxtw #2].
+func formatCommaMem(a arch.Arch, mem *ast.Mem) (string, error) {
+	var terms []string
+	if mem.Base != nil {
+		base, err := FormatArg(a, mem.Base)
+		if err != nil {
+			return "", err
+		}
+		terms = append(terms, base)
+	}
+	if mem.Index != nil {
+		index, err := FormatArg(a, mem.Index)
+		if err != nil {
+			return "", err
+		}
+		extend := mem.Extend
+		if extend == "" && mem.Scale > 1 {
+			extend = "lsl"
+		}
+		if extend != "" {
+			index += ", " + extend
+			if mem.Scale != 0 {
+				index += " #" + strconv.Itoa(bits.TrailingZeros(uint(mem.Scale)))
+			}
+		}
+		terms = append(terms, index)
+	}
+	if mem.Disp != nil {
+		disp, err := FormatArg(a, mem.Disp)
+		if err != nil {
+			return "", err
+		}
+		terms = append(terms, "#"+disp)
+	}
+	return "[" + strings.Join(terms, ", ") + "]", nil
+}
@@ -0,0 +1,156 @@
+package riscv
+
+import (
+	riscvarch "github.com/mewlang/asm/arch/riscv"
+	"github.com/mewlang/asm/ast"
+)
+
+// A 16-bit compressed instruction.
+type half uint16
+
+// Fields of compressed instructions.
+func (c half) op() uint16      { return uint16(c) & 3 }
+func (c half) funct3() uint16  { return uint16(c) >> 13 }
+func (c half) rd() ast.Reg     { return ast.Reg(c >> 7 & 0x1F) }
+func (c half) rs2() ast.Reg    { return ast.Reg(c >> 2 & 0x1F) }
+func (c half) rdc() ast.Reg    { return ast.Reg(c>>2&7) + 8 }
+func (c half) rs1c() ast.Reg   { return ast.Reg(c>>7&7) + 8 }
+func (c half) bit(i uint) uint { return uint(c) >> i & 1 }
+
+// imm6 returns the sign-extended six-bit immediate of the CI format
+// instruction.
+func (c half) imm6() int64 {
+	imm := c.bit(12)<<5 | uint(c)>>2&0x1F
+	return int64(int8(imm<<2)) >> 2
+}
+
+// shamt returns the six-bit shift amount of the CI or CB format instruction.
+func (c half) shamt() int64 {
+	return int64(c.bit(12)<<5 | uint(c)>>2&0x1F)
+}
+
+// joff returns the jump offset of the CJ format instruction.
+func (c half) joff() int64 {
+	off := c.bit(12)<<11 | c.bit(11)<<4 | (uint(c)>>9&3)<<8 | c.bit(8)<<10 | c.bit(7)<<6 | c.bit(6)<<7 | (uint(c)>>3&7)<<1 | c.bit(2)<<5
+	return int64(int16(off<<4)) >> 4
+}
+
+// boff returns the branch offset of the CB format branch instruction.
+func (c half) boff() int64 {
+	off := c.bit(12)<<8 | (uint(c)>>10&3)<<3 | (uint(c)>>5&3)<<6 | (uint(c)>>3&3)<<1 | c.bit(2)<<5
+	return int64(int16(off<<7)) >> 7
+}
+
+// decodeCompressed decodes the compressed instruction c, which is located at
+// address pc. It returns false if c is not a valid compressed instruction.
+func decodeCompressed(c half, pc int64, xlen int) (inst ast.Inst, ok bool) {
+	rv64 := xlen == 64
+	sp := riscvarch.SP
+	// newInst returns the compressed instruction with the given mnemonic and
+	// operands.
+	newInst := func(op ast.Op, args ...ast.Arg) ast.Inst {
+		return ast.Inst{Op: op, Args: args}
+	}
+
+	switch c.op() {
+	case 0:
+		switch c.funct3() {
+		case 0:
+			imm := c.bit(12)<<5 | c.bit(11)<<4 | (uint(c)>>7&0xF)<<6 | c.bit(6)<<2 | c.bit(5)<<3
+			return newInst("c.addi4spn", c.rdc(), sp, ast.Int(imm)), imm != 0
+		case 2:
+			off := (uint(c)>>10&7)<<3 | c.bit(6)<<2 | c.bit(5)<<6
+			return newInst("c.lw", c.rdc(), mem(c.rs1c(), ast.Int(off))), true
+		case 3:
+			off := (uint(c)>>10&7)<<3 | (uint(c)>>5&3)<<6
+			return newInst("c.ld", c.rdc(), mem(c.rs1c(), ast.Int(off))), rv64
+		case 6:
+			off := (uint(c)>>10&7)<<3 | c.bit(6)<<2 | c.bit(5)<<6
+			return newInst("c.sw", c.rdc(), mem(c.rs1c(), ast.Int(off))), true
+		case 7:
+			off := (uint(c)>>10&7)<<3 | (uint(c)>>5&3)<<6
+			return newInst("c.sd", c.rdc(), mem(c.rs1c(), ast.Int(off))), rv64
+		}
+	case 1:
+		switch c.funct3() {
+		case 0:
+			if c.rd() == 0 {
+				return newInst("c.nop"), c.imm6() == 0
+			}
+			return newInst("c.addi", c.rd(), ast.Int(c.imm6())), c.imm6() != 0
+		case 1:
+			if rv64 {
+				return newInst("c.addiw", c.rd(), ast.Int(c.imm6())), c.rd() != 0
+			}
+			return newInst("c.jal", ast.Addr(pc+c.joff())), true
+		case 2:
+			return newInst("c.li", c.rd(), ast.Int(c.imm6())), c.rd() != 0
+		case 3:
+			switch c.rd() {
+			case 0:
+				return inst, false
+			case sp:
+				imm := c.bit(12)<<9 | c.bit(6)<<4 | c.bit(5)<<6 | (uint(c)>>3&3)<<7 | c.bit(2)<<5
+				off := int64(int16(imm<<6)) >> 6
+				return newInst("c.addi16sp", sp, ast.Int(off)), off != 0
+			}
+			// The immediate holds bits 17:12 of the sign-extended value, and is
+			// written as the 20-bit immediate of lui.
+			return newInst("c.lui", c.rd(), ast.Int(c.imm6()&0xFFFFF)), c.imm6() != 0
+		case 4:
+			switch uint(c) >> 10 & 3 {
+			case 0:
+				return newInst("c.srli", c.rs1c(), ast.Int(c.shamt())), c.shamt() != 0 && (rv64 || c.bit(12) == 0)
+			case 1:
+				return newInst("c.srai", c.rs1c(), ast.Int(c.shamt())), c.shamt() != 0 && (rv64 || c.bit(12) == 0)
+			case 2:
+				return newInst("c.andi", c.rs1c(), ast.Int(c.imm6())), true
+			}
+			funct2 := uint(c) >> 5 & 3
+			if c.bit(12) == 0 {
+				op := [4]ast.Op{"c.sub", "c.xor", "c.or", "c.and"}[funct2]
+				return newInst(op, c.rs1c(), c.rdc()), true
+			}
+			op := [4]ast.Op{0: "c.subw", 1: "c.addw"}[funct2]
+			return newInst(op, c.rs1c(), c.rdc()), rv64 && op != ""
+		case 5:
+			return newInst("c.j", ast.Addr(pc+c.joff())), true
+		case 6:
+			return newInst("c.beqz", c.rs1c(), ast.Addr(pc+c.boff())), true
+		case 7:
+			return newInst("c.bnez", c.rs1c(), ast.Addr(pc+c.boff())), true
+		}
+	case 2:
+		switch c.funct3() {
+		case 0:
+			ok := c.rd() != 0 && c.shamt() != 0 && (rv64 || c.bit(12) == 0)
+			return newInst("c.slli", c.rd(), ast.Int(c.shamt())), ok
+		case 2:
+			off := c.bit(12)<<5 | (uint(c)>>4&7)<<2 | (uint(c)>>2&3)<<6
+			return newInst("c.lwsp", c.rd(), mem(sp, ast.Int(off))), c.rd() != 0
+		case 3:
+			off := c.bit(12)<<5 | (uint(c)>>5&3)<<3 | (uint(c)>>2&7)<<6
+			return newInst("c.ldsp", c.rd(), mem(sp, ast.Int(off))), rv64 && c.rd() != 0
+		case 4:
+			rd, rs2 := c.rd(), c.rs2()
+			switch {
+			case c.bit(12) == 0 && rs2 == 0:
+				return newInst("c.jr", rd), rd != 0
+			case c.bit(12) == 0:
+				return newInst("c.mv", rd, rs2), rd != 0
+			case rd == 0 && rs2 == 0:
+				return newInst("c.ebreak"), true
+			case rs2 == 0:
+				return newInst("c.jalr", rd), true
+			}
+			return newInst("c.add", rd, rs2), rd != 0
+		case 6:
+			off := (uint(c)>>9&0xF)<<2 | (uint(c)>>7&3)<<6
+			return newInst("c.swsp", c.rs2(), mem(sp, ast.Int(off))), true
+		case 7:
+			off := (uint(c)>>10&7)<<3 | (uint(c)>>7&7)<<6
+			return newInst("c.sdsp", c.rs2(), mem(sp, ast.Int(off))), rv64
+		}
+	}
+	return inst, false
+}
@@ -0,0 +1,38 @@
+package riscv_test
+
+import (
+	"log"
+	"os"
+
+	"github.com/mewlang/asm/disasm"
+	"github.com/mewlang/asm/disasm/riscv"
+)
+
+func ExampleDecoder() {
+	src := []byte{
+		0x41, 0x11, // c.addi sp, -16
+		0x06, 0xC6, // c.swsp ra, 12(sp)
+		0x83, 0x45, 0x05, 0x00, // loop: lbu a1, 0(a0)
+		0x99, 0xC1, // c.beqz a1, done
+		0x05, 0x05, // c.addi a0, 1
+		0xE5, 0xBF, // c.j loop
+		0x82, 0x80, // done: c.jr ra
+		0x00, 0x00, // illegal instruction
+	}
+	prog := disasm.Disassemble(riscv.Decoder, src, 0x10000)
+	if err := disasm.Fprint(os.Stdout, riscv.Decoder.Arch(), prog); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	//	org 0x10000
+	//	c.addi sp, -16    ; 00010000: 4111
+	//	c.swsp ra, 12(sp) ; 00010002: 06C6
+	// loc_00010004:
+	//	lbu a1, 0(a0)           ; 00010004: 83450500
+	//	c.beqz a1, loc_0001000E ; 00010008: 99C1
+	//	c.addi a0, 1            ; 0001000A: 0505
+	//	c.j loc_00010004        ; 0001000C: E5BF
+	// loc_0001000E:
+	//	c.jr ra ; 0001000E: 8280
+	//	db 0, 0 ; 00010010: riscv.Decode: invalid compressed instruction 0x0000
+}
@@ -0,0 +1,321 @@
+// Package riscv implements a RISC-V instruction decoder for the RV32I and RV64I
+// base integer instruction sets, with the M, A and C standard extensions.
+//
+// The decoder is the inverse of the asm/riscv encoders; every decoded
+// instruction encodes back into the same machine code. Compressed instructions
+// are decoded into their c. prefixed mnemonics, and no other pseudo-instructions
+// are used. Instructions with non-zero fields which are required to be zero,
+// and compressed instructions which are reserved or hints, are reported as
+// invalid.
+package riscv
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mewlang/asm/arch"
+	riscvarch "github.com/mewlang/asm/arch/riscv"
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/disasm"
+)
+
+// Instruction decoders of the base integer instruction sets.
+var (
+	// Decoder is the RV32I instruction decoder.
+	Decoder disasm.Decoder = decoder{xlen: 32}
+	// Decoder64 is the RV64I instruction decoder.
+	Decoder64 disasm.Decoder = decoder{xlen: 64}
+)
+
+// decoder implements the disasm.Decoder interface for RISC-V.
+type decoder struct {
+	// Width in bits of the general purpose registers; either 32 or 64.
+	xlen int
+}
+
+// Arch returns the RISC-V architecture of the base integer instruction set.
+func (dec decoder) Arch() arch.Arch {
+	if dec.xlen == 64 {
+		return riscvarch.Arch64
+	}
+	return riscvarch.Arch
+}
+
+// Decode decodes the first little-endian instruction of src, which is located
+// at address pc, and returns the instruction and its length in bytes; either 2
+// or 4. Branch and jump targets are represented by ast.Addr operands.
+func (dec decoder) Decode(src []byte, pc int64) (inst ast.Inst, n int, err error) {
+	if len(src) < 2 {
+		return inst, len(src), fmt.Errorf("riscv.Decode: truncated instruction; expected 2 bytes, got %d", len(src))
+	}
+	if src[0]&3 != 3 {
+		c := half(binary.LittleEndian.Uint16(src))
+		inst, ok := decodeCompressed(c, pc, dec.xlen)
+		if !ok {
+			return inst, 2, fmt.Errorf("riscv.Decode: invalid compressed instruction 0x%04X", uint16(c))
+		}
+		return inst, 2, nil
+	}
+	if src[0]&0x1F == 0x1F {
+		return inst, 2, fmt.Errorf("riscv.Decode: unsupported instruction length; first parcel 0x%04X", binary.LittleEndian.Uint16(src))
+	}
+	if len(src) < 4 {
+		return inst, len(src), fmt.Errorf("riscv.Decode: truncated instruction; expected 4 bytes, got %d", len(src))
+	}
+	w := word(binary.LittleEndian.Uint32(src))
+	inst, ok := decodeWord(w, pc, dec.xlen)
+	if !ok {
+		return inst, 4, fmt.Errorf("riscv.Decode: invalid instruction word 0x%08X", uint32(w))
+	}
+	return inst, 4, nil
+}
+
+// Mnemonics of R format instructions indexed by funct7, funct3 and whether the
+// opcode is OP-32.
+var regOps = map[[3]uint32]ast.Op{
+	{0x00, 0, 0}: "add",
+	{0x20, 0, 0}: "sub",
+	{0x00, 1, 0}: "sll",
+	{0x00, 2, 0}: "slt",
+	{0x00, 3, 0}: "sltu",
+	{0x00, 4, 0}: "xor",
+	{0x00, 5, 0}: "srl",
+	{0x20, 5, 0}: "sra",
+	{0x00, 6, 0}: "or",
+	{0x00, 7, 0}: "and",
+	{0x01, 0, 0}: "mul",
+	{0x01, 1, 0}: "mulh",
+	{0x01, 2, 0}: "mulhsu",
+	{0x01, 3, 0}: "mulhu",
+	{0x01, 4, 0}: "div",
+	{0x01, 5, 0}: "divu",
+	{0x01, 6, 0}: "rem",
+	{0x01, 7, 0}: "remu",
+	{0x00, 0, 1}: "addw",
+	{0x20, 0, 1}: "subw",
+	{0x00, 1, 1}: "sllw",
+	{0x00, 5, 1}: "srlw",
+	{0x20, 5, 1}: "sraw",
+	{0x01, 0, 1}: "mulw",
+	{0x01, 4, 1}: "divw",
+	{0x01, 5, 1}: "divuw",
+	{0x01, 6, 1}: "remw",
+	{0x01, 7, 1}: "remuw",
+}
+
+// Mnemonics indexed by funct3.
+var (
+	immOps    = [8]ast.Op{0: "addi", 2: "slti", 3: "sltiu", 4: "xori", 6: "ori", 7: "andi"}
+	loadOps   = [8]ast.Op{0: "lb", 1: "lh", 2: "lw", 3: "ld", 4: "lbu", 5: "lhu", 6: "lwu"}
+	storeOps  = [8]ast.Op{0: "sb", 1: "sh", 2: "sw", 3: "sd"}
+	branchOps = [8]ast.Op{0: "beq", 1: "bne", 4: "blt", 5: "bge", 6: "bltu", 7: "bgeu"}
+	csrOps    = [8]ast.Op{1: "csrrw", 2: "csrrs", 3: "csrrc", 5: "csrrwi", 6: "csrrsi", 7: "csrrci"}
+)
+
+// Mnemonics of atomic memory operations indexed by funct5.
+var amoOps = [32]string{
+	0x00: "amoadd",
+	0x01: "amoswap",
+	0x02: "lr",
+	0x03: "sc",
+	0x04: "amoxor",
+	0x08: "amoor",
+	0x0C: "amoand",
+	0x10: "amomin",
+	0x14: "amomax",
+	0x18: "amominu",
+	0x1C: "amomaxu",
+}
+
+// Major opcodes of 32-bit instructions.
+const (
+	opLoad    = 0x03
+	opMiscMem = 0x0F
+	opImm     = 0x13
+	opAuipc   = 0x17
+	opImm32   = 0x1B
+	opStore   = 0x23
+	opAMO     = 0x2F
+	opOp      = 0x33
+	opLui     = 0x37
+	opOp32    = 0x3B
+	opBranch  = 0x63
+	opJalr    = 0x67
+	opJal     = 0x6F
+	opSystem  = 0x73
+)
+
+// A 32-bit instruction word.
+type word uint32
+
+// Fields of instruction words.
+func (w word) opcode() uint32 { return uint32(w) & 0x7F }
+func (w word) rd() ast.Reg    { return ast.Reg(w >> 7 & 0x1F) }
+func (w word) funct3() uint32 { return uint32(w) >> 12 & 7 }
+func (w word) rs1() ast.Reg   { return ast.Reg(w >> 15 & 0x1F) }
+func (w word) rs2() ast.Reg   { return ast.Reg(w >> 20 & 0x1F) }
+func (w word) funct7() uint32 { return uint32(w) >> 25 }
+func (w word) iimm() ast.Int  { return ast.Int(int32(w) >> 20) }
+func (w word) simm() ast.Int  { return ast.Int(int32(w)>>25<<5 | int32(w>>7&0x1F)) }
+
+// bit returns bit i of x.
+func bit(x uint32, i uint) uint32 {
+	return x >> i & 1
+}
+
+// boff returns the branch offset of the B format instruction word.
+func (w word) boff() int64 {
+	x := uint32(w)
+	off := bit(x, 31)<<12 | bit(x, 7)<<11 | (x>>25&0x3F)<<5 | (x>>8&0xF)<<1
+	return int64(int32(off<<19) >> 19)
+}
+
+// joff returns the jump offset of the J format instruction word.
+func (w word) joff() int64 {
+	x := uint32(w)
+	off := bit(x, 31)<<20 | (x>>12&0xFF)<<12 | bit(x, 20)<<11 | (x>>21&0x3FF)<<1
+	return int64(int32(off<<11) >> 11)
+}
+
+// mem returns the memory operand with the given base register and offset.
+func mem(base ast.Reg, off ast.Int) *ast.Mem {
+	return &ast.Mem{Base: base, Disp: off}
+}
+
+// decodeWord decodes the 32-bit instruction word w, which is located at
+// address pc. It returns false if w is not a valid instruction word.
+func decodeWord(w word, pc int64, xlen int) (inst ast.Inst, ok bool) {
+	rv64 := xlen == 64
+	funct3 := w.funct3()
+	switch w.opcode() {
+	case opLui:
+		return ast.Inst{Op: "lui", Args: []ast.Arg{w.rd(), ast.Int(w >> 12)}}, true
+	case opAuipc:
+		return ast.Inst{Op: "auipc", Args: []ast.Arg{w.rd(), ast.Int(w >> 12)}}, true
+	case opJal:
+		return ast.Inst{Op: "jal", Args: []ast.Arg{w.rd(), ast.Addr(pc + w.joff())}}, true
+	case opJalr:
+		return ast.Inst{Op: "jalr", Args: []ast.Arg{w.rd(), mem(w.rs1(), w.iimm())}}, funct3 == 0
+	case opBranch:
+		op := branchOps[funct3]
+		return ast.Inst{Op: op, Args: []ast.Arg{w.rs1(), w.rs2(), ast.Addr(pc + w.boff())}}, op != ""
+	case opLoad:
+		op := loadOps[funct3]
+		if (op == "ld" || op == "lwu") && !rv64 {
+			return inst, false
+		}
+		return ast.Inst{Op: op, Args: []ast.Arg{w.rd(), mem(w.rs1(), w.iimm())}}, op != ""
+	case opStore:
+		op := storeOps[funct3]
+		if op == "sd" && !rv64 {
+			return inst, false
+		}
+		return ast.Inst{Op: op, Args: []ast.Arg{w.rs2(), mem(w.rs1(), w.simm())}}, op != ""
+	case opImm:
+		switch funct3 {
+		case 1, 5:
+			// Shifts; the upper bits of the immediate hold the shift type and
+			// shift amounts of 32 and above are only valid on RV64.
+			shamt, hi := uint32(w>>20&0x3F), w.funct7()>>1
+			if !rv64 && shamt >= 32 {
+				return inst, false
+			}
+			var op ast.Op
+			switch {
+			case funct3 == 1 && hi == 0:
+				op = "slli"
+			case funct3 == 5 && hi == 0:
+				op = "srli"
+			case funct3 == 5 && hi == 0x10:
+				op = "srai"
+			default:
+				return inst, false
+			}
+			return ast.Inst{Op: op, Args: []ast.Arg{w.rd(), w.rs1(), ast.Int(shamt)}}, true
+		}
+		return ast.Inst{Op: immOps[funct3], Args: []ast.Arg{w.rd(), w.rs1(), w.iimm()}}, true
+	case opImm32:
+		if !rv64 {
+			return inst, false
+		}
+		shamt := ast.Int(w >> 20 & 0x1F)
+		switch {
+		case funct3 == 0:
+			return ast.Inst{Op: "addiw", Args: []ast.Arg{w.rd(), w.rs1(), w.iimm()}}, true
+		case funct3 == 1 && w.funct7() == 0:
+			return ast.Inst{Op: "slliw", Args: []ast.Arg{w.rd(), w.rs1(), shamt}}, true
+		case funct3 == 5 && w.funct7() == 0:
+			return ast.Inst{Op: "srliw", Args: []ast.Arg{w.rd(), w.rs1(), shamt}}, true
+		case funct3 == 5 && w.funct7() == 0x20:
+			return ast.Inst{Op: "sraiw", Args: []ast.Arg{w.rd(), w.rs1(), shamt}}, true
+		}
+		return inst, false
+	case opOp, opOp32:
+		var op32 uint32
+		if w.opcode() == opOp32 {
+			if !rv64 {
+				return inst, false
+			}
+			op32 = 1
+		}
+		op, ok := regOps[[3]uint32{w.funct7(), funct3, op32}]
+		return ast.Inst{Op: op, Args: []ast.Arg{w.rd(), w.rs1(), w.rs2()}}, ok
+	case opMiscMem:
+		// Only the fence instructions without fence mode and register operands
+		// are supported.
+		if w.rd() != 0 || w.rs1() != 0 {
+			return inst, false
+		}
+		switch {
+		case funct3 == 0 && w>>20 == 0xFF:
+			return ast.Inst{Op: "fence"}, true
+		case funct3 == 0 && w>>28 == 0 && w>>24&0xF != 0 && w>>20&0xF != 0:
+			pred, succ := fenceSet(uint32(w>>24&0xF)), fenceSet(uint32(w>>20&0xF))
+			return ast.Inst{Op: "fence", Args: []ast.Arg{pred, succ}}, true
+		case funct3 == 1 && w>>20 == 0:
+			return ast.Inst{Op: "fence.i"}, true
+		}
+		return inst, false
+	case opSystem:
+		if funct3 == 0 {
+			switch w {
+			case 0x00000073:
+				return ast.Inst{Op: "ecall"}, true
+			case 0x00100073:
+				return ast.Inst{Op: "ebreak"}, true
+			}
+			return inst, false
+		}
+		op := csrOps[funct3]
+		var src ast.Arg = w.rs1()
+		if funct3 >= 5 {
+			src = ast.Int(w.rs1())
+		}
+		return ast.Inst{Op: op, Args: []ast.Arg{w.rd(), ast.Int(w >> 20), src}}, op != ""
+	case opAMO:
+		name := amoOps[w.funct7()>>2]
+		if name == "" || funct3 != 2 && !(funct3 == 3 && rv64) {
+			return inst, false
+		}
+		op := name + map[uint32]string{2: ".w", 3: ".d"}[funct3]
+		op += [4]string{"", ".rl", ".aq", ".aqrl"}[w.funct7()&3]
+		addr := &ast.Mem{Base: w.rs1()}
+		if name == "lr" {
+			return ast.Inst{Op: ast.Op(op), Args: []ast.Arg{w.rd(), addr}}, w.rs2() == 0
+		}
+		return ast.Inst{Op: ast.Op(op), Args: []ast.Arg{w.rd(), w.rs2(), addr}}, true
+	}
+	return inst, false
+}
+
+// fenceSet returns the textual representation of the given set of memory and
+// I/O operations of a fence instruction.
+func fenceSet(set uint32) ast.Ident {
+	var s []byte
+	for i, c := range "iorw" {
+		if set&(8>>uint(i)) != 0 {
+			s = append(s, byte(c))
+		}
+	}
+	return ast.Ident(s)
+}
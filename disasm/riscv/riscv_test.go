@@ -0,0 +1,254 @@
+// The expected instructions of the test cases in this file have been verified
+// using llvm-mc.
+
+package riscv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/mewlang/asm/asm"
+	riscvasm "github.com/mewlang/asm/asm/riscv"
+	"github.com/mewlang/asm/disasm"
+	"github.com/mewlang/asm/internal/asmtest"
+)
+
+func TestDecode(t *testing.T) {
+	golden := []struct {
+		dec  disasm.Decoder
+		in   string
+		want string
+		err  string
+	}{
+		// i=0
+		{dec: Decoder64, in: "37453412", want: "lui a0, 74564"},
+		// i=1
+		{dec: Decoder64, in: "97120000", want: "auipc t0, 1"},
+		// i=2
+		{dec: Decoder64, in: "ef008000", want: "jal ra, 0x8"},
+		// i=3
+		{dec: Decoder64, in: "e7008500", want: "jalr ra, 8(a0)"},
+		// i=4
+		{dec: Decoder64, in: "e31804fe", want: "bne s0, zero, 0xFFFFFFFFFFFFFFF0"},
+		// i=5
+		{dec: Decoder64, in: "03358100", want: "ld a0, 8(sp)"},
+		// i=6
+		{dec: Decoder64, in: "0305f1ff", want: "lb a0, -1(sp)"},
+		// i=7
+		{dec: Decoder64, in: "233c1100", want: "sd ra, 24(sp)"},
+		// i=8
+		{dec: Decoder64, in: "1385f5ff", want: "addi a0, a1, -1"},
+		// i=9
+		{dec: Decoder64, in: "13d53540", want: "srai a0, a1, 3"},
+		// i=10
+		{dec: Decoder64, in: "1b85b5ff", want: "addiw a0, a1, -5"},
+		// i=11
+		{dec: Decoder64, in: "3b85c540", want: "subw a0, a1, a2"},
+		// i=12
+		{dec: Decoder64, in: "0f00f00f", want: "fence"},
+		// i=13
+		{dec: Decoder64, in: "0f001003", want: "fence rw, w"},
+		// i=14
+		{dec: Decoder64, in: "0f100000", want: "fence.i"},
+		// i=15
+		{dec: Decoder64, in: "73000000", want: "ecall"},
+		// i=16
+		{dec: Decoder64, in: "73001000", want: "ebreak"},
+		// i=17
+		{dec: Decoder64, in: "73d50230", want: "csrrwi a0, 768, 5"},
+		// i=18
+		{dec: Decoder64, in: "33a5c502", want: "mulhsu a0, a1, a2"},
+		// i=19
+		{dec: Decoder64, in: "2fb5c51c", want: "sc.d.aq a0, a2, (a1)"},
+		// i=20
+		{dec: Decoder64, in: "2fa5c50e", want: "amoswap.w.aqrl a0, a2, (a1)"},
+		// i=21
+		{dec: Decoder64, in: "4101", want: "c.addi sp, 16"},
+		// i=22
+		{dec: Decoder64, in: "3971", want: "c.addi16sp sp, -64"},
+		// i=23
+		{dec: Decoder64, in: "0808", want: "c.addi4spn a0, sp, 16"},
+		// i=24
+		{dec: Decoder64, in: "2e85", want: "c.mv a0, a1"},
+		// i=25
+		{dec: Decoder64, in: "1545", want: "c.li a0, 5"},
+		// i=26
+		{dec: Decoder64, in: "9a92", want: "c.add t0, t1"},
+		// i=27
+		{dec: Decoder64, in: "0d8d", want: "c.sub a0, a1"},
+		// i=28
+		{dec: Decoder64, in: "7d89", want: "c.andi a0, 31"},
+		// i=29
+		{dec: Decoder64, in: "757d", want: "c.lui s10, 1048573"},
+		// i=30
+		{dec: Decoder64, in: "8280", want: "c.jr ra"},
+		// i=31
+		{dec: Decoder64, in: "0100", want: "c.nop"},
+		// i=32
+		{dec: Decoder64, in: "0290", want: "c.ebreak"},
+		// i=33
+		{dec: Decoder64, in: "e5bf", want: "c.j 0xFFFFFFFFFFFFFFF8"},
+		// i=34
+		{dec: Decoder64, in: "99c1", want: "c.beqz a1, 0x6"},
+		// i=35
+		{dec: Decoder64, in: "b240", want: "c.lwsp ra, 12(sp)"},
+		// i=36
+		{dec: Decoder64, in: "06c6", want: "c.swsp ra, 12(sp)"},
+		// i=37
+		{dec: Decoder64, in: "4c41", want: "c.lw a1, 4(a0)"},
+		// i=38
+		{dec: Decoder64, in: "2265", want: "c.ldsp a0, 8(sp)"},
+		// i=39
+		{dec: Decoder64, in: "2d35", want: "c.addiw a0, -21"},
+		// i=40
+		{dec: Decoder, in: "e53f", want: "c.jal 0xFFFFFFFFFFFFFFF8"},
+		// i=41
+		{dec: Decoder, in: "13007000", want: "addi zero, zero, 7"},
+		// i=42
+		{dec: Decoder, in: "03358100", err: "riscv.Decode: invalid instruction word 0x00813503"},
+		// i=43
+		{dec: Decoder, in: "1395f503", err: "riscv.Decode: invalid instruction word 0x03F59513"},
+		// i=44
+		{dec: Decoder, in: "2265", err: "riscv.Decode: invalid compressed instruction 0x6522"},
+		// i=45
+		{dec: Decoder64, in: "0000", err: "riscv.Decode: invalid compressed instruction 0x0000"},
+		// i=46
+		{dec: Decoder64, in: "0101", err: "riscv.Decode: invalid compressed instruction 0x0101"},
+		// i=47
+		{dec: Decoder64, in: "5150", err: "riscv.Decode: invalid compressed instruction 0x5051"},
+		// i=48
+		{dec: Decoder64, in: "7300a000", err: "riscv.Decode: invalid instruction word 0x00A00073"},
+		// i=49
+		{dec: Decoder64, in: "ff", err: "riscv.Decode: truncated instruction; expected 2 bytes, got 1"},
+		// i=50
+		{dec: Decoder64, in: "1305", err: "riscv.Decode: truncated instruction; expected 4 bytes, got 2"},
+		// i=51
+		{dec: Decoder64, in: "1f000000", err: "riscv.Decode: unsupported instruction length; first parcel 0x001F"},
+	}
+
+	for i, g := range golden {
+		src, err := hex.DecodeString(g.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inst, n, err := g.dec.Decode(src, 0)
+		if len(g.err) > 0 {
+			if err == nil {
+				t.Errorf("i=%d: expected error %q, got nil", i, g.err)
+			} else if got := err.Error(); got != g.err {
+				t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.err, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if n != len(src) {
+			t.Errorf("i=%d: length mismatch; expected %d, got %d", i, len(src), n)
+		}
+		got, err := disasm.FormatNode(g.dec.Arch(), &inst)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if got != g.want {
+			t.Errorf("i=%d: instruction mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	// The programs are assembled with compression, and the disassembly is
+	// assembled without; explicitly compressed instructions remain compressed.
+	golden := []struct {
+		enc, reenc asm.Encoder
+		dec        disasm.Decoder
+		src        string
+	}{
+		// i=0
+		{
+			enc:   riscvasm.EncoderC,
+			reenc: riscvasm.Encoder,
+			dec:   Decoder,
+			src: `
+	org 0x10000
+main:
+	addi sp, sp, -16
+	sw ra, 12(sp)
+	la a0, msg
+	call puts
+	li a0, 0x12345678
+	lw ra, 12(sp)
+	addi sp, sp, 16
+	ret
+puts:
+	lbu a1, 0(a0)
+	beqz a1, done
+	addi a0, a0, 1
+	j puts
+done:
+	mul a0, a0, a1
+	amoadd.w.aq a0, a1, (a2)
+	fence rw, rw
+	csrrs a0, 0xC00, zero
+	jal main
+	tail main
+msg:
+	db "hi", 0
+`,
+		},
+		// i=1
+		{
+			enc:   riscvasm.Encoder64C,
+			reenc: riscvasm.Encoder64,
+			dec:   Decoder64,
+			src: `
+	org 0x10000
+_start:
+	addi sp, sp, -32
+	sd ra, 24(sp)
+	sd s0, 16(sp)
+	li s0, 0x123456789ABCDEF0
+	mv a0, s0
+loop:
+	addiw a0, a0, -1
+	sext.w a1, a0
+	slli a1, a1, 40
+	bnez a0, loop
+	subw a0, a0, a1
+	ld s0, 16(sp)
+	ld ra, 24(sp)
+	addi sp, sp, 32
+	lr.d a0, (a1)
+	sc.d.rl a2, a0, (a1)
+	ecall
+	ebreak
+	ret
+`,
+		},
+	}
+
+	for i, g := range golden {
+		want, err := asmtest.Text(g.enc, g.src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		prog := disasm.Disassemble(g.dec, want, 0x10000)
+		out, err := disasm.Sprint(g.dec.Arch(), prog)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		got, err := asmtest.Text(g.reenc, out)
+		if err != nil {
+			t.Errorf("i=%d: unable to assemble disassembly; %v\n%s", i, err, out)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("i=%d: round-trip mismatch; expected %x, got %x\n%s", i, want, got, out)
+		}
+	}
+}
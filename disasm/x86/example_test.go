@@ -0,0 +1,34 @@
+package x86_test
+
+import (
+	"log"
+	"os"
+
+	"github.com/mewlang/asm/disasm"
+	"github.com/mewlang/asm/disasm/x86"
+)
+
+func ExampleDecoder64() {
+	src := []byte{
+		0x48, 0x8D, 0x35, 0x07, 0x00, 0x00, 0x00, // lea rsi, [rel ret]
+		0x48, 0xFF, 0xC9, // dec rcx
+		0x75, 0xF4, // jnz start
+		0x0F, 0x05, // syscall
+		0xC3, // ret
+		0x06, // push es; invalid in 64-bit mode
+	}
+	prog := disasm.Disassemble(x86.Decoder64, src, 0x401000)
+	if err := disasm.Fprint(os.Stdout, x86.Decoder64.Arch(), prog); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	//	org 0x401000
+	// loc_00401000:
+	//	lea rsi, [rel loc_0040100E] ; 00401000: 488D3507000000
+	//	dec rcx                     ; 00401007: 48FFC9
+	//	jne loc_00401000            ; 0040100A: 75F4
+	//	syscall                     ; 0040100C: 0F05
+	// loc_0040100E:
+	//	ret  ; 0040100E: C3
+	//	db 6 ; 0040100F: x86.Decode: invalid opcode 0x06
+}
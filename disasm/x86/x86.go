@@ -0,0 +1,636 @@
+// Package x86 implements a table-driven x86 and x86-64 instruction decoder for
+// the Intel syntax of NASM.
+//
+// The decoder is the inverse of the asm/x86 encoder; every decoded instruction
+// is accepted by the operation table of the architecture and encodes back into
+// the same machine code, provided that the machine code uses the shortest
+// encoding. Instructions which cannot be represented in the assembly language
+// are reported as unsupported.
+package x86
+
+import (
+	"fmt"
+
+	"github.com/mewlang/asm/arch"
+	x86arch "github.com/mewlang/asm/arch/x86"
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/disasm"
+)
+
+// Instruction decoders of the processor modes.
+var (
+	// Decoder is the x86 instruction decoder for 32-bit mode.
+	Decoder disasm.Decoder = decoder{bits: 32}
+	// Decoder16 is the x86 instruction decoder for 16-bit mode. Memory operands
+	// using 16-bit addressing are not supported.
+	Decoder16 disasm.Decoder = decoder{bits: 16}
+	// Decoder64 is the x86-64 instruction decoder for 64-bit mode.
+	Decoder64 disasm.Decoder = decoder{bits: 64}
+)
+
+// decoder implements the disasm.Decoder interface for x86.
+type decoder struct {
+	// Processor mode; either 16, 32 or 64.
+	bits int
+}
+
+// Arch returns the x86 architecture of the processor mode.
+func (dec decoder) Arch() arch.Arch {
+	if dec.bits == 64 {
+		return x86arch.Arch64
+	}
+	return x86arch.Arch
+}
+
+// Decode decodes the first instruction of src, which is located at address pc,
+// and returns the instruction and its length in bytes. Branch targets and the
+// addresses of RIP-relative memory operands are represented by ast.Addr
+// operands.
+func (dec decoder) Decode(src []byte, pc int64) (inst ast.Inst, n int, err error) {
+	d := &decoding{src: src, pc: pc, bits: dec.bits}
+	inst, err = d.decode()
+	if d.truncated {
+		return ast.Inst{}, len(src), fmt.Errorf("x86.Decode: truncated instruction of %d bytes", len(src))
+	}
+	if err != nil {
+		return ast.Inst{}, 1, err
+	}
+	if err := dec.Arch().Validate(&inst); err != nil {
+		return ast.Inst{}, d.off, fmt.Errorf("x86.Decode: unsupported operands of %q", inst.Op)
+	}
+	return inst, d.off, nil
+}
+
+// An operand specifies the kind of an instruction operand, and how it is
+// encoded.
+type operand uint8
+
+// Operand kinds.
+const (
+	// 8-bit register or memory operand of the r/m field of the ModRM byte.
+	rm8 operand = iota + 1
+	// 16-bit register or memory operand of the r/m field.
+	rm16
+	// 32-bit register or memory operand of the r/m field.
+	rm32
+	// Register or memory operand of the r/m field, of the operand size.
+	rmv
+	// Memory operand of the r/m field.
+	mem
+	// 8-bit register of the reg field of the ModRM byte.
+	reg8
+	// Register of the reg field, of the operand size.
+	regv
+	// 8-bit register of the three lowest bits of the opcode.
+	op8
+	// Register of the three lowest bits of the opcode, of the operand size.
+	opv
+	// The al register.
+	al
+	// The accumulator register of the operand size; ax, eax or rax.
+	acc
+	// The cl register.
+	cl
+	// The constant 1 of the shift and rotate instructions.
+	one
+	// Unsigned 8-bit immediate.
+	uimm8
+	// Unsigned 16-bit immediate.
+	uimm16
+	// Sign-extended 8-bit immediate.
+	simm8
+	// Immediate of the operand size; a sign-extended 32-bit immediate for
+	// 64-bit operands.
+	immz
+	// Immediate of the operand size, including 64-bit immediates.
+	immv
+	// Memory offset of the address size, as used by the accumulator forms of
+	// mov.
+	moffs
+	// 8-bit branch displacement.
+	rel8
+	// 16-bit or 32-bit branch displacement, depending on the operand size.
+	relz
+)
+
+// An entry describes the decoding of an opcode.
+type entry struct {
+	// Mnemonic of the instruction.
+	op ast.Op
+	// Operands of the instruction.
+	args []operand
+	// Opcode extensions of the reg field of the ModRM byte; or nil if the
+	// opcode has no extension.
+	group *[8]entry
+	// Default operand size of 64 bits in 64-bit mode; as used by stack and
+	// indirect branch instructions.
+	def64 bool
+	// Instruction only valid in 64-bit mode.
+	only64 bool
+	// Instruction not valid in 64-bit mode.
+	no64 bool
+}
+
+// Mnemonics of the arithmetic instructions indexed by opcode extension.
+var arithOps = [8]ast.Op{"add", "or", "adc", "sbb", "and", "sub", "xor", "cmp"}
+
+// Mnemonics of the shift and rotate instructions indexed by opcode extension.
+var shiftOps = [8]ast.Op{"rol", "ror", "rcl", "rcr", "shl", "shr", "", "sar"}
+
+// Canonical names of the condition codes indexed by encoding.
+var conds = [16]string{"o", "no", "b", "ae", "e", "ne", "be", "a", "s", "ns", "p", "np", "l", "ge", "le", "g"}
+
+// Opcode tables of the one-byte and two-byte (0F) opcodes.
+var (
+	oneByte [256]entry
+	twoByte [256]entry
+)
+
+func init() {
+	// Opcode extension groups.
+	group1 := func(args ...operand) *[8]entry {
+		g := new([8]entry)
+		for x, op := range arithOps {
+			g[x] = entry{op: op, args: args}
+		}
+		return g
+	}
+	group2 := func(args ...operand) *[8]entry {
+		g := new([8]entry)
+		for x, op := range shiftOps {
+			if op != "" {
+				g[x] = entry{op: op, args: args}
+			}
+		}
+		return g
+	}
+	group3 := func(rm, imm operand) *[8]entry {
+		return &[8]entry{
+			0: {op: "test", args: []operand{rm, imm}},
+			2: {op: "not", args: []operand{rm}},
+			3: {op: "neg", args: []operand{rm}},
+			4: {op: "mul", args: []operand{rm}},
+			5: {op: "imul", args: []operand{rm}},
+			6: {op: "div", args: []operand{rm}},
+			7: {op: "idiv", args: []operand{rm}},
+		}
+	}
+
+	for x, op := range arithOps {
+		base := x << 3
+		oneByte[base+0] = entry{op: op, args: []operand{rm8, reg8}}
+		oneByte[base+1] = entry{op: op, args: []operand{rmv, regv}}
+		oneByte[base+2] = entry{op: op, args: []operand{reg8, rm8}}
+		oneByte[base+3] = entry{op: op, args: []operand{regv, rmv}}
+		oneByte[base+4] = entry{op: op, args: []operand{al, uimm8}}
+		oneByte[base+5] = entry{op: op, args: []operand{acc, immz}}
+	}
+	for r := 0; r < 8; r++ {
+		oneByte[0x40+r] = entry{op: "inc", args: []operand{opv}, no64: true}
+		oneByte[0x48+r] = entry{op: "dec", args: []operand{opv}, no64: true}
+		oneByte[0x50+r] = entry{op: "push", args: []operand{opv}, def64: true}
+		oneByte[0x58+r] = entry{op: "pop", args: []operand{opv}, def64: true}
+		oneByte[0xB0+r] = entry{op: "mov", args: []operand{op8, uimm8}}
+		oneByte[0xB8+r] = entry{op: "mov", args: []operand{opv, immv}}
+	}
+	for cc, name := range conds {
+		oneByte[0x70+cc] = entry{op: ast.Op("j" + name), args: []operand{rel8}}
+		twoByte[0x40+cc] = entry{op: ast.Op("cmov" + name), args: []operand{regv, rmv}}
+		twoByte[0x80+cc] = entry{op: ast.Op("j" + name), args: []operand{relz}}
+		twoByte[0x90+cc] = entry{group: &[8]entry{0: {op: ast.Op("set" + name), args: []operand{rm8}}}}
+	}
+	oneByte[0x63] = entry{op: "movsxd", args: []operand{regv, rm32}, only64: true}
+	oneByte[0x68] = entry{op: "push", args: []operand{immz}, def64: true}
+	oneByte[0x69] = entry{op: "imul", args: []operand{regv, rmv, immz}}
+	oneByte[0x6A] = entry{op: "push", args: []operand{simm8}, def64: true}
+	oneByte[0x6B] = entry{op: "imul", args: []operand{regv, rmv, simm8}}
+	oneByte[0x80] = entry{group: group1(rm8, uimm8)}
+	oneByte[0x81] = entry{group: group1(rmv, immz)}
+	oneByte[0x83] = entry{group: group1(rmv, simm8)}
+	oneByte[0x84] = entry{op: "test", args: []operand{rm8, reg8}}
+	oneByte[0x85] = entry{op: "test", args: []operand{rmv, regv}}
+	oneByte[0x88] = entry{op: "mov", args: []operand{rm8, reg8}}
+	oneByte[0x89] = entry{op: "mov", args: []operand{rmv, regv}}
+	oneByte[0x8A] = entry{op: "mov", args: []operand{reg8, rm8}}
+	oneByte[0x8B] = entry{op: "mov", args: []operand{regv, rmv}}
+	oneByte[0x8D] = entry{op: "lea", args: []operand{regv, mem}}
+	oneByte[0x8F] = entry{group: &[8]entry{0: {op: "pop", args: []operand{rmv}, def64: true}}}
+	oneByte[0x90] = entry{op: "nop"}
+	oneByte[0xA0] = entry{op: "mov", args: []operand{al, moffs}}
+	oneByte[0xA1] = entry{op: "mov", args: []operand{acc, moffs}}
+	oneByte[0xA2] = entry{op: "mov", args: []operand{moffs, al}}
+	oneByte[0xA3] = entry{op: "mov", args: []operand{moffs, acc}}
+	oneByte[0xA8] = entry{op: "test", args: []operand{al, uimm8}}
+	oneByte[0xA9] = entry{op: "test", args: []operand{acc, immz}}
+	oneByte[0xC0] = entry{group: group2(rm8, uimm8)}
+	oneByte[0xC1] = entry{group: group2(rmv, uimm8)}
+	oneByte[0xC2] = entry{op: "ret", args: []operand{uimm16}}
+	oneByte[0xC3] = entry{op: "ret"}
+	oneByte[0xC6] = entry{group: &[8]entry{0: {op: "mov", args: []operand{rm8, uimm8}}}}
+	oneByte[0xC7] = entry{group: &[8]entry{0: {op: "mov", args: []operand{rmv, immz}}}}
+	oneByte[0xC9] = entry{op: "leave"}
+	oneByte[0xCC] = entry{op: "int3"}
+	oneByte[0xCD] = entry{op: "int", args: []operand{uimm8}}
+	oneByte[0xD0] = entry{group: group2(rm8, one)}
+	oneByte[0xD1] = entry{group: group2(rmv, one)}
+	oneByte[0xD2] = entry{group: group2(rm8, cl)}
+	oneByte[0xD3] = entry{group: group2(rmv, cl)}
+	oneByte[0xE0] = entry{op: "loopne", args: []operand{rel8}}
+	oneByte[0xE1] = entry{op: "loope", args: []operand{rel8}}
+	oneByte[0xE2] = entry{op: "loop", args: []operand{rel8}}
+	oneByte[0xE8] = entry{op: "call", args: []operand{relz}, def64: true}
+	oneByte[0xE9] = entry{op: "jmp", args: []operand{relz}, def64: true}
+	oneByte[0xEB] = entry{op: "jmp", args: []operand{rel8}}
+	oneByte[0xF4] = entry{op: "hlt"}
+	oneByte[0xF5] = entry{op: "cmc"}
+	oneByte[0xF6] = entry{group: group3(rm8, uimm8)}
+	oneByte[0xF7] = entry{group: group3(rmv, immz)}
+	oneByte[0xF8] = entry{op: "clc"}
+	oneByte[0xF9] = entry{op: "stc"}
+	oneByte[0xFA] = entry{op: "cli"}
+	oneByte[0xFB] = entry{op: "sti"}
+	oneByte[0xFC] = entry{op: "cld"}
+	oneByte[0xFD] = entry{op: "std"}
+	oneByte[0xFE] = entry{group: &[8]entry{
+		0: {op: "inc", args: []operand{rm8}},
+		1: {op: "dec", args: []operand{rm8}},
+	}}
+	oneByte[0xFF] = entry{group: &[8]entry{
+		0: {op: "inc", args: []operand{rmv}},
+		1: {op: "dec", args: []operand{rmv}},
+		2: {op: "call", args: []operand{rmv}, def64: true},
+		4: {op: "jmp", args: []operand{rmv}, def64: true},
+		6: {op: "push", args: []operand{rmv}, def64: true},
+	}}
+
+	twoByte[0x05] = entry{op: "syscall", only64: true}
+	twoByte[0xAF] = entry{op: "imul", args: []operand{regv, rmv}}
+	twoByte[0xB6] = entry{op: "movzx", args: []operand{regv, rm8}}
+	twoByte[0xB7] = entry{op: "movzx", args: []operand{regv, rm16}}
+	twoByte[0xBE] = entry{op: "movsx", args: []operand{regv, rm8}}
+	twoByte[0xBF] = entry{op: "movsx", args: []operand{regv, rm16}}
+}
+
+// REX prefix bits.
+const (
+	rexB = 1 << iota
+	rexX
+	rexR
+	rexW
+)
+
+// A decoding keeps track of the decoding of a single instruction.
+type decoding struct {
+	// Machine code of the instruction.
+	src []byte
+	// Address of the instruction.
+	pc int64
+	// Processor mode; either 16, 32 or 64.
+	bits int
+	// Offset of the next byte to decode.
+	off int
+	// Truncated instruction.
+	truncated bool
+	// Operand-size (0x66) and address-size (0x67) override prefixes.
+	opsize, addrsize bool
+	// REX prefix; or 0 if not present.
+	rex byte
+	// ModRM byte, and whether it has been decoded.
+	modrm    byte
+	hasModRM bool
+	// RIP-relative memory operand, and its displacement; resolved once the
+	// length of the instruction is known.
+	rel     *ast.Mem
+	relDisp int64
+}
+
+// decode decodes the instruction.
+func (d *decoding) decode() (ast.Inst, error) {
+	if err := d.prefixes(); err != nil {
+		return ast.Inst{}, err
+	}
+	b := d.u8()
+	opcode := []byte{b}
+	e := oneByte[b]
+	if b == 0x0F {
+		b = d.u8()
+		opcode = append(opcode, b)
+		e = twoByte[b]
+	}
+	if d.truncated {
+		return ast.Inst{}, nil
+	}
+
+	// Instructions whose mnemonic depends on the operand or address size.
+	switch {
+	case len(opcode) == 1 && (b == 0x98 || b == 0x99):
+		names := map[int]ast.Op{32: "cwde", 64: "cdqe"}
+		if b == 0x99 {
+			names = map[int]ast.Op{32: "cdq", 64: "cqo"}
+		}
+		if op, ok := names[d.opSize(false)]; ok {
+			return ast.Inst{Op: op}, nil
+		}
+	case len(opcode) == 1 && b == 0xE3:
+		names := map[int]ast.Op{32: "jecxz", 64: "jrcxz"}
+		if op, ok := names[d.addrSize()]; ok {
+			return d.inst(entry{op: op, args: []operand{rel8}})
+		}
+	case len(opcode) == 1 && b == 0x90 && (d.opsize || d.rex&rexB != 0):
+		// xchg ax, ax and xchg r8, rax
+		e = entry{}
+	}
+
+	if e.group != nil {
+		e = e.group[d.modRM()>>3&7]
+	}
+	if e.op == "" || (e.only64 && d.bits != 64) || (e.no64 && d.bits == 64) {
+		return ast.Inst{}, fmt.Errorf("x86.Decode: invalid opcode 0x%X", opcode)
+	}
+	return d.inst(e)
+}
+
+// prefixes decodes the instruction prefixes.
+func (d *decoding) prefixes() error {
+	for d.off < len(d.src) {
+		b := d.src[d.off]
+		switch {
+		case b == 0x66 && !d.opsize:
+			d.opsize = true
+		case b == 0x67 && !d.addrsize:
+			d.addrsize = true
+		case d.bits == 64 && b&0xF0 == 0x40:
+			// The REX prefix must immediately precede the opcode.
+			d.rex = b
+			d.off++
+			return nil
+		case b == 0x66, b == 0x67, b == 0xF0, b == 0xF2, b == 0xF3, b == 0x26, b == 0x2E, b == 0x36, b == 0x3E, b == 0x64, b == 0x65:
+			return fmt.Errorf("x86.Decode: unsupported prefix 0x%02X", b)
+		default:
+			return nil
+		}
+		d.off++
+	}
+	return nil
+}
+
+// inst decodes the operands of the instruction described by e.
+func (d *decoding) inst(e entry) (ast.Inst, error) {
+	inst := ast.Inst{Op: e.op}
+	size := d.opSize(e.def64)
+	for _, operand := range e.args {
+		arg, err := d.operand(operand, size)
+		if err != nil {
+			return ast.Inst{}, err
+		}
+		inst.Args = append(inst.Args, arg)
+	}
+	if d.rel != nil {
+		// The address of RIP-relative memory operands is relative to the end of
+		// the instruction.
+		d.rel.Disp = ast.Addr(d.pc + int64(d.off) + d.relDisp)
+	}
+	// Memory operands whose size is not given by a register operand of the same
+	// size require a size keyword; e.g. inc dword [eax].
+	for i, arg := range inst.Args {
+		if m, ok := arg.(*ast.Mem); ok {
+			if msize := rmSize(e.args[i], size); msize != 0 && !hasReg(e, inst.Args, msize) {
+				m.Size = msize
+			}
+		}
+	}
+	return inst, nil
+}
+
+// rmSize returns the size in bits of the register or memory operand kind of the
+// r/m field, given the operand size; or 0 if kind is not of the r/m field.
+func rmSize(kind operand, size int) int {
+	switch kind {
+	case rm8:
+		return 8
+	case rm16:
+		return 16
+	case rm32:
+		return 32
+	case rmv:
+		return size
+	}
+	return 0
+}
+
+// hasReg returns true if the operands of the instruction described by e include
+// a register of the given size in bits other than the shift count, and false
+// otherwise.
+func hasReg(e entry, args []ast.Arg, size int) bool {
+	for i, arg := range args {
+		if reg, ok := arg.(ast.Reg); ok && e.args[i] != cl && x86arch.Arch64.RegClass(reg).Size == size {
+			return true
+		}
+	}
+	return false
+}
+
+// operand decodes an operand of the given kind and operand size.
+func (d *decoding) operand(kind operand, size int) (ast.Arg, error) {
+	switch kind {
+	case rm8:
+		return d.rm(8, true)
+	case rm16:
+		return d.rm(16, true)
+	case rm32:
+		return d.rm(32, true)
+	case rmv:
+		return d.rm(size, true)
+	case mem:
+		return d.rm(size, false)
+	case reg8:
+		return d.reg(8, d.modRM()>>3&7, rexR), nil
+	case regv:
+		return d.reg(size, d.modRM()>>3&7, rexR), nil
+	case op8:
+		return d.reg(8, d.src[d.off-1]&7, rexB), nil
+	case opv:
+		return d.reg(size, d.src[d.off-1]&7, rexB), nil
+	case al:
+		return x86arch.AL, nil
+	case acc:
+		return x86arch.RegOf(size, 0, false), nil
+	case cl:
+		return x86arch.CL, nil
+	case one:
+		return ast.Int(1), nil
+	case uimm8:
+		return ast.Int(d.uint(8)), nil
+	case uimm16:
+		return ast.Int(d.uint(16)), nil
+	case simm8:
+		return ast.Int(d.int(8)), nil
+	case immz:
+		if size == 64 {
+			return ast.Int(d.int(32)), nil
+		}
+		return ast.Int(d.uint(size)), nil
+	case immv:
+		if size == 64 {
+			return ast.Int(d.int(64)), nil
+		}
+		return ast.Int(d.uint(size)), nil
+	case moffs:
+		return &ast.Mem{Disp: ast.Addr(d.uint(d.addrSize()))}, nil
+	case rel8:
+		rel := d.int(8)
+		return ast.Addr(d.pc + int64(d.off) + rel), nil
+	case relz:
+		width := 32
+		if d.bits != 64 && size == 16 {
+			width = 16
+		}
+		rel := d.int(width)
+		return ast.Addr(d.pc + int64(d.off) + rel), nil
+	}
+	panic(fmt.Errorf("x86.decoding.operand: support for operand kind %d not yet implemented", kind))
+}
+
+// rm decodes the register or memory operand of the ModRM byte, where size
+// specifies the size of register operands. Register operands are only valid if
+// reg is true.
+func (d *decoding) rm(size int, reg bool) (ast.Arg, error) {
+	modrm := d.modRM()
+	mod, rm := modrm>>6, modrm&7
+	if mod == 3 {
+		if !reg {
+			return nil, fmt.Errorf("x86.Decode: invalid register operand; expected memory operand")
+		}
+		return d.reg(size, rm, rexB), nil
+	}
+
+	asize := d.addrSize()
+	if asize == 16 {
+		return nil, fmt.Errorf("x86.Decode: unsupported 16-bit addressing")
+	}
+	m := new(ast.Mem)
+	hasBase := true
+	if rm == 4 {
+		sib := d.u8()
+		scale, index, base := sib>>6, sib>>3&7, sib&7
+		if index != 4 || d.rex&rexX != 0 {
+			m.Index = x86arch.RegOf(asize, index|d.rexBit(rexX), false)
+			m.Scale = 1 << scale
+		}
+		rm = base
+		hasBase = !(mod == 0 && base == 5)
+	} else if mod == 0 && rm == 5 {
+		hasBase = false
+		if d.bits == 64 {
+			// RIP-relative addressing.
+			if asize != 64 {
+				return nil, fmt.Errorf("x86.Decode: unsupported EIP-relative addressing")
+			}
+			m.Rel = true
+			d.rel, d.relDisp = m, d.int(32)
+			return m, nil
+		}
+	}
+	if hasBase {
+		m.Base = x86arch.RegOf(asize, rm|d.rexBit(rexB), false)
+	}
+
+	// Displacement.
+	var disp int64
+	switch {
+	case mod == 1:
+		disp = d.int(8)
+	case mod == 2 || !hasBase:
+		disp = d.int(32)
+	}
+	switch {
+	case m.Base == nil && m.Index == nil:
+		m.Disp = ast.Addr(uint32(disp))
+		if d.bits == 64 {
+			m.Disp = ast.Addr(disp)
+		}
+	case disp != 0:
+		m.Disp = ast.Int(disp)
+	}
+	return m, nil
+}
+
+// reg returns the register of the given size and 3-bit register number,
+// extended by the given bit of the REX prefix.
+func (d *decoding) reg(size int, num byte, bit byte) ast.Reg {
+	return x86arch.RegOf(size, num|d.rexBit(bit), d.rex != 0)
+}
+
+// rexBit returns the fourth bit of a register number, as stored in the given
+// bit of the REX prefix.
+func (d *decoding) rexBit(bit byte) byte {
+	if d.rex&bit != 0 {
+		return 8
+	}
+	return 0
+}
+
+// opSize returns the operand size of the instruction. Stack and indirect
+// branch instructions default to 64-bit operands in 64-bit mode.
+func (d *decoding) opSize(def64 bool) int {
+	switch {
+	case d.rex&rexW != 0:
+		return 64
+	case d.bits == 16 && d.opsize:
+		return 32
+	case d.bits == 16 || d.opsize:
+		return 16
+	case d.bits == 64 && def64:
+		return 64
+	}
+	return 32
+}
+
+// addrSize returns the address size of the instruction.
+func (d *decoding) addrSize() int {
+	switch {
+	case d.bits == 16 && d.addrsize:
+		return 32
+	case d.bits == 64 && !d.addrsize:
+		return 64
+	case d.bits == 64, d.bits == 32 && !d.addrsize:
+		return 32
+	}
+	return 16
+}
+
+// modRM returns the ModRM byte of the instruction, which is decoded on first
+// use.
+func (d *decoding) modRM() byte {
+	if !d.hasModRM {
+		d.modrm, d.hasModRM = d.u8(), true
+	}
+	return d.modrm
+}
+
+// u8 decodes an 8-bit value.
+func (d *decoding) u8() byte {
+	return byte(d.uint(8))
+}
+
+// uint decodes a little-endian unsigned integer of the given size in bits.
+func (d *decoding) uint(size int) uint64 {
+	n := size / 8
+	if d.off+n > len(d.src) {
+		d.truncated = true
+		d.off = len(d.src)
+		return 0
+	}
+	var x uint64
+	for i := n - 1; i >= 0; i-- {
+		x = x<<8 | uint64(d.src[d.off+i])
+	}
+	d.off += n
+	return x
+}
+
+// int decodes a little-endian signed integer of the given size in bits.
+func (d *decoding) int(size int) int64 {
+	shift := uint(64 - size)
+	return int64(d.uint(size)<<shift) >> shift
+}
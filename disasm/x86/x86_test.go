@@ -0,0 +1,276 @@
+package x86
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/mewlang/asm/asm"
+	x86asm "github.com/mewlang/asm/asm/x86"
+	"github.com/mewlang/asm/disasm"
+	"github.com/mewlang/asm/internal/asmtest"
+)
+
+func TestDecode(t *testing.T) {
+	// The expected instructions have been verified using objdump.
+	golden := []struct {
+		dec  disasm.Decoder
+		in   string
+		want string
+		err  string
+	}{
+		// i=0
+		{dec: Decoder, in: "b804000000", want: "mov eax, 4"},
+		// i=1
+		{dec: Decoder, in: "66b83412", want: "mov ax, 4660"},
+		// i=2
+		{dec: Decoder, in: "88f1", want: "mov cl, dh"},
+		// i=3
+		{dec: Decoder, in: "83f8ff", want: "cmp eax, -1"},
+		// i=4
+		{dec: Decoder, in: "2500ffffff", want: "and eax, 4294967040"},
+		// i=5
+		{dec: Decoder, in: "c0fa02", want: "sar dl, 2"},
+		// i=6
+		{dec: Decoder, in: "d3eb", want: "shr ebx, cl"},
+		// i=7
+		{dec: Decoder, in: "d1e0", want: "shl eax, 1"},
+		// i=8
+		{dec: Decoder, in: "6bc30a", want: "imul eax, ebx, 10"},
+		// i=9
+		{dec: Decoder, in: "660f4dca", want: "cmovge cx, dx"},
+		// i=10
+		{dec: Decoder, in: "0f94c0", want: "sete al"},
+		// i=11
+		{dec: Decoder, in: "c20800", want: "ret 8"},
+		// i=12
+		{dec: Decoder, in: "cd80", want: "int 128"},
+		// i=13
+		{dec: Decoder, in: "8b448bf8", want: "mov eax, [ebx + ecx*4 - 8]"},
+		// i=14
+		{dec: Decoder, in: "8b04cd00000000", want: "mov eax, [ecx*8]"},
+		// i=15
+		{dec: Decoder, in: "a100100000", want: "mov eax, [0x1000]"},
+		// i=16
+		{dec: Decoder, in: "ff1500200000", want: "call dword [0x2000]"},
+		// i=17
+		{dec: Decoder, in: "8d7600", want: "lea esi, [esi]"},
+		// i=18
+		{dec: Decoder, in: "e9fb0f0000", want: "jmp 0x1000"},
+		// i=19
+		{dec: Decoder, in: "74fe", want: "je 0x0"},
+		// i=20
+		{dec: Decoder, in: "0f85fa1f0000", want: "jne 0x2000"},
+		// i=21
+		{dec: Decoder, in: "e306", want: "jecxz 0x8"},
+		// i=22
+		{dec: Decoder16, in: "66b804000000", want: "mov eax, 4"},
+		// i=23
+		{dec: Decoder16, in: "e9fd0f", want: "jmp 0x1000"},
+		// i=24
+		{dec: Decoder16, in: "67668b03", want: "mov eax, [ebx]"},
+		// i=25
+		{dec: Decoder64, in: "4d89c8", want: "mov r8, r9"},
+		// i=26
+		{dec: Decoder64, in: "4088fe", want: "mov sil, dil"},
+		// i=27
+		{dec: Decoder64, in: "49ba8967452301000000", want: "mov r10, 4886718345"},
+		// i=28
+		{dec: Decoder64, in: "48c7c0ffffffff", want: "mov rax, -1"},
+		// i=29
+		{dec: Decoder64, in: "4b8b44ec10", want: "mov rax, [r12 + r13*8 + 16]"},
+		// i=30
+		{dec: Decoder64, in: "498b4500", want: "mov rax, [r13]"},
+		// i=31
+		{dec: Decoder64, in: "8b042500100000", want: "mov eax, [0x1000]"},
+		// i=32
+		{dec: Decoder64, in: "678b03", want: "mov eax, [ebx]"},
+		// i=33
+		{dec: Decoder64, in: "488d3513000000", want: "lea rsi, [rel 0x1A]"},
+		// i=34
+		{dec: Decoder64, in: "4154", want: "push r12"},
+		// i=35
+		{dec: Decoder64, in: "41ffe3", want: "jmp r11"},
+		// i=36
+		{dec: Decoder64, in: "4863c1", want: "movsxd rax, ecx"},
+		// i=37
+		{dec: Decoder64, in: "0f05", want: "syscall"},
+		// i=38
+		{dec: Decoder64, in: "4899", want: "cqo"},
+		// i=39
+		{dec: Decoder64, in: "e3fe", want: "jrcxz 0x0"},
+		// i=40
+		{dec: Decoder, in: "0f0b", err: "x86.Decode: invalid opcode 0x0F0B"},
+		// i=41
+		{dec: Decoder64, in: "4040", err: "x86.Decode: invalid opcode 0x40"},
+		// i=42
+		{dec: Decoder, in: "0f05", err: "x86.Decode: invalid opcode 0x0F05"},
+		// i=43
+		{dec: Decoder, in: "f3a4", err: "x86.Decode: unsupported prefix 0xF3"},
+		// i=44
+		{dec: Decoder, in: "6690", err: "x86.Decode: invalid opcode 0x90"},
+		// i=45
+		{dec: Decoder, in: "8dc0", err: "x86.Decode: invalid register operand; expected memory operand"},
+		// i=46
+		{dec: Decoder, in: "678b07", err: "x86.Decode: unsupported 16-bit addressing"},
+		// i=47
+		{dec: Decoder, in: "c70001000000", want: "mov dword [eax], 1"},
+		// i=48
+		{dec: Decoder, in: "b80400", err: "x86.Decode: truncated instruction of 3 bytes"},
+		// i=49
+		{dec: Decoder64, in: "48", err: "x86.Decode: truncated instruction of 1 bytes"},
+		// i=50
+		{dec: Decoder, in: "fe08", want: "dec byte [eax]"},
+		// i=51
+		{dec: Decoder, in: "66d339", want: "sar word [ecx], cl"},
+		// i=52
+		{dec: Decoder, in: "0fb603", want: "movzx eax, byte [ebx]"},
+		// i=53
+		{dec: Decoder, in: "8918", want: "mov [eax], ebx"},
+		// i=54
+		{dec: Decoder64, in: "418f00", want: "pop qword [r8]"},
+		// i=55
+		{dec: Decoder64, in: "48c700ffffffff", want: "mov qword [rax], -1"},
+	}
+
+	for i, g := range golden {
+		src, err := hex.DecodeString(g.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inst, n, err := g.dec.Decode(src, 0)
+		if len(g.err) > 0 {
+			if err == nil {
+				t.Errorf("i=%d: expected error %q, got nil", i, g.err)
+			} else if got := err.Error(); got != g.err {
+				t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.err, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if n != len(src) {
+			t.Errorf("i=%d: length mismatch; expected %d, got %d", i, len(src), n)
+		}
+		got, err := disasm.FormatNode(g.dec.Arch(), &inst)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if got != g.want {
+			t.Errorf("i=%d: instruction mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	golden := []struct {
+		enc  asm.Encoder
+		dec  disasm.Decoder
+		addr int64
+		src  string
+	}{
+		// i=0
+		{
+			enc:  x86asm.Encoder,
+			dec:  Decoder,
+			addr: 0x8048000,
+			src: `
+	org 0x8048000
+main:
+	push ebp
+	mov ebp, esp
+	sub esp, 16
+	mov eax, [ebp + 8]
+	mov ecx, [ebp + 12]
+	xor edx, edx
+loop:
+	add edx, [eax + ecx*4 - 4]
+	dec ecx
+	jnz loop
+	mov [0x8049000], edx
+	movzx eax, dl
+	imul eax, [ebx], 1000
+	test al, 1
+	setne bl
+	shr edx, cl
+	add dword [ebp - 4], 1
+	shl byte [esi], cl
+	not word [edi]
+	call func
+	leave
+	ret
+	db 0x0F, 0x0B
+func:
+	cmp eax, 0xffffff00
+	jae far
+	jmp [ebx + 4]
+	db ` + zeros(200) + `
+far:
+	int 0x80
+	ret 4
+`,
+		},
+		// i=1
+		{
+			enc:  x86asm.Encoder64,
+			dec:  Decoder64,
+			addr: 0x401000,
+			src: `
+	org 0x401000
+_start:
+	mov eax, 1
+	mov edi, 1
+	lea rsi, [rel hello]
+	mov edx, 13
+	syscall
+	mov r10, 0x123456789
+	mov rax, -1
+	mov r8b, [r12 + r13*8 + 16]
+	mov [rsp + 8], spl
+	push r15
+	cdqe
+	movsxd rcx, eax
+	cmovg rax, [rbp - 8]
+	push qword [rax]
+	movsxd rdx, dword [rbx]
+	call [rel hello]
+	jmp r11
+hello:
+	mov eax, 60
+	xor edi, edi
+	syscall
+`,
+		},
+	}
+
+	for i, g := range golden {
+		want, err := asmtest.Text(g.enc, g.src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		prog := disasm.Disassemble(g.dec, want, g.addr)
+		out, err := disasm.Sprint(g.dec.Arch(), prog)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		got, err := asmtest.Text(g.enc, out)
+		if err != nil {
+			t.Errorf("i=%d: unable to assemble disassembly; %v\n%s", i, err, out)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("i=%d: round-trip mismatch; expected %x, got %x\n%s", i, want, got, out)
+		}
+	}
+}
+
+// zeros returns a comma-separated list of n zeros.
+func zeros(n int) string {
+	return strings.TrimSuffix(strings.Repeat("0, ", n), ", ")
+}
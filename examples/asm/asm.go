@@ -0,0 +1,197 @@
+// asm is a tool which assembles source files into executables, relocatable
+// objects and memory images.
+//
+// Usage:
+//
+//	asm build [-arch name] [-format name] [-o output] [flags] file.asm
+//
+// The build command assembles the source file into a file of the given output
+// format:
+//
+//	elf    static ELF executable (default)
+//	obj    relocatable ELF object; as selected by -c
+//	macho  relocatable Mach-O object
+//	coff   relocatable COFF object
+//	bin    flat binary
+//	ihex   Intel HEX file
+//	srec   Motorola S-record file
+//	nes    iNES image
+//
+// Unless specified by flags, the architecture and the name of the output file
+// are given by the name of the source file; e.g. hello_x86.asm is assembled for
+// the x86 architecture into hello, or hello.o if -c is set.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/asm/arm"
+	"github.com/mewlang/asm/asm/arm64"
+	"github.com/mewlang/asm/asm/mips"
+	"github.com/mewlang/asm/asm/mos6502"
+	"github.com/mewlang/asm/asm/riscv"
+	"github.com/mewlang/asm/asm/x86"
+	"github.com/mewlang/asm/obj"
+	"github.com/mewlang/asm/obj/bin"
+	"github.com/mewlang/asm/obj/coff"
+	"github.com/mewlang/asm/obj/elf"
+	"github.com/mewlang/asm/obj/ihex"
+	"github.com/mewlang/asm/obj/ines"
+	"github.com/mewlang/asm/obj/macho"
+	"github.com/mewlang/asm/obj/srec"
+	"github.com/mewlang/asm/parser"
+	"github.com/mewlang/asm/token"
+)
+
+// encoders maps from architecture name to instruction encoder. The riscv32c and
+// riscv64c architectures are RV32I and RV64I with the C extension; i.e. the
+// instructions are compressed whenever possible.
+var encoders = map[string]asm.Encoder{
+	"6502":     mos6502.Encoder,
+	"arm64":    arm64.Encoder,
+	"mips":     mips.Encoder,
+	"riscv32":  riscv.Encoder,
+	"riscv32c": riscv.EncoderC,
+	"riscv64":  riscv.Encoder64,
+	"riscv64c": riscv.Encoder64C,
+	"thumb":    new(arm.Encoder),
+	"x86":      x86.Encoder,
+	"x86-64":   x86.Encoder64,
+}
+
+// An outputFormat is an output file format, and the extension and permissions
+// of its files.
+type outputFormat struct {
+	obj.Format
+	ext  string
+	perm os.FileMode
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: asm build [-arch name] [-format name] [-o output] [flags] file.asm")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var (
+		archName   string
+		formatName string
+		output     string
+		entry      string
+		object     bool
+		origin     int64
+		pad        bool
+	)
+	flag.StringVar(&archName, "arch", "", "architecture of the source file (6502, arm64, mips, riscv32, riscv32c, riscv64, riscv64c, thumb, x86 or x86-64)")
+	flag.StringVar(&formatName, "format", "elf", "output format (elf, obj, macho, coff, bin, ihex, srec or nes)")
+	flag.StringVar(&output, "o", "", "output file")
+	flag.StringVar(&entry, "entry", elf.DefaultEntry, "label of the entry point of executables")
+	flag.BoolVar(&object, "c", false, "write a relocatable object; short for -format obj")
+	flag.Int64Var(&origin, "origin", 0, "origin address of memory images of programs without an org directive")
+	flag.BoolVar(&pad, "pad", false, "fill the gaps between sections of Intel HEX and S-record files with zero bytes")
+	flag.Usage = usage
+	if len(os.Args) < 2 || os.Args[1] != "build" {
+		usage()
+		os.Exit(2)
+	}
+	flag.CommandLine.Parse(os.Args[2:])
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+	srcPath := flag.Arg(0)
+
+	// Locate the architecture, the output format and the output file.
+	name, suffix := splitName(srcPath)
+	if archName == "" {
+		archName = suffix
+	}
+	enc, ok := encoders[archName]
+	if !ok {
+		log.Fatalf("unknown architecture %q; specify using -arch", archName)
+	}
+	if object {
+		formatName = "obj"
+	}
+	formats := map[string]outputFormat{
+		"elf":   {Format: &elf.Exec{Entry: entry}, perm: 0755},
+		"obj":   {Format: &elf.Rel{}, ext: ".o", perm: 0644},
+		"macho": {Format: &macho.Format{}, ext: ".o", perm: 0644},
+		"coff":  {Format: &coff.Format{}, ext: ".obj", perm: 0644},
+		"bin":   {Format: &bin.Format{Origin: origin}, ext: ".bin", perm: 0644},
+		"ihex":  {Format: &ihex.Format{Origin: origin, Pad: pad}, ext: ".hex", perm: 0644},
+		"srec":  {Format: &srec.Format{Origin: origin, Pad: pad, Header: name}, ext: ".srec", perm: 0644},
+		"nes":   {Format: &ines.Format{}, ext: ".nes", perm: 0644},
+	}
+	format, ok := formats[formatName]
+	if !ok {
+		log.Fatalf("unknown output format %q", formatName)
+	}
+	if output == "" {
+		output = name + format.ext
+	}
+
+	if err := build(enc, format, srcPath, output); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// splitName splits the base name of the given source file, excluding its
+// extension, into a name and the name of an architecture, as separated by an
+// underscore. Underscores of the architecture name denote hyphens; e.g.
+// hello_x86_64.asm is split into hello and x86-64. If no architecture is
+// present, the returned architecture name is empty.
+func splitName(srcPath string) (name, archName string) {
+	base := filepath.Base(srcPath)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	for i, r := range base {
+		if r != '_' {
+			continue
+		}
+		suffix := strings.Replace(base[i+1:], "_", "-", -1)
+		if _, ok := encoders[suffix]; ok {
+			return base[:i], suffix
+		}
+	}
+	return base, ""
+}
+
+// build assembles the given source file using enc, and writes the file of the
+// given output format to the output file.
+func build(enc asm.Encoder, format outputFormat, srcPath, output string) error {
+	src, err := ioutil.ReadFile(srcPath)
+	if err != nil {
+		return err
+	}
+	fset := token.NewFileSet()
+	prog, err := (&parser.Config{Arch: enc.Arch()}).ParseFile(fset, srcPath, string(src))
+	if err != nil {
+		return err
+	}
+	conf, err := format.Config(enc.Arch())
+	if err != nil {
+		return err
+	}
+	o, err := conf.Assemble(fset, enc, prog)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, format.perm)
+	if err != nil {
+		return err
+	}
+	if err := format.Write(f, o); err != nil {
+		f.Close()
+		os.Remove(output)
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,106 @@
+// disasm is a tool which demonstrates how to disassemble raw binary files using
+// the Disassemble function, and the sections of object files using the objfile
+// package.
+//
+// Usage:
+//
+//	disasm [-arch name] [-addr address] [-raw] file...
+//
+// ELF, Mach-O and PE files are disassembled section by section, with symbol
+// names as labels and data sections represented by db directives; unless -raw
+// is set. The architecture of object files is given by the file, unless
+// specified by -arch. Any other file is disassembled as raw machine code
+// located at the given address.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/mewlang/asm/disasm"
+	"github.com/mewlang/asm/disasm/arm64"
+	"github.com/mewlang/asm/disasm/mips"
+	"github.com/mewlang/asm/disasm/objfile"
+	"github.com/mewlang/asm/disasm/riscv"
+	"github.com/mewlang/asm/disasm/x86"
+)
+
+// decoders maps from architecture name to instruction decoder.
+var decoders = map[string]disasm.Decoder{
+	"arm64":   arm64.Decoder,
+	"mips":    mips.Decoder,
+	"riscv32": riscv.Decoder,
+	"riscv64": riscv.Decoder64,
+	"x86":     x86.Decoder,
+	"x86-16":  x86.Decoder16,
+	"x86-64":  x86.Decoder64,
+}
+
+// defaultArch is the architecture of raw binary files, unless specified by
+// -arch.
+const defaultArch = "mips"
+
+func main() {
+	var (
+		addr     int64
+		archName string
+		raw      bool
+	)
+	flag.Int64Var(&addr, "addr", 0, "load address of raw binary input files")
+	flag.StringVar(&archName, "arch", "", "architecture of the input files (arm64, mips, riscv32, riscv64, x86, x86-16 or x86-64); mips for raw binary files by default")
+	flag.BoolVar(&raw, "raw", false, "disassemble object files as raw binary files")
+	flag.Parse()
+	for _, filePath := range flag.Args() {
+		err := disassemble(filePath, archName, addr, raw)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+}
+
+// disassemble decodes the machine code of the provided file, and prints the
+// assembly to standard output. Raw binary files are located at addr.
+func disassemble(filePath, archName string, addr int64, raw bool) (err error) {
+	if !raw {
+		if f, err := objfile.Open(filePath); err == nil {
+			defer f.Close()
+			if archName == "" {
+				archName = f.Arch
+			}
+			dec, err := decoder(archName)
+			if err != nil {
+				return err
+			}
+			return disasm.Fprint(os.Stdout, dec.Arch(), f.Disassemble(dec))
+		}
+	}
+
+	// Read input from file.
+	src, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	if archName == "" {
+		archName = defaultArch
+	}
+	dec, err := decoder(archName)
+	if err != nil {
+		return err
+	}
+
+	// Disassemble the input.
+	prog := disasm.Disassemble(dec, src, addr)
+	return disasm.Fprint(os.Stdout, dec.Arch(), prog)
+}
+
+// decoder returns the instruction decoder of the named architecture.
+func decoder(archName string) (disasm.Decoder, error) {
+	dec, ok := decoders[archName]
+	if !ok {
+		return nil, fmt.Errorf("unknown architecture %q; specify using -arch", archName)
+	}
+	return dec, nil
+}
@@ -1,5 +1,5 @@
 // tokens is a tool which demonstrates how to tokenize input files using the
-// Parse function.
+// ParseFile function.
 package main
 
 import (
@@ -9,6 +9,7 @@ import (
 	"log"
 
 	"github.com/mewlang/asm/lexer"
+	"github.com/mewlang/asm/token"
 )
 
 func main() {
@@ -21,7 +22,8 @@ func main() {
 	}
 }
 
-// tokens lexes the provided file and prints each token to standard output.
+// tokens lexes the provided file and prints each token, along with its
+// position, to standard output.
 func tokens(filePath string) (err error) {
 	// Read input from file.
 	buf, err := ioutil.ReadFile(filePath)
@@ -31,9 +33,10 @@ func tokens(filePath string) (err error) {
 	input := string(buf)
 
 	// Tokenize the input.
-	tokens := lexer.Parse(input)
+	fset := token.NewFileSet()
+	tokens := lexer.ParseFile(fset, filePath, input)
 	for _, tok := range tokens {
-		fmt.Println("tok:", tok)
+		fmt.Printf("%v: tok: %v\n", fset.Position(tok.Pos), tok)
 	}
 
 	return nil
@@ -0,0 +1,34 @@
+// Package asmtest implements helper functions for the tests of encoders,
+// decoders and object file writers, which assemble source code.
+package asmtest
+
+import (
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/parser"
+	"github.com/mewlang/asm/token"
+)
+
+// Assemble parses the provided source code using the architecture of the given
+// encoder, and assembles it using the layout specified by conf; or the default
+// layout if conf is nil.
+func Assemble(conf *asm.Config, enc asm.Encoder, src string) (*asm.Object, error) {
+	fset := token.NewFileSet()
+	prog, err := (&parser.Config{Arch: enc.Arch()}).ParseFile(fset, "", src)
+	if err != nil {
+		return nil, err
+	}
+	if conf == nil {
+		conf = &asm.Config{}
+	}
+	return conf.Assemble(fset, enc, prog)
+}
+
+// Text assembles the provided source code using the given encoder, and returns
+// the contents of its text section.
+func Text(enc asm.Encoder, src string) ([]byte, error) {
+	obj, err := Assemble(nil, enc, src)
+	if err != nil {
+		return nil, err
+	}
+	return obj.Section(asm.DefaultSection).Data, nil
+}
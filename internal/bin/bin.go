@@ -0,0 +1,14 @@
+// Package bin implements helper functions for writing the binary
+// representation of object files.
+package bin
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Write writes the binary representation of the fixed-size value v to buf,
+// using the given byte order. Writes to a bytes.Buffer never fail.
+func Write(buf *bytes.Buffer, order binary.ByteOrder, v interface{}) {
+	binary.Write(buf, order, v)
+}
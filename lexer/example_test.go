@@ -2,8 +2,10 @@ package lexer_test
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/mewlang/asm/lexer"
+	"github.com/mewlang/asm/token"
 )
 
 func ExampleParse() {
@@ -57,3 +59,24 @@ done:
 	// token 32: [newline]: "\n"
 	// token 33: EOF
 }
+
+func ExampleNew() {
+	r := strings.NewReader("loop:\n\tj loop\n")
+	l := lexer.New(r)
+	for {
+		tok := l.Next()
+		fmt.Println(tok)
+		if tok.Typ == token.EOF || tok.Typ == token.Error {
+			break
+		}
+	}
+
+	// Output:
+	// [identifier]: "loop"
+	// [:]: ":"
+	// [newline]: "\n"
+	// [identifier]: "j"
+	// [identifier]: "loop"
+	// [newline]: "\n"
+	// EOF
+}
@@ -16,6 +16,7 @@ package lexer
 
 import (
 	"fmt"
+	"io"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -26,50 +27,126 @@ import (
 const (
 	// eof is the rune returned by next when no more input is available.
 	eof = -1
+	// chunkSize is the number of bytes requested from the input reader at a
+	// time.
+	chunkSize = 64 * 1024
 )
 
-// A lexer lexes the input into tokens.
-type lexer struct {
-	// The input text.
-	input string
+// Digits of integer and floating-point literals.
+const (
+	octalDigits   = "01234567"
+	decimalDigits = "0123456789"
+	hexDigits     = "0123456789abcdefABCDEF"
+)
+
+// A Lexer lexes an input stream into tokens. The input is read incrementally;
+// only the pending input of the current token is kept in memory.
+type Lexer struct {
+	// The input reader.
+	r io.Reader
+	// The error which terminated reading from r; or io.EOF once all input has
+	// been read.
+	rerr error
+	// A buffer holding the unread input from offset off and onwards.
+	buf []byte
+	// The offset in the input of the first byte in buf.
+	off int
+	// The file of the input text, which keeps track of line offsets.
+	file *token.File
+	// The offset of the line following the last newline read.
+	line int
 	// The start position of the current token.
 	start int
 	// The current position in the input.
 	pos int
 	// The width in byte of the last rune read with next.
 	width int
-	// A slice of scanned tokens.
+	// The type of the last emitted token.
+	last token.Type
+	// The active state function; or nil if the scan has terminated.
+	state stateFn
+	// A queue of scanned tokens which have not yet been returned by Next.
 	tokens []token.Token
 }
 
-// Parse lexes the provided input and returns a slice of scanned tokens.
-func Parse(input string) (tokens []token.Token) {
-	l := &lexer{
-		input:  input,
-		tokens: make([]token.Token, 0),
+// New returns a new lexer which incrementally lexes the input read from r. The
+// positions of the tokens are relative to a file of its own, as returned by
+// File; use NewFile to record positions in a file set.
+func New(r io.Reader) *Lexer {
+	return NewFile(token.NewFileSet().AddFile("", -1, 0), r)
+}
+
+// NewFile returns a new lexer which incrementally lexes the input read from r.
+// The positions of the tokens are relative to the provided file. The size and
+// line offsets of the file are recorded as the input is read; input exceeding
+// the size of a file which cannot grow, as it is not the last file of its file
+// set, terminates the scan with an error token.
+func NewFile(file *token.File, r io.Reader) *Lexer {
+	return &Lexer{
+		r:     r,
+		file:  file,
+		state: lexLine, // lexLine is the initial state function of the lexer.
 	}
+}
 
-	// Tokenize the input.
-	l.run()
+// File returns the file of the input, which may be used to convert the
+// positions of the tokens into line and column information.
+func (l *Lexer) File() *token.File {
+	return l.file
+}
 
-	return l.tokens
+// Next returns the next token of the input. The last token of the input is
+// either an EOF token or an error token; any subsequent call to Next returns an
+// EOF token.
+func (l *Lexer) Next() token.Token {
+	for len(l.tokens) == 0 {
+		if l.state == nil {
+			return token.Token{Typ: token.EOF, Pos: l.position(l.pos)}
+		}
+		l.state = l.state(l)
+	}
+	tok := l.tokens[0]
+	l.tokens = l.tokens[1:]
+	return tok
 }
 
-// run lexes the input by repeatedly executing the active state function until
-// it returns a nil state.
-func (l *lexer) run() {
-	// lexLine is the initial state function of the lexer.
-	for state := lexLine; state != nil; {
-		state = state(l)
+// Parse lexes the provided input and returns a slice of scanned tokens. The
+// positions of the tokens are relative to a file of its own; use ParseFile to
+// record line information in a file set.
+func Parse(input string) (tokens []token.Token) {
+	return ParseFile(token.NewFileSet(), "", input)
+}
+
+// ParseFile lexes the provided input and returns a slice of scanned tokens. The
+// input is added as a new file with the given filename to the file set, which
+// may be used to convert the positions of the tokens into line and column
+// information.
+func ParseFile(fset *token.FileSet, filename, input string) (tokens []token.Token) {
+	l := NewFile(fset.AddFile(filename, -1, len(input)), strings.NewReader(input))
+	tokens = make([]token.Token, 0)
+
+	// Tokenize the input.
+	for {
+		tok := l.Next()
+		tokens = append(tokens, tok)
+		if tok.Typ == token.EOF || tok.Typ == token.Error {
+			return tokens
+		}
 	}
 }
 
 // errorf emits an error token and terminates the scan by returning a nil state
-// function.
-func (l *lexer) errorf(format string, args ...interface{}) stateFn {
+// function. Errors encountered while reading the input take precedence over the
+// provided error message.
+func (l *Lexer) errorf(format string, args ...interface{}) stateFn {
+	msg := fmt.Sprintf(format, args...)
+	if l.rerr != nil && l.rerr != io.EOF {
+		msg = fmt.Sprintf("lexer: unable to read input; %v", l.rerr)
+	}
 	tok := token.Token{
 		Typ: token.Error,
-		Val: fmt.Sprintf(format, args...),
+		Val: msg,
+		Pos: l.position(l.start),
 	}
 	l.tokens = append(l.tokens, tok)
 	return nil
@@ -77,12 +154,15 @@ func (l *lexer) errorf(format string, args ...interface{}) stateFn {
 
 // emitEOF emits an EOF token and terminates the scan by returning a nil state
 // function.
-func (l *lexer) emitEOF() stateFn {
-	if l.pos < len(l.input) {
-		panic(fmt.Sprintf("lexer.emitEOF: unexpected eof; pos %d < len(input) %d.\n", l.pos, len(l.input)))
+func (l *Lexer) emitEOF() stateFn {
+	if l.rerr == nil || l.pos < l.off+len(l.buf) {
+		panic(fmt.Sprintf("lexer.emitEOF: unexpected eof; pos %d < len(input) %d.\n", l.pos, l.off+len(l.buf)))
 	}
 	if l.start != l.pos {
-		panic(fmt.Sprintf("lexer.emitEOF: invalid eof; pending input %q not handled.\n", l.input[l.start:]))
+		panic(fmt.Sprintf("lexer.emitEOF: invalid eof; pending input %q not handled.\n", l.buf[l.start-l.off:]))
+	}
+	if l.rerr != io.EOF {
+		return l.errorf("lexer: unable to read input; %v", l.rerr)
 	}
 	l.emit(token.EOF)
 	return nil
@@ -90,28 +170,89 @@ func (l *lexer) emitEOF() stateFn {
 
 // emit emits a token of the provided token type and advances the token start
 // position.
-func (l *lexer) emit(typ token.Type) {
+func (l *Lexer) emit(typ token.Type) {
 	tok := token.Token{
 		Typ: typ,
-		Val: l.input[l.start:l.pos],
+		Val: string(l.buf[l.start-l.off : l.pos-l.off]),
+		Pos: l.position(l.start),
 	}
 	l.tokens = append(l.tokens, tok)
 	l.start = l.pos
+	l.last = typ
+}
+
+// value returns the input from the provided offset up to the current position.
+// The offset must not precede the start position of the current token.
+func (l *Lexer) value(offset int) string {
+	return string(l.buf[offset-l.off : l.pos-l.off])
+}
+
+// position returns the position of the provided input offset.
+func (l *Lexer) position(offset int) token.Pos {
+	return l.file.Pos(offset)
+}
+
+// fill reads more input into the buffer. The input preceding the start position
+// of the current token is discarded to bound the memory usage. It returns false
+// if no more input is available.
+func (l *Lexer) fill() bool {
+	if l.rerr != nil {
+		return false
+	}
+	// Discard input which has already been emitted or ignored.
+	if n := l.start - l.off; n > 0 {
+		l.buf = l.buf[:copy(l.buf, l.buf[n:])]
+		l.off = l.start
+	}
+	if cap(l.buf)-len(l.buf) < chunkSize {
+		buf := make([]byte, len(l.buf), 2*cap(l.buf)+chunkSize)
+		copy(buf, l.buf)
+		l.buf = buf
+	}
+	for {
+		n, err := l.r.Read(l.buf[len(l.buf):cap(l.buf)])
+		l.buf = l.buf[:len(l.buf)+n]
+		if end := l.off + len(l.buf); !l.file.Grow(end) {
+			// Discard the input exceeding the size of the file.
+			l.buf = l.buf[:len(l.buf)-(end-l.file.Size())]
+			n -= end - l.file.Size()
+			err = fmt.Errorf("input exceeds size %d of file %q", l.file.Size(), l.file.Name())
+		}
+		// Add the line offset of a newline read at the previous end of the
+		// file, which was ignored.
+		l.file.AddLine(l.line)
+		if err != nil {
+			l.rerr = err
+			return n > 0
+		}
+		if n > 0 {
+			return true
+		}
+	}
 }
 
 // next consumes the next rune of the input.
-func (l *lexer) next() (r rune) {
-	if l.pos >= len(l.input) {
+func (l *Lexer) next() (r rune) {
+	for !utf8.FullRune(l.buf[l.pos-l.off:]) {
+		if !l.fill() {
+			break
+		}
+	}
+	if l.pos >= l.off+len(l.buf) {
 		l.width = 0
 		return eof
 	}
-	r, l.width = utf8.DecodeRuneInString(l.input[l.pos:])
+	r, l.width = utf8.DecodeRune(l.buf[l.pos-l.off:])
 	l.pos += l.width
+	if r == '\n' {
+		l.line = l.pos
+		l.file.AddLine(l.pos)
+	}
 	return r
 }
 
 // peek returns but does not consume the next rune of the input.
-func (l *lexer) peek() (r rune) {
+func (l *Lexer) peek() (r rune) {
 	r = l.next()
 	if r == eof {
 		return eof
@@ -122,7 +263,7 @@ func (l *lexer) peek() (r rune) {
 
 // backup backs up one rune in the input. It can only be called once per call to
 // next.
-func (l *lexer) backup() {
+func (l *Lexer) backup() {
 	if l.width == 0 {
 		// TODO(u): Handle eof elsewhere so we never hit this case.
 		panic("lexer.backup: invalid width; no matching call to next.")
@@ -143,6 +284,12 @@ func isLetterOrDigit(r rune) bool {
 	return isLetter(r) || unicode.IsDigit(r)
 }
 
+// isIdentRune returns true if r may follow the first letter of an identifier,
+// and false otherwise.
+func isIdentRune(r rune) bool {
+	return isLetterOrDigit(r) || r == '.'
+}
+
 // isDigit returns true if r is an ASCII digit from '0' through '9', and false
 // otherwise.
 func isDigit(r rune) bool {
@@ -159,7 +306,7 @@ func isSpace(r rune) bool {
 
 // accept consumes the next rune if it's from the valid set. It returns true if
 // a rune was consumed and false otherwise.
-func (l *lexer) accept(valid string) bool {
+func (l *Lexer) accept(valid string) bool {
 	r := l.next()
 	if r == eof {
 		return false
@@ -173,7 +320,7 @@ func (l *lexer) accept(valid string) bool {
 
 // acceptFunc consumes the next rune if it's valid. It returns true if a rune
 // was consumed and false otherwise.
-func (l *lexer) acceptFunc(isValid func(rune) bool) bool {
+func (l *Lexer) acceptFunc(isValid func(rune) bool) bool {
 	r := l.next()
 	if r == eof {
 		return false
@@ -187,7 +334,7 @@ func (l *lexer) acceptFunc(isValid func(rune) bool) bool {
 
 // acceptRun consumes a run of runes from the valid set. It returns true if a
 // rune was consumed and false otherwise.
-func (l *lexer) acceptRun(valid string) bool {
+func (l *Lexer) acceptRun(valid string) bool {
 	consumed := false
 	for l.accept(valid) {
 		consumed = true
@@ -197,7 +344,7 @@ func (l *lexer) acceptRun(valid string) bool {
 
 // acceptRunFunc consumes a run of valid runes. It returns true if a rune was
 // consumed and false otherwise.
-func (l *lexer) acceptRunFunc(isValid func(rune) bool) bool {
+func (l *Lexer) acceptRunFunc(isValid func(rune) bool) bool {
 	consumed := false
 	for l.acceptFunc(isValid) {
 		consumed = true
@@ -207,21 +354,32 @@ func (l *lexer) acceptRunFunc(isValid func(rune) bool) bool {
 
 // acceptIdent consumes an identifier. It returns true if a rune was consumed
 // and false otherwise.
-func (l *lexer) acceptIdent() bool {
+func (l *Lexer) acceptIdent() bool {
 	if !l.acceptFunc(isLetter) {
 		return false
 	}
-	l.acceptRunFunc(isLetterOrDigit)
+	l.acceptRunFunc(isIdentRune)
 	return true
 }
 
+// afterOperand returns true if the last emitted token may end an operand; in
+// which case a subsequent '+' or '-' is a binary operator rather than the sign
+// of an integer literal.
+func (l *Lexer) afterOperand() bool {
+	switch l.last {
+	case token.Ident, token.Int, token.Float, token.Char, token.RParen, token.RBrack:
+		return true
+	}
+	return false
+}
+
 // ignore ignores any pending input read since the last token.
-func (l *lexer) ignore() {
+func (l *Lexer) ignore() {
 	l.start = l.pos
 }
 
 // ignoreRun ignores a run of valid runes.
-func (l *lexer) ignoreRun(valid string) {
+func (l *Lexer) ignoreRun(valid string) {
 	if l.acceptRun(valid) {
 		l.ignore()
 	}
@@ -1,8 +1,12 @@
 package lexer
 
 import (
+	"errors"
+	"io"
 	"io/ioutil"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/mewlang/asm/token"
 )
@@ -115,9 +119,351 @@ func TestParse(t *testing.T) {
 				t.Errorf("missing token; unable to access token at index %d", j)
 			}
 			got := tokens[j]
-			if got != want {
+			if got.Typ != want.Typ || got.Val != want.Val {
 				t.Errorf("i=%d: expected %v, got %v", i, want, got)
 			}
 		}
 	}
 }
+
+func TestParseFilePos(t *testing.T) {
+	golden := []struct {
+		input string
+		// Positions of the tokens in the input.
+		pos []string
+	}{
+		// i=0
+		{
+			input: "foo:\n\tmov eax, 4 ; bar\n",
+			pos:   []string{"a.asm:1:1", "a.asm:1:4", "a.asm:1:5", "a.asm:2:2", "a.asm:2:6", "a.asm:2:9", "a.asm:2:11", "a.asm:2:13", "a.asm:2:18", "a.asm:2:19"},
+		},
+		// i=1
+		{
+			input: "\n\n\tmov eax, @",
+			pos:   []string{"a.asm:1:1", "a.asm:2:1", "a.asm:3:2", "a.asm:3:6", "a.asm:3:9", "a.asm:3:11"},
+		},
+	}
+
+	for i, g := range golden {
+		fset := token.NewFileSet()
+		tokens := ParseFile(fset, "a.asm", g.input)
+		if len(tokens) != len(g.pos) {
+			t.Errorf("i=%d: token count mismatch; expected %d, got %d", i, len(g.pos), len(tokens))
+			continue
+		}
+		for j, want := range g.pos {
+			got := fset.Position(tokens[j].Pos).String()
+			if got != want {
+				t.Errorf("i=%d, j=%d: position mismatch for %v; expected %s, got %s", i, j, tokens[j], want, got)
+			}
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	const input = "héllo: db \"wörld\", 'ä' ; ünicode\n\tmov eax, 0x80\n"
+	want := Parse(input)
+
+	// Read one byte at a time to split multi-byte runes across reads.
+	fset := token.NewFileSet()
+	l := NewFile(fset.AddFile("", -1, len(input)), iotest.OneByteReader(strings.NewReader(input)))
+	for i, w := range want {
+		got := l.Next()
+		if got != w {
+			t.Errorf("i=%d: expected %v at %d, got %v at %d", i, w, w.Pos, got, got.Pos)
+		}
+	}
+	// Subsequent calls return EOF.
+	if got := l.Next(); got.Typ != token.EOF {
+		t.Errorf("expected EOF after end of input, got %v", got)
+	}
+}
+
+func TestNewPos(t *testing.T) {
+	const input = "foo:\n\tmov eax, 4 ; bar\n"
+	want := []string{"1:1", "1:4", "1:5", "2:2", "2:6", "2:9", "2:11", "2:13", "2:18", "2:19"}
+
+	// Read one byte at a time to record line offsets at the end of the input
+	// read so far.
+	l := New(iotest.OneByteReader(strings.NewReader(input)))
+	for i, w := range want {
+		tok := l.Next()
+		if got := l.File().Position(tok.Pos).String(); got != w {
+			t.Errorf("i=%d: position mismatch for %v; expected %s, got %s", i, tok, w, got)
+		}
+	}
+}
+
+func TestNewFileSize(t *testing.T) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("a.asm", -1, 4)
+	fset.AddFile("b.asm", -1, 0)
+	l := NewFile(file, strings.NewReader("mov eax, 1\n"))
+	var got token.Token
+	for got = l.Next(); got.Typ != token.EOF && got.Typ != token.Error; got = l.Next() {
+	}
+	want := `lexer: unable to read input; input exceeds size 4 of file "a.asm"`
+	if got.Typ != token.Error || got.Val != want {
+		t.Errorf("expected error token %q, got %v", want, got)
+	}
+}
+
+func TestNewReadError(t *testing.T) {
+	r := io.MultiReader(strings.NewReader("mov eax, 1\nmov"), iotest.ErrReader(errors.New("disk on fire")))
+	l := New(r)
+	var got token.Token
+	for got = l.Next(); got.Typ != token.EOF && got.Typ != token.Error; got = l.Next() {
+	}
+	want := "lexer: unable to read input; disk on fire"
+	if got.Typ != token.Error || got.Val != want {
+		t.Errorf("expected error token %q, got %v", want, got)
+	}
+}
+
+func TestNewBoundedMemory(t *testing.T) {
+	const line = "\taddi $t0, $t0, -1 ; decrement counter\n"
+	const n = 100000
+	l := New(strings.NewReader(strings.Repeat(line, n)))
+	ntoks := 0
+	for tok := l.Next(); tok.Typ != token.EOF; tok = l.Next() {
+		if tok.Typ == token.Error {
+			t.Fatalf("unexpected error token; %v", tok)
+		}
+		ntoks++
+	}
+	if want := 8 * n; ntoks != want {
+		t.Errorf("token count mismatch; expected %d, got %d", want, ntoks)
+	}
+	if max := 4 * chunkSize; cap(l.buf) > max {
+		t.Errorf("input buffer too large; expected at most %d bytes, got %d", max, cap(l.buf))
+	}
+}
+
+func TestParseOperators(t *testing.T) {
+	golden := []struct {
+		input  string
+		tokens []token.Token
+	}{
+		// i=0
+		{
+			input: "label+4",
+			tokens: []token.Token{
+				{Typ: token.Ident, Val: "label"},
+				{Typ: token.Add, Val: "+"},
+				{Typ: token.Int, Val: "4"},
+				{Typ: token.EOF},
+			},
+		},
+		// i=1
+		{
+			input: "1<<3 | buf_end - buf",
+			tokens: []token.Token{
+				{Typ: token.Int, Val: "1"},
+				{Typ: token.Shl, Val: "<<"},
+				{Typ: token.Int, Val: "3"},
+				{Typ: token.Or, Val: "|"},
+				{Typ: token.Ident, Val: "buf_end"},
+				{Typ: token.Sub, Val: "-"},
+				{Typ: token.Ident, Val: "buf"},
+				{Typ: token.EOF},
+			},
+		},
+		// i=2
+		{
+			input: "x*2/y%3&z^0x10>>1, -1",
+			tokens: []token.Token{
+				{Typ: token.Ident, Val: "x"},
+				{Typ: token.Mul, Val: "*"},
+				{Typ: token.Int, Val: "2"},
+				{Typ: token.Div, Val: "/"},
+				{Typ: token.Ident, Val: "y"},
+				{Typ: token.Mod, Val: "%"},
+				{Typ: token.Int, Val: "3"},
+				{Typ: token.And, Val: "&"},
+				{Typ: token.Ident, Val: "z"},
+				{Typ: token.Xor, Val: "^"},
+				{Typ: token.Int, Val: "0x10"},
+				{Typ: token.Shr, Val: ">>"},
+				{Typ: token.Int, Val: "1"},
+				{Typ: token.Comma, Val: ","},
+				{Typ: token.Int, Val: "-1"},
+				{Typ: token.EOF},
+			},
+		},
+		// i=3
+		{
+			input: "'a'-1 - -2",
+			tokens: []token.Token{
+				{Typ: token.Char, Val: "'a'"},
+				{Typ: token.Sub, Val: "-"},
+				{Typ: token.Int, Val: "1"},
+				{Typ: token.Sub, Val: "-"},
+				{Typ: token.Int, Val: "-2"},
+				{Typ: token.EOF},
+			},
+		},
+		// i=4
+		{
+			input: "~(x)-1",
+			tokens: []token.Token{
+				{Typ: token.Not, Val: "~"},
+				{Typ: token.LParen, Val: "("},
+				{Typ: token.Ident, Val: "x"},
+				{Typ: token.RParen, Val: ")"},
+				{Typ: token.Sub, Val: "-"},
+				{Typ: token.Int, Val: "1"},
+				{Typ: token.EOF},
+			},
+		},
+		// i=5
+		{
+			input: "1 < 2",
+			tokens: []token.Token{
+				{Typ: token.Int, Val: "1"},
+				{Typ: token.Error, Val: "lexer.lexLshOp: expected '<' after '<', got ' '"},
+			},
+		},
+		// i=6
+		{
+			input: "[ebx+ecx*4-8]",
+			tokens: []token.Token{
+				{Typ: token.LBrack, Val: "["},
+				{Typ: token.Ident, Val: "ebx"},
+				{Typ: token.Add, Val: "+"},
+				{Typ: token.Ident, Val: "ecx"},
+				{Typ: token.Mul, Val: "*"},
+				{Typ: token.Int, Val: "4"},
+				{Typ: token.Sub, Val: "-"},
+				{Typ: token.Int, Val: "8"},
+				{Typ: token.RBrack, Val: "]"},
+				{Typ: token.EOF},
+			},
+		},
+		// i=7
+		{
+			input: "[-8]",
+			tokens: []token.Token{
+				{Typ: token.LBrack, Val: "["},
+				{Typ: token.Int, Val: "-8"},
+				{Typ: token.RBrack, Val: "]"},
+				{Typ: token.EOF},
+			},
+		},
+		// i=8
+		{
+			input: "lr.w.aq a0, -8(sp)",
+			tokens: []token.Token{
+				{Typ: token.Ident, Val: "lr.w.aq"},
+				{Typ: token.Ident, Val: "a0"},
+				{Typ: token.Comma, Val: ","},
+				{Typ: token.Int, Val: "-8"},
+				{Typ: token.LParen, Val: "("},
+				{Typ: token.Ident, Val: "sp"},
+				{Typ: token.RParen, Val: ")"},
+				{Typ: token.EOF},
+			},
+		},
+		// i=9
+		{
+			input: "stmdb r0!, {r4-r7, lr}",
+			tokens: []token.Token{
+				{Typ: token.Ident, Val: "stmdb"},
+				{Typ: token.Ident, Val: "r0"},
+				{Typ: token.Excl, Val: "!"},
+				{Typ: token.Comma, Val: ","},
+				{Typ: token.LBrace, Val: "{"},
+				{Typ: token.Ident, Val: "r4"},
+				{Typ: token.Sub, Val: "-"},
+				{Typ: token.Ident, Val: "r7"},
+				{Typ: token.Comma, Val: ","},
+				{Typ: token.Ident, Val: "lr"},
+				{Typ: token.RBrace, Val: "}"},
+				{Typ: token.EOF},
+			},
+		},
+		// i=10
+		{
+			input: "ldr r0, [r1, #-4]",
+			tokens: []token.Token{
+				{Typ: token.Ident, Val: "ldr"},
+				{Typ: token.Ident, Val: "r0"},
+				{Typ: token.Comma, Val: ","},
+				{Typ: token.LBrack, Val: "["},
+				{Typ: token.Ident, Val: "r1"},
+				{Typ: token.Comma, Val: ","},
+				{Typ: token.Hash, Val: "#"},
+				{Typ: token.Int, Val: "-4"},
+				{Typ: token.RBrack, Val: "]"},
+				{Typ: token.EOF},
+			},
+		},
+		// i=11
+		{
+			input: "ldr r0, =0x12345678",
+			tokens: []token.Token{
+				{Typ: token.Ident, Val: "ldr"},
+				{Typ: token.Ident, Val: "r0"},
+				{Typ: token.Comma, Val: ","},
+				{Typ: token.Eq, Val: "="},
+				{Typ: token.Int, Val: "0x12345678"},
+				{Typ: token.EOF},
+			},
+		},
+	}
+
+	for i, g := range golden {
+		tokens := Parse(g.input)
+		if len(tokens) != len(g.tokens) {
+			t.Errorf("i=%d: token count mismatch; expected %d, got %d", i, len(g.tokens), len(tokens))
+			continue
+		}
+		for j, want := range g.tokens {
+			got := tokens[j]
+			if got.Typ != want.Typ || got.Val != want.Val {
+				t.Errorf("i=%d, j=%d: expected %v, got %v", i, j, want, got)
+			}
+		}
+	}
+}
+
+func TestParseFloat(t *testing.T) {
+	golden := []struct {
+		input string
+		want  token.Token
+	}{
+		// i=0
+		{input: "1.5e3", want: token.Token{Typ: token.Float, Val: "1.5e3"}},
+		// i=1
+		{input: "0x1.8p1", want: token.Token{Typ: token.Float, Val: "0x1.8p1"}},
+		// i=2
+		{input: "-2.", want: token.Token{Typ: token.Float, Val: "-2."}},
+		// i=3
+		{input: ".5", want: token.Token{Typ: token.Float, Val: ".5"}},
+		// i=4
+		{input: "1E-7", want: token.Token{Typ: token.Float, Val: "1E-7"}},
+		// i=5
+		{input: "0.25", want: token.Token{Typ: token.Float, Val: "0.25"}},
+		// i=6
+		{input: "09.5", want: token.Token{Typ: token.Float, Val: "09.5"}},
+		// i=7
+		{input: "0x1p-2", want: token.Token{Typ: token.Float, Val: "0x1p-2"}},
+		// i=8
+		{input: ".loop", want: token.Token{Typ: token.Ident, Val: ".loop"}},
+		// i=9
+		{input: "0x1e3", want: token.Token{Typ: token.Int, Val: "0x1e3"}},
+		// i=10
+		{input: "1e", want: token.Token{Typ: token.Error, Val: "lexer.lexFloatFrac: missing exponent digits in floating-point literal"}},
+		// i=11
+		{input: "0x1.8", want: token.Token{Typ: token.Error, Val: "lexer.lexHexFloatFrac: missing 'p' exponent in hexadecimal floating-point literal"}},
+		// i=12
+		{input: "09", want: token.Token{Typ: token.Error, Val: "lexer.lexIntLit: invalid digit in octal literal"}},
+	}
+
+	for i, g := range golden {
+		tokens := Parse(g.input)
+		got := tokens[0]
+		if got.Typ != g.want.Typ || got.Val != g.want.Val {
+			t.Errorf("i=%d: expected %v, got %v", i, g.want, got)
+		}
+	}
+}
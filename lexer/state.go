@@ -3,16 +3,17 @@ package lexer
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/mewlang/asm/token"
 )
 
 // A stateFn represents the state of the scanner as a function that returns a
 // state function.
-type stateFn func(l *lexer) stateFn
+type stateFn func(l *Lexer) stateFn
 
 // lexLine lexes a line. It is the initial state function of the lexer.
-func lexLine(l *lexer) stateFn {
+func lexLine(l *Lexer) stateFn {
 	for {
 		r := l.next()
 		switch {
@@ -26,19 +27,22 @@ func lexLine(l *lexer) stateFn {
 			// Lex label, operator, register or directive identifier.
 			return lexIdent
 		case r == '.':
+			if isDigit(l.peek()) {
+				// Lex floating-point literal without integer part; e.g. .5
+				return lexFloatFrac
+			}
 			// Lex local label identifier.
-			// TODO(u): Add support for floating-point literals.
 			return lexIdent
 		case r == ':':
 			// Lex label declaration colon.
 			return lexColon
 		case r == '+':
-			if isDigit(l.peek()) {
+			if isDigit(l.peek()) && !l.afterOperand() {
 				return lexIntLit
 			}
 			return lexAddOp
 		case r == '-':
-			if isDigit(l.peek()) {
+			if isDigit(l.peek()) && !l.afterOperand() {
 				return lexIntLit
 			}
 			return lexSubOp
@@ -58,6 +62,26 @@ func lexLine(l *lexer) stateFn {
 			return lexLshOp
 		case r == '>':
 			return lexRshOp
+		case r == '~':
+			return lexNotOp
+		case r == '(':
+			return lexLParen
+		case r == ')':
+			return lexRParen
+		case r == '[':
+			return lexLBrack
+		case r == ']':
+			return lexRBrack
+		case r == '{':
+			return lexLBrace
+		case r == '}':
+			return lexRBrace
+		case r == '#':
+			return lexHash
+		case r == '!':
+			return lexExcl
+		case r == '=':
+			return lexEq
 		case isDigit(r):
 			// Lex integer literal.
 			l.backup()
@@ -90,28 +114,30 @@ func lexLine(l *lexer) stateFn {
 }
 
 // lexIdent lexes an identifier. A '.' or a '$' may have been consumed already.
-func lexIdent(l *lexer) stateFn {
+// Identifiers may contain dots after the first letter; e.g. lr.w.
+func lexIdent(l *Lexer) stateFn {
 	if !l.acceptFunc(isLetter) {
 		return l.errorf("lexer.lexIdent: expected Unicode letter or underscore, got '%c'", l.next())
 	}
-	l.acceptRunFunc(isLetterOrDigit)
+	l.acceptRunFunc(isIdentRune)
 	l.emit(token.Ident)
 	return lexLine
 }
 
 // lexColon lexes a label declaration colon. A ':' has already been consumed.
-func lexColon(l *lexer) stateFn {
+func lexColon(l *Lexer) stateFn {
 	l.emit(token.Colon)
 	return lexLine
 }
 
-// lexIntLit lexes an integer literal. No characters have been consumed already.
-func lexIntLit(l *lexer) stateFn {
+// lexIntLit lexes an integer literal or a floating-point literal. No characters
+// have been consumed already, except for an optional sign.
+func lexIntLit(l *Lexer) stateFn {
 	// Optional sign.
 	l.accept("+-")
 
 	// Decimal digits.
-	digits := "0123456789"
+	digits := decimalDigits
 	hasIntLit := false
 	r := l.next()
 	switch {
@@ -121,13 +147,13 @@ func lexIntLit(l *lexer) stateFn {
 		switch {
 		case l.accept("xX"):
 			// Hexadecimal digits.
-			digits = "0123456789abcdefABCDEF"
+			digits = hexDigits
 		case l.accept("b"):
 			// Binary digits.
 			digits = "01"
 		default:
 			// Octal digits.
-			digits = "01234567"
+			digits = octalDigits
 
 			// The octal literal '0' has been consumed.
 			hasIntLit = true
@@ -138,17 +164,78 @@ func lexIntLit(l *lexer) stateFn {
 	default:
 		return l.errorf("lexer.lexIntLit: expected decimal digit, got %c", r)
 	}
+	if digits == octalDigits {
+		// The integer part of a decimal floating-point literal may have a leading
+		// zero; e.g. 09.5.
+		start := l.pos
+		l.acceptRun(decimalDigits)
+		if r := l.peek(); r == '.' || r == 'e' || r == 'E' {
+			return lexFloatFrac
+		}
+		if strings.ContainsAny(l.value(start), "89") {
+			return l.errorf("lexer.lexIntLit: invalid digit in octal literal")
+		}
+		l.emit(token.Int)
+		return lexLine
+	}
 	if !l.acceptRun(digits) && !hasIntLit {
 		return l.errorf("lexer.lexIntLit: missing digits in integer literal")
 	}
+	switch r := l.peek(); {
+	case digits == decimalDigits && (r == '.' || r == 'e' || r == 'E'):
+		return lexFloatFrac
+	case digits == hexDigits && (r == '.' || r == 'p' || r == 'P'):
+		return lexHexFloatFrac
+	}
 	l.emit(token.Int)
 
 	return lexLine
 }
 
+// lexFloatFrac lexes the fractional part and the exponent of a decimal
+// floating-point literal. The integer part, if any, has already been consumed;
+// as has the decimal point of literals without integer part.
+func lexFloatFrac(l *Lexer) stateFn {
+	hasMantissa := strings.ContainsAny(l.value(l.start), decimalDigits)
+	if strings.HasSuffix(l.value(l.start), ".") || l.accept(".") {
+		if l.acceptRun(decimalDigits) {
+			hasMantissa = true
+		}
+	}
+	if !hasMantissa {
+		return l.errorf("lexer.lexFloatFrac: missing digits in floating-point literal")
+	}
+	if l.accept("eE") {
+		l.accept("+-")
+		if !l.acceptRun(decimalDigits) {
+			return l.errorf("lexer.lexFloatFrac: missing exponent digits in floating-point literal")
+		}
+	}
+	l.emit(token.Float)
+	return lexLine
+}
+
+// lexHexFloatFrac lexes the fractional part and the mandatory binary exponent
+// of a hexadecimal floating-point literal. The hexadecimal integer part has
+// already been consumed.
+func lexHexFloatFrac(l *Lexer) stateFn {
+	if l.accept(".") {
+		l.acceptRun(hexDigits)
+	}
+	if !l.accept("pP") {
+		return l.errorf("lexer.lexHexFloatFrac: missing 'p' exponent in hexadecimal floating-point literal")
+	}
+	l.accept("+-")
+	if !l.acceptRun(decimalDigits) {
+		return l.errorf("lexer.lexHexFloatFrac: missing exponent digits in floating-point literal")
+	}
+	l.emit(token.Float)
+	return lexLine
+}
+
 // lexCharLit lexes a character literal. A single quote has already been
 // consumed.
-func lexCharLit(l *lexer) stateFn {
+func lexCharLit(l *Lexer) stateFn {
 	if l.accept(`\`) {
 		// Consume backslash escape sequence.
 		err := consumeEscape(l, '\'')
@@ -176,7 +263,7 @@ func lexCharLit(l *lexer) stateFn {
 }
 
 // lexStringLit lexes a string literal. A '"' has already been consumed.
-func lexStringLit(l *lexer) stateFn {
+func lexStringLit(l *Lexer) stateFn {
 	for {
 		r := l.next()
 		switch r {
@@ -198,7 +285,7 @@ func lexStringLit(l *lexer) stateFn {
 }
 
 // lexRawStringLit lexes a raw string literal. A '`' has already been consumed.
-func lexRawStringLit(l *lexer) stateFn {
+func lexRawStringLit(l *Lexer) stateFn {
 	for {
 		r := l.next()
 		switch r {
@@ -215,7 +302,7 @@ func lexRawStringLit(l *lexer) stateFn {
 // consumed. A valid single-character escape sequence is specified by valid.
 // Single quotes are only valid within character literals and double quotes are
 // only valid within string literals.
-func consumeEscape(l *lexer, valid rune) (err error) {
+func consumeEscape(l *Lexer, valid rune) (err error) {
 	// Several backslash escapes allow arbitrary values to be encoded as ASCII
 	// text. There are two ways to represent the integer value as a numeric
 	// constant: \x followed by exactly two hexadecimal digits, and a plain
@@ -269,20 +356,19 @@ func consumeEscape(l *lexer, valid rune) (err error) {
 }
 
 // lexComma lexes an operand separation comma. A ',' has already been consumed.
-func lexComma(l *lexer) stateFn {
+func lexComma(l *Lexer) stateFn {
 	l.emit(token.Comma)
 	return lexLine
 }
 
 // lexLineComment lexes a line comment. A ';' has already been consumed.
-func lexLineComment(l *lexer) stateFn {
+func lexLineComment(l *Lexer) stateFn {
 	for {
 		r := l.next()
 		switch r {
 		case eof:
 			l.emit(token.LineComment)
-			l.emit(token.EOF)
-			return nil
+			return l.emitEOF()
 		case '\n':
 			l.backup()
 			l.emit(token.LineComment)
@@ -292,47 +378,133 @@ func lexLineComment(l *lexer) stateFn {
 }
 
 // lexNewline lexes a newline. A '\n' has already been consumed.
-func lexNewline(l *lexer) stateFn {
+func lexNewline(l *Lexer) stateFn {
 	l.emit(token.Newline)
 	return lexLine
 }
 
-func lexAddOp(l *lexer) stateFn {
-	panic("not yet implemented.")
+// lexAddOp lexes an addition operator. A '+' has already been consumed.
+func lexAddOp(l *Lexer) stateFn {
+	l.emit(token.Add)
+	return lexLine
+}
+
+// lexSubOp lexes a subtraction operator. A '-' has already been consumed.
+func lexSubOp(l *Lexer) stateFn {
+	l.emit(token.Sub)
+	return lexLine
+}
+
+// lexMulOp lexes a multiplication operator. A '*' has already been consumed.
+func lexMulOp(l *Lexer) stateFn {
+	l.emit(token.Mul)
+	return lexLine
+}
+
+// lexDivOp lexes a division operator. A '/' has already been consumed.
+func lexDivOp(l *Lexer) stateFn {
+	l.emit(token.Div)
+	return lexLine
 }
 
-func lexSubOp(l *lexer) stateFn {
-	panic("not yet implemented.")
+// lexModOp lexes a modulo operator. A '%' has already been consumed.
+func lexModOp(l *Lexer) stateFn {
+	l.emit(token.Mod)
+	return lexLine
 }
 
-func lexMulOp(l *lexer) stateFn {
-	panic("not yet implemented.")
+// lexAndOp lexes a bitwise AND operator. A '&' has already been consumed.
+func lexAndOp(l *Lexer) stateFn {
+	l.emit(token.And)
+	return lexLine
 }
 
-func lexDivOp(l *lexer) stateFn {
-	panic("not yet implemented.")
+// lexOrOp lexes a bitwise OR operator. A '|' has already been consumed.
+func lexOrOp(l *Lexer) stateFn {
+	l.emit(token.Or)
+	return lexLine
 }
 
-func lexModOp(l *lexer) stateFn {
-	panic("not yet implemented.")
+// lexXorOp lexes a bitwise XOR operator. A '^' has already been consumed.
+func lexXorOp(l *Lexer) stateFn {
+	l.emit(token.Xor)
+	return lexLine
 }
 
-func lexAndOp(l *lexer) stateFn {
-	panic("not yet implemented.")
+// lexLshOp lexes a left shift operator. A '<' has already been consumed.
+func lexLshOp(l *Lexer) stateFn {
+	if !l.accept("<") {
+		return l.errorf("lexer.lexLshOp: expected '<' after '<', got '%c'", l.next())
+	}
+	l.emit(token.Shl)
+	return lexLine
 }
 
-func lexOrOp(l *lexer) stateFn {
-	panic("not yet implemented.")
+// lexRshOp lexes a right shift operator. A '>' has already been consumed.
+func lexRshOp(l *Lexer) stateFn {
+	if !l.accept(">") {
+		return l.errorf("lexer.lexRshOp: expected '>' after '>', got '%c'", l.next())
+	}
+	l.emit(token.Shr)
+	return lexLine
 }
 
-func lexXorOp(l *lexer) stateFn {
-	panic("not yet implemented.")
+// lexNotOp lexes a bitwise NOT operator. A '~' has already been consumed.
+func lexNotOp(l *Lexer) stateFn {
+	l.emit(token.Not)
+	return lexLine
+}
+
+// lexLParen lexes a left parenthesis. A '(' has already been consumed.
+func lexLParen(l *Lexer) stateFn {
+	l.emit(token.LParen)
+	return lexLine
 }
 
-func lexLshOp(l *lexer) stateFn {
-	panic("not yet implemented.")
+// lexRParen lexes a right parenthesis. A ')' has already been consumed.
+func lexRParen(l *Lexer) stateFn {
+	l.emit(token.RParen)
+	return lexLine
+}
+
+// lexLBrack lexes a left bracket. A '[' has already been consumed.
+func lexLBrack(l *Lexer) stateFn {
+	l.emit(token.LBrack)
+	return lexLine
 }
 
-func lexRshOp(l *lexer) stateFn {
-	panic("not yet implemented.")
+// lexRBrack lexes a right bracket. A ']' has already been consumed.
+func lexRBrack(l *Lexer) stateFn {
+	l.emit(token.RBrack)
+	return lexLine
+}
+
+// lexLBrace lexes a left brace. A '{' has already been consumed.
+func lexLBrace(l *Lexer) stateFn {
+	l.emit(token.LBrace)
+	return lexLine
+}
+
+// lexRBrace lexes a right brace. A '}' has already been consumed.
+func lexRBrace(l *Lexer) stateFn {
+	l.emit(token.RBrace)
+	return lexLine
+}
+
+// lexHash lexes an immediate prefix. A '#' has already been consumed.
+func lexHash(l *Lexer) stateFn {
+	l.emit(token.Hash)
+	return lexLine
+}
+
+// lexExcl lexes a writeback suffix. A '!' has already been consumed.
+func lexExcl(l *Lexer) stateFn {
+	l.emit(token.Excl)
+	return lexLine
+}
+
+// lexEq lexes a literal pool prefix. A '=' has already been consumed.
+func lexEq(l *Lexer) stateFn {
+	l.emit(token.Eq)
+	return lexLine
 }
@@ -0,0 +1,51 @@
+// Package bin implements a writer of flat binaries; files which hold the raw
+// memory image of a program, such as boot sectors and firmware images.
+//
+// The first byte of a flat binary is located at the origin address of the
+// program, as specified by an org directive or else by the configured origin;
+// e.g. a boot sector of the PC BIOS starts with
+//
+//	org 0x7C00
+//
+// The gaps between sections are filled with zero bytes.
+package bin
+
+import (
+	"io"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/obj"
+)
+
+// Format is the flat binary output format.
+type Format struct {
+	// Origin is the address of the first byte of programs without an org
+	// directive.
+	Origin int64
+}
+
+// Config returns the assembler configuration of flat binaries, which places
+// programs without an org directive at the configured origin.
+func (f *Format) Config(a arch.Arch) (*asm.Config, error) {
+	return &asm.Config{Origin: f.Origin}, nil
+}
+
+// Write writes the flat binary of the program of o to w.
+func (f *Format) Write(w io.Writer, o *asm.Object) error {
+	return Write(w, o)
+}
+
+// Write writes the flat binary of the program of o to w.
+func Write(w io.Writer, o *asm.Object) error {
+	blocks, err := obj.Blocks(o, true)
+	if err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		if _, err := w.Write(block.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
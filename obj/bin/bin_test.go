@@ -0,0 +1,65 @@
+package bin
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mewlang/asm/asm/x86"
+	"github.com/mewlang/asm/parser"
+	"github.com/mewlang/asm/token"
+)
+
+func TestWrite(t *testing.T) {
+	golden := []struct {
+		src    string
+		origin int64
+		want   []byte
+	}{
+		// i=0
+		{
+			src:    "start:\n\tjmp start\n\tsection .data\n\tdb 'h', 'i'",
+			origin: 0x7C00,
+			want:   []byte{0xEB, 0xFE, 0x00, 0x00, 'h', 'i'},
+		},
+		// i=1
+		{
+			src:    "\torg 0x100\nstart:\n\tmov eax, start",
+			origin: 0x7C00,
+			want:   []byte{0xB8, 0x00, 0x01, 0x00, 0x00},
+		},
+		// i=2
+		{
+			src:    "start:\n\tmov eax, start",
+			origin: 0x7C00,
+			want:   []byte{0xB8, 0x00, 0x7C, 0x00, 0x00},
+		},
+	}
+
+	for i, g := range golden {
+		f := &Format{Origin: g.origin}
+		fset := token.NewFileSet()
+		prog, err := (&parser.Config{Arch: x86.Encoder.Arch()}).ParseFile(fset, "", g.src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		conf, err := f.Config(x86.Encoder.Arch())
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		obj, err := conf.Assemble(fset, x86.Encoder, prog)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		buf := new(bytes.Buffer)
+		if err := f.Write(buf, obj); err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if got := buf.Bytes(); !bytes.Equal(got, g.want) {
+			t.Errorf("i=%d: output mismatch; expected %x, got %x", i, g.want, got)
+		}
+	}
+}
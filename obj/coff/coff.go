@@ -0,0 +1,483 @@
+// Package coff implements a writer of COFF relocatable objects; the object file
+// format of Windows, which is linked into PE executables.
+//
+// Objects hold the sections of a program assembled using the configuration
+// returned by Format.Config, a symbol table and the relocation records of each
+// section; as supported for the x86, x86-64, arm64 and thumb architectures. The
+// characteristics of sections are given by the kind of their names, as
+// specified by obj.SectionKind. The targets of arm64 and thumb branches to
+// other sections and external symbols must be the start of the section or the
+// symbol, as linkers ignore the offset encoded in the instruction.
+//
+// Each section has a static section symbol, to which references to the section
+// are relocated. Labels exported by a global directive and external symbols are
+// external symbols of the symbol table, while all other labels and constants
+// are static symbols.
+package coff
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/internal/bin"
+	"github.com/mewlang/asm/obj"
+	"github.com/mewlang/asm/symtab"
+)
+
+// A machine describes the COFF representation of an architecture.
+type machine struct {
+	// COFF machine.
+	mach uint16
+	// Relocation types, indexed by field size and PC-relativity.
+	relocs map[relocKind]uint16
+	// Relocation types of the bit fields of instructions.
+	insts map[symtab.RelocType]uint16
+}
+
+// A relocKind specifies the kind of field of a relocation record.
+type relocKind struct {
+	// Size in bytes of the field.
+	size int
+	// PC-relative field.
+	pcrel bool
+}
+
+// machines maps from architecture name to COFF machine.
+var machines = map[string]*machine{
+	"x86": {
+		mach: pe.IMAGE_FILE_MACHINE_I386,
+		relocs: map[relocKind]uint16{
+			// IMAGE_REL_I386_DIR32 and IMAGE_REL_I386_REL32.
+			{4, false}: 0x0006,
+			{4, true}:  0x0014,
+		},
+	},
+	"x86-64": {
+		mach: pe.IMAGE_FILE_MACHINE_AMD64,
+		relocs: map[relocKind]uint16{
+			// IMAGE_REL_AMD64_ADDR32, IMAGE_REL_AMD64_REL32 and
+			// IMAGE_REL_AMD64_ADDR64.
+			{4, false}: 0x0002,
+			{4, true}:  0x0004,
+			{8, false}: 0x0001,
+		},
+	},
+	"arm64": {
+		mach: pe.IMAGE_FILE_MACHINE_ARM64,
+		relocs: map[relocKind]uint16{
+			// IMAGE_REL_ARM64_ADDR32, IMAGE_REL_ARM64_REL32 and
+			// IMAGE_REL_ARM64_ADDR64.
+			{4, false}: 0x0001,
+			{4, true}:  0x0011,
+			{8, false}: 0x000E,
+		},
+		insts: map[symtab.RelocType]uint16{
+			// IMAGE_REL_ARM64_BRANCH26, IMAGE_REL_ARM64_PAGEBASE_REL21 and
+			// IMAGE_REL_ARM64_PAGEOFFSET_12A.
+			symtab.ARM64Branch26:  0x0003,
+			symtab.ARM64Page21:    0x0004,
+			symtab.ARM64PageOff12: 0x0006,
+		},
+	},
+	"thumb": {
+		mach: pe.IMAGE_FILE_MACHINE_ARMNT,
+		relocs: map[relocKind]uint16{
+			// IMAGE_REL_ARM_ADDR32 and IMAGE_REL_ARM_REL32.
+			{4, false}: 0x0001,
+			{4, true}:  0x000A,
+		},
+		insts: map[symtab.RelocType]uint16{
+			// IMAGE_REL_ARM_BLX23T and IMAGE_REL_ARM_BRANCH24T; as used by LLVM
+			// for bl and b.w respectively.
+			symtab.ThumbCall:   0x0015,
+			symtab.ThumbJump24: 0x0014,
+		},
+	},
+}
+
+// Section characteristics.
+const (
+	// Alignment of 16 bytes.
+	align16 = 0x00500000
+	// Characteristics of executable code.
+	textFlags = pe.IMAGE_SCN_CNT_CODE | pe.IMAGE_SCN_MEM_EXECUTE | pe.IMAGE_SCN_MEM_READ
+	// Characteristics of read-only data.
+	rodataFlags = pe.IMAGE_SCN_CNT_INITIALIZED_DATA | pe.IMAGE_SCN_MEM_READ
+	// Characteristics of writable data.
+	dataFlags = pe.IMAGE_SCN_CNT_INITIALIZED_DATA | pe.IMAGE_SCN_MEM_READ | pe.IMAGE_SCN_MEM_WRITE
+	// Characteristics of zero-initialized data.
+	bssFlags = pe.IMAGE_SCN_CNT_UNINITIALIZED_DATA | pe.IMAGE_SCN_MEM_READ | pe.IMAGE_SCN_MEM_WRITE
+)
+
+// Special section numbers of symbols.
+const (
+	// Undefined symbol.
+	undefSection = 0
+	// Absolute symbol.
+	absSection = -1
+)
+
+// Storage classes of symbols.
+const (
+	// External symbol.
+	classExternal = 2
+	// Static symbol.
+	classStatic = 3
+)
+
+// Sizes in bytes of the structures of COFF objects.
+const (
+	headerSize  = 20
+	sectionSize = 40
+	relocSize   = 10
+)
+
+// Format is the COFF relocatable object output format.
+type Format struct{}
+
+// Config returns the assembler configuration of relocatable objects.
+func (f *Format) Config(a arch.Arch) (*asm.Config, error) {
+	if _, ok := machines[a.Name()]; !ok {
+		return nil, fmt.Errorf("coff.Format.Config: unsupported architecture %q", a.Name())
+	}
+	return &asm.Config{Relocatable: true}, nil
+}
+
+// Write writes the relocatable object of obj to w.
+func (f *Format) Write(w io.Writer, obj *asm.Object) error {
+	return Write(w, obj)
+}
+
+// A section is a section of a COFF object.
+type section struct {
+	// Section of the program.
+	sect *asm.Section
+	// Characteristics of the section.
+	flags uint32
+	// Contents of the section, with the fields of relocation records set to
+	// their addends.
+	data []byte
+	// Relocation entries of the section.
+	relocs []byte
+}
+
+// Write writes the COFF relocatable object of obj to w. The program of obj
+// must be assembled as a relocatable object.
+func Write(w io.Writer, obj *asm.Object) error {
+	if obj.Symtab == nil || !obj.Symtab.Layout().Relocatable {
+		return fmt.Errorf("coff.Write: program not assembled as a relocatable object")
+	}
+	mach, ok := machines[obj.Arch.Name()]
+	if !ok {
+		return fmt.Errorf("coff.Write: unsupported architecture %q", obj.Arch.Name())
+	}
+	order := obj.Arch.ByteOrder()
+	sects, err := sections(obj)
+	if err != nil {
+		return err
+	}
+	strtab := newStrtab()
+	symbuf, index, err := symbols(obj, sects, strtab, order)
+	if err != nil {
+		return err
+	}
+	for _, s := range sects {
+		if err := s.relocate(mach, order, index); err != nil {
+			return err
+		}
+	}
+
+	// Lay out the object; the section headers are followed by the contents and
+	// relocation entries of each section, the symbol table and the string
+	// table.
+	off := int64(headerSize + sectionSize*len(sects))
+	dataoffs := make([]int64, len(sects))
+	reloffs := make([]int64, len(sects))
+	for i, s := range sects {
+		if s.flags&pe.IMAGE_SCN_CNT_UNINITIALIZED_DATA == 0 {
+			dataoffs[i] = off
+			off += int64(len(s.data))
+		}
+		if len(s.relocs) > 0 {
+			reloffs[i] = off
+			off += int64(len(s.relocs))
+		}
+	}
+
+	// Write the file header and section headers.
+	buf := new(bytes.Buffer)
+	bin.Write(buf, order, &pe.FileHeader{
+		Machine:              mach.mach,
+		NumberOfSections:     uint16(len(sects)),
+		PointerToSymbolTable: uint32(off),
+		NumberOfSymbols:      uint32(symbuf.Len() / pe.COFFSymbolSize),
+	})
+	for i, s := range sects {
+		hdr := &pe.SectionHeader32{
+			SizeOfRawData:        uint32(len(s.sect.Data)),
+			PointerToRawData:     uint32(dataoffs[i]),
+			PointerToRelocations: uint32(reloffs[i]),
+			NumberOfRelocations:  uint16(len(s.relocs) / relocSize),
+			Characteristics:      s.flags,
+		}
+		copy(hdr.Name[:], sectionName(s.sect.Name, strtab))
+		bin.Write(buf, order, hdr)
+	}
+
+	// Write the contents and relocation entries of the sections, the symbol
+	// table and the string table.
+	for _, s := range sects {
+		if s.flags&pe.IMAGE_SCN_CNT_UNINITIALIZED_DATA == 0 {
+			buf.Write(s.data)
+		}
+		buf.Write(s.relocs)
+	}
+	buf.Write(symbuf.Bytes())
+	bin.Write(buf, order, uint32(strtab.Len()+4))
+	buf.Write(strtab.Bytes())
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// sections returns the sections of the object holding the sections of o.
+func sections(o *asm.Object) ([]*section, error) {
+	var sects []*section
+	for _, sect := range o.Sections {
+		s := &section{sect: sect, data: sect.Data}
+		switch obj.SectionKind(sect.Name) {
+		case obj.Text:
+			s.flags = textFlags
+		case obj.ROData:
+			s.flags = rodataFlags
+		case obj.Data:
+			s.flags = dataFlags
+		case obj.BSS:
+			for _, b := range sect.Data {
+				if b != 0 {
+					return nil, fmt.Errorf("coff.sections: non-zero data in %q section", sect.Name)
+				}
+			}
+			if len(sect.Relocs) > 0 {
+				return nil, fmt.Errorf("coff.sections: relocations in %q section", sect.Name)
+			}
+			s.flags = bssFlags
+		}
+		s.flags |= align16
+		sects = append(sects, s)
+	}
+	return sects, nil
+}
+
+// symbols returns the symbol table of the symbols of obj, whose long names are
+// added to strtab. Each section has a section symbol, which precedes the
+// symbols of obj. The returned index maps from the name of each section and
+// external symbol to its symbol table index.
+func symbols(obj *asm.Object, sects []*section, strtab *strtab, order binary.ByteOrder) (*bytes.Buffer, map[string]uint32, error) {
+	buf := new(bytes.Buffer)
+	index := make(map[string]uint32)
+	n := uint32(0)
+	add := func(name string, value uint32, sect int16, class uint8, aux []byte) {
+		sym := &pe.COFFSymbol{
+			Value:         value,
+			SectionNumber: sect,
+			StorageClass:  class,
+		}
+		if len(name) <= len(sym.Name) {
+			copy(sym.Name[:], name)
+		} else {
+			order.PutUint32(sym.Name[4:], strtab.add(name))
+		}
+		if aux != nil {
+			sym.NumberOfAuxSymbols = 1
+		}
+		bin.Write(buf, order, sym)
+		buf.Write(aux)
+		n += 1 + uint32(sym.NumberOfAuxSymbols)
+	}
+	for i, s := range sects {
+		// Auxiliary section definition record.
+		aux := make([]byte, pe.COFFSymbolSize)
+		order.PutUint32(aux[0:], uint32(len(s.sect.Data)))
+		order.PutUint16(aux[4:], uint16(len(s.sect.Relocs)))
+		order.PutUint16(aux[12:], uint16(i+1))
+		index[s.sect.Name] = n
+		add(s.sect.Name, 0, int16(i+1), classStatic, aux)
+	}
+	for _, sym := range obj.Symtab.Symbols() {
+		class := uint8(classStatic)
+		if sym.Global || sym.Kind == symtab.Extern {
+			class = classExternal
+		}
+		switch sym.Kind {
+		case symtab.Label:
+			i := sectionIndex(sects, sym.Section)
+			if i == 0 {
+				return nil, nil, fmt.Errorf("coff.symbols: unable to locate section %q of symbol %q", sym.Section, sym.Name)
+			}
+			add(sym.Name, uint32(sym.Value-sects[i-1].sect.Addr), int16(i), class, nil)
+		case symtab.Const:
+			add(sym.Name, uint32(sym.Value), absSection, class, nil)
+		case symtab.Extern:
+			index[sym.Name] = n
+			add(sym.Name, 0, undefSection, class, nil)
+		}
+	}
+	for _, name := range obj.Globals {
+		if obj.Symtab.Symbol(name) == nil {
+			return nil, nil, fmt.Errorf("coff.symbols: undefined global symbol %q", name)
+		}
+	}
+	return buf, index, nil
+}
+
+// sectionIndex returns the section number of the named section of the program;
+// or 0 if not present.
+func sectionIndex(sects []*section, name string) int {
+	for i, s := range sects {
+		if s.sect.Name == name {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// sectionName returns the name field of the section header of the named
+// section. Names longer than 8 bytes are added to strtab, and referred to by
+// their offset.
+func sectionName(name string, strtab *strtab) string {
+	if len(name) <= 8 {
+		return name
+	}
+	return "/" + strconv.Itoa(int(strtab.add(name)))
+}
+
+// relocate sets the relocation entries of the section, and the fields of its
+// relocation records to their addends. References to sections are relocated
+// relative to their section symbol, and references to external symbols relative
+// to the symbol; the fields of PC-relative records are relative to the end of
+// the field. The symbol table index of sections and external symbols is given
+// by index.
+func (s *section) relocate(mach *machine, order binary.ByteOrder, index map[string]uint32) error {
+	if len(s.sect.Relocs) == 0 {
+		return nil
+	}
+	if len(s.sect.Relocs) > 0xFFFF {
+		return fmt.Errorf("coff.section.relocate: %d relocations of %q section exceeds 65535", len(s.sect.Relocs), s.sect.Name)
+	}
+	s.data = append([]byte(nil), s.data...)
+	buf := new(bytes.Buffer)
+	for _, r := range s.sect.Relocs {
+		typ, ok := mach.relocs[relocKind{size: r.Size, pcrel: r.PCRel}]
+		if r.Type != symtab.Field {
+			typ, ok = mach.insts[r.Type]
+			if !ok {
+				return fmt.Errorf("coff.section.relocate: unsupported %v relocation of %q section", r.Type, s.sect.Name)
+			}
+		} else if !ok {
+			kind := "absolute"
+			if r.PCRel {
+				kind = "PC-relative"
+			}
+			return fmt.Errorf("coff.section.relocate: unsupported %d-byte %s relocation of %q section", r.Size, kind, s.sect.Name)
+		}
+		sym := index[r.Section]
+		if r.Symbol != "" {
+			sym = index[r.Symbol]
+		}
+		if r.Type != symtab.Field {
+			if err := s.patch(order, r); err != nil {
+				return err
+			}
+		} else {
+			val := r.Addend
+			if r.PCRel {
+				val += int64(r.Size)
+			}
+			put(order, s.data[r.Offset:r.Offset+int64(r.Size)], uint64(val))
+		}
+		bin.Write(buf, order, &pe.Reloc{
+			VirtualAddress:   uint32(r.Offset),
+			SymbolTableIndex: sym,
+			Type:             typ,
+		})
+	}
+	s.relocs = buf.Bytes()
+	return nil
+}
+
+// patch stores the addend of the relocation record r of a bit field of an
+// instruction in the field. The fields of branch instructions are overwritten
+// by linkers, and their targets may therefore not be offset from the symbol or
+// section referred to.
+func (s *section) patch(order binary.ByteOrder, r symtab.Reloc) error {
+	b := s.data[r.Offset : r.Offset+int64(r.Size)]
+	switch r.Type {
+	case symtab.ARM64Page21:
+		// The addend is the signed 21-bit immediate immhi:immlo of adrp.
+		if r.Addend < -1<<20 || r.Addend >= 1<<20 {
+			return fmt.Errorf("coff.section.patch: addend %d of %v relocation of %q section out of range", r.Addend, r.Type, s.sect.Name)
+		}
+		u := uint32(r.Addend)
+		order.PutUint32(b, order.Uint32(b)|u&3<<29|u>>2&0x7FFFF<<5)
+	case symtab.ARM64PageOff12:
+		order.PutUint32(b, order.Uint32(b)|uint32(r.Addend)&0xFFF<<10)
+	default:
+		off := r.Addend
+		if r.Type == symtab.ThumbCall || r.Type == symtab.ThumbJump24 {
+			// Thumb branches are relative to the address of the instruction
+			// plus 4.
+			off += 4
+		}
+		if off != 0 {
+			return fmt.Errorf("coff.section.patch: unsupported offset %d from target of %v relocation of %q section", off, r.Type, s.sect.Name)
+		}
+	}
+	return nil
+}
+
+// put stores the value of a field of machine code in b, using the given byte
+// order.
+func put(order binary.ByteOrder, b []byte, val uint64) {
+	switch len(b) {
+	case 1:
+		b[0] = uint8(val)
+	case 2:
+		order.PutUint16(b, uint16(val))
+	case 4:
+		order.PutUint32(b, uint32(val))
+	case 8:
+		order.PutUint64(b, val)
+	}
+}
+
+// A strtab is the string table of a COFF object, which holds the
+// null-terminated names longer than 8 bytes referred to by their offset. The
+// offsets include the 4-byte size of the table which precedes the strings.
+type strtab struct {
+	bytes.Buffer
+	// Offsets of the strings of the table.
+	offs map[string]uint32
+}
+
+// newStrtab returns a new empty string table.
+func newStrtab() *strtab {
+	return &strtab{offs: make(map[string]uint32)}
+}
+
+// add adds the given string to the table and returns its offset.
+func (t *strtab) add(s string) uint32 {
+	if off, ok := t.offs[s]; ok {
+		return off
+	}
+	off := uint32(t.Len() + 4)
+	t.WriteString(s)
+	t.WriteByte(0)
+	t.offs[s] = off
+	return off
+}
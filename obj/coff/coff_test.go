@@ -0,0 +1,285 @@
+package coff
+
+import (
+	"bytes"
+	"debug/pe"
+	"reflect"
+	"testing"
+
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/asm/arm"
+	"github.com/mewlang/asm/asm/arm64"
+	"github.com/mewlang/asm/asm/mos6502"
+	"github.com/mewlang/asm/asm/x86"
+	"github.com/mewlang/asm/internal/asmtest"
+)
+
+func TestWrite(t *testing.T) {
+	golden := []struct {
+		enc asm.Encoder
+		src string
+		// Expected machine.
+		mach uint16
+		// Expected section names and characteristics.
+		sects []string
+		flags []uint32
+		// Expected symbols.
+		syms []pe.Symbol
+		// Expected relocation entries of the first section.
+		relocs []pe.Reloc
+		// Expected contents of each section.
+		data [][]byte
+	}{
+		// i=0
+		{
+			enc: x86.Encoder,
+			src: `
+	extern printf
+	global main
+	section .text
+main:
+	push msg
+	call printf
+	add esp, 4
+	ret
+	section .rodata.strings
+msg:
+	db "hi", 10, 0
+	section .bss
+buf:
+	dd 0
+`,
+			mach:  pe.IMAGE_FILE_MACHINE_I386,
+			sects: []string{".text", ".rodata.strings", ".bss"},
+			flags: []uint32{textFlags | align16, rodataFlags | align16, bssFlags | align16},
+			syms: []pe.Symbol{
+				{Name: ".text", SectionNumber: 1, StorageClass: classStatic},
+				{Name: ".rodata.strings", SectionNumber: 2, StorageClass: classStatic},
+				{Name: ".bss", SectionNumber: 3, StorageClass: classStatic},
+				{Name: "printf", SectionNumber: undefSection, StorageClass: classExternal},
+				{Name: "main", SectionNumber: 1, StorageClass: classExternal},
+				{Name: "msg", SectionNumber: 2, StorageClass: classStatic},
+				{Name: "buf", SectionNumber: 3, StorageClass: classStatic},
+			},
+			relocs: []pe.Reloc{
+				// IMAGE_REL_I386_DIR32 .rodata.strings
+				{VirtualAddress: 1, SymbolTableIndex: 2, Type: 0x0006},
+				// IMAGE_REL_I386_REL32 printf
+				{VirtualAddress: 6, SymbolTableIndex: 6, Type: 0x0014},
+			},
+			data: [][]byte{
+				{
+					0x68, 0x00, 0x00, 0x00, 0x00, // push msg
+					0xE8, 0x00, 0x00, 0x00, 0x00, // call printf
+					0x83, 0xC4, 0x04, // add esp, 4
+					0xC3, // ret
+				},
+				{'h', 'i', 10, 0},
+				nil,
+			},
+		},
+		// i=1
+		{
+			enc: x86.Encoder64,
+			src: `
+	global main
+	section .text
+main:
+	mov rax, [rel ptr]
+	ret
+	section .data
+n equ 42
+	dq 0
+ptr:
+	dq main + 1
+`,
+			mach:  pe.IMAGE_FILE_MACHINE_AMD64,
+			sects: []string{".text", ".data"},
+			flags: []uint32{textFlags | align16, dataFlags | align16},
+			syms: []pe.Symbol{
+				{Name: ".text", SectionNumber: 1, StorageClass: classStatic},
+				{Name: ".data", SectionNumber: 2, StorageClass: classStatic},
+				{Name: "main", SectionNumber: 1, StorageClass: classExternal},
+				{Name: "n", Value: 42, SectionNumber: absSection, StorageClass: classStatic},
+				{Name: "ptr", Value: 8, SectionNumber: 2, StorageClass: classStatic},
+			},
+			relocs: []pe.Reloc{
+				// IMAGE_REL_AMD64_REL32 .data
+				{VirtualAddress: 3, SymbolTableIndex: 2, Type: 0x0004},
+			},
+			data: [][]byte{
+				{
+					0x48, 0x8B, 0x05, 0x08, 0x00, 0x00, 0x00, // mov rax, [rel ptr]
+					0xC3, // ret
+				},
+				{
+					0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // dq 0
+					0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // dq main + 1
+				},
+			},
+		},
+		// i=2
+		{
+			enc: arm64.Encoder,
+			src: `
+	extern puts
+	section .text
+	adrp x0, msg
+	add x0, x0, #msg
+	b puts
+	section .data
+	dd 0
+msg:
+	db "hi", 0
+`,
+			mach:  pe.IMAGE_FILE_MACHINE_ARM64,
+			sects: []string{".text", ".data"},
+			flags: []uint32{textFlags | align16, dataFlags | align16},
+			syms: []pe.Symbol{
+				{Name: ".text", SectionNumber: 1, StorageClass: classStatic},
+				{Name: ".data", SectionNumber: 2, StorageClass: classStatic},
+				{Name: "puts", SectionNumber: undefSection, StorageClass: classExternal},
+				{Name: "msg", Value: 4, SectionNumber: 2, StorageClass: classStatic},
+			},
+			relocs: []pe.Reloc{
+				// IMAGE_REL_ARM64_PAGEBASE_REL21 .data
+				{VirtualAddress: 0, SymbolTableIndex: 2, Type: 0x0004},
+				// IMAGE_REL_ARM64_PAGEOFFSET_12A .data
+				{VirtualAddress: 4, SymbolTableIndex: 2, Type: 0x0006},
+				// IMAGE_REL_ARM64_BRANCH26 puts
+				{VirtualAddress: 8, SymbolTableIndex: 4, Type: 0x0003},
+			},
+			data: [][]byte{
+				{
+					0x20, 0x00, 0x00, 0x90, // adrp x0, msg
+					0x00, 0x10, 0x00, 0x91, // add x0, x0, #msg
+					0x00, 0x00, 0x00, 0x14, // b puts
+				},
+				{
+					0x00, 0x00, 0x00, 0x00, // dd 0
+					'h', 'i', 0x00, // msg
+				},
+			},
+		},
+		// i=3
+		{
+			enc: &arm.Encoder{},
+			src: `
+	extern f
+	section .text
+	bl f
+	b f
+`,
+			mach:  pe.IMAGE_FILE_MACHINE_ARMNT,
+			sects: []string{".text"},
+			flags: []uint32{textFlags | align16},
+			syms: []pe.Symbol{
+				{Name: ".text", SectionNumber: 1, StorageClass: classStatic},
+				{Name: "f", SectionNumber: undefSection, StorageClass: classExternal},
+			},
+			relocs: []pe.Reloc{
+				// IMAGE_REL_ARM_BLX23T f
+				{VirtualAddress: 0, SymbolTableIndex: 2, Type: 0x0015},
+				// IMAGE_REL_ARM_BRANCH24T f
+				{VirtualAddress: 4, SymbolTableIndex: 2, Type: 0x0014},
+			},
+			data: [][]byte{
+				{
+					0x00, 0xF0, 0x00, 0xF8, // bl f
+					0x00, 0xF0, 0x00, 0xB8, // b.w f
+				},
+			},
+		},
+	}
+
+	for i, g := range golden {
+		obj, err := asmtest.Assemble(&asm.Config{Relocatable: true}, g.enc, g.src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		buf := new(bytes.Buffer)
+		if err := Write(buf, obj); err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		f, err := pe.NewFile(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Errorf("i=%d: unable to parse COFF file; %v", i, err)
+			continue
+		}
+		if f.Machine != g.mach {
+			t.Errorf("i=%d: machine mismatch; expected 0x%X, got 0x%X", i, g.mach, f.Machine)
+		}
+		var sects []string
+		var flags []uint32
+		for _, sect := range f.Sections {
+			sects = append(sects, sect.Name)
+			flags = append(flags, sect.Characteristics)
+		}
+		if !reflect.DeepEqual(sects, g.sects) || !reflect.DeepEqual(flags, g.flags) {
+			t.Errorf("i=%d: sections mismatch; expected %q with %#x, got %q with %#x", i, g.sects, g.flags, sects, flags)
+		}
+		var syms []pe.Symbol
+		for _, sym := range f.Symbols {
+			syms = append(syms, *sym)
+		}
+		if !reflect.DeepEqual(syms, g.syms) {
+			t.Errorf("i=%d: symbols mismatch; expected %+v, got %+v", i, g.syms, syms)
+		}
+		if relocs := f.Sections[0].Relocs; !reflect.DeepEqual(relocs, g.relocs) {
+			t.Errorf("i=%d: relocations mismatch; expected %+v, got %+v", i, g.relocs, relocs)
+		}
+		for j, sect := range f.Sections {
+			if sect.Characteristics&pe.IMAGE_SCN_CNT_UNINITIALIZED_DATA != 0 {
+				continue
+			}
+			data, err := sect.Data()
+			if err != nil {
+				t.Errorf("i=%d: unable to read %s; %v", i, sect.Name, err)
+				continue
+			}
+			if !bytes.Equal(data, g.data[j]) {
+				t.Errorf("i=%d: %s mismatch; expected %x, got %x", i, sect.Name, g.data[j], data)
+			}
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	golden := []struct {
+		enc         asm.Encoder
+		src         string
+		relocatable bool
+		want        string
+	}{
+		// i=0
+		{enc: x86.Encoder, src: "\tret", relocatable: false, want: "coff.Write: program not assembled as a relocatable object"},
+		// i=1
+		{enc: mos6502.Encoder, src: "\trts", relocatable: true, want: `coff.Write: unsupported architecture "6502"`},
+		// i=2
+		{enc: x86.Encoder, src: "\tglobal main\n\tret", relocatable: true, want: `coff.symbols: undefined global symbol "main"`},
+		// i=3
+		{enc: x86.Encoder, src: "\tsection .bss\n\tdb 1", relocatable: true, want: `coff.sections: non-zero data in ".bss" section`},
+		// i=4
+		{enc: &arm.Encoder{}, src: "\textern f\n\tbl f+8", relocatable: true, want: `coff.section.patch: unsupported offset 8 from target of Thumb call relocation of ".text" section`},
+		// i=5
+		{enc: arm64.Encoder, src: "\textern f\n\tadrp x0, f+0x100000", relocatable: true, want: `coff.section.patch: addend 1048576 of ARM64 page21 relocation of ".text" section out of range`},
+	}
+
+	for i, g := range golden {
+		obj, err := asmtest.Assemble(&asm.Config{Relocatable: g.relocatable}, g.enc, g.src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		err = Write(new(bytes.Buffer), obj)
+		if err == nil {
+			t.Errorf("i=%d: expected error %q, got nil", i, g.want)
+			continue
+		}
+		if got := err.Error(); got != g.want {
+			t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
@@ -0,0 +1,498 @@
+// Package elf implements a writer of ELF object files; the file format of
+// relocatable objects and executables of Linux and other Unix-like systems.
+//
+// Relocatable objects hold the sections of a program assembled using
+// asm.Config with Relocatable set, a symbol table and the relocation records of
+// each section. The type and flags of sections are given by the kind of their
+// names, as specified by obj.SectionKind. Labels exported by a global directive
+// and external symbols are global symbols of the symbol table, while all other
+// labels and constants are local symbols. The relocation records of the lower
+// 12 bits of RISC-V PC-relative offsets refer to .Lpcrel_hiN local labels of
+// their auipc instructions, like GNU as. The ELF header of RISC-V objects
+// assembled using a compressing encoder has the EF_RISCV_RVC flag set.
+//
+// Executables hold the sections of a program assembled using the configuration
+// returned by ExecConfig, which places each section on pages of its own. Each
+// section is mapped into memory by a loadable segment, with access permissions
+// given by the flags of the section. The entry point is a label of the program;
+// _start by default.
+package elf
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/internal/bin"
+	"github.com/mewlang/asm/obj"
+	"github.com/mewlang/asm/symtab"
+)
+
+// A machine describes the ELF representation of an architecture.
+type machine struct {
+	// ELF machine.
+	mach elf.Machine
+	// Conventional base address of executables.
+	base int64
+	// Processor specific flags of the ELF header.
+	flags uint32
+	// Processor specific flags of the ELF header of files holding compressed
+	// instructions.
+	compressed uint32
+	// rela specifies that relocation sections hold explicit addends.
+	rela bool
+	// Relocation types of fields, indexed by field size and PC-relativity.
+	relocs map[relocKind]uint32
+	// Relocation types of the bit fields of instructions.
+	insts map[symtab.RelocType]uint32
+}
+
+// A relocKind specifies the kind of field of a relocation record.
+type relocKind struct {
+	// Size in bytes of the field.
+	size int
+	// PC-relative field.
+	pcrel bool
+}
+
+// machines maps from architecture name to ELF machine.
+var machines = map[string]*machine{
+	"x86": {
+		mach: elf.EM_386,
+		base: 0x08048000,
+		relocs: map[relocKind]uint32{
+			{1, false}: uint32(elf.R_386_8),
+			{1, true}:  uint32(elf.R_386_PC8),
+			{2, false}: uint32(elf.R_386_16),
+			{2, true}:  uint32(elf.R_386_PC16),
+			{4, false}: uint32(elf.R_386_32),
+			{4, true}:  uint32(elf.R_386_PC32),
+		},
+	},
+	"x86-64": {
+		mach: elf.EM_X86_64,
+		base: 0x400000,
+		rela: true,
+		relocs: map[relocKind]uint32{
+			{1, false}: uint32(elf.R_X86_64_8),
+			{1, true}:  uint32(elf.R_X86_64_PC8),
+			{2, false}: uint32(elf.R_X86_64_16),
+			{2, true}:  uint32(elf.R_X86_64_PC16),
+			{4, false}: uint32(elf.R_X86_64_32),
+			{4, true}:  uint32(elf.R_X86_64_PC32),
+			{8, false}: uint32(elf.R_X86_64_64),
+			{8, true}:  uint32(elf.R_X86_64_PC64),
+		},
+	},
+	"thumb": {
+		mach: elf.EM_ARM,
+		base: 0x10000,
+		// Version 5 of the ARM EABI.
+		flags: 0x05000000,
+		relocs: map[relocKind]uint32{
+			{1, false}: uint32(elf.R_ARM_ABS8),
+			{2, false}: uint32(elf.R_ARM_ABS16),
+			{4, false}: uint32(elf.R_ARM_ABS32),
+			{4, true}:  uint32(elf.R_ARM_REL32),
+		},
+		insts: map[symtab.RelocType]uint32{
+			// R_ARM_THM_CALL.
+			symtab.ThumbCall:   uint32(elf.R_ARM_THM_PC22),
+			symtab.ThumbJump24: uint32(elf.R_ARM_THM_JUMP24),
+		},
+	},
+	"arm64": {
+		mach: elf.EM_AARCH64,
+		base: 0x400000,
+		rela: true,
+		relocs: map[relocKind]uint32{
+			{2, false}: uint32(elf.R_AARCH64_ABS16),
+			{2, true}:  uint32(elf.R_AARCH64_PREL16),
+			{4, false}: uint32(elf.R_AARCH64_ABS32),
+			{4, true}:  uint32(elf.R_AARCH64_PREL32),
+			{8, false}: uint32(elf.R_AARCH64_ABS64),
+			{8, true}:  uint32(elf.R_AARCH64_PREL64),
+		},
+		insts: map[symtab.RelocType]uint32{
+			// R_AARCH64_JUMP26 for b; see relSection.
+			symtab.ARM64Branch26:  uint32(elf.R_AARCH64_CALL26),
+			symtab.ARM64Page21:    uint32(elf.R_AARCH64_ADR_PREL_PG_HI21),
+			symtab.ARM64PageOff12: uint32(elf.R_AARCH64_ADD_ABS_LO12_NC),
+		},
+	},
+	"mips": {
+		mach: elf.EM_MIPS,
+		base: 0x400000,
+		// MIPS32 instruction set and O32 ABI.
+		flags: 0x50001000,
+		relocs: map[relocKind]uint32{
+			{2, false}: uint32(elf.R_MIPS_16),
+			{4, false}: uint32(elf.R_MIPS_32),
+			{4, true}:  uint32(elf.R_MIPS_PC32),
+		},
+		insts: map[symtab.RelocType]uint32{
+			symtab.MIPS26:   uint32(elf.R_MIPS_26),
+			symtab.MIPSHi16: uint32(elf.R_MIPS_HI16),
+			symtab.MIPSLo16: uint32(elf.R_MIPS_LO16),
+		},
+	},
+	"riscv32": riscv,
+	"riscv64": riscv,
+}
+
+// riscv is the ELF machine of RV32I and RV64I.
+var riscv = &machine{
+	mach: elf.EM_RISCV,
+	base: 0x10000,
+	rela: true,
+	// EF_RISCV_RVC.
+	compressed: 0x1,
+	relocs: map[relocKind]uint32{
+		{4, false}: uint32(elf.R_RISCV_32),
+		{4, true}:  uint32(elf.R_RISCV_32_PCREL),
+		{8, false}: uint32(elf.R_RISCV_64),
+	},
+	insts: map[symtab.RelocType]uint32{
+		symtab.RISCVCall:       uint32(elf.R_RISCV_CALL),
+		symtab.RISCVPCRelHi20:  uint32(elf.R_RISCV_PCREL_HI20),
+		symtab.RISCVPCRelLo12I: uint32(elf.R_RISCV_PCREL_LO12_I),
+	},
+}
+
+// sectionAlign is the alignment in bytes of the sections of relocatable
+// objects.
+const sectionAlign = 16
+
+// PageSize is the size in bytes of memory pages, to which the segments of
+// executables are aligned.
+const PageSize = 0x1000
+
+// A file is an ELF file being written.
+type file struct {
+	// Class of the file; either 32-bit or 64-bit.
+	class elf.Class
+	// Byte order of the file.
+	order binary.ByteOrder
+	// Type of the file.
+	typ elf.Type
+	// Machine of the file.
+	mach *machine
+	// Processor specific flags of the ELF header.
+	flags uint32
+	// Entry point address; or 0 if not present.
+	entry int64
+	// Sections of the file, excluding the null section at index 0.
+	sects []*section
+	// Segments of executables.
+	segs []*segment
+}
+
+// A section is a section of an ELF file.
+type section struct {
+	// Name of the section.
+	name string
+	// Type of the section.
+	typ elf.SectionType
+	// Flags of the section.
+	flags elf.SectionFlag
+	// Address of the section in memory.
+	addr int64
+	// Contents of the section.
+	data []byte
+	// Size in bytes of sections which occupy no space in the file; such as
+	// .bss.
+	size int64
+	// Section header index of an associated section, and extra information;
+	// as specified by the type of the section.
+	link, info uint32
+	// Alignment in bytes of the section.
+	align int64
+	// Size in bytes of each entry of sections holding a table.
+	entsize int64
+	// Offset in bytes of the contents of the section in the file.
+	off int64
+}
+
+// A segment is a segment of an executable, which specifies the mapping of a
+// section into memory.
+type segment struct {
+	// Type of the segment.
+	typ elf.ProgType
+	// Access permissions of the segment.
+	flags elf.ProgFlag
+	// Section mapped by the segment; or nil if not present.
+	sect *section
+}
+
+// newFile returns a new ELF file of the given type holding the machine code of
+// obj.
+func newFile(typ elf.Type, obj *asm.Object) (*file, error) {
+	mach, ok := machines[obj.Arch.Name()]
+	if !ok {
+		return nil, fmt.Errorf("elf.newFile: unsupported architecture %q", obj.Arch.Name())
+	}
+	f := &file{
+		class: elf.ELFCLASS32,
+		order: obj.Arch.ByteOrder(),
+		typ:   typ,
+		mach:  mach,
+		flags: mach.flags,
+	}
+	if obj.Arch.WordSize() == 8 {
+		f.class = elf.ELFCLASS64
+	}
+	if obj.Compressed {
+		f.flags |= mach.compressed
+	}
+	return f, nil
+}
+
+// progSection returns a section of the file holding the contents of sect. The
+// type and flags of the section are given by the kind of its name.
+func progSection(sect *asm.Section) (*section, error) {
+	s := &section{
+		name:  sect.Name,
+		typ:   elf.SHT_PROGBITS,
+		flags: elf.SHF_ALLOC | elf.SHF_WRITE,
+		addr:  sect.Addr,
+		data:  sect.Data,
+		align: sectionAlign,
+	}
+	switch obj.SectionKind(sect.Name) {
+	case obj.Text:
+		s.flags = elf.SHF_ALLOC | elf.SHF_EXECINSTR
+	case obj.ROData:
+		s.flags = elf.SHF_ALLOC
+	case obj.BSS:
+		for _, b := range sect.Data {
+			if b != 0 {
+				return nil, fmt.Errorf("elf.progSection: non-zero data in %q section", sect.Name)
+			}
+		}
+		if len(sect.Relocs) > 0 {
+			return nil, fmt.Errorf("elf.progSection: relocations in %q section", sect.Name)
+		}
+		s.typ = elf.SHT_NOBITS
+		s.data, s.size = nil, int64(len(sect.Data))
+	}
+	return s, nil
+}
+
+// wordSize returns the size in bytes of addresses of the file.
+func (f *file) wordSize() int64 {
+	if f.class == elf.ELFCLASS64 {
+		return 8
+	}
+	return 4
+}
+
+// index returns the section header index of the named section; or 0 if not
+// present.
+func (f *file) index(name string) int {
+	for i, s := range f.sects {
+		if s.name == name {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// writeTo writes the ELF file to w. The program header table and the contents
+// of the sections follow the ELF header, and the section header table is placed
+// at the end of the file. The sections of executables which occupy memory are
+// placed at file offsets congruent to their addresses modulo the page size.
+func (f *file) writeTo(w io.Writer) error {
+	shstrtab := newStrtab()
+	names := make([]uint32, len(f.sects))
+	for i, s := range f.sects {
+		names[i] = shstrtab.add(s.name)
+	}
+	shstrndx := len(f.sects) + 1
+	shstrName := shstrtab.add(".shstrtab")
+	sects := append(f.sects, &section{name: ".shstrtab", typ: elf.SHT_STRTAB, data: shstrtab.Bytes(), align: 1})
+	names = append(names, shstrName)
+
+	// Lay out the contents of the sections.
+	ehsize, phentsize, shentsize := int64(52), int64(32), int64(40)
+	if f.class == elf.ELFCLASS64 {
+		ehsize, phentsize, shentsize = 64, 56, 64
+	}
+	var phoff int64
+	off := ehsize
+	if len(f.segs) > 0 {
+		phoff = off
+		off += int64(len(f.segs)) * phentsize
+	}
+	for _, s := range sects {
+		if f.typ == elf.ET_EXEC && s.flags&elf.SHF_ALLOC != 0 {
+			off += (s.addr - off) & (PageSize - 1)
+		} else if s.align > 1 {
+			off = symtab.AlignUp(off, s.align)
+		}
+		s.off = off
+		if s.typ == elf.SHT_NOBITS {
+			continue
+		}
+		off += int64(len(s.data))
+	}
+	shoff := symtab.AlignUp(off, f.wordSize())
+
+	// Write the ELF header.
+	buf := new(bytes.Buffer)
+	var ident [elf.EI_NIDENT]byte
+	copy(ident[:], elf.ELFMAG)
+	ident[elf.EI_CLASS] = byte(f.class)
+	ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	if f.order == binary.BigEndian {
+		ident[elf.EI_DATA] = byte(elf.ELFDATA2MSB)
+	}
+	ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	shnum := uint16(len(sects) + 1)
+	if f.class == elf.ELFCLASS64 {
+		bin.Write(buf, f.order, &elf.Header64{
+			Ident:     ident,
+			Type:      uint16(f.typ),
+			Machine:   uint16(f.mach.mach),
+			Version:   uint32(elf.EV_CURRENT),
+			Entry:     uint64(f.entry),
+			Phoff:     uint64(phoff),
+			Shoff:     uint64(shoff),
+			Flags:     f.flags,
+			Ehsize:    uint16(ehsize),
+			Phentsize: uint16(phentsize),
+			Phnum:     uint16(len(f.segs)),
+			Shentsize: uint16(shentsize),
+			Shnum:     shnum,
+			Shstrndx:  uint16(shstrndx),
+		})
+	} else {
+		bin.Write(buf, f.order, &elf.Header32{
+			Ident:     ident,
+			Type:      uint16(f.typ),
+			Machine:   uint16(f.mach.mach),
+			Version:   uint32(elf.EV_CURRENT),
+			Entry:     uint32(f.entry),
+			Phoff:     uint32(phoff),
+			Shoff:     uint32(shoff),
+			Flags:     f.flags,
+			Ehsize:    uint16(ehsize),
+			Phentsize: uint16(phentsize),
+			Phnum:     uint16(len(f.segs)),
+			Shentsize: uint16(shentsize),
+			Shnum:     shnum,
+			Shstrndx:  uint16(shstrndx),
+		})
+	}
+
+	// Write the program header table.
+	for _, seg := range f.segs {
+		var off, addr, filesz, memsz, align int64
+		if s := seg.sect; s != nil {
+			off, addr, memsz, align = s.off, s.addr, s.size, PageSize
+			if s.typ != elf.SHT_NOBITS {
+				filesz, memsz = int64(len(s.data)), int64(len(s.data))
+			}
+		}
+		if f.class == elf.ELFCLASS64 {
+			bin.Write(buf, f.order, &elf.Prog64{
+				Type:   uint32(seg.typ),
+				Flags:  uint32(seg.flags),
+				Off:    uint64(off),
+				Vaddr:  uint64(addr),
+				Paddr:  uint64(addr),
+				Filesz: uint64(filesz),
+				Memsz:  uint64(memsz),
+				Align:  uint64(align),
+			})
+		} else {
+			bin.Write(buf, f.order, &elf.Prog32{
+				Type:   uint32(seg.typ),
+				Off:    uint32(off),
+				Vaddr:  uint32(addr),
+				Paddr:  uint32(addr),
+				Filesz: uint32(filesz),
+				Memsz:  uint32(memsz),
+				Flags:  uint32(seg.flags),
+				Align:  uint32(align),
+			})
+		}
+	}
+
+	// Write the contents of the sections.
+	for _, s := range sects {
+		if s.typ == elf.SHT_NOBITS {
+			continue
+		}
+		buf.Write(make([]byte, s.off-int64(buf.Len())))
+		buf.Write(s.data)
+	}
+
+	// Write the section header table, starting with the null section.
+	buf.Write(make([]byte, shoff-int64(buf.Len())))
+	buf.Write(make([]byte, shentsize))
+	for i, s := range sects {
+		size := s.size
+		if s.typ != elf.SHT_NOBITS {
+			size = int64(len(s.data))
+		}
+		if f.class == elf.ELFCLASS64 {
+			bin.Write(buf, f.order, &elf.Section64{
+				Name:      names[i],
+				Type:      uint32(s.typ),
+				Flags:     uint64(s.flags),
+				Addr:      uint64(s.addr),
+				Off:       uint64(s.off),
+				Size:      uint64(size),
+				Link:      s.link,
+				Info:      s.info,
+				Addralign: uint64(s.align),
+				Entsize:   uint64(s.entsize),
+			})
+		} else {
+			bin.Write(buf, f.order, &elf.Section32{
+				Name:      names[i],
+				Type:      uint32(s.typ),
+				Flags:     uint32(s.flags),
+				Addr:      uint32(s.addr),
+				Off:       uint32(s.off),
+				Size:      uint32(size),
+				Link:      s.link,
+				Info:      s.info,
+				Addralign: uint32(s.align),
+				Entsize:   uint32(s.entsize),
+			})
+		}
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// A strtab is a string table section, which holds null-terminated strings
+// referred to by their offset.
+type strtab struct {
+	bytes.Buffer
+	// Offsets of the strings of the table.
+	offs map[string]uint32
+}
+
+// newStrtab returns a new string table, holding the empty string at offset 0.
+func newStrtab() *strtab {
+	t := &strtab{offs: map[string]uint32{"": 0}}
+	t.WriteByte(0)
+	return t
+}
+
+// add adds the given string to the table and returns its offset.
+func (t *strtab) add(s string) uint32 {
+	if off, ok := t.offs[s]; ok {
+		return off
+	}
+	off := uint32(t.Len())
+	t.WriteString(s)
+	t.WriteByte(0)
+	t.offs[s] = off
+	return off
+}
@@ -0,0 +1,589 @@
+package elf
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/asm/arm"
+	"github.com/mewlang/asm/asm/arm64"
+	"github.com/mewlang/asm/asm/mips"
+	"github.com/mewlang/asm/asm/mos6502"
+	riscvasm "github.com/mewlang/asm/asm/riscv"
+	"github.com/mewlang/asm/asm/x86"
+	"github.com/mewlang/asm/internal/asmtest"
+	"github.com/mewlang/asm/parser"
+	"github.com/mewlang/asm/token"
+)
+
+// A rel is a relocation entry, with the name of its symbol.
+type rel struct {
+	off    uint64
+	typ    uint32
+	sym    string
+	addend int64
+}
+
+// rels returns the relocation entries of the named relocation section of f.
+func rels(f *elf.File, name string) ([]rel, error) {
+	syms, err := f.Symbols()
+	if err != nil {
+		return nil, err
+	}
+	sect := f.Section(name)
+	data, err := sect.Data()
+	if err != nil {
+		return nil, err
+	}
+	var rs []rel
+	r := bytes.NewReader(data)
+	// symName returns the name of the given symbol; or the name of its
+	// section for section symbols.
+	symName := func(i uint32) string {
+		sym := syms[i-1]
+		if elf.ST_TYPE(sym.Info) == elf.STT_SECTION {
+			return f.Sections[sym.Section].Name
+		}
+		return sym.Name
+	}
+	for r.Len() > 0 {
+		switch {
+		case f.Class == elf.ELFCLASS64:
+			var e elf.Rela64
+			if err := binary.Read(r, f.ByteOrder, &e); err != nil {
+				return nil, err
+			}
+			rs = append(rs, rel{off: e.Off, typ: elf.R_TYPE64(e.Info), sym: symName(elf.R_SYM64(e.Info)), addend: e.Addend})
+		default:
+			var e elf.Rel32
+			if err := binary.Read(r, f.ByteOrder, &e); err != nil {
+				return nil, err
+			}
+			rs = append(rs, rel{off: uint64(e.Off), typ: elf.R_TYPE32(e.Info), sym: symName(elf.R_SYM32(e.Info))})
+		}
+	}
+	return rs, nil
+}
+
+func TestWriteRel(t *testing.T) {
+	golden := []struct {
+		enc asm.Encoder
+		src string
+		// Expected section names.
+		sects []string
+		// Expected symbols, mapping from name to binding and section name.
+		syms map[string]string
+		// Expected relocation entries of the relocation section of .text.
+		rels []rel
+		// Expected contents of .text.
+		text []byte
+	}{
+		// i=0
+		{
+			enc: x86.Encoder,
+			src: `
+	extern printf
+	global main
+	section .text
+main:
+	push msg
+	call printf
+	add esp, 4
+.done:
+	ret
+	section .rodata
+msg:
+	db "hi", 10, 0
+	section .bss
+buf:
+	dd 0, 0
+`,
+			sects: []string{"", ".text", ".rodata", ".bss", ".note.GNU-stack", ".rel.text", ".symtab", ".strtab", ".shstrtab"},
+			syms: map[string]string{
+				"main":      "STB_GLOBAL .text",
+				"main.done": "STB_LOCAL .text",
+				"msg":       "STB_LOCAL .rodata",
+				"buf":       "STB_LOCAL .bss",
+				"printf":    "STB_GLOBAL ",
+			},
+			rels: []rel{
+				{off: 1, typ: uint32(elf.R_386_32), sym: ".rodata"},
+				{off: 6, typ: uint32(elf.R_386_PC32), sym: "printf"},
+			},
+			text: []byte{
+				0x68, 0x00, 0x00, 0x00, 0x00, // push msg
+				0xE8, 0xFC, 0xFF, 0xFF, 0xFF, // call printf
+				0x83, 0xC4, 0x04, // add esp, 4
+				0xC3, // ret
+			},
+		},
+		// i=1
+		{
+			enc: x86.Encoder64,
+			src: `
+	extern puts
+	global main
+main:
+	lea rdi, [rel msg]
+	jmp puts
+	section .data
+msg:
+	db "hi", 0
+`,
+			sects: []string{"", ".text", ".data", ".note.GNU-stack", ".rela.text", ".symtab", ".strtab", ".shstrtab"},
+			syms: map[string]string{
+				"main": "STB_GLOBAL .text",
+				"msg":  "STB_LOCAL .data",
+				"puts": "STB_GLOBAL ",
+			},
+			rels: []rel{
+				{off: 3, typ: uint32(elf.R_X86_64_PC32), sym: ".data", addend: -4},
+				{off: 8, typ: uint32(elf.R_X86_64_PC32), sym: "puts", addend: -4},
+			},
+			text: []byte{
+				0x48, 0x8D, 0x3D, 0x00, 0x00, 0x00, 0x00, // lea rdi, [rel msg]
+				0xE9, 0x00, 0x00, 0x00, 0x00, // jmp puts
+			},
+		},
+		// i=2
+		{
+			enc: &arm.Encoder{},
+			src: `
+	extern f
+	bl f
+	b f
+	bl g+8
+	bl h
+g:
+	nop
+	section .data
+h:
+	dd 0
+`,
+			sects: []string{"", ".text", ".data", ".note.GNU-stack", ".rel.text", ".symtab", ".strtab", ".shstrtab"},
+			syms: map[string]string{
+				"$t": "STB_LOCAL .text",
+				"g":  "STB_LOCAL .text",
+				"h":  "STB_LOCAL .data",
+				"f":  "STB_GLOBAL ",
+			},
+			rels: []rel{
+				{off: 0, typ: uint32(elf.R_ARM_THM_PC22), sym: "f"},
+				{off: 4, typ: uint32(elf.R_ARM_THM_JUMP24), sym: "f"},
+				{off: 12, typ: uint32(elf.R_ARM_THM_PC22), sym: ".data"},
+			},
+			text: []byte{
+				0xFF, 0xF7, 0xFE, 0xFF, // bl f
+				0xFF, 0xF7, 0xFE, 0xBF, // b.w f
+				0x00, 0xF0, 0x06, 0xF8, // bl g+8
+				0xFF, 0xF7, 0xFE, 0xFF, // bl h
+				0x00, 0xBF, // nop
+			},
+		},
+		// i=3
+		{
+			enc: arm64.Encoder,
+			src: `
+	extern f
+	bl f
+	b f+8
+	adrp x0, msg
+	add x0, x0, #msg
+	section .data
+	dd 0
+msg:
+	dd 0
+`,
+			sects: []string{"", ".text", ".data", ".note.GNU-stack", ".rela.text", ".symtab", ".strtab", ".shstrtab"},
+			syms: map[string]string{
+				"msg": "STB_LOCAL .data",
+				"f":   "STB_GLOBAL ",
+			},
+			rels: []rel{
+				{off: 0, typ: uint32(elf.R_AARCH64_CALL26), sym: "f"},
+				{off: 4, typ: uint32(elf.R_AARCH64_JUMP26), sym: "f", addend: 8},
+				{off: 8, typ: uint32(elf.R_AARCH64_ADR_PREL_PG_HI21), sym: ".data", addend: 4},
+				{off: 12, typ: uint32(elf.R_AARCH64_ADD_ABS_LO12_NC), sym: ".data", addend: 4},
+			},
+			text: []byte{
+				0x00, 0x00, 0x00, 0x94, // bl f
+				0x00, 0x00, 0x00, 0x14, // b f+8
+				0x00, 0x00, 0x00, 0x90, // adrp x0, msg
+				0x00, 0x00, 0x00, 0x91, // add x0, x0, #msg
+			},
+		},
+		// i=4
+		{
+			enc: mips.Encoder,
+			src: `
+	extern f
+	jal f
+	nop
+	j g+8
+	nop
+	la $a0, f+0x12345
+g:
+	nop
+`,
+			sects: []string{"", ".text", ".note.GNU-stack", ".rel.text", ".symtab", ".strtab", ".shstrtab"},
+			syms: map[string]string{
+				"g": "STB_LOCAL .text",
+				"f": "STB_GLOBAL ",
+			},
+			rels: []rel{
+				{off: 0, typ: uint32(elf.R_MIPS_26), sym: "f"},
+				{off: 8, typ: uint32(elf.R_MIPS_26), sym: ".text"},
+				{off: 16, typ: uint32(elf.R_MIPS_HI16), sym: "f"},
+				{off: 20, typ: uint32(elf.R_MIPS_LO16), sym: "f"},
+			},
+			text: []byte{
+				0x0C, 0x00, 0x00, 0x00, // jal f
+				0x00, 0x00, 0x00, 0x00, // nop
+				0x08, 0x00, 0x00, 0x08, // j g+8
+				0x00, 0x00, 0x00, 0x00, // nop
+				0x3C, 0x04, 0x00, 0x01, // lui $a0, %hi(f+0x12345)
+				0x24, 0x84, 0x23, 0x45, // addiu $a0, $a0, %lo(f+0x12345)
+				0x00, 0x00, 0x00, 0x00, // nop
+			},
+		},
+		// i=5
+		{
+			enc: riscvasm.Encoder64,
+			src: `
+	extern f
+	call f
+	la a0, msg
+	section .data
+	dd 0
+msg:
+	dd 0
+`,
+			sects: []string{"", ".text", ".data", ".note.GNU-stack", ".rela.text", ".symtab", ".strtab", ".shstrtab"},
+			syms: map[string]string{
+				".Lpcrel_hi0": "STB_LOCAL .text",
+				"msg":         "STB_LOCAL .data",
+				"f":           "STB_GLOBAL ",
+			},
+			rels: []rel{
+				{off: 0, typ: uint32(elf.R_RISCV_CALL), sym: "f"},
+				{off: 8, typ: uint32(elf.R_RISCV_PCREL_HI20), sym: ".data", addend: 4},
+				{off: 12, typ: uint32(elf.R_RISCV_PCREL_LO12_I), sym: ".Lpcrel_hi0"},
+			},
+			text: []byte{
+				0x97, 0x00, 0x00, 0x00, // auipc ra, %pcrel_hi(f)
+				0xE7, 0x80, 0x00, 0x00, // jalr ra, %pcrel_lo(f)(ra)
+				0x17, 0x05, 0x00, 0x00, // auipc a0, %pcrel_hi(msg)
+				0x13, 0x05, 0x05, 0x00, // addi a0, a0, %pcrel_lo(.Lpcrel_hi0)
+			},
+		},
+	}
+
+	for i, g := range golden {
+		obj, err := asmtest.Assemble(&asm.Config{Relocatable: true}, g.enc, g.src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		buf := new(bytes.Buffer)
+		if err := WriteRel(buf, obj); err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		f, err := elf.NewFile(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Errorf("i=%d: unable to parse ELF file; %v", i, err)
+			continue
+		}
+		if f.Type != elf.ET_REL {
+			t.Errorf("i=%d: file type mismatch; expected %v, got %v", i, elf.ET_REL, f.Type)
+		}
+		var sects []string
+		for _, sect := range f.Sections {
+			sects = append(sects, sect.Name)
+		}
+		if !reflect.DeepEqual(sects, g.sects) {
+			t.Errorf("i=%d: sections mismatch; expected %q, got %q", i, g.sects, sects)
+		}
+		syms, err := f.Symbols()
+		if err != nil {
+			t.Errorf("i=%d: unable to parse symbols; %v", i, err)
+			continue
+		}
+		got := make(map[string]string)
+		for _, sym := range syms {
+			if sym.Name == "" {
+				continue
+			}
+			sect := ""
+			if sym.Section != elf.SHN_UNDEF {
+				sect = f.Sections[sym.Section].Name
+			}
+			got[sym.Name] = elf.ST_BIND(sym.Info).String() + " " + sect
+		}
+		if !reflect.DeepEqual(got, g.syms) {
+			t.Errorf("i=%d: symbols mismatch; expected %v, got %v", i, g.syms, got)
+		}
+		rs, err := rels(f, g.sects[len(g.sects)-4])
+		if err != nil {
+			t.Errorf("i=%d: unable to parse relocations; %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(rs, g.rels) {
+			t.Errorf("i=%d: relocations mismatch; expected %+v, got %+v", i, g.rels, rs)
+		}
+		text, err := f.Section(".text").Data()
+		if err != nil {
+			t.Errorf("i=%d: unable to read .text; %v", i, err)
+			continue
+		}
+		if !bytes.Equal(text, g.text) {
+			t.Errorf("i=%d: .text mismatch; expected %x, got %x", i, g.text, text)
+		}
+	}
+}
+
+func TestWriteRelFlags(t *testing.T) {
+	golden := []struct {
+		enc  asm.Encoder
+		want uint32
+	}{
+		// i=0
+		{enc: riscvasm.Encoder, want: 0},
+		// i=1
+		{enc: riscvasm.Encoder64, want: 0},
+		// i=2
+		{enc: riscvasm.EncoderC, want: 0x1},
+		// i=3
+		{enc: riscvasm.Encoder64C, want: 0x1},
+		// i=4
+		{enc: &arm.Encoder{}, want: 0x05000000},
+	}
+
+	for i, g := range golden {
+		obj, err := asmtest.Assemble(&asm.Config{Relocatable: true}, g.enc, "	nop")
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		buf := new(bytes.Buffer)
+		if err := WriteRel(buf, obj); err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		// Offset of e_flags in the ELF header.
+		off := 0x24
+		if obj.Arch.WordSize() == 8 {
+			off = 0x30
+		}
+		got := binary.LittleEndian.Uint32(buf.Bytes()[off:])
+		if got != g.want {
+			t.Errorf("i=%d: flags mismatch; expected 0x%X, got 0x%X", i, g.want, got)
+		}
+	}
+}
+
+func TestWriteRelError(t *testing.T) {
+	golden := []struct {
+		enc         asm.Encoder
+		src         string
+		relocatable bool
+		want        string
+	}{
+		// i=0
+		{enc: x86.Encoder, src: "\tret", relocatable: false, want: "elf.WriteRel: program not assembled as a relocatable object"},
+		// i=1
+		{enc: mos6502.Encoder, src: "\trts", relocatable: true, want: `elf.newFile: unsupported architecture "6502"`},
+		// i=2
+		{enc: x86.Encoder, src: "\tglobal main\n\tret", relocatable: true, want: `elf.file.symbols: undefined global symbol "main"`},
+		// i=3
+		{enc: x86.Encoder, src: "\tsection .bss\n\tdb 1", relocatable: true, want: `elf.progSection: non-zero data in ".bss" section`},
+	}
+
+	for i, g := range golden {
+		obj, err := asmtest.Assemble(&asm.Config{Relocatable: g.relocatable}, g.enc, g.src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		err = WriteRel(new(bytes.Buffer), obj)
+		if err == nil {
+			t.Errorf("i=%d: expected error %q, got nil", i, g.want)
+			continue
+		}
+		if got := err.Error(); got != g.want {
+			t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
+
+func TestWriteExec(t *testing.T) {
+	golden := []struct {
+		enc  asm.Encoder
+		path string
+		// Expected entry point.
+		entry uint64
+		// Expected address, size and flags of the loadable segments.
+		progs []elf.ProgHeader
+		// GOARCH values of the machines which run the executable.
+		goarchs []string
+	}{
+		// i=0
+		{
+			enc:   x86.Encoder,
+			path:  "../../testdata/hello_x86.asm",
+			entry: 0x08048000,
+			progs: []elf.ProgHeader{
+				{Type: elf.PT_LOAD, Flags: elf.PF_R | elf.PF_X, Vaddr: 0x08048000, Filesz: 34},
+				{Type: elf.PT_LOAD, Flags: elf.PF_R, Vaddr: 0x08049000, Filesz: 13},
+			},
+			goarchs: []string{"386", "amd64"},
+		},
+		// i=1
+		{
+			enc:   x86.Encoder64,
+			path:  "../../testdata/hello_x86_64.asm",
+			entry: 0x400000,
+			progs: []elf.ProgHeader{
+				{Type: elf.PT_LOAD, Flags: elf.PF_R | elf.PF_X, Vaddr: 0x400000, Filesz: 33},
+				{Type: elf.PT_LOAD, Flags: elf.PF_R, Vaddr: 0x401000, Filesz: 13},
+			},
+			goarchs: []string{"amd64"},
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i, g := range golden {
+		src, err := ioutil.ReadFile(g.path)
+		if err != nil {
+			t.Errorf("i=%d: unable to read source file; %v", i, err)
+			continue
+		}
+		fset := token.NewFileSet()
+		prog, err := (&parser.Config{Arch: g.enc.Arch()}).ParseFile(fset, g.path, string(src))
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		conf, err := ExecConfig(g.enc.Arch())
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		obj, err := conf.Assemble(fset, g.enc, prog)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		buf := new(bytes.Buffer)
+		if err := WriteExec(buf, obj, ""); err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		f, err := elf.NewFile(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Errorf("i=%d: unable to parse ELF file; %v", i, err)
+			continue
+		}
+		if f.Type != elf.ET_EXEC {
+			t.Errorf("i=%d: file type mismatch; expected %v, got %v", i, elf.ET_EXEC, f.Type)
+		}
+		if f.Entry != g.entry {
+			t.Errorf("i=%d: entry point mismatch; expected 0x%X, got 0x%X", i, g.entry, f.Entry)
+		}
+		var progs []elf.ProgHeader
+		for _, p := range f.Progs {
+			if p.Type != elf.PT_LOAD {
+				continue
+			}
+			if p.Off%PageSize != p.Vaddr%PageSize {
+				t.Errorf("i=%d: offset 0x%X of segment at 0x%X not congruent to its address", i, p.Off, p.Vaddr)
+			}
+			progs = append(progs, elf.ProgHeader{Type: p.Type, Flags: p.Flags, Vaddr: p.Vaddr, Filesz: p.Filesz})
+		}
+		if !reflect.DeepEqual(progs, g.progs) {
+			t.Errorf("i=%d: segments mismatch; expected %+v, got %+v", i, g.progs, progs)
+		}
+
+		// Run the executable on machines which support it.
+		if runtime.GOOS != "linux" || !contains(g.goarchs, runtime.GOARCH) {
+			continue
+		}
+		path := filepath.Join(dir, "hello")
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0755); err != nil {
+			t.Errorf("i=%d: unable to write executable; %v", i, err)
+			continue
+		}
+		out, err := exec.Command(path).Output()
+		if err != nil {
+			t.Errorf("i=%d: unable to run executable; %v", i, err)
+			continue
+		}
+		if want := "Hello world!\n"; string(out) != want {
+			t.Errorf("i=%d: output mismatch; expected %q, got %q", i, want, out)
+		}
+	}
+}
+
+func TestWriteExecError(t *testing.T) {
+	golden := []struct {
+		src   string
+		entry string
+		want  string
+	}{
+		// i=0
+		{src: "main:\n\tret", entry: "", want: `elf.WriteExec: undefined entry point "_start"`},
+		// i=1
+		{src: "\textern exit\n_start:\n\tcall exit", entry: "", want: `elf.WriteExec: unresolved reference to external symbol "exit" in ".text" section`},
+		// i=2
+		{src: "n equ 1\n\tret", entry: "n", want: `elf.WriteExec: undefined entry point "n"`},
+	}
+
+	for i, g := range golden {
+		fset := token.NewFileSet()
+		prog, err := (&parser.Config{Arch: x86.Encoder.Arch()}).ParseFile(fset, "", g.src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		conf, err := ExecConfig(x86.Encoder.Arch())
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		obj, err := conf.Assemble(fset, x86.Encoder, prog)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		err = WriteExec(new(bytes.Buffer), obj, g.entry)
+		if err == nil {
+			t.Errorf("i=%d: expected error %q, got nil", i, g.want)
+			continue
+		}
+		if got := err.Error(); got != g.want {
+			t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
+
+// contains returns true if ss contains s, and false otherwise.
+func contains(ss []string, s string) bool {
+	for _, t := range ss {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package elf
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/symtab"
+)
+
+// DefaultEntry is the name of the default entry point of executables.
+const DefaultEntry = "_start"
+
+// Exec is the ELF executable output format.
+type Exec struct {
+	// Entry is the label of the entry point; or DefaultEntry if empty.
+	Entry string
+}
+
+// Config returns the assembler configuration of executables of the given
+// architecture.
+func (f *Exec) Config(a arch.Arch) (*asm.Config, error) {
+	return ExecConfig(a)
+}
+
+// Write writes the executable of obj to w.
+func (f *Exec) Write(w io.Writer, obj *asm.Object) error {
+	return WriteExec(w, obj, f.Entry)
+}
+
+// ExecConfig returns the assembler configuration of programs written as
+// executables of the given architecture. Each section is placed on pages of its
+// own, starting at the conventional base address of executables unless the
+// program has an org directive.
+func ExecConfig(a arch.Arch) (*asm.Config, error) {
+	mach, ok := machines[a.Name()]
+	if !ok {
+		return nil, fmt.Errorf("elf.ExecConfig: unsupported architecture %q", a.Name())
+	}
+	return &asm.Config{Origin: mach.base, SectionAlign: PageSize}, nil
+}
+
+// WriteExec writes the executable (ET_EXEC) of obj to w. The entry point is
+// given by the named label; or DefaultEntry if empty. The program of obj must be
+// assembled using the configuration returned by ExecConfig, and may not refer
+// to external symbols.
+func WriteExec(w io.Writer, obj *asm.Object, entry string) error {
+	if obj.Symtab == nil || obj.Symtab.Layout().Relocatable {
+		return fmt.Errorf("elf.WriteExec: program assembled as a relocatable object")
+	}
+	f, err := newFile(elf.ET_EXEC, obj)
+	if err != nil {
+		return err
+	}
+
+	// Locate the entry point.
+	if entry == "" {
+		entry = DefaultEntry
+	}
+	sym := obj.Symtab.Symbol(entry)
+	if sym == nil || sym.Kind != symtab.Label {
+		return fmt.Errorf("elf.WriteExec: undefined entry point %q", entry)
+	}
+	f.entry = sym.Value
+	if f.mach.mach == elf.EM_ARM {
+		// Mark the entry point as Thumb code.
+		f.entry |= 1
+	}
+
+	// Map each section into memory.
+	for _, sect := range obj.Sections {
+		if len(sect.Relocs) > 0 {
+			return fmt.Errorf("elf.WriteExec: unresolved reference to external symbol %q in %q section", sect.Relocs[0].Symbol, sect.Name)
+		}
+		s, err := progSection(sect)
+		if err != nil {
+			return err
+		}
+		s.align = 1
+		f.sects = append(f.sects, s)
+		if len(sect.Data) == 0 {
+			continue
+		}
+		flags := elf.PF_R
+		if s.flags&elf.SHF_WRITE != 0 {
+			flags |= elf.PF_W
+		}
+		if s.flags&elf.SHF_EXECINSTR != 0 {
+			flags |= elf.PF_X
+		}
+		f.segs = append(f.segs, &segment{typ: elf.PT_LOAD, flags: flags, sect: s})
+	}
+	if err := checkPages(f.segs); err != nil {
+		return err
+	}
+	// Mark the stack as non-executable.
+	f.segs = append(f.segs, &segment{typ: elf.PT_GNU_STACK, flags: elf.PF_R | elf.PF_W})
+
+	// Add the symbol table.
+	syms, err := f.symbols(obj)
+	if err != nil {
+		return err
+	}
+	symtabIndex := uint32(len(f.sects) + 1)
+	f.sects = append(f.sects, f.symtabSection(syms, symtabIndex+1), &section{
+		name:  ".strtab",
+		typ:   elf.SHT_STRTAB,
+		data:  syms.strtab.Bytes(),
+		align: 1,
+	})
+	return f.writeTo(w)
+}
+
+// checkPages returns an error if any of the given loadable segments share a
+// page of memory.
+func checkPages(segs []*segment) error {
+	segs = append([]*segment(nil), segs...)
+	sort.Slice(segs, func(i, j int) bool {
+		return segs[i].sect.addr < segs[j].sect.addr
+	})
+	for i := 1; i < len(segs); i++ {
+		prev, s := segs[i-1].sect, segs[i].sect
+		end := prev.addr + int64(len(prev.data)) + prev.size
+		if s.addr&^(PageSize-1) < symtab.AlignUp(end, PageSize) {
+			return fmt.Errorf("elf.checkPages: sections %q and %q share a page of memory", prev.name, s.name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package elf
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/internal/bin"
+	"github.com/mewlang/asm/symtab"
+)
+
+// Rel is the ELF relocatable object output format.
+type Rel struct{}
+
+// Config returns the assembler configuration of relocatable objects.
+func (f *Rel) Config(a arch.Arch) (*asm.Config, error) {
+	if _, ok := machines[a.Name()]; !ok {
+		return nil, fmt.Errorf("elf.Rel.Config: unsupported architecture %q", a.Name())
+	}
+	return &asm.Config{Relocatable: true}, nil
+}
+
+// Write writes the relocatable object of obj to w.
+func (f *Rel) Write(w io.Writer, obj *asm.Object) error {
+	return WriteRel(w, obj)
+}
+
+// WriteRel writes the relocatable object (ET_REL) of obj to w. The program of
+// obj must be assembled as a relocatable object.
+func WriteRel(w io.Writer, obj *asm.Object) error {
+	if obj.Symtab == nil || !obj.Symtab.Layout().Relocatable {
+		return fmt.Errorf("elf.WriteRel: program not assembled as a relocatable object")
+	}
+	f, err := newFile(elf.ET_REL, obj)
+	if err != nil {
+		return err
+	}
+	for _, sect := range obj.Sections {
+		s, err := progSection(sect)
+		if err != nil {
+			return err
+		}
+		// The addresses of sections are assigned at link time.
+		s.addr = 0
+		f.sects = append(f.sects, s)
+	}
+	// Mark the stack as non-executable.
+	f.sects = append(f.sects, &section{name: ".note.GNU-stack", typ: elf.SHT_PROGBITS, align: 1})
+	syms, err := f.symbols(obj)
+	if err != nil {
+		return err
+	}
+
+	// Add the relocation sections, followed by the symbol table.
+	symtabIndex := uint32(len(f.sects) + 1)
+	for _, sect := range obj.Sections {
+		if len(sect.Relocs) > 0 {
+			symtabIndex++
+		}
+	}
+	var rels []*section
+	for i, sect := range obj.Sections {
+		if len(sect.Relocs) == 0 {
+			continue
+		}
+		s, err := f.relSection(f.sects[i], sect, syms)
+		if err != nil {
+			return err
+		}
+		s.link = symtabIndex
+		s.info = uint32(i + 1)
+		rels = append(rels, s)
+	}
+	f.sects = append(f.sects, rels...)
+	f.sects = append(f.sects, f.symtabSection(syms, symtabIndex+1), &section{
+		name:  ".strtab",
+		typ:   elf.SHT_STRTAB,
+		data:  syms.strtab.Bytes(),
+		align: 1,
+	})
+	return f.writeTo(w)
+}
+
+// A symbols is the symbol table of an ELF file.
+type symbols struct {
+	// Symbols of the table, excluding the null symbol at index 0. Local
+	// symbols precede global symbols.
+	syms []symbol
+	// Number of local symbols, including the null symbol.
+	nlocals int
+	// index maps from symbol name to symbol table index. The symbol of each
+	// section has the section header index of the section.
+	index map[string]uint32
+	// String table of the symbol names.
+	strtab *strtab
+	// hi maps from the location of RISC-V auipc instructions, whose offset is
+	// referred to by the relocation records of the lower 12 bits of the
+	// offset, to the symbol table index of their local label.
+	hi map[location]uint32
+}
+
+// A location is the location of an instruction.
+type location struct {
+	// Name of the section of the instruction.
+	sect string
+	// Offset of the instruction from the start of the section.
+	off int64
+}
+
+// A symbol is a symbol of an ELF file.
+type symbol struct {
+	// Offset of the name of the symbol in the string table.
+	name uint32
+	// Value of the symbol.
+	value int64
+	// Binding and type of the symbol.
+	info uint8
+	// Section header index of the section of the symbol.
+	shndx elf.SectionIndex
+}
+
+// symbols returns the symbol table of the symbols of obj. Each section of the
+// file has a section symbol, which precedes the symbols of obj.
+func (f *file) symbols(obj *asm.Object) (*symbols, error) {
+	syms := &symbols{index: make(map[string]uint32), strtab: newStrtab(), hi: make(map[location]uint32)}
+	for i := range obj.Sections {
+		syms.syms = append(syms.syms, symbol{
+			info:  elf.ST_INFO(elf.STB_LOCAL, elf.STT_SECTION),
+			shndx: elf.SectionIndex(i + 1),
+		})
+	}
+	if f.mach.mach == elf.EM_ARM {
+		// Mark the start of Thumb code in executable sections.
+		for i, s := range f.sects {
+			if s.flags&elf.SHF_EXECINSTR != 0 {
+				syms.syms = append(syms.syms, symbol{
+					name:  syms.strtab.add("$t"),
+					info:  elf.ST_INFO(elf.STB_LOCAL, elf.STT_NOTYPE),
+					shndx: elf.SectionIndex(i + 1),
+				})
+			}
+		}
+	}
+	// Label the auipc instructions referred to by the relocation records of
+	// the lower 12 bits of PC-relative offsets, like GNU as.
+	for i, sect := range obj.Sections {
+		for _, r := range sect.Relocs {
+			if r.Type != symtab.RISCVPCRelLo12I {
+				continue
+			}
+			loc := location{sect: sect.Name, off: r.Offset - 4}
+			syms.syms = append(syms.syms, symbol{
+				name:  syms.strtab.add(fmt.Sprintf(".Lpcrel_hi%d", len(syms.hi))),
+				value: loc.off,
+				info:  elf.ST_INFO(elf.STB_LOCAL, elf.STT_NOTYPE),
+				shndx: elf.SectionIndex(i + 1),
+			})
+			syms.hi[loc] = uint32(len(syms.syms))
+		}
+	}
+	var globals []*symtab.Symbol
+	for _, sym := range obj.Symtab.Symbols() {
+		if sym.Kind == symtab.Extern && f.typ == elf.ET_EXEC {
+			// External symbols are not referred to by executables.
+			continue
+		}
+		if sym.Global || sym.Kind == symtab.Extern {
+			globals = append(globals, sym)
+			continue
+		}
+		s, err := f.symbol(obj, sym, syms.strtab)
+		if err != nil {
+			return nil, err
+		}
+		syms.syms = append(syms.syms, s)
+	}
+	syms.nlocals = len(syms.syms) + 1
+	for _, sym := range globals {
+		s, err := f.symbol(obj, sym, syms.strtab)
+		if err != nil {
+			return nil, err
+		}
+		syms.index[sym.Name] = uint32(len(syms.syms) + 1)
+		syms.syms = append(syms.syms, s)
+	}
+	for _, name := range obj.Globals {
+		if obj.Symtab.Symbol(name) == nil {
+			return nil, fmt.Errorf("elf.file.symbols: undefined global symbol %q", name)
+		}
+	}
+	return syms, nil
+}
+
+// symbol returns the ELF symbol of sym, whose name is added to strtab. The value
+// of labels is relative to their section in relocatable objects, and absolute
+// in executables.
+func (f *file) symbol(obj *asm.Object, sym *symtab.Symbol, strtab *strtab) (symbol, error) {
+	s := symbol{name: strtab.add(sym.Name)}
+	bind := elf.STB_LOCAL
+	if sym.Global || sym.Kind == symtab.Extern {
+		bind = elf.STB_GLOBAL
+	}
+	s.info = elf.ST_INFO(bind, elf.STT_NOTYPE)
+	switch sym.Kind {
+	case symtab.Label:
+		sect := obj.Section(sym.Section)
+		if sect == nil {
+			return symbol{}, fmt.Errorf("elf.file.symbol: unable to locate section %q of symbol %q", sym.Section, sym.Name)
+		}
+		s.value = sym.Value
+		if f.typ == elf.ET_REL {
+			s.value -= sect.Addr
+		}
+		s.shndx = elf.SectionIndex(f.index(sym.Section))
+	case symtab.Const:
+		s.value = sym.Value
+		s.shndx = elf.SHN_ABS
+	case symtab.Extern:
+		s.shndx = elf.SHN_UNDEF
+	}
+	return s, nil
+}
+
+// symtabSection returns the symbol table section of syms, whose names are held
+// by the string table of the given section header index.
+func (f *file) symtabSection(syms *symbols, strtabIndex uint32) *section {
+	buf := new(bytes.Buffer)
+	entsize := int64(16)
+	if f.class == elf.ELFCLASS64 {
+		entsize = 24
+	}
+	buf.Write(make([]byte, entsize))
+	for _, s := range syms.syms {
+		if f.class == elf.ELFCLASS64 {
+			bin.Write(buf, f.order, &elf.Sym64{
+				Name:  s.name,
+				Info:  s.info,
+				Shndx: uint16(s.shndx),
+				Value: uint64(s.value),
+			})
+		} else {
+			bin.Write(buf, f.order, &elf.Sym32{
+				Name:  s.name,
+				Value: uint32(s.value),
+				Info:  s.info,
+				Shndx: uint16(s.shndx),
+			})
+		}
+	}
+	return &section{
+		name:    ".symtab",
+		typ:     elf.SHT_SYMTAB,
+		data:    buf.Bytes(),
+		link:    strtabIndex,
+		info:    uint32(syms.nlocals),
+		align:   f.wordSize(),
+		entsize: entsize,
+	}
+}
+
+// relSection returns the relocation section of the relocation records of sect,
+// whose contents are held by s. The fields of the relocation records in s are
+// set to their addends if the relocation section holds implicit addends, and
+// zero otherwise. The symbol table indices of external symbols and labels are
+// given by syms.
+func (f *file) relSection(s *section, sect *asm.Section, syms *symbols) (*section, error) {
+	s.data = append([]byte(nil), s.data...)
+	buf := new(bytes.Buffer)
+	for _, r := range sect.Relocs {
+		field := s.data[r.Offset : r.Offset+int64(r.Size)]
+		typ, ok := f.mach.relocs[relocKind{size: r.Size, pcrel: r.PCRel}]
+		if r.Type != symtab.Field {
+			typ, ok = f.mach.insts[r.Type]
+			if !ok {
+				return nil, fmt.Errorf("elf.file.relSection: unsupported %v relocation of %q section", r.Type, sect.Name)
+			}
+			if r.Type == symtab.ARM64Branch26 && f.order.Uint32(field)&0x80000000 == 0 {
+				// b rather than bl.
+				typ = uint32(elf.R_AARCH64_JUMP26)
+			}
+		} else if !ok {
+			kind := "absolute"
+			if r.PCRel {
+				kind = "PC-relative"
+			}
+			return nil, fmt.Errorf("elf.file.relSection: unsupported %d-byte %s relocation of %q section", r.Size, kind, sect.Name)
+		}
+		// Symbol table index of the referenced section or external symbol.
+		sym := uint32(f.index(r.Section))
+		if r.Symbol != "" {
+			sym = syms.index[r.Symbol]
+		}
+		addend := r.Addend
+		if r.Type == symtab.RISCVPCRelLo12I {
+			// The label of the auipc instruction, whose relocation record
+			// refers to the address.
+			sym, addend = syms.hi[location{sect: sect.Name, off: r.Offset - 4}], 0
+		}
+		switch {
+		case f.mach.rela:
+			// Relocated fields hold zero.
+		case r.Type == symtab.Field:
+			f.put(field, uint64(addend))
+		default:
+			f.patch(field, r.Type, addend)
+		}
+		switch {
+		case f.class == elf.ELFCLASS64 && f.mach.rela:
+			bin.Write(buf, f.order, &elf.Rela64{Off: uint64(r.Offset), Info: elf.R_INFO(sym, typ), Addend: addend})
+		case f.class == elf.ELFCLASS64:
+			bin.Write(buf, f.order, &elf.Rel64{Off: uint64(r.Offset), Info: elf.R_INFO(sym, typ)})
+		case f.mach.rela:
+			bin.Write(buf, f.order, &elf.Rela32{Off: uint32(r.Offset), Info: elf.R_INFO32(sym, typ), Addend: int32(addend)})
+		default:
+			bin.Write(buf, f.order, &elf.Rel32{Off: uint32(r.Offset), Info: elf.R_INFO32(sym, typ)})
+		}
+	}
+	rel := &section{
+		name:    ".rel" + sect.Name,
+		typ:     elf.SHT_REL,
+		flags:   elf.SHF_INFO_LINK,
+		data:    buf.Bytes(),
+		align:   f.wordSize(),
+		entsize: 2 * f.wordSize(),
+	}
+	if f.mach.rela {
+		rel.name = ".rela" + sect.Name
+		rel.typ = elf.SHT_RELA
+		rel.entsize = 3 * f.wordSize()
+	}
+	return rel, nil
+}
+
+// put stores the value of a field of machine code in b, using the byte order of
+// the file.
+func (f *file) put(b []byte, val uint64) {
+	switch len(b) {
+	case 1:
+		b[0] = uint8(val)
+	case 2:
+		f.order.PutUint16(b, uint16(val))
+	case 4:
+		f.order.PutUint32(b, uint32(val))
+	case 8:
+		f.order.PutUint64(b, val)
+	}
+}
+
+// patch stores the implicit addend of a relocation record of the given type in
+// the bit field of the instruction b.
+func (f *file) patch(b []byte, typ symtab.RelocType, addend int64) {
+	switch typ {
+	case symtab.ThumbCall, symtab.ThumbJump24:
+		// imm32 = SignExtend(S:I1:I2:imm10:imm11:'0'), where I1 = NOT(J1 XOR S)
+		// and I2 = NOT(J2 XOR S).
+		u := uint32(addend)
+		s, i1, i2 := u>>24&1, u>>23&1, u>>22&1
+		j1, j2 := ^(i1^s)&1, ^(i2^s)&1
+		f.order.PutUint16(b, f.order.Uint16(b)&^0x7FF|uint16(s<<10|u>>12&0x3FF))
+		f.order.PutUint16(b[2:], f.order.Uint16(b[2:])&^0x2FFF|uint16(j1<<13|j2<<11|u>>1&0x7FF))
+	case symtab.MIPS26:
+		f.order.PutUint32(b, f.order.Uint32(b)&^0x3FFFFFF|uint32(addend>>2)&0x3FFFFFF)
+	case symtab.MIPSHi16:
+		// Adjusted for the sign extension of the lower 16 bits.
+		f.order.PutUint32(b, f.order.Uint32(b)&^0xFFFF|uint32((addend+0x8000)>>16)&0xFFFF)
+	case symtab.MIPSLo16:
+		f.order.PutUint32(b, f.order.Uint32(b)&^0xFFFF|uint32(addend)&0xFFFF)
+	}
+}
@@ -0,0 +1,101 @@
+// Package ihex implements a writer of Intel HEX files; a text format of memory
+// images used to program microcontrollers and EPROMs.
+//
+// Each line of an Intel HEX file is a record of the form
+//
+//	:LLAAAATTDD...CC
+//
+// where LL is the number of data bytes DD, AAAA is the 16-bit address of the
+// data, TT is the record type and CC is the checksum of the record. Addresses
+// of 32 bits are given by extended linear address records, which specify the
+// upper 16 bits of the address of subsequent data records.
+package ihex
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/obj"
+)
+
+// Record types.
+const (
+	typeData          = 0x00
+	typeEOF           = 0x01
+	typeLinearAddress = 0x04
+)
+
+// recordSize is the maximum number of data bytes of data records.
+const recordSize = 16
+
+// Format is the Intel HEX output format.
+type Format struct {
+	// Origin is the start address of programs without an org directive.
+	Origin int64
+	// Pad specifies that the gaps between sections are filled with zero bytes;
+	// otherwise, the data records skip the gaps.
+	Pad bool
+}
+
+// Config returns the assembler configuration of Intel HEX files, which places
+// programs without an org directive at the configured origin.
+func (f *Format) Config(a arch.Arch) (*asm.Config, error) {
+	return &asm.Config{Origin: f.Origin}, nil
+}
+
+// Write writes the Intel HEX file of the program of o to w.
+func (f *Format) Write(w io.Writer, o *asm.Object) error {
+	return Write(w, o, f.Pad)
+}
+
+// Write writes the Intel HEX file of the program of o to w. The gaps between
+// sections are filled with zero bytes if pad is set; and skipped otherwise.
+func Write(w io.Writer, o *asm.Object, pad bool) error {
+	blocks, err := obj.Blocks(o, pad)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	// Upper 16 bits of the address of the previous data record.
+	upper := int64(0)
+	for _, block := range blocks {
+		if block.Addr < 0 || block.Addr+int64(len(block.Data)) > 1<<32 {
+			return fmt.Errorf("ihex.Write: block at address 0x%X of %d bytes exceeds 32-bit address space", block.Addr, len(block.Data))
+		}
+		addr, data := block.Addr, block.Data
+		for len(data) > 0 {
+			if addr>>16 != upper {
+				upper = addr >> 16
+				writeRecord(bw, typeLinearAddress, 0, []byte{byte(upper >> 8), byte(upper)})
+			}
+			// Data records do not cross 64 KiB boundaries.
+			n := recordSize
+			if n > len(data) {
+				n = len(data)
+			}
+			if end := (upper + 1) << 16; addr+int64(n) > end {
+				n = int(end - addr)
+			}
+			writeRecord(bw, typeData, uint16(addr), data[:n])
+			addr += int64(n)
+			data = data[n:]
+		}
+	}
+	writeRecord(bw, typeEOF, 0, nil)
+	return bw.Flush()
+}
+
+// writeRecord writes a record of the given type, address and data to w.
+func writeRecord(w *bufio.Writer, typ byte, addr uint16, data []byte) {
+	rec := []byte{byte(len(data)), byte(addr >> 8), byte(addr), typ}
+	rec = append(rec, data...)
+	sum := byte(0)
+	for _, b := range rec {
+		sum += b
+	}
+	rec = append(rec, -sum)
+	fmt.Fprintf(w, ":%X\n", rec)
+}
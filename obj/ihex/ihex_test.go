@@ -0,0 +1,59 @@
+package ihex
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mewlang/asm/asm/x86"
+	"github.com/mewlang/asm/internal/asmtest"
+)
+
+func TestWrite(t *testing.T) {
+	const src = `
+	org 0x1FFFC
+	db 1, 2, 3, 4, 5, 6, 7
+	section .data
+	db 9
+`
+	golden := []struct {
+		pad  bool
+		want string
+	}{
+		// i=0
+		{
+			pad: false,
+			want: `:020000040001F9
+:04FFFC0001020304F7
+:020000040002F8
+:03000000050607EB
+:0100040009F2
+:00000001FF
+`,
+		},
+		// i=1
+		{
+			pad: true,
+			want: `:020000040001F9
+:04FFFC0001020304F7
+:020000040002F8
+:050000000506070009E0
+:00000001FF
+`,
+		},
+	}
+
+	obj, err := asmtest.Assemble(nil, x86.Encoder, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, g := range golden {
+		buf := new(bytes.Buffer)
+		if err := Write(buf, obj, g.pad); err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if got := buf.String(); got != g.want {
+			t.Errorf("i=%d: output mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Package ines implements a writer of iNES images; the file format of NES
+// cartridge ROM images used by emulators.
+//
+// An iNES image consists of a 16-byte header followed by the contents of the
+// PRG ROM, which holds the program of the CPU, and of the CHR ROM, which holds
+// the pattern tables of the PPU. The PRG ROM holds the sections of an assembled
+// 6502 program except for the following sections:
+//
+//	.chr      contents of the CHR ROM
+//	.vectors  interrupt vectors of the CPU; i.e. the 16-bit addresses of the
+//	          nmi, reset and irq handlers, in that order
+//
+// The end of the PRG ROM is mapped at the end of the address space of the CPU,
+// and the interrupt vectors are placed at its last 6 bytes; which are located
+// at 0xFFFA. For instance, a program of the NROM board is located at 0x8000 or
+// 0xC000 using an org directive, and ends with
+//
+//	section ".vectors"
+//	dw nmi, reset, irq
+package ines
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/asm"
+)
+
+// Names of the sections which are not part of the PRG ROM.
+const (
+	// CHRSection is the name of the section holding the contents of the CHR
+	// ROM.
+	CHRSection = ".chr"
+	// VectorsSection is the name of the section holding the interrupt vectors.
+	VectorsSection = ".vectors"
+)
+
+// Sizes in bytes of ROM banks and interrupt vectors.
+const (
+	prgBankSize = 16 * 1024
+	chrBankSize = 8 * 1024
+	vectorsSize = 6
+)
+
+// Mirroring specifies the arrangement of the nametables of the PPU.
+type Mirroring uint8
+
+// Nametable arrangements.
+const (
+	// Horizontal mirroring; used by games which scroll vertically.
+	Horizontal Mirroring = iota
+	// Vertical mirroring; used by games which scroll horizontally.
+	Vertical
+	// FourScreen specifies that the cartridge provides four nametables.
+	FourScreen
+)
+
+// A Header holds the properties of the cartridge which are stored in the header
+// of an iNES image.
+type Header struct {
+	// Mapper is the iNES mapper number of the cartridge board; e.g. 0 for NROM.
+	Mapper uint8
+	// Mirroring of the nametables.
+	Mirroring Mirroring
+	// Battery specifies that the cartridge holds battery-backed PRG RAM.
+	Battery bool
+	// PRGBanks is the number of 16 KiB banks of the PRG ROM; or 0 to use the
+	// smallest number of banks which holds the program, being either 1 or 2.
+	PRGBanks int
+}
+
+// Format is the iNES image output format.
+type Format struct {
+	// Header holds the cartridge properties of the image.
+	Header Header
+}
+
+// Config returns the assembler configuration of iNES images. The programs of
+// iNES images specify their origin using an org directive.
+func (f *Format) Config(a arch.Arch) (*asm.Config, error) {
+	return &asm.Config{}, nil
+}
+
+// Write writes the iNES image of obj to w.
+func (f *Format) Write(w io.Writer, obj *asm.Object) error {
+	return Write(w, obj, &f.Header)
+}
+
+// Write writes the iNES image of obj to w, using the cartridge properties of
+// hdr.
+func Write(w io.Writer, obj *asm.Object, hdr *Header) error {
+	var sects []*asm.Section
+	for _, sect := range obj.Sections {
+		if sect.Name != CHRSection && sect.Name != VectorsSection {
+			sects = append(sects, sect)
+		}
+	}
+	addr, data := asm.Image(sects)
+	if len(data) > 0 && (addr < 0x8000 || addr+int64(len(data)) > 0x10000) {
+		return fmt.Errorf("ines.Write: program at address 0x%X of %d bytes out of range; expected address range [0x8000, 0xFFFF]", addr, len(data))
+	}
+	var vectors []byte
+	if sect := obj.Section(VectorsSection); sect != nil {
+		if len(sect.Data) != vectorsSize {
+			return fmt.Errorf("ines.Write: invalid size of %q section; expected %d bytes, got %d", VectorsSection, vectorsSize, len(sect.Data))
+		}
+		vectors = sect.Data
+	}
+
+	// Lay out the PRG ROM.
+	nprg := hdr.PRGBanks
+	off, ok := 0, false
+	if nprg == 0 {
+		for nprg = 1; !ok && nprg <= 2; nprg++ {
+			off, ok = prgOffset(addr, len(data), len(vectors), nprg)
+		}
+		nprg--
+	} else {
+		off, ok = prgOffset(addr, len(data), len(vectors), nprg)
+	}
+	if !ok || nprg > 0xFF {
+		return fmt.Errorf("ines.Write: program at address 0x%X of %d bytes does not fit in %d PRG ROM banks", addr, len(data), nprg)
+	}
+	prg := make([]byte, nprg*prgBankSize)
+	copy(prg[off:], data)
+	copy(prg[len(prg)-vectorsSize:], vectors)
+
+	// Lay out the CHR ROM.
+	var chr []byte
+	if sect := obj.Section(CHRSection); sect != nil {
+		n := (len(sect.Data) + chrBankSize - 1) / chrBankSize
+		if n > 0xFF {
+			return fmt.Errorf("ines.Write: CHR ROM of %d bytes exceeds 255 banks", len(sect.Data))
+		}
+		chr = make([]byte, n*chrBankSize)
+		copy(chr, sect.Data)
+	}
+
+	// Write the header, the PRG ROM and the CHR ROM.
+	buf := new(bytes.Buffer)
+	flags6 := hdr.Mapper << 4
+	switch hdr.Mirroring {
+	case Vertical:
+		flags6 |= 0x01
+	case FourScreen:
+		flags6 |= 0x08
+	}
+	if hdr.Battery {
+		flags6 |= 0x02
+	}
+	flags7 := hdr.Mapper & 0xF0
+	buf.WriteString("NES\x1A")
+	buf.Write([]byte{byte(nprg), byte(len(chr) / chrBankSize), flags6, flags7})
+	buf.Write(make([]byte, 8))
+	buf.Write(prg)
+	buf.Write(chr)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// prgOffset returns the offset in the PRG ROM of nprg banks of a program of n
+// bytes located at address addr, and true if the program and the interrupt
+// vectors of nvec bytes fit in the PRG ROM.
+func prgOffset(addr int64, n, nvec, nprg int) (off int, ok bool) {
+	size := int64(nprg * prgBankSize)
+	if n == 0 {
+		return 0, int64(nvec) <= size
+	}
+	// The end of the PRG ROM is mapped at 0x10000, and smaller PRG ROMs are
+	// mirrored below.
+	o := ((addr-0x10000)%size + size) % size
+	return int(o), o+int64(n) <= size-int64(nvec)
+}
@@ -0,0 +1,137 @@
+package ines
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mewlang/asm/asm/mos6502"
+	"github.com/mewlang/asm/internal/asmtest"
+)
+
+func TestWrite(t *testing.T) {
+	golden := []struct {
+		src string
+		hdr Header
+		// Expected header.
+		want []byte
+		// Expected PRG ROM contents at the given offsets.
+		prg map[int][]byte
+		// Expected size of the CHR ROM.
+		chr int
+	}{
+		// i=0
+		{
+			src: `
+	org 0xC000
+reset:
+	jmp reset
+nmi:
+irq:
+	rti
+	section ".vectors"
+	dw nmi, reset, irq
+	section ".chr"
+	db 0xFF, 0x81
+`,
+			hdr:  Header{Mirroring: Vertical},
+			want: []byte{'N', 'E', 'S', 0x1A, 1, 1, 0x01, 0x00, 0, 0, 0, 0, 0, 0, 0, 0},
+			prg: map[int][]byte{
+				0x0000: {0x4C, 0x00, 0xC0, 0x40},
+				0x3FFA: {0x03, 0xC0, 0x00, 0xC0, 0x03, 0xC0},
+			},
+			chr: 8192,
+		},
+		// i=1
+		{
+			src: `
+	org 0x8000
+reset:
+	jmp reset
+	section ".vectors"
+	dw reset, reset, reset
+`,
+			hdr:  Header{Mapper: 0x42, Battery: true, PRGBanks: 2},
+			want: []byte{'N', 'E', 'S', 0x1A, 2, 0, 0x22, 0x40, 0, 0, 0, 0, 0, 0, 0, 0},
+			prg: map[int][]byte{
+				0x0000: {0x4C, 0x00, 0x80},
+				0x7FFA: {0x00, 0x80, 0x00, 0x80, 0x00, 0x80},
+			},
+		},
+		// i=2
+		{
+			src: `
+	org 0xBFFE
+	nop
+	nop
+	nop
+`,
+			hdr:  Header{Mirroring: FourScreen},
+			want: []byte{'N', 'E', 'S', 0x1A, 2, 0, 0x08, 0x00, 0, 0, 0, 0, 0, 0, 0, 0},
+			prg: map[int][]byte{
+				0x3FFE: {0xEA, 0xEA, 0xEA},
+			},
+		},
+	}
+
+	for i, g := range golden {
+		obj, err := asmtest.Assemble(nil, mos6502.Encoder, g.src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		buf := new(bytes.Buffer)
+		if err := Write(buf, obj, &g.hdr); err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		img := buf.Bytes()
+		if got := img[:16]; !bytes.Equal(got, g.want) {
+			t.Errorf("i=%d: header mismatch; expected %x, got %x", i, g.want, got)
+			continue
+		}
+		prgSize, chrSize := int(img[4])*16384, int(img[5])*8192
+		if len(img) != 16+prgSize+chrSize || chrSize != g.chr {
+			t.Errorf("i=%d: image size mismatch; expected %d bytes of CHR ROM, got %d bytes of %d", i, g.chr, chrSize, len(img))
+			continue
+		}
+		prg := img[16 : 16+prgSize]
+		for off, want := range g.prg {
+			if got := prg[off : off+len(want)]; !bytes.Equal(got, want) {
+				t.Errorf("i=%d: PRG ROM mismatch at offset 0x%X; expected %x, got %x", i, off, want, got)
+			}
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	golden := []struct {
+		src  string
+		hdr  Header
+		want string
+	}{
+		// i=0
+		{src: "\torg 0x6000\n\tnop", want: "ines.Write: program at address 0x6000 of 1 bytes out of range; expected address range [0x8000, 0xFFFF]"},
+		// i=1
+		{src: "\torg 0x8000\n\tnop\n\tsection \".vectors\"\n\tdw 0", want: `ines.Write: invalid size of ".vectors" section; expected 6 bytes, got 2`},
+		// i=2
+		{src: "\torg 0xFFF8\n\tnop\n\tnop\n\tnop\n\tsection \".vectors\"\n\tdw 0, 0, 0", want: "ines.Write: program at address 0xFFF8 of 3 bytes does not fit in 2 PRG ROM banks"},
+		// i=3
+		{src: "\torg 0x8000\n\tnop", hdr: Header{PRGBanks: 256}, want: "ines.Write: program at address 0x8000 of 1 bytes does not fit in 256 PRG ROM banks"},
+	}
+
+	for i, g := range golden {
+		obj, err := asmtest.Assemble(nil, mos6502.Encoder, g.src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		err = Write(new(bytes.Buffer), obj, &g.hdr)
+		if err == nil {
+			t.Errorf("i=%d: expected error %q, got nil", i, g.want)
+			continue
+		}
+		if got := err.Error(); got != g.want {
+			t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
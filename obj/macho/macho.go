@@ -0,0 +1,586 @@
+// Package macho implements a writer of Mach-O relocatable objects; the object
+// file format of macOS and iOS.
+//
+// Objects hold the sections of a program assembled using the configuration
+// returned by Format.Config, a symbol table and the relocation records of each
+// section; as supported for the x86-64 and arm64 architectures. The Mach-O
+// segment and section names of sections are given by the kind of their names,
+// as specified by obj.SectionKind:
+//
+//	.text     __TEXT,__text
+//	.rodata   __TEXT,__const; as is .rdata
+//	.data     __DATA,__data
+//	.bss      __DATA,__bss; zero-initialized data
+//
+// Other sections are named by their names with the leading '.' replaced by "__"
+// and any other '.' replaced by '_'; e.g. .text.init is placed in
+// __TEXT,__text_init.
+//
+// Labels exported by a global directive are external symbols of the symbol
+// table, and external symbols are undefined symbols; while all other labels and
+// constants are local symbols. Symbol names are written as is; note that the C
+// symbols of Mach-O are prefixed by an underscore, as in _main. The relocation
+// records of arm64 instructions refer to symbols; the sections they refer to
+// are labeled by ltmpN local symbols, where N is the index of the section, as
+// by LLVM.
+package macho
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/internal/bin"
+	"github.com/mewlang/asm/obj"
+	"github.com/mewlang/asm/symtab"
+)
+
+// A machine describes the Mach-O representation of an architecture.
+type machine struct {
+	// CPU type and subtype.
+	cpu    macho.Cpu
+	subcpu uint32
+	// Relocation types, indexed by field size and PC-relativity.
+	relocs map[relocKind]uint8
+	// Relocation types of the bit fields of instructions.
+	insts map[symtab.RelocType]uint8
+	// branch returns true if the PC-relative field at the given offset of
+	// machine code is the target of a branch instruction, and false
+	// otherwise; or nil if branches are not relocated by a relocation type of
+	// their own.
+	branch func(code []byte, off int64) bool
+}
+
+// A relocKind specifies the kind of field of a relocation record.
+type relocKind struct {
+	// Size in bytes of the field.
+	size int
+	// PC-relative field.
+	pcrel bool
+}
+
+// machines maps from architecture name to Mach-O machine.
+var machines = map[string]*machine{
+	"x86-64": {
+		cpu:    macho.CpuAmd64,
+		subcpu: 3,
+		relocs: map[relocKind]uint8{
+			{4, false}: uint8(macho.X86_64_RELOC_UNSIGNED),
+			{4, true}:  uint8(macho.X86_64_RELOC_SIGNED),
+			{8, false}: uint8(macho.X86_64_RELOC_UNSIGNED),
+		},
+		branch: x86Branch,
+	},
+	"arm64": {
+		cpu:    macho.CpuArm64,
+		subcpu: 0,
+		relocs: map[relocKind]uint8{
+			{4, false}: uint8(macho.ARM64_RELOC_UNSIGNED),
+			{8, false}: uint8(macho.ARM64_RELOC_UNSIGNED),
+		},
+		insts: map[symtab.RelocType]uint8{
+			symtab.ARM64Branch26:  uint8(macho.ARM64_RELOC_BRANCH26),
+			symtab.ARM64Page21:    uint8(macho.ARM64_RELOC_PAGE21),
+			symtab.ARM64PageOff12: uint8(macho.ARM64_RELOC_PAGEOFF12),
+		},
+	},
+}
+
+// x86Branch returns true if the 32-bit PC-relative field at the given offset of
+// x86-64 machine code is the target of a call, jmp or conditional jump
+// instruction, and false otherwise.
+func x86Branch(code []byte, off int64) bool {
+	switch {
+	case off >= 1 && (code[off-1] == 0xE8 || code[off-1] == 0xE9):
+		// call rel32 and jmp rel32.
+		return true
+	case off >= 2 && code[off-2] == 0x0F && code[off-1]&0xF0 == 0x80:
+		// jcc rel32.
+		return true
+	}
+	return false
+}
+
+// Section flags and alignment.
+const (
+	// Section of zero-initialized data.
+	zeroFill = 0x1
+	// Section holding only machine code.
+	pureInstructions = 0x80000000
+	// Section holding some machine code.
+	someInstructions = 0x400
+	// Alignment in bytes of sections, and its base 2 logarithm.
+	sectionAlign    = 16
+	sectionAlignLog = 4
+)
+
+// Symbol types.
+const (
+	// Undefined symbol.
+	typeUndef = 0x0
+	// External symbol.
+	typeExt = 0x1
+	// Absolute symbol.
+	typeAbs = 0x2
+	// Symbol defined in a section.
+	typeSect = 0xE
+)
+
+// Format is the Mach-O relocatable object output format.
+type Format struct{}
+
+// Config returns the assembler configuration of relocatable objects.
+func (f *Format) Config(a arch.Arch) (*asm.Config, error) {
+	if _, ok := machines[a.Name()]; !ok {
+		return nil, fmt.Errorf("macho.Format.Config: unsupported architecture %q", a.Name())
+	}
+	return &asm.Config{Relocatable: true}, nil
+}
+
+// Write writes the relocatable object of obj to w.
+func (f *Format) Write(w io.Writer, obj *asm.Object) error {
+	return Write(w, obj)
+}
+
+// A section is a section of a Mach-O object.
+type section struct {
+	// Section of the program.
+	sect *asm.Section
+	// Section and segment names.
+	name, seg string
+	// Flags of the section.
+	flags uint32
+	// Address of the section in the address space of the object.
+	addr int64
+	// Contents of the section, with the fields of relocation records set as
+	// specified by their relocation type.
+	data []byte
+	// Relocation entries of the section.
+	relocs []byte
+	// Local label of the start of the section, to which the relocation records
+	// of instructions referring to the section are relocated; or nil if not
+	// referred to.
+	label *symtab.Symbol
+}
+
+// A symbol is a symbol of a Mach-O object.
+type symbol struct {
+	// Symbol of the program.
+	sym *symtab.Symbol
+	// Symbol type and section number.
+	typ, sect uint8
+	// Value of the symbol.
+	value int64
+}
+
+// Write writes the Mach-O relocatable object (MH_OBJECT) of obj to w. The
+// program of obj must be assembled as a relocatable object.
+func Write(w io.Writer, obj *asm.Object) error {
+	if obj.Symtab == nil || !obj.Symtab.Layout().Relocatable {
+		return fmt.Errorf("macho.Write: program not assembled as a relocatable object")
+	}
+	mach, ok := machines[obj.Arch.Name()]
+	if !ok {
+		return fmt.Errorf("macho.Write: unsupported architecture %q", obj.Arch.Name())
+	}
+	order := obj.Arch.ByteOrder()
+	sects, err := sections(obj)
+	if err != nil {
+		return err
+	}
+	syms, nlocals, nextdefs, err := symbols(obj, sects)
+	if err != nil {
+		return err
+	}
+	for _, s := range sects {
+		if err := s.relocate(mach, order, sects, syms); err != nil {
+			return err
+		}
+	}
+
+	// Lay out the object; the load commands are followed by the contents of
+	// the sections, the relocation entries, the symbol table and the string
+	// table.
+	const (
+		headerSize  = 32
+		segmentSize = 72
+		sectionSize = 80
+		symtabSize  = 24
+		dysymSize   = 80
+		nlistSize   = 16
+		relocSize   = 8
+	)
+	cmdsize := segmentSize + sectionSize*len(sects) + symtabSize + dysymSize
+	segoff := int64(headerSize + cmdsize)
+	var vmsize, filesize int64
+	for _, s := range sects {
+		vmsize = s.addr + int64(len(s.sect.Data))
+		if s.flags&zeroFill == 0 {
+			filesize = vmsize
+		}
+	}
+	off := symtab.AlignUp(segoff+filesize, 4)
+	reloffs := make([]int64, len(sects))
+	for i, s := range sects {
+		reloffs[i] = off
+		off += int64(len(s.relocs))
+	}
+	symoff := symtab.AlignUp(off, 8)
+	strtab, names := stringTable(syms)
+	stroff := symoff + int64(nlistSize*len(syms))
+
+	// Write the Mach-O header and load commands.
+	buf := new(bytes.Buffer)
+	bin.Write(buf, order, &macho.FileHeader{
+		Magic:  macho.Magic64,
+		Cpu:    mach.cpu,
+		SubCpu: mach.subcpu,
+		Type:   macho.TypeObj,
+		Ncmd:   3,
+		Cmdsz:  uint32(cmdsize),
+	})
+	// Reserved.
+	bin.Write(buf, order, uint32(0))
+	bin.Write(buf, order, &macho.Segment64{
+		Cmd:     macho.LoadCmdSegment64,
+		Len:     uint32(segmentSize + sectionSize*len(sects)),
+		Addr:    0,
+		Memsz:   uint64(vmsize),
+		Offset:  uint64(segoff),
+		Filesz:  uint64(filesize),
+		Maxprot: 7,
+		Prot:    7,
+		Nsect:   uint32(len(sects)),
+	})
+	for i, s := range sects {
+		hdr := &macho.Section64{
+			Addr:   uint64(s.addr),
+			Size:   uint64(len(s.sect.Data)),
+			Align:  sectionAlignLog,
+			Reloff: uint32(reloffs[i]),
+			Nreloc: uint32(len(s.relocs) / relocSize),
+			Flags:  s.flags,
+		}
+		copy(hdr.Name[:], s.name)
+		copy(hdr.Seg[:], s.seg)
+		if s.flags&zeroFill == 0 {
+			hdr.Offset = uint32(segoff + s.addr)
+		}
+		if len(s.relocs) == 0 {
+			hdr.Reloff = 0
+		}
+		bin.Write(buf, order, hdr)
+	}
+	bin.Write(buf, order, &macho.SymtabCmd{
+		Cmd:     macho.LoadCmdSymtab,
+		Len:     symtabSize,
+		Symoff:  uint32(symoff),
+		Nsyms:   uint32(len(syms)),
+		Stroff:  uint32(stroff),
+		Strsize: uint32(len(strtab)),
+	})
+	bin.Write(buf, order, &macho.DysymtabCmd{
+		Cmd:        macho.LoadCmdDysymtab,
+		Len:        dysymSize,
+		Ilocalsym:  0,
+		Nlocalsym:  uint32(nlocals),
+		Iextdefsym: uint32(nlocals),
+		Nextdefsym: uint32(nextdefs),
+		Iundefsym:  uint32(nlocals + nextdefs),
+		Nundefsym:  uint32(len(syms) - nlocals - nextdefs),
+	})
+
+	// Write the contents of the sections, the relocation entries, the symbol
+	// table and the string table.
+	for _, s := range sects {
+		if s.flags&zeroFill != 0 {
+			continue
+		}
+		buf.Write(make([]byte, segoff+s.addr-int64(buf.Len())))
+		buf.Write(s.data)
+	}
+	for i, s := range sects {
+		buf.Write(make([]byte, reloffs[i]-int64(buf.Len())))
+		buf.Write(s.relocs)
+	}
+	buf.Write(make([]byte, symoff-int64(buf.Len())))
+	for i, s := range syms {
+		bin.Write(buf, order, &macho.Nlist64{
+			Name:  names[i],
+			Type:  s.typ,
+			Sect:  s.sect,
+			Value: uint64(s.value),
+		})
+	}
+	buf.Write(strtab)
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// sections returns the sections of the object holding the sections of o, in
+// order of address. Zero-initialized sections are placed after all other
+// sections.
+func sections(o *asm.Object) ([]*section, error) {
+	var sects, bss []*section
+	seen := make(map[string]string)
+	for _, sect := range o.Sections {
+		s := &section{sect: sect, data: sect.Data, seg: "__DATA"}
+		if sect.Name == ".rodata" || sect.Name == ".rdata" {
+			s.name = "__const"
+		} else {
+			s.name = "__" + strings.Replace(strings.TrimPrefix(sect.Name, "."), ".", "_", -1)
+		}
+		if len(s.name) > 16 {
+			return nil, fmt.Errorf("macho.sections: Mach-O name %q of %q section exceeds 16 bytes", s.name, sect.Name)
+		}
+		if prev, ok := seen[s.name]; ok {
+			return nil, fmt.Errorf("macho.sections: sections %q and %q have the same Mach-O name %q", prev, sect.Name, s.name)
+		}
+		seen[s.name] = sect.Name
+		switch obj.SectionKind(sect.Name) {
+		case obj.Text:
+			s.seg = "__TEXT"
+			s.flags = pureInstructions | someInstructions
+		case obj.ROData:
+			s.seg = "__TEXT"
+		case obj.BSS:
+			for _, b := range sect.Data {
+				if b != 0 {
+					return nil, fmt.Errorf("macho.sections: non-zero data in %q section", sect.Name)
+				}
+			}
+			if len(sect.Relocs) > 0 {
+				return nil, fmt.Errorf("macho.sections: relocations in %q section", sect.Name)
+			}
+			s.flags = zeroFill
+			bss = append(bss, s)
+			continue
+		}
+		sects = append(sects, s)
+	}
+	sects = append(sects, bss...)
+	addr := int64(0)
+	for _, s := range sects {
+		s.addr = symtab.AlignUp(addr, sectionAlign)
+		addr = s.addr + int64(len(s.sect.Data))
+	}
+	return sects, nil
+}
+
+// index returns the section number of the named section of the program; or 0
+// if not present.
+func index(sects []*section, name string) int {
+	for i, s := range sects {
+		if s.sect.Name == name {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// symbols returns the symbol table of the symbols of obj, and the number of
+// local and external symbols. Local symbols precede external symbols, which
+// precede undefined symbols; external and undefined symbols are sorted by name.
+func symbols(obj *asm.Object, sects []*section) (syms []symbol, nlocals, nextdefs int, err error) {
+	var locals, extdefs, undefs []symbol
+	for _, sym := range obj.Symtab.Symbols() {
+		s := symbol{sym: sym}
+		switch sym.Kind {
+		case symtab.Label:
+			i := index(sects, sym.Section)
+			if i == 0 {
+				return nil, 0, 0, fmt.Errorf("macho.symbols: unable to locate section %q of symbol %q", sym.Section, sym.Name)
+			}
+			s.typ, s.sect = typeSect, uint8(i)
+			s.value = sects[i-1].addr + sym.Value - sects[i-1].sect.Addr
+		case symtab.Const:
+			s.typ = typeAbs
+			s.value = sym.Value
+		case symtab.Extern:
+			s.typ = typeUndef | typeExt
+			undefs = append(undefs, s)
+			continue
+		}
+		if sym.Global {
+			s.typ |= typeExt
+			extdefs = append(extdefs, s)
+			continue
+		}
+		locals = append(locals, s)
+	}
+	// Label the sections referred to by the relocation records of instructions,
+	// which are relocated relative to symbols; as named by LLVM.
+	for _, s := range sects {
+		for _, r := range s.sect.Relocs {
+			if r.Type == symtab.Field || r.Symbol != "" {
+				continue
+			}
+			i := index(sects, r.Section)
+			if i == 0 || sects[i-1].label != nil {
+				continue
+			}
+			t := sects[i-1]
+			t.label = &symtab.Symbol{Name: fmt.Sprintf("ltmp%d", i-1), Kind: symtab.Label, Section: t.sect.Name, Value: t.sect.Addr}
+			locals = append(locals, symbol{sym: t.label, typ: typeSect, sect: uint8(i), value: t.addr})
+		}
+	}
+	for _, name := range obj.Globals {
+		if obj.Symtab.Symbol(name) == nil {
+			return nil, 0, 0, fmt.Errorf("macho.symbols: undefined global symbol %q", name)
+		}
+	}
+	byName := func(syms []symbol) {
+		sort.Slice(syms, func(i, j int) bool {
+			return syms[i].sym.Name < syms[j].sym.Name
+		})
+	}
+	byName(extdefs)
+	byName(undefs)
+	syms = append(append(locals, extdefs...), undefs...)
+	return syms, len(locals), len(extdefs), nil
+}
+
+// relocate sets the relocation entries of the section, and the fields of its
+// relocation records. References to sections are relocated relative to the
+// address space of the object, and references to external symbols relative to
+// the symbol; the fields of PC-relative records are relative to the end of the
+// field. The relocation records of instructions are relocated relative to
+// symbols, as specified by instReloc.
+func (s *section) relocate(mach *machine, order binary.ByteOrder, sects []*section, syms []symbol) error {
+	if len(s.sect.Relocs) == 0 {
+		return nil
+	}
+	s.data = append([]byte(nil), s.data...)
+	buf := new(bytes.Buffer)
+	for _, r := range s.sect.Relocs {
+		if r.Type != symtab.Field {
+			if err := s.instReloc(buf, mach, order, r, sects, syms); err != nil {
+				return err
+			}
+			continue
+		}
+		typ, ok := mach.relocs[relocKind{size: r.Size, pcrel: r.PCRel}]
+		if !ok {
+			kind := "absolute"
+			if r.PCRel {
+				kind = "PC-relative"
+			}
+			return fmt.Errorf("macho.section.relocate: unsupported %d-byte %s relocation of %q section", r.Size, kind, s.sect.Name)
+		}
+		if r.PCRel && mach.branch != nil && r.Addend == -4 && mach.branch(s.data, r.Offset) {
+			typ = uint8(macho.X86_64_RELOC_BRANCH)
+		}
+		var num, extern uint32
+		val := r.Addend
+		if r.Symbol != "" {
+			for i, sym := range syms {
+				if sym.sym.Name == r.Symbol {
+					num = uint32(i)
+					break
+				}
+			}
+			extern = 1
+			if r.PCRel {
+				val += int64(r.Size)
+			}
+		} else {
+			i := index(sects, r.Section)
+			if i == 0 {
+				return fmt.Errorf("macho.section.relocate: unable to locate section %q", r.Section)
+			}
+			num = uint32(i)
+			val += sects[i-1].addr
+			if r.PCRel {
+				val -= s.addr + r.Offset
+			}
+		}
+		put(order, s.data[r.Offset:r.Offset+int64(r.Size)], uint64(val))
+		var pcrel uint32
+		if r.PCRel {
+			pcrel = 1
+		}
+		length := map[int]uint32{1: 0, 2: 1, 4: 2, 8: 3}[r.Size]
+		bin.Write(buf, order, uint32(r.Offset))
+		bin.Write(buf, order, num|pcrel<<24|length<<25|extern<<27|uint32(typ)<<28)
+	}
+	s.relocs = buf.Bytes()
+	return nil
+}
+
+// instReloc appends the relocation entries of the relocation record r of a bit
+// field of an instruction to buf. References to sections are relocated relative
+// to the label of the section, and references to external symbols relative to
+// the symbol. Non-zero addends are given by a preceding ARM64_RELOC_ADDEND
+// entry, and the field holds zero.
+func (s *section) instReloc(buf *bytes.Buffer, mach *machine, order binary.ByteOrder, r symtab.Reloc, sects []*section, syms []symbol) error {
+	typ, ok := mach.insts[r.Type]
+	if !ok {
+		return fmt.Errorf("macho.section.instReloc: unsupported %v relocation of %q section", r.Type, s.sect.Name)
+	}
+	var sym *symtab.Symbol
+	if r.Symbol == "" {
+		i := index(sects, r.Section)
+		if i == 0 {
+			return fmt.Errorf("macho.section.instReloc: unable to locate section %q", r.Section)
+		}
+		sym = sects[i-1].label
+	}
+	var num uint32
+	for i, t := range syms {
+		if t.sym == sym || sym == nil && t.sym.Name == r.Symbol {
+			num = uint32(i)
+			break
+		}
+	}
+	if r.Addend != 0 {
+		// The addend is a signed 24-bit integer.
+		if r.Addend < -1<<23 || r.Addend >= 1<<23 {
+			return fmt.Errorf("macho.section.instReloc: addend %d of %v relocation of %q section out of range", r.Addend, r.Type, s.sect.Name)
+		}
+		bin.Write(buf, order, uint32(r.Offset))
+		bin.Write(buf, order, uint32(r.Addend)&0xFFFFFF|2<<25|uint32(macho.ARM64_RELOC_ADDEND)<<28)
+	}
+	var pcrel uint32
+	if r.PCRel {
+		pcrel = 1
+	}
+	bin.Write(buf, order, uint32(r.Offset))
+	bin.Write(buf, order, num|pcrel<<24|2<<25|1<<27|uint32(typ)<<28)
+	return nil
+}
+
+// stringTable returns the string table of the names of the given symbols, and
+// the offset of each name; the table is padded to a multiple of 8 bytes.
+func stringTable(syms []symbol) ([]byte, []uint32) {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(0)
+	names := make([]uint32, len(syms))
+	for i, s := range syms {
+		names[i] = uint32(buf.Len())
+		buf.WriteString(s.sym.Name)
+		buf.WriteByte(0)
+	}
+	buf.Write(make([]byte, symtab.AlignUp(int64(buf.Len()), 8)-int64(buf.Len())))
+	return buf.Bytes(), names
+}
+
+// put stores the value of a field of machine code in b, using the given byte
+// order.
+func put(order binary.ByteOrder, b []byte, val uint64) {
+	switch len(b) {
+	case 1:
+		b[0] = uint8(val)
+	case 2:
+		order.PutUint16(b, uint16(val))
+	case 4:
+		order.PutUint32(b, uint32(val))
+	case 8:
+		order.PutUint64(b, val)
+	}
+}
@@ -0,0 +1,260 @@
+package macho
+
+import (
+	"bytes"
+	"debug/macho"
+	"reflect"
+	"testing"
+
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/asm/arm64"
+	"github.com/mewlang/asm/asm/x86"
+	"github.com/mewlang/asm/internal/asmtest"
+)
+
+// A sym is a symbol, with the name of its section.
+type sym struct {
+	name  string
+	typ   uint8
+	sect  string
+	value uint64
+}
+
+func TestWrite(t *testing.T) {
+	golden := []struct {
+		enc asm.Encoder
+		src string
+		// Expected segment and section names, and addresses of sections.
+		sects []string
+		addrs []uint64
+		// Expected symbols.
+		syms []sym
+		// Expected relocation entries of the first section.
+		relocs []macho.Reloc
+		// Expected contents of each section.
+		data [][]byte
+	}{
+		// i=0
+		{
+			enc: x86.Encoder64,
+			src: `
+	extern puts
+	global main
+	section .text
+main:
+	lea rdi, [rel msg]
+	call puts
+	mov rax, [rel ptr]
+	ret
+	section .data
+msg:
+	db "hi", 0
+ptr:
+	dq msg
+	dq puts + 3
+	section .bss
+buf:
+	dq 0
+`,
+			sects: []string{"__TEXT,__text", "__DATA,__data", "__DATA,__bss"},
+			addrs: []uint64{0x00, 0x20, 0x40},
+			syms: []sym{
+				{name: "msg", typ: typeSect, sect: "__data", value: 0x20},
+				{name: "ptr", typ: typeSect, sect: "__data", value: 0x23},
+				{name: "buf", typ: typeSect, sect: "__bss", value: 0x40},
+				{name: "main", typ: typeSect | typeExt, sect: "__text", value: 0x00},
+				{name: "puts", typ: typeUndef | typeExt, sect: "", value: 0x00},
+			},
+			relocs: []macho.Reloc{
+				{Addr: 3, Value: 2, Type: uint8(macho.X86_64_RELOC_SIGNED), Len: 2, Pcrel: true},
+				{Addr: 8, Value: 4, Type: uint8(macho.X86_64_RELOC_BRANCH), Len: 2, Pcrel: true, Extern: true},
+				{Addr: 15, Value: 2, Type: uint8(macho.X86_64_RELOC_SIGNED), Len: 2, Pcrel: true},
+			},
+			data: [][]byte{
+				{
+					0x48, 0x8D, 0x3D, 0x19, 0x00, 0x00, 0x00, // lea rdi, [rel msg]
+					0xE8, 0x00, 0x00, 0x00, 0x00, // call puts
+					0x48, 0x8B, 0x05, 0x10, 0x00, 0x00, 0x00, // mov rax, [rel ptr]
+					0xC3, // ret
+				},
+				{
+					'h', 'i', 0x00, // msg
+					0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // dq msg
+					0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // dq puts + 3
+				},
+				nil,
+			},
+		},
+		// i=1
+		{
+			enc: arm64.Encoder,
+			src: `
+	global f
+	section .text
+f:
+	ret
+	section .rodata
+p:
+	dq f
+	dd p + 4
+`,
+			sects: []string{"__TEXT,__text", "__TEXT,__const"},
+			addrs: []uint64{0x00, 0x10},
+			syms: []sym{
+				{name: "p", typ: typeSect, sect: "__const", value: 0x10},
+				{name: "f", typ: typeSect | typeExt, sect: "__text", value: 0x00},
+			},
+			data: [][]byte{
+				{0xC0, 0x03, 0x5F, 0xD6}, // ret
+				{
+					0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // dq f
+					0x14, 0x00, 0x00, 0x00, // dd p + 4
+				},
+			},
+		},
+		// i=2
+		{
+			enc: arm64.Encoder,
+			src: `
+	extern _puts
+	global _main
+	section .text
+_main:
+	adrp x0, msg
+	add x0, x0, #msg
+	bl _puts
+	b _puts+8
+	section .data
+	dd 0
+msg:
+	db "hi", 0
+`,
+			sects: []string{"__TEXT,__text", "__DATA,__data"},
+			addrs: []uint64{0x00, 0x10},
+			syms: []sym{
+				{name: "msg", typ: typeSect, sect: "__data", value: 0x14},
+				{name: "ltmp1", typ: typeSect, sect: "__data", value: 0x10},
+				{name: "_main", typ: typeSect | typeExt, sect: "__text", value: 0x00},
+				{name: "_puts", typ: typeUndef | typeExt, sect: "", value: 0x00},
+			},
+			relocs: []macho.Reloc{
+				{Addr: 0, Value: 4, Type: uint8(macho.ARM64_RELOC_ADDEND), Len: 2},
+				{Addr: 0, Value: 1, Type: uint8(macho.ARM64_RELOC_PAGE21), Len: 2, Pcrel: true, Extern: true},
+				{Addr: 4, Value: 4, Type: uint8(macho.ARM64_RELOC_ADDEND), Len: 2},
+				{Addr: 4, Value: 1, Type: uint8(macho.ARM64_RELOC_PAGEOFF12), Len: 2, Extern: true},
+				{Addr: 8, Value: 3, Type: uint8(macho.ARM64_RELOC_BRANCH26), Len: 2, Pcrel: true, Extern: true},
+				{Addr: 12, Value: 8, Type: uint8(macho.ARM64_RELOC_ADDEND), Len: 2},
+				{Addr: 12, Value: 3, Type: uint8(macho.ARM64_RELOC_BRANCH26), Len: 2, Pcrel: true, Extern: true},
+			},
+			data: [][]byte{
+				{
+					0x00, 0x00, 0x00, 0x90, // adrp x0, msg
+					0x00, 0x00, 0x00, 0x91, // add x0, x0, #msg
+					0x00, 0x00, 0x00, 0x94, // bl _puts
+					0x00, 0x00, 0x00, 0x14, // b _puts+8
+				},
+				{
+					0x00, 0x00, 0x00, 0x00, // dd 0
+					'h', 'i', 0x00, // msg
+				},
+			},
+		},
+	}
+
+	for i, g := range golden {
+		obj, err := asmtest.Assemble(&asm.Config{Relocatable: true}, g.enc, g.src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		buf := new(bytes.Buffer)
+		if err := Write(buf, obj); err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		f, err := macho.NewFile(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Errorf("i=%d: unable to parse Mach-O file; %v", i, err)
+			continue
+		}
+		if f.Type != macho.TypeObj {
+			t.Errorf("i=%d: file type mismatch; expected %v, got %v", i, macho.TypeObj, f.Type)
+		}
+		var sects []string
+		var addrs []uint64
+		for _, sect := range f.Sections {
+			sects = append(sects, sect.Seg+","+sect.Name)
+			addrs = append(addrs, sect.Addr)
+		}
+		if !reflect.DeepEqual(sects, g.sects) || !reflect.DeepEqual(addrs, g.addrs) {
+			t.Errorf("i=%d: sections mismatch; expected %q at %#x, got %q at %#x", i, g.sects, g.addrs, sects, addrs)
+		}
+		var syms []sym
+		for _, s := range f.Symtab.Syms {
+			sect := ""
+			if s.Sect != 0 {
+				sect = f.Sections[s.Sect-1].Name
+			}
+			syms = append(syms, sym{name: s.Name, typ: s.Type, sect: sect, value: s.Value})
+		}
+		if !reflect.DeepEqual(syms, g.syms) {
+			t.Errorf("i=%d: symbols mismatch; expected %+v, got %+v", i, g.syms, syms)
+		}
+		if relocs := f.Sections[0].Relocs; !reflect.DeepEqual(relocs, g.relocs) {
+			t.Errorf("i=%d: relocations mismatch; expected %+v, got %+v", i, g.relocs, relocs)
+		}
+		for j, sect := range f.Sections {
+			if sect.Flags&zeroFill != 0 {
+				continue
+			}
+			data, err := sect.Data()
+			if err != nil {
+				t.Errorf("i=%d: unable to read %s; %v", i, sect.Name, err)
+				continue
+			}
+			if !bytes.Equal(data, g.data[j]) {
+				t.Errorf("i=%d: %s mismatch; expected %x, got %x", i, sect.Name, g.data[j], data)
+			}
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	golden := []struct {
+		enc         asm.Encoder
+		src         string
+		relocatable bool
+		want        string
+	}{
+		// i=0
+		{enc: x86.Encoder64, src: "\tret", relocatable: false, want: "macho.Write: program not assembled as a relocatable object"},
+		// i=1
+		{enc: x86.Encoder, src: "\tret", relocatable: true, want: `macho.Write: unsupported architecture "x86"`},
+		// i=2
+		{enc: x86.Encoder64, src: "\tglobal main\n\tret", relocatable: true, want: `macho.symbols: undefined global symbol "main"`},
+		// i=3
+		{enc: x86.Encoder64, src: "\tsection .bss\n\tdb 1", relocatable: true, want: `macho.sections: non-zero data in ".bss" section`},
+		// i=4
+		{enc: x86.Encoder64, src: "\tsection .rodata\n\tdb 1\n\tsection .rdata\n\tdb 2", relocatable: true, want: `macho.sections: sections ".rodata" and ".rdata" have the same Mach-O name "__const"`},
+		// i=5
+		{enc: x86.Encoder64, src: "\tsection .text.initialization\n\tret", relocatable: true, want: `macho.sections: Mach-O name "__text_initialization" of ".text.initialization" section exceeds 16 bytes`},
+		// i=6
+		{enc: arm64.Encoder, src: "\textern f\n\tb f+0x800000", relocatable: true, want: `macho.section.instReloc: addend 8388608 of ARM64 branch26 relocation of ".text" section out of range`},
+	}
+
+	for i, g := range golden {
+		obj, err := asmtest.Assemble(&asm.Config{Relocatable: g.relocatable}, g.enc, g.src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		err = Write(new(bytes.Buffer), obj)
+		if err == nil {
+			t.Errorf("i=%d: expected error %q, got nil", i, g.want)
+			continue
+		}
+		if got := err.Error(); got != g.want {
+			t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
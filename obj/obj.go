@@ -0,0 +1,122 @@
+// Package obj defines the interface of the output file formats of assembled
+// programs, and implements the memory images of formats which hold the raw
+// machine code of programs; such as flat binaries and Intel HEX files.
+//
+// The object file formats share the kinds of sections, as given by their names:
+//
+//	.text     executable code; as are sections prefixed by ".text."
+//	.rodata   read-only data; as are .rdata and sections prefixed by ".rodata."
+//	.bss      zero-initialized data, which occupies no space in the file; as
+//	          are sections prefixed by ".bss."
+//
+// Any other section holds writable data; such as .data.
+package obj
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/asm"
+)
+
+// A Format is an output file format of assembled programs.
+type Format interface {
+	// Config returns the assembler configuration of programs of the given
+	// architecture written in the format.
+	Config(a arch.Arch) (*asm.Config, error)
+	// Write writes the file of the program of obj to w. The program of obj is
+	// assembled using the configuration returned by Config.
+	Write(w io.Writer, obj *asm.Object) error
+}
+
+// A Kind specifies the kind of contents of a section.
+type Kind uint8
+
+// Kinds of sections.
+const (
+	// Writable data.
+	Data Kind = iota
+	// Executable code.
+	Text
+	// Read-only data.
+	ROData
+	// Zero-initialized data.
+	BSS
+)
+
+// SectionKind returns the kind of the named section.
+func SectionKind(name string) Kind {
+	switch {
+	case hasPrefix(name, ".text"):
+		return Text
+	case hasPrefix(name, ".rodata"), name == ".rdata":
+		return ROData
+	case hasPrefix(name, ".bss"):
+		return BSS
+	}
+	return Data
+}
+
+// hasPrefix returns true if the section name is equal to prefix or starts with
+// prefix followed by a '.', and false otherwise.
+func hasPrefix(name, prefix string) bool {
+	return name == prefix || strings.HasPrefix(name, prefix+".")
+}
+
+// A Block is a contiguous block of the memory image of a program.
+type Block struct {
+	// Addr is the address of the first byte of the block.
+	Addr int64
+	// Data holds the contents of the block.
+	Data []byte
+}
+
+// Blocks returns the memory image of the program of obj as blocks of
+// contiguous memory, sorted by address. Sections separated by gaps are placed
+// in separate blocks, unless pad is set; in which case the gaps are filled with
+// zero bytes and the image consists of a single block. Empty sections are
+// omitted. Programs with relocation records, such as references to external
+// symbols, have no memory image.
+func Blocks(obj *asm.Object, pad bool) ([]Block, error) {
+	if obj.Symtab != nil && obj.Symtab.Layout().Relocatable {
+		return nil, fmt.Errorf("obj.Blocks: program assembled as a relocatable object")
+	}
+	var sects []*asm.Section
+	for _, sect := range obj.Sections {
+		if len(sect.Relocs) > 0 {
+			return nil, fmt.Errorf("obj.Blocks: unresolved reference to external symbol %q in %q section", sect.Relocs[0].Symbol, sect.Name)
+		}
+		if len(sect.Data) > 0 {
+			sects = append(sects, sect)
+		}
+	}
+	sort.SliceStable(sects, func(i, j int) bool {
+		return sects[i].Addr < sects[j].Addr
+	})
+	for i := 1; i < len(sects); i++ {
+		prev := sects[i-1]
+		if sects[i].Addr < prev.Addr+int64(len(prev.Data)) {
+			return nil, fmt.Errorf("obj.Blocks: sections %q and %q overlap", prev.Name, sects[i].Name)
+		}
+	}
+	if len(sects) == 0 {
+		return nil, nil
+	}
+	if pad {
+		addr, data := asm.Image(sects)
+		return []Block{{Addr: addr, Data: data}}, nil
+	}
+	var blocks []Block
+	for _, sect := range sects {
+		if n := len(blocks); n > 0 && blocks[n-1].Addr+int64(len(blocks[n-1].Data)) == sect.Addr {
+			blocks[n-1].Data = append(blocks[n-1].Data, sect.Data...)
+			continue
+		}
+		data := append([]byte(nil), sect.Data...)
+		blocks = append(blocks, Block{Addr: sect.Addr, Data: data})
+	}
+	return blocks, nil
+}
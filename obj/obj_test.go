@@ -0,0 +1,67 @@
+package obj
+
+import (
+	"testing"
+
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/asm/x86"
+	"github.com/mewlang/asm/parser"
+	"github.com/mewlang/asm/token"
+)
+
+func TestBlocksError(t *testing.T) {
+	golden := []struct {
+		src  string
+		conf *asm.Config
+		err  string
+	}{
+		// i=0
+		{
+			src:  "start:\n\tjmp start",
+			conf: &asm.Config{Relocatable: true},
+			err:  "obj.Blocks: program assembled as a relocatable object",
+		},
+		// i=1
+		{
+			src:  "\textern puts\n\tcall puts",
+			conf: &asm.Config{},
+			err:  `obj.Blocks: unresolved reference to external symbol "puts" in ".text" section`,
+		},
+	}
+
+	for i, g := range golden {
+		fset := token.NewFileSet()
+		prog, err := (&parser.Config{Arch: x86.Encoder.Arch()}).ParseFile(fset, "", g.src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		o, err := g.conf.Assemble(fset, x86.Encoder, prog)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		_, err = Blocks(o, false)
+		if err == nil {
+			t.Errorf("i=%d: expected error %q, got nil", i, g.err)
+			continue
+		}
+		if err.Error() != g.err {
+			t.Errorf("i=%d: error mismatch; expected %q, got %q", i, g.err, err.Error())
+		}
+	}
+}
+
+func TestBlocksOverlap(t *testing.T) {
+	o := &asm.Object{
+		Arch: x86.Encoder.Arch(),
+		Sections: []*asm.Section{
+			{Name: ".text", Addr: 0x100, Data: []byte{0, 0, 0, 0}},
+			{Name: ".data", Addr: 0x102, Data: []byte{1}},
+		},
+	}
+	want := `obj.Blocks: sections ".text" and ".data" overlap`
+	if _, err := Blocks(o, false); err == nil || err.Error() != want {
+		t.Errorf("error mismatch; expected %q, got %v", want, err)
+	}
+}
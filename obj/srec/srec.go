@@ -0,0 +1,122 @@
+// Package srec implements a writer of Motorola S-record files; a text format of
+// memory images used to program microcontrollers and EPROMs.
+//
+// Each line of an S-record file is a record of the form
+//
+//	STLLAAAADD...CC
+//
+// where T is the record type, LL is the number of bytes of the address AAAA,
+// the data DD and the checksum CC. The width of addresses is given by the
+// record type; 16 bits for S1 data records and S9 termination records, 24 bits
+// for S2 and S8 records, and 32 bits for S3 and S7 records. A file consists of
+// an S0 header record, followed by data records of the narrowest address width
+// which holds the addresses of the program, an S5 or S6 record holding the
+// number of data records, and a termination record.
+package srec
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/asm"
+	"github.com/mewlang/asm/obj"
+)
+
+// recordSize is the maximum number of data bytes of data records.
+const recordSize = 32
+
+// maxData is the maximum number of address and data bytes of records.
+const maxData = 0xFF - 1
+
+// Format is the Motorola S-record output format.
+type Format struct {
+	// Origin is the start address of programs without an org directive.
+	Origin int64
+	// Pad specifies that the gaps between sections are filled with zero bytes;
+	// otherwise, the data records skip the gaps.
+	Pad bool
+	// Header is the contents of the header record; such as the name of the
+	// program.
+	Header string
+}
+
+// Config returns the assembler configuration of S-record files, which places
+// programs without an org directive at the configured origin.
+func (f *Format) Config(a arch.Arch) (*asm.Config, error) {
+	return &asm.Config{Origin: f.Origin}, nil
+}
+
+// Write writes the S-record file of the program of o to w.
+func (f *Format) Write(w io.Writer, o *asm.Object) error {
+	return Write(w, o, f.Header, f.Pad)
+}
+
+// Write writes the S-record file of the program of o to w, with a header record
+// holding the given header. The gaps between sections are filled with zero
+// bytes if pad is set; and skipped otherwise.
+func Write(w io.Writer, o *asm.Object, header string, pad bool) error {
+	if len(header) > maxData-2 {
+		return fmt.Errorf("srec.Write: header of %d bytes exceeds %d bytes", len(header), maxData-2)
+	}
+	blocks, err := obj.Blocks(o, pad)
+	if err != nil {
+		return err
+	}
+	// Locate the narrowest address width which holds the addresses of the
+	// program.
+	width := 2
+	for _, block := range blocks {
+		end := block.Addr + int64(len(block.Data))
+		if block.Addr < 0 || end > 1<<32 {
+			return fmt.Errorf("srec.Write: block at address 0x%X of %d bytes exceeds 32-bit address space", block.Addr, len(block.Data))
+		}
+		for end-1 >= 1<<uint(8*width) {
+			width++
+		}
+	}
+	// Record types of data and termination records, indexed by address width.
+	dataTypes := map[int]byte{2: '1', 3: '2', 4: '3'}
+	termTypes := map[int]byte{2: '9', 3: '8', 4: '7'}
+
+	bw := bufio.NewWriter(w)
+	writeRecord(bw, '0', 0, 2, []byte(header))
+	n := 0
+	for _, block := range blocks {
+		addr, data := block.Addr, block.Data
+		for len(data) > 0 {
+			m := recordSize
+			if m > len(data) {
+				m = len(data)
+			}
+			writeRecord(bw, dataTypes[width], addr, width, data[:m])
+			addr += int64(m)
+			data = data[m:]
+			n++
+		}
+	}
+	if n < 1<<16 {
+		writeRecord(bw, '5', int64(n), 2, nil)
+	} else {
+		writeRecord(bw, '6', int64(n), 3, nil)
+	}
+	writeRecord(bw, termTypes[width], 0, width, nil)
+	return bw.Flush()
+}
+
+// writeRecord writes a record of the given type, address and data to w. The
+// address is width bytes wide.
+func writeRecord(w *bufio.Writer, typ byte, addr int64, width int, data []byte) {
+	rec := []byte{byte(width + len(data) + 1)}
+	for i := width - 1; i >= 0; i-- {
+		rec = append(rec, byte(addr>>uint(8*i)))
+	}
+	rec = append(rec, data...)
+	sum := byte(0)
+	for _, b := range rec {
+		sum += b
+	}
+	rec = append(rec, ^sum)
+	fmt.Fprintf(w, "S%c%X\n", typ, rec)
+}
@@ -0,0 +1,80 @@
+package srec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mewlang/asm/asm/x86"
+	"github.com/mewlang/asm/internal/asmtest"
+)
+
+func TestWrite(t *testing.T) {
+	golden := []struct {
+		src    string
+		header string
+		pad    bool
+		want   string
+	}{
+		// i=0
+		{
+			src:    "\torg 0x100\n\tret",
+			header: "",
+			pad:    false,
+			want: `S0030000FC
+S1040100C337
+S5030001FB
+S9030000FC
+`,
+		},
+		// i=1
+		{
+			src:    "\torg 0x1FFFC\n\tdb 1, 2, 3, 4, 5, 6, 7\n\tsection .data\n\tdb 9",
+			header: "test",
+			pad:    false,
+			want: `S00700007465737438
+S20B01FFFC01020304050607DC
+S20502000409EB
+S5030002FA
+S804000000FB
+`,
+		},
+		// i=2
+		{
+			src:    "\torg 0x1FFFC\n\tdb 1, 2, 3, 4, 5, 6, 7\n\tsection .data\n\tdb 9",
+			header: "test",
+			pad:    true,
+			want: `S00700007465737438
+S20D01FFFC010203040506070009D1
+S5030001FB
+S804000000FB
+`,
+		},
+		// i=3
+		{
+			src:    "\torg 0x80000000\n\tret",
+			header: "",
+			pad:    false,
+			want: `S0030000FC
+S30680000000C3B6
+S5030001FB
+S70500000000FA
+`,
+		},
+	}
+
+	for i, g := range golden {
+		obj, err := asmtest.Assemble(nil, x86.Encoder, g.src)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		buf := new(bytes.Buffer)
+		if err := Write(buf, obj, g.header, g.pad); err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if got := buf.String(); got != g.want {
+			t.Errorf("i=%d: output mismatch; expected %q, got %q", i, g.want, got)
+		}
+	}
+}
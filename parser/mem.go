@@ -0,0 +1,270 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/token"
+)
+
+// The memory operand grammar is shared by all architectures, and specifies the
+// address of a memory location as the sum of an optional base register, an
+// optional scaled or extended index register and an optional displacement.
+//
+//	MemoryOperand = "[" [ "rel" ] MemoryTerm { ( "+" | "-" | "," ) MemoryTerm } "]" |
+//	                [ Expression ] "(" Register ")" |
+//	                "(" Expression "," Register ")" .
+//
+// The bracketed form is used by x86, ARM and AArch64, the parenthesized base
+// register by MIPS and RISC-V, and the parenthesized index register by the
+// 6502. Memory operands are parsed by parseOperand; which also handles the
+// size keywords and the "!" suffix of pre-indexed memory operands.
+
+// memSizes maps from size keyword to the size in bits of memory locations.
+var memSizes = map[string]int{
+	"byte":  8,
+	"word":  16,
+	"dword": 32,
+	"qword": 64,
+}
+
+// indexOps is the set of shift and extend operators of index registers of
+// memory operands.
+var indexOps = map[string]bool{
+	"lsl":  true,
+	"uxtw": true,
+	"sxtw": true,
+	"sxtx": true,
+}
+
+// isBaseReg returns true if the next tokens are a parenthesized register, and
+// false otherwise. The disp parameter specifies whether the register follows a
+// displacement.
+//
+// If the parser has no architecture, the parenthesized identifier following a
+// displacement is a register; as is a parenthesized identifier with a "$"
+// prefix, such as ($sp). Other parenthesized identifiers are expressions.
+func (p *parser) isBaseReg(disp bool) bool {
+	if p.peek().Typ != token.LParen || p.peekN(2).Typ != token.RParen {
+		return false
+	}
+	_, ok := p.memBase(p.peekN(1), disp)
+	return ok
+}
+
+// parseBaseReg parses a parenthesized base register, preceded by the given
+// displacement; or nil if not present.
+//
+//	MemoryOperand = [ Expression ] "(" Register ")" .
+func (p *parser) parseBaseReg(disp ast.Arg) (mem *ast.Mem, err error) {
+	// Consume '('.
+	p.next()
+	reg, _ := p.memBase(p.next(), disp != nil)
+	// Consume ')'.
+	p.next()
+	return &ast.Mem{Base: reg, Disp: disp}, nil
+}
+
+// memBase returns the parenthesized base register denoted by the provided
+// identifier token and true if present, and false otherwise. If the parser has
+// no architecture, registers are represented as identifiers.
+func (p *parser) memBase(tok token.Token, disp bool) (reg ast.Arg, ok bool) {
+	if p.conf.Arch == nil {
+		if tok.Typ == token.Ident && (disp || strings.HasPrefix(tok.Val, "$")) {
+			return ast.Ident(tok.Val), true
+		}
+		return nil, false
+	}
+	return p.reg(tok)
+}
+
+// isIndexedIndirect returns true if the next tokens are a parenthesized
+// expression and register separated by a comma; such as (ptr, x), and false
+// otherwise.
+func (p *parser) isIndexedIndirect() bool {
+	if p.peek().Typ != token.LParen {
+		return false
+	}
+	depth := 0
+	for n := 0; ; n++ {
+		switch p.peekN(n).Typ {
+		case token.LParen:
+			depth++
+		case token.RParen:
+			depth--
+			if depth == 0 {
+				return false
+			}
+		case token.Comma:
+			if depth != 1 {
+				return false
+			}
+			_, ok := p.memIndex(p.peekN(n + 1))
+			return ok && p.peekN(n+2).Typ == token.RParen
+		case token.Newline, token.LineComment, token.EOF:
+			return false
+		}
+	}
+}
+
+// parseIndexedIndirect parses a parenthesized displacement and index register.
+// The memory operand specifies the location of a pointer; such as the (zp, x)
+// operand of the 6502.
+//
+//	MemoryOperand = "(" Expression "," Register ")" .
+func (p *parser) parseIndexedIndirect() (mem *ast.Mem, err error) {
+	// Consume '('.
+	p.next()
+	disp, err := p.parseExpr(1)
+	if err != nil {
+		return nil, err
+	}
+	// Consume ','.
+	p.next()
+	reg, _ := p.memIndex(p.next())
+	// Consume ')'.
+	p.next()
+	return &ast.Mem{Index: reg, Scale: 1, Disp: disp}, nil
+}
+
+// memIndex returns the index register of an indexed indirect memory operand
+// denoted by the provided identifier token and true if present, and false
+// otherwise. If the parser has no architecture, registers are represented as
+// identifiers.
+func (p *parser) memIndex(tok token.Token) (reg ast.Arg, ok bool) {
+	if p.conf.Arch == nil {
+		if tok.Typ == token.Ident {
+			return ast.Ident(tok.Val), true
+		}
+		return nil, false
+	}
+	return p.reg(tok)
+}
+
+// parseMem parses a memory operand.
+//
+//	MemoryOperand = "[" [ "rel" ] MemoryTerm { ( "+" | "-" | "," ) MemoryTerm } "]" .
+//	MemoryTerm    = Register [ "*" Expression | "," IndexOp [ [ "#" ] Expression ] ] |
+//	                [ "#" ] Expression .
+//	IndexOp       = "lsl" | "uxtw" | "sxtw" | "sxtx" .
+//
+// The shift amount of the lsl operator is required.
+func (p *parser) parseMem() (mem *ast.Mem, err error) {
+	// Consume '['.
+	p.next()
+	mem = new(ast.Mem)
+	if tok := p.peek(); tok.Typ == token.Ident && tok.Val == "rel" && p.peekN(1).Typ != token.RBrack {
+		p.next()
+		mem.Rel = true
+	}
+	op := token.Add
+	for {
+		tok := p.peek()
+		if reg, ok := p.memReg(tok); ok {
+			p.next()
+			if op == token.Sub {
+				return nil, p.errorf(tok, "parser.parseMem: unexpected negated register in memory operand")
+			}
+			scale, extend := 0, ""
+			switch {
+			case p.peek().Typ == token.Mul:
+				p.next()
+				stok := p.peek()
+				x, err := p.parseUnaryExpr()
+				if err != nil {
+					return nil, err
+				}
+				val, err := ast.Eval(x, nil)
+				if err != nil || (val != 1 && val != 2 && val != 4 && val != 8) {
+					return nil, p.errorf(stok, "parser.parseMem: invalid scale factor; expected 1, 2, 4 or 8")
+				}
+				scale = int(val)
+			case p.isIndexOp():
+				// Shifted or extended index register; e.g. [r0, r1, lsl #2]
+				p.next()
+				extend = p.next().Val
+				if p.peek().Typ == token.RBrack {
+					// Extended index register without shift amount; e.g.
+					// [x0, w1, sxtw]
+					if extend == "lsl" {
+						return nil, p.errorf(p.peek(), "parser.parseMem: missing shift amount of index register")
+					}
+					break
+				}
+				if p.peek().Typ == token.Hash {
+					p.next()
+				}
+				stok := p.peek()
+				x, err := p.parseUnaryExpr()
+				if err != nil {
+					return nil, err
+				}
+				val, err := ast.Eval(x, nil)
+				if err != nil || val < 0 || val > 3 {
+					return nil, p.errorf(stok, "parser.parseMem: invalid shift amount of index register; expected 0, 1, 2 or 3")
+				}
+				scale = 1 << uint(val)
+			}
+			switch {
+			case scale == 0 && extend == "" && mem.Base == nil:
+				mem.Base = reg
+			case mem.Index == nil:
+				if scale == 0 && extend == "" {
+					scale = 1
+				}
+				mem.Index, mem.Scale, mem.Extend = reg, scale, extend
+			default:
+				return nil, p.errorf(tok, "parser.parseMem: too many registers in memory operand")
+			}
+		} else {
+			// Parse the displacement term, which binds stronger than the
+			// addition operators separating the terms.
+			if tok.Typ == token.Hash {
+				p.next()
+			}
+			x, err := p.parseExpr(token.Add.Precedence() + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case mem.Disp != nil:
+				mem.Disp = &ast.BinaryExpr{X: mem.Disp, Op: op, Y: x}
+			case op == token.Sub:
+				mem.Disp = &ast.UnaryExpr{Op: op, X: x}
+			default:
+				mem.Disp = x
+			}
+		}
+		switch tok := p.next(); tok.Typ {
+		case token.RBrack:
+			return mem, nil
+		case token.Add, token.Sub:
+			op = tok.Typ
+		case token.Comma:
+			op = token.Add
+		default:
+			return nil, p.errorf(tok, "parser.parseMem: expected '+', '-', ',' or ']' in memory operand")
+		}
+	}
+}
+
+// isIndexOp returns true if the next tokens are a comma followed by a shift or
+// extend operator of an index register, and false otherwise.
+func (p *parser) isIndexOp() bool {
+	tok := p.peekN(1)
+	return p.peek().Typ == token.Comma && tok.Typ == token.Ident && indexOps[tok.Val]
+}
+
+// memReg returns the register of a memory term denoted by the provided
+// identifier token and true if present, and false otherwise. If the parser has
+// no architecture, an identifier followed by a shift or extend operator is an
+// index register.
+func (p *parser) memReg(tok token.Token) (reg ast.Arg, ok bool) {
+	if p.conf.Arch == nil {
+		if tok.Typ == token.Ident && p.peekN(1).Typ == token.Comma && indexOps[p.peekN(2).Val] {
+			return ast.Ident(tok.Val), true
+		}
+		return nil, false
+	}
+	return p.reg(tok)
+}
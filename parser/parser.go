@@ -1,3 +1,734 @@
 // Package parser implements syntactical parsing of assembly source code; the
 // output of which is an abstract syntax tree (AST).
 package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/lexer"
+	"github.com/mewlang/asm/token"
+)
+
+// An Error represents a syntax error which occurred at a given token.
+type Error struct {
+	// The position of the offending token; or an invalid position if no file set
+	// was provided to the parser.
+	Pos token.Position
+	// The offending token.
+	Tok token.Token
+	// The error message.
+	Msg string
+}
+
+func (e *Error) Error() string {
+	msg := e.Msg
+	if e.Tok.Typ != token.Error {
+		msg = fmt.Sprintf("%s; got %v", e.Msg, e.Tok)
+	}
+	if e.Pos.IsValid() {
+		return fmt.Sprintf("%v: %s", e.Pos, msg)
+	}
+	return msg
+}
+
+// A Config specifies the configuration of a parser.
+type Config struct {
+	// Arch is the architecture used to identify registers and to validate
+	// instructions; or nil to parse architecture independently, in which case
+	// registers are represented as identifiers and instructions are not
+	// validated.
+	Arch arch.Arch
+}
+
+// A parser parses a slice of tokens into an abstract syntax tree.
+type parser struct {
+	// The parser configuration.
+	conf *Config
+	// The file set used to report error positions; or nil if not present.
+	fset *token.FileSet
+	// The input tokens.
+	tokens []token.Token
+	// The index of the next token.
+	pos int
+	// The size in bits of floating-point literals; 32 in the values of 4-byte
+	// data directives, and 64 otherwise.
+	floatSize int
+}
+
+// Parse parses the provided tokens, as produced by lexer.Parse, and returns the
+// corresponding abstract syntax tree. The tokens are parsed architecture
+// independently.
+func Parse(tokens []token.Token) (prog *ast.Program, err error) {
+	return new(Config).Parse(tokens)
+}
+
+// ParseFile lexes and parses the provided source code and returns the
+// corresponding abstract syntax tree. The source code is added as a new file
+// with the given filename to the file set, which is used to report the
+// positions of syntax errors. The source code is parsed architecture
+// independently.
+func ParseFile(fset *token.FileSet, filename, src string) (prog *ast.Program, err error) {
+	return new(Config).ParseFile(fset, filename, src)
+}
+
+// Parse parses the provided tokens, as produced by lexer.Parse, and returns the
+// corresponding abstract syntax tree.
+func (conf *Config) Parse(tokens []token.Token) (prog *ast.Program, err error) {
+	p := &parser{
+		conf:   conf,
+		tokens: tokens,
+	}
+	return p.parseProgram()
+}
+
+// ParseFile lexes and parses the provided source code and returns the
+// corresponding abstract syntax tree. The source code is added as a new file
+// with the given filename to the file set, which is used to report the
+// positions of syntax errors.
+func (conf *Config) ParseFile(fset *token.FileSet, filename, src string) (prog *ast.Program, err error) {
+	p := &parser{
+		conf:   conf,
+		fset:   fset,
+		tokens: lexer.ParseFile(fset, filename, src),
+	}
+	return p.parseProgram()
+}
+
+// next consumes and returns the next token. It returns an EOF token if no more
+// tokens are available.
+func (p *parser) next() token.Token {
+	tok := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return tok
+}
+
+// peek returns but does not consume the next token.
+func (p *parser) peek() token.Token {
+	if p.pos >= len(p.tokens) {
+		return token.Token{Typ: token.EOF}
+	}
+	return p.tokens[p.pos]
+}
+
+// peekN returns but does not consume the token n positions after the next
+// token.
+func (p *parser) peekN(n int) token.Token {
+	if p.pos+n >= len(p.tokens) {
+		return token.Token{Typ: token.EOF}
+	}
+	return p.tokens[p.pos+n]
+}
+
+// errorf returns a syntax error located at the provided token.
+func (p *parser) errorf(tok token.Token, format string, args ...interface{}) error {
+	e := &Error{Tok: tok, Msg: tok.Val}
+	if tok.Typ != token.Error {
+		e.Msg = fmt.Sprintf(format, args...)
+	}
+	if p.fset != nil {
+		e.Pos = p.fset.Position(tok.Pos)
+	}
+	return e
+}
+
+// parseProgram parses a program.
+//
+//	Program = { Line } .
+func (p *parser) parseProgram() (prog *ast.Program, err error) {
+	prog = new(ast.Program)
+	for p.peek().Typ != token.EOF {
+		line, err := p.parseLine()
+		if err != nil {
+			return nil, err
+		}
+		if line.Label != nil || line.Node != nil || len(line.Comment) > 0 {
+			prog.Lines = append(prog.Lines, line)
+		}
+	}
+	return prog, nil
+}
+
+// parseLine parses a line, including its terminating newline.
+//
+//	Line = [ LabelDecl ] [ Instruction | Directive ] [ line_comment ] .
+func (p *parser) parseLine() (line *ast.Line, err error) {
+	line = &ast.Line{Pos: p.peek().Pos}
+
+	// Optional label declaration.
+	if p.peek().Typ == token.Ident && p.peekN(1).Typ == token.Colon {
+		line.Label, err = p.parseLabelDecl()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().Typ == token.Ident && p.peekN(1).Typ == token.Colon {
+			return nil, p.errorf(p.peek(), "parser.parseLine: more than one label declaration on line")
+		}
+	}
+
+	// Optional instruction or directive.
+	tok := p.peek()
+	if tok.Typ == token.Ident {
+		switch {
+		case isEqu(tok) && line.Label != nil:
+			// An equate directive may define the symbol of a label declaration;
+			// e.g. buf_len: equ 13
+			line.Node, err = p.parseEquDir(line.Label.Name)
+			line.Label = nil
+		case isEqu(tok):
+			return nil, p.errorf(tok, "parser.parseLine: missing symbol name of equate directive")
+		case isEqu(p.peekN(1)):
+			// e.g. buf_len equ 13
+			p.next()
+			if strings.HasPrefix(tok.Val, "$") {
+				return nil, p.errorf(tok, "parser.parseLine: invalid symbol name %q", tok.Val)
+			}
+			line.Node, err = p.parseEquDir(tok.Val)
+		case directives[tok.Val]:
+			line.Node, err = p.parseDirective()
+		default:
+			line.Node, err = p.parseInst()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Optional line comment.
+	if tok := p.peek(); tok.Typ == token.LineComment {
+		p.next()
+		line.Comment = tok.Val
+	}
+
+	// Terminating newline.
+	switch tok := p.next(); tok.Typ {
+	case token.Newline, token.EOF:
+	default:
+		return nil, p.errorf(tok, "parser.parseLine: expected newline or line comment at end of line")
+	}
+
+	if inst, ok := line.Node.(*ast.Inst); ok {
+		if err := p.validate(tok, inst); err != nil {
+			return nil, err
+		}
+	}
+	return line, nil
+}
+
+// parseLabelDecl parses a label declaration.
+//
+//	LabelDecl = Label ":" .
+//	Label     = [ "." ] identifier .
+func (p *parser) parseLabelDecl() (label *ast.LabelDecl, err error) {
+	tok := p.next()
+	if strings.HasPrefix(tok.Val, "$") {
+		return nil, p.errorf(tok, "parser.parseLabelDecl: invalid label name %q", tok.Val)
+	}
+	p.next() // ":"
+	return &ast.LabelDecl{Name: tok.Val}, nil
+}
+
+// directives is the set of directive keywords, except for "equ".
+var directives = map[string]bool{
+	"section": true,
+	"global":  true,
+	"extern":  true,
+	"align":   true,
+	"db":      true,
+	"dw":      true,
+	"dd":      true,
+	"dq":      true,
+	"org":     true,
+	"pool":    true,
+}
+
+// dataWidths is a map from data directive keyword to element width in bytes.
+var dataWidths = map[string]int{
+	"db": 1,
+	"dw": 2,
+	"dd": 4,
+	"dq": 8,
+}
+
+// isEqu returns true if tok is the "equ" keyword, and false otherwise.
+func isEqu(tok token.Token) bool {
+	return tok.Typ == token.Ident && tok.Val == "equ"
+}
+
+// parseDirective parses a directive, except for equate directives.
+//
+//	Directive  = SectionDir | GlobalDir | ExternDir | AlignDir | DataDir |
+//	             OrgDir | EquDir | PoolDir .
+//	SectionDir = "section" ( string_lit | Label ) .
+//	GlobalDir  = "global" identifier { "," identifier } .
+//	ExternDir  = "extern" identifier { "," identifier } .
+//	AlignDir   = "align" Expression .
+//	DataDir    = ( "db" | "dw" | "dd" | "dq" ) Expression { "," Expression } .
+//	OrgDir     = "org" Expression .
+//	PoolDir    = "pool" .
+func (p *parser) parseDirective() (dir ast.Directive, err error) {
+	keyword := p.next()
+	switch keyword.Val {
+	case "section":
+		tok := p.next()
+		switch {
+		case tok.Typ == token.String:
+			name, err := p.parseString(tok)
+			if err != nil {
+				return nil, err
+			}
+			return &ast.SectionDir{Name: string(name.(ast.String))}, nil
+		case tok.Typ == token.Ident && !strings.HasPrefix(tok.Val, "$"):
+			return &ast.SectionDir{Name: tok.Val}, nil
+		}
+		return nil, p.errorf(tok, "parser.parseDirective: expected section name")
+	case "global", "extern":
+		var names []string
+		for {
+			tok := p.next()
+			if tok.Typ != token.Ident || strings.HasPrefix(tok.Val, "$") || strings.HasPrefix(tok.Val, ".") {
+				return nil, p.errorf(tok, "parser.parseDirective: expected %s symbol name", keyword.Val)
+			}
+			names = append(names, tok.Val)
+			if p.peek().Typ != token.Comma {
+				break
+			}
+			p.next()
+		}
+		if keyword.Val == "extern" {
+			return &ast.ExternDir{Names: names}, nil
+		}
+		return &ast.GlobalDir{Names: names}, nil
+	case "align":
+		x, err := p.parseExpr(1)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.AlignDir{Align: x}, nil
+	case "org":
+		x, err := p.parseExpr(1)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.OrgDir{Addr: x}, nil
+	case "pool":
+		return &ast.PoolDir{}, nil
+	}
+
+	// Data directive. Floating-point literals of 4-byte elements are rounded
+	// directly to 32 bits.
+	dir = &ast.DataDir{Width: dataWidths[keyword.Val]}
+	if dir.(*ast.DataDir).Width == 4 {
+		p.floatSize = 32
+		defer func() { p.floatSize = 0 }()
+	}
+	for {
+		x, err := p.parseExpr(1)
+		if err != nil {
+			return nil, err
+		}
+		dir.(*ast.DataDir).Vals = append(dir.(*ast.DataDir).Vals, x)
+		if p.peek().Typ != token.Comma {
+			return dir, nil
+		}
+		p.next()
+	}
+}
+
+// parseEquDir parses an equate directive defining the named symbol. The
+// symbol name has already been consumed.
+//
+//	EquDir = identifier [ ":" ] "equ" Expression .
+func (p *parser) parseEquDir(name string) (dir *ast.EquDir, err error) {
+	p.next() // "equ"
+	x, err := p.parseExpr(1)
+	if err != nil {
+		return nil, err
+	}
+	return &ast.EquDir{Name: name, Val: x}, nil
+}
+
+// parseInst parses an instruction.
+//
+//	Instruction = Operator [ Operand { "," Operand } ] .
+//	Operator    = identifier .
+func (p *parser) parseInst() (inst *ast.Inst, err error) {
+	tok := p.next()
+	if strings.HasPrefix(tok.Val, "$") || strings.HasPrefix(tok.Val, ".") {
+		return nil, p.errorf(tok, "parser.parseInst: invalid operator %q", tok.Val)
+	}
+	inst = &ast.Inst{Op: ast.Op(tok.Val)}
+
+	// Operands.
+	switch p.peek().Typ {
+	case token.Newline, token.LineComment, token.EOF:
+		return inst, nil
+	}
+	for {
+		arg, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		inst.Args = append(inst.Args, arg)
+		if p.peek().Typ != token.Comma {
+			break
+		}
+		p.next()
+	}
+	return inst, nil
+}
+
+// validate validates the instruction using the architecture of the parser, if
+// any. Errors are located at the provided operator token of the instruction.
+func (p *parser) validate(tok token.Token, inst *ast.Inst) error {
+	if p.conf.Arch == nil {
+		return nil
+	}
+	if err := p.conf.Arch.Validate(inst); err != nil {
+		return p.errorf(tok, "parser.parseInst: %v", err)
+	}
+	return nil
+}
+
+// parseOperand parses an instruction operand.
+//
+//	Operand   = Register [ "!" ] | ShiftedRegister | RegisterList |
+//	            [ size_keyword ] MemoryOperand [ "!" ] |
+//	            "#" Expression [ "," Shift ] | "=" Expression | Expression .
+//	Register  = [ "$" ] identifier .
+//	size_keyword = "byte" | "word" | "dword" | "qword" .
+//
+// A size keyword is only recognized when followed by "[", and specifies the
+// size of the memory location.
+//
+// The precise definition of a Register is architecture specific. If the parser
+// has no architecture, registers are represented as identifiers.
+func (p *parser) parseOperand() (arg ast.Arg, err error) {
+	if tok := p.peek(); tok.Typ == token.Ident && memSizes[tok.Val] != 0 && p.peekN(1).Typ == token.LBrack {
+		// Memory operand with size keyword; e.g. dword [eax]
+		p.next()
+		mem, err := p.parseMem()
+		if err != nil {
+			return nil, err
+		}
+		mem.Size = memSizes[tok.Val]
+		return mem, nil
+	}
+	switch p.peek().Typ {
+	case token.LBrack:
+		mem, err := p.parseMem()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().Typ == token.Excl {
+			// Pre-indexed memory operand; e.g. [sp, #-16]!
+			p.next()
+			return &ast.Writeback{X: mem}, nil
+		}
+		return mem, nil
+	case token.LBrace:
+		return p.parseRegList()
+	case token.Hash:
+		p.next()
+		x, err := p.parseExpr(1)
+		if err != nil {
+			return nil, err
+		}
+		imm := &ast.Imm{X: x}
+		if p.isShift() {
+			// Shifted immediate; e.g. #1, lsl #12
+			return p.parseShift(imm)
+		}
+		return imm, nil
+	case token.Eq:
+		p.next()
+		x, err := p.parseExpr(1)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.Literal{X: x}, nil
+	}
+	if p.isBaseReg(false) {
+		return p.parseBaseReg(nil)
+	}
+	if p.isIndexedIndirect() {
+		return p.parseIndexedIndirect()
+	}
+	if tok := p.peek(); tok.Typ == token.Ident {
+		if reg, ok := p.reg(tok); ok {
+			p.next()
+			switch {
+			case p.peek().Typ == token.Excl:
+				p.next()
+				return &ast.Writeback{X: reg}, nil
+			case p.isShift():
+				return p.parseShift(reg)
+			}
+			return reg, nil
+		}
+		if strings.HasPrefix(tok.Val, "$") {
+			p.next()
+			if p.conf.Arch == nil {
+				return ast.Ident(tok.Val), nil
+			}
+			return nil, p.errorf(tok, "parser.parseOperand: unknown register %q", tok.Val)
+		}
+		if p.conf.Arch == nil && p.peekN(1).Typ == token.Comma && shiftOps[p.peekN(2).Val] {
+			// Register name of a shifted register operand.
+			p.next()
+			return p.parseShift(ast.Ident(tok.Val))
+		}
+	}
+	x, err := p.parseExpr(1)
+	if err != nil {
+		return nil, err
+	}
+	if p.isBaseReg(true) {
+		return p.parseBaseReg(x)
+	}
+	return x, nil
+}
+
+// shiftOps is the set of shift and extend operators of shifted register
+// operands.
+var shiftOps = map[string]bool{
+	"lsl":  true,
+	"lsr":  true,
+	"asr":  true,
+	"ror":  true,
+	"rrx":  true,
+	"uxtb": true,
+	"uxth": true,
+	"uxtw": true,
+	"uxtx": true,
+	"sxtb": true,
+	"sxth": true,
+	"sxtw": true,
+	"sxtx": true,
+}
+
+// isShift returns true if the next tokens are a comma followed by a shift
+// operator, and false otherwise.
+func (p *parser) isShift() bool {
+	tok := p.peekN(1)
+	return p.peek().Typ == token.Comma && tok.Typ == token.Ident && shiftOps[tok.Val]
+}
+
+// parseShift parses the shift of a shifted register or immediate operand. The
+// register or immediate has already been consumed.
+//
+//	ShiftedRegister = Register "," Shift .
+//	Shift           = ShiftOp [ [ "#" ] Expression ] .
+//	ShiftOp         = "lsl" | "lsr" | "asr" | "ror" | "rrx" |
+//	                  "uxtb" | "uxth" | "uxtw" | "uxtx" |
+//	                  "sxtb" | "sxth" | "sxtw" | "sxtx" .
+func (p *parser) parseShift(x ast.Arg) (shift *ast.ShiftedReg, err error) {
+	// Consume ','.
+	p.next()
+	shift = &ast.ShiftedReg{X: x, Op: p.next().Val}
+	switch p.peek().Typ {
+	case token.Comma, token.RBrack, token.LineComment, token.Newline, token.EOF:
+		// Shift without amount; e.g. rrx.
+		return shift, nil
+	case token.Hash:
+		p.next()
+	}
+	shift.Amount, err = p.parseExpr(1)
+	if err != nil {
+		return nil, err
+	}
+	return shift, nil
+}
+
+// parseRegList parses a register list. Register ranges are expanded if the
+// parser has an architecture.
+//
+//	RegisterList  = "{" RegisterRange { "," RegisterRange } "}" .
+//	RegisterRange = Register [ "-" Register ] .
+func (p *parser) parseRegList() (list *ast.RegList, err error) {
+	// Consume '{'.
+	p.next()
+	list = new(ast.RegList)
+	for {
+		tok := p.peek()
+		if p.conf.Arch == nil {
+			x, err := p.parseExpr(1)
+			if err != nil {
+				return nil, err
+			}
+			list.Regs = append(list.Regs, x)
+		} else {
+			lo, ok := p.reg(tok)
+			if !ok {
+				return nil, p.errorf(tok, "parser.parseRegList: expected register in register list")
+			}
+			p.next()
+			hi := lo
+			if p.peek().Typ == token.Sub {
+				p.next()
+				htok := p.next()
+				hi, ok = p.reg(htok)
+				if !ok {
+					return nil, p.errorf(htok, "parser.parseRegList: expected register at end of register range")
+				}
+				if hi < lo || p.conf.Arch.RegClass(lo) != p.conf.Arch.RegClass(hi) {
+					return nil, p.errorf(htok, "parser.parseRegList: invalid register range %s-%s", tok.Val, htok.Val)
+				}
+			}
+			for reg := lo; reg <= hi; reg++ {
+				list.Regs = append(list.Regs, reg)
+			}
+		}
+		switch tok := p.next(); tok.Typ {
+		case token.RBrace:
+			return list, nil
+		case token.Comma:
+		default:
+			return nil, p.errorf(tok, "parser.parseRegList: expected ',' or '}' in register list")
+		}
+	}
+}
+
+// reg returns the register denoted by the provided identifier token and true
+// if present, and false otherwise.
+func (p *parser) reg(tok token.Token) (reg ast.Reg, ok bool) {
+	if p.conf.Arch == nil {
+		return 0, false
+	}
+	return p.conf.Arch.Reg(tok.Val)
+}
+
+// parseExpr parses an expression whose binary operators have a precedence of
+// at least prec1.
+//
+//	Expression = UnaryExpr | Expression binary_op Expression .
+func (p *parser) parseExpr(prec1 int) (x ast.Arg, err error) {
+	x, err = p.parseUnaryExpr()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek().Typ
+		prec := op.Precedence()
+		if prec < prec1 {
+			return x, nil
+		}
+		p.next()
+		y, err := p.parseExpr(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &ast.BinaryExpr{X: x, Op: op, Y: y}
+	}
+}
+
+// parseUnaryExpr parses a unary expression.
+//
+//	UnaryExpr = PrimaryExpr | unary_op UnaryExpr .
+func (p *parser) parseUnaryExpr() (x ast.Arg, err error) {
+	switch op := p.peek().Typ; op {
+	case token.Add, token.Sub, token.Not:
+		p.next()
+		x, err = p.parseUnaryExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &ast.UnaryExpr{Op: op, X: x}, nil
+	}
+	return p.parsePrimaryExpr()
+}
+
+// parsePrimaryExpr parses a primary expression.
+//
+//	PrimaryExpr = Label | Immediate | "(" Expression ")" .
+//	Immediate   = int_lit | float_lit | char_lit | string_lit | raw_string_lit .
+func (p *parser) parsePrimaryExpr() (x ast.Arg, err error) {
+	tok := p.next()
+	switch tok.Typ {
+	case token.Ident:
+		if _, ok := p.reg(tok); ok || strings.HasPrefix(tok.Val, "$") {
+			return nil, p.errorf(tok, "parser.parsePrimaryExpr: unexpected register in expression")
+		}
+		return ast.Ident(tok.Val), nil
+	case token.Int:
+		return p.parseInt(tok)
+	case token.Float:
+		return p.parseFloat(tok)
+	case token.Char:
+		return p.parseChar(tok)
+	case token.String:
+		return p.parseString(tok)
+	case token.LParen:
+		x, err = p.parseExpr(1)
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.next(); tok.Typ != token.RParen {
+			return nil, p.errorf(tok, "parser.parsePrimaryExpr: expected ')' at end of parenthesized expression")
+		}
+		return &ast.ParenExpr{X: x}, nil
+	default:
+		return nil, p.errorf(tok, "parser.parsePrimaryExpr: expected register, label, immediate or expression")
+	}
+}
+
+// parseInt parses an integer literal. Values which fit in a 64-bit signed
+// integer are represented by ast.Int; remaining values which fit in a 64-bit
+// unsigned integer are represented by ast.Uint.
+func (p *parser) parseInt(tok token.Token) (arg ast.Arg, err error) {
+	x, err := strconv.ParseInt(tok.Val, 0, 64)
+	if err == nil {
+		return ast.Int(x), nil
+	}
+	if x, err := strconv.ParseUint(strings.TrimPrefix(tok.Val, "+"), 0, 64); err == nil {
+		return ast.Uint(x), nil
+	}
+	if err.(*strconv.NumError).Err == strconv.ErrRange {
+		return nil, p.errorf(tok, "parser.parseInt: integer literal %q out of range", tok.Val)
+	}
+	return nil, p.errorf(tok, "parser.parseInt: invalid integer literal %q", tok.Val)
+}
+
+// parseFloat parses a floating-point literal, rounded to the size of
+// floating-point literals of the current context.
+func (p *parser) parseFloat(tok token.Token) (arg ast.Arg, err error) {
+	size := 64
+	if p.floatSize != 0 {
+		size = p.floatSize
+	}
+	x, err := strconv.ParseFloat(tok.Val, size)
+	if err != nil {
+		if err.(*strconv.NumError).Err == strconv.ErrRange {
+			return nil, p.errorf(tok, "parser.parseFloat: floating-point literal %q overflows %d-bit floating-point value", tok.Val, size)
+		}
+		return nil, p.errorf(tok, "parser.parseFloat: invalid floating-point literal %q", tok.Val)
+	}
+	return ast.Float(x), nil
+}
+
+// parseChar parses a character literal.
+func (p *parser) parseChar(tok token.Token) (arg ast.Arg, err error) {
+	s := tok.Val[1 : len(tok.Val)-1]
+	r, _, tail, err := strconv.UnquoteChar(s, '\'')
+	if err != nil || len(tail) > 0 || r > 0xFF {
+		return nil, p.errorf(tok, "parser.parseChar: invalid character literal %q", tok.Val)
+	}
+	return ast.Int(r), nil
+}
+
+// parseString parses an interpreted or a raw string literal.
+func (p *parser) parseString(tok token.Token) (arg ast.Arg, err error) {
+	if strings.HasPrefix(tok.Val, "`") {
+		return ast.String(tok.Val[1 : len(tok.Val)-1]), nil
+	}
+	s, err := strconv.Unquote(tok.Val)
+	if err != nil {
+		return nil, p.errorf(tok, "parser.parseString: invalid string literal %q", tok.Val)
+	}
+	return ast.String(s), nil
+}
@@ -0,0 +1,340 @@
+package parser
+
+import (
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/mewlang/asm/arch"
+	"github.com/mewlang/asm/arch/arm"
+	"github.com/mewlang/asm/arch/arm64"
+	"github.com/mewlang/asm/arch/mips"
+	"github.com/mewlang/asm/arch/mos6502"
+	"github.com/mewlang/asm/arch/riscv"
+	"github.com/mewlang/asm/arch/x86"
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/lexer"
+	"github.com/mewlang/asm/token"
+)
+
+func TestParse(t *testing.T) {
+	golden := []struct {
+		path string
+		prog *ast.Program
+	}{
+		// i=0
+		{
+			path: "../testdata/hello_x86.asm",
+			prog: &ast.Program{
+				Lines: []*ast.Line{
+					{Comment: "; === [ text section ] ========================================================="},
+					{Node: &ast.SectionDir{Name: ".text"}},
+					{Node: &ast.GlobalDir{Names: []string{"_start"}}},
+					{Label: &ast.LabelDecl{Name: "_start"}},
+					{Comment: "; write(1, \"Hello world!\\n\", 13)"},
+					{Node: &ast.Inst{Op: "mov", Args: []ast.Arg{ast.Ident("eax"), ast.Int(4)}}, Comment: "; sys_write"},
+					{Node: &ast.Inst{Op: "mov", Args: []ast.Arg{ast.Ident("ebx"), ast.Int(1)}}, Comment: ";    fd: stdout"},
+					{Node: &ast.Inst{Op: "mov", Args: []ast.Arg{ast.Ident("ecx"), ast.Ident("hello")}}, Comment: ";    buf: str"},
+					{Node: &ast.Inst{Op: "mov", Args: []ast.Arg{ast.Ident("edx"), ast.Int(13)}}, Comment: ";    len: len(str)"},
+					{Node: &ast.Inst{Op: "int", Args: []ast.Arg{ast.Int(0x80)}}, Comment: "; syscall"},
+					{Comment: "; exit(0)"},
+					{Node: &ast.Inst{Op: "mov", Args: []ast.Arg{ast.Ident("eax"), ast.Int(1)}}, Comment: "; sys_exit"},
+					{Node: &ast.Inst{Op: "mov", Args: []ast.Arg{ast.Ident("ebx"), ast.Int(0)}}, Comment: ";    status = 0"},
+					{Node: &ast.Inst{Op: "int", Args: []ast.Arg{ast.Int(0x80)}}, Comment: "; syscall"},
+					{Comment: "; === [ rdata section ] ========================================================"},
+					{Node: &ast.SectionDir{Name: ".rdata"}},
+					{Label: &ast.LabelDecl{Name: "hello"}, Node: &ast.DataDir{Width: 1, Vals: []ast.Arg{ast.String("Hello world"), ast.Int('!'), ast.Int(10)}}},
+				},
+			},
+		},
+	}
+
+	for i, g := range golden {
+		buf, err := ioutil.ReadFile(g.path)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		prog, err := Parse(lexer.Parse(string(buf)))
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if len(prog.Lines) != len(g.prog.Lines) {
+			t.Errorf("i=%d: line count mismatch; expected %d, got %d", i, len(g.prog.Lines), len(prog.Lines))
+			continue
+		}
+		for j, want := range g.prog.Lines {
+			got := prog.Lines[j]
+			got.Pos = token.NoPos
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("i=%d, j=%d: expected %#v, got %#v", i, j, want, got)
+			}
+		}
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	golden := []struct {
+		arch  arch.Arch
+		input string
+		args  []ast.Arg
+	}{
+		// i=0
+		{arch: mips.Arch, input: "ori $t0, $zero, 16", args: []ast.Arg{mips.T0, mips.Zero, ast.Int(16)}},
+		// i=1
+		{arch: mips.Arch, input: "addi $r31, $sp, -0x10", args: []ast.Arg{mips.RA, mips.SP, ast.Int(-16)}},
+		// i=2
+		{arch: mips.Arch, input: "li $a0, 0xFFFFFFFFFFFFFFFF", args: []ast.Arg{mips.A0, ast.Uint(0xFFFFFFFFFFFFFFFF)}},
+		// i=3
+		{arch: mips.Arch, input: "li $a0, 0b101", args: []ast.Arg{mips.A0, ast.Int(5)}},
+		// i=4
+		{arch: mips.Arch, input: "li $a0, 017", args: []ast.Arg{mips.A0, ast.Int(15)}},
+		// i=5
+		{input: `foo '\n', '\x41', '\101', "a\tb", ` + "`raw\\n`", args: []ast.Arg{ast.Int('\n'), ast.Int('A'), ast.Int('A'), ast.String("a\tb"), ast.String(`raw\n`)}},
+		// i=6
+		{input: "j .loop", args: []ast.Arg{ast.Ident(".loop")}},
+		// i=7
+		{arch: mips.Arch, input: "li $a0, buf_end - buf", args: []ast.Arg{mips.A0, &ast.BinaryExpr{X: ast.Ident("buf_end"), Op: token.Sub, Y: ast.Ident("buf")}}},
+		// i=8
+		{arch: mips.Arch, input: "li $a0, (1 << 12) | flags", args: []ast.Arg{mips.A0, &ast.BinaryExpr{X: &ast.ParenExpr{X: &ast.BinaryExpr{X: ast.Int(1), Op: token.Shl, Y: ast.Int(12)}}, Op: token.Or, Y: ast.Ident("flags")}}},
+		// i=9
+		{arch: mips.Arch, input: "li $a0, 1 + 2 * 3 - 4", args: []ast.Arg{mips.A0, &ast.BinaryExpr{X: &ast.BinaryExpr{X: ast.Int(1), Op: token.Add, Y: &ast.BinaryExpr{X: ast.Int(2), Op: token.Mul, Y: ast.Int(3)}}, Op: token.Sub, Y: ast.Int(4)}}},
+		// i=10
+		{arch: mips.Arch, input: "li $a0, ~-x", args: []ast.Arg{mips.A0, &ast.UnaryExpr{Op: token.Not, X: &ast.UnaryExpr{Op: token.Sub, X: ast.Ident("x")}}}},
+		// i=11
+		{input: "foo 1.5e3, -0x1.8p1, -(.5)", args: []ast.Arg{ast.Float(1500), ast.Float(-3), &ast.UnaryExpr{Op: token.Sub, X: &ast.ParenExpr{X: ast.Float(0.5)}}}},
+		// i=12
+		{input: "move $t0, $t1", args: []ast.Arg{ast.Ident("$t0"), ast.Ident("$t1")}},
+		// i=13
+		{arch: x86.Arch, input: "mov eax, [ebx+ecx*4-8]", args: []ast.Arg{x86.EAX, &ast.Mem{Base: x86.EBX, Index: x86.ECX, Scale: 4, Disp: &ast.UnaryExpr{Op: token.Sub, X: ast.Int(8)}}}},
+		// i=14
+		{arch: x86.Arch, input: "mov [esi*2 + buf + 4], al", args: []ast.Arg{&ast.Mem{Index: x86.ESI, Scale: 2, Disp: &ast.BinaryExpr{X: ast.Ident("buf"), Op: token.Add, Y: ast.Int(4)}}, x86.AL}},
+		// i=15
+		{arch: x86.Arch64, input: "lea rsi, [rel hello]", args: []ast.Arg{x86.RSI, &ast.Mem{Disp: ast.Ident("hello"), Rel: true}}},
+		// i=16
+		{arch: x86.Arch64, input: "mov rax, [r12 + r13]", args: []ast.Arg{x86.RAX, &ast.Mem{Base: x86.R12, Index: x86.R13, Scale: 1}}},
+		// i=17
+		{arch: riscv.Arch, input: "lw a0, 8(sp)", args: []ast.Arg{riscv.A0, &ast.Mem{Base: riscv.SP, Disp: ast.Int(8)}}},
+		// i=18
+		{arch: riscv.Arch, input: "sw x1, -4(fp)", args: []ast.Arg{riscv.RA, &ast.Mem{Base: riscv.S0, Disp: ast.Int(-4)}}},
+		// i=19
+		{arch: riscv.Arch64, input: "lr.d.aqrl a0, (a1)", args: []ast.Arg{riscv.A0, &ast.Mem{Base: riscv.A1}}},
+		// i=20
+		{arch: riscv.Arch, input: "lw a0, msg+4(gp)", args: []ast.Arg{riscv.A0, &ast.Mem{Base: riscv.GP, Disp: &ast.BinaryExpr{X: ast.Ident("msg"), Op: token.Add, Y: ast.Int(4)}}}},
+		// i=21
+		{arch: arm.Arch, input: "stmdb sp!, {r4-r7, lr}", args: []ast.Arg{&ast.Writeback{X: arm.SP}, &ast.RegList{Regs: []ast.Arg{arm.R4, arm.R5, arm.R6, arm.R7, arm.LR}}}},
+		// i=22
+		{arch: arm.Arch, input: "add r0, r1, r2, lsl #3", args: []ast.Arg{arm.R0, arm.R1, &ast.ShiftedReg{X: arm.R2, Op: "lsl", Amount: ast.Int(3)}}},
+		// i=23
+		{arch: arm.Arch, input: "mov r0, r1, rrx", args: []ast.Arg{arm.R0, &ast.ShiftedReg{X: arm.R1, Op: "rrx"}}},
+		// i=24
+		{arch: arm.Arch, input: "mov r0, #-1", args: []ast.Arg{arm.R0, &ast.Imm{X: ast.Int(-1)}}},
+		// i=25
+		{arch: arm.Arch, input: "ldr r0, =msg + 4", args: []ast.Arg{arm.R0, &ast.Literal{X: &ast.BinaryExpr{X: ast.Ident("msg"), Op: token.Add, Y: ast.Int(4)}}}},
+		// i=26
+		{arch: arm.Arch, input: "ldr r0, [r1, #-4]", args: []ast.Arg{arm.R0, &ast.Mem{Base: arm.R1, Disp: ast.Int(-4)}}},
+		// i=27
+		{arch: arm.Arch, input: "ldr r0, [r1, r2, lsl #2]", args: []ast.Arg{arm.R0, &ast.Mem{Base: arm.R1, Index: arm.R2, Scale: 4, Extend: "lsl"}}},
+		// i=28
+		{input: "push {r4, lr}", args: []ast.Arg{&ast.RegList{Regs: []ast.Arg{ast.Ident("r4"), ast.Ident("lr")}}}},
+		// i=29
+		{arch: arm64.Arch, input: "ldr x0, [x1, #16]!", args: []ast.Arg{arm64.X0, &ast.Writeback{X: &ast.Mem{Base: arm64.X1, Disp: ast.Int(16)}}}},
+		// i=30
+		{arch: arm64.Arch, input: "ldr x0, [x1], #8", args: []ast.Arg{arm64.X0, &ast.Mem{Base: arm64.X1}, &ast.Imm{X: ast.Int(8)}}},
+		// i=31
+		{arch: arm64.Arch, input: "add x0, x1, #1, lsl #12", args: []ast.Arg{arm64.X0, arm64.X1, &ast.ShiftedReg{X: &ast.Imm{X: ast.Int(1)}, Op: "lsl", Amount: ast.Int(12)}}},
+		// i=32
+		{arch: arm64.Arch, input: "add x0, x1, w2, sxtw", args: []ast.Arg{arm64.X0, arm64.X1, &ast.ShiftedReg{X: arm64.W0 + 2, Op: "sxtw"}}},
+		// i=33
+		{arch: arm64.Arch, input: "ldr w0, [x1, w2, sxtw #2]", args: []ast.Arg{arm64.W0, &ast.Mem{Base: arm64.X1, Index: arm64.W0 + 2, Scale: 4, Extend: "sxtw"}}},
+		// i=34
+		{arch: arm64.Arch, input: "ldrb w0, [sp, w2, uxtw]", args: []ast.Arg{arm64.W0, &ast.Mem{Base: arm64.SP, Index: arm64.W0 + 2, Extend: "uxtw"}}},
+		// i=35
+		{arch: mos6502.Arch, input: "lda (ptr + 2, X)", args: []ast.Arg{&ast.Mem{Index: mos6502.X, Scale: 1, Disp: &ast.BinaryExpr{X: ast.Ident("ptr"), Op: token.Add, Y: ast.Int(2)}}}},
+		// i=36
+		{arch: mos6502.Arch, input: "lda (ptr), y", args: []ast.Arg{&ast.ParenExpr{X: ast.Ident("ptr")}, mos6502.Y}},
+		// i=37
+		{arch: mos6502.Arch, input: "sta (1 << 4) + 1, x", args: []ast.Arg{&ast.BinaryExpr{X: &ast.ParenExpr{X: &ast.BinaryExpr{X: ast.Int(1), Op: token.Shl, Y: ast.Int(4)}}, Op: token.Add, Y: ast.Int(1)}, mos6502.X}},
+		// i=38
+		{arch: x86.Arch, input: "inc dword [eax]", args: []ast.Arg{&ast.Mem{Base: x86.EAX, Size: 32}}},
+		// i=39
+		{arch: x86.Arch, input: "mov byte [esi*2 + buf], 1", args: []ast.Arg{&ast.Mem{Index: x86.ESI, Scale: 2, Disp: ast.Ident("buf"), Size: 8}, ast.Int(1)}},
+		// i=40
+		{arch: x86.Arch64, input: "push qword [rel ptr]", args: []ast.Arg{&ast.Mem{Disp: ast.Ident("ptr"), Rel: true, Size: 64}}},
+		// i=41
+		{input: "foo word, word [8]", args: []ast.Arg{ast.Ident("word"), &ast.Mem{Disp: ast.Int(8), Size: 16}}},
+		// i=42
+		{input: "lw $t0, 8($sp)", args: []ast.Arg{ast.Ident("$t0"), &ast.Mem{Base: ast.Ident("$sp"), Disp: ast.Int(8)}}},
+		// i=43
+		{input: "sw $ra, ($sp)", args: []ast.Arg{ast.Ident("$ra"), &ast.Mem{Base: ast.Ident("$sp")}}},
+		// i=44
+		{input: "lw a0, msg-4(gp)", args: []ast.Arg{ast.Ident("a0"), &ast.Mem{Base: ast.Ident("gp"), Disp: &ast.BinaryExpr{X: ast.Ident("msg"), Op: token.Sub, Y: ast.Int(4)}}}},
+		// i=45
+		{input: "foo (x)", args: []ast.Arg{&ast.ParenExpr{X: ast.Ident("x")}}},
+		// i=46
+		{input: "lda (ptr, x)", args: []ast.Arg{&ast.Mem{Index: ast.Ident("x"), Scale: 1, Disp: ast.Ident("ptr")}}},
+	}
+
+	for i, g := range golden {
+		conf := &Config{Arch: g.arch}
+		prog, err := conf.Parse(lexer.Parse(g.input))
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		inst := prog.Lines[0].Node.(*ast.Inst)
+		if !reflect.DeepEqual(inst.Args, g.args) {
+			t.Errorf("i=%d: expected %#v, got %#v", i, g.args, inst.Args)
+		}
+	}
+}
+
+func TestParseDirective(t *testing.T) {
+	golden := []struct {
+		input string
+		line  *ast.Line
+	}{
+		// i=0
+		{input: "section .bss", line: &ast.Line{Node: &ast.SectionDir{Name: ".bss"}}},
+		// i=1
+		{input: "global _start, main", line: &ast.Line{Node: &ast.GlobalDir{Names: []string{"_start", "main"}}}},
+		// i=2
+		{input: "align 0x80", line: &ast.Line{Node: &ast.AlignDir{Align: ast.Int(0x80)}}},
+		// i=3
+		{input: "org 0x7C00", line: &ast.Line{Node: &ast.OrgDir{Addr: ast.Int(0x7C00)}}},
+		// i=4
+		{input: "table: dw 1, 2 * 3", line: &ast.Line{Label: &ast.LabelDecl{Name: "table"}, Node: &ast.DataDir{Width: 2, Vals: []ast.Arg{ast.Int(1), &ast.BinaryExpr{X: ast.Int(2), Op: token.Mul, Y: ast.Int(3)}}}}},
+		// i=5
+		{input: "coeffs: dd 1.5, -0.25", line: &ast.Line{Label: &ast.LabelDecl{Name: "coeffs"}, Node: &ast.DataDir{Width: 4, Vals: []ast.Arg{ast.Float(1.5), ast.Float(-0.25)}}}},
+		// i=6
+		{input: "dq hello", line: &ast.Line{Node: &ast.DataDir{Width: 8, Vals: []ast.Arg{ast.Ident("hello")}}}},
+		// i=7
+		{input: "buf_len equ buf_end - buf", line: &ast.Line{Node: &ast.EquDir{Name: "buf_len", Val: &ast.BinaryExpr{X: ast.Ident("buf_end"), Op: token.Sub, Y: ast.Ident("buf")}}}},
+		// i=8
+		{input: "len: equ 13 ; length", line: &ast.Line{Node: &ast.EquDir{Name: "len", Val: ast.Int(13)}, Comment: "; length"}},
+		// i=9
+		{input: "pool", line: &ast.Line{Node: &ast.PoolDir{}}},
+		// i=10
+		{input: "extern printf, exit", line: &ast.Line{Node: &ast.ExternDir{Names: []string{"printf", "exit"}}}},
+		// i=11
+		{input: "dd 3.4028235e38, 1.00000005960464477626", line: &ast.Line{Node: &ast.DataDir{Width: 4, Vals: []ast.Arg{ast.Float(math.MaxFloat32), ast.Float(1 + 0x1p-23)}}}},
+		// i=12
+		{input: "dq 1.00000005960464477626", line: &ast.Line{Node: &ast.DataDir{Width: 8, Vals: []ast.Arg{ast.Float(1 + 0x1p-24)}}}},
+	}
+
+	for i, g := range golden {
+		prog, err := Parse(lexer.Parse(g.input))
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		got := prog.Lines[0]
+		got.Pos = token.NoPos
+		if !reflect.DeepEqual(got, g.line) {
+			t.Errorf("i=%d: expected %#v, got %#v", i, g.line, got)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	golden := []struct {
+		input string
+		err   string
+	}{
+		// i=0
+		{input: "beq $t0, $zero done", err: `parser.parseLine: expected newline or line comment at end of line; got [identifier]: "done"`},
+		// i=1
+		{input: "li $a0, 0x10000000000000000", err: `parser.parseInt: integer literal "0x10000000000000000" out of range; got [integer literal]: "0x10000000000000000"`},
+		// i=2
+		{input: "li $foo, 1", err: `parser.parseOperand: unknown register "$foo"; got [identifier]: "$foo"`},
+		// i=3
+		{input: "add $t0,, $t1", err: `parser.parsePrimaryExpr: expected register, label, immediate or expression; got [,]: ","`},
+		// i=4
+		{input: "foo: bar: nop", err: `parser.parseLine: more than one label declaration on line; got [identifier]: "bar"`},
+		// i=5
+		{input: "mov eax, @", err: "lexer.lexLine: unexpected rune '@' at beginning of token"},
+		// i=6
+		{input: "li $a0, (1 + 2", err: "parser.parsePrimaryExpr: expected ')' at end of parenthesized expression; got EOF"},
+		// i=7
+		{input: "li $a0, 1 + $t0", err: `parser.parsePrimaryExpr: unexpected register in expression; got [identifier]: "$t0"`},
+		// i=8
+		{input: "section 42", err: `parser.parseDirective: expected section name; got [integer literal]: "42"`},
+		// i=9
+		{input: "global .local", err: `parser.parseDirective: expected global symbol name; got [identifier]: ".local"`},
+		// i=10
+		{input: "equ 42", err: `parser.parseLine: missing symbol name of equate directive; got [identifier]: "equ"`},
+		// i=11
+		{input: "frob $t0", err: `parser.parseInst: arch.OpTable.Validate: unknown mips instruction "frob"; got [identifier]: "frob"`},
+		// i=12
+		{input: "add $t0, $t1, 1", err: `parser.parseInst: arch.OpTable.Validate: invalid operands of "add"; expected "gpr, gpr, gpr"; got [identifier]: "add"`},
+		// i=13
+		{input: "jalr 1", err: `parser.parseInst: arch.OpTable.Validate: invalid operands of "jalr"; expected "gpr" or "gpr, gpr"; got [identifier]: "jalr"`},
+		// i=14
+		{input: "foo {$t1-$t0}", err: `parser.parseRegList: invalid register range $t1-$t0; got [identifier]: "$t0"`},
+		// i=15
+		{input: "foo {$t0-1}", err: `parser.parseRegList: expected register at end of register range; got [integer literal]: "1"`},
+		// i=16
+		{input: "foo {$t0 $t1}", err: `parser.parseRegList: expected ',' or '}' in register list; got [identifier]: "$t1"`},
+		// i=17
+		{input: "lw $t0, [$t1, $t2, lsl #4]", err: `parser.parseMem: invalid shift amount of index register; expected 0, 1, 2 or 3; got [integer literal]: "4"`},
+		// i=18
+		{input: "lw $t0, [$t1, $t2, lsl]", err: `parser.parseMem: missing shift amount of index register; got []]: "]"`},
+		// i=19
+		{input: "dd 3.5e38", err: `parser.parseFloat: floating-point literal "3.5e38" overflows 32-bit floating-point value; got [floating-point literal]: "3.5e38"`},
+	}
+
+	conf := &Config{Arch: mips.Arch}
+	for i, g := range golden {
+		_, err := conf.Parse(lexer.Parse(g.input))
+		if err == nil {
+			t.Errorf("i=%d: expected error %q, got nil", i, g.err)
+			continue
+		}
+		if err.Error() != g.err {
+			t.Errorf("i=%d: expected error %q, got %q", i, g.err, err)
+		}
+	}
+}
+
+func TestParseFilePos(t *testing.T) {
+	const input = "; foo\n\nloop:\n\tj loop\n"
+	fset := token.NewFileSet()
+	prog, err := ParseFile(fset, "foo.asm", input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"foo.asm:1:1", "foo.asm:3:1", "foo.asm:4:2"}
+	if len(prog.Lines) != len(want) {
+		t.Fatalf("line count mismatch; expected %d, got %d", len(want), len(prog.Lines))
+	}
+	for i, line := range prog.Lines {
+		if got := fset.Position(line.Pos).String(); got != want[i] {
+			t.Errorf("i=%d: position mismatch; expected %s, got %s", i, want[i], got)
+		}
+	}
+}
+
+func TestParseFileError(t *testing.T) {
+	golden := []struct {
+		input string
+		err   string
+	}{
+		// i=0
+		{input: "loop:\n\tbeq $t0, $zero done\n", err: `foo.asm:2:17: parser.parseLine: expected newline or line comment at end of line; got [identifier]: "done"`},
+		// i=1
+		{input: "\n\n\tmov eax, @", err: "foo.asm:3:11: lexer.lexLine: unexpected rune '@' at beginning of token"},
+	}
+
+	for i, g := range golden {
+		_, err := ParseFile(token.NewFileSet(), "foo.asm", g.input)
+		if err == nil {
+			t.Errorf("i=%d: expected error %q, got nil", i, g.err)
+			continue
+		}
+		if err.Error() != g.err {
+			t.Errorf("i=%d: expected error %q, got %q", i, g.err, err)
+		}
+	}
+}
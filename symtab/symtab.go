@@ -0,0 +1,562 @@
+// Package symtab implements the symbol table of the assembler, which maps labels
+// to their sections and addresses, and resolves the references to symbols whose
+// addresses are not known until link time.
+//
+// A program is laid out by making a number of passes over it. The symbols
+// defined by a pass resolve the forward references of the next pass, and the
+// start addresses of sections are given by the sizes of the sections of the
+// previous pass. The layout is stable once neither the symbols nor the section
+// addresses change; which allows instructions whose size depends on the
+// distance to their operands, such as short and near branches, to be relaxed.
+//
+// The addresses of sections of relocatable objects are assigned at link time.
+// While laying out a relocatable object, each section is located at a distinct
+// provisional address, far from the other sections and from the provisional
+// address of external symbols. The fields of machine code which refer to such
+// addresses are reported by the instruction encoders as relocation records, and
+// hold zero; only references relative to the address of the referencing
+// instruction within a section are resolved by the assembler.
+package symtab
+
+import (
+	"fmt"
+
+	"github.com/mewlang/asm/ast"
+)
+
+// Kind specifies the kind of a symbol.
+type Kind uint8
+
+// Symbol kinds.
+const (
+	// Label is the address of a location in a section. Constants whose value
+	// is an address relative to a label, such as end equ msg + 13, are labels
+	// as well.
+	Label Kind = iota + 1
+	// Const is a constant defined by an equate directive.
+	Const
+	// Extern is a symbol defined by another object, as declared by an extern
+	// directive.
+	Extern
+)
+
+// A Symbol is a label, a constant or an external symbol.
+type Symbol struct {
+	// Name of the symbol.
+	Name string
+	// Kind of the symbol.
+	Kind Kind
+	// Section is the name of the section of labels; or the empty string.
+	Section string
+	// Value is the address of labels and the value of constants. External
+	// symbols have a provisional address.
+	Value int64
+	// Global specifies that the symbol is exported by a global directive.
+	Global bool
+}
+
+// A Reloc is a relocation record; a field of the machine code of a section
+// which refers to the address of a section or of an external symbol. The field
+// holds the referenced address plus the addend, minus the address of the field
+// itself if PC-relative; encoded as specified by the relocation type.
+type Reloc struct {
+	// Offset is the offset in bytes of the field from the start of the
+	// section. Bit fields of instructions are located by the offset of the
+	// instruction.
+	Offset int64
+	// Type specifies the encoding of the field.
+	Type RelocType
+	// Size is the size in bytes of the field, or of the instruction holding
+	// the bit field; either 1, 2, 4 or 8.
+	Size int
+	// Section is the name of the referenced section; or the empty string if
+	// the field refers to an external symbol.
+	Section string
+	// Symbol is the name of the referenced external symbol; or the empty
+	// string if the field refers to a section.
+	Symbol string
+	// Addend is the constant added to the referenced address.
+	Addend int64
+	// PCRel specifies that the field is relative to its own address; or to
+	// the address of the instruction holding the bit field.
+	PCRel bool
+}
+
+// A RelocType specifies the encoding of the field of a relocation record;
+// either a field of bytes, or a bit field of an instruction encoded like its
+// immediate operand.
+type RelocType uint8
+
+// Relocation types.
+const (
+	// Field is a field of Size bytes holding the address, in the byte order of
+	// the architecture.
+	Field RelocType = iota
+	// ARM64Branch26 is the 26-bit word offset of AArch64 b and bl
+	// instructions.
+	ARM64Branch26
+	// ARM64Page21 is the 21-bit page offset of AArch64 adrp instructions; the
+	// difference between the 4 KB pages of the address and of the instruction.
+	ARM64Page21
+	// ARM64PageOff12 is the offset of the address within its 4 KB page, as the
+	// 12-bit immediate of AArch64 add instructions.
+	ARM64PageOff12
+	// ThumbCall is the 24-bit halfword offset of Thumb bl instructions.
+	ThumbCall
+	// ThumbJump24 is the 24-bit halfword offset of 32-bit Thumb b
+	// instructions.
+	ThumbJump24
+	// MIPS26 is the 26-bit word address, within the 256 MB region of the
+	// instruction, of MIPS j and jal instructions.
+	MIPS26
+	// MIPSHi16 is the upper 16 bits of the address of MIPS lui instructions,
+	// adjusted for the sign extension of the lower 16 bits.
+	MIPSHi16
+	// MIPSLo16 is the lower 16 bits of the address, as the sign-extended
+	// immediate of MIPS I-format instructions.
+	MIPSLo16
+	// RISCVCall is the offset of a RISC-V auipc and jalr pair; as of the call
+	// and tail pseudo-instructions.
+	RISCVCall
+	// RISCVPCRelHi20 is the upper 20 bits of the offset of RISC-V auipc
+	// instructions, adjusted for the sign extension of the lower 12 bits.
+	RISCVPCRelHi20
+	// RISCVPCRelLo12I is the lower 12 bits of the offset of the auipc
+	// instruction immediately preceding a RISC-V I-format instruction, as its
+	// immediate. The field refers to the same address as the RISCVPCRelHi20
+	// field of the auipc instruction, and is relative to the address of the
+	// auipc instruction.
+	RISCVPCRelLo12I
+)
+
+// relocTypeNames maps from relocation type to its name.
+var relocTypeNames = [...]string{
+	Field:           "field",
+	ARM64Branch26:   "ARM64 branch26",
+	ARM64Page21:     "ARM64 page21",
+	ARM64PageOff12:  "ARM64 pageoff12",
+	ThumbCall:       "Thumb call",
+	ThumbJump24:     "Thumb jump24",
+	MIPS26:          "MIPS 26",
+	MIPSHi16:        "MIPS hi16",
+	MIPSLo16:        "MIPS lo16",
+	RISCVCall:       "RISC-V call",
+	RISCVPCRelHi20:  "RISC-V pcrel_hi20",
+	RISCVPCRelLo12I: "RISC-V pcrel_lo12_i",
+}
+
+func (typ RelocType) String() string {
+	if int(typ) < len(relocTypeNames) {
+		return relocTypeNames[typ]
+	}
+	return fmt.Sprintf("RelocType(%d)", uint8(typ))
+}
+
+// A Ref is a reference to an address assigned at link time; the address of a
+// section or an external symbol, plus a constant addend.
+type Ref struct {
+	// Name of the referenced label or external symbol.
+	Name string
+	// Section is the name of the section of the referenced label; or the
+	// empty string if the reference is to an external symbol.
+	Section string
+	// Symbol is the name of the referenced external symbol; or the empty
+	// string if the reference is to a section.
+	Symbol string
+	// Addend is the constant added to the address of the section or external
+	// symbol.
+	Addend int64
+}
+
+// A Layout specifies the placement of sections in memory.
+type Layout struct {
+	// Origin is the start address of the first section, as specified by an
+	// org directive.
+	Origin int64
+	// Align is the alignment in bytes of the start address of sections, which
+	// must be a power of two; or 0 if not aligned.
+	Align int64
+	// Relocatable specifies that the addresses of sections are assigned at
+	// link time. The origin and alignment are ignored.
+	Relocatable bool
+}
+
+// Provisional addresses of relocatable objects.
+const (
+	// relocBase is the distance between the provisional start addresses of the
+	// sections of relocatable objects, and the start address of the first
+	// section; which limits the size of sections.
+	relocBase = 1 << 24
+	// externAddr is the provisional address of external symbols.
+	externAddr = 0x70000000
+	// maxRelocSections is the maximum number of sections of relocatable
+	// objects.
+	maxRelocSections = externAddr/relocBase - 1
+)
+
+// delta is the displacement of addresses used to determine how the value of an
+// expression depends on them.
+const delta = 0x01020304
+
+// A Table is the symbol table of a program being laid out. It is an
+// ast.SymbolTable which resolves identifiers to the symbols of the current
+// pass, and to those of the previous pass for forward references.
+type Table struct {
+	// Layout of sections.
+	layout Layout
+	// Symbols defined by the current pass, and their names in order of
+	// definition.
+	syms  map[string]*Symbol
+	names []string
+	// Symbols defined by the previous pass.
+	prev map[string]*Symbol
+	// Names of the global symbols.
+	globals map[string]bool
+	// Sections of the current pass, in order of first appearance.
+	sects []string
+	// Section start addresses computed by the previous pass; or the
+	// provisional start addresses of the sections of relocatable objects,
+	// assigned in order of first appearance.
+	addrs map[string]int64
+	// Final pass.
+	final bool
+	// Address of the current line.
+	pc int64
+}
+
+// New returns a new symbol table, which lays out sections as specified by
+// layout.
+func New(layout Layout) *Table {
+	return &Table{
+		layout: layout,
+		syms:   make(map[string]*Symbol),
+		prev:   make(map[string]*Symbol),
+		addrs:  make(map[string]int64),
+	}
+}
+
+// Layout returns the layout of sections of the symbol table.
+func (t *Table) Layout() Layout {
+	return t.layout
+}
+
+// Begin begins a pass over the program. In the final pass, symbols are resolved
+// strictly. Earlier passes provisionally resolve undefined symbols to the
+// address of the current line, to lay out the program.
+func (t *Table) Begin(final bool) {
+	t.syms = make(map[string]*Symbol)
+	t.names = nil
+	t.globals = make(map[string]bool)
+	t.sects = nil
+	t.final = final
+	t.pc = 0
+}
+
+// End ends the pass over the program and computes the start addresses of the
+// sections for the next pass, given the size in bytes of each section. It
+// returns true if the layout is stable; i.e. if neither the symbols nor the
+// section addresses changed since the previous pass.
+func (t *Table) End(sizes map[string]int64) (stable bool, err error) {
+	stable = len(t.syms) == len(t.prev)
+	for name, sym := range t.syms {
+		if old, ok := t.prev[name]; !ok || *old != *sym {
+			stable = false
+		}
+	}
+	t.prev = t.syms
+
+	// Place the sections one after another, in order of first appearance.
+	if t.layout.Relocatable && len(t.addrs) > maxRelocSections {
+		return false, fmt.Errorf("symtab.Table.End: number of sections of relocatable object exceeds %d", maxRelocSections)
+	}
+	addr := t.layout.Origin
+	for i, name := range t.sects {
+		if t.layout.Relocatable {
+			if sizes[name] > relocBase {
+				return false, fmt.Errorf("symtab.Table.End: size %d of section %q exceeds %d bytes", sizes[name], name, relocBase)
+			}
+			continue
+		}
+		if i > 0 && t.layout.Align > 0 {
+			addr = AlignUp(addr, t.layout.Align)
+		}
+		if old, ok := t.addrs[name]; !ok || old != addr {
+			stable = false
+		}
+		t.addrs[name] = addr
+		addr += sizes[name]
+	}
+	return stable, nil
+}
+
+// Section declares the named section in the current pass and returns its start
+// address.
+func (t *Table) Section(name string) (addr int64) {
+	for _, sect := range t.sects {
+		if sect == name {
+			return t.start(name)
+		}
+	}
+	t.sects = append(t.sects, name)
+	if _, ok := t.addrs[name]; !ok && t.layout.Relocatable {
+		t.addrs[name] = int64(len(t.addrs)+1) * relocBase
+	}
+	return t.start(name)
+}
+
+// start returns the start address of the named section.
+func (t *Table) start(name string) int64 {
+	if addr, ok := t.addrs[name]; ok {
+		return addr
+	}
+	return t.layout.Origin
+}
+
+// SetPC sets the address of the current line.
+func (t *Table) SetPC(pc int64) {
+	t.pc = pc
+}
+
+// Symbol returns the named symbol defined by the current pass; or nil if not
+// present.
+func (t *Table) Symbol(name string) *Symbol {
+	return t.syms[name]
+}
+
+// Symbols returns the symbols defined by the current pass, in order of
+// definition.
+func (t *Table) Symbols() []*Symbol {
+	var syms []*Symbol
+	for _, name := range t.names {
+		syms = append(syms, t.syms[name])
+	}
+	return syms
+}
+
+// symbol returns the named symbol defined by the current pass, or else by the
+// previous pass; or nil if not present.
+func (t *Table) symbol(name string) *Symbol {
+	if sym, ok := t.syms[name]; ok {
+		return sym
+	}
+	return t.prev[name]
+}
+
+// define defines the given symbol in the current pass.
+func (t *Table) define(sym *Symbol) {
+	sym.Global = t.globals[sym.Name]
+	t.syms[sym.Name] = sym
+	t.names = append(t.names, sym.Name)
+}
+
+// Define defines the named label, located at the given address of a section.
+func (t *Table) Define(name, sect string, addr int64) {
+	t.define(&Symbol{Name: name, Kind: Label, Section: sect, Value: addr})
+}
+
+// Extern declares the named external symbol.
+func (t *Table) Extern(name string) {
+	t.define(&Symbol{Name: name, Kind: Extern, Value: externAddr})
+}
+
+// Equ defines the named constant, whose value is given by the constant
+// expression x. Constants whose value is an address relative to a label are
+// labels of the same section.
+func (t *Table) Equ(name string, x ast.Arg) error {
+	val, err := ast.Eval(x, t)
+	if err != nil {
+		return err
+	}
+	sym := &Symbol{Name: name, Kind: Const, Value: val}
+	for _, g := range t.groups(x) {
+		v, err := ast.Eval(x, &displaced{t: t, g: g})
+		if err != nil {
+			return err
+		}
+		switch {
+		case v == val:
+			// Independent of the address of the group; e.g. end - start.
+		case v-val == delta && g.extern == "" && sym.Kind == Const:
+			sym.Kind, sym.Section = Label, g.section
+		default:
+			return fmt.Errorf("symtab.Table.Equ: value of %q is not a constant or an address relative to a label", name)
+		}
+	}
+	t.define(sym)
+	return nil
+}
+
+// Global marks the named symbol as exported.
+func (t *Table) Global(name string) {
+	t.globals[name] = true
+	if sym, ok := t.syms[name]; ok {
+		sym.Global = true
+	}
+}
+
+// Lookup returns the value of the named symbol and true if the symbol is
+// defined, and false otherwise. Symbols defined earlier in the pass take
+// precedence over symbols defined by the previous pass. Before the final pass,
+// undefined symbols provisionally resolve to the address of the current line.
+func (t *Table) Lookup(name string) (val int64, ok bool) {
+	if sym, ok := t.syms[name]; ok {
+		return sym.Value, true
+	}
+	if sym, ok := t.prev[name]; ok {
+		return sym.Value, true
+	}
+	if !t.final {
+		return t.pc, true
+	}
+	return 0, false
+}
+
+// A group is a set of symbols whose addresses are assigned at link time; either
+// the labels of a section or an external symbol.
+type group struct {
+	// Name of the section; or the empty string.
+	section string
+	// Name of the external symbol; or the empty string.
+	extern string
+}
+
+// groups returns the groups of the symbols referred to by the given operands,
+// in order of first reference. The labels of sections only form groups of
+// relocatable objects.
+func (t *Table) groups(args ...ast.Arg) []group {
+	var gs []group
+	seen := make(map[group]bool)
+	for _, name := range refs(nil, args...) {
+		sym := t.symbol(name)
+		if sym == nil {
+			continue
+		}
+		var g group
+		switch {
+		case sym.Kind == Extern:
+			g.extern = sym.Name
+		case sym.Kind == Label && t.layout.Relocatable:
+			g.section = sym.Section
+		default:
+			continue
+		}
+		if !seen[g] {
+			seen[g] = true
+			gs = append(gs, g)
+		}
+	}
+	return gs
+}
+
+// refs appends the names of the identifiers of the given operands to names.
+func refs(names []string, args ...ast.Arg) []string {
+	for _, arg := range args {
+		switch arg := arg.(type) {
+		case ast.Ident:
+			names = append(names, string(arg))
+		case *ast.ParenExpr:
+			names = refs(names, arg.X)
+		case *ast.UnaryExpr:
+			names = refs(names, arg.X)
+		case *ast.BinaryExpr:
+			names = refs(names, arg.X, arg.Y)
+		case *ast.Imm:
+			names = refs(names, arg.X)
+		case *ast.Literal:
+			names = refs(names, arg.X)
+		case *ast.Mem:
+			names = refs(names, arg.Disp)
+		case *ast.ShiftedReg:
+			names = refs(names, arg.X, arg.Amount)
+		case *ast.Writeback:
+			names = refs(names, arg.X)
+		}
+	}
+	return names
+}
+
+// displaced is a view of a symbol table, in which the addresses of a group of
+// symbols are displaced by delta.
+type displaced struct {
+	t *Table
+	// Displaced group.
+	g group
+}
+
+// Lookup returns the value of the named symbol and true if the symbol is
+// defined, and false otherwise.
+func (d *displaced) Lookup(name string) (val int64, ok bool) {
+	val, ok = d.t.Lookup(name)
+	if !ok {
+		return 0, false
+	}
+	sym := d.t.symbol(name)
+	if sym == nil {
+		return val, true
+	}
+	if (sym.Kind == Extern && d.g.extern == name) || (sym.Kind == Label && d.g.section != "" && d.g.section == sym.Section) {
+		val += delta
+	}
+	return val, true
+}
+
+// addr returns the address of the given group.
+func (t *Table) addr(g group) int64 {
+	if g.extern != "" {
+		return externAddr
+	}
+	return t.start(g.section)
+}
+
+// Ref returns the reference of the address x to a section or an external
+// symbol whose address is assigned at link time, and true if present. An error
+// is returned if x refers to such an address other than by adding a constant
+// to it; e.g. msg * 2 or printf - msg. The name of the offending symbol is then
+// given by ref.
+func (t *Table) Ref(x ast.Arg) (ref Ref, ok bool, err error) {
+	gs := t.groups(x)
+	if len(gs) == 0 {
+		return Ref{}, false, nil
+	}
+	val, err := ast.Eval(x, t)
+	if err != nil {
+		return Ref{}, false, err
+	}
+	for _, g := range gs {
+		v, err := ast.Eval(x, &displaced{t: t, g: g})
+		if err != nil {
+			return Ref{}, false, err
+		}
+		switch {
+		case v == val:
+			// Independent of the address of the group; e.g. end - start.
+		case v-val == delta && !ok:
+			ref = Ref{Name: t.name(x, g), Section: g.section, Symbol: g.extern, Addend: val - t.addr(g)}
+			ok = true
+		default:
+			name := t.name(x, g)
+			return Ref{Name: name}, false, fmt.Errorf("symtab.Table.Ref: unable to relocate reference to symbol %q", name)
+		}
+	}
+	return ref, ok, nil
+}
+
+// name returns the name of the first symbol of the given group referred to by
+// x.
+func (t *Table) name(x ast.Arg, g group) string {
+	for _, name := range refs(nil, x) {
+		if sym := t.symbol(name); sym != nil && (sym.Name == g.extern || (sym.Kind == Label && sym.Section == g.section)) {
+			return name
+		}
+	}
+	return ""
+}
+
+// AlignUp rounds addr up to the nearest multiple of n, which must be a power of
+// two; or zero.
+func AlignUp(addr, n int64) int64 {
+	if n <= 1 {
+		return addr
+	}
+	return (addr + n - 1) &^ (n - 1)
+}
@@ -0,0 +1,124 @@
+package symtab
+
+import (
+	"testing"
+
+	"github.com/mewlang/asm/ast"
+	"github.com/mewlang/asm/token"
+)
+
+func TestEqu(t *testing.T) {
+	golden := []struct {
+		relocatable bool
+		x           ast.Arg
+		want        Symbol
+	}{
+		// i=0
+		{
+			relocatable: false,
+			x:           &ast.BinaryExpr{X: ast.Ident("msg"), Op: token.Add, Y: ast.Int(3)},
+			want:        Symbol{Name: "x", Kind: Const, Value: 0x1003},
+		},
+		// i=1
+		{
+			relocatable: true,
+			x:           &ast.BinaryExpr{X: ast.Ident("msg"), Op: token.Add, Y: ast.Int(3)},
+			want:        Symbol{Name: "x", Kind: Label, Section: ".data", Value: 2*relocBase + 3},
+		},
+		// i=2
+		{
+			relocatable: true,
+			x:           &ast.BinaryExpr{X: ast.Ident("msg"), Op: token.Sub, Y: ast.Ident("data")},
+			want:        Symbol{Name: "x", Kind: Const, Value: 0},
+		},
+	}
+
+	for i, g := range golden {
+		layout := Layout{Origin: 0x1000, Relocatable: g.relocatable}
+		tab := New(layout)
+		tab.Begin(true)
+		tab.Section(".text")
+		tab.Define("data", ".data", tab.Section(".data"))
+		tab.Define("msg", ".data", tab.Section(".data"))
+		if err := tab.Equ("x", g.x); err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if got := *tab.Symbol("x"); got != g.want {
+			t.Errorf("i=%d: symbol mismatch; expected %+v, got %+v", i, g.want, got)
+		}
+	}
+}
+
+func TestRef(t *testing.T) {
+	golden := []struct {
+		relocatable bool
+		x           ast.Arg
+		want        Ref
+		ok          bool
+		err         string
+	}{
+		// i=0
+		{
+			relocatable: false,
+			x:           &ast.BinaryExpr{X: ast.Ident("printf"), Op: token.Add, Y: ast.Int(4)},
+			want:        Ref{Name: "printf", Symbol: "printf", Addend: 4},
+			ok:          true,
+		},
+		// i=1
+		{
+			relocatable: false,
+			x:           &ast.BinaryExpr{X: ast.Ident("msg"), Op: token.Add, Y: ast.Int(3)},
+			ok:          false,
+		},
+		// i=2
+		{
+			relocatable: true,
+			x:           &ast.BinaryExpr{X: ast.Ident("msg"), Op: token.Add, Y: ast.Int(3)},
+			want:        Ref{Name: "msg", Section: ".data", Addend: 7},
+			ok:          true,
+		},
+		// i=3
+		{
+			relocatable: true,
+			x:           &ast.BinaryExpr{X: ast.Ident("msg"), Op: token.Sub, Y: ast.Ident("data")},
+			ok:          false,
+		},
+		// i=4
+		{
+			relocatable: true,
+			x:           &ast.BinaryExpr{X: ast.Ident("msg"), Op: token.Mul, Y: ast.Int(2)},
+			err:         `symtab.Table.Ref: unable to relocate reference to symbol "msg"`,
+		},
+		// i=5
+		{
+			relocatable: true,
+			x:           &ast.BinaryExpr{X: ast.Ident("printf"), Op: token.Sub, Y: ast.Ident("msg")},
+			err:         `symtab.Table.Ref: unable to relocate reference to symbol "msg"`,
+		},
+	}
+
+	for i, g := range golden {
+		layout := Layout{Origin: 0x1000, Relocatable: g.relocatable}
+		tab := New(layout)
+		tab.Begin(true)
+		tab.Section(".text")
+		tab.Extern("printf")
+		tab.Define("data", ".data", tab.Section(".data"))
+		tab.Define("msg", ".data", tab.Section(".data")+4)
+		ref, ok, err := tab.Ref(g.x)
+		if g.err != "" {
+			if err == nil || err.Error() != g.err {
+				t.Errorf("i=%d: error mismatch; expected %q, got %v", i, g.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if ok != g.ok || ref != g.want {
+			t.Errorf("i=%d: reference mismatch; expected %+v (%v), got %+v (%v)", i, g.want, g.ok, ref, ok)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+; === [ text section ] =========================================================
+
+section ".text"
+global _start
+
+_start:
+	; write(1, "Hello world!\n", 13)
+	mov	eax, 1		; sys_write
+	mov	edi, 1		;    fd: stdout
+	lea	rsi, [rel hello]	;    buf: str
+	mov	edx, 13		;    len: len(str)
+	syscall
+
+	; exit(0)
+	mov	eax, 60		; sys_exit
+	xor	edi, edi	;    status = 0
+	syscall
+
+; === [ rdata section ] ========================================================
+
+section ".rdata"
+
+hello:	db "Hello world", '!', 10
@@ -0,0 +1,237 @@
+// The design of this file is heavily inspired by the go/token package of the Go
+// standard library, which is governed by a BSD-style license [1].
+//
+// [1]: http://golang.org/LICENSE
+
+package token
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Pos is a compact encoding of a source position within a file set. It can be
+// converted into a Position for a more convenient, but much larger,
+// representation.
+//
+// The Pos value for a given file is a number in the range [base, base+size],
+// where base and size are specified when adding the file to the file set. The
+// difference between a Pos value and the corresponding file base corresponds to
+// the byte offset of that position (represented by the Pos value) from the
+// beginning of the file.
+type Pos int
+
+// NoPos is the zero value for Pos; there is no file and line information
+// associated with it.
+const NoPos Pos = 0
+
+// IsValid returns true if the position is valid, and false otherwise.
+func (p Pos) IsValid() bool {
+	return p != NoPos
+}
+
+// Position describes an arbitrary source position including the file, line,
+// and column location. A Position is valid if the line number is > 0.
+type Position struct {
+	// Filename, if any.
+	Filename string
+	// Byte offset, starting at 0.
+	Offset int
+	// Line number, starting at 1.
+	Line int
+	// Column number, starting at 1 (byte count).
+	Column int
+}
+
+// IsValid returns true if the position is valid, and false otherwise.
+func (pos Position) IsValid() bool {
+	return pos.Line > 0
+}
+
+// String returns a string in one of several forms:
+//
+//	file:line:column    valid position with file name
+//	line:column         valid position without file name
+//	file                invalid position with file name
+//	-                   invalid position without file name
+func (pos Position) String() string {
+	s := pos.Filename
+	if pos.IsValid() {
+		if len(s) > 0 {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+	}
+	if len(s) == 0 {
+		s = "-"
+	}
+	return s
+}
+
+// A File is a handle for a file belonging to a FileSet. A File has a name, size
+// and line offset table.
+type File struct {
+	// File set of the file.
+	set *FileSet
+	// File name as provided to AddFile.
+	name string
+	// Pos value range for this file is [base, base+size].
+	base int
+	// File size as provided to AddFile, or as grown by Grow.
+	size int
+	// Lines contains the offset of the first character for each line (the first
+	// entry is always 0).
+	lines []int
+}
+
+// Name returns the file name of file f as registered with AddFile.
+func (f *File) Name() string {
+	return f.name
+}
+
+// Base returns the base offset of file f as registered with AddFile.
+func (f *File) Base() int {
+	return f.base
+}
+
+// Size returns the size of file f as registered with AddFile, or as grown by
+// Grow.
+func (f *File) Size() int {
+	return f.size
+}
+
+// Grow grows the size of file f to the given size, so that the positions of
+// input read incrementally may be recorded as it is read. Only the last file
+// added to a file set may grow. Grow returns true if the size of f is at least
+// the given size, and false otherwise.
+func (f *File) Grow(size int) bool {
+	if size <= f.size {
+		return true
+	}
+	if f.set == nil || f.set.files[len(f.set.files)-1] != f {
+		return false
+	}
+	f.size = size
+	// +1 so that the position directly after the end of the file does not
+	// belong to the next file.
+	f.set.base = f.base + size + 1
+	return true
+}
+
+// LineCount returns the number of lines in file f.
+func (f *File) LineCount() int {
+	return len(f.lines)
+}
+
+// AddLine adds the line offset for a new line. The line offset must be larger
+// than the offset for the previous line and smaller than the file size;
+// otherwise the line offset is ignored.
+func (f *File) AddLine(offset int) {
+	if offset > f.lines[len(f.lines)-1] && offset < f.size {
+		f.lines = append(f.lines, offset)
+	}
+}
+
+// Pos returns the Pos value for the given file offset. The offset must be in
+// the range [0, f.Size()].
+func (f *File) Pos(offset int) Pos {
+	if offset < 0 || offset > f.size {
+		panic(fmt.Sprintf("token.File.Pos: illegal file offset %d; not in range [0, %d]", offset, f.size))
+	}
+	return Pos(f.base + offset)
+}
+
+// Offset returns the offset for the given file position p. The position must
+// be in the range [f.Base(), f.Base()+f.Size()].
+func (f *File) Offset(p Pos) int {
+	if int(p) < f.base || int(p) > f.base+f.size {
+		panic(fmt.Sprintf("token.File.Offset: illegal Pos value %d; not in range [%d, %d]", p, f.base, f.base+f.size))
+	}
+	return int(p) - f.base
+}
+
+// Line returns the line number for the given file position p.
+func (f *File) Line(p Pos) int {
+	return f.Position(p).Line
+}
+
+// Position returns the Position value for the given file position p.
+func (f *File) Position(p Pos) (pos Position) {
+	if !p.IsValid() {
+		return pos
+	}
+	offset := f.Offset(p)
+	i := sort.Search(len(f.lines), func(i int) bool { return f.lines[i] > offset }) - 1
+	pos.Filename = f.name
+	pos.Offset = offset
+	pos.Line = i + 1
+	pos.Column = offset - f.lines[i] + 1
+	return pos
+}
+
+// A FileSet represents a set of source files.
+type FileSet struct {
+	// Base offset for the next file.
+	base int
+	// List of files in the order added to the set.
+	files []*File
+}
+
+// NewFileSet creates a new file set.
+func NewFileSet() *FileSet {
+	return &FileSet{
+		base: 1, // 0 == NoPos
+	}
+}
+
+// Base returns the minimum base offset that must be provided to AddFile when
+// adding the next file.
+func (s *FileSet) Base() int {
+	return s.base
+}
+
+// AddFile adds a new file with a given filename, base offset, and file size to
+// the file set s and returns the file. If base is negative, the current value
+// of s.Base() is used instead.
+func (s *FileSet) AddFile(filename string, base, size int) *File {
+	if base < 0 {
+		base = s.base
+	}
+	if base < s.base || size < 0 {
+		panic(fmt.Sprintf("token.FileSet.AddFile: illegal base %d or size %d", base, size))
+	}
+	f := &File{
+		set:   s,
+		name:  filename,
+		base:  base,
+		size:  size,
+		lines: []int{0},
+	}
+	// +1 so that the position directly after the end of the file does not
+	// belong to the next file.
+	s.base = base + size + 1
+	s.files = append(s.files, f)
+	return f
+}
+
+// File returns the file that contains the position p. If no such file is
+// found, it returns nil.
+func (s *FileSet) File(p Pos) *File {
+	for _, f := range s.files {
+		if f.base <= int(p) && int(p) <= f.base+f.size {
+			return f
+		}
+	}
+	return nil
+}
+
+// Position converts a Pos p in the file set into a Position value.
+func (s *FileSet) Position(p Pos) (pos Position) {
+	if !p.IsValid() {
+		return pos
+	}
+	if f := s.File(p); f != nil {
+		return f.Position(p)
+	}
+	return pos
+}
@@ -10,6 +10,8 @@ type Token struct {
 	Typ Type
 	// The string value of the token.
 	Val string
+	// The position of the first character of the token.
+	Pos Pos
 }
 
 func (tok Token) String() string {
@@ -29,11 +31,32 @@ const (
 	Ident                   // identifier.
 	Colon                   // label declaration colon.
 	Int                     // integer literal
+	Float                   // floating-point literal
 	Char                    // character literal
 	String                  // string literal
 	Comma                   // operand separation comma
 	LineComment             // ; line comment
 	Newline                 // newline
+	Add                     // +
+	Sub                     // -
+	Mul                     // *
+	Div                     // /
+	Mod                     // %
+	And                     // &
+	Or                      // |
+	Xor                     // ^
+	Shl                     // <<
+	Shr                     // >>
+	Not                     // ~
+	LParen                  // (
+	RParen                  // )
+	LBrack                  // [
+	RBrack                  // ]
+	LBrace                  // {
+	RBrace                  // }
+	Hash                    // #
+	Excl                    // !
+	Eq                      // =
 )
 
 // names is a map from token type to token name.
@@ -43,11 +66,32 @@ var names = map[Type]string{
 	Ident:       "identifier",
 	Colon:       ":",
 	Int:         "integer literal",
+	Float:       "floating-point literal",
 	Char:        "character literal",
 	String:      "string literal",
 	Comma:       ",",
 	LineComment: "line comment",
 	Newline:     "newline",
+	Add:         "+",
+	Sub:         "-",
+	Mul:         "*",
+	Div:         "/",
+	Mod:         "%",
+	And:         "&",
+	Or:          "|",
+	Xor:         "^",
+	Shl:         "<<",
+	Shr:         ">>",
+	Not:         "~",
+	LParen:      "(",
+	RParen:      ")",
+	LBrack:      "[",
+	RBrack:      "]",
+	LBrace:      "{",
+	RBrace:      "}",
+	Hash:        "#",
+	Excl:        "!",
+	Eq:          "=",
 }
 
 func (typ Type) String() string {
@@ -57,3 +101,23 @@ func (typ Type) String() string {
 	}
 	return name
 }
+
+// Precedence returns the operator precedence of the binary operator typ. If
+// typ is not a binary operator, the result is 0.
+func (typ Type) Precedence() int {
+	switch typ {
+	case Or:
+		return 1
+	case Xor:
+		return 2
+	case And:
+		return 3
+	case Shl, Shr:
+		return 4
+	case Add, Sub:
+		return 5
+	case Mul, Div, Mod:
+		return 6
+	}
+	return 0
+}